@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidAnnotation indicates that an annotation key is empty or
+// is reserved, i.e., it is one of the keys set by the engine itself.
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+
+// reservedAnnotations contains the annotation keys set by the engine
+// or by the experiments, which the user cannot override.
+var reservedAnnotations = map[string]bool{
+	"assets_version":  true,
+	"backend_channel": true,
+	"captive_portal":  true,
+	"engine_name":     true,
+	"engine_version":  true,
+	"ip_family":       true,
+	"nat_type":        true,
+	"platform":        true,
+}
+
+// ValidateAnnotations returns an error wrapping ErrInvalidAnnotation
+// if any key in annotations is empty or reserved.
+func ValidateAnnotations(annotations map[string]string) error {
+	for key := range annotations {
+		if key == "" || reservedAnnotations[key] {
+			return fmt.Errorf("%w: %q", ErrInvalidAnnotation, key)
+		}
+	}
+	return nil
+}
+
+// mergeAnnotations returns a new map containing the annotations in
+// each input map. Later maps override earlier ones.
+func mergeAnnotations(inputs ...map[string]string) map[string]string {
+	out := make(map[string]string)
+	for _, input := range inputs {
+		for key, value := range input {
+			out[key] = value
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestValidateAnnotations(t *testing.T) {
+	var tests = []struct {
+		name        string
+		annotations map[string]string
+		err         error
+	}{{
+		name: "with nil annotations",
+	}, {
+		name:        "with valid annotations",
+		annotations: map[string]string{"run_id": "xx", "device_model": "yy"},
+	}, {
+		name:        "with an empty key",
+		annotations: map[string]string{"": "xx"},
+		err:         ErrInvalidAnnotation,
+	}, {
+		name:        "with a reserved key",
+		annotations: map[string]string{"campaign": "xx", "engine_name": "yy"},
+		err:         ErrInvalidAnnotation,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateAnnotations(tt.annotations); !errors.Is(err, tt.err) {
+				t.Fatal("not the error we expected")
+			}
+		})
+	}
+}
+
+func TestExperimentAnnotations(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.annotations = map[string]string{"campaign": "xx", "run_id": "yy"}
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{}, "example",
+	))
+	err := exp.AddAnnotations(map[string]string{"platform": "antani"})
+	if !errors.Is(err, ErrInvalidAnnotation) {
+		t.Fatal("not the error we expected")
+	}
+	if err := exp.AddAnnotations(map[string]string{"run_id": "zz"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.AddAnnotations(map[string]string{"device_model": "ww"}); err != nil {
+		t.Fatal(err)
+	}
+	m := exp.newMeasurement("")
+	for key, value := range map[string]string{
+		"campaign":     "xx",
+		"device_model": "ww",
+		"engine_name":  "ooniprobe-engine",
+		"run_id":       "zz",
+	} {
+		if diff := cmp.Diff(value, m.Annotations[key]); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+	if _, found := m.Annotations["ip_family"]; found {
+		t.Fatal("did not expect ip_family without knowing the probe IP")
+	}
+}
+
+func TestExperimentIPFamilyAnnotation(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.location = &model.LocationInfo{ProbeIP: "2001:db8::1"}
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{}, "example",
+	))
+	m := exp.newMeasurement("")
+	if m.Annotations["ip_family"] != "ipv6" {
+		t.Fatal("unexpected ip_family annotation")
+	}
+}
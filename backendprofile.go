@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/gocertifi"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+// ErrInvalidBackendProfile indicates that a BackendProfile is not valid.
+var ErrInvalidBackendProfile = errors.New("invalid backend profile")
+
+// BackendProfile points the whole engine at an OONI compatible backend,
+// e.g., the one run by an organization or a test backend. ProbeServicesURL
+// is the base URL of the probe services, which we use without trying any
+// circumvention channel. CollectorURL and OrchestraURL, when not empty,
+// override the base URL we use to submit measurements and to talk to the
+// orchestra, respectively. TestHelpers, when not nil, replaces the test
+// helpers advertised by the probe services. RootCAs is a PEM bundle with
+// additional CAs that we trust when talking with the backend, which is
+// useful when the backend uses a private CA.
+type BackendProfile struct {
+	CollectorURL     string
+	OrchestraURL     string
+	ProbeServicesURL string
+	RootCAs          []byte
+	TestHelpers      map[string][]model.Service
+}
+
+// Validate returns an error wrapping ErrInvalidBackendProfile if the
+// profile contains invalid URLs or a RootCAs without certificates.
+func (p *BackendProfile) Validate() error {
+	if p.ProbeServicesURL == "" {
+		return fmt.Errorf("%w: empty ProbeServicesURL", ErrInvalidBackendProfile)
+	}
+	for _, URL := range []string{p.ProbeServicesURL, p.CollectorURL, p.OrchestraURL} {
+		if URL == "" {
+			continue
+		}
+		parsed, err := url.Parse(URL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("%w: invalid URL: %q", ErrInvalidBackendProfile, URL)
+		}
+	}
+	if _, err := p.certPool(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// certPool returns the pool of the CAs we trust when talking with the
+// backend, or nil when we should use the default pool.
+func (p *BackendProfile) certPool() (*x509.CertPool, error) {
+	if len(p.RootCAs) <= 0 {
+		return nil, nil
+	}
+	pool, err := gocertifi.CACerts()
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(p.RootCAs) {
+		return nil, fmt.Errorf("%w: no certificates in RootCAs", ErrInvalidBackendProfile)
+	}
+	return pool, nil
+}
+
+// probeServices returns the probe services of the profile.
+func (p *BackendProfile) probeServices() []model.Service {
+	return []model.Service{{Address: p.ProbeServicesURL, Type: "https"}}
+}
+
+// collectorURL returns the base URL override for the collector, if any.
+func (s *Session) collectorURL() string {
+	if s.backendProfile == nil {
+		return ""
+	}
+	return s.backendProfile.CollectorURL
+}
+
+// orchestraURL returns the base URL override for the orchestra, if any.
+func (s *Session) orchestraURL() string {
+	if s.backendProfile == nil {
+		return ""
+	}
+	return s.backendProfile.OrchestraURL
+}
+
+// withBaseURL sets the base URL of clnt to URL, unless URL is empty.
+func withBaseURL(clnt *probeservices.Client, URL string) *probeservices.Client {
+	if URL != "" {
+		clnt.BaseURL = URL
+	}
+	return clnt
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestBackendProfileValidate(t *testing.T) {
+	var cases = []struct {
+		name    string
+		profile BackendProfile
+		valid   bool
+	}{{
+		name:    "with only the probe services",
+		profile: BackendProfile{ProbeServicesURL: "https://ps.example.org"},
+		valid:   true,
+	}, {
+		name: "with all the URLs",
+		profile: BackendProfile{
+			CollectorURL:     "http://127.0.0.1:8080",
+			OrchestraURL:     "https://orchestra.example.org/",
+			ProbeServicesURL: "https://ps.example.org",
+		},
+		valid: true,
+	}, {
+		name:    "with empty probe services",
+		profile: BackendProfile{CollectorURL: "https://collector.example.org"},
+	}, {
+		name:    "with invalid scheme",
+		profile: BackendProfile{ProbeServicesURL: "ftp://ps.example.org"},
+	}, {
+		name: "with invalid collector",
+		profile: BackendProfile{
+			CollectorURL:     "\t",
+			ProbeServicesURL: "https://ps.example.org",
+		},
+	}, {
+		name: "with no certificates in RootCAs",
+		profile: BackendProfile{
+			ProbeServicesURL: "https://ps.example.org",
+			RootCAs:          []byte("antani"),
+		},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.profile.Validate()
+			if c.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !c.valid && !errors.Is(err, ErrInvalidBackendProfile) {
+				t.Fatal("not the error we expected", err)
+			}
+		})
+	}
+}
+
+func TestNewSessionWithInvalidBackendProfile(t *testing.T) {
+	t.Run("with invalid profile", func(t *testing.T) {
+		newSessionMustFail(t, SessionConfig{
+			AssetsDir:       "testdata",
+			BackendProfile:  &BackendProfile{},
+			Logger:          log.Log,
+			SoftwareName:    "ooniprobe-engine",
+			SoftwareVersion: "0.0.1",
+		})
+	})
+	t.Run("with AvailableProbeServices", func(t *testing.T) {
+		newSessionMustFail(t, SessionConfig{
+			AssetsDir: "testdata",
+			AvailableProbeServices: []model.Service{{
+				Address: "https://ams-pg.ooni.org",
+				Type:    "https",
+			}},
+			BackendProfile:  &BackendProfile{ProbeServicesURL: "https://ps.example.org"},
+			Logger:          log.Log,
+			SoftwareName:    "ooniprobe-engine",
+			SoftwareVersion: "0.0.1",
+		})
+	})
+}
+
+func TestSessionWithBackendProfile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/test-helpers" {
+				w.WriteHeader(404)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string][]model.Service{
+				"web-connectivity": {{Address: "https://wcth.ooni.io", Type: "https"}},
+			})
+		}))
+	defer server.Close()
+	rootCAs := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+	helpers := map[string][]model.Service{
+		"web-connectivity": {{Address: "https://th.example.org", Type: "https"}},
+	}
+	sess, err := NewSession(SessionConfig{
+		AssetsDir: "testdata",
+		BackendProfile: &BackendProfile{
+			CollectorURL:     "https://collector.example.org",
+			OrchestraURL:     "https://orchestra.example.org",
+			ProbeServicesURL: server.URL,
+			RootCAs:          rootCAs,
+			TestHelpers:      helpers,
+		},
+		Logger:          log.Log,
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	if err := sess.MaybeLookupBackends(); err != nil {
+		t.Fatal(err)
+	}
+	if sess.selectedProbeService.Address != server.URL {
+		t.Fatal("not the probe services we expected")
+	}
+	if sess.BackendChannel() != "direct" {
+		t.Fatal("not the channel we expected")
+	}
+	if th, _ := sess.GetTestHelpersByName("web-connectivity"); th[0].Address != "https://th.example.org" {
+		t.Fatal("not the test helpers we expected")
+	}
+	clnt, err := sess.newSelectedProbeServicesClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withBaseURL(clnt, sess.collectorURL()).BaseURL != "https://collector.example.org" {
+		t.Fatal("not the collector we expected")
+	}
+	if withBaseURL(clnt, "").BaseURL != "https://collector.example.org" {
+		t.Fatal("an empty URL should not override the base URL")
+	}
+	if sess.orchestraURL() != "https://orchestra.example.org" {
+		t.Fatal("not the orchestra we expected")
+	}
+}
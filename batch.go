@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/litemode"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+// DefaultBatchMaxExperiments is the default number of experiments
+// that RunBatch runs concurrently.
+const DefaultBatchMaxExperiments = 4
+
+// batchPollInterval is how often we check whether we are within budget.
+const batchPollInterval = 250 * time.Millisecond
+
+// BatchConfig contains the RunBatch config. MaxExperiments is the maximum
+// number of jobs running concurrently (if zero, DefaultBatchMaxExperiments,
+// or a single job when the session is in lite mode).
+// MaxSockets is the maximum number of connections open at any given time
+// by all the jobs. MaxKiBps and MaxMemoryMiB are the bandwidth used by the
+// session and the heap size above which we stop starting new measurements
+// until we're within budget again. A zero value means no limit.
+type BatchConfig struct {
+	MaxExperiments int
+	MaxKiBps       float64
+	MaxMemoryMiB   uint64
+	MaxSockets     int
+}
+
+// BatchJob is an experiment to run as part of RunBatch. We measure each
+// input in sequence, calling OnMeasurement, if not nil, after each of
+// them. For experiments not taking any input, use a single empty input. To
+// measure several batches of URLs concurrently, e.g., with Web Connectivity,
+// create a job with a distinct Experiment for each batch.
+type BatchJob struct {
+	Experiment    *Experiment
+	Inputs        []string
+	OnMeasurement func(measurement *model.Measurement, err error)
+}
+
+// RunBatch runs independent jobs concurrently while honouring the limits
+// set in config. It returns when all jobs are done or ctx is done, in
+// which case it returns the context error. The caller is responsible for
+// opening reports and submitting measurements, e.g., in OnMeasurement.
+func (s *Session) RunBatch(ctx context.Context, config BatchConfig, jobs []BatchJob) error {
+	// Lookup the location now, so that experiments do not race to do it.
+	if err := s.maybeLookupLocation(ctx); err != nil {
+		return err
+	}
+	if config.MaxExperiments <= 0 {
+		config.MaxExperiments = DefaultBatchMaxExperiments
+		if s.LiteMode() {
+			config.MaxExperiments = litemode.MaxExperiments
+		}
+	}
+	if config.MaxSockets > 0 {
+		ctx = dialer.WithConnLimiter(ctx, dialer.NewConnLimiter(config.MaxSockets))
+	}
+	budget := newBatchBudget(s, config)
+	ch := make(chan BatchJob)
+	var wg sync.WaitGroup
+	for idx := 0; idx < config.MaxExperiments; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				s.runBatchJob(ctx, budget, job)
+			}
+		}()
+	}
+loop:
+	for _, job := range jobs {
+		select {
+		case ch <- job:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (s *Session) runBatchJob(ctx context.Context, budget *batchBudget, job BatchJob) {
+	for _, input := range job.Inputs {
+		if err := budget.wait(ctx); err != nil {
+			return
+		}
+		measurement, err := job.Experiment.MeasureWithContext(ctx, input)
+		if job.OnMeasurement != nil {
+			job.OnMeasurement(measurement, err)
+		}
+	}
+}
+
+// batchBudget tracks the bandwidth and memory used during a batch.
+type batchBudget struct {
+	config  BatchConfig
+	kib     float64
+	last    time.Time
+	logger  model.Logger
+	mu      sync.Mutex
+	rate    float64
+	session *Session
+}
+
+func newBatchBudget(sess *Session, config BatchConfig) *batchBudget {
+	return &batchBudget{
+		config:  config,
+		kib:     sess.KibiBytesReceived() + sess.KibiBytesSent(),
+		last:    time.Now(),
+		logger:  sess.Logger(),
+		session: sess,
+	}
+}
+
+// kibps returns the bandwidth used by the session, in KiB/s, since the
+// previous sample, waiting at least batchPollInterval between samples.
+func (b *batchBudget) kibps() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed < batchPollInterval.Seconds() {
+		return b.rate
+	}
+	kib := b.session.KibiBytesReceived() + b.session.KibiBytesSent()
+	b.rate = (kib - b.kib) / elapsed
+	b.kib, b.last = kib, now
+	return b.rate
+}
+
+func (b *batchBudget) heapMiB() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc / (1 << 20)
+}
+
+func (b *batchBudget) within() bool {
+	if b.config.MaxKiBps > 0 && b.kibps() > b.config.MaxKiBps {
+		b.logger.Debug("batch: above the bandwidth budget; waiting")
+		return false
+	}
+	if b.config.MaxMemoryMiB > 0 && b.heapMiB() > b.config.MaxMemoryMiB {
+		b.logger.Debug("batch: above the memory budget; waiting")
+		return false
+	}
+	return true
+}
+
+// wait waits until we're within budget or ctx is done.
+func (b *batchBudget) wait(ctx context.Context) error {
+	for !b.within() {
+		select {
+		case <-time.After(batchPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
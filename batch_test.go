@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/model"
+)
+
+func newBatchSession(t *testing.T) *Session {
+	sess := newSessionForTestingNoLookups(t)
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	return sess
+}
+
+func newBatchJob(sess *Session, sleepTime time.Duration, onMeasurement func(
+	*model.Measurement, error)) BatchJob {
+	return BatchJob{
+		Experiment: NewExperiment(sess, example.NewExperimentMeasurer(
+			example.Config{SleepTime: int64(sleepTime)}, "example",
+		)),
+		Inputs:        []string{""},
+		OnMeasurement: onMeasurement,
+	}
+}
+
+func TestRunBatchConcurrently(t *testing.T) {
+	sess := newBatchSession(t)
+	defer sess.Close()
+	var (
+		count int
+		mu    sync.Mutex
+	)
+	onMeasurement := func(measurement *model.Measurement, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		count++
+		mu.Unlock()
+	}
+	var jobs []BatchJob
+	for idx := 0; idx < 4; idx++ {
+		jobs = append(jobs, newBatchJob(sess, 200*time.Millisecond, onMeasurement))
+	}
+	start := time.Now()
+	err := sess.RunBatch(context.Background(), BatchConfig{
+		MaxExperiments: 4,
+		MaxSockets:     16,
+	}, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 600*time.Millisecond {
+		t.Fatalf("jobs did not run concurrently: %s", elapsed)
+	}
+	if count != 4 {
+		t.Fatal("not the number of measurements we expected")
+	}
+}
+
+func TestRunBatchCanceledContext(t *testing.T) {
+	sess := newBatchSession(t)
+	defer sess.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	err := sess.RunBatch(ctx, BatchConfig{}, []BatchJob{
+		newBatchJob(sess, time.Second, func(*model.Measurement, error) {
+			t.Error("should not be called")
+		}),
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestBatchBudgetBandwidth(t *testing.T) {
+	sess := newBatchSession(t)
+	defer sess.Close()
+	budget := newBatchBudget(sess, BatchConfig{MaxKiBps: 1})
+	budget.last = time.Now().Add(-time.Second)
+	sess.byteCounter.CountBytesReceived(1 << 20)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := budget.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected")
+	}
+	if budget.kibps() <= 1 {
+		t.Fatal("not the rate we expected")
+	}
+}
+
+func TestBatchBudgetNoLimits(t *testing.T) {
+	sess := newBatchSession(t)
+	defer sess.Close()
+	budget := newBatchBudget(sess, BatchConfig{})
+	sess.byteCounter.CountBytesReceived(1 << 20)
+	if err := budget.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
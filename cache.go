@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+)
+
+// cacheNamespace is the namespace of the KVStore containing the results
+// that are expensive to obtain and that we can reuse for some time, e.g.,
+// across several miniooni invocations (see kvstore.TTLKeyValueStore).
+const cacheNamespace = "cache"
+
+const (
+	// checkInCacheTTL is how long we reuse the URLs returned by the
+	// probe services for the same country, categories and limit.
+	checkInCacheTTL = time.Hour
+
+	// locationCacheTTL is how long we reuse the probe location. We keep
+	// it short, because the location changes when the network changes.
+	locationCacheTTL = 5 * time.Minute
+)
+
+// newCache creates the cache backed by store and removes the entries
+// that have expired since we last used it.
+func newCache(store model.KeyValueStore, logger model.Logger) *kvstore.TTLKeyValueStore {
+	cache := kvstore.NewTTLKeyValueStore(kvstore.WithNamespace(store, cacheNamespace))
+	if _, err := cache.Sweep(""); err != nil {
+		logger.Debugf("session: cannot sweep the cache: %s", err.Error())
+	}
+	return cache
+}
+
+// cacheKey returns the key of the entry for params, which must be
+// serializable as JSON, inside the cache of the given kind.
+func cacheKey(kind string, params interface{}) string {
+	data, _ := json.Marshal(params)
+	digest := sha256.Sum256(data)
+	return kind + kvstore.NamespaceSeparator + hex.EncodeToString(digest[:])
+}
+
+// getCached unmarshals into value the fresh entry of the cache with the
+// given key and returns whether it succeeded.
+func (s *Session) getCached(key string, value interface{}) bool {
+	data, err := s.cache.GetFresh(key)
+	return err == nil && json.Unmarshal(data, value) == nil
+}
+
+// setCached saves value into the cache with the given key and ttl.
+func (s *Session) setCached(key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = s.cache.SetWithTTL(key, data, ttl)
+	}
+	if err != nil {
+		s.logger.Debugf("session: cannot cache %s: %s", key, err.Error())
+	}
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestCacheKey(t *testing.T) {
+	first := cacheKey("checkin", model.FetchURLListConfig{CountryCode: "IT"})
+	second := cacheKey("checkin", model.FetchURLListConfig{CountryCode: "IT"})
+	third := cacheKey("checkin", model.FetchURLListConfig{CountryCode: "DE"})
+	if first != second || first == third {
+		t.Fatal("unexpected cache keys")
+	}
+}
+
+func TestSessionCache(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	sess := &Session{cache: newCache(store, log.Log), logger: log.Log}
+	var urls []model.URLInfo
+	if sess.getCached("checkin.x", &urls) {
+		t.Fatal("expected a cache miss")
+	}
+	sess.setCached("checkin.x", []model.URLInfo{{URL: "https://x.org"}}, time.Hour)
+	if !sess.getCached("checkin.x", &urls) || len(urls) != 1 || urls[0].URL != "https://x.org" {
+		t.Fatal("expected a cache hit")
+	}
+	sess.setCached("checkin.y", []model.URLInfo{}, -time.Hour)
+	if sess.getCached("checkin.y", &urls) {
+		t.Fatal("expected an expired entry")
+	}
+	newCache(store, log.Log) // sweeps the expired entries
+	if _, err := store.Get("cache.checkin.y"); err == nil {
+		t.Fatal("expected the expired entry to be removed")
+	}
+	if _, err := store.Get("cache.checkin.x"); err != nil {
+		t.Fatal("expected the fresh entry to be kept")
+	}
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+const (
+	// crashFilePrefix is the prefix of the crash files inside CrashDir.
+	crashFilePrefix = "crash-"
+
+	// crashFileSuffix is the suffix of the crash files inside CrashDir.
+	crashFileSuffix = ".json"
+
+	// maxCrashFiles is the number of crash files kept inside CrashDir.
+	maxCrashFiles = 16
+)
+
+var (
+	// ErrCrashReportsDisabled indicates that we cannot submit crash
+	// reports because the user did not opt in.
+	ErrCrashReportsDisabled = errors.New("session: crash reports are disabled")
+
+	// ErrNoCrashReportsURL indicates that we cannot submit crash reports
+	// because SessionConfig.CrashReportsURL is empty.
+	ErrNoCrashReportsURL = errors.New("session: no crash reports URL")
+)
+
+// CrashFiles returns the paths of the crash files saved into
+// SessionConfig.CrashDir that we have not submitted yet, oldest first.
+// Each crash file contains a Diagnostic serialized as JSON.
+func (s *Session) CrashFiles() ([]string, error) {
+	if s.crashDir == "" {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(s.crashDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var out []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, crashFilePrefix) && strings.HasSuffix(name, crashFileSuffix) {
+			out = append(out, filepath.Join(s.crashDir, name))
+		}
+	}
+	sort.Strings(out) // the names contain the time
+	return out, nil
+}
+
+// SubmitCrashReports submits the crash files returned by CrashFiles to
+// SessionConfig.CrashReportsURL and removes them. We only submit the
+// anonymous subset of each crash file described by probeservices.CrashReport.
+// This function fails with ErrCrashReportsDisabled unless the user opted in
+// by setting SessionConfig.EnableCrashReports, and with ErrNoCrashReportsURL
+// if there is no CrashReportsURL. Returns the number of crash reports
+// submitted and the first error that occurred, if any.
+func (s *Session) SubmitCrashReports(ctx context.Context) (int, error) {
+	if !s.crashReportsEnabled {
+		return 0, ErrCrashReportsDisabled
+	}
+	if s.crashReportsURL == "" {
+		return 0, ErrNoCrashReportsURL
+	}
+	paths, err := s.CrashFiles()
+	if err != nil || len(paths) <= 0 {
+		return 0, err
+	}
+	ctx = s.withTracer(ctx)
+	clnt, err := probeservices.NewClient(s, model.Service{
+		Address: s.crashReportsURL,
+		Type:    "https",
+	})
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return count, err
+		}
+		var diagnostic Diagnostic
+		if err := json.Unmarshal(data, &diagnostic); err != nil {
+			s.logger.Warnf("session: removing invalid crash file %s: %s", path, err.Error())
+			os.Remove(path)
+			continue
+		}
+		err = clnt.SubmitCrashReport(ctx, s.crashReportsURL, s.newCrashReport(diagnostic))
+		if err != nil {
+			return count, err
+		}
+		if err := os.Remove(path); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// newCrashReport converts diagnostic to a crash report. We redact the failure
+// again because the crash file may have been written by a previous session.
+func (s *Session) newCrashReport(diagnostic Diagnostic) probeservices.CrashReport {
+	return probeservices.CrashReport{
+		EngineVersion:   diagnostic.EngineVersion,
+		Experiment:      diagnostic.Experiment,
+		Failure:         s.redactLine(diagnostic.Failure),
+		Platform:        s.Platform(),
+		SoftwareName:    s.SoftwareName(),
+		SoftwareVersion: s.SoftwareVersion(),
+		Stack:           diagnostic.Stack,
+		Time:            diagnostic.Time,
+	}
+}
+
+// writeCrashFile saves diagnostic, which must describe a panic, into a
+// new crash file inside CrashDir, unless CrashDir is empty. We then remove
+// the oldest crash files, such that we keep at most maxCrashFiles.
+func (s *Session) writeCrashFile(diagnostic Diagnostic) error {
+	if s.crashDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.crashDir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(diagnostic)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s%s-%s%s", crashFilePrefix,
+		diagnostic.Time.Format("20060102T150405.000000000Z"),
+		diagnostic.Experiment, crashFileSuffix)
+	if err := ioutil.WriteFile(filepath.Join(s.crashDir, name), data, 0600); err != nil {
+		return err
+	}
+	paths, err := s.CrashFiles()
+	if err != nil {
+		return err
+	}
+	for len(paths) > maxCrashFiles {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func TestCrashReports(t *testing.T) {
+	var received []probeservices.CrashReport
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v1/test-helpers":
+				json.NewEncoder(w).Encode(map[string][]model.Service{})
+			case "/crashes":
+				var report probeservices.CrashReport
+				if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+					w.WriteHeader(400)
+					return
+				}
+				received = append(received, report)
+				w.Write([]byte(`{}`))
+			default:
+				w.WriteHeader(404)
+			}
+		}))
+	defer server.Close()
+	crashDir, err := ioutil.TempDir("", "ooniprobe-engine-crashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(crashDir)
+	newSession := func(enabled bool, crashReportsURL string) *Session {
+		sess, err := NewSession(SessionConfig{
+			AssetsDir: "testdata",
+			BackendProfile: &BackendProfile{
+				ProbeServicesURL: server.URL,
+				RootCAs: pem.EncodeToMemory(&pem.Block{
+					Type:  "CERTIFICATE",
+					Bytes: server.Certificate().Raw,
+				}),
+			},
+			CrashDir:           filepath.Join(crashDir, "crashes"),
+			CrashReportsURL:    crashReportsURL,
+			EnableCrashReports: enabled,
+			Logger:             log.Log,
+			SoftwareName:       "ooniprobe-engine",
+			SoftwareVersion:    "0.0.1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess
+	}
+
+	sess := newSession(false, server.URL+"/crashes")
+	defer sess.Close()
+	if paths, err := sess.CrashFiles(); err != nil || len(paths) != 0 {
+		t.Fatal("expected no crash files", paths, err)
+	}
+	exp := &Experiment{measurer: panickingMeasurer{}, session: sess, testName: "panicking"}
+	ctx := context.Background()
+	stack, err := exp.runMeasurer(ctx, exp.measurer, &measurementSession{Session: sess},
+		new(model.Measurement), model.NewPrinterCallbacks(sess.Logger()))
+	exp.maybeAddDiagnostic(ctx, "https://www.example.com/", stack, err)
+	exp.maybeAddDiagnostic(ctx, "", "", errors.New("mocked error")) // not a crash
+	paths, err := sess.CrashFiles()
+	if err != nil || len(paths) != 1 {
+		t.Fatal("expected a single crash file", paths, err)
+	}
+	if _, err := sess.SubmitCrashReports(ctx); !errors.Is(err, ErrCrashReportsDisabled) {
+		t.Fatal("not the error we expected", err)
+	}
+
+	sess = newSession(true, "")
+	defer sess.Close()
+	if _, err := sess.SubmitCrashReports(ctx); !errors.Is(err, ErrNoCrashReportsURL) {
+		t.Fatal("not the error we expected", err)
+	}
+
+	sess = newSession(true, server.URL+"/crashes")
+	defer sess.Close()
+	count, err := sess.SubmitCrashReports(ctx)
+	if err != nil || count != 1 {
+		t.Fatal("expected to submit a crash report", count, err)
+	}
+	if len(received) != 1 || received[0].Experiment != "panicking" ||
+		received[0].Stack != stack || received[0].SoftwareName != "ooniprobe-engine" {
+		t.Fatalf("unexpected crash reports: %+v", received)
+	}
+	if paths, err := sess.CrashFiles(); err != nil || len(paths) != 0 {
+		t.Fatal("expected no crash files", paths, err)
+	}
+}
+
+func TestNewSessionInvalidCrashReportsURL(t *testing.T) {
+	_, err := NewSession(SessionConfig{
+		AssetsDir:       "testdata",
+		CrashReportsURL: "/crashes",
+		Logger:          log.Log,
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+	if !errors.Is(err, probeservices.ErrInvalidCrashReportsURL) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestWriteCrashFileKeepsMostRecent(t *testing.T) {
+	crashDir, err := ioutil.TempDir("", "ooniprobe-engine-crashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(crashDir)
+	sess := &Session{crashDir: crashDir}
+	start := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	for idx := 0; idx < maxCrashFiles+4; idx++ {
+		err := sess.writeCrashFile(Diagnostic{
+			Experiment: "panicking",
+			Time:       start.Add(time.Duration(idx) * time.Second),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := sess.CrashFiles()
+	if err != nil || len(paths) != maxCrashFiles {
+		t.Fatal("unexpected crash files", paths, err)
+	}
+	data, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oldest Diagnostic
+	if err := json.Unmarshal(data, &oldest); err != nil {
+		t.Fatal(err)
+	}
+	if !oldest.Time.Equal(start.Add(4 * time.Second)) {
+		t.Fatal("we did not remove the oldest crash files", oldest.Time)
+	}
+}
+
+func TestNewCrashReportRedactsFailure(t *testing.T) {
+	sess := &Session{location: &model.LocationInfo{ProbeIP: "130.192.91.211"}}
+	report := sess.newCrashReport(Diagnostic{
+		Failure: "engine: experiment panicked: dial 130.192.91.211:443 from 10.0.0.1:5555",
+	})
+	if strings.Contains(report.Failure, "130.192.91.211") ||
+		strings.Contains(report.Failure, "10.0.0.1") {
+		t.Fatal("we did not redact the failure", report.Failure)
+	}
+}
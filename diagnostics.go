@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/ooni/probe-engine/eventbus"
+	"github.com/ooni/probe-engine/internal/redact"
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	// diagnosticLogLines is the number of log lines in a Diagnostic.
+	diagnosticLogLines = 256
+
+	// maxDiagnostics is the number of diagnostics kept by the session.
+	maxDiagnostics = 16
+)
+
+// ErrMeasurerPanic indicates that an experiment panicked while measuring.
+var ErrMeasurerPanic = errors.New("engine: experiment panicked")
+
+// Diagnostic describes a measurement that failed hard, i.e., whose
+// experiment returned an error or panicked, to ease bug reports. Logs
+// contains the most recent log lines of the session. We remove the probe
+// IP addresses and the local network addresses from Logs and Failure. Stack
+// is the stack of the experiment that panicked, if any, while Goroutines
+// contains the stacks of all the goroutines when the watchdog gave up on a
+// stuck experiment (see SessionConfig.MeasurementWatchdog).
+type Diagnostic struct {
+	EngineVersion string    `json:"engine_version"`
+	Experiment    string    `json:"experiment"`
+	Failure       string    `json:"failure"`
+	Goroutines    string    `json:"goroutines,omitempty"`
+	Input         string    `json:"input"`
+	Logs          []string  `json:"logs"`
+	Stack         string    `json:"stack,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// Diagnostics returns the diagnostics of the measurements that failed
+// hard during this session, oldest first. We keep the most recent ones.
+func (s *Session) Diagnostics() []Diagnostic {
+	s.diagnosticsMu.Lock()
+	defer s.diagnosticsMu.Unlock()
+	return append([]Diagnostic{}, s.diagnostics...)
+}
+
+func (s *Session) addDiagnostic(diagnostic Diagnostic) {
+	s.diagnosticsMu.Lock()
+	defer s.diagnosticsMu.Unlock()
+	s.diagnostics = append(s.diagnostics, diagnostic)
+	if len(s.diagnostics) > maxDiagnostics {
+		s.diagnostics = s.diagnostics[len(s.diagnostics)-maxDiagnostics:]
+	}
+}
+
+// redactLogs returns a copy of lines without the probe IP addresses
+// and the local network addresses.
+func (s *Session) redactLogs(lines []string) []string {
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		out = append(out, s.redactLine(line))
+	}
+	return out
+}
+
+// redactLine is like redactLogs but for a single line.
+func (s *Session) redactLine(line string) string {
+	rules := []redact.Rule{
+		redact.Addresses(s.ProbeIP(), s.ProbeIPv4(), s.ProbeIPv6()),
+		redact.LocalAddresses(),
+	}
+	for _, rule := range rules {
+		line = rule(nil, line)
+	}
+	return line
+}
+
+// runMeasurer runs measurer and converts a panic into an error
+// wrapping ErrMeasurerPanic, in which case we also return the stack.
+func (e *Experiment) runMeasurer(
+	ctx context.Context, measurer model.ExperimentMeasurer, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) (stack string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack, err = string(debug.Stack()), fmt.Errorf("%w: %v", ErrMeasurerPanic, r)
+		}
+	}()
+	err = measurer.Run(ctx, sess, measurement, callbacks)
+	return
+}
+
+// hardFailure returns err or, if err is nil, the reason why measurement
+// failed hard according to the measurer (see model.ExperimentFailureDetector).
+func (e *Experiment) hardFailure(measurement *model.Measurement, err error) error {
+	if err != nil {
+		return err
+	}
+	if detector, ok := e.measurer.(model.ExperimentFailureDetector); ok {
+		return detector.HardFailure(measurement)
+	}
+	return nil
+}
+
+// maybeAddDiagnostic adds a Diagnostic to the session if the measurement
+// failed hard with err, unless we were interrupted or exceeded the data cap.
+// We redact the failure like the logs, since errors often include addresses.
+// When the experiment panicked, we also write a crash file.
+func (e *Experiment) maybeAddDiagnostic(
+	ctx context.Context, input, stack string, err error) {
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrDataCapExceeded) {
+		return
+	}
+	e.logger().Warnf("experiment: %s failed: %s", e.testName, err.Error())
+	diagnostic := Diagnostic{
+		EngineVersion: Version,
+		Experiment:    e.testName,
+		Failure:       e.session.redactLine(err.Error()),
+		Input:         input,
+		Logs:          e.session.redactLogs(e.session.logCapturer.Lines()),
+		Stack:         stack,
+		Time:          time.Now().UTC(),
+	}
+	var stuck *watchdogError
+	if errors.As(err, &stuck) {
+		diagnostic.Goroutines = stuck.Goroutines
+	}
+	e.session.addDiagnostic(diagnostic)
+	e.session.eventBus.Publish(eventbus.Diagnostic{
+		Experiment: e.testName,
+		Failure:    diagnostic.Failure,
+		Input:      input,
+		Panic:      stack != "",
+	})
+	if stack == "" {
+		return
+	}
+	if err := e.session.writeCrashFile(diagnostic); err != nil {
+		e.logger().Warnf("experiment: cannot write crash file: %s", err.Error())
+	}
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+type panickingMeasurer struct{}
+
+func (panickingMeasurer) ExperimentName() string {
+	return "panicking"
+}
+
+func (panickingMeasurer) ExperimentVersion() string {
+	return "0.1.0"
+}
+
+func (panickingMeasurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	sess.Logger().Infof("connecting to 192.168.1.1:443")
+	panic("mocked panic")
+}
+
+func TestMeasurerPanicAddsDiagnostic(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	exp := &Experiment{measurer: panickingMeasurer{}, session: sess, testName: "panicking"}
+	measurement := new(model.Measurement)
+	ctx := context.Background()
+	stack, err := exp.runMeasurer(ctx, exp.measurer, &measurementSession{Session: sess},
+		measurement, model.NewPrinterCallbacks(sess.Logger()))
+	if !errors.Is(err, ErrMeasurerPanic) || !strings.Contains(err.Error(), "mocked panic") {
+		t.Fatal("not the error we expected", err)
+	}
+	if !strings.Contains(stack, "panickingMeasurer") {
+		t.Fatal("the stack does not mention the measurer")
+	}
+	exp.maybeAddDiagnostic(ctx, "https://www.example.com/", stack, err)
+	diagnostics := sess.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatal("expected a diagnostic")
+	}
+	diagnostic := diagnostics[0]
+	if diagnostic.Experiment != "panicking" || diagnostic.Input != "https://www.example.com/" ||
+		diagnostic.Failure != err.Error() || diagnostic.Stack != stack {
+		t.Fatal("unexpected diagnostic", diagnostic)
+	}
+	logs := strings.Join(diagnostic.Logs, "\n")
+	if !strings.Contains(logs, "connecting to [scrubbed]:443") || strings.Contains(logs, "192.168.1.1") {
+		t.Fatal("logs not captured or not redacted", logs)
+	}
+}
+
+func TestMaybeAddDiagnosticSkipsSoftFailures(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	exp := &Experiment{session: sess, testName: "example"}
+	exp.maybeAddDiagnostic(context.Background(), "", "", nil)
+	exp.maybeAddDiagnostic(context.Background(), "", "", ErrDataCapExceeded)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exp.maybeAddDiagnostic(ctx, "", "", errors.New("mocked error"))
+	if len(sess.Diagnostics()) != 0 {
+		t.Fatal("expected no diagnostics")
+	}
+}
+
+type failingControlMeasurer struct {
+	model.ExperimentMeasurer
+}
+
+func (failingControlMeasurer) HardFailure(measurement *model.Measurement) error {
+	return errors.New("control measurement failed")
+}
+
+func TestHardFailure(t *testing.T) {
+	exp := &Experiment{measurer: panickingMeasurer{}}
+	measurement := new(model.Measurement)
+	if err := exp.hardFailure(measurement, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := errors.New("mocked error")
+	if err := exp.hardFailure(measurement, expected); err != expected {
+		t.Fatal("not the error we expected", err)
+	}
+	exp.measurer = failingControlMeasurer{panickingMeasurer{}}
+	if err := exp.hardFailure(measurement, nil); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestDiagnosticsAreBounded(t *testing.T) {
+	sess := &Session{}
+	for i := 0; i < maxDiagnostics+3; i++ {
+		sess.addDiagnostic(Diagnostic{Input: fmt.Sprintf("%d", i)})
+	}
+	diagnostics := sess.Diagnostics()
+	if len(diagnostics) != maxDiagnostics || diagnostics[0].Input != "3" {
+		t.Fatal("unexpected diagnostics")
+	}
+}
@@ -0,0 +1,80 @@
+// Package eventbus contains a typed publish/subscribe event bus. The
+// engine, the experiments, and netx publish events describing progress and
+// diagnostics, and frontends, such as oonimkall and miniooni, subscribe to
+// them, so that adding a new event type does not require changing every
+// frontend. Publishers find the bus in the context (see WithBus).
+package eventbus
+
+import (
+	"context"
+	"sync"
+)
+
+// Event is an event published on the bus. Name returns the name of the
+// event (e.g. "experiment.progress"), which frontends that do not know
+// about a specific event type may use to forward it.
+type Event interface {
+	Name() string
+}
+
+// Bus delivers the published events to the subscribers.
+type Bus struct {
+	mu          sync.Mutex
+	next        int64
+	subscribers map[int64]func(ev Event)
+}
+
+// New creates a new Bus without subscribers.
+func New() *Bus {
+	return &Bus{subscribers: make(map[int64]func(ev Event))}
+}
+
+// Subscribe registers fn to be called for each published event and
+// returns a function that removes the subscription. We call fn from the
+// goroutine that publishes the event, therefore fn should not block and
+// must be safe to call from several goroutines at the same time.
+func (b *Bus) Subscribe(fn func(ev Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish delivers ev to all the subscribers.
+func (b *Bus) Publish(ev Event) {
+	b.mu.Lock()
+	subscribers := make([]func(ev Event), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+}
+
+type busKey struct{}
+
+// WithBus returns a copy of ctx using bus.
+func WithBus(ctx context.Context, bus *Bus) context.Context {
+	return context.WithValue(ctx, busKey{}, bus)
+}
+
+// ContextBus returns the bus in ctx, or nil.
+func ContextBus(ctx context.Context) *Bus {
+	bus, _ := ctx.Value(busKey{}).(*Bus)
+	return bus
+}
+
+// Publish publishes ev on the bus in ctx, if any.
+func Publish(ctx context.Context, ev Event) {
+	if bus := ContextBus(ctx); bus != nil {
+		bus.Publish(ev)
+	}
+}
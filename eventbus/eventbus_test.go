@@ -0,0 +1,57 @@
+package eventbus_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ooni/probe-engine/eventbus"
+)
+
+func TestBus(t *testing.T) {
+	bus := eventbus.New()
+	var (
+		first, second []eventbus.Event
+		mu            sync.Mutex
+	)
+	unsubscribe := bus.Subscribe(func(ev eventbus.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		first = append(first, ev)
+	})
+	bus.Subscribe(func(ev eventbus.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		second = append(second, ev)
+	})
+	bus.Publish(eventbus.Progress{Experiment: "example", Percentage: 0.5})
+	unsubscribe()
+	bus.Publish(eventbus.Dial{Address: "8.8.8.8:53", Network: "udp"})
+	if len(first) != 1 || len(second) != 2 {
+		t.Fatal("unexpected number of events", len(first), len(second))
+	}
+	if ev, ok := first[0].(eventbus.Progress); !ok || ev.Percentage != 0.5 {
+		t.Fatal("unexpected event", first[0])
+	}
+	if second[1].Name() != "netx.dial" {
+		t.Fatal("unexpected event name", second[1].Name())
+	}
+}
+
+func TestPublishWithContext(t *testing.T) {
+	// must not panic when there is no bus
+	eventbus.Publish(context.Background(), eventbus.Progress{})
+	bus := eventbus.New()
+	var count int
+	bus.Subscribe(func(ev eventbus.Event) {
+		count++
+	})
+	ctx := eventbus.WithBus(context.Background(), bus)
+	if eventbus.ContextBus(ctx) != bus {
+		t.Fatal("not the bus we expected")
+	}
+	eventbus.Publish(ctx, eventbus.Diagnostic{Experiment: "example"})
+	if count != 1 {
+		t.Fatal("event not delivered")
+	}
+}
@@ -0,0 +1,54 @@
+package eventbus
+
+// DataUsage is published when an experiment reports the data it has
+// used outside of netx (see model.ExperimentCallbacks.OnDataUsage).
+type DataUsage struct {
+	Experiment        string  `json:"experiment"`
+	KibiBytesReceived float64 `json:"kibi_bytes_received"`
+	KibiBytesSent     float64 `json:"kibi_bytes_sent"`
+}
+
+// Name implements Event.Name.
+func (DataUsage) Name() string {
+	return "experiment.data_usage"
+}
+
+// Diagnostic is published when a measurement fails hard, i.e., when the
+// experiment returns an error or panics (see engine.Diagnostic).
+type Diagnostic struct {
+	Experiment string `json:"experiment"`
+	Failure    string `json:"failure"`
+	Input      string `json:"input"`
+	Panic      bool   `json:"panic"`
+}
+
+// Name implements Event.Name.
+func (Diagnostic) Name() string {
+	return "experiment.diagnostic"
+}
+
+// Dial is published by netx when a dial completes. Failure is
+// empty when the dial succeeded.
+type Dial struct {
+	Address string `json:"address"`
+	Failure string `json:"failure"`
+	Network string `json:"network"`
+}
+
+// Name implements Event.Name.
+func (Dial) Name() string {
+	return "netx.dial"
+}
+
+// Progress is published when an experiment makes progress. The
+// percentage is between zero and one.
+type Progress struct {
+	Experiment string  `json:"experiment"`
+	Message    string  `json:"message"`
+	Percentage float64 `json:"percentage"`
+}
+
+// Name implements Event.Name.
+func (Progress) Name() string {
+	return "experiment.progress"
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/eventbus"
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestSessionEventBus(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	var (
+		events []eventbus.Event
+		mu     sync.Mutex
+	)
+	defer sess.EventBus().Subscribe(func(ev eventbus.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	})()
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(example.Config{
+		Message: "antani", ReturnError: true, SleepTime: int64(time.Millisecond),
+	}, "example"))
+	if _, err := exp.Measure(""); err == nil {
+		t.Fatal("expected an error here")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var progress, diagnostic bool
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case eventbus.Progress:
+			progress = ev.Experiment == "example" && ev.Message == "antani"
+		case eventbus.Diagnostic:
+			diagnostic = ev.Experiment == "example" && ev.Failure != "" && !ev.Panic
+		}
+	}
+	if !progress || !diagnostic {
+		t.Fatalf("missing events: %+v", events)
+	}
+}
@@ -6,29 +6,50 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"reflect"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/iancoleman/strcase"
+	"github.com/ooni/probe-engine/eventbus"
+	"github.com/ooni/probe-engine/experiment/cdnfronting"
 	"github.com/ooni/probe-engine/experiment/dash"
+	"github.com/ooni/probe-engine/experiment/dnscheck"
+	"github.com/ooni/probe-engine/experiment/dnsconsistency"
+	"github.com/ooni/probe-engine/experiment/dnstraceroute"
+	"github.com/ooni/probe-engine/experiment/echblocking"
+	"github.com/ooni/probe-engine/experiment/emailblocking"
 	"github.com/ooni/probe-engine/experiment/example"
 	"github.com/ooni/probe-engine/experiment/fbmessenger"
 	"github.com/ooni/probe-engine/experiment/hhfm"
+	"github.com/ooni/probe-engine/experiment/hhmd"
 	"github.com/ooni/probe-engine/experiment/hirl"
 	"github.com/ooni/probe-engine/experiment/ndt7"
+	"github.com/ooni/probe-engine/experiment/ntpreachability"
+	"github.com/ooni/probe-engine/experiment/portscan"
 	"github.com/ooni/probe-engine/experiment/psiphon"
+	"github.com/ooni/probe-engine/experiment/quicping"
+	"github.com/ooni/probe-engine/experiment/signal"
 	"github.com/ooni/probe-engine/experiment/sniblocking"
 	"github.com/ooni/probe-engine/experiment/stunreachability"
+	"github.com/ooni/probe-engine/experiment/tcpping"
 	"github.com/ooni/probe-engine/experiment/telegram"
 	"github.com/ooni/probe-engine/experiment/tor"
+	"github.com/ooni/probe-engine/experiment/torsf"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/experiment/vpnhandshake"
 	"github.com/ooni/probe-engine/experiment/webconnectivity"
+	"github.com/ooni/probe-engine/experiment/webconnectivityh3"
 	"github.com/ooni/probe-engine/experiment/whatsapp"
+	"github.com/ooni/probe-engine/geolocate"
+	"github.com/ooni/probe-engine/internal/litemode"
 	"github.com/ooni/probe-engine/internal/platform"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/model/dataformat"
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/httptransport"
@@ -66,6 +87,7 @@ type ExperimentBuilder struct {
 	build         func(interface{}) *Experiment
 	callbacks     model.ExperimentCallbacks
 	config        interface{}
+	defaultInputs []string
 	inputPolicy   InputPolicy
 	interruptible bool
 }
@@ -81,13 +103,20 @@ func (b *ExperimentBuilder) InputPolicy() InputPolicy {
 	return b.inputPolicy
 }
 
+// DefaultInputs returns the inputs to measure when the experiment has
+// optional input and the user did not provide any (see InputLoader).
+func (b *ExperimentBuilder) DefaultInputs() []string {
+	return append([]string(nil), b.defaultInputs...)
+}
+
 // OptionInfo contains info about an option
 type OptionInfo struct {
 	Doc  string
 	Type string
 }
 
-// Options returns info about all options
+// Options returns info about all options. We skip the unexported
+// fields, which are not options but rather hooks for testing.
 func (b *ExperimentBuilder) Options() (map[string]OptionInfo, error) {
 	result := make(map[string]OptionInfo)
 	ptrinfo := reflect.ValueOf(b.config)
@@ -100,6 +129,9 @@ func (b *ExperimentBuilder) Options() (map[string]OptionInfo, error) {
 	}
 	for i := 0; i < structinfo.NumField(); i++ {
 		field := structinfo.Field(i)
+		if field.PkgPath != "" {
+			continue // not exported
+		}
 		result[field.Name] = OptionInfo{
 			Doc:  field.Tag.Get("ooni"),
 			Type: field.Type.String(),
@@ -147,6 +179,79 @@ func (b *ExperimentBuilder) SetOptionString(key, value string) error {
 	return nil
 }
 
+// SetOptionStringMap sets a map[string]string option. We copy value, such
+// that changing it later does not affect the option.
+func (b *ExperimentBuilder) SetOptionStringMap(key string, value map[string]string) error {
+	field, err := fieldbyname(b.config, key)
+	if err != nil {
+		return err
+	}
+	if field.Type() != reflect.TypeOf(value) {
+		return errors.New("field is not a map[string]string")
+	}
+	copied := make(map[string]string, len(value))
+	for k, v := range value {
+		copied[k] = v
+	}
+	field.Set(reflect.ValueOf(copied))
+	return nil
+}
+
+// SetOptionAny sets an option of any type. We convert strings, such
+// as the ones read from a CSV file, to booleans and integers when the option
+// is a bool or an int64, integral numbers, such as the ones read from
+// JSON, to int64 when the option is an int64, and JSON objects whose
+// values are strings to map[string]string.
+func (b *ExperimentBuilder) SetOptionAny(key string, value interface{}) error {
+	field, err := fieldbyname(b.config, key)
+	if err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		return b.SetOptionBool(key, v)
+	case float64:
+		if field.Kind() == reflect.Int64 && v == float64(int64(v)) {
+			return b.SetOptionInt(key, int64(v))
+		}
+		return fmt.Errorf("cannot set %s using %v", key, v)
+	case int64:
+		return b.SetOptionInt(key, v)
+	case int:
+		return b.SetOptionInt(key, int64(v))
+	case string:
+		switch field.Kind() {
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			return b.SetOptionBool(key, parsed)
+		case reflect.Int64:
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			return b.SetOptionInt(key, parsed)
+		}
+		return b.SetOptionString(key, v)
+	case map[string]string:
+		return b.SetOptionStringMap(key, v)
+	case map[string]interface{}:
+		converted := make(map[string]string, len(v))
+		for k, entry := range v {
+			s, ok := entry.(string)
+			if !ok {
+				return fmt.Errorf("cannot set %s using a %T value for %s", key, entry, k)
+			}
+			converted[k] = s
+		}
+		return b.SetOptionStringMap(key, converted)
+	default:
+		return fmt.Errorf("cannot set %s using a %T", key, value)
+	}
+}
+
 // SetCallbacks sets the interactive callbacks
 func (b *ExperimentBuilder) SetCallbacks(callbacks model.ExperimentCallbacks) {
 	b.callbacks = callbacks
@@ -173,9 +278,34 @@ func fieldbyname(v interface{}, key string) (reflect.Value, error) {
 func (b *ExperimentBuilder) NewExperiment() *Experiment {
 	experiment := b.build(b.config)
 	experiment.callbacks = b.callbacks
+	experiment.reconfigure = (&ExperimentBuilder{
+		build:  b.build,
+		config: copyConfig(b.config),
+	}).measurerWithOptions
 	return experiment
 }
 
+// measurerWithOptions returns a measurer using a copy of the config
+// where we have also set the given options.
+func (b *ExperimentBuilder) measurerWithOptions(
+	options map[string]interface{}) (model.ExperimentMeasurer, error) {
+	copied := &ExperimentBuilder{config: copyConfig(b.config)}
+	for key, value := range options {
+		if err := copied.SetOptionAny(key, value); err != nil {
+			return nil, fmt.Errorf("cannot set option %s: %w", key, err)
+		}
+	}
+	return b.build(copied.config).measurer, nil
+}
+
+// copyConfig returns a pointer to a copy of the struct pointed by config.
+func copyConfig(config interface{}) interface{} {
+	value := reflect.ValueOf(config).Elem()
+	copied := reflect.New(value.Type())
+	copied.Elem().Set(value)
+	return copied.Interface()
+}
+
 // canonicalizeExperimentName allows code to provide experiment names
 // in a more flexible way, where we have aliases.
 func canonicalizeExperimentName(name string) string {
@@ -188,20 +318,30 @@ func canonicalizeExperimentName(name string) string {
 }
 
 func newExperimentBuilder(session *Session, name string) (*ExperimentBuilder, error) {
+	experimentsMu.RLock()
 	factory, _ := experimentsByName[canonicalizeExperimentName(name)]
+	experimentsMu.RUnlock()
 	if factory == nil {
 		return nil, fmt.Errorf("no such experiment: %s", name)
 	}
 	builder := factory(session)
-	builder.callbacks = model.NewPrinterCallbacks(session.Logger())
+	builder.callbacks = model.NewPrinterCallbacks(
+		experimentLogger(session.logger, canonicalizeExperimentName(name)))
 	return builder, nil
 }
 
 // Experiment is an experiment instance.
 type Experiment struct {
+	annotations   map[string]string
+	anomalies     []convertedAnomaly
+	anomaliesMu   sync.Mutex
 	byteCounter   *bytecounter.Counter
 	callbacks     model.ExperimentCallbacks
 	measurer      model.ExperimentMeasurer
+	middleware    []ExperimentMiddleware
+	reconfigure   func(options map[string]interface{}) (model.ExperimentMeasurer, error)
+	records       []pendingRecord
+	recordsMu     sync.Mutex
 	report        *probeservices.Report
 	session       *Session
 	testName      string
@@ -215,7 +355,7 @@ type Experiment struct {
 func NewExperiment(sess *Session, measurer model.ExperimentMeasurer) *Experiment {
 	return &Experiment{
 		byteCounter:   bytecounter.New(),
-		callbacks:     model.NewPrinterCallbacks(sess.Logger()),
+		callbacks:     model.NewPrinterCallbacks(experimentLogger(sess.logger, measurer.ExperimentName())),
 		measurer:      measurer,
 		session:       sess,
 		testName:      measurer.ExperimentName(),
@@ -224,6 +364,18 @@ func NewExperiment(sess *Session, measurer model.ExperimentMeasurer) *Experiment
 	}
 }
 
+// AddAnnotations adds annotations that we will add to every measurement
+// of this experiment, in addition to the session annotations, which they
+// override. We return an error if any key is invalid (see
+// ValidateAnnotations). This function is not goroutine safe.
+func (e *Experiment) AddAnnotations(annotations map[string]string) error {
+	if err := ValidateAnnotations(annotations); err != nil {
+		return err
+	}
+	e.annotations = mergeAnnotations(e.annotations, annotations)
+	return nil
+}
+
 // KibiBytesReceived accounts for the KibiBytes received by the HTTP clients
 // managed by this session so far, including experiments.
 func (e *Experiment) KibiBytesReceived() float64 {
@@ -247,6 +399,50 @@ func (e *Experiment) OpenReport() (err error) {
 	return e.openReport(context.Background())
 }
 
+// logger returns the logger for the messages concerning the experiment.
+func (e *Experiment) logger() model.Logger {
+	return experimentLogger(e.session.logger, e.testName)
+}
+
+// IsAnomaly returns whether the experiment's measurer thinks that the
+// measurement shows signs of network interference. It is always false
+// for experiments that do not implement model.ExperimentAnomalyDetector.
+// For the measurements that we have converted to another data format
+// (see SessionConfig.DataFormatVersion), whose test keys the measurer
+// does not understand, we return what we decided before converting them.
+func (e *Experiment) IsAnomaly(measurement *model.Measurement) bool {
+	e.anomaliesMu.Lock()
+	for _, entry := range e.anomalies {
+		if entry.measurement == measurement {
+			e.anomaliesMu.Unlock()
+			return entry.anomaly
+		}
+	}
+	e.anomaliesMu.Unlock()
+	return isAnomaly(e.measurer, measurement)
+}
+
+// maxConvertedAnomalies is the maximum number of converted measurements
+// for which we remember whether they are an anomaly.
+const maxConvertedAnomalies = 64
+
+// convertedAnomaly tells whether a converted measurement is an anomaly.
+type convertedAnomaly struct {
+	anomaly     bool
+	measurement *model.Measurement
+}
+
+// rememberAnomaly remembers whether the converted measurement is an anomaly.
+func (e *Experiment) rememberAnomaly(measurement *model.Measurement, anomaly bool) {
+	e.anomaliesMu.Lock()
+	defer e.anomaliesMu.Unlock()
+	if len(e.anomalies) >= maxConvertedAnomalies {
+		e.anomalies = e.anomalies[1:]
+	}
+	e.anomalies = append(e.anomalies, convertedAnomaly{
+		anomaly: anomaly, measurement: measurement})
+}
+
 // ReportID returns the open reportID, if we have opened a report
 // successfully before, or an empty string, otherwise.
 func (e *Experiment) ReportID() string {
@@ -276,24 +472,61 @@ func (e *Experiment) Measure(input string) (*model.Measurement, error) {
 	return e.MeasureWithContext(context.Background(), input)
 }
 
-// MeasureWithContext is like Measure but with context.
+// MeasureWithContext is like Measure but with context. When the session
+// exceeds its data cap (see SessionConfig.DataCapKiB), we interrupt the
+// measurement and return the partial measurement along with ErrDataCapExceeded.
 func (e *Experiment) MeasureWithContext(
 	ctx context.Context, input string,
+) (*model.Measurement, error) {
+	return e.measure(ctx, input, e.measurer)
+}
+
+// measure implements MeasureWithContext using measurer, which is e.measurer
+// or, for MeasureWithOptions, a measurer of the same experiment using
+// other options. We never replace e.measurer, since the experiment may
+// be measuring several inputs at the same time.
+func (e *Experiment) measure(
+	ctx context.Context, input string, measurer model.ExperimentMeasurer,
 ) (measurement *model.Measurement, err error) {
+	if e.session.DataCapExceeded() {
+		err = ErrDataCapExceeded
+		return
+	}
+	ctx, span := model.StartSpan(e.session.withTracer(ctx), "experiment.measure")
+	span.SetAttribute("experiment", e.testName)
+	span.SetAttribute("input", input)
+	defer func() { span.End(err) }()
 	err = e.session.maybeLookupLocation(ctx) // this already tracks session bytes
 	if err != nil {
 		return
 	}
+	err = e.beforeMeasurement(ctx, input)
+	if err != nil {
+		return
+	}
+	ctx = eventbus.WithBus(ctx, e.session.eventBus)
 	ctx = dialer.WithSessionByteCounter(ctx, e.session.byteCounter)
 	ctx = dialer.WithExperimentByteCounter(ctx, e.byteCounter)
+	if e.session.LiteMode() {
+		ctx = litemode.WithLiteMode(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go e.session.enforceDataCap(ctx, cancel)
 	measurement = e.newMeasurement(input)
+	kibRecv, kibSent := e.KibiBytesReceived(), e.KibiBytesSent()
 	start := time.Now()
-	err = e.measurer.Run(ctx, e.session, measurement, &sessionExperimentCallbacks{
+	sess := &measurementSession{Session: e.session, testName: e.testName}
+	stack, err := e.runMeasurerWithWatchdog(ctx, measurer, sess, measurement, &sessionExperimentCallbacks{
 		exp:   e,
 		inner: e.callbacks,
 		sess:  e.session,
 	})
 	stop := time.Now()
+	if e.session.DataCapExceeded() {
+		err = ErrDataCapExceeded
+	}
+	e.maybeAddDiagnostic(ctx, input, stack, e.hardFailure(measurement, err))
 	measurement.MeasurementRuntime = stop.Sub(start).Seconds()
 	scrubErr := e.session.privacySettings.Apply(
 		measurement, e.session.ProbeIP(),
@@ -301,9 +534,49 @@ func (e *Experiment) MeasureWithContext(
 	if err == nil {
 		err = scrubErr
 	}
+	anomaly := err == nil && isAnomaly(measurer, measurement)
+	usage := model.DataUsage{
+		KibiBytesReceived: e.KibiBytesReceived() - kibRecv,
+		KibiBytesSent:     e.KibiBytesSent() - kibSent,
+	}
+	measurement.SetDataUsage(usage)
+	convertErr := dataformat.Convert(measurement, e.session.DataFormatVersion())
+	if err == nil {
+		err = convertErr
+	}
+	if measurement.DataFormatVersion != dataformat.Version020 {
+		e.rememberAnomaly(measurement, anomaly)
+	}
+	e.session.runSummary.measured(
+		e.testName, measurement, start, stop, usage.KibiBytesReceived,
+		usage.KibiBytesSent, anomaly, err,
+	)
+	if err == nil {
+		e.session.recordRuntime(e.testName, input, measurement.MeasurementRuntime)
+	}
+	e.recordMeasurement(measurement, anomaly, err)
+	e.observeMeasurement(anomaly, err)
+	e.session.writeToSink(measurement)
+	e.afterMeasurement(measurement, err)
 	return
 }
 
+// MeasureWithOptions is like MeasureWithContext but the measurer also uses
+// the given options, which override the ones set using the builder and
+// only apply to this measurement. We use this method for inputs carrying
+// their own options (see model.URLInfo).
+func (e *Experiment) MeasureWithOptions(ctx context.Context, input string,
+	options map[string]interface{}) (*model.Measurement, error) {
+	if len(options) <= 0 || e.reconfigure == nil {
+		return e.MeasureWithContext(ctx, input)
+	}
+	measurer, err := e.reconfigure(options)
+	if err != nil {
+		return nil, err
+	}
+	return e.measure(ctx, input, measurer)
+}
+
 type sessionExperimentCallbacks struct {
 	exp   *Experiment
 	inner model.ExperimentCallbacks
@@ -315,50 +588,130 @@ func (cb *sessionExperimentCallbacks) OnDataUsage(dloadKiB, uploadKiB float64) {
 	cb.exp.byteCounter.CountKibiBytesReceived(dloadKiB)
 	cb.sess.byteCounter.CountKibiBytesSent(uploadKiB)
 	cb.exp.byteCounter.CountKibiBytesSent(uploadKiB)
+	cb.sess.eventBus.Publish(eventbus.DataUsage{
+		Experiment:        cb.exp.testName,
+		KibiBytesReceived: dloadKiB,
+		KibiBytesSent:     uploadKiB,
+	})
 	cb.inner.OnDataUsage(dloadKiB, uploadKiB)
 }
 
 func (cb *sessionExperimentCallbacks) OnProgress(percentage float64, message string) {
+	cb.sess.eventBus.Publish(eventbus.Progress{
+		Experiment: cb.exp.testName,
+		Message:    message,
+		Percentage: percentage,
+	})
 	cb.inner.OnProgress(percentage, message)
 }
 
 // SaveMeasurement saves a measurement on the specified file path.
 func (e *Experiment) SaveMeasurement(measurement *model.Measurement, filePath string) error {
 	return e.saveMeasurement(
-		measurement, filePath, json.Marshal, os.OpenFile,
-		func(fp *os.File, b []byte) (int, error) {
-			return fp.Write(b)
-		},
-	)
+		measurement, filePath, (*model.Measurement).WriteJSON, os.OpenFile)
 }
 
 // SubmitAndUpdateMeasurement submits a measurement and updates the
-// fields whose value has changed as part of the submission.
+// fields whose value has changed as part of the submission. In dry-run
+// mode, we append the measurement to the dry-run file instead.
 func (e *Experiment) SubmitAndUpdateMeasurement(measurement *model.Measurement) error {
 	if e.report == nil {
 		return errors.New("Report is not open")
 	}
-	return e.report.SubmitMeasurement(context.Background(), measurement)
+	ctx := e.session.withTracer(context.Background())
+	if err := e.onSubmit(ctx, measurement); err != nil {
+		return err
+	}
+	if e.session.DryRun() {
+		err := e.submitDryRun(measurement)
+		e.session.runSummary.submitted(e.testName, err)
+		return err
+	}
+	err := e.submitConverted(ctx, measurement, e.report.SubmitMeasurement)
+	if err != nil {
+		e.session.submissionsFailed.Add(1)
+	}
+	e.session.runSummary.submitted(e.testName, err)
+	e.recordSubmission(measurement, err)
+	e.observeSubmission(err)
+	return err
+}
+
+// SubmitOrQueueMeasurement is like SubmitAndUpdateMeasurement except that,
+// if we cannot reach the collector, we queue the measurement and return
+// probeservices.ErrMeasurementQueued. Use the session's FlushQueuedMeasurements
+// method to submit the queued measurements later.
+func (e *Experiment) SubmitOrQueueMeasurement(measurement *model.Measurement) error {
+	if e.report == nil {
+		return errors.New("Report is not open")
+	}
+	ctx := e.session.withTracer(context.Background())
+	if err := e.onSubmit(ctx, measurement); err != nil {
+		return err
+	}
+	if e.session.DryRun() {
+		err := e.submitDryRun(measurement)
+		e.session.runSummary.submitted(e.testName, err)
+		return err
+	}
+	err := e.submitConverted(ctx, measurement, func(
+		ctx context.Context, m *model.Measurement) error {
+		return e.session.submitter.Submit(ctx, e.report, m)
+	})
+	if err != nil {
+		e.session.submissionsFailed.Add(1)
+	}
+	e.session.runSummary.submitted(e.testName, err)
+	e.recordSubmission(measurement, err)
+	e.observeSubmission(err)
+	return err
 }
 
 // CloseReport is an idempotent method that closes an open report
 // if one has previously been opened, otherwise it does nothing.
 func (e *Experiment) CloseReport() (err error) {
-	if e.report != nil {
+	if e.report != nil && !e.session.DryRun() {
 		err = e.report.Close(context.Background())
-		e.report = nil
 	}
+	e.report = nil
 	return
 }
 
+// submitDryRun appends measurement to the dry-run file.
+func (e *Experiment) submitDryRun(measurement *model.Measurement) error {
+	measurement.ReportID = e.report.ID
+	return e.SaveMeasurement(measurement, e.session.dryRunFile)
+}
+
+// submitConverted submits measurement using submit. The collector only
+// knows about probeservices.DefaultDataFormatVersion, hence we submit a
+// copy converted back to such a version when the session emits another
+// data format version. Then, we update the fields that the submission
+// changes, so that measurement keeps the session's data format version.
+func (e *Experiment) submitConverted(ctx context.Context, measurement *model.Measurement,
+	submit func(context.Context, *model.Measurement) error) error {
+	if measurement.DataFormatVersion == probeservices.DefaultDataFormatVersion {
+		return submit(ctx, measurement)
+	}
+	converted := *measurement
+	if err := dataformat.Convert(&converted, probeservices.DefaultDataFormatVersion); err != nil {
+		return err
+	}
+	err := submit(ctx, &converted)
+	measurement.ReportID, measurement.OOID = converted.ReportID, converted.OOID
+	return err
+}
+
 func (e *Experiment) newMeasurement(input string) *model.Measurement {
 	utctimenow := time.Now().UTC()
 	m := model.Measurement{
-		DataFormatVersion:         probeservices.DefaultDataFormatVersion,
+		DataFormatVersion:         dataformat.Version020, // converted after measuring
 		Input:                     model.MeasurementTarget(input),
 		MeasurementStartTime:      utctimenow.Format(dateFormat),
 		MeasurementStartTimeSaved: utctimenow,
 		ProbeIP:                   e.session.ProbeIP(),
+		ProbeIPv4:                 e.session.ProbeIPv4(),
+		ProbeIPv6:                 e.session.ProbeIPv6(),
 		ProbeASN:                  e.session.ProbeASNString(),
 		ProbeCC:                   e.session.ProbeCC(),
 		ProbeNetworkName:          e.session.ProbeNetworkName(),
@@ -366,16 +719,32 @@ func (e *Experiment) newMeasurement(input string) *model.Measurement {
 		ResolverASN:               e.session.ResolverASNString(),
 		ResolverIP:                e.session.ResolverIP(),
 		ResolverNetworkName:       e.session.ResolverNetworkName(),
+		ResolverNetworkType:       e.session.ResolverNetworkType(),
 		SoftwareName:              e.session.SoftwareName(),
 		SoftwareVersion:           e.session.SoftwareVersion(),
 		TestName:                  e.testName,
 		TestStartTime:             e.testStartTime,
 		TestVersion:               e.testVersion,
 	}
+	m.AddAnnotations(e.session.annotations)
+	m.AddAnnotations(e.annotations)
 	m.AddAnnotation("assets_version", strconv.FormatInt(resources.Version, 10))
 	m.AddAnnotation("engine_name", "ooniprobe-engine")
 	m.AddAnnotation("engine_version", Version)
+	if e.session.ProbeIP() != model.DefaultProbeIP {
+		// Experiments that know which address they used may override this.
+		m.AddIPFamilyAnnotation(e.session.ProbeIP())
+	}
+	if natType := e.session.NATType(); natType != geolocate.NATTypeUnknown {
+		m.AddAnnotation("nat_type", natType)
+	}
 	m.AddAnnotation("platform", platform.Name())
+	if e.session.BehindCaptivePortal() {
+		m.AddAnnotation("captive_portal", "true")
+	}
+	if date := e.session.FallbackDatabaseDate(); date != "" {
+		m.AddAnnotation("geoip_fallback_database", date)
+	}
 	return &m
 }
 
@@ -383,6 +752,12 @@ func (e *Experiment) openReport(ctx context.Context) error {
 	if e.report != nil {
 		return nil // already open
 	}
+	ctx = e.session.withTracer(ctx)
+	if e.session.DryRun() {
+		e.report = &probeservices.Report{ID: e.newDryRunReportID()}
+		e.logger().Infof("experiment: dry-run mode: saving into %s", e.session.dryRunFile)
+		return nil
+	}
 	// use custom client to have proper byte accounting
 	httpClient := &http.Client{
 		Transport: &httptransport.ByteCountingTransport{
@@ -393,14 +768,19 @@ func (e *Experiment) openReport(ctx context.Context) error {
 	if e.session.selectedProbeService == nil {
 		return errors.New("no probe services selected")
 	}
-	client, err := probeservices.NewClient(e.session, *e.session.selectedProbeService)
+	client, err := e.session.newSelectedProbeServicesClient()
 	if err != nil {
-		e.session.logger.Debugf("%+v", err)
+		e.logger().Debugf("%+v", err)
 		return err
 	}
+	client = withBaseURL(client, e.session.collectorURL())
 	client.HTTPClient = httpClient // patch HTTP client to use
+	client.Compression = e.session.uploadCompression
+	if cb, ok := e.callbacks.(model.ExperimentUploadCallbacks); ok {
+		client.UploadProgress = cb.OnUploadProgress
+	}
 	template := probeservices.ReportTemplate{
-		DataFormatVersion: probeservices.DefaultDataFormatVersion,
+		DataFormatVersion: probeservices.DefaultDataFormatVersion, // see submitConverted
 		Format:            probeservices.DefaultFormat,
 		ProbeASN:          e.session.ProbeASNString(),
 		ProbeCC:           e.session.ProbeCC(),
@@ -409,36 +789,56 @@ func (e *Experiment) openReport(ctx context.Context) error {
 		TestName:          e.testName,
 		TestVersion:       e.testVersion,
 	}
-	e.report, err = client.OpenReport(ctx, template)
+	// Using OpenOrResumeReport allows us to continue appending to the
+	// same report if we have been restarted while running.
+	e.report, err = client.OpenOrResumeReport(ctx, template)
 	if err != nil {
-		e.session.logger.Debugf("experiment: probe services error: %s", err.Error())
+		e.logger().Debugf("experiment: probe services error: %s", err.Error())
 		return err
 	}
 	return nil
 }
 
+// DryRunReportIDPrefix is the prefix of the fake report IDs that we
+// use in dry-run mode. Real report IDs start with a timestamp, hence a
+// fake report ID cannot be mistaken for a real one.
+const DryRunReportIDPrefix = "DRYRUN_"
+
+func (e *Experiment) newDryRunReportID() string {
+	return fmt.Sprintf("%s%s_%s_%s", DryRunReportIDPrefix,
+		time.Now().UTC().Format("20060102T150405Z"),
+		e.session.ProbeASNString(), e.testName)
+}
+
 func (e *Experiment) saveMeasurement(
 	measurement *model.Measurement, filePath string,
-	marshal func(v interface{}) ([]byte, error),
+	writeJSON func(m *model.Measurement, w io.Writer) error,
 	openFile func(name string, flag int, perm os.FileMode) (*os.File, error),
-	write func(fp *os.File, b []byte) (n int, err error),
 ) error {
-	data, err := marshal(measurement)
-	if err != nil {
-		return err
-	}
-	data = append(data, byte('\n'))
 	filep, err := openFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
-	if _, err := write(filep, data); err != nil {
+	if err := writeJSON(measurement, filep); err != nil {
+		filep.Close()
 		return err
 	}
 	return filep.Close()
 }
 
 var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
+	"cdn_fronting": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, cdnfronting.NewExperimentMeasurer(
+					*config.(*cdnfronting.Config),
+				))
+			},
+			config:      &cdnfronting.Config{},
+			inputPolicy: InputNone,
+		}
+	},
+
 	"dash": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -452,6 +852,66 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"dns_consistency": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, dnsconsistency.NewExperimentMeasurer(
+					*config.(*dnsconsistency.Config),
+				))
+			},
+			config:      &dnsconsistency.Config{},
+			inputPolicy: InputRequired,
+		}
+	},
+
+	"dns_traceroute": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, dnstraceroute.NewExperimentMeasurer(
+					*config.(*dnstraceroute.Config),
+				))
+			},
+			config:      &dnstraceroute.Config{},
+			inputPolicy: InputRequired,
+		}
+	},
+
+	"dnscheck": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, dnscheck.NewExperimentMeasurer(
+					*config.(*dnscheck.Config),
+				))
+			},
+			config:      &dnscheck.Config{},
+			inputPolicy: InputRequired,
+		}
+	},
+
+	"ech_blocking": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, echblocking.NewExperimentMeasurer(
+					*config.(*echblocking.Config),
+				))
+			},
+			config:      &echblocking.Config{},
+			inputPolicy: InputOptional,
+		}
+	},
+
+	"email_blocking": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, emailblocking.NewExperimentMeasurer(
+					*config.(*emailblocking.Config),
+				))
+			},
+			config:      &emailblocking.Config{},
+			inputPolicy: InputNone,
+		}
+	},
+
 	"example": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -545,6 +1005,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"http_header_manipulation": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, hhmd.NewExperimentMeasurer(
+					*config.(*hhmd.Config),
+				))
+			},
+			config:      &hhmd.Config{},
+			inputPolicy: InputNone,
+		}
+	},
+
 	"http_invalid_request_line": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -570,6 +1042,30 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"ntp_reachability": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, ntpreachability.NewExperimentMeasurer(
+					*config.(*ntpreachability.Config),
+				))
+			},
+			config:      &ntpreachability.Config{},
+			inputPolicy: InputOptional,
+		}
+	},
+
+	"port_scan": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, portscan.NewExperimentMeasurer(
+					*config.(*portscan.Config),
+				))
+			},
+			config:      &portscan.Config{},
+			inputPolicy: InputRequired,
+		}
+	},
+
 	"psiphon": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -582,6 +1078,30 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"quicping": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, quicping.NewExperimentMeasurer(
+					*config.(*quicping.Config),
+				))
+			},
+			config:      &quicping.Config{},
+			inputPolicy: InputRequired,
+		}
+	},
+
+	"signal": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, signal.NewExperimentMeasurer(
+					*config.(*signal.Config),
+				))
+			},
+			config:      &signal.Config{},
+			inputPolicy: InputNone,
+		}
+	},
+
 	"sni_blocking": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -603,8 +1123,21 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 					*config.(*stunreachability.Config),
 				))
 			},
-			config:      &stunreachability.Config{},
-			inputPolicy: InputOptional,
+			config:        &stunreachability.Config{},
+			defaultInputs: stunreachability.DefaultInputs,
+			inputPolicy:   InputOptional,
+		}
+	},
+
+	"tcpping": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, tcpping.NewExperimentMeasurer(
+					*config.(*tcpping.Config),
+				))
+			},
+			config:      &tcpping.Config{},
+			inputPolicy: InputRequired,
 		}
 	},
 
@@ -632,6 +1165,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"torsf": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, torsf.NewExperimentMeasurer(
+					*config.(*torsf.Config),
+				))
+			},
+			config:      &torsf.Config{},
+			inputPolicy: InputNone,
+		}
+	},
+
 	"urlgetter": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -644,6 +1189,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"vpn_handshake": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, vpnhandshake.NewExperimentMeasurer(
+					*config.(*vpnhandshake.Config),
+				))
+			},
+			config:      &vpnhandshake.Config{},
+			inputPolicy: InputRequired,
+		}
+	},
+
 	"web_connectivity": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -656,6 +1213,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"web_connectivity_h3": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, webconnectivityh3.NewExperimentMeasurer(
+					*config.(*webconnectivityh3.Config),
+				))
+			},
+			config:      &webconnectivityh3.Config{},
+			inputPolicy: InputRequired,
+		}
+	},
+
 	"whatsapp": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -671,6 +1240,8 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 
 // AllExperiments returns the name of all experiments
 func AllExperiments() []string {
+	experimentsMu.RLock()
+	defer experimentsMu.RUnlock()
 	var names []string
 	for key := range experimentsByName {
 		names = append(names, key)
@@ -0,0 +1,243 @@
+// Package cdnfronting contains the cdn_fronting experiment.
+//
+// This experiment connects to the edges of major CDNs and performs TLS
+// handshakes using several front domains as SNI. The result is a matrix
+// telling which fronts are reachable through which CDN, which is useful
+// to configure domain fronted services that work in the probe network.
+//
+// For each CDN, we resolve the edge domain once and use the first address
+// for all its fronts, so that every handshake reaches the same edge. We
+// verify the certificate against the front, because a front is only useful
+// when the edge is able to serve a valid certificate for it.
+package cdnfronting
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "cdn_fronting"
+	testVersion = "0.1.0"
+)
+
+// CDN describes a CDN and the fronts we want to test with it. The
+// Edge is a domain, with optional port, served by the CDN.
+type CDN struct {
+	Edge   string
+	Fronts []string
+	Name   string
+}
+
+// DefaultCDNs contains the CDNs and the fronts we test by default.
+var DefaultCDNs = []CDN{{
+	Edge:   "www.cloudflare.com",
+	Fronts: []string{"www.cloudflare.com", "cdnjs.cloudflare.com", "developers.cloudflare.com"},
+	Name:   "cloudflare",
+}, {
+	Edge:   "www.fastly.com",
+	Fronts: []string{"www.fastly.com", "pypi.org", "www.python.org"},
+	Name:   "fastly",
+}, {
+	Edge:   "a248.e.akamai.net",
+	Fronts: []string{"a248.e.akamai.net", "www.akamai.com"},
+	Name:   "akamai",
+}, {
+	Edge:   "aws.amazon.com",
+	Fronts: []string{"aws.amazon.com", "d1.awsstatic.com"},
+	Name:   "cloudfront",
+}}
+
+// Config contains the experiment config.
+type Config struct {
+	cdns    []CDN
+	rootCAs *x509.CertPool
+}
+
+// FrontResult contains the result of testing a front with a CDN.
+type FrontResult struct {
+	Address         string  `json:"address"`
+	CDN             string  `json:"cdn"`
+	Edge            string  `json:"edge"`
+	FailedOperation *string `json:"failed_operation"`
+	Failure         *string `json:"failure"`
+	SNI             string  `json:"sni"`
+}
+
+// TestKeys contains the experiment's result. ReachableFronts maps
+// each CDN to the fronts we could successfully use with it.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Matrix          []FrontResult              `json:"matrix"`
+	NetworkEvents   []archival.NetworkEvent    `json:"network_events"`
+	Queries         []archival.DNSQueryEntry   `json:"queries"`
+	ReachableFronts map[string][]string        `json:"reachable_fronts"`
+	TCPConnect      []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes   []archival.TLSHandshake    `json:"tls_handshakes"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when we cannot use any front with some CDN.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok {
+		return false
+	}
+	for _, result := range tk.Matrix {
+		if len(tk.ReachableFronts[result.CDN]) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	tk.ReachableFronts = make(map[string][]string)
+	cdns := m.config.cdns
+	if len(cdns) <= 0 {
+		cdns = DefaultCDNs
+	}
+	rootCAs := m.config.rootCAs
+	if rootCAs == nil {
+		rootCAs = netx.CertPool
+	}
+	saver := new(trace.Saver)
+	begin := time.Now()
+	defer func() {
+		events := saver.Read()
+		tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+		tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+		tk.TCPConnect = archival.NewTCPConnectList(begin, events)
+		tk.TLSHandshakes = archival.NewTLSHandshakesList(begin, events)
+	}()
+	config := netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+	}
+	var handshaker dialer.TLSHandshaker = dialer.SystemTLSHandshaker{}
+	handshaker = dialer.TimeoutTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.ErrorWrapperTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.LoggingTLSHandshaker{Logger: sess.Logger(), TLSHandshaker: handshaker}
+	handshaker = dialer.SaverTLSHandshaker{TLSHandshaker: handshaker, Saver: saver}
+	p := prober{
+		dialer:     netx.NewDialer(config),
+		handshaker: handshaker,
+		resolver:   netx.NewResolver(config),
+		rootCAs:    rootCAs,
+	}
+	for idx, cdn := range cdns {
+		for _, result := range p.probe(ctx, cdn) {
+			tk.Matrix = append(tk.Matrix, result)
+			if result.Failure == nil {
+				tk.ReachableFronts[cdn.Name] = append(tk.ReachableFronts[cdn.Name], result.SNI)
+			}
+		}
+		callbacks.OnProgress(float64(idx+1)/float64(len(cdns)), fmt.Sprintf(
+			"cdn_fronting: %s: %d/%d reachable fronts", cdn.Name,
+			len(tk.ReachableFronts[cdn.Name]), len(cdn.Fronts)))
+	}
+	return nil
+}
+
+type prober struct {
+	dialer     dialer.Dialer
+	handshaker dialer.TLSHandshaker
+	resolver   netx.Resolver
+	rootCAs    *x509.CertPool
+}
+
+// probe tests all the fronts of cdn. When we cannot resolve the edge
+// domain, all the fronts fail with the resolve failure.
+func (p prober) probe(ctx context.Context, cdn CDN) (out []FrontResult) {
+	host, port, err := net.SplitHostPort(cdn.Edge)
+	if err != nil {
+		host, port = cdn.Edge, "443"
+	}
+	addrs, err := p.resolver.LookupHost(ctx, host)
+	var address string
+	if err == nil {
+		address = net.JoinHostPort(addrs[0], port)
+	}
+	for _, front := range cdn.Fronts {
+		failure := err
+		if failure == nil {
+			failure = p.handshake(ctx, address, front)
+		}
+		out = append(out, FrontResult{
+			Address:         address,
+			CDN:             cdn.Name,
+			Edge:            cdn.Edge,
+			FailedOperation: archival.NewFailedOperation(failure),
+			Failure:         archival.NewFailure(failure),
+			SNI:             front,
+		})
+	}
+	return
+}
+
+func (p prober) handshake(ctx context.Context, address, front string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := p.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tlsconn, _, err := p.handshaker.Handshake(ctx, conn, &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		RootCAs:    p.rootCAs,
+		ServerName: front,
+	})
+	if err != nil {
+		return err
+	}
+	tlsconn.Close()
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
@@ -0,0 +1,11 @@
+package cdnfronting
+
+import "crypto/x509"
+
+func (c *Config) SetCDNs(cdns []CDN) {
+	c.cdns = cdns
+}
+
+func (c *Config) SetRootCAs(pool *x509.CertPool) {
+	c.rootCAs = pool
+}
@@ -0,0 +1,120 @@
+package cdnfronting_test
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/cdnfronting"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := cdnfronting.NewExperimentMeasurer(cdnfronting.Config{})
+	if measurer.ExperimentName() != "cdn_fronting" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func TestDefaultCDNs(t *testing.T) {
+	names := make(map[string]bool)
+	for _, cdn := range cdnfronting.DefaultCDNs {
+		if cdn.Edge == "" || len(cdn.Fronts) <= 0 {
+			t.Fatal("invalid CDN", cdn.Name)
+		}
+		names[cdn.Name] = true
+	}
+	for _, name := range []string{"akamai", "cloudflare", "cloudfront", "fastly"} {
+		if !names[name] {
+			t.Fatal("missing CDN", name)
+		}
+	}
+}
+
+func TestWithLocalServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close() // nobody is listening anymore
+	config := cdnfronting.Config{}
+	config.SetRootCAs(pool)
+	config.SetCDNs([]cdnfronting.CDN{{
+		Edge:   strings.TrimPrefix(server.URL, "https://"),
+		Fronts: []string{"example.com", "www.antani.org"},
+		Name:   "local",
+	}, {
+		Edge:   closed,
+		Fronts: []string{"example.com"},
+		Name:   "closed",
+	}})
+	measurer := cdnfronting.NewExperimentMeasurer(config)
+	measurement := new(model.Measurement)
+	err = measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*cdnfronting.TestKeys)
+	if len(tk.Matrix) != 3 {
+		t.Fatal("unexpected matrix size")
+	}
+	if tk.Matrix[0].Failure != nil {
+		t.Fatal(*tk.Matrix[0].Failure)
+	}
+	if *tk.Matrix[1].Failure != "ssl_invalid_hostname" {
+		t.Fatal("not the failure we expected", *tk.Matrix[1].Failure)
+	}
+	if *tk.Matrix[1].FailedOperation != "tls_handshake" {
+		t.Fatal("not the failed operation we expected")
+	}
+	if *tk.Matrix[2].Failure != "connection_refused" {
+		t.Fatal("not the failure we expected", *tk.Matrix[2].Failure)
+	}
+	fronts := tk.ReachableFronts["local"]
+	if len(fronts) != 1 || fronts[0] != "example.com" {
+		t.Fatal("unexpected reachable fronts")
+	}
+	if len(tk.ReachableFronts["closed"]) != 0 {
+		t.Fatal("unexpected reachable fronts")
+	}
+	if len(tk.TCPConnect) != 3 || len(tk.TLSHandshakes) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestIsAnomaly(t *testing.T) {
+	measurer := cdnfronting.NewExperimentMeasurer(cdnfronting.Config{})
+	failure := "generic_timeout_error"
+	measurement := &model.Measurement{TestKeys: &cdnfronting.TestKeys{
+		Matrix: []cdnfronting.FrontResult{
+			{CDN: "fastly", SNI: "pypi.org"},
+			{CDN: "fastly", SNI: "www.python.org", Failure: &failure},
+		},
+		ReachableFronts: map[string][]string{"fastly": {"pypi.org"}},
+	}}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
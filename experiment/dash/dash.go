@@ -31,6 +31,13 @@ const (
 	totalStep      = 15.0
 )
 
+// sdQualityBitrate is the initial bitrate as well as the threshold below
+// which we flag the measurement. According to a comment in MK sources 3000
+// kbit/s was the minimum speed recommended by Netflix for SD quality in 2017.
+//
+// See: <https://help.netflix.com/en/node/306>.
+const sdQualityBitrate = 3000
+
 var (
 	errServerBusy        = errors.New("Server busy; try again later")
 	errHTTPRequestFailed = errors.New("HTTP request failed")
@@ -59,6 +66,7 @@ type ServerInfo struct {
 
 // TestKeys contains the test keys
 type TestKeys struct {
+	model.MeasurementDataUsage
 	BootstrapTime float64         `json:"bootstrap_time,omitempty"`
 	Server        ServerInfo      `json:"server"`
 	Simple        Simple          `json:"simple"`
@@ -137,15 +145,10 @@ func (r runner) loop(ctx context.Context, numIterations int64) error {
 func (r runner) measure(
 	ctx context.Context, fqdn string, negotiateResp negotiateResponse,
 	numIterations int64) error {
-	// Note: according to a comment in MK sources 3000 kbit/s was the
-	// minimum speed recommended by Netflix for SD quality in 2017.
-	//
-	// See: <https://help.netflix.com/en/node/306>.
-	const initialBitrate = 3000
 	current := clientResults{
 		ElapsedTarget: 2,
 		Platform:      runtime.GOOS,
-		Rate:          initialBitrate,
+		Rate:          sdQualityBitrate,
 		RealAddress:   negotiateResp.RealAddress,
 		Version:       magicVersion,
 	}
@@ -259,6 +262,14 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when the median bitrate is not enough for
+// streaming in SD quality.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Failure == nil && tk.Simple.MedianBitrate < sdQualityBitrate
+}
+
 // Run implements model.ExperimentMeasurer.Run.
 func (m Measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
@@ -327,3 +327,16 @@ func TestUnitMeasureWithProxyURL(t *testing.T) {
 		t.Fatal("unexpected SOCKSProxy")
 	}
 }
+
+func TestUnitIsAnomaly(t *testing.T) {
+	detector := NewExperimentMeasurer(Config{}).(model.ExperimentAnomalyDetector)
+	tk := &TestKeys{Simple: Simple{MedianBitrate: sdQualityBitrate}}
+	measurement := &model.Measurement{TestKeys: tk}
+	if detector.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+	tk.Simple.MedianBitrate = sdQualityBitrate - 1
+	if !detector.IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
@@ -0,0 +1,256 @@
+// Package dnscheck contains the dnscheck experiment.
+//
+// This experiment takes in input the URL of a resolver (e.g.,
+// udp://8.8.8.8:53, dot://dns.google:853 or https://dns.google/dns-query),
+// bootstraps it by resolving its domain name using the system resolver,
+// and then uses it to resolve a set of test domains. We record how long
+// bootstrapping took, which lookups failed, and whether the answers look
+// correct, i.e., they do not contain bogons. This experiment is meant
+// to study the blocking of encrypted DNS resolvers. We do not support
+// DNS-over-QUIC (doq://) yet, because netx does not implement it.
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "dnscheck"
+	testVersion = "0.1.0"
+
+	// DefaultDomains contains the domains we query by default.
+	DefaultDomains = "example.org,example.com"
+)
+
+var (
+	// ErrInputRequired indicates that we did not receive any input.
+	ErrInputRequired = errors.New("dnscheck: input required")
+
+	// ErrInvalidURL indicates that the input is not a valid URL.
+	ErrInvalidURL = errors.New("dnscheck: invalid URL")
+
+	// ErrUnsupportedURLScheme indicates that we do not support the input
+	// URL scheme. We support udp, tcp, dot, https, and the doh:// aliases
+	// supported by netx.NewDNSClient.
+	ErrUnsupportedURLScheme = errors.New("dnscheck: unsupported URL scheme")
+
+	// ErrDNSOverQUICNotSupported indicates that the input is a doq:// URL,
+	// which we cannot measure until netx implements DNS-over-QUIC.
+	ErrDNSOverQUICNotSupported = fmt.Errorf("%w: doq is not implemented", ErrUnsupportedURLScheme)
+)
+
+// Config contains the experiment config.
+type Config struct {
+	Domains string `ooni:"Comma separated list of domains to query"`
+}
+
+// Lookup contains the results of resolving a domain.
+type Lookup struct {
+	Addresses []string `json:"addresses"`
+	Correct   bool     `json:"correct"`
+	Domain    string   `json:"domain"`
+	Failure   *string  `json:"failure"`
+	Runtime   float64  `json:"runtime"`
+}
+
+// TestKeys contains the experiment's result.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	BootstrapAddresses []string                 `json:"bootstrap_addresses"`
+	BootstrapFailure   *string                  `json:"bootstrap_failure"`
+	BootstrapTime      float64                  `json:"bootstrap_time"`
+	Failure            *string                  `json:"failure"`
+	Lookups            []Lookup                 `json:"lookups"`
+	NetworkEvents      []archival.NetworkEvent  `json:"network_events"`
+	Queries            []archival.DNSQueryEntry `json:"queries"`
+	ResolverURL        string                   `json:"resolver_url"`
+	TLSHandshakes      []archival.TLSHandshake  `json:"tls_handshakes"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when we cannot bootstrap the resolver or when
+// some lookups fail.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok {
+		return false
+	}
+	if tk.BootstrapFailure != nil {
+		return true
+	}
+	for _, lookup := range tk.Lookups {
+		if lookup.Failure != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	tk.ResolverURL = string(measurement.Input)
+	saver := new(trace.Saver)
+	begin := time.Now()
+	err := tk.run(ctx, m.config, sess, saver, callbacks)
+	events := saver.Read()
+	tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+	tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+	tk.TLSHandshakes = archival.NewTLSHandshakesList(begin, events)
+	if err != nil {
+		s := err.Error()
+		tk.Failure = &s
+		return err
+	}
+	return nil
+}
+
+func (tk *TestKeys) run(
+	ctx context.Context, config Config, sess model.ExperimentSession,
+	saver *trace.Saver, callbacks model.ExperimentCallbacks,
+) error {
+	if tk.ResolverURL == "" {
+		return ErrInputRequired
+	}
+	resolverURL, hostname, err := parseResolverURL(tk.ResolverURL)
+	if err != nil {
+		return err
+	}
+	callbacks.OnProgress(0, fmt.Sprintf("dnscheck: bootstrapping %s...", resolverURL))
+	if err := tk.bootstrap(ctx, sess, saver, hostname); err != nil {
+		return nil // not a fatal error: it's recorded in BootstrapFailure
+	}
+	dnsclient, err := netx.NewDNSClient(netx.Config{
+		ContextByteCounting: true,
+		DNSCache:            map[string][]string{hostname: tk.BootstrapAddresses},
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+		TLSSaver:            saver,
+	}, resolverURL)
+	if err != nil {
+		return err
+	}
+	defer dnsclient.CloseIdleConnections()
+	domains := strings.Split(config.Domains, ",")
+	if config.Domains == "" {
+		domains = strings.Split(DefaultDomains, ",")
+	}
+	reso := resolver.SaverResolver{Resolver: dnsclient, Saver: saver}
+	for idx, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		tk.Lookups = append(tk.Lookups, lookup(ctx, reso, domain))
+		callbacks.OnProgress(float64(idx+1)/float64(len(domains)),
+			fmt.Sprintf("dnscheck: resolved %s", domain))
+	}
+	return nil
+}
+
+// bootstrap resolves hostname using the system resolver.
+func (tk *TestKeys) bootstrap(
+	ctx context.Context, sess model.ExperimentSession, saver *trace.Saver, hostname string,
+) error {
+	if net.ParseIP(hostname) != nil {
+		tk.BootstrapAddresses = []string{hostname}
+		return nil
+	}
+	reso := netx.NewResolver(netx.Config{
+		Logger:       sess.Logger(),
+		ResolveSaver: saver,
+	})
+	start := time.Now()
+	addrs, err := reso.LookupHost(ctx, hostname)
+	tk.BootstrapTime = time.Since(start).Seconds()
+	if err != nil {
+		s := err.Error()
+		tk.BootstrapFailure = &s
+		return err
+	}
+	tk.BootstrapAddresses = addrs
+	return nil
+}
+
+func lookup(ctx context.Context, reso resolver.Resolver, domain string) Lookup {
+	out := Lookup{Domain: domain}
+	start := time.Now()
+	addrs, err := reso.LookupHost(ctx, domain)
+	out.Runtime = time.Since(start).Seconds()
+	if err != nil {
+		s := errorx.SafeErrWrapperBuilder{
+			Error:     err,
+			Operation: errorx.ResolveOperation,
+		}.MaybeBuild().Error()
+		out.Failure = &s
+		return out
+	}
+	out.Addresses = addrs
+	out.Correct = len(addrs) > 0
+	for _, addr := range addrs {
+		out.Correct = out.Correct && !resolver.IsBogon(addr)
+	}
+	return out
+}
+
+// parseResolverURL returns the resolver URL, expanding the doh://
+// aliases (see netx.ParseResolverURL), and the hostname of the resolver.
+func parseResolverURL(input string) (string, string, error) {
+	URL, err := netx.ParseResolverURL(input)
+	if err != nil {
+		return "", "", ErrInvalidURL
+	}
+	switch URL.Scheme {
+	case "doq":
+		return "", "", ErrDNSOverQUICNotSupported
+	case "dot", "https", "tcp", "udp":
+		if URL.Hostname() == "" {
+			return "", "", ErrInvalidURL
+		}
+		return URL.String(), URL.Hostname(), nil
+	default:
+		return "", "", fmt.Errorf("%w: %s", ErrUnsupportedURLScheme, URL.Scheme)
+	}
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
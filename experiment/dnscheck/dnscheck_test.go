@@ -0,0 +1,165 @@
+package dnscheck_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/experiment/dnscheck"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := dnscheck.NewExperimentMeasurer(dnscheck.Config{})
+	if measurer.ExperimentName() != "dnscheck" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func runWithInput(t *testing.T, config dnscheck.Config, input string) (*dnscheck.TestKeys, error) {
+	measurer := dnscheck.NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*dnscheck.TestKeys), err
+}
+
+func TestRunInvalidInputs(t *testing.T) {
+	var tests = []struct {
+		input string
+		err   error
+	}{
+		{input: "", err: dnscheck.ErrInputRequired},
+		{input: "\t", err: dnscheck.ErrInvalidURL},
+		{input: "udp://", err: dnscheck.ErrInvalidURL},
+		{input: "doq://dns.adguard.com:784", err: dnscheck.ErrDNSOverQUICNotSupported},
+		{input: "tor://127.0.0.1:9050", err: dnscheck.ErrUnsupportedURLScheme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tk, err := runWithInput(t, dnscheck.Config{}, tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatal("not the error we expected")
+			}
+			if tk.Failure == nil {
+				t.Fatal("expected a failure here")
+			}
+		})
+	}
+}
+
+func TestRunBootstrapFailure(t *testing.T) {
+	tk, err := runWithInput(t, dnscheck.Config{}, "dot://dns.nonexistent.invalid:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.BootstrapFailure == nil {
+		t.Fatal("expected a bootstrap failure here")
+	}
+	if len(tk.Lookups) != 0 {
+		t.Fatal("we should not have performed any lookups")
+	}
+}
+
+// startFakeServer starts a DNS server answering A queries for
+// good.example with a public address, bad.example with a bogon
+// address, and returning NXDOMAIN otherwise.
+func startFakeServer(t *testing.T) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(
+		func(w dns.ResponseWriter, query *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(query)
+			question := query.Question[0]
+			var address string
+			switch question.Name {
+			case "good.example.":
+				address = "8.8.8.8"
+			case "bad.example.":
+				address = "10.0.0.1"
+			}
+			switch {
+			case address == "":
+				reply.Rcode = dns.RcodeNameError
+			case question.Qtype == dns.TypeA:
+				reply.Answer = append(reply.Answer, &dns.A{
+					Hdr: dns.RR_Header{
+						Name:   question.Name,
+						Rrtype: dns.TypeA,
+						Class:  dns.ClassINET,
+						Ttl:    60,
+					},
+					A: net.ParseIP(address),
+				})
+			}
+			w.WriteMsg(reply)
+		},
+	)}
+	go server.ActivateAndServe()
+	return conn.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func TestRunWithFakeServer(t *testing.T) {
+	address, stop := startFakeServer(t)
+	defer stop()
+	tk, err := runWithInput(t, dnscheck.Config{
+		Domains: "good.example, bad.example,missing.example",
+	}, "udp://"+address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure != nil || tk.BootstrapFailure != nil {
+		t.Fatal("unexpected failure")
+	}
+	if len(tk.BootstrapAddresses) != 1 || tk.BootstrapAddresses[0] != "127.0.0.1" {
+		t.Fatal("unexpected bootstrap addresses")
+	}
+	if len(tk.Lookups) != 3 {
+		t.Fatal("unexpected number of lookups")
+	}
+	if good := tk.Lookups[0]; good.Domain != "good.example" || !good.Correct || good.Failure != nil {
+		t.Fatalf("unexpected good lookup: %+v", good)
+	}
+	if bad := tk.Lookups[1]; bad.Domain != "bad.example" || bad.Correct || bad.Failure != nil {
+		t.Fatalf("unexpected bad lookup: %+v", bad)
+	}
+	if missing := tk.Lookups[2]; missing.Correct || missing.Failure == nil {
+		t.Fatalf("unexpected missing lookup: %+v", missing)
+	}
+	if len(tk.Queries) <= 0 {
+		t.Fatal("no DNS queries?!")
+	}
+}
+
+func TestIsAnomaly(t *testing.T) {
+	detector := dnscheck.NewExperimentMeasurer(
+		dnscheck.Config{}).(model.ExperimentAnomalyDetector)
+	failure := "dns_nxdomain_error"
+	var cases = []struct {
+		tk       *dnscheck.TestKeys
+		expected bool
+	}{
+		{&dnscheck.TestKeys{Lookups: []dnscheck.Lookup{{}}}, false},
+		{&dnscheck.TestKeys{BootstrapFailure: &failure}, true},
+		{&dnscheck.TestKeys{Lookups: []dnscheck.Lookup{{}, {Failure: &failure}}}, true},
+	}
+	for idx, c := range cases {
+		if detector.IsAnomaly(&model.Measurement{TestKeys: c.tk}) != c.expected {
+			t.Fatal("unexpected result for case", idx)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Package dnsconsistency contains the dnsconsistency experiment.
+//
+// This experiment resolves the input domain using the system resolver,
+// which typically is the ISP resolver, and using several encrypted
+// resolvers, which we use as controls, because they are harder to
+// tamper with. Then, we compare the addresses returned by the system
+// resolver with the ones returned by the encrypted resolvers. We say
+// that the results are consistent if they share at least an address or
+// an ASN. We flag the cases where the system resolver returns bogons
+// but the controls do not, the cases where only the system resolver
+// fails, and the cases where the answers are inconsistent.
+package dnsconsistency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/geolocate"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "dns_consistency"
+	testVersion = "0.1.0"
+
+	// DefaultResolvers contains the encrypted resolvers we use by default.
+	DefaultResolvers = "doh://google,doh://cloudflare,dot://dns.quad9.net:853"
+)
+
+// ErrInputRequired indicates that we did not receive any input.
+var ErrInputRequired = errors.New("dnsconsistency: input required")
+
+// Config contains the experiment config.
+type Config struct {
+	Resolvers string `ooni:"Comma separated list of URLs of encrypted resolvers"`
+}
+
+// ResolverResult contains the result of resolving the domain with
+// a specific resolver. The ASNs are empty if we cannot map the
+// addresses to ASNs, e.g., because the ASN database is missing.
+type ResolverResult struct {
+	Addresses   []string `json:"addresses"`
+	ASNs        []uint   `json:"asns"`
+	Failure     *string  `json:"failure"`
+	ResolverURL string   `json:"resolver_url"`
+}
+
+// TestKeys contains the experiment's result.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Controls      []ResolverResult         `json:"controls"`
+	Domain        string                   `json:"domain"`
+	NetworkEvents []archival.NetworkEvent  `json:"network_events"`
+	Queries       []archival.DNSQueryEntry `json:"queries"`
+	Result        string                   `json:"result"`
+	System        ResolverResult           `json:"system"`
+	TLSHandshakes []archival.TLSHandshake  `json:"tls_handshakes"`
+}
+
+const (
+	classAnomalyControlFailure     = "anomaly.control_failure"
+	classConsistent                = "consistent"
+	classInterferenceBogon         = "interference.bogon"
+	classInterferenceInconsistent  = "interference.inconsistent"
+	classInterferenceSystemFailure = "interference.system_failure"
+)
+
+// systemResolverURL is the ResolverURL of the system resolver result.
+const systemResolverURL = "system:///"
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+func (tk *TestKeys) classify() string {
+	var (
+		addresses = make(map[string]bool)
+		asns      = make(map[uint]bool)
+		bogons    bool
+		succeeded bool
+	)
+	for _, control := range tk.Controls {
+		if control.Failure != nil {
+			continue
+		}
+		succeeded = true
+		for _, addr := range control.Addresses {
+			addresses[addr] = true
+			bogons = bogons || resolver.IsBogon(addr)
+		}
+		for _, asn := range control.ASNs {
+			asns[asn] = true
+		}
+	}
+	if !succeeded {
+		return classAnomalyControlFailure
+	}
+	if tk.System.Failure != nil {
+		return classInterferenceSystemFailure
+	}
+	for _, addr := range tk.System.Addresses {
+		if resolver.IsBogon(addr) && !bogons {
+			return classInterferenceBogon
+		}
+	}
+	for _, addr := range tk.System.Addresses {
+		if addresses[addr] {
+			return classConsistent
+		}
+	}
+	for _, asn := range tk.System.ASNs {
+		if asns[asn] {
+			return classConsistent
+		}
+	}
+	return classInterferenceInconsistent
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && strings.HasPrefix(tk.Result, "interference.")
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	tk.Domain = maybeURLToDomain(string(measurement.Input))
+	if tk.Domain == "" {
+		return ErrInputRequired
+	}
+	resolvers := m.config.Resolvers
+	if resolvers == "" {
+		resolvers = DefaultResolvers
+	}
+	saver := new(trace.Saver)
+	config := netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+		TLSSaver:            saver,
+	}
+	begin := time.Now()
+	tk.System = lookup(ctx, sess, netx.NewResolver(config), systemResolverURL, tk.Domain)
+	urls := strings.Split(resolvers, ",")
+	for idx, URL := range urls {
+		URL = strings.TrimSpace(URL)
+		tk.Controls = append(tk.Controls, lookupWithURL(ctx, sess, config, URL, tk.Domain))
+		callbacks.OnProgress(float64(idx+1)/float64(len(urls)), fmt.Sprintf(
+			"dns_consistency: resolved %s using %s", tk.Domain, URL))
+	}
+	events := saver.Read()
+	tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+	tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+	tk.TLSHandshakes = archival.NewTLSHandshakesList(begin, events)
+	tk.Result = tk.classify()
+	sess.Logger().Infof("dns_consistency: result: %s", tk.Result)
+	return nil
+}
+
+func lookupWithURL(
+	ctx context.Context, sess model.ExperimentSession, config netx.Config,
+	URL, domain string,
+) ResolverResult {
+	dnsclient, err := netx.NewDNSClient(config, URL)
+	if err != nil {
+		s := fmt.Sprintf("dnsconsistency: cannot create resolver: %s", err.Error())
+		return ResolverResult{Failure: &s, ResolverURL: URL}
+	}
+	defer dnsclient.CloseIdleConnections()
+	reso := resolver.SaverResolver{Resolver: dnsclient, Saver: config.ResolveSaver}
+	return lookup(ctx, sess, reso, URL, domain)
+}
+
+func lookup(
+	ctx context.Context, sess model.ExperimentSession, reso resolver.Resolver,
+	URL, domain string,
+) ResolverResult {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out := ResolverResult{ResolverURL: URL}
+	addrs, err := reso.LookupHost(ctx, domain)
+	if err != nil {
+		s := errorx.SafeErrWrapperBuilder{
+			Error:     err,
+			Operation: errorx.ResolveOperation,
+		}.MaybeBuild().Error()
+		out.Failure = &s
+		return out
+	}
+	out.Addresses = addrs
+	seen := make(map[uint]bool)
+	for _, addr := range addrs {
+		asn, _, err := geolocate.LookupASN(sess.ASNDatabasePath(), addr)
+		if err != nil || asn == 0 || seen[asn] {
+			continue
+		}
+		seen[asn] = true
+		out.ASNs = append(out.ASNs, asn)
+	}
+	return out
+}
+
+// maybeURLToDomain handles the case where the input is from the test-lists
+// and hence every input is a URL rather than a domain.
+func maybeURLToDomain(input string) string {
+	if parsed, err := url.Parse(input); err == nil && parsed.Hostname() != "" {
+		return parsed.Hostname()
+	}
+	return input
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
@@ -0,0 +1,181 @@
+package dnsconsistency
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "dns_consistency" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func TestTestKeysClassify(t *testing.T) {
+	failure := "dns_nxdomain_error"
+	var inputs = []struct {
+		name     string
+		controls []ResolverResult
+		system   ResolverResult
+		result   string
+	}{{
+		name:     "when all the controls fail",
+		controls: []ResolverResult{{Failure: &failure}},
+		system:   ResolverResult{Addresses: []string{"8.8.8.8"}},
+		result:   classAnomalyControlFailure,
+	}, {
+		name:     "when only the system resolver fails",
+		controls: []ResolverResult{{Failure: &failure}, {Addresses: []string{"8.8.8.8"}}},
+		system:   ResolverResult{Failure: &failure},
+		result:   classInterferenceSystemFailure,
+	}, {
+		name:     "when the system resolver returns bogons",
+		controls: []ResolverResult{{Addresses: []string{"8.8.8.8"}}},
+		system:   ResolverResult{Addresses: []string{"10.0.0.1"}},
+		result:   classInterferenceBogon,
+	}, {
+		name:     "when everyone returns bogons",
+		controls: []ResolverResult{{Addresses: []string{"10.0.0.1"}}},
+		system:   ResolverResult{Addresses: []string{"10.0.0.1"}},
+		result:   classConsistent,
+	}, {
+		name:     "when the addresses overlap",
+		controls: []ResolverResult{{Addresses: []string{"8.8.8.8", "8.8.4.4"}}},
+		system:   ResolverResult{Addresses: []string{"8.8.4.4"}},
+		result:   classConsistent,
+	}, {
+		name:     "when the ASNs overlap",
+		controls: []ResolverResult{{Addresses: []string{"8.8.8.8"}, ASNs: []uint{15169}}},
+		system:   ResolverResult{Addresses: []string{"8.8.4.4"}, ASNs: []uint{15169}},
+		result:   classConsistent,
+	}, {
+		name:     "when nothing overlaps",
+		controls: []ResolverResult{{Addresses: []string{"8.8.8.8"}, ASNs: []uint{15169}}},
+		system:   ResolverResult{Addresses: []string{"1.1.1.1"}, ASNs: []uint{13335}},
+		result:   classInterferenceInconsistent,
+	}}
+	for _, input := range inputs {
+		t.Run(input.name, func(t *testing.T) {
+			tk := &TestKeys{Controls: input.controls, System: input.system}
+			if result := tk.classify(); result != input.result {
+				t.Fatal("unexpected result", result)
+			}
+		})
+	}
+}
+
+func TestMaybeURLToDomain(t *testing.T) {
+	if maybeURLToDomain("https://www.example.com/robots.txt") != "www.example.com" {
+		t.Fatal("cannot convert URL to domain")
+	}
+	if maybeURLToDomain("www.example.com") != "www.example.com" {
+		t.Fatal("cannot handle domain")
+	}
+}
+
+// startFakeServer starts a DNS server resolving localhost to address.
+func startFakeServer(t *testing.T, address string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(
+		func(w dns.ResponseWriter, query *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(query)
+			if question := query.Question[0]; question.Qtype == dns.TypeA {
+				reply.Answer = append(reply.Answer, &dns.A{
+					Hdr: dns.RR_Header{
+						Name:   question.Name,
+						Rrtype: dns.TypeA,
+						Class:  dns.ClassINET,
+						Ttl:    60,
+					},
+					A: net.ParseIP(address),
+				})
+			}
+			w.WriteMsg(reply)
+		},
+	)}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return "udp://" + conn.LocalAddr().String()
+}
+
+func run(ctx context.Context, config Config, input string) (*TestKeys, error) {
+	measurer := NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*TestKeys), err
+}
+
+func TestRunInputRequired(t *testing.T) {
+	_, err := run(context.Background(), Config{}, "")
+	if !errors.Is(err, ErrInputRequired) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestRunConsistent(t *testing.T) {
+	resolverURL := startFakeServer(t, "127.0.0.1")
+	tk, err := run(context.Background(), Config{Resolvers: resolverURL}, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.System.Failure != nil || tk.System.ResolverURL != systemResolverURL {
+		t.Fatal("unexpected system result")
+	}
+	if len(tk.Controls) != 1 || tk.Controls[0].ResolverURL != resolverURL {
+		t.Fatal("unexpected controls")
+	}
+	if tk.Result != classConsistent {
+		t.Fatal("unexpected result", tk.Result)
+	}
+	if len(tk.Queries) <= 0 {
+		t.Fatal("no queries?!")
+	}
+}
+
+func TestRunBogon(t *testing.T) {
+	resolverURL := startFakeServer(t, "93.184.216.34")
+	tk, err := run(context.Background(), Config{Resolvers: resolverURL}, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Result != classInterferenceBogon {
+		t.Fatal("unexpected result", tk.Result)
+	}
+	measurer := NewExperimentMeasurer(Config{})
+	if !measurer.(*Measurer).IsAnomaly(&model.Measurement{TestKeys: tk}) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestRunInvalidResolverURL(t *testing.T) {
+	tk, err := run(context.Background(), Config{Resolvers: "antani://x"}, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Controls[0].Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if tk.Result != classAnomalyControlFailure {
+		t.Fatal("unexpected result", tk.Result)
+	}
+}
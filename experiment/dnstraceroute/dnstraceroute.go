@@ -0,0 +1,271 @@
+// Package dnstraceroute contains the dns_traceroute experiment.
+//
+// This experiment locates the network hop where DNS injection happens. For
+// increasing values of the IP TTL, we send to a DNS server a query for the
+// input domain, which is supposedly censored, and a query for a control
+// domain, which is not. At each TTL, we collect all the replies arriving
+// within a short time window, because an injector and the server may both
+// reply to the same query.
+//
+// The first TTL at which the control query gets a reply tells us how many
+// hops away the server is, since injectors do not reply for the control
+// domain. A reply for the input domain arriving with a smaller TTL could
+// not possibly come from the server, hence it has been injected by a
+// middlebox located at such hop. For best results, the server should be
+// a server we control, or otherwise a well known public resolver.
+package dnstraceroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+const (
+	testName    = "dns_traceroute"
+	testVersion = "0.1.0"
+
+	defaultControlDomain = "example.org"
+	defaultMaxTTL        = 30
+	defaultServer        = "8.8.8.8:53"
+	defaultTimeout       = 2 * time.Second
+)
+
+var (
+	// ErrInputRequired indicates that we did not receive any input.
+	ErrInputRequired = errors.New("dnstraceroute: input required")
+
+	// ErrInvalidServer indicates that the server is not an IP endpoint.
+	ErrInvalidServer = errors.New("dnstraceroute: server is not an IP endpoint")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	ControlDomain string `ooni:"Domain that is not censored, used to find the hop of the server"`
+	MaxTTL        int64  `ooni:"Maximum IP TTL to use"`
+	Server        string `ooni:"IP endpoint of the DNS server to query (e.g. 8.8.8.8:53)"`
+
+	dialWithTTL func(ctx context.Context, address string, ttl int) (net.Conn, error)
+	timeout     time.Duration
+}
+
+// QueryResult contains the result of sending a query with a given TTL.
+// Replies counts all the replies we received for the query and Answers
+// contains the IPv4 addresses included in all such replies. Failure is
+// set when we did not receive any reply containing addresses.
+type QueryResult struct {
+	Answers []string `json:"answers"`
+	Failure *string  `json:"failure"`
+	Replies int64    `json:"replies"`
+}
+
+// Hop contains the results of the queries sent with a given TTL.
+type Hop struct {
+	Control QueryResult `json:"control"`
+	Target  QueryResult `json:"target"`
+	TTL     int64       `json:"ttl"`
+}
+
+// TestKeys contains the experiment's result. Failure is set when we
+// were interrupted before reaching the server. ServerHop is the smallest
+// TTL with which the control query got a reply. InjectionHop is the smallest
+// TTL with which the target query got a reply, if such TTL is smaller than
+// ServerHop, or if we never got a reply for the control query.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	ControlDomain string  `json:"control_domain"`
+	Domain        string  `json:"domain"`
+	Failure       *string `json:"failure"`
+	Hops          []Hop   `json:"hops"`
+	Injected      bool    `json:"injected"`
+	InjectionHop  *int64  `json:"injection_hop"`
+	Server        string  `json:"server"`
+	ServerHop     *int64  `json:"server_hop"`
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when we have detected DNS injection.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Injected
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	tk.Domain = string(measurement.Input)
+	if tk.Domain == "" {
+		return ErrInputRequired
+	}
+	tk.ControlDomain = m.config.ControlDomain
+	if tk.ControlDomain == "" {
+		tk.ControlDomain = defaultControlDomain
+	}
+	tk.Server = m.config.Server
+	if tk.Server == "" {
+		tk.Server = defaultServer
+	}
+	if host, _, err := net.SplitHostPort(tk.Server); err != nil || net.ParseIP(host) == nil {
+		return ErrInvalidServer
+	}
+	maxTTL := m.config.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = defaultMaxTTL
+	}
+	p := prober{
+		dialWithTTL: m.config.dialWithTTL,
+		server:      tk.Server,
+		timeout:     m.config.timeout,
+	}
+	if p.dialWithTTL == nil {
+		p.dialWithTTL = dialWithTTL
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultTimeout
+	}
+	for ttl := int64(1); ttl <= maxTTL && tk.ServerHop == nil; ttl++ {
+		if err := ctx.Err(); err != nil {
+			tk.Failure = newFailure(err)
+			break
+		}
+		hop := p.hop(ctx, int(ttl), tk.Domain, tk.ControlDomain)
+		tk.Hops = append(tk.Hops, hop)
+		if hop.Control.Replies > 0 {
+			tk.ServerHop = &hop.TTL
+		}
+		callbacks.OnProgress(float64(ttl)/float64(maxTTL), fmt.Sprintf(
+			"dnstraceroute: ttl %d: %d replies for %s, %d replies for %s", ttl,
+			hop.Target.Replies, tk.Domain, hop.Control.Replies, tk.ControlDomain))
+	}
+	tk.analyze()
+	return nil
+}
+
+// analyze sets InjectionHop and Injected.
+func (tk *TestKeys) analyze() {
+	for idx := range tk.Hops {
+		hop := &tk.Hops[idx]
+		if hop.Target.Replies <= 0 {
+			continue
+		}
+		if tk.ServerHop == nil || hop.TTL < *tk.ServerHop {
+			tk.InjectionHop = &hop.TTL
+			tk.Injected = true
+		}
+		return
+	}
+}
+
+func newFailure(err error) *string {
+	err = errorx.SafeErrWrapperBuilder{
+		Error:     err,
+		Operation: errorx.ResolveOperation,
+	}.MaybeBuild()
+	s := err.Error()
+	return &s
+}
+
+type prober struct {
+	dialWithTTL func(ctx context.Context, address string, ttl int) (net.Conn, error)
+	server      string
+	timeout     time.Duration
+}
+
+// hop sends the target and the control queries in parallel.
+func (p prober) hop(ctx context.Context, ttl int, domain, controlDomain string) Hop {
+	hop := Hop{TTL: int64(ttl)}
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		hop.Target = p.query(ctx, ttl, domain)
+	}()
+	go func() {
+		defer wg.Done()
+		hop.Control = p.query(ctx, ttl, controlDomain)
+	}()
+	wg.Wait()
+	return hop
+}
+
+// query sends a query for domain using ttl and collects all the
+// replies that we receive before the timeout expires.
+func (p prober) query(ctx context.Context, ttl int, domain string) (out QueryResult) {
+	query, err := resolver.MiekgEncoder{}.Encode(domain, dns.TypeA, false)
+	if err != nil {
+		out.Failure = newFailure(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	conn, err := p.dialWithTTL(ctx, p.server, ttl)
+	if err != nil {
+		out.Failure = newFailure(err)
+		return
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(query); err != nil {
+		out.Failure = newFailure(err)
+		return
+	}
+	var decodeErr error
+	buffer := make([]byte, 1<<17)
+	for {
+		count, err := conn.Read(buffer)
+		if err != nil {
+			if out.Replies <= 0 {
+				decodeErr = err
+			}
+			break
+		}
+		reply := buffer[:count]
+		if count < 2 || reply[0] != query[0] || reply[1] != query[1] {
+			continue // not a reply to our query
+		}
+		out.Replies++
+		addrs, err := resolver.MiekgDecoder{}.Decode(dns.TypeA, reply)
+		if err != nil {
+			decodeErr = err
+			continue
+		}
+		out.Answers = append(out.Answers, addrs...)
+	}
+	if len(out.Answers) <= 0 && decodeErr != nil {
+		out.Failure = newFailure(decodeErr)
+	}
+	return
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
@@ -0,0 +1,16 @@
+package dnstraceroute
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+func (c *Config) SetDialWithTTL(
+	f func(ctx context.Context, address string, ttl int) (net.Conn, error)) {
+	c.dialWithTTL = f
+}
+
+func (c *Config) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
@@ -0,0 +1,231 @@
+package dnstraceroute_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/experiment/dnstraceroute"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := dnstraceroute.NewExperimentMeasurer(dnstraceroute.Config{})
+	if measurer.ExperimentName() != "dns_traceroute" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func run(ctx context.Context, config dnstraceroute.Config, input string) (
+	*model.Measurement, model.ExperimentMeasurer, error) {
+	measurer := dnstraceroute.NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement, measurer, err
+}
+
+// listen starts a DNS server that replies to queries using the addresses
+// returned by answer and ignores queries for which answer returns nil.
+func listen(t *testing.T, answer func(domain string) []string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query := new(dns.Msg)
+			if err := query.Unpack(buffer[:count]); err != nil {
+				continue
+			}
+			addrs := answer(query.Question[0].Name)
+			if addrs == nil {
+				continue
+			}
+			reply := new(dns.Msg)
+			reply.SetReply(query)
+			for _, a := range addrs {
+				reply.Answer = append(reply.Answer, &dns.A{
+					Hdr: dns.RR_Header{
+						Name:   query.Question[0].Name,
+						Rrtype: dns.TypeA,
+						Class:  dns.ClassINET,
+					},
+					A: net.ParseIP(a),
+				})
+			}
+			data, err := reply.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(data, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// newConfig returns a config where queries sent with a TTL are
+// delivered to the server in servers for such TTL, if any.
+func newConfig(servers map[int]string) dnstraceroute.Config {
+	config := dnstraceroute.Config{MaxTTL: 5}
+	config.SetTimeout(200 * time.Millisecond)
+	config.SetDialWithTTL(func(
+		ctx context.Context, address string, ttl int) (net.Conn, error) {
+		if server, found := servers[ttl]; found {
+			address = server
+		}
+		return new(net.Dialer).DialContext(ctx, "udp", address)
+	})
+	return config
+}
+
+func nobody(string) []string {
+	return nil
+}
+
+func injector(domain string) []string {
+	if domain == "www.example.com." {
+		return []string{"10.10.34.35"}
+	}
+	return nil
+}
+
+func server(string) []string {
+	return []string{"93.184.216.34"}
+}
+
+func TestInputErrors(t *testing.T) {
+	_, _, err := run(context.Background(), dnstraceroute.Config{}, "")
+	if !errors.Is(err, dnstraceroute.ErrInputRequired) {
+		t.Fatal("not the error we expected")
+	}
+	config := dnstraceroute.Config{Server: "dns.google:53"}
+	_, _, err = run(context.Background(), config, "www.example.com")
+	if !errors.Is(err, dnstraceroute.ErrInvalidServer) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestInjection(t *testing.T) {
+	config := newConfig(map[int]string{
+		1: listen(t, nobody),
+		2: listen(t, injector),
+		3: listen(t, server),
+	})
+	config.Server = "127.0.0.1:53"
+	measurement, measurer, err := run(context.Background(), config, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*dnstraceroute.TestKeys)
+	if len(tk.Hops) != 3 {
+		t.Fatal("unexpected number of hops")
+	}
+	if *tk.Hops[0].Target.Failure != "generic_timeout_error" {
+		t.Fatal("not the failure we expected")
+	}
+	if tk.Hops[1].Target.Answers[0] != "10.10.34.35" || tk.Hops[1].Control.Replies != 0 {
+		t.Fatal("unexpected second hop")
+	}
+	if tk.ServerHop == nil || *tk.ServerHop != 3 {
+		t.Fatal("unexpected server hop")
+	}
+	if !tk.Injected || tk.InjectionHop == nil || *tk.InjectionHop != 2 {
+		t.Fatal("expected injection at the second hop")
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestNoInjection(t *testing.T) {
+	config := newConfig(map[int]string{
+		1: listen(t, nobody),
+		2: listen(t, server),
+	})
+	config.Server = "127.0.0.1:53"
+	measurement, measurer, err := run(context.Background(), config, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*dnstraceroute.TestKeys)
+	if len(tk.Hops) != 2 || tk.ServerHop == nil || *tk.ServerHop != 2 {
+		t.Fatal("unexpected server hop")
+	}
+	if tk.Injected || tk.InjectionHop != nil {
+		t.Fatal("did not expect injection")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestServerNeverReplies(t *testing.T) {
+	config := newConfig(map[int]string{
+		1: listen(t, injector),
+		2: listen(t, nobody),
+	})
+	config.MaxTTL = 2
+	config.Server = "127.0.0.1:53"
+	measurement, _, err := run(context.Background(), config, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*dnstraceroute.TestKeys)
+	if len(tk.Hops) != 2 || tk.ServerHop != nil {
+		t.Fatal("unexpected server hop")
+	}
+	if !tk.Injected || *tk.InjectionHop != 1 {
+		t.Fatal("expected injection at the first hop")
+	}
+}
+
+func TestWithRealTTL(t *testing.T) {
+	config := dnstraceroute.Config{Server: listen(t, server)}
+	config.SetTimeout(200 * time.Millisecond)
+	measurement, _, err := run(context.Background(), config, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*dnstraceroute.TestKeys)
+	if tk.ServerHop == nil || *tk.ServerHop != 1 {
+		t.Fatal("unexpected server hop")
+	}
+	if tk.Hops[0].Target.Replies != 1 || tk.Hops[0].Target.Failure != nil {
+		t.Fatal("unexpected target result")
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	measurement, _, err := run(ctx, dnstraceroute.Config{}, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*dnstraceroute.TestKeys)
+	if tk.Failure == nil || *tk.Failure != "interrupted" {
+		t.Fatal("not the failure we expected")
+	}
+	if len(tk.Hops) != 0 {
+		t.Fatal("expected no hops")
+	}
+}
@@ -0,0 +1,85 @@
+package dnstraceroute
+
+import (
+	"context"
+	"net"
+
+	"github.com/ooni/probe-engine/netx"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// dialWithTTL creates a UDP connection with address where all the
+// outgoing packets have the given IP TTL (or IPv6 hop limit). We use
+// the netx packet listener, so that we count the bytes we exchange.
+func dialWithTTL(ctx context.Context, address string, ttl int) (net.Conn, error) {
+	remote, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if remote.IP.To4() == nil {
+		network = "udp6"
+	}
+	pl := netx.NewPacketListener(netx.Config{
+		BasePacketListener:  ttlPacketListener{ttl: ttl},
+		ContextByteCounting: true,
+	})
+	pconn, err := pl.ListenPacket(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	return &udpConn{PacketConn: pconn, remote: remote}, nil
+}
+
+// ttlPacketListener creates sockets where all the outgoing packets
+// have the given IP TTL (or IPv6 hop limit).
+type ttlPacketListener struct {
+	ttl int
+}
+
+func (pl ttlPacketListener) ListenPacket(
+	ctx context.Context, network string) (net.PacketConn, error) {
+	pconn, err := new(net.ListenConfig).ListenPacket(ctx, network, ":0")
+	if err != nil {
+		return nil, err
+	}
+	if network == "udp6" {
+		err = ipv6.NewPacketConn(pconn).SetHopLimit(pl.ttl)
+	} else {
+		err = ipv4.NewPacketConn(pconn).SetTTL(pl.ttl)
+	}
+	if err != nil {
+		pconn.Close()
+		return nil, err
+	}
+	return pconn, nil
+}
+
+// udpConn adapts a net.PacketConn to a net.Conn that, like a connected
+// UDP socket, only exchanges packets with the remote address.
+type udpConn struct {
+	net.PacketConn
+	remote *net.UDPAddr
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	for {
+		count, addr, err := c.ReadFrom(b)
+		if err != nil {
+			return 0, err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if ok && udpAddr.IP.Equal(c.remote.IP) && udpAddr.Port == c.remote.Port {
+			return count, nil
+		}
+	}
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.remote)
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
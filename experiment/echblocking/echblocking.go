@@ -0,0 +1,249 @@
+// Package echblocking contains the ECH/ESNI blocking experiment.
+//
+// This experiment performs three TLS handshakes with a host: a control
+// handshake, a handshake whose ClientHello includes a GREASE Encrypted
+// Client Hello (ECH) extension, and a handshake whose ClientHello includes
+// the legacy Encrypted SNI (ESNI) extension. We flag interference when
+// the control handshake succeeds but a handshake advertising ECH or ESNI
+// fails, e.g., because the network resets the connection.
+//
+// We do not implement ECH proper, which needs the server's ECH config
+// and HPKE. Since an on-path observer cannot distinguish a GREASE ECH
+// extension from a real one, GREASE ECH is enough to detect networks
+// blocking ClientHellos merely because they advertise ECH.
+package echblocking
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/trace"
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	testName    = "ech_blocking"
+	testVersion = "0.1.0"
+
+	// DefaultInput is the host we measure by default. Cloudflare
+	// operates it to test ECH deployments.
+	DefaultInput = "crypto.cloudflare.com"
+)
+
+// ErrInvalidInput indicates that the input is not a valid host, endpoint or URL.
+var ErrInvalidInput = errors.New("echblocking: invalid input")
+
+// Config contains the experiment config.
+type Config struct {
+	rootCAs *x509.CertPool
+}
+
+// HandshakeResult contains the result of a TLS handshake.
+type HandshakeResult struct {
+	FailedOperation *string `json:"failed_operation"`
+	Failure         *string `json:"failure"`
+}
+
+// TestKeys contains the experiment's result.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Control       HandshakeResult            `json:"control"`
+	ESNI          HandshakeResult            `json:"esni"`
+	GreaseECH     HandshakeResult            `json:"grease_ech"`
+	NetworkEvents []archival.NetworkEvent    `json:"network_events"`
+	Queries       []archival.DNSQueryEntry   `json:"queries"`
+	Result        string                     `json:"result"`
+	TCPConnect    []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes []archival.TLSHandshake    `json:"tls_handshakes"`
+}
+
+const (
+	classAnomalyControlFailure  = "anomaly.control_failure"
+	classInterferenceECH        = "interference.ech"
+	classInterferenceECHAndESNI = "interference.ech_and_esni"
+	classInterferenceESNI       = "interference.esni"
+	classSuccess                = "success"
+)
+
+func (tk *TestKeys) classify() string {
+	if tk.Control.Failure != nil {
+		return classAnomalyControlFailure
+	}
+	switch ech, esni := tk.GreaseECH.Failure != nil, tk.ESNI.Failure != nil; {
+	case ech && esni:
+		return classInterferenceECHAndESNI
+	case ech:
+		return classInterferenceECH
+	case esni:
+		return classInterferenceESNI
+	default:
+		return classSuccess
+	}
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok {
+		return false
+	}
+	switch tk.Result {
+	case classInterferenceECH, classInterferenceECHAndESNI, classInterferenceESNI:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	input := string(measurement.Input)
+	if input == "" {
+		input = DefaultInput
+	}
+	address, err := parseInput(input)
+	if err != nil {
+		return err
+	}
+	saver := new(trace.Saver)
+	config := netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+	}
+	rootCAs := m.config.rootCAs
+	if rootCAs == nil {
+		rootCAs = netx.CertPool
+	}
+	g := getter{
+		dialer:  netx.NewDialer(config),
+		logger:  sess.Logger(),
+		rootCAs: rootCAs,
+		saver:   saver,
+	}
+	begin := time.Now()
+	variants := []struct {
+		name      string
+		result    *HandshakeResult
+		extension *utls.GenericExtension
+	}{
+		{name: "control", result: &tk.Control},
+		{name: "grease_ech", result: &tk.GreaseECH, extension: newGreaseECHExtension()},
+		{name: "esni", result: &tk.ESNI, extension: newESNIExtension()},
+	}
+	for idx, variant := range variants {
+		err := g.handshake(ctx, address, variant.extension)
+		variant.result.Failure = archival.NewFailure(err)
+		variant.result.FailedOperation = archival.NewFailedOperation(err)
+		callbacks.OnProgress(float64(idx+1)/float64(len(variants)), fmt.Sprintf(
+			"ech_blocking: %s: %+v", variant.name, err))
+	}
+	events := saver.Read()
+	tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+	tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+	tk.TCPConnect = archival.NewTCPConnectList(begin, events)
+	tk.TLSHandshakes = archival.NewTLSHandshakesList(begin, events)
+	tk.Result = tk.classify()
+	sess.Logger().Infof("ech_blocking: result: %s", tk.Result)
+	return nil
+}
+
+type getter struct {
+	dialer  dialer.Dialer
+	logger  model.Logger
+	rootCAs *x509.CertPool
+	saver   *trace.Saver
+}
+
+func (g getter) handshake(
+	ctx context.Context, address string, extension *utls.GenericExtension) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	conn, err := g.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var handshaker dialer.TLSHandshaker = utlsHandshaker{extension: extension}
+	handshaker = dialer.TimeoutTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.ErrorWrapperTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.LoggingTLSHandshaker{Logger: g.logger, TLSHandshaker: handshaker}
+	handshaker = dialer.SaverTLSHandshaker{TLSHandshaker: handshaker, Saver: g.saver}
+	tlsconn, _, err := handshaker.Handshake(ctx, conn, &tls.Config{
+		RootCAs:    g.rootCAs,
+		ServerName: host,
+	})
+	if err != nil {
+		return err
+	}
+	tlsconn.Close()
+	return nil
+}
+
+// parseInput accepts a host, an endpoint, or a URL, and returns
+// the endpoint to connect to. The default port is 443.
+func parseInput(input string) (string, error) {
+	if parsed, err := url.Parse(input); err == nil && parsed.Hostname() != "" {
+		if parsed.Port() != "" {
+			return parsed.Host, nil
+		}
+		return net.JoinHostPort(parsed.Hostname(), "443"), nil
+	}
+	if _, _, err := net.SplitHostPort(input); err == nil {
+		return input, nil
+	}
+	if strings.ContainsAny(input, " /") {
+		return "", ErrInvalidInput
+	}
+	return net.JoinHostPort(input, "443"), nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
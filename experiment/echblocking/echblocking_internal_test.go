@@ -0,0 +1,7 @@
+package echblocking
+
+import "crypto/x509"
+
+func (c *Config) SetRootCAs(pool *x509.CertPool) {
+	c.rootCAs = pool
+}
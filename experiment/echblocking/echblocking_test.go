@@ -0,0 +1,220 @@
+package echblocking_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/echblocking"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := echblocking.NewExperimentMeasurer(echblocking.Config{})
+	if measurer.ExperimentName() != "ech_blocking" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+// extensions returns the extensions in the ClientHello contained by
+// the TLS record. The code assumes the record is well formed.
+func extensions(record []byte) map[uint16]bool {
+	out := make(map[uint16]bool)
+	hello := record[5+4+2+32:] // record header, handshake header, version, random
+	hello = hello[1+int(hello[0]):]
+	hello = hello[2+int(binary.BigEndian.Uint16(hello)):]
+	hello = hello[1+int(hello[0]):]
+	hello = hello[2:]
+	for len(hello) >= 4 {
+		out[binary.BigEndian.Uint16(hello)] = true
+		hello = hello[4+int(binary.BigEndian.Uint16(hello[2:])):]
+	}
+	return out
+}
+
+// startMiddlebox starts a TLS server behind a middlebox that resets the
+// connections whose ClientHello includes any of the blocked extensions.
+func startMiddlebox(t *testing.T, blocked ...uint16) (string, *httptest.Server) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	URL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy(conn.(*net.TCPConn), URL.Host, blocked)
+		}
+	}()
+	return listener.Addr().String(), server
+}
+
+func proxy(conn *net.TCPConn, backend string, blocked []uint16) {
+	defer conn.Close()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(header[3:])))
+	copy(record, header)
+	if _, err := io.ReadFull(conn, record[5:]); err != nil {
+		return
+	}
+	found := extensions(record)
+	for _, id := range blocked {
+		if found[id] {
+			conn.SetLinger(0) // send RST
+			return
+		}
+	}
+	bconn, err := net.Dial("tcp", backend)
+	if err != nil {
+		return
+	}
+	defer bconn.Close()
+	if _, err := bconn.Write(record); err != nil {
+		return
+	}
+	go io.Copy(conn, bconn)
+	io.Copy(bconn, conn)
+}
+
+func run(t *testing.T, input string, server *httptest.Server) (
+	*model.Measurement, model.ExperimentMeasurer, error) {
+	config := echblocking.Config{}
+	if server != nil {
+		config.SetRootCAs(server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs)
+	}
+	measurer := echblocking.NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement, measurer, err
+}
+
+func TestNoBlocking(t *testing.T) {
+	address, server := startMiddlebox(t)
+	measurement, measurer, err := run(t, address, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*echblocking.TestKeys)
+	if tk.Control.Failure != nil || tk.GreaseECH.Failure != nil || tk.ESNI.Failure != nil {
+		t.Fatal("unexpected failure")
+	}
+	if tk.Result != "success" {
+		t.Fatal("unexpected result", tk.Result)
+	}
+	if len(tk.TCPConnect) != 3 || len(tk.TLSHandshakes) != 3 {
+		t.Fatal("unexpected number of events")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestECHBlocking(t *testing.T) {
+	address, server := startMiddlebox(t, 0xfe0d)
+	measurement, measurer, err := run(t, address, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*echblocking.TestKeys)
+	if tk.GreaseECH.Failure == nil || *tk.GreaseECH.Failure != "connection_reset" {
+		t.Fatal("not the failure we expected")
+	}
+	if *tk.GreaseECH.FailedOperation != "tls_handshake" {
+		t.Fatal("not the failed operation we expected")
+	}
+	if tk.Result != "interference.ech" {
+		t.Fatal("unexpected result", tk.Result)
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestESNIBlocking(t *testing.T) {
+	address, server := startMiddlebox(t, 0xffce)
+	measurement, _, err := run(t, address, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*echblocking.TestKeys)
+	if tk.Result != "interference.esni" {
+		t.Fatal("unexpected result", tk.Result)
+	}
+}
+
+func TestECHAndESNIBlocking(t *testing.T) {
+	address, server := startMiddlebox(t, 0xfe0d, 0xffce)
+	measurement, _, err := run(t, address, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*echblocking.TestKeys)
+	if tk.Result != "interference.ech_and_esni" {
+		t.Fatal("unexpected result", tk.Result)
+	}
+}
+
+func TestControlFailure(t *testing.T) {
+	address, _ := startMiddlebox(t)
+	measurement, measurer, err := run(t, address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*echblocking.TestKeys)
+	if tk.Control.Failure == nil || *tk.Control.Failure != "ssl_unknown_authority" {
+		t.Fatal("not the failure we expected")
+	}
+	if tk.Result != "anomaly.control_failure" {
+		t.Fatal("unexpected result", tk.Result)
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	_, _, err := run(t, "antani mascetti", nil)
+	if !errors.Is(err, echblocking.ErrInvalidInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestURLInput(t *testing.T) {
+	address, server := startMiddlebox(t)
+	measurement, _, err := run(t, "https://"+address+"/robots.txt", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*echblocking.TestKeys)
+	if tk.Result != "success" {
+		t.Fatal("unexpected result", tk.Result)
+	}
+}
@@ -0,0 +1,153 @@
+package echblocking
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	// extensionECH is the code point of the encrypted_client_hello extension.
+	extensionECH = 0xfe0d
+
+	// extensionESNI is the code point of the legacy encrypted_server_name extension.
+	extensionESNI = 0xffce
+)
+
+// utlsHandshaker is a dialer.TLSHandshaker using uTLS. It always sends
+// the same ClientHello, optionally including an extra extension.
+type utlsHandshaker struct {
+	extension *utls.GenericExtension
+}
+
+// Handshake implements dialer.TLSHandshaker.Handshake.
+func (h utlsHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	uconn := utls.UClient(conn, &utls.Config{
+		RootCAs:    config.RootCAs,
+		ServerName: config.ServerName,
+	}, utls.HelloCustom)
+	if err := uconn.ApplyPreset(newClientHelloSpec(h.extension)); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	errch := make(chan error, 1)
+	go func() {
+		errch <- uconn.Handshake()
+	}()
+	select {
+	case err := <-errch:
+		if err != nil {
+			return nil, tls.ConnectionState{}, err
+		}
+	case <-ctx.Done():
+		conn.Close() // unblock the background goroutine
+		return nil, tls.ConnectionState{}, ctx.Err()
+	}
+	state := uconn.ConnectionState()
+	return uconn, tls.ConnectionState{
+		CipherSuite:        state.CipherSuite,
+		HandshakeComplete:  state.HandshakeComplete,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		PeerCertificates:   state.PeerCertificates,
+		ServerName:         state.ServerName,
+		Version:            state.Version,
+	}, nil
+}
+
+// newClientHelloSpec returns a TLS 1.3 ClientHello that also includes
+// the specified extension, when it is not nil.
+func newClientHelloSpec(extension *utls.GenericExtension) *utls.ClientHelloSpec {
+	spec := &utls.ClientHelloSpec{
+		CipherSuites: []uint16{
+			utls.TLS_AES_128_GCM_SHA256,
+			utls.TLS_AES_256_GCM_SHA384,
+			utls.TLS_CHACHA20_POLY1305_SHA256,
+			utls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			utls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			utls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			utls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			utls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			utls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CompressionMethods: []byte{0},
+		Extensions: []utls.TLSExtension{
+			&utls.SNIExtension{},
+			&utls.SupportedCurvesExtension{Curves: []utls.CurveID{
+				utls.X25519, utls.CurveP256, utls.CurveP384,
+			}},
+			&utls.SupportedPointsExtension{SupportedPoints: []byte{0}},
+			&utls.SignatureAlgorithmsExtension{
+				SupportedSignatureAlgorithms: []utls.SignatureScheme{
+					utls.ECDSAWithP256AndSHA256,
+					utls.PSSWithSHA256,
+					utls.PKCS1WithSHA256,
+					utls.ECDSAWithP384AndSHA384,
+					utls.PSSWithSHA384,
+					utls.PKCS1WithSHA384,
+					utls.PSSWithSHA512,
+					utls.PKCS1WithSHA512,
+				},
+			},
+			&utls.ALPNExtension{AlpnProtocols: []string{"http/1.1"}},
+			&utls.KeyShareExtension{KeyShares: []utls.KeyShare{{Group: utls.X25519}}},
+			&utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}},
+			&utls.SupportedVersionsExtension{Versions: []uint16{
+				utls.VersionTLS13, utls.VersionTLS12,
+			}},
+		},
+	}
+	if extension != nil {
+		spec.Extensions = append(spec.Extensions, extension)
+	}
+	return spec
+}
+
+// newGreaseECHExtension returns a GREASE ECH extension. To an observer
+// that does not have the server's private key, this extension looks
+// exactly like a real ECH extension. The format is that of an outer
+// ECHClientHello: type, HPKE KDF and AEAD, config ID, enc, and payload.
+func newGreaseECHExtension() *utls.GenericExtension {
+	const (
+		outerClientHello = 0
+		kdfHKDFSHA256    = 0x0001
+		aeadAES128GCM    = 0x0001
+		encLength        = 32 // X25519 public key
+		payloadLength    = 239
+	)
+	data := []byte{outerClientHello, 0, kdfHKDFSHA256, 0, aeadAES128GCM}
+	data = append(data, randomBytes(1)...) // config ID
+	data = append(data, 0, encLength)
+	data = append(data, randomBytes(encLength)...)
+	data = append(data, 0, payloadLength)
+	data = append(data, randomBytes(payloadLength)...)
+	return &utls.GenericExtension{Id: extensionECH, Data: data}
+}
+
+// newESNIExtension returns a draft-02 encrypted_server_name extension
+// filled with random data: cipher suite, key share, record digest, and
+// encrypted SNI. This is what censors used to detect ESNI.
+func newESNIExtension() *utls.GenericExtension {
+	const (
+		keyLength    = 32 // X25519 public key
+		digestLength = 32 // SHA256
+		esniLength   = 276
+	)
+	data := []byte{0x13, 0x01} // TLS_AES_128_GCM_SHA256
+	data = append(data, 0x00, 0x1d, 0, keyLength)
+	data = append(data, randomBytes(keyLength)...)
+	data = append(data, 0, digestLength)
+	data = append(data, randomBytes(digestLength)...)
+	data = append(data, esniLength>>8, esniLength&0xff)
+	data = append(data, randomBytes(esniLength)...)
+	return &utls.GenericExtension{Id: extensionESNI, Data: data}
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
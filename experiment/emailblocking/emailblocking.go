@@ -0,0 +1,242 @@
+// Package emailblocking contains the email blocking experiment.
+//
+// This experiment checks whether we can reach the mail servers of major
+// email providers. For each provider we perform a TCP connect and a TLS
+// handshake with its SMTP submission servers (port 465 using implicit
+// TLS and port 587 using STARTTLS) and with its IMAP server (port 993
+// using implicit TLS). Several censored networks block these ports, thus
+// preventing users from accessing third party email services.
+package emailblocking
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "email_blocking"
+	testVersion = "0.1.0"
+
+	// startTLSOperation is the operation name used for STARTTLS failures.
+	startTLSOperation = "starttls"
+)
+
+// Endpoint is a mail server endpoint we measure.
+type Endpoint struct {
+	Address  string
+	Provider string
+	StartTLS bool
+}
+
+// DefaultEndpoints contains the endpoints we measure by default.
+var DefaultEndpoints = []Endpoint{
+	{Address: "smtp.gmail.com:465", Provider: "gmail"},
+	{Address: "smtp.gmail.com:587", Provider: "gmail", StartTLS: true},
+	{Address: "imap.gmail.com:993", Provider: "gmail"},
+	{Address: "smtp.office365.com:587", Provider: "outlook", StartTLS: true},
+	{Address: "outlook.office365.com:993", Provider: "outlook"},
+	{Address: "smtp.mail.yahoo.com:465", Provider: "yahoo"},
+	{Address: "smtp.mail.yahoo.com:587", Provider: "yahoo", StartTLS: true},
+	{Address: "imap.mail.yahoo.com:993", Provider: "yahoo"},
+}
+
+// Config contains the experiment config.
+type Config struct {
+	endpoints []Endpoint
+	tlsConfig *tls.Config
+}
+
+// Target contains the results of measuring an endpoint.
+type Target struct {
+	Address         string  `json:"address"`
+	FailedOperation *string `json:"failed_operation"`
+	Failure         *string `json:"failure"`
+	Port            string  `json:"port"`
+	Provider        string  `json:"provider"`
+	StartTLS        bool    `json:"starttls"`
+}
+
+// TestKeys contains the experiment's result.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	NetworkEvents []archival.NetworkEvent    `json:"network_events"`
+	Queries       []archival.DNSQueryEntry   `json:"queries"`
+	TCPConnect    []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes []archival.TLSHandshake    `json:"tls_handshakes"`
+	Targets       []Target                   `json:"targets"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when we failed to reach any endpoint.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok {
+		return false
+	}
+	for _, target := range tk.Targets {
+		if target.Failure != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	endpoints := m.config.endpoints
+	if len(endpoints) <= 0 {
+		endpoints = DefaultEndpoints
+	}
+	saver := new(trace.Saver)
+	config := netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+	}
+	var handshaker dialer.TLSHandshaker = dialer.SystemTLSHandshaker{}
+	handshaker = dialer.TimeoutTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.ErrorWrapperTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.LoggingTLSHandshaker{Logger: sess.Logger(), TLSHandshaker: handshaker}
+	handshaker = dialer.SaverTLSHandshaker{TLSHandshaker: handshaker, Saver: saver}
+	g := getter{
+		dialer:     netx.NewDialer(config),
+		handshaker: handshaker,
+		tlsConfig:  m.config.tlsConfig,
+	}
+	begin := time.Now()
+	for idx, endpoint := range endpoints {
+		target := g.measure(ctx, endpoint)
+		tk.Targets = append(tk.Targets, target)
+		callbacks.OnProgress(float64(idx+1)/float64(len(endpoints)), fmt.Sprintf(
+			"email_blocking: %s (%s): %s", endpoint.Address, endpoint.Provider,
+			archival.FailureString(target.Failure)))
+	}
+	events := saver.Read()
+	tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+	tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+	tk.TCPConnect = archival.NewTCPConnectList(begin, events)
+	tk.TLSHandshakes = archival.NewTLSHandshakesList(begin, events)
+	return nil
+}
+
+type getter struct {
+	dialer     dialer.Dialer
+	handshaker dialer.TLSHandshaker
+	tlsConfig  *tls.Config
+}
+
+func (g getter) measure(ctx context.Context, endpoint Endpoint) Target {
+	target := Target{
+		Address:  endpoint.Address,
+		Provider: endpoint.Provider,
+		StartTLS: endpoint.StartTLS,
+	}
+	host, port, err := net.SplitHostPort(endpoint.Address)
+	if err != nil {
+		target.Failure = archival.NewFailure(err)
+		return target
+	}
+	target.Port = port
+	if err := g.do(ctx, endpoint, host); err != nil {
+		target.Failure = archival.NewFailure(err)
+		target.FailedOperation = archival.NewFailedOperation(err)
+	}
+	return target
+}
+
+func (g getter) do(ctx context.Context, endpoint Endpoint, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	conn, err := g.dialer.DialContext(ctx, "tcp", endpoint.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if endpoint.StartTLS {
+		if err := startTLS(ctx, conn); err != nil {
+			return errorx.SafeErrWrapperBuilder{
+				Error:     err,
+				Operation: startTLSOperation,
+			}.MaybeBuild()
+		}
+	}
+	config := &tls.Config{RootCAs: netx.CertPool}
+	if g.tlsConfig != nil {
+		config = g.tlsConfig.Clone()
+	}
+	config.ServerName = host
+	tlsconn, _, err := g.handshaker.Handshake(ctx, conn, config)
+	if err != nil {
+		return err
+	}
+	tlsconn.Close()
+	return nil
+}
+
+// startTLS asks the SMTP server to upgrade conn to TLS.
+func startTLS(ctx context.Context, conn net.Conn) error {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+	if err := tp.PrintfLine("EHLO localhost"); err != nil {
+		return err
+	}
+	if _, _, err := tp.ReadResponse(250); err != nil {
+		return err
+	}
+	if err := tp.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+	_, _, err := tp.ReadResponse(220)
+	return err
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
@@ -0,0 +1,11 @@
+package emailblocking
+
+import "crypto/tls"
+
+func (c *Config) SetEndpoints(endpoints []Endpoint) {
+	c.endpoints = endpoints
+}
+
+func (c *Config) SetTLSConfig(config *tls.Config) {
+	c.tlsConfig = config
+}
@@ -0,0 +1,207 @@
+package emailblocking_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/emailblocking"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := emailblocking.NewExperimentMeasurer(emailblocking.Config{})
+	if measurer.ExperimentName() != "email_blocking" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+// startServer starts a fake mail server. If starttls is true, the server
+// speaks enough SMTP to upgrade the connection using STARTTLS, otherwise
+// it immediately performs the TLS handshake. If refuseTLS is true, the
+// server refuses to perform the STARTTLS upgrade.
+func startServer(t *testing.T, starttls, refuseTLS bool) (string, *tls.Config) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, server.TLS, starttls, refuseTLS)
+		}
+	}()
+	clientConfig := &tls.Config{
+		RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+	return listener.Addr().String(), clientConfig
+}
+
+func serve(conn net.Conn, config *tls.Config, starttls, refuseTLS bool) {
+	defer conn.Close()
+	if starttls {
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimSpace(line) {
+			case "EHLO localhost":
+				conn.Write([]byte("250-mail.example.com\r\n250 STARTTLS\r\n"))
+				continue
+			case "STARTTLS":
+				if refuseTLS {
+					conn.Write([]byte("454 TLS not available\r\n"))
+					return
+				}
+				conn.Write([]byte("220 Ready to start TLS\r\n"))
+			default:
+				return
+			}
+			break
+		}
+	}
+	tlsconn := tls.Server(conn, config)
+	tlsconn.Handshake()
+	tlsconn.Close()
+}
+
+func run(t *testing.T, endpoint emailblocking.Endpoint, tlsConfig *tls.Config) (
+	*model.Measurement, model.ExperimentMeasurer) {
+	config := emailblocking.Config{}
+	config.SetEndpoints([]emailblocking.Endpoint{endpoint})
+	config.SetTLSConfig(tlsConfig)
+	measurer := emailblocking.NewExperimentMeasurer(config)
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return measurement, measurer
+}
+
+func TestImplicitTLS(t *testing.T) {
+	address, tlsConfig := startServer(t, false, false)
+	measurement, measurer := run(t, emailblocking.Endpoint{
+		Address: address, Provider: "example",
+	}, tlsConfig)
+	tk := measurement.TestKeys.(*emailblocking.TestKeys)
+	if len(tk.Targets) != 1 {
+		t.Fatal("unexpected number of targets")
+	}
+	target := tk.Targets[0]
+	if target.Failure != nil {
+		t.Fatal(*target.Failure)
+	}
+	if target.Provider != "example" || target.StartTLS || target.Port == "" {
+		t.Fatal("unexpected target fields")
+	}
+	if len(tk.TCPConnect) != 1 || len(tk.TLSHandshakes) != 1 {
+		t.Fatal("unexpected number of events")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	address, tlsConfig := startServer(t, true, false)
+	measurement, _ := run(t, emailblocking.Endpoint{
+		Address: address, Provider: "example", StartTLS: true,
+	}, tlsConfig)
+	tk := measurement.TestKeys.(*emailblocking.TestKeys)
+	if tk.Targets[0].Failure != nil {
+		t.Fatal(*tk.Targets[0].Failure)
+	}
+	if len(tk.NetworkEvents) <= 0 {
+		t.Fatal("no network events?!")
+	}
+}
+
+func TestStartTLSRefused(t *testing.T) {
+	address, tlsConfig := startServer(t, true, true)
+	measurement, measurer := run(t, emailblocking.Endpoint{
+		Address: address, Provider: "example", StartTLS: true,
+	}, tlsConfig)
+	tk := measurement.TestKeys.(*emailblocking.TestKeys)
+	target := tk.Targets[0]
+	if target.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if target.FailedOperation == nil || *target.FailedOperation != "starttls" {
+		t.Fatal("not the failed operation we expected")
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestUnknownAuthority(t *testing.T) {
+	address, _ := startServer(t, false, false)
+	measurement, _ := run(t, emailblocking.Endpoint{
+		Address: address, Provider: "example",
+	}, nil)
+	tk := measurement.TestKeys.(*emailblocking.TestKeys)
+	target := tk.Targets[0]
+	if target.Failure == nil || *target.Failure != "ssl_unknown_authority" {
+		t.Fatal("not the failure we expected")
+	}
+	if *target.FailedOperation != "tls_handshake" {
+		t.Fatal("not the failed operation we expected")
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	measurement, _ := run(t, emailblocking.Endpoint{
+		Address: address, Provider: "example",
+	}, nil)
+	tk := measurement.TestKeys.(*emailblocking.TestKeys)
+	target := tk.Targets[0]
+	if target.Failure == nil || *target.Failure != "connection_refused" {
+		t.Fatal("not the failure we expected")
+	}
+	if *target.FailedOperation != "connect" {
+		t.Fatal("not the failed operation we expected")
+	}
+}
+
+func TestDefaultEndpoints(t *testing.T) {
+	for _, endpoint := range emailblocking.DefaultEndpoints {
+		_, port, err := net.SplitHostPort(endpoint.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint.StartTLS != (port == "587") {
+			t.Fatal("only port 587 should use STARTTLS")
+		}
+	}
+}
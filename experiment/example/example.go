@@ -33,6 +33,7 @@ type Config struct {
 // In other words, the variables in this struct will be
 // the specific results of this experiment.
 type TestKeys struct {
+	model.MeasurementDataUsage
 	Success bool `json:"success"`
 }
 
@@ -69,11 +69,7 @@ type TestKeys struct {
 // Update updates the TestKeys using the given MultiOutput result.
 func (tk *TestKeys) Update(v urlgetter.MultiOutput) {
 	// Update the easy to update entries first
-	tk.NetworkEvents = append(tk.NetworkEvents, v.TestKeys.NetworkEvents...)
-	tk.Queries = append(tk.Queries, v.TestKeys.Queries...)
-	tk.Requests = append(tk.Requests, v.TestKeys.Requests...)
-	tk.TCPConnect = append(tk.TCPConnect, v.TestKeys.TCPConnect...)
-	tk.TLSHandshakes = append(tk.TLSHandshakes, v.TestKeys.TLSHandshakes...)
+	tk.AppendObservations(v.TestKeys)
 	// Set the status of endpoints
 	switch v.Input.Target {
 	case ServiceSTUN:
@@ -155,6 +151,14 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when we see DNS or TCP blocking.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && ((tk.FacebookDNSBlocking != nil && *tk.FacebookDNSBlocking) ||
+		(tk.FacebookTCPBlocking != nil && *tk.FacebookTCPBlocking))
+}
+
 // Run implements ExperimentMeasurer.Run
 func (m Measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
@@ -38,6 +38,7 @@ type Config struct{}
 // Here we are emitting for the same set of test keys that are
 // produced by the MK implementation.
 type TestKeys struct {
+	model.MeasurementDataUsage
 	Agent      string                  `json:"agent"`
 	Failure    *string                 `json:"failure"`
 	Requests   []archival.RequestEntry `json:"requests"`
@@ -85,6 +86,12 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Tampering.Total
+}
+
 var (
 	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
 	ErrNoAvailableTestHelpers = errors.New("no available helpers")
@@ -196,7 +203,11 @@ func transact(txp Transport, req *http.Request,
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, nil, urlgetter.ErrHTTPRequestFailed
+		return nil, nil, errorx.SafeErrWrapperBuilder{
+			Error:          urlgetter.ErrHTTPRequestFailed,
+			HTTPStatusCode: int64(resp.StatusCode),
+			Operation:      errorx.TopLevelOperation,
+		}.MaybeBuild()
 	}
 	callbacks.OnProgress(0.75, "reading response body...")
 	data, err := ioutil.ReadAll(resp.Body)
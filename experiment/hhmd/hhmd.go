@@ -0,0 +1,257 @@
+// Package hhmd contains the HTTP header manipulation detection experiment.
+//
+// This experiment is a refresh of hhfm. Rather than relying on net/http,
+// which normalizes the request, we use a raw HTTP/1.1 transport to write
+// a request whose request line, header names capitalization, and headers
+// order are deliberately unusual. We send such request to the tcp-echo
+// helper, which echoes back the bytes it received. Then, we diff what we
+// sent with what arrived to detect middleboxes manipulating the request,
+// including middleboxes that rewrite or remove the Host header.
+package hhmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/httpheader"
+	"github.com/ooni/probe-engine/internal/randx"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+const (
+	testName    = "http_header_manipulation"
+	testVersion = "0.1.0"
+	timeout     = 5 * time.Second
+)
+
+// Config contains the experiment config.
+type Config struct {
+	Host string `ooni:"Host header to send (default: random domain)"`
+	port string
+}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Failure         *string                   `json:"failure"`
+	Received        archival.MaybeBinaryValue `json:"received"`
+	ReceivedHeaders []archival.HTTPHeader     `json:"received_headers"`
+	Sent            string                    `json:"sent"`
+	SentHeaders     []archival.HTTPHeader     `json:"sent_headers"`
+	Tampering       Tampering                 `json:"tampering"`
+}
+
+// Tampering describes the detected forms of tampering. The fields
+// with the same name of hhfm's fields have the same meaning. Total
+// means that we did not receive our request back.
+type Tampering struct {
+	HeaderFieldName          bool     `json:"header_field_name"`
+	HeaderFieldNumber        bool     `json:"header_field_number"`
+	HeaderFieldOrder         bool     `json:"header_field_order"`
+	HeaderFieldValue         bool     `json:"header_field_value"`
+	HeaderNameCapitalization bool     `json:"header_name_capitalization"`
+	HeaderNameDiff           []string `json:"header_name_diff"`
+	HeadersAdded             []string `json:"headers_added"`
+	HeadersRemoved           []string `json:"headers_removed"`
+	HostHeader               bool     `json:"host_header"`
+	RequestLine              bool     `json:"request_line"`
+	Total                    bool     `json:"total"`
+}
+
+// Any returns whether we detected any form of tampering.
+func (t Tampering) Any() bool {
+	return t.HeaderFieldName || t.HeaderFieldNumber || t.HeaderFieldOrder ||
+		t.HeaderFieldValue || t.HeaderNameCapitalization || t.HostHeader ||
+		t.RequestLine || t.Total
+}
+
+var (
+	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
+	ErrNoAvailableTestHelpers = errors.New("hhmd: no available helpers")
+
+	// ErrInvalidHelperType is emitted when the helper type is invalid.
+	ErrInvalidHelperType = errors.New("hhmd: invalid helper type")
+)
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Tampering.Any()
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	tk.Tampering.HeaderNameDiff = []string{}
+	tk.Tampering.HeadersAdded = []string{}
+	tk.Tampering.HeadersRemoved = []string{}
+	measurement.TestKeys = tk
+	const helperName = "tcp-echo"
+	helpers, ok := sess.GetTestHelpersByName(helperName)
+	if !ok || len(helpers) < 1 {
+		return ErrNoAvailableTestHelpers
+	}
+	helper := helpers[0]
+	if helper.Type != "legacy" {
+		return ErrInvalidHelperType
+	}
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": helper.Address,
+	}
+	host := m.config.Host
+	if host == "" {
+		host = randx.Letters(15) + ".com"
+	}
+	port := m.config.port
+	if port == "" {
+		port = "80"
+	}
+	req := NewRawRequest(host)
+	tk.Sent = req.String()
+	tk.SentHeaders = newHeadersList(req.Headers)
+	txp := RawTransport{
+		Dialer: netx.NewDialer(netx.Config{
+			ContextByteCounting: true,
+			Logger:              sess.Logger(),
+		}),
+		Timeout: timeout,
+	}
+	callbacks.OnProgress(0.25, "sending request...")
+	received, err := txp.RoundTrip(ctx, net.JoinHostPort(helper.Address, port), req)
+	tk.Received = archival.MaybeBinaryValue{Value: received}
+	callbacks.OnProgress(0.75, fmt.Sprintf("got response... %+v", err))
+	if err != nil {
+		tk.Failure = archival.NewFailure(errorx.SafeErrWrapperBuilder{
+			Error: err, Operation: errorx.TopLevelOperation}.MaybeBuild())
+		tk.Tampering.Total = true
+		return nil // measurement did not fail, we measured tampering
+	}
+	if strings.HasPrefix(received, "HTTP/") {
+		// A middlebox intercepted our request and replied itself.
+		tk.Tampering.Total = true
+		return nil
+	}
+	parsed, err := ParseRawRequest(received)
+	if err != nil {
+		tk.Failure = archival.NewFailure(errorx.SafeErrWrapperBuilder{
+			Error: err, Operation: errorx.TopLevelOperation}.MaybeBuild())
+		tk.Tampering.Total = true
+		return nil
+	}
+	tk.ReceivedHeaders = newHeadersList(parsed.Headers)
+	tk.Tampering.Fill(req, parsed)
+	callbacks.OnProgress(1.0, fmt.Sprintf("tampering: %+v", tk.Tampering.Any()))
+	return nil
+}
+
+// NewRawRequest creates the request we send. The request line uses an
+// odd capitalization for the method, each header name has a random
+// capitalization, and the Host header is not the first header.
+func NewRawRequest(host string) RawRequest {
+	return RawRequest{
+		RequestLine: "GeT / HTTP/1.1",
+		Headers: []Header{
+			{Key: randx.ChangeCapitalization("User-Agent"), Value: httpheader.UserAgent()},
+			{Key: randx.ChangeCapitalization("Accept"), Value: httpheader.Accept()},
+			{Key: randx.ChangeCapitalization("Accept-Language"), Value: httpheader.AcceptLanguage()},
+			{Key: randx.ChangeCapitalization("Host"), Value: host},
+			{Key: randx.ChangeCapitalization("Accept-Encoding"), Value: "gzip,deflate,sdch"},
+			{Key: randx.ChangeCapitalization("Accept-Charset"), Value: "ISO-8859-1,utf-8;q=0.7,*;q=0.3"},
+		},
+	}
+}
+
+// Fill fills the tampering structure by comparing the request we
+// sent with the request that the helper has received.
+func (t *Tampering) Fill(sent, received RawRequest) {
+	t.RequestLine = sent.RequestLine != received.RequestLine
+	t.HeaderFieldNumber = len(sent.Headers) != len(received.Headers)
+	sentByKey, sentOrder := indexHeaders(sent.Headers)
+	receivedByKey, receivedOrder := indexHeaders(received.Headers)
+	var sentCommon, receivedCommon []string
+	for _, key := range sentOrder {
+		if _, found := receivedByKey[key]; !found {
+			t.HeadersRemoved = append(t.HeadersRemoved, sentByKey[key].Key)
+			continue
+		}
+		sentCommon = append(sentCommon, key)
+		expected, got := sentByKey[key], receivedByKey[key]
+		if expected.Key != got.Key {
+			t.HeaderNameCapitalization = true
+			t.HeaderNameDiff = append(t.HeaderNameDiff, expected.Key, got.Key)
+		}
+		if expected.Value != got.Value {
+			t.HeaderFieldValue = true
+		}
+	}
+	for _, key := range receivedOrder {
+		if _, found := sentByKey[key]; !found {
+			t.HeadersAdded = append(t.HeadersAdded, receivedByKey[key].Key)
+			continue
+		}
+		receivedCommon = append(receivedCommon, key)
+	}
+	t.HeaderFieldName = len(t.HeadersAdded) > 0 || len(t.HeadersRemoved) > 0
+	t.HeaderFieldOrder = strings.Join(sentCommon, "\n") != strings.Join(receivedCommon, "\n")
+	const hostKey = "Host"
+	expectedHost, gotHost := sentByKey[hostKey], receivedByKey[hostKey]
+	t.HostHeader = expectedHost.Value != gotHost.Value
+}
+
+// indexHeaders maps the canonical header keys to the first header
+// with such key and returns the canonical keys in order.
+func indexHeaders(headers []Header) (map[string]Header, []string) {
+	index := make(map[string]Header)
+	var order []string
+	for _, h := range headers {
+		key := http.CanonicalHeaderKey(h.Key)
+		if _, found := index[key]; found {
+			continue
+		}
+		index[key] = h
+		order = append(order, key)
+	}
+	return index, order
+}
+
+func newHeadersList(headers []Header) []archival.HTTPHeader {
+	out := []archival.HTTPHeader{}
+	for _, h := range headers {
+		out = append(out, archival.HTTPHeader{
+			Key:   h.Key,
+			Value: archival.MaybeBinaryValue{Value: h.Value},
+		})
+	}
+	return out
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
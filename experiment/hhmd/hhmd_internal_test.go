@@ -0,0 +1,5 @@
+package hhmd
+
+func (c *Config) SetPort(port string) {
+	c.port = port
+}
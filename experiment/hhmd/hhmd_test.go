@@ -0,0 +1,220 @@
+package hhmd_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/hhmd"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := hhmd.NewExperimentMeasurer(hhmd.Config{})
+	if measurer.ExperimentName() != "http_header_manipulation" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+// startServer starts a fake tcp-echo helper that reads the request
+// headers and replies with whatever rewrite returns.
+func startServer(t *testing.T, rewrite func(string) string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				var request string
+				for !strings.HasSuffix(request, "\r\n\r\n") {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					request += line
+				}
+				conn.Write([]byte(rewrite(request)))
+			}()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func run(t *testing.T, config hhmd.Config, sess *mockable.ExperimentSession) (
+	*model.Measurement, model.ExperimentMeasurer, error) {
+	measurer := hhmd.NewExperimentMeasurer(config)
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(), sess, measurement, model.NewPrinterCallbacks(log.Log))
+	return measurement, measurer, err
+}
+
+func newsession() *mockable.ExperimentSession {
+	return &mockable.ExperimentSession{
+		MockableLogger: log.Log,
+		MockableTestHelpers: map[string][]model.Service{
+			"tcp-echo": {{Address: "127.0.0.1", Type: "legacy"}},
+		},
+	}
+}
+
+func TestNoHelpers(t *testing.T) {
+	_, _, err := run(t, hhmd.Config{}, &mockable.ExperimentSession{})
+	if !errors.Is(err, hhmd.ErrNoAvailableTestHelpers) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestWrongTestHelperType(t *testing.T) {
+	sess := newsession()
+	sess.MockableTestHelpers["tcp-echo"][0].Type = "antani"
+	_, _, err := run(t, hhmd.Config{}, sess)
+	if !errors.Is(err, hhmd.ErrInvalidHelperType) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestNoTampering(t *testing.T) {
+	config := hhmd.Config{Host: "www.example.com"}
+	config.SetPort(startServer(t, func(s string) string { return s }))
+	measurement, measurer, err := run(t, config, newsession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*hhmd.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Sent != tk.Received.Value {
+		t.Fatal("mismatch between sent and received")
+	}
+	if len(tk.SentHeaders) != len(tk.ReceivedHeaders) {
+		t.Fatal("mismatch between sent and received headers")
+	}
+	if tk.Tampering.Any() {
+		t.Fatalf("unexpected tampering: %+v", tk.Tampering)
+	}
+	if measurement.TestHelpers["backend"] != "127.0.0.1" {
+		t.Fatal("unexpected test helpers")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestMiddleboxRewritingTheRequest(t *testing.T) {
+	config := hhmd.Config{Host: "www.example.com"}
+	config.SetPort(startServer(t, func(s string) string {
+		// behave like a proxy that parses and reserializes the request
+		parsed, err := hhmd.ParseRawRequest(s)
+		if err != nil {
+			return ""
+		}
+		out := hhmd.RawRequest{RequestLine: "GET / HTTP/1.1"}
+		out.Headers = append(out.Headers, hhmd.Header{Key: "Host", Value: "blocked.example.com"})
+		for _, h := range parsed.Headers {
+			key := http.CanonicalHeaderKey(h.Key)
+			if key == "Host" || key == "Accept-Charset" {
+				continue
+			}
+			out.Headers = append(out.Headers, hhmd.Header{Key: key, Value: h.Value})
+		}
+		out.Headers = append(out.Headers, hhmd.Header{Key: "Via", Value: "1.1 squid"})
+		return out.String()
+	}))
+	measurement, measurer, err := run(t, config, newsession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*hhmd.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	tampering := tk.Tampering
+	if !tampering.RequestLine || !tampering.HostHeader || !tampering.HeaderFieldName {
+		t.Fatalf("unexpected tampering: %+v", tampering)
+	}
+	if !tampering.HeaderFieldOrder || tampering.HeaderFieldNumber || tampering.Total {
+		t.Fatalf("unexpected tampering: %+v", tampering)
+	}
+	if len(tampering.HeadersAdded) != 1 || tampering.HeadersAdded[0] != "Via" {
+		t.Fatal("unexpected added headers")
+	}
+	if len(tampering.HeadersRemoved) != 1 ||
+		http.CanonicalHeaderKey(tampering.HeadersRemoved[0]) != "Accept-Charset" {
+		t.Fatal("unexpected removed headers")
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestMiddleboxReplyingItself(t *testing.T) {
+	config := hhmd.Config{}
+	config.SetPort(startServer(t, func(s string) string {
+		return "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n"
+	}))
+	measurement, _, err := run(t, config, newsession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*hhmd.TestKeys)
+	if !tk.Tampering.Total {
+		t.Fatal("expected total tampering here")
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	config := hhmd.Config{}
+	config.SetPort(startServer(t, func(s string) string {
+		return "antani\r\nmascetti\r\n\r\n"
+	}))
+	measurement, _, err := run(t, config, newsession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*hhmd.TestKeys)
+	if tk.Failure == nil || !strings.HasSuffix(*tk.Failure, hhmd.ErrMalformedRequest.Error()) {
+		t.Fatal("not the failure we expected")
+	}
+	if !tk.Tampering.Total {
+		t.Fatal("expected total tampering here")
+	}
+}
+
+func TestConnectionReset(t *testing.T) {
+	config := hhmd.Config{}
+	config.SetPort(startServer(t, func(s string) string { return "" }))
+	measurement, _, err := run(t, config, newsession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*hhmd.TestKeys)
+	if tk.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if !tk.Tampering.Total {
+		t.Fatal("expected total tampering here")
+	}
+}
@@ -0,0 +1,114 @@
+package hhmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+// ErrMalformedRequest indicates that we cannot parse what
+// the echo helper returned as an HTTP/1.1 request.
+var ErrMalformedRequest = errors.New("hhmd: malformed request")
+
+// Header is an HTTP header whose name capitalization is preserved.
+type Header struct {
+	Key   string
+	Value string
+}
+
+// RawRequest is an HTTP/1.1 request that we serialize byte by byte
+// without normalizing the headers. Unlike what net/http does, we keep
+// the capitalization and the order of the headers as they are.
+type RawRequest struct {
+	Headers     []Header
+	RequestLine string
+}
+
+// String serializes the request. The request has no body.
+func (r RawRequest) String() string {
+	var b strings.Builder
+	b.WriteString(r.RequestLine)
+	b.WriteString("\r\n")
+	for _, h := range r.Headers {
+		b.WriteString(h.Key)
+		b.WriteString(": ")
+		b.WriteString(h.Value)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// ParseRawRequest parses the headers part of a request without
+// normalizing it. We are lenient with respect to line endings, because
+// a middlebox could have rewritten them, but we fail if a header
+// does not contain the `:` separator.
+func ParseRawRequest(data string) (RawRequest, error) {
+	var out RawRequest
+	if idx := strings.Index(data, "\r\n\r\n"); idx >= 0 {
+		data = data[:idx]
+	}
+	lines := strings.Split(data, "\n")
+	if len(lines) < 1 || lines[0] == "" {
+		return out, ErrMalformedRequest
+	}
+	out.RequestLine = strings.TrimSuffix(lines[0], "\r")
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			break
+		}
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			return out, ErrMalformedRequest
+		}
+		out.Headers = append(out.Headers, Header{
+			Key:   line[:idx],
+			Value: strings.TrimLeft(line[idx+1:], " \t"),
+		})
+	}
+	return out, nil
+}
+
+// RawTransport sends a RawRequest to a TCP echo server and reads
+// back what the server has received.
+type RawTransport struct {
+	Dialer  netx.Dialer
+	Timeout time.Duration
+}
+
+// RoundTrip sends req to address and returns the bytes echoed back by
+// the server. We stop reading as soon as we see the end of the headers
+// or when the timeout expires. In the latter case, we return whatever
+// we have read so far, if anything, and otherwise the timeout error.
+func (txp RawTransport) RoundTrip(
+	ctx context.Context, address string, req RawRequest) (string, error) {
+	conn, err := txp.Dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(txp.Timeout)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte(req.String())); err != nil {
+		return "", err
+	}
+	var received string
+	data := make([]byte, 4096)
+	for !strings.Contains(received, "\r\n\r\n") {
+		count, err := conn.Read(data)
+		received += string(data[:count])
+		if err != nil {
+			if received != "" && err.Error() == errorx.FailureGenericTimeoutError {
+				break
+			}
+			return received, err
+		}
+	}
+	return received, nil
+}
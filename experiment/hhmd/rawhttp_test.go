@@ -0,0 +1,41 @@
+package hhmd_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/experiment/hhmd"
+)
+
+func TestRawRequestRoundTrip(t *testing.T) {
+	req := hhmd.NewRawRequest("www.example.com")
+	parsed, err := hhmd.ParseRawRequest(req.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != req.String() {
+		t.Fatal("the serialized requests differ")
+	}
+}
+
+func TestParseRawRequestLenient(t *testing.T) {
+	parsed, err := hhmd.ParseRawRequest("GET / HTTP/1.1\nhOsT:example.com\n\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.RequestLine != "GET / HTTP/1.1" {
+		t.Fatal("unexpected request line")
+	}
+	if len(parsed.Headers) != 1 || parsed.Headers[0].Key != "hOsT" ||
+		parsed.Headers[0].Value != "example.com" {
+		t.Fatal("unexpected headers")
+	}
+}
+
+func TestParseRawRequestErrors(t *testing.T) {
+	for _, input := range []string{"", "GET / HTTP/1.1\r\nantani\r\n\r\n"} {
+		if _, err := hhmd.ParseRawRequest(input); !errors.Is(err, hhmd.ErrMalformedRequest) {
+			t.Fatal("not the error we expected")
+		}
+	}
+}
@@ -29,6 +29,7 @@ type Config struct{}
 
 // TestKeys contains the experiment test keys.
 type TestKeys struct {
+	model.MeasurementDataUsage
 	FailureList   []*string                   `json:"failure_list"`
 	Received      []archival.MaybeBinaryValue `json:"received"`
 	Sent          []string                    `json:"sent"`
@@ -66,6 +67,12 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Tampering
+}
+
 var (
 	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
 	ErrNoAvailableTestHelpers = errors.New("no available helpers")
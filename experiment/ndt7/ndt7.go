@@ -50,6 +50,9 @@ type ServerInfo struct {
 
 // TestKeys contains the test keys
 type TestKeys struct {
+	// MeasurementDataUsage contains the data used by the measurement
+	model.MeasurementDataUsage
+
 	// BootstrapTime is the bootstrap time of the tunnel we're using (if any)
 	BootstrapTime float64 `json:"bootstrap_time,omitempty"`
 
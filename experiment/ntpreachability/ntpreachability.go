@@ -0,0 +1,259 @@
+// Package ntpreachability contains the NTP reachability experiment.
+//
+// This experiment sends an SNTP request (RFC4330) to several well known
+// NTP servers and records whether they reply and the offset between the
+// clock of the probe and the clock of each server. When NTP is blocked,
+// devices cannot correct a skewed clock, and TLS certificate validation
+// fails because certificates appear to be expired or not yet valid. So,
+// the offset helps to interpret certificate errors in other experiments.
+package ntpreachability
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "ntp_reachability"
+	testVersion = "0.1.0"
+
+	// defaultPort is the port used when the input does not contain a port.
+	defaultPort = "123"
+)
+
+// DefaultInputs contains the NTP servers we measure when we have no input.
+var DefaultInputs = []string{
+	"pool.ntp.org",
+	"time.apple.com",
+	"time.cloudflare.com",
+	"time.google.com",
+	"time.windows.com",
+}
+
+var (
+	// ErrInvalidReply indicates that the server sent an invalid SNTP reply.
+	ErrInvalidReply = errors.New("ntpreachability: invalid reply")
+
+	// ErrKissOfDeath indicates that the server replied with a kiss-of-death
+	// packet, meaning it refuses to give us the time.
+	ErrKissOfDeath = errors.New("ntpreachability: kiss of death")
+)
+
+// Config contains the experiment config.
+type Config struct{}
+
+// ServerResult contains the result of querying a server. The offset is
+// the number of seconds that must be added to the clock of the probe to
+// obtain the clock of the server. The RTT is also in seconds.
+type ServerResult struct {
+	Failure *string  `json:"failure"`
+	Offset  *float64 `json:"offset"`
+	RTT     *float64 `json:"rtt"`
+	Server  string   `json:"server"`
+	Stratum int64    `json:"stratum"`
+}
+
+// TestKeys contains the experiment's result. ClockOffset is the median of the
+// offsets measured with the servers that replied, in seconds, or nil if no
+// server replied.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	ClockOffset   *float64                 `json:"clock_offset"`
+	NetworkEvents []archival.NetworkEvent  `json:"network_events"`
+	Queries       []archival.DNSQueryEntry `json:"queries"`
+	Servers       []ServerResult           `json:"servers"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when none of the servers replied.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && len(tk.Servers) > 0 && tk.ClockOffset == nil
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	servers := DefaultInputs
+	if measurement.Input != "" {
+		servers = []string{string(measurement.Input)}
+	}
+	saver := new(trace.Saver)
+	begin := time.Now()
+	dialer := netx.NewDialer(netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+	})
+	var offsets []float64
+	for idx, server := range servers {
+		result := measure(ctx, dialer, server)
+		tk.Servers = append(tk.Servers, result)
+		if result.Offset != nil {
+			offsets = append(offsets, *result.Offset)
+		}
+		status := "ok"
+		if result.Failure != nil {
+			status = *result.Failure
+		}
+		callbacks.OnProgress(float64(idx+1)/float64(len(servers)),
+			fmt.Sprintf("ntpreachability: %s: %s", server, status))
+	}
+	tk.ClockOffset = median(offsets)
+	events := saver.Read()
+	tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+	tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+	return nil
+}
+
+func measure(ctx context.Context, dialer dialer.Dialer, server string) ServerResult {
+	result := ServerResult{Server: server}
+	reply, err := query(ctx, dialer, server)
+	if err = wrap(err); err != nil {
+		result.Failure = archival.NewFailure(err)
+		return result
+	}
+	offset, rtt := reply.offset.Seconds(), reply.rtt.Seconds()
+	result.Offset, result.RTT = &offset, &rtt
+	result.Stratum = int64(reply.stratum)
+	return result
+}
+
+func wrap(err error) error {
+	return errorx.SafeErrWrapperBuilder{
+		Error:     err,
+		Operation: "ntp",
+	}.MaybeBuild()
+}
+
+type sntpReply struct {
+	offset  time.Duration
+	rtt     time.Duration
+	stratum uint8
+}
+
+// query performs an SNTP query with server. Since the server is
+// generally a pool, we let the dialer choose which address to use.
+func query(ctx context.Context, dialer dialer.Dialer, server string) (*sntpReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	address := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		address = net.JoinHostPort(server, defaultPort)
+	}
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	const (
+		packetSize = 48
+		modeClient = 3
+		modeServer = 4
+		version    = 4
+	)
+	request := make([]byte, packetSize)
+	request[0] = version<<3 | modeClient
+	t1 := time.Now()
+	// Set our transmit timestamp, which the server will echo back as the
+	// originate timestamp, to detect spoofed or stale replies.
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(t1))
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, packetSize)
+	count, err := conn.Read(reply)
+	t4 := time.Now()
+	if err != nil {
+		return nil, err
+	}
+	if count < packetSize || reply[0]&0x07 != modeServer ||
+		binary.BigEndian.Uint64(reply[24:]) != binary.BigEndian.Uint64(request[40:]) {
+		return nil, ErrInvalidReply
+	}
+	if reply[1] == 0 {
+		return nil, ErrKissOfDeath
+	}
+	t2 := fromNTPTime(binary.BigEndian.Uint64(reply[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(reply[40:]))
+	return &sntpReply{
+		offset:  (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		rtt:     t4.Sub(t1) - t3.Sub(t2),
+		stratum: reply[1],
+	}, nil
+}
+
+// ntpEpochOffset is the number of seconds between the NTP epoch
+// (1900-01-01) and the Unix epoch (1970-01-01).
+const ntpEpochOffset = 2208988800
+
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := (uint64(t.Nanosecond()) << 32) / 1e9
+	return seconds<<32 | fraction
+}
+
+func fromNTPTime(v uint64) time.Time {
+	seconds := int64(v>>32) - ntpEpochOffset
+	nanoseconds := int64(((v & 0xffffffff) * 1e9) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
+
+func median(values []float64) *float64 {
+	if len(values) <= 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	middle := len(values) / 2
+	out := values[middle]
+	if len(values)%2 == 0 {
+		out = (values[middle-1] + values[middle]) / 2
+	}
+	return &out
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
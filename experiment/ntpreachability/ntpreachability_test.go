@@ -0,0 +1,163 @@
+package ntpreachability_test
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/ntpreachability"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := ntpreachability.NewExperimentMeasurer(ntpreachability.Config{})
+	if measurer.ExperimentName() != "ntp_reachability" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func run(input string) (*model.Measurement, model.ExperimentMeasurer, error) {
+	measurer := ntpreachability.NewExperimentMeasurer(ntpreachability.Config{})
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement, measurer, err
+}
+
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + 2208988800)
+	fraction := (uint64(t.Nanosecond()) << 32) / 1e9
+	return seconds<<32 | fraction
+}
+
+// listen starts an SNTP server whose clock is ahead of ours by offset
+// and replies with the given stratum. It returns the server endpoint.
+func listen(t *testing.T, offset time.Duration, stratum byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if count < 48 {
+				continue
+			}
+			now := toNTPTime(time.Now().Add(offset))
+			reply := make([]byte, 48)
+			reply[0] = 4<<3 | 4
+			reply[1] = stratum
+			copy(reply[24:32], buffer[40:48])
+			binary.BigEndian.PutUint64(reply[32:], now)
+			binary.BigEndian.PutUint64(reply[40:], now)
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestSuccess(t *testing.T) {
+	measurement, measurer, err := run(listen(t, time.Hour, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*ntpreachability.TestKeys)
+	if len(tk.Servers) != 1 {
+		t.Fatal("unexpected number of servers")
+	}
+	server := tk.Servers[0]
+	if server.Failure != nil {
+		t.Fatal(*server.Failure)
+	}
+	if server.Stratum != 2 || server.RTT == nil || server.Offset == nil {
+		t.Fatal("unexpected server result")
+	}
+	if math.Abs(*server.Offset-3600) > 1 {
+		t.Fatal("unexpected offset", *server.Offset)
+	}
+	if tk.ClockOffset == nil || *tk.ClockOffset != *server.Offset {
+		t.Fatal("unexpected clock offset")
+	}
+	if len(tk.NetworkEvents) <= 0 {
+		t.Fatal("expected network events")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestKissOfDeath(t *testing.T) {
+	measurement, measurer, err := run(listen(t, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*ntpreachability.TestKeys)
+	if tk.Servers[0].Failure == nil || *tk.Servers[0].Failure != "unknown_failure: ntpreachability: kiss of death" {
+		t.Fatal("not the failure we expected")
+	}
+	if tk.ClockOffset != nil {
+		t.Fatal("expected nil clock offset")
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().String()
+	conn.Close() // nobody is listening anymore
+	measurement, _, err := run(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*ntpreachability.TestKeys)
+	if tk.Servers[0].Failure == nil || *tk.Servers[0].Failure != "connection_refused" {
+		t.Fatal("not the failure we expected")
+	}
+}
+
+func TestDefaultInputs(t *testing.T) {
+	measurer := ntpreachability.NewExperimentMeasurer(ntpreachability.Config{})
+	measurement := new(model.Measurement)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*ntpreachability.TestKeys)
+	if len(tk.Servers) != len(ntpreachability.DefaultInputs) {
+		t.Fatal("unexpected number of servers")
+	}
+	for _, server := range tk.Servers {
+		if server.Failure == nil || *server.Failure != "interrupted" {
+			t.Fatal("not the failure we expected")
+		}
+	}
+}
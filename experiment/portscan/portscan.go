@@ -0,0 +1,287 @@
+// Package portscan contains the port_scan experiment.
+//
+// This experiment takes in input a host and tests a curated set of ports
+// that are commonly used by circumvention tools and VPNs. For each port,
+// we perform a TCP connect and then, where possible, a handshake using
+// the protocol typically spoken on such port. Comparing the outcome of
+// these operations across ports allows us to identify networks that
+// filter traffic based on the destination port.
+//
+// WireGuard only runs over UDP and its handshake requires knowing the
+// public key of the server, hence for 51820 we only check whether we
+// can establish a TCP connection.
+package portscan
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/openvpn"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "port_scan"
+	testVersion = "0.1.0"
+
+	// DefaultPorts contains the ports we test by default along with
+	// the protocol we use to perform a handshake.
+	DefaultPorts = "443/tls,8443/tls,1194/openvpn,51820/tcp,4443/tls,993/tls"
+
+	// openVPNHandshakeOperation is the operation name used for
+	// OpenVPN handshake failures.
+	openVPNHandshakeOperation = "openvpn_handshake"
+)
+
+const (
+	protocolOpenVPN = "openvpn"
+	protocolTCP     = "tcp"
+	protocolTLS     = "tls"
+)
+
+const (
+	statusClosed          = "closed"
+	statusFiltered        = "filtered"
+	statusHandshakeFailed = "handshake_failed"
+	statusOpen            = "open"
+)
+
+var (
+	// ErrInputRequired indicates that we did not receive any input.
+	ErrInputRequired = errors.New("portscan: input required")
+
+	// ErrInvalidPorts indicates that the Ports config is invalid.
+	ErrInvalidPorts = errors.New("portscan: invalid ports")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	Ports string `ooni:"Comma separated list of port/protocol entries (protocol is one of tcp, tls, openvpn)"`
+}
+
+// PortResult contains the result of testing a port. The status is
+// open if both the TCP connect and the handshake succeed, closed if
+// the connection is refused, filtered if the connect fails otherwise,
+// and handshake_failed if the handshake fails.
+type PortResult struct {
+	Address         string  `json:"address"`
+	FailedOperation *string `json:"failed_operation"`
+	Failure         *string `json:"failure"`
+	Port            string  `json:"port"`
+	Protocol        string  `json:"protocol"`
+	Status          string  `json:"status"`
+}
+
+// TestKeys contains the experiment's result. Failure is set when we
+// cannot resolve the domain name of the input host.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Failure       *string                    `json:"failure"`
+	Host          string                     `json:"host"`
+	NetworkEvents []archival.NetworkEvent    `json:"network_events"`
+	Ports         []PortResult               `json:"ports"`
+	Queries       []archival.DNSQueryEntry   `json:"queries"`
+	TCPConnect    []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes []archival.TLSHandshake    `json:"tls_handshakes"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We flag
+// the measurement when we can reach the host on some ports but some other
+// ports are filtered, which suggests port-based filtering.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok {
+		return false
+	}
+	var open, filtered bool
+	for _, port := range tk.Ports {
+		open = open || port.Status == statusOpen
+		filtered = filtered || port.Status == statusFiltered
+	}
+	return open && filtered
+}
+
+type portSpec struct {
+	port     string
+	protocol string
+}
+
+func parsePorts(ports string) ([]portSpec, error) {
+	var out []portSpec
+	for _, entry := range strings.Split(ports, ",") {
+		v := strings.Split(strings.TrimSpace(entry), "/")
+		if len(v) != 2 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPorts, entry)
+		}
+		switch v[1] {
+		case protocolOpenVPN, protocolTCP, protocolTLS:
+		default:
+			return nil, fmt.Errorf("%w: unknown protocol: %s", ErrInvalidPorts, v[1])
+		}
+		out = append(out, portSpec{port: v[0], protocol: v[1]})
+	}
+	return out, nil
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	tk.Host = string(measurement.Input)
+	if parsed, err := url.Parse(tk.Host); err == nil && parsed.Hostname() != "" {
+		tk.Host = parsed.Hostname()
+	}
+	if tk.Host == "" {
+		return ErrInputRequired
+	}
+	ports := m.config.Ports
+	if ports == "" {
+		ports = DefaultPorts
+	}
+	specs, err := parsePorts(ports)
+	if err != nil {
+		return err
+	}
+	saver := new(trace.Saver)
+	begin := time.Now()
+	defer func() {
+		events := saver.Read()
+		tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+		tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+		tk.TCPConnect = archival.NewTCPConnectList(begin, events)
+		tk.TLSHandshakes = archival.NewTLSHandshakesList(begin, events)
+	}()
+	config := netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+	}
+	addrs, err := netx.NewResolver(config).LookupHost(ctx, tk.Host)
+	if err != nil {
+		s := err.Error()
+		tk.Failure = &s
+		return nil
+	}
+	var handshaker dialer.TLSHandshaker = dialer.SystemTLSHandshaker{}
+	handshaker = dialer.TimeoutTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.ErrorWrapperTLSHandshaker{TLSHandshaker: handshaker}
+	handshaker = dialer.LoggingTLSHandshaker{Logger: sess.Logger(), TLSHandshaker: handshaker}
+	handshaker = dialer.SaverTLSHandshaker{TLSHandshaker: handshaker, Saver: saver}
+	s := scanner{
+		dialer:     netx.NewDialer(config),
+		handshaker: handshaker,
+		host:       tk.Host,
+	}
+	for idx, spec := range specs {
+		result := s.scan(ctx, net.JoinHostPort(addrs[0], spec.port), spec)
+		tk.Ports = append(tk.Ports, result)
+		callbacks.OnProgress(float64(idx+1)/float64(len(specs)), fmt.Sprintf(
+			"port_scan: %s/%s: %s", spec.port, spec.protocol, result.Status))
+	}
+	return nil
+}
+
+type scanner struct {
+	dialer     dialer.Dialer
+	handshaker dialer.TLSHandshaker
+	host       string
+}
+
+func (s scanner) scan(ctx context.Context, address string, spec portSpec) PortResult {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	result := PortResult{Address: address, Port: spec.port, Protocol: spec.protocol}
+	conn, err := s.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Failure = archival.NewFailure(err)
+		result.FailedOperation = archival.NewFailedOperation(err)
+		result.Status = statusFiltered
+		if err.Error() == errorx.FailureConnectionRefused {
+			result.Status = statusClosed
+		}
+		return result
+	}
+	defer conn.Close()
+	switch spec.protocol {
+	case protocolOpenVPN:
+		err = openVPNHandshake(ctx, conn)
+	case protocolTLS:
+		err = s.tlsHandshake(ctx, conn)
+	}
+	if err != nil {
+		result.Failure = archival.NewFailure(err)
+		result.FailedOperation = archival.NewFailedOperation(err)
+		result.Status = statusHandshakeFailed
+		return result
+	}
+	result.Status = statusOpen
+	return result
+}
+
+// tlsHandshake performs a TLS handshake without verifying the certificate,
+// because here we are only interested in whether the port speaks TLS.
+func (s scanner) tlsHandshake(ctx context.Context, conn net.Conn) error {
+	tlsconn, _, err := s.handshaker.Handshake(ctx, conn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         s.host,
+	})
+	if err != nil {
+		return err
+	}
+	tlsconn.Close()
+	return nil
+}
+
+// openVPNHandshake sends an OpenVPN P_CONTROL_HARD_RESET_CLIENT_V2 packet
+// and checks whether the server replies with P_CONTROL_HARD_RESET_SERVER_V2.
+func openVPNHandshake(ctx context.Context, conn net.Conn) error {
+	err := openvpn.Handshake(ctx, conn, "tcp")
+	return errorx.SafeErrWrapperBuilder{
+		Error:     err,
+		Operation: openVPNHandshakeOperation,
+	}.MaybeBuild()
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
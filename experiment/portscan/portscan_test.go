@@ -0,0 +1,201 @@
+package portscan_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/portscan"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := portscan.NewExperimentMeasurer(portscan.Config{})
+	if measurer.ExperimentName() != "port_scan" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func run(ctx context.Context, config portscan.Config, input string) (
+	*model.Measurement, model.ExperimentMeasurer, error) {
+	measurer := portscan.NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement, measurer, err
+}
+
+// listen starts a server that handles each connection using handle
+// and returns the port on which the server is listening.
+func listen(t *testing.T, handle func(net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+// openVPNServer replies to a client hard reset with a hard reset using
+// the given opcode that acknowledges the client packet.
+func openVPNServer(opcode byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		packet := make([]byte, 16)
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		reply := []byte{0, 26, opcode << 3}
+		reply = append(reply, make([]byte, 8)...) // server session ID
+		reply = append(reply, 1, 0, 0, 0, 0)      // ack of packet ID zero
+		reply = append(reply, packet[3:11]...)    // client session ID
+		reply = append(reply, 0, 0, 0, 0)         // packet ID
+		conn.Write(reply)
+	}
+}
+
+func closedPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close() // nobody is listening anymore
+	return port
+}
+
+func TestInputRequired(t *testing.T) {
+	_, _, err := run(context.Background(), portscan.Config{}, "")
+	if !errors.Is(err, portscan.ErrInputRequired) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestInvalidPorts(t *testing.T) {
+	for _, ports := range []string{"443", "443/antani"} {
+		_, _, err := run(context.Background(), portscan.Config{Ports: ports}, "127.0.0.1")
+		if !errors.Is(err, portscan.ErrInvalidPorts) {
+			t.Fatal("not the error we expected")
+		}
+	}
+}
+
+func TestDefaultPorts(t *testing.T) {
+	for _, port := range []string{"443", "8443", "1194", "51820", "4443", "993"} {
+		if !strings.Contains(portscan.DefaultPorts, port+"/") {
+			t.Fatal("missing default port", port)
+		}
+	}
+}
+
+func TestWithLocalServers(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	URL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := strings.Join([]string{
+		URL.Port() + "/tls",
+		listen(t, openVPNServer(8)) + "/openvpn",
+		listen(t, openVPNServer(3)) + "/openvpn",
+		listen(t, func(net.Conn) {}) + "/tcp",
+		closedPort(t) + "/tcp",
+	}, ",")
+	measurement, measurer, err := run(
+		context.Background(), portscan.Config{Ports: ports}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*portscan.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	expect := []string{"open", "open", "handshake_failed", "open", "closed"}
+	if len(tk.Ports) != len(expect) {
+		t.Fatal("unexpected number of results")
+	}
+	for idx, result := range tk.Ports {
+		if result.Status != expect[idx] {
+			t.Fatalf("port %s/%s: expected %s, got %s", result.Port,
+				result.Protocol, expect[idx], result.Status)
+		}
+	}
+	if *tk.Ports[2].FailedOperation != "openvpn_handshake" {
+		t.Fatal("not the failed operation we expected")
+	}
+	if *tk.Ports[4].Failure != "connection_refused" {
+		t.Fatal("not the failure we expected")
+	}
+	if len(tk.TCPConnect) != 5 || len(tk.TLSHandshakes) != 1 {
+		t.Fatal("unexpected number of events")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestIsAnomaly(t *testing.T) {
+	measurer := portscan.NewExperimentMeasurer(portscan.Config{})
+	measurement := &model.Measurement{TestKeys: &portscan.TestKeys{
+		Ports: []portscan.PortResult{{Status: "open"}, {Status: "filtered"}},
+	}}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+	measurement.TestKeys = &portscan.TestKeys{
+		Ports: []portscan.PortResult{{Status: "filtered"}, {Status: "filtered"}},
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	measurement, _, err := run(ctx, portscan.Config{}, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*portscan.TestKeys)
+	if tk.Failure == nil || *tk.Failure != "interrupted" {
+		t.Fatal("not the failure we expected")
+	}
+	if len(tk.Ports) != 0 {
+		t.Fatal("expected no results here")
+	}
+}
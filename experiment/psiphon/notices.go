@@ -0,0 +1,86 @@
+package psiphon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
+)
+
+// Notice is a notice emitted by psiphon-tunnel-core while bootstrapping.
+// T is the time when we received the notice relative to the beginning
+// of the measurement, while Timestamp is psiphon's own timestamp.
+type Notice struct {
+	Data      map[string]interface{} `json:"data"`
+	T         float64                `json:"t"`
+	Timestamp string                 `json:"timestamp"`
+	Type      string                 `json:"type"`
+}
+
+// Stage is a stage of the bootstrap. These are the stages, in the order
+// in which they typically occur: "candidate_selection", when psiphon has
+// selected the candidate servers; "handshake_start" and "handshake_done",
+// when psiphon starts and completes the handshake with a server; "active",
+// when the tunnel passed the liveness test and became active; "connected",
+// when the tunnel is ready. T is relative to the beginning of the measurement.
+type Stage struct {
+	Name string  `json:"name"`
+	T    float64 `json:"t"`
+}
+
+// stages maps notice types to stages.
+var stages = map[string]string{
+	"ActiveTunnel":     "active",
+	"CandidateServers": "candidate_selection",
+	"ConnectedServer":  "handshake_done",
+	"ConnectingServer": "handshake_start",
+	"Tunnels":          "connected",
+}
+
+// noticesCollector collects notices until we stop it, because
+// psiphon emits notices for as long as the tunnel is running.
+type noticesCollector struct {
+	begin   time.Time
+	mu      sync.Mutex
+	notices []Notice
+	seen    map[string]bool
+	stages  []Stage
+	stopped bool
+}
+
+func newNoticesCollector(begin time.Time) *noticesCollector {
+	return &noticesCollector{begin: begin, seen: make(map[string]bool)}
+}
+
+// Handle implements psiphonnotice.Handler.
+func (c *noticesCollector) Handle(notice psiphonnotice.Notice) {
+	t := time.Since(c.begin).Seconds()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	c.notices = append(c.notices, Notice{
+		Data:      notice.Data,
+		T:         t,
+		Timestamp: notice.Timestamp,
+		Type:      notice.Type,
+	})
+	name, found := stages[notice.Type]
+	if !found || c.seen[name] {
+		return
+	}
+	if count, _ := notice.Data["count"].(float64); notice.Type == "Tunnels" && count <= 0 {
+		return // this notice also says when we lose all tunnels
+	}
+	c.seen[name] = true
+	c.stages = append(c.stages, Stage{Name: name, T: t})
+}
+
+// Stop stops collecting and returns the notices and the stages.
+func (c *noticesCollector) Stop() ([]Notice, []Stage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	return c.notices, c.stages
+}
@@ -0,0 +1,41 @@
+package psiphon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
+)
+
+func TestNoticesCollector(t *testing.T) {
+	collector := newNoticesCollector(time.Now())
+	for _, name := range []string{
+		"CandidateServers", "ConnectingServer", "ConnectedServer",
+		"ActiveTunnel", "Tunnels",
+	} {
+		collector.Handle(psiphonnotice.Notice{
+			Data: map[string]interface{}{"count": 1.0},
+			Type: name,
+		})
+	}
+	notices, stages := collector.Stop()
+	collector.Handle(psiphonnotice.Notice{Type: "BytesTransferred"})
+	if len(notices) != 5 {
+		t.Fatal("unexpected number of notices")
+	}
+	expected := []string{
+		"candidate_selection", "handshake_start", "handshake_done",
+		"active", "connected",
+	}
+	if len(stages) != len(expected) {
+		t.Fatal("unexpected number of stages")
+	}
+	for idx, stage := range stages {
+		if stage.Name != expected[idx] {
+			t.Fatal("unexpected stage", stage.Name)
+		}
+	}
+	if notices, _ := collector.Stop(); len(notices) != 5 {
+		t.Fatal("we should not collect notices after Stop")
+	}
+}
@@ -1,5 +1,8 @@
 // Package psiphon implements the psiphon network experiment. This
-// implements, in particular, v0.2.0 of the spec.
+// implements, in particular, v0.2.0 of the spec. In addition, we
+// include in the test keys the notices emitted by psiphon-tunnel-core
+// while bootstrapping and the resulting bootstrap stages, so that it
+// is possible to know where a bootstrap failed.
 //
 // See https://github.com/ooni/spec/blob/master/nettests/ts-015-psiphon.md
 package psiphon
@@ -10,12 +13,13 @@ import (
 	"time"
 
 	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
 	"github.com/ooni/probe-engine/model"
 )
 
 const (
 	testName    = "psiphon"
-	testVersion = "0.4.0"
+	testVersion = "0.5.0"
 )
 
 // Config contains the experiment's configuration.
@@ -23,10 +27,14 @@ type Config struct {
 	urlgetter.Config
 }
 
-// TestKeys contains the experiment's result.
+// TestKeys contains the experiment's result. When the session was
+// already using a psiphon tunnel, we do not bootstrap a new tunnel,
+// hence BootstrapStages and Notices are empty.
 type TestKeys struct {
 	urlgetter.TestKeys
-	MaxRuntime float64 `json:"max_runtime"`
+	BootstrapStages []Stage  `json:"bootstrap_stages"`
+	MaxRuntime      float64  `json:"max_runtime"`
+	Notices         []Notice `json:"psiphon_notices"`
 }
 
 // Measurer is the psiphon measurer.
@@ -45,6 +53,13 @@ func (m *Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when we cannot use the tunnel.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Failure != nil
+}
+
 func (m *Measurer) printprogress(
 	ctx context.Context, wg *sync.WaitGroup,
 	maxruntime int, callbacks model.ExperimentCallbacks,
@@ -72,6 +87,8 @@ func (m *Measurer) Run(
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
 	const maxruntime = 60
+	collector := newNoticesCollector(time.Now())
+	ctx = psiphonnotice.WithHandler(ctx, collector.Handle)
 	ctx, cancel := context.WithTimeout(ctx, maxruntime*time.Second)
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -93,9 +110,12 @@ func (m *Measurer) Run(
 	tk, err := g.Get(ctx)
 	cancel()
 	wg.Wait()
-	measurement.TestKeys = TestKeys{
-		TestKeys:   tk,
-		MaxRuntime: maxruntime,
+	notices, stages := collector.Stop()
+	measurement.TestKeys = &TestKeys{
+		TestKeys:        tk,
+		BootstrapStages: stages,
+		MaxRuntime:      maxruntime,
+		Notices:         notices,
 	}
 	return err
 }
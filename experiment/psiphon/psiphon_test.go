@@ -11,6 +11,7 @@ import (
 	"github.com/ooni/probe-engine/experiment/psiphon"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
 	"github.com/ooni/probe-engine/model"
 )
 
@@ -22,7 +23,7 @@ func TestNewExperimentMeasurer(t *testing.T) {
 	if measurer.ExperimentName() != "psiphon" {
 		t.Fatal("unexpected name")
 	}
-	if measurer.ExperimentVersion() != "0.4.0" {
+	if measurer.ExperimentVersion() != "0.5.0" {
 		t.Fatal("unexpected version")
 	}
 }
@@ -37,7 +38,7 @@ func TestRunWithCancelledContext(t *testing.T) {
 	if !errors.Is(err, context.Canceled) {
 		t.Fatal("expected another error here")
 	}
-	tk := measurement.TestKeys.(psiphon.TestKeys)
+	tk := measurement.TestKeys.(*psiphon.TestKeys)
 	if tk.MaxRuntime <= 0 {
 		t.Fatal("you did not set the max runtime")
 	}
@@ -61,7 +62,7 @@ func TestRunWithCustomInputAndCancelledContext(t *testing.T) {
 	if !errors.Is(err, context.Canceled) {
 		t.Fatal("expected another error here")
 	}
-	tk := measurement.TestKeys.(psiphon.TestKeys)
+	tk := measurement.TestKeys.(*psiphon.TestKeys)
 	if tk.MaxRuntime <= 0 {
 		t.Fatal("you did not set the max runtime")
 	}
@@ -80,7 +81,7 @@ func TestRunWillPrintSomethingWithCancelledContext(t *testing.T) {
 	if !errors.Is(err, context.Canceled) {
 		t.Fatal("expected another error here")
 	}
-	tk := measurement.TestKeys.(psiphon.TestKeys)
+	tk := measurement.TestKeys.(*psiphon.TestKeys)
 	if tk.MaxRuntime <= 0 {
 		t.Fatal("you did not set the max runtime")
 	}
@@ -103,3 +104,51 @@ func (d observerCallbacks) OnProgress(percentage float64, message string) {
 func newfakesession() model.ExperimentSession {
 	return &mockable.ExperimentSession{MockableLogger: log.Log}
 }
+
+// noticesSession is a session emitting notices when starting a tunnel.
+type noticesSession struct {
+	*mockable.ExperimentSession
+	notices []psiphonnotice.Notice
+}
+
+func (sess *noticesSession) MaybeStartTunnel(ctx context.Context, name string) error {
+	handler := psiphonnotice.ContextHandler(ctx)
+	for _, notice := range sess.notices {
+		handler(notice)
+	}
+	return errors.New("mocked error")
+}
+
+func TestRunCollectsNoticesAndStages(t *testing.T) {
+	sess := &noticesSession{
+		ExperimentSession: &mockable.ExperimentSession{MockableLogger: log.Log},
+		notices: []psiphonnotice.Notice{
+			{Type: "CandidateServers", Data: map[string]interface{}{"count": 10.0}},
+			{Type: "ConnectingServer"},
+			{Type: "Info"},
+			{Type: "ConnectingServer"},
+			{Type: "Tunnels", Data: map[string]interface{}{"count": 0.0}},
+		},
+	}
+	measurement := new(model.Measurement)
+	measurer := psiphon.NewExperimentMeasurer(psiphon.Config{})
+	err := measurer.Run(context.Background(), sess, measurement,
+		model.NewPrinterCallbacks(log.Log))
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	tk := measurement.TestKeys.(*psiphon.TestKeys)
+	if len(tk.Notices) != 5 || tk.Notices[0].Type != "CandidateServers" {
+		t.Fatal("unexpected notices")
+	}
+	if len(tk.BootstrapStages) != 2 {
+		t.Fatal("unexpected number of stages")
+	}
+	if tk.BootstrapStages[0].Name != "candidate_selection" ||
+		tk.BootstrapStages[1].Name != "handshake_start" {
+		t.Fatal("unexpected stages")
+	}
+	if tk.BootstrapStages[0].T > tk.BootstrapStages[1].T {
+		t.Fatal("stages are not ordered")
+	}
+}
@@ -0,0 +1,312 @@
+// Package quicping contains the quicping experiment.
+//
+// This experiment sends QUIC Initial packets to UDP port 443 of the
+// input host and records whether any response comes back, to map networks
+// that silently drop QUIC. We use QUIC's version-independent properties
+// (RFC 8999). Our Initial packets use a reserved version, so we do not
+// need to encrypt them: a QUIC server MUST answer with a Version
+// Negotiation packet. We also send malformed packets, i.e., the same
+// packets with the QUIC fixed bit cleared, which servers should discard:
+// getting a response to them suggests that a middlebox is involved.
+package quicping
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/runtimex"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "quicping"
+	testVersion = "0.1.0"
+
+	// KindMalformed is a ping with the QUIC fixed bit cleared.
+	KindMalformed = "malformed"
+
+	// KindVersionNegotiation is a well formed Initial using a
+	// reserved version, which forces version negotiation.
+	KindVersionNegotiation = "version_negotiation"
+
+	// ResponseRetry is a Retry response.
+	ResponseRetry = "retry"
+
+	// ResponseUnknown is a long header response we don't know.
+	ResponseUnknown = "unknown"
+
+	// ResponseVersionNegotiation is a Version Negotiation response.
+	ResponseVersionNegotiation = "version_negotiation"
+
+	// reservedVersion is a reserved QUIC version (RFC 9000, Sect. 15).
+	reservedVersion = 0xbabababa
+
+	// minInitialSize is the minimum size of a UDP datagram
+	// containing a client Initial (RFC 9000, Sect. 14.1).
+	minInitialSize = 1200
+
+	connIDLength = 8
+)
+
+// ErrInputRequired indicates that we did not receive any input.
+var ErrInputRequired = errors.New("quicping: input required")
+
+// Config contains the experiment config.
+type Config struct {
+	Port        int64 `ooni:"UDP port to send packets to (default: 443)"`
+	Repetitions int64 `ooni:"Number of pings of each kind to send (default: 5)"`
+	timeout     time.Duration
+}
+
+// Response is a response to a ping.
+type Response struct {
+	SupportedVersions []uint32 `json:"supported_versions,omitempty"`
+	T                 float64  `json:"t"`
+	Type              string   `json:"type"`
+}
+
+// SinglePing contains the results of a single ping. The Failure is
+// nil if we received a response.
+type SinglePing struct {
+	ConnIDDst string     `json:"conn_id_dst"`
+	ConnIDSrc string     `json:"conn_id_src"`
+	Failure   *string    `json:"failure"`
+	Kind      string     `json:"kind"`
+	Responses []Response `json:"responses"`
+	T         float64    `json:"t"`
+}
+
+// TestKeys contains the experiment's result.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Domain        string                   `json:"domain"`
+	Failure       *string                  `json:"failure"`
+	NetworkEvents []archival.NetworkEvent  `json:"network_events"`
+	Pings         []SinglePing             `json:"pings"`
+	Queries       []archival.DNSQueryEntry `json:"queries"`
+	Repetitions   int64                    `json:"repetitions"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when no well formed ping got a response.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok || tk.Failure != nil {
+		return false
+	}
+	for _, ping := range tk.Pings {
+		if ping.Kind == KindVersionNegotiation && ping.Failure == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	tk.Domain = string(measurement.Input)
+	if tk.Domain == "" {
+		return ErrInputRequired
+	}
+	port, timeout := m.config.Port, m.config.timeout
+	if port <= 0 {
+		port = 443
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	tk.Repetitions = m.config.Repetitions
+	if tk.Repetitions <= 0 {
+		tk.Repetitions = 5
+	}
+	endpoint := net.JoinHostPort(tk.Domain, strconv.FormatInt(port, 10))
+	saver := new(trace.Saver)
+	d := netx.NewDialer(netx.Config{
+		CacheResolutions:    true,
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+	})
+	begin := time.Now()
+	kinds := []string{KindVersionNegotiation, KindMalformed}
+	total := float64(tk.Repetitions) * float64(len(kinds))
+	for idx := int64(0); idx < tk.Repetitions && ctx.Err() == nil; idx++ {
+		for _, kind := range kinds {
+			ping := doPing(ctx, d, endpoint, kind, begin, timeout)
+			tk.Pings = append(tk.Pings, ping)
+			callbacks.OnProgress(float64(len(tk.Pings))/total, fmt.Sprintf(
+				"quicping: %s ping to %s: %s", kind, endpoint, archival.FailureString(ping.Failure)))
+		}
+	}
+	events := saver.Read()
+	tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+	tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+	if err := ctx.Err(); err != nil {
+		s := errorx.SafeErrWrapperBuilder{Error: err}.MaybeBuild().Error()
+		tk.Failure = &s
+	}
+	return nil
+}
+
+func doPing(
+	ctx context.Context, dialer dialer.Dialer, endpoint, kind string,
+	begin time.Time, timeout time.Duration,
+) SinglePing {
+	dcid, scid := newConnID(), newConnID()
+	ping := SinglePing{
+		ConnIDDst: hex.EncodeToString(dcid),
+		ConnIDSrc: hex.EncodeToString(scid),
+		Kind:      kind,
+		T:         time.Since(begin).Seconds(),
+	}
+	response, err := exchange(ctx, dialer, endpoint, newPacket(kind, dcid, scid), scid, begin, timeout)
+	if err != nil {
+		s := err.Error()
+		ping.Failure = &s
+		return ping
+	}
+	ping.Responses = append(ping.Responses, response)
+	return ping
+}
+
+// exchange sends packet to endpoint and waits for the first response
+// to it, until the timeout expires.
+func exchange(
+	ctx context.Context, dialer dialer.Dialer, endpoint string, packet, scid []byte,
+	begin time.Time, timeout time.Duration,
+) (Response, error) {
+	conn, err := dialer.DialContext(ctx, "udp", endpoint)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(packet); err != nil {
+		return Response{}, err
+	}
+	buffer := make([]byte, 1<<14)
+	for {
+		count, err := conn.Read(buffer)
+		if err != nil {
+			return Response{}, err
+		}
+		if response, ok := parseResponse(buffer[:count], scid); ok {
+			response.T = time.Since(begin).Seconds()
+			return response, nil
+		}
+	}
+}
+
+func newConnID() []byte {
+	b := make([]byte, connIDLength)
+	_, err := rand.Read(b)
+	runtimex.PanicOnError(err, "rand.Read failed")
+	return b
+}
+
+// newPacket returns a client Initial packet using a reserved version. If
+// kind is KindMalformed, the fixed bit of the first byte is cleared. Since
+// the server only parses the version-independent header, we don't care
+// about encrypting and we pad the frames with zeroes.
+func newPacket(kind string, dcid, scid []byte) []byte {
+	var first byte = 0xc0 // long header, fixed bit, Initial
+	if kind == KindMalformed {
+		first = 0x80
+	}
+	packet := []byte{first}
+	packet = append(packet, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(packet[1:], reservedVersion)
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	packet = append(packet, 0) // token length
+	// length of the packet number and payload as a two bytes varint
+	length := minInitialSize - len(packet) - 2
+	packet = append(packet, 0x40|byte(length>>8), byte(length))
+	packet = append(packet, make([]byte, length)...)
+	return packet
+}
+
+// parseResponse parses a long header packet sent in response to a
+// ping with the specified source connection ID. We ignore packets that
+// are not long header packets or are not for us.
+func parseResponse(data, scid []byte) (Response, bool) {
+	if len(data) < 7 || data[0]&0x80 == 0 {
+		return Response{}, false
+	}
+	version := binary.BigEndian.Uint32(data[1:5])
+	dcidlen := int(data[5])
+	if len(data) < 6+dcidlen+1 || !bytes.Equal(data[6:6+dcidlen], scid) {
+		return Response{}, false
+	}
+	rest := data[6+dcidlen:]
+	scidlen := int(rest[0])
+	if len(rest) < 1+scidlen {
+		return Response{}, false
+	}
+	rest = rest[1+scidlen:]
+	switch {
+	case version == 0:
+		response := Response{Type: ResponseVersionNegotiation}
+		for ; len(rest) >= 4; rest = rest[4:] {
+			response.SupportedVersions = append(
+				response.SupportedVersions, binary.BigEndian.Uint32(rest))
+		}
+		return response, true
+	case (data[0]&0x30)>>4 == 3:
+		return Response{Type: ResponseRetry}, true
+	default:
+		return Response{Type: ResponseUnknown}, true
+	}
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
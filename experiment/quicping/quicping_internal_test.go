@@ -0,0 +1,7 @@
+package quicping
+
+import "time"
+
+func (c *Config) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
@@ -0,0 +1,165 @@
+package quicping_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/quicping"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := quicping.NewExperimentMeasurer(quicping.Config{})
+	if measurer.ExperimentName() != "quicping" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func TestInputRequired(t *testing.T) {
+	measurer := quicping.NewExperimentMeasurer(quicping.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, quicping.ErrInputRequired) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+// startServer starts a fake QUIC server that answers to well formed
+// Initial packets with a Version Negotiation packet, when respond is
+// true, and otherwise does not answer. It returns the server port.
+func startServer(t *testing.T, respond bool) int64 {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 1<<14)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			data := buffer[:count]
+			if !respond || count < 1200 || data[0]&0x40 == 0 {
+				continue // drop malformed packets
+			}
+			dcid := data[6 : 6+int(data[5])]
+			rest := data[6+len(dcid):]
+			scid := rest[1 : 1+int(rest[0])]
+			reply := []byte{0x80, 0, 0, 0, 0, byte(len(scid))}
+			reply = append(reply, scid...)
+			reply = append(reply, byte(len(dcid)))
+			reply = append(reply, dcid...)
+			reply = append(reply, 0, 0, 0, 1)
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	return int64(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func runWithServer(t *testing.T, respond bool) (*model.Measurement, model.ExperimentMeasurer) {
+	config := quicping.Config{Port: startServer(t, respond), Repetitions: 2}
+	config.SetTimeout(250 * time.Millisecond)
+	measurer := quicping.NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: "127.0.0.1"}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return measurement, measurer
+}
+
+func TestWithResponsiveServer(t *testing.T) {
+	measurement, measurer := runWithServer(t, true)
+	tk := measurement.TestKeys.(*quicping.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal("unexpected failure")
+	}
+	if tk.Repetitions != 2 || len(tk.Pings) != 4 {
+		t.Fatal("unexpected number of pings")
+	}
+	for _, ping := range tk.Pings {
+		switch ping.Kind {
+		case quicping.KindVersionNegotiation:
+			if ping.Failure != nil {
+				t.Fatal(*ping.Failure)
+			}
+			if len(ping.Responses) != 1 {
+				t.Fatal("expected a response here")
+			}
+			response := ping.Responses[0]
+			if response.Type != quicping.ResponseVersionNegotiation {
+				t.Fatal("unexpected response type")
+			}
+			if len(response.SupportedVersions) != 1 || response.SupportedVersions[0] != 1 {
+				t.Fatal("unexpected supported versions")
+			}
+		case quicping.KindMalformed:
+			if ping.Failure == nil || *ping.Failure != "generic_timeout_error" {
+				t.Fatal("expected a timeout here")
+			}
+		default:
+			t.Fatal("unexpected ping kind")
+		}
+	}
+	if len(tk.NetworkEvents) <= 0 {
+		t.Fatal("no network events?!")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestWithSilentServer(t *testing.T) {
+	measurement, measurer := runWithServer(t, false)
+	tk := measurement.TestKeys.(*quicping.TestKeys)
+	for _, ping := range tk.Pings {
+		if ping.Failure == nil || *ping.Failure != "generic_timeout_error" {
+			t.Fatal("expected a timeout here")
+		}
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	measurer := quicping.NewExperimentMeasurer(quicping.Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	measurement := &model.Measurement{Input: "www.google.com"}
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*quicping.TestKeys)
+	if tk.Failure == nil || *tk.Failure != "interrupted" {
+		t.Fatal("expected interrupted here")
+	}
+	if len(tk.Pings) != 0 {
+		t.Fatal("expected no pings here")
+	}
+}
@@ -0,0 +1,172 @@
+// Package signal contains the Signal messenger network experiment.
+//
+// We check whether we can establish TLS connections and perform HTTP
+// requests with the Signal backend, i.e., its registration service,
+// its storage service, and its CDNs. We don't care about the HTTP status
+// code, since we're only interested in detecting TLS or socket level
+// interference. Signal uses its own CA, hence we validate the certificates
+// against it (i.e., we pin the Signal CA). By default, we use the Signal CA
+// embedded in this package, which you can override using Config.SignalCA.
+package signal
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	// CDNURL is the URL of Signal's CDN
+	CDNURL = "https://cdn.signal.org/"
+
+	// CDN2URL is the URL of Signal's second CDN
+	CDN2URL = "https://cdn2.signal.org/"
+
+	// RegistrationServiceURL is the URL of Signal's registration service
+	RegistrationServiceURL = "https://textsecure-service.whispersystems.org/"
+
+	// StorageServiceURL is the URL of Signal's storage service
+	StorageServiceURL = "https://storage.signal.org/"
+
+	testName    = "signal"
+	testVersion = "0.1.0"
+
+	// signalCA is the CA that signs the certificates of the Signal backend.
+	signalCA = `-----BEGIN CERTIFICATE-----
+MIID7zCCAtegAwIBAgIJAIm6LatK5PNiMA0GCSqGSIb3DQEBBQUAMIGNMQswCQYD
+VQQGEwJVUzETMBEGA1UECAwKQ2FsaWZvcm5pYTEWMBQGA1UEBwwNU2FuIEZyYW5j
+aXNjbzEdMBsGA1UECgwUT3BlbiBXaGlzcGVyIFN5c3RlbXMxHTAbBgNVBAsMFE9w
+ZW4gV2hpc3BlciBTeXN0ZW1zMRMwEQYDVQQDDApUZXh0U2VjdXJlMB4XDTEzMDMy
+NTIyMTgzNVoXDTIzMDMyMzIyMTgzNVowgY0xCzAJBgNVBAYTAlVTMRMwEQYDVQQI
+DApDYWxpZm9ybmlhMRYwFAYDVQQHDA1TYW4gRnJhbmNpc2NvMR0wGwYDVQQKDBRP
+cGVuIFdoaXNwZXIgU3lzdGVtczEdMBsGA1UECwwUT3BlbiBXaGlzcGVyIFN5c3Rl
+bXMxEzARBgNVBAMMClRleHRTZWN1cmUwggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAw
+ggEKAoIBAQDBSWBpOCBDF0i4q2d4jAXkSXUGpbeWugVPQCjaL6qD9QDOxeW1afvf
+Po863i6Crq1KDxHpB36EwzVcjwLkFTIMeo7t9s1FQolAt3mErV2U0vie6Ves+yj6
+grSfxwIDAcdsKmI0a1SQCZlr3Q1tcHAkAKFRxYNawADyps5B+Zmqcgf653TXS5/0
+IPPQLocLn8GWLwOYNnYfBvILKDMItmZTtEbucdigxEA9mfIvvHADEbteLtVgwBm9
+R5vVvtwrD6CCxI3pgH7EH7kMP0Od93wLisvn1yhHY7FuYlrkYqdkMvWUrKoASVw4
+jb69vaeJCUdU+HCoXOSP1PQcL6WenNCHAgMBAAGjUDBOMB0GA1UdDgQWBBQBixjx
+P/s5GURuhYa+lGUypzI8kDAfBgNVHSMEGDAWgBQBixjxP/s5GURuhYa+lGUypzI8
+kDAMBgNVHRMEBTADAQH/MA0GCSqGSIb3DQEBBQUAA4IBAQB+Hr4hC56m0LvJAu1R
+K6NuPDbQMEoUA7Set0t2JmTQpolGHJqq3nyfxe1AVctByeG6vDHRsXbMl6n4v8uR
+qdCoaOumCYuZwqPpd3RdFQ6Yp8ikJNu0BrvSbKPZVCD2Mi4jR/xf0dlnmtljhQVG
+u60T6deAIVuvyKX32DpOzin2R+2piE1qQS0jXAtNvLmyf+f2aXK2U1x53kBTo3W/
+xmUctmkYRoJq0C32OZ+96bh8JZuyZMhPGMs4h2VBHwLB6JWP1oNrYBWs1dyaKRD5
+ET6EiUx7ZaL8sHf4eC0YzKQbEPnvOxEDoWZOYxUBtoIdnZNGIyzwOykHO7IiHoZa
+y6Pw
+-----END CERTIFICATE-----`
+)
+
+// ErrInvalidSignalCA indicates that Config.SignalCA does not
+// contain any valid PEM-encoded certificate.
+var ErrInvalidSignalCA = errors.New("signal: invalid SignalCA")
+
+// Config contains the experiment config.
+type Config struct {
+	SignalCA string `ooni:"PEM-encoded CA used to validate Signal's certificates (default: the Signal CA)"`
+}
+
+// TestKeys contains the experiment results. SignalBackendFailures maps
+// each endpoint we checked to its failure (nil on success).
+type TestKeys struct {
+	urlgetter.TestKeys
+	SignalBackendFailure  *string            `json:"signal_backend_failure"`
+	SignalBackendFailures map[string]*string `json:"signal_backend_failures"`
+	SignalBackendStatus   string             `json:"signal_backend_status"`
+}
+
+// NewTestKeys returns a new instance of the test keys.
+func NewTestKeys() *TestKeys {
+	return &TestKeys{
+		SignalBackendFailures: make(map[string]*string),
+		SignalBackendStatus:   "ok",
+	}
+}
+
+// Update updates the TestKeys using the given MultiOutput result.
+func (tk *TestKeys) Update(v urlgetter.MultiOutput) {
+	tk.AppendObservations(v.TestKeys)
+	tk.SignalBackendFailures[v.Input.Target] = v.TestKeys.Failure
+	if v.TestKeys.Failure != nil {
+		tk.SignalBackendStatus = "blocked"
+		if tk.SignalBackendFailure == nil {
+			tk.SignalBackendFailure = v.TestKeys.Failure
+		}
+	}
+}
+
+// Measurer performs the measurement
+type Measurer struct {
+	// Config contains the experiment settings. If empty we
+	// will be using default settings.
+	Config Config
+
+	// Getter is an optional getter to be used for testing.
+	Getter urlgetter.MultiGetter
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName
+func (m Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion
+func (m Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.SignalBackendStatus != "ok"
+}
+
+// Run implements ExperimentMeasurer.Run
+func (m Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	signalCAPEM := m.Config.SignalCA
+	if signalCAPEM == "" {
+		signalCAPEM = signalCA
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM([]byte(signalCAPEM)) {
+		return ErrInvalidSignalCA
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	urlgetter.RegisterExtensions(measurement)
+	var inputs []urlgetter.MultiInput
+	for _, target := range []string{
+		CDNURL, CDN2URL, RegistrationServiceURL, StorageServiceURL,
+	} {
+		inputs = append(inputs, urlgetter.MultiInput{
+			CertPool: certPool,
+			Target:   target,
+		})
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rnd.Shuffle(len(inputs), func(i, j int) {
+		inputs[i], inputs[j] = inputs[j], inputs[i]
+	})
+	multi := urlgetter.Multi{Begin: time.Now(), Getter: m.Getter, Session: sess}
+	testkeys := NewTestKeys()
+	testkeys.Agent = "redirect"
+	measurement.TestKeys = testkeys
+	for entry := range multi.Collect(ctx, inputs, "signal", callbacks) {
+		testkeys.Update(entry)
+	}
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return Measurer{Config: config}
+}
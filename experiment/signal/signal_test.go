@@ -0,0 +1,157 @@
+package signal_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/experiment/signal"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestNewExperimentMeasurer(t *testing.T) {
+	measurer := signal.NewExperimentMeasurer(signal.Config{})
+	if measurer.ExperimentName() != "signal" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestFailureAllEndpoints(t *testing.T) {
+	measurer := signal.NewExperimentMeasurer(signal.Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sess := &mockable.ExperimentSession{MockableLogger: log.Log}
+	measurement := new(model.Measurement)
+	callbacks := model.NewPrinterCallbacks(log.Log)
+	err := measurer.Run(ctx, sess, measurement, callbacks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*signal.TestKeys)
+	if *tk.SignalBackendFailure != "interrupted" {
+		t.Fatal("invalid SignalBackendFailure")
+	}
+	if tk.SignalBackendStatus != "blocked" {
+		t.Fatal("invalid SignalBackendStatus")
+	}
+	if len(tk.SignalBackendFailures) != 4 {
+		t.Fatal("invalid SignalBackendFailures")
+	}
+	for target, failure := range tk.SignalBackendFailures {
+		if failure == nil || *failure != "interrupted" {
+			t.Fatalf("invalid failure for %s", target)
+		}
+	}
+	if !measurer.(signal.Measurer).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestInvalidSignalCA(t *testing.T) {
+	measurer := signal.NewExperimentMeasurer(signal.Config{
+		SignalCA: "antani",
+	})
+	ctx := context.Background()
+	sess := &mockable.ExperimentSession{MockableLogger: log.Log}
+	measurement := new(model.Measurement)
+	callbacks := model.NewPrinterCallbacks(log.Log)
+	err := measurer.Run(ctx, sess, measurement, callbacks)
+	if !errors.Is(err, signal.ErrInvalidSignalCA) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestWePinTheSignalCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	signalCA := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}))
+	called := atomicx.NewInt64()
+	measurer := signal.Measurer{
+		Config: signal.Config{SignalCA: signalCA},
+		Getter: func(ctx context.Context, g urlgetter.Getter) (urlgetter.TestKeys, error) {
+			called.Add(1)
+			if g.CertPool == nil {
+				panic("expected a non-nil CertPool here")
+			}
+			return urlgetter.TestKeys{}, nil
+		},
+	}
+	ctx := context.Background()
+	sess := &mockable.ExperimentSession{MockableLogger: log.Log}
+	measurement := new(model.Measurement)
+	callbacks := model.NewPrinterCallbacks(log.Log)
+	if err := measurer.Run(ctx, sess, measurement, callbacks); err != nil {
+		t.Fatal(err)
+	}
+	if called.Load() != 4 {
+		t.Fatal("not called the expected number of times")
+	}
+	tk := measurement.TestKeys.(*signal.TestKeys)
+	if tk.SignalBackendFailure != nil {
+		t.Fatal("invalid SignalBackendFailure")
+	}
+	if tk.SignalBackendStatus != "ok" {
+		t.Fatal("invalid SignalBackendStatus")
+	}
+	if measurer.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestWeUseTheEmbeddedSignalCAByDefault(t *testing.T) {
+	measurer := signal.Measurer{
+		Getter: func(ctx context.Context, g urlgetter.Getter) (urlgetter.TestKeys, error) {
+			if g.CertPool == nil || len(g.CertPool.Subjects()) != 1 {
+				panic("expected the embedded Signal CA here")
+			}
+			return urlgetter.TestKeys{}, nil
+		},
+	}
+	ctx := context.Background()
+	sess := &mockable.ExperimentSession{MockableLogger: log.Log}
+	measurement := new(model.Measurement)
+	callbacks := model.NewPrinterCallbacks(log.Log)
+	if err := measurer.Run(ctx, sess, measurement, callbacks); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTestKeysUpdate(t *testing.T) {
+	failure := "connection_reset"
+	tk := signal.NewTestKeys()
+	tk.Update(urlgetter.MultiOutput{
+		Input: urlgetter.MultiInput{Target: signal.CDNURL},
+	})
+	if tk.SignalBackendStatus != "ok" || tk.SignalBackendFailure != nil {
+		t.Fatal("unexpected status after success")
+	}
+	tk.Update(urlgetter.MultiOutput{
+		Input:    urlgetter.MultiInput{Target: signal.StorageServiceURL},
+		TestKeys: urlgetter.TestKeys{Failure: &failure},
+	})
+	if tk.SignalBackendStatus != "blocked" {
+		t.Fatal("invalid SignalBackendStatus")
+	}
+	if tk.SignalBackendFailure != &failure {
+		t.Fatal("invalid SignalBackendFailure")
+	}
+	if tk.SignalBackendFailures[signal.CDNURL] != nil {
+		t.Fatal("invalid CDN failure")
+	}
+	if tk.SignalBackendFailures[signal.StorageServiceURL] != &failure {
+		t.Fatal("invalid storage failure")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,7 +21,7 @@ import (
 
 const (
 	testName    = "sni_blocking"
-	testVersion = "0.1.0"
+	testVersion = "0.2.0"
 )
 
 // Config contains the experiment config.
@@ -43,6 +44,7 @@ type Subresult struct {
 
 // TestKeys contains sniblocking test keys.
 type TestKeys struct {
+	model.MeasurementDataUsage
 	Control Subresult `json:"control"`
 	Result  string    `json:"result"`
 	Target  Subresult `json:"target"`
@@ -52,6 +54,7 @@ const (
 	classAnomalyTestHelperUnreachable   = "anomaly.test_helper_unreachable"
 	classAnomalyTimeout                 = "anomaly.timeout"
 	classAnomalyUnexpectedFailure       = "anomaly.unexpected_failure"
+	classInterferenceBlockpage          = "interference.blockpage"
 	classInterferenceClosed             = "interference.closed"
 	classInterferenceInvalidCertificate = "interference.invalid_certificate"
 	classInterferenceReset              = "interference.reset"
@@ -59,6 +62,10 @@ const (
 	classSuccessGotServerHello          = "success.got_server_hello"
 )
 
+// notTLSRecordError is the error returned by crypto/tls when the
+// server response does not start with a TLS record.
+const notTLSRecordError = "first record does not look like a TLS handshake"
+
 func (tk *TestKeys) classify() string {
 	if tk.Target.Failure == nil {
 		return classSuccessGotServerHello
@@ -84,6 +91,12 @@ func (tk *TestKeys) classify() string {
 	case errorx.FailureSSLUnknownAuthority:
 		return classInterferenceUnknownAuthority
 	}
+	// When the censor replies to the ClientHello with a blockpage, or
+	// with a redirect to a blockpage, the TLS library complains that it
+	// did not receive a TLS record.
+	if strings.HasSuffix(*tk.Target.Failure, notTLSRecordError) {
+		return classInterferenceBlockpage
+	}
 	return classAnomalyUnexpectedFailure
 }
 
@@ -94,7 +107,7 @@ type Measurer struct {
 	mu     sync.Mutex
 }
 
-// ExperimentName implements ExperimentMeasurer.ExperiExperimentName.
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
 func (m *Measurer) ExperimentName() string {
 	return testName
 }
@@ -104,6 +117,12 @@ func (m *Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && strings.HasPrefix(tk.Result, "interference.")
+}
+
 func (m *Measurer) measureone(
 	ctx context.Context,
 	sess model.ExperimentSession,
@@ -2,6 +2,7 @@ package sniblocking
 
 import (
 	"context"
+	"net"
 	"strings"
 	"testing"
 	"time"
@@ -91,6 +92,14 @@ func TestUnitTestKeysClassify(t *testing.T) {
 			t.Fatal("unexpected result")
 		}
 	})
+	t.Run("with tk.Target.Failure == not a TLS record", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(
+			"unknown_failure: tls: first record does not look like a TLS handshake")
+		if tk.classify() != classInterferenceBlockpage {
+			t.Fatal("unexpected result")
+		}
+	})
 	t.Run("with tk.Target.Failure == unknown_failure", func(t *testing.T) {
 		tk := new(TestKeys)
 		tk.Target.Failure = asStringPtr("unknown_failure")
@@ -105,7 +114,7 @@ func TestUnitNewExperimentMeasurer(t *testing.T) {
 	if measurer.ExperimentName() != "sni_blocking" {
 		t.Fatal("unexpected name")
 	}
-	if measurer.ExperimentVersion() != "0.1.0" {
+	if measurer.ExperimentVersion() != "0.2.0" {
 		t.Fatal("unexpected version")
 	}
 }
@@ -158,6 +167,46 @@ func TestUnitMeasurerMeasureWithInvalidInput(t *testing.T) {
 	}
 }
 
+func TestUnitMeasurerMeasureWithBlockpage(t *testing.T) {
+	// A fake test helper that behaves like a censor replying to
+	// every ClientHello with a redirect to a blockpage.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("HTTP/1.1 302 Found\r\nLocation: http://127.0.0.1/\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	measurer := NewExperimentMeasurer(Config{
+		ControlSNI:        "example.com",
+		TestHelperAddress: listener.Addr().String(),
+	})
+	measurement := &model.Measurement{
+		Input: "kernel.org",
+	}
+	err = measurer.Run(
+		context.Background(),
+		newsession(),
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Result != classInterferenceBlockpage {
+		t.Fatal("unexpected result", tk.Result)
+	}
+}
+
 func TestUnitMeasurerMeasureWithCancelledContext(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // immediately cancel the context
@@ -425,3 +474,21 @@ func TestUnitMaybeURLToSNI(t *testing.T) {
 func newsession() model.ExperimentSession {
 	return &mockable.ExperimentSession{MockableLogger: log.Log}
 }
+
+func TestUnitIsAnomaly(t *testing.T) {
+	detector := NewExperimentMeasurer(Config{}).(model.ExperimentAnomalyDetector)
+	var cases = []struct {
+		result   string
+		expected bool
+	}{
+		{classSuccessGotServerHello, false},
+		{classAnomalyTimeout, false},
+		{classInterferenceReset, true},
+	}
+	for _, c := range cases {
+		measurement := &model.Measurement{TestKeys: &TestKeys{Result: c.result}}
+		if detector.IsAnomaly(measurement) != c.expected {
+			t.Fatal("unexpected result for", c.result)
+		}
+	}
+}
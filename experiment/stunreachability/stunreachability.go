@@ -9,6 +9,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/ooni/probe-engine/geolocate"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/archival"
@@ -21,8 +22,15 @@ import (
 const (
 	testName    = "stun_reachability"
 	testVersion = "0.0.1"
+
+	// DefaultEndpoint is the endpoint we measure when we have no input.
+	DefaultEndpoint = "stun.l.google.com:19302"
 )
 
+// DefaultInputs contains the STUN servers we measure when the user does
+// not provide any input. These are the servers also used by geolocate.
+var DefaultInputs = geolocate.DefaultSTUNServers
+
 // Config contains the experiment config.
 type Config struct {
 	dialContext func(ctx context.Context, network, address string) (net.Conn, error)
@@ -31,6 +39,7 @@ type Config struct {
 
 // TestKeys contains the experiment's result.
 type TestKeys struct {
+	model.MeasurementDataUsage
 	Endpoint      string                   `json:"endpoint"`
 	Failure       *string                  `json:"failure"`
 	NetworkEvents []archival.NetworkEvent  `json:"network_events"`
@@ -47,7 +56,7 @@ type Measurer struct {
 	config Config
 }
 
-// ExperimentName implements ExperimentMeasurer.ExperiExperimentName.
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
 func (m *Measurer) ExperimentName() string {
 	return testName
 }
@@ -57,6 +66,13 @@ func (m *Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when we cannot talk to the STUN server.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Failure != nil
+}
+
 func wrap(err error) error {
 	return errorx.SafeErrWrapperBuilder{
 		Error:     err,
@@ -84,10 +100,9 @@ func (tk *TestKeys) run(
 	ctx context.Context, config Config, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	const defaultAddress = "stun.l.google.com:19302"
 	endpoint := string(measurement.Input)
 	if endpoint == "" {
-		endpoint = defaultAddress
+		endpoint = DefaultEndpoint
 	}
 	callbacks.OnProgress(0, fmt.Sprintf("stunreachability: measuring: %s...", endpoint))
 	defer callbacks.OnProgress(
@@ -10,6 +10,7 @@ import (
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/stunreachability"
+	"github.com/ooni/probe-engine/geolocate"
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -26,6 +27,25 @@ func TestMeasurerExperimentNameVersion(t *testing.T) {
 	}
 }
 
+func TestDefaultInputs(t *testing.T) {
+	if len(stunreachability.DefaultInputs) <= 0 {
+		t.Fatal("no default inputs?!")
+	}
+	var found bool
+	for _, input := range stunreachability.DefaultInputs {
+		if _, _, err := net.SplitHostPort(input); err != nil {
+			t.Fatal(err)
+		}
+		found = found || input == stunreachability.DefaultEndpoint
+	}
+	if !found {
+		t.Fatal("DefaultEndpoint is not in DefaultInputs")
+	}
+	if &stunreachability.DefaultInputs[0] != &geolocate.DefaultSTUNServers[0] {
+		t.Fatal("DefaultInputs should be geolocate.DefaultSTUNServers")
+	}
+}
+
 func TestIntegrationRun(t *testing.T) {
 	if os.Getenv("GITHUB_ACTIONS") == "true" {
 		// See https://github.com/ooni/probe-engine/issues/874#issuecomment-679850652
@@ -0,0 +1,171 @@
+// Package tcpping contains the tcpping experiment.
+//
+// This experiment takes in input a host:port endpoint and performs
+// several TCP connects to it, measuring the RTT of each attempt. It is
+// a lightweight way of checking whether an endpoint is reachable and
+// of estimating the latency towards it. When the host is a domain
+// name, we resolve it once, before starting, so that the RTT of the
+// first attempt does not include the DNS lookup.
+package tcpping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "tcpping"
+	testVersion = "0.1.0"
+)
+
+var (
+	// ErrInputRequired indicates that we did not receive any input.
+	ErrInputRequired = errors.New("tcpping: input required")
+
+	// ErrInvalidInput indicates that the input is not host:port.
+	ErrInvalidInput = errors.New("tcpping: invalid input")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	Delay       int64 `ooni:"Milliseconds to wait between attempts (default: 1000)"`
+	Repetitions int64 `ooni:"Number of TCP connects to perform (default: 10)"`
+}
+
+// SinglePing contains the results of a single TCP connect.
+type SinglePing struct {
+	Address string  `json:"address"`
+	Failure *string `json:"failure"`
+	RTT     float64 `json:"rtt"`
+	T       float64 `json:"t"`
+}
+
+// TestKeys contains the experiment's result. Failure is set when we
+// cannot resolve the domain name of the input endpoint.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Endpoint    string                     `json:"endpoint"`
+	Failure     *string                    `json:"failure"`
+	Pings       []SinglePing               `json:"pings"`
+	Queries     []archival.DNSQueryEntry   `json:"queries"`
+	Repetitions int64                      `json:"repetitions"`
+	TCPConnect  []archival.TCPConnectEntry `json:"tcp_connect"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when some pings fail.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok {
+		return false
+	}
+	for _, ping := range tk.Pings {
+		if ping.Failure != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	tk.Endpoint = string(measurement.Input)
+	if tk.Endpoint == "" {
+		return ErrInputRequired
+	}
+	host, port, err := net.SplitHostPort(tk.Endpoint)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidInput, err.Error())
+	}
+	tk.Repetitions = m.config.Repetitions
+	if tk.Repetitions <= 0 {
+		tk.Repetitions = 10
+	}
+	delay := time.Duration(m.config.Delay) * time.Millisecond
+	if m.config.Delay <= 0 {
+		delay = time.Second
+	}
+	saver := new(trace.Saver)
+	begin := time.Now()
+	defer func() {
+		events := saver.Read()
+		tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+		tk.TCPConnect = archival.NewTCPConnectList(begin, events)
+	}()
+	config := netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ResolveSaver:        saver,
+	}
+	addrs, err := netx.NewResolver(config).LookupHost(ctx, host)
+	if err != nil {
+		s := err.Error()
+		tk.Failure = &s
+		return nil
+	}
+	address := net.JoinHostPort(addrs[0], port)
+	dialer := netx.NewDialer(config)
+	for idx := int64(0); idx < tk.Repetitions; idx++ {
+		if idx > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		ping := SinglePing{Address: address, T: time.Since(begin).Seconds()}
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		ping.RTT = time.Since(start).Seconds()
+		if err != nil {
+			s := err.Error()
+			ping.Failure = &s
+		} else {
+			conn.Close()
+		}
+		tk.Pings = append(tk.Pings, ping)
+		callbacks.OnProgress(float64(idx+1)/float64(tk.Repetitions), fmt.Sprintf(
+			"tcpping: %s: rtt=%.3fs failure=%s", address, ping.RTT, archival.FailureString(ping.Failure)))
+	}
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
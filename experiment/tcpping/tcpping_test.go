@@ -0,0 +1,141 @@
+package tcpping_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/tcpping"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := tcpping.NewExperimentMeasurer(tcpping.Config{})
+	if measurer.ExperimentName() != "tcpping" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func run(ctx context.Context, config tcpping.Config, input string) (*tcpping.TestKeys, error) {
+	measurer := tcpping.NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*tcpping.TestKeys), err
+}
+
+func TestInvalidInputs(t *testing.T) {
+	var inputs = []struct {
+		input string
+		err   error
+	}{{
+		input: "",
+		err:   tcpping.ErrInputRequired,
+	}, {
+		input: "www.example.com",
+		err:   tcpping.ErrInvalidInput,
+	}}
+	for _, in := range inputs {
+		_, err := run(context.Background(), tcpping.Config{}, in.input)
+		if !errors.Is(err, in.err) {
+			t.Fatal("not the error we expected")
+		}
+	}
+}
+
+func TestWithLocalServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	config := tcpping.Config{Delay: 1, Repetitions: 3}
+	tk, err := run(context.Background(), config, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Repetitions != 3 || len(tk.Pings) != 3 {
+		t.Fatal("unexpected number of pings")
+	}
+	for _, ping := range tk.Pings {
+		if ping.Failure != nil {
+			t.Fatal(*ping.Failure)
+		}
+		if ping.RTT <= 0 {
+			t.Fatal("invalid RTT")
+		}
+	}
+	if len(tk.TCPConnect) != 3 {
+		t.Fatal("unexpected number of TCP connect entries")
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close() // nobody is listening anymore
+	tk, err := run(context.Background(), tcpping.Config{Delay: 1, Repetitions: 2}, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ping := range tk.Pings {
+		if ping.Failure == nil || *ping.Failure != "connection_refused" {
+			t.Fatal("not the failure we expected")
+		}
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tk, err := run(ctx, tcpping.Config{}, "www.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure == nil || *tk.Failure != "interrupted" {
+		t.Fatal("not the failure we expected")
+	}
+	if len(tk.Pings) != 0 {
+		t.Fatal("expected no pings here")
+	}
+}
+
+func TestIsAnomaly(t *testing.T) {
+	detector := tcpping.NewExperimentMeasurer(
+		tcpping.Config{}).(model.ExperimentAnomalyDetector)
+	failure := "connection_refused"
+	tk := &tcpping.TestKeys{Pings: []tcpping.SinglePing{{}, {}}}
+	measurement := &model.Measurement{TestKeys: tk}
+	if detector.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+	tk.Pings[1].Failure = &failure
+	if !detector.IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
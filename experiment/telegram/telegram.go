@@ -43,11 +43,7 @@ func NewTestKeys() *TestKeys {
 // Update updates the TestKeys using the given MultiOutput result.
 func (tk *TestKeys) Update(v urlgetter.MultiOutput) {
 	// update the easy to update entries first
-	tk.NetworkEvents = append(tk.NetworkEvents, v.TestKeys.NetworkEvents...)
-	tk.Queries = append(tk.Queries, v.TestKeys.Queries...)
-	tk.Requests = append(tk.Requests, v.TestKeys.Requests...)
-	tk.TCPConnect = append(tk.TCPConnect, v.TestKeys.TCPConnect...)
-	tk.TLSHandshakes = append(tk.TLSHandshakes, v.TestKeys.TLSHandshakes...)
+	tk.AppendObservations(v.TestKeys)
 	// then process access points
 	if v.Input.Config.Method != "GET" {
 		if v.TestKeys.Failure == nil {
@@ -99,6 +95,14 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when the access points or the web are blocked.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && (tk.TelegramHTTPBlocking || tk.TelegramTCPBlocking ||
+		tk.TelegramWebStatus != "ok")
+}
+
 // Run implements ExperimentMeasurer.Run
 func (m Measurer) Run(ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks) error {
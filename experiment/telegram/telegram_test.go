@@ -361,3 +361,21 @@ func TestUpdateWithAllGood(t *testing.T) {
 		t.Fatal("invalid TelegramWebFailure")
 	}
 }
+
+func TestIsAnomaly(t *testing.T) {
+	detector := telegram.NewExperimentMeasurer(
+		telegram.Config{}).(model.ExperimentAnomalyDetector)
+	tk := telegram.NewTestKeys()
+	measurement := &model.Measurement{TestKeys: tk}
+	if !detector.IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly with blocked access points")
+	}
+	tk.TelegramHTTPBlocking, tk.TelegramTCPBlocking = false, false
+	if detector.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+	tk.TelegramWebStatus = "blocked"
+	if !detector.IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly with blocked web")
+	}
+}
@@ -95,6 +95,7 @@ func (tr *TargetResults) fillSummary() {
 
 // TestKeys contains tor test keys.
 type TestKeys struct {
+	model.MeasurementDataUsage
 	DirPortTotal            int64                    `json:"dir_port_total"`
 	DirPortAccessible       int64                    `json:"dir_port_accessible"`
 	OBFS4Total              int64                    `json:"obfs4_total"`
@@ -153,7 +154,7 @@ func NewMeasurer(config Config) *Measurer {
 	}
 }
 
-// ExperimentName implements ExperimentMeasurer.ExperiExperimentName.
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
 func (m *Measurer) ExperimentName() string {
 	return testName
 }
@@ -163,6 +164,16 @@ func (m *Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when some of the targets are not accessible.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && (tk.DirPortAccessible < tk.DirPortTotal ||
+		tk.OBFS4Accessible < tk.OBFS4Total ||
+		tk.ORPortDirauthAccessible < tk.ORPortDirauthTotal ||
+		tk.ORPortAccessible < tk.ORPortTotal)
+}
+
 // Run implements ExperimentMeasurer.Run.
 func (m *Measurer) Run(
 	ctx context.Context,
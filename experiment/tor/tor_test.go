@@ -863,3 +863,16 @@ func TestMaybeScrubbingLogger(t *testing.T) {
 		}
 	})
 }
+
+func TestUnitIsAnomaly(t *testing.T) {
+	detector := NewExperimentMeasurer(Config{}).(model.ExperimentAnomalyDetector)
+	tk := &TestKeys{ORPortTotal: 2, ORPortAccessible: 2, OBFS4Total: 1, OBFS4Accessible: 1}
+	measurement := &model.Measurement{TestKeys: tk}
+	if detector.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+	tk.OBFS4Accessible = 0
+	if !detector.IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
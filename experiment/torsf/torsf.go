@@ -0,0 +1,150 @@
+// Package torsf contains the torsf experiment, which measures how long
+// it takes to bootstrap tor using the snowflake pluggable transport. We
+// record the bootstrap progress events emitted by tor, so that it
+// is possible to know where a bootstrap failed.
+//
+// This experiment requires the tor and snowflake-client binaries.
+package torsf
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/torx"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+const (
+	testName    = "torsf"
+	testVersion = "0.1.0"
+
+	// maxRuntime is the maximum time allowed for bootstrapping.
+	maxRuntime = 600 * time.Second
+)
+
+// Config contains the experiment config.
+type Config struct {
+	SnowflakeClient string `ooni:"Path of the snowflake-client binary"`
+
+	startTunnel func(ctx context.Context, config torx.StartConfig) (tunnel, error)
+}
+
+// tunnel is the tunnel started by torx.
+type tunnel interface {
+	BootstrapTime() time.Duration
+	Stop()
+}
+
+// BootstrapEvent is a bootstrap progress event emitted by tor. T is the
+// time elapsed since the beginning of the measurement.
+type BootstrapEvent struct {
+	Progress int     `json:"progress"`
+	Summary  string  `json:"summary"`
+	T        float64 `json:"t"`
+	Tag      string  `json:"tag"`
+}
+
+// TestKeys contains the experiment's result. BootstrapTime is the
+// time required to bootstrap and is zero on failure.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	BootstrapEvents []BootstrapEvent `json:"bootstrap_events"`
+	BootstrapTime   float64          `json:"bootstrap_time"`
+	Failure         *string          `json:"failure"`
+	MaxRuntime      float64          `json:"max_runtime"`
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when tor fails to bootstrap.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Failure != nil
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := &TestKeys{MaxRuntime: maxRuntime.Seconds()}
+	measurement.TestKeys = tk
+	err := errorx.SafeErrWrapperBuilder{
+		Error:     tk.run(ctx, m.config, sess, callbacks),
+		Operation: errorx.TopLevelOperation,
+	}.MaybeBuild()
+	if err != nil {
+		s := err.Error()
+		tk.Failure = &s
+		return err
+	}
+	return nil
+}
+
+func (tk *TestKeys) run(ctx context.Context, config Config,
+	sess model.ExperimentSession, callbacks model.ExperimentCallbacks) error {
+	// Use a fresh data directory, so that we always perform a full
+	// bootstrap and we do not interfere with the session's tunnel.
+	datadir, err := ioutil.TempDir(sess.TempDir(), "torsf")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(datadir)
+	ctx, cancel := context.WithTimeout(ctx, maxRuntime)
+	defer cancel()
+	begin := time.Now()
+	var mu sync.Mutex
+	startConfig := torx.NewStartConfig(sess)
+	startConfig.BridgeTimeout = maxRuntime
+	startConfig.DataDir = datadir
+	startConfig.Snowflake = &torx.SnowflakeConfig{ClientBinary: config.SnowflakeClient}
+	startConfig.Progress = func(progress torx.BootstrapProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		tk.BootstrapEvents = append(tk.BootstrapEvents, BootstrapEvent{
+			Progress: progress.Progress,
+			Summary:  progress.Summary,
+			T:        time.Since(begin).Seconds(),
+			Tag:      progress.Tag,
+		})
+		callbacks.OnProgress(float64(progress.Progress)/100,
+			fmt.Sprintf("torsf: %s", progress.Summary))
+	}
+	startTunnel := config.startTunnel
+	if startTunnel == nil {
+		startTunnel = func(ctx context.Context, config torx.StartConfig) (tunnel, error) {
+			return torx.StartWithConfig(ctx, config)
+		}
+	}
+	tun, err := startTunnel(ctx, startConfig)
+	if err != nil {
+		return err
+	}
+	defer tun.Stop()
+	tk.BootstrapTime = tun.BootstrapTime().Seconds()
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
@@ -0,0 +1,14 @@
+package torsf
+
+import (
+	"context"
+
+	"github.com/ooni/probe-engine/internal/torx"
+)
+
+type Tunnel = tunnel
+
+func (c *Config) SetStartTunnel(
+	f func(ctx context.Context, config torx.StartConfig) (Tunnel, error)) {
+	c.startTunnel = f
+}
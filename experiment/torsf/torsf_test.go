@@ -0,0 +1,113 @@
+package torsf_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/torsf"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/torx"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := torsf.NewExperimentMeasurer(torsf.Config{})
+	if measurer.ExperimentName() != "torsf" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+type fakeTunnel struct {
+	stopped bool
+}
+
+func (tun *fakeTunnel) BootstrapTime() time.Duration {
+	return 3 * time.Second
+}
+
+func (tun *fakeTunnel) Stop() {
+	tun.stopped = true
+}
+
+func newSession() model.ExperimentSession {
+	return &mockable.ExperimentSession{MockableLogger: log.Log}
+}
+
+func TestSuccess(t *testing.T) {
+	tun := &fakeTunnel{}
+	config := torsf.Config{SnowflakeClient: "/usr/local/bin/snowflake-client"}
+	config.SetStartTunnel(func(
+		ctx context.Context, config torx.StartConfig) (torsf.Tunnel, error) {
+		if config.Snowflake == nil || config.Snowflake.ClientBinary != "/usr/local/bin/snowflake-client" {
+			t.Fatal("we did not configure snowflake")
+		}
+		if config.DataDir == "" {
+			t.Fatal("we did not configure a fresh data directory")
+		}
+		config.Progress(torx.BootstrapProgress{
+			Progress: 10, Summary: "Finishing handshake", Tag: "handshake_done"})
+		config.Progress(torx.BootstrapProgress{
+			Progress: 100, Summary: "Done", Tag: "done"})
+		return tun, nil
+	})
+	measurement := new(model.Measurement)
+	measurer := torsf.NewExperimentMeasurer(config)
+	err := measurer.Run(context.Background(), newSession(), measurement,
+		model.NewPrinterCallbacks(log.Log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*torsf.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal("unexpected failure")
+	}
+	if tk.BootstrapTime != 3 || tk.MaxRuntime != 600 {
+		t.Fatal("unexpected bootstrap time or max runtime")
+	}
+	if len(tk.BootstrapEvents) != 2 {
+		t.Fatal("unexpected number of bootstrap events")
+	}
+	if tk.BootstrapEvents[0].Progress != 10 || tk.BootstrapEvents[0].Tag != "handshake_done" {
+		t.Fatal("unexpected first bootstrap event")
+	}
+	if tk.BootstrapEvents[1].Progress != 100 || tk.BootstrapEvents[1].Summary != "Done" {
+		t.Fatal("unexpected second bootstrap event")
+	}
+	if tk.BootstrapEvents[0].T > tk.BootstrapEvents[1].T {
+		t.Fatal("bootstrap events are not ordered")
+	}
+	if !tun.stopped {
+		t.Fatal("we did not stop the tunnel")
+	}
+}
+
+func TestFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	config := torsf.Config{}
+	config.SetStartTunnel(func(
+		ctx context.Context, config torx.StartConfig) (torsf.Tunnel, error) {
+		config.Progress(torx.BootstrapProgress{
+			Progress: 5, Summary: "Connecting to a relay", Tag: "conn"})
+		return nil, expected
+	})
+	measurement := new(model.Measurement)
+	measurer := torsf.NewExperimentMeasurer(config)
+	err := measurer.Run(context.Background(), newSession(), measurement,
+		model.NewPrinterCallbacks(log.Log))
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	tk := measurement.TestKeys.(*torsf.TestKeys)
+	if tk.Failure == nil || *tk.Failure != "unknown_failure: mocked error" {
+		t.Fatal("unexpected failure")
+	}
+	if tk.BootstrapTime != 0 || len(tk.BootstrapEvents) != 1 {
+		t.Fatal("unexpected results")
+	}
+}
@@ -2,12 +2,14 @@ package urlgetter
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"net"
 	"net/url"
 	"regexp"
 	"strings"
 
+	"github.com/ooni/probe-engine/internal/litemode"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/trace"
@@ -15,8 +17,14 @@ import (
 
 // The Configurer job is to construct a Configuration that can
 // later be used by the measurer to perform measurements.
+//
+// When LiteMode is true, we save smaller HTTP body snapshots and we do
+// not save the read and write events (see the litemode package). When
+// CertPool is not nil, we use it to verify certificates.
 type Configurer struct {
+	CertPool *x509.CertPool
 	Config   Config
+	LiteMode bool
 	Logger   model.Logger
 	ProxyURL *url.URL
 	Saver    *trace.Saver
@@ -40,6 +48,7 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 		HTTPConfig: netx.Config{
 			BogonIsError:        c.Config.RejectDNSBogons,
 			CacheResolutions:    true,
+			CertPool:            c.CertPool,
 			ContextByteCounting: true,
 			DialSaver:           c.Saver,
 			HTTPSaver:           c.Saver,
@@ -49,6 +58,10 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 			TLSSaver:            c.Saver,
 		},
 	}
+	if c.LiteMode {
+		configuration.HTTPConfig.HTTPSnapshotSize = litemode.SnapshotSize
+		configuration.HTTPConfig.ReadWriteSaver = nil
+	}
 	// fill DNS cache
 	if c.Config.DNSCache != "" {
 		entry := strings.Split(c.Config.DNSCache, " ")
@@ -2,12 +2,14 @@ package urlgetter_test
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"net/url"
 	"strings"
 	"testing"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/internal/litemode"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
 )
@@ -521,6 +523,23 @@ func TestConfigurerNewConfigurationNoTLSVerify(t *testing.T) {
 	}
 }
 
+func TestConfigurerNewConfigurationCertPool(t *testing.T) {
+	saver := new(trace.Saver)
+	pool := x509.NewCertPool()
+	configurer := urlgetter.Configurer{
+		CertPool: pool,
+		Logger:   log.Log,
+		Saver:    saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.HTTPConfig.CertPool != pool {
+		t.Fatal("not the CertPool we expected")
+	}
+}
+
 func TestConfigurerNewConfigurationTLSv1(t *testing.T) {
 	saver := new(trace.Saver)
 	configurer := urlgetter.Configurer{
@@ -730,3 +749,26 @@ func TestConfigurerNewConfigurationProxyURL(t *testing.T) {
 		t.Fatal("invalid ProxyURL")
 	}
 }
+
+func TestConfigurerNewConfigurationLiteMode(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		LiteMode: true,
+		Logger:   log.Log,
+		Saver:    saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if configuration.HTTPConfig.HTTPSaver != saver {
+		t.Fatal("not the HTTPSaver we expected")
+	}
+	if configuration.HTTPConfig.HTTPSnapshotSize != litemode.SnapshotSize {
+		t.Fatal("not the HTTPSnapshotSize we expected")
+	}
+	if configuration.HTTPConfig.ReadWriteSaver != nil {
+		t.Fatal("not the ReadWriteSaver we expected")
+	}
+}
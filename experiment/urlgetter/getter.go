@@ -2,8 +2,10 @@ package urlgetter
 
 import (
 	"context"
+	"crypto/x509"
 	"time"
 
+	"github.com/ooni/probe-engine/internal/litemode"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/archival"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -20,6 +22,10 @@ type Getter struct {
 	// set this field, every target is measured independently.
 	Begin time.Time
 
+	// CertPool is the optional CA pool used to verify certificates,
+	// e.g., to pin a specific CA. If nil, we use our default CA pool.
+	CertPool *x509.CertPool
+
 	// Config contains settings for this run. If not set, then
 	// we will use the default config.
 	Config Config
@@ -96,7 +102,9 @@ func (g Getter) get(ctx context.Context, saver *trace.Saver) (TestKeys, error) {
 	}
 	// create configuration
 	configurer := Configurer{
+		CertPool: g.CertPool,
 		Config:   g.Config,
+		LiteMode: litemode.Enabled(ctx),
 		Logger:   g.Session.Logger(),
 		ProxyURL: g.Session.ProxyURL(),
 		Saver:    saver,
@@ -2,6 +2,7 @@ package urlgetter
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"time"
 
@@ -10,6 +11,9 @@ import (
 
 // MultiInput is the input for Multi.Run().
 type MultiInput struct {
+	// CertPool is the optional CA pool used to verify certificates.
+	CertPool *x509.CertPool
+
 	// Config contains the configuration for this target.
 	Config Config
 
@@ -118,10 +122,11 @@ func (m Multi) source(inputs []MultiInput, inputch chan<- MultiInput) {
 func (m Multi) do(ctx context.Context, in <-chan MultiInput, out chan<- MultiOutput) {
 	for input := range in {
 		g := Getter{
-			Begin:   m.Begin,
-			Config:  input.Config,
-			Session: m.Session,
-			Target:  input.Target,
+			Begin:    m.Begin,
+			CertPool: input.CertPool,
+			Config:   input.Config,
+			Session:  m.Session,
+			Target:   input.Target,
 		}
 		fn := m.Getter
 		if fn == nil {
@@ -69,6 +69,9 @@ func (r Runner) httpGet(ctx context.Context, url string) error {
 	req.Header.Set("Accept", httpheader.Accept())
 	req.Header.Set("Accept-Language", httpheader.AcceptLanguage())
 	req.Header.Set("User-Agent", MaybeUserAgent(r.Config.UserAgent))
+	for key, value := range r.Config.HTTPHeaders {
+		req.Header.Set(key, value)
+	}
 	if r.Config.HTTPHost != "" {
 		req.Host = r.Config.HTTPHost
 	}
@@ -99,7 +102,11 @@ func (r Runner) httpGet(ctx context.Context, url string) error {
 	// whole body. Even though we discard the body, we want to know whether we
 	// see any error when reading the body before inspecting the HTTP status code.
 	if resp.StatusCode >= 400 && r.Config.FailOnHTTPError {
-		return ErrHTTPRequestFailed
+		return errorx.SafeErrWrapperBuilder{
+			Error:          ErrHTTPRequestFailed,
+			HTTPStatusCode: int64(resp.StatusCode),
+			Operation:      errorx.TopLevelOperation,
+		}.MaybeBuild()
 	}
 	return nil
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/internal/httpheader"
+	"github.com/ooni/probe-engine/netx/errorx"
 )
 
 func TestRunnerWithInvalidURLScheme(t *testing.T) {
@@ -204,6 +205,10 @@ func TestRunnerHTTPWeHandle400Correctly(t *testing.T) {
 	if !errors.Is(err, urlgetter.ErrHTTPRequestFailed) {
 		t.Fatal("not the error we expected")
 	}
+	var errWrapper *errorx.ErrWrapper
+	if !errors.As(err, &errWrapper) || errWrapper.HTTPStatusCode != 400 {
+		t.Fatal("not the HTTP status code we expected")
+	}
 }
 
 func TestRunnerHTTPCannotReadBodyWinsOver400(t *testing.T) {
@@ -285,3 +290,27 @@ func TestRunnerDefaultUserAgent(t *testing.T) {
 		t.Fatal("we didn't override the user agent")
 	}
 }
+
+func TestRunnerHTTPSetHeaders(t *testing.T) {
+	found := atomicx.NewInt64()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cookie") == "a=b" && r.Header.Get("X-Antani") == "mascetti" {
+			found.Add(1)
+		}
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+	r := urlgetter.Runner{
+		Config: urlgetter.Config{
+			FailOnHTTPError: true,
+			HTTPHeaders:     map[string]string{"Cookie": "a=b", "x-antani": "mascetti"},
+		},
+		Target: server.URL,
+	}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if found.Load() != 1 {
+		t.Fatal("we didn't set the headers")
+	}
+}
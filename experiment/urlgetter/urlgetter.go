@@ -18,22 +18,26 @@ const (
 
 // Config contains the experiment's configuration.
 type Config struct {
-	DNSCache          string `ooni:"Add 'DOMAIN IP...' to cache"`
-	FailOnHTTPError   bool   `ooni:"Fail HTTP request if status code is 400 or above"`
-	HTTPHost          string `ooni:"Force using specific HTTP Host header"`
-	Method            string `ooni:"Force HTTP method different than GET"`
-	NoFollowRedirects bool   `ooni:"Disable following redirects"`
-	NoTLSVerify       bool   `ooni:"Disable TLS verification"`
-	RejectDNSBogons   bool   `ooni:"Fail DNS lookup if response contains bogons"`
-	ResolverURL       string `ooni:"URL describing the resolver to use"`
-	TLSServerName     string `ooni:"Force TLS to using a specific SNI in Client Hello"`
-	TLSVersion        string `ooni:"Force specific TLS version (e.g. 'TLSv1.3')"`
-	Tunnel            string `ooni:"Run experiment over a tunnel, e.g. psiphon"`
-	UserAgent         string `ooni:"Use the specified User-Agent"`
+	DNSCache          string            `ooni:"Add 'DOMAIN IP...' to cache"`
+	FailOnHTTPError   bool              `ooni:"Fail HTTP request if status code is 400 or above"`
+	HTTPHeaders       map[string]string `ooni:"Add the specified HTTP headers to the request"`
+	HTTPHost          string            `ooni:"Force using specific HTTP Host header"`
+	Method            string            `ooni:"Force HTTP method different than GET"`
+	NoFollowRedirects bool              `ooni:"Disable following redirects"`
+	NoTLSVerify       bool              `ooni:"Disable TLS verification"`
+	RejectDNSBogons   bool              `ooni:"Fail DNS lookup if response contains bogons"`
+	ResolverURL       string            `ooni:"URL describing the resolver to use"`
+	TLSServerName     string            `ooni:"Force TLS to using a specific SNI in Client Hello"`
+	TLSVersion        string            `ooni:"Force specific TLS version (e.g. 'TLSv1.3')"`
+	Tunnel            string            `ooni:"Run experiment over a tunnel, e.g. psiphon"`
+	UserAgent         string            `ooni:"Use the specified User-Agent"`
 }
 
 // TestKeys contains the experiment's result.
 type TestKeys struct {
+	// MeasurementDataUsage contains the data used by the measurement
+	model.MeasurementDataUsage
+
 	// The following fields are part of the typical JSON emitted by OONI.
 	Agent           string                     `json:"agent"`
 	BootstrapTime   float64                    `json:"bootstrap_time,omitempty"`
@@ -55,6 +59,18 @@ type TestKeys struct {
 	HTTPResponseLocations []string `json:"-"`
 }
 
+// AppendObservations appends the network events, the DNS queries,
+// the HTTP requests, the TCP connects, and the TLS handshakes saved
+// in other to the ones saved in tk. Experiments that run several
+// urlgetter measurements use it to build their own test keys.
+func (tk *TestKeys) AppendObservations(other TestKeys) {
+	tk.NetworkEvents = append(tk.NetworkEvents, other.NetworkEvents...)
+	tk.Queries = append(tk.Queries, other.Queries...)
+	tk.Requests = append(tk.Requests, other.Requests...)
+	tk.TCPConnect = append(tk.TCPConnect, other.TCPConnect...)
+	tk.TLSHandshakes = append(tk.TLSHandshakes, other.TLSHandshakes...)
+}
+
 // RegisterExtensions registers the extensions used by the urlgetter
 // experiment into the provided measurement.
 func RegisterExtensions(m *model.Measurement) {
@@ -94,7 +110,7 @@ func (m measurer) Run(
 		Target:  string(measurement.Input),
 	}
 	tk, err := g.Get(ctx)
-	measurement.TestKeys = tk
+	measurement.TestKeys = &tk
 	return err
 }
 
@@ -9,6 +9,7 @@ import (
 	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
 )
 
 func TestMeasurer(t *testing.T) {
@@ -33,7 +34,7 @@ func TestMeasurer(t *testing.T) {
 	if len(measurement.Extensions) != 5 {
 		t.Fatal("not the expected number of extensions")
 	}
-	tk := measurement.TestKeys.(urlgetter.TestKeys)
+	tk := measurement.TestKeys.(*urlgetter.TestKeys)
 	if len(tk.DNSCache) != 0 {
 		t.Fatal("not the DNSCache value we expected")
 	}
@@ -63,8 +64,28 @@ func TestMeasurerDNSCache(t *testing.T) {
 	if len(measurement.Extensions) != 5 {
 		t.Fatal("not the expected number of extensions")
 	}
-	tk := measurement.TestKeys.(urlgetter.TestKeys)
+	tk := measurement.TestKeys.(*urlgetter.TestKeys)
 	if len(tk.DNSCache) != 1 || tk.DNSCache[0] != "dns.google 8.8.8.8 8.8.4.4" {
 		t.Fatal("invalid tk.DNSCache")
 	}
 }
+
+func TestAppendObservations(t *testing.T) {
+	tk := urlgetter.TestKeys{
+		Queries: []archival.DNSQueryEntry{{Hostname: "www.example.com"}},
+	}
+	tk.AppendObservations(urlgetter.TestKeys{
+		NetworkEvents: []archival.NetworkEvent{{Operation: "read"}},
+		Queries:       []archival.DNSQueryEntry{{Hostname: "www.example.org"}},
+		Requests:      []archival.RequestEntry{{}},
+		TCPConnect:    []archival.TCPConnectEntry{{IP: "93.184.216.34"}},
+		TLSHandshakes: []archival.TLSHandshake{{ServerName: "www.example.org"}},
+	})
+	if len(tk.NetworkEvents) != 1 || len(tk.Requests) != 1 ||
+		len(tk.TCPConnect) != 1 || len(tk.TLSHandshakes) != 1 {
+		t.Fatal("not the observations we expected")
+	}
+	if len(tk.Queries) != 2 || tk.Queries[1].Hostname != "www.example.org" {
+		t.Fatal("not the queries we expected")
+	}
+}
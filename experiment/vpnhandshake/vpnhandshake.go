@@ -0,0 +1,244 @@
+// Package vpnhandshake contains the vpn_handshake experiment.
+//
+// This experiment sends the first packet of a VPN handshake to a VPN
+// endpoint and checks whether the endpoint replies. We generate packets
+// that are correct according to the protocol, so that DPI boxes classify
+// the flow as VPN traffic. We support these inputs:
+//
+//	wireguard://host:port?public_key=<base64 server public key>
+//	openvpn://host:port?transport=udp
+//	openvpn://host:port?transport=tcp
+//
+// The default ports are 51820 for WireGuard and 1194 for OpenVPN. The
+// OpenVPN transport defaults to UDP.
+//
+// A WireGuard server only replies to peers it knows, hence one should
+// configure the private key of a client that the server knows. Without a
+// known key, we still send a valid initiation message, but the lack of
+// reply does not tell apart blocking and an unknown client, hence we do
+// not flag such failures as anomalies. Because the
+// public key is base64 encoded, it must be URL escaped in the input.
+package vpnhandshake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/openvpn"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "vpn_handshake"
+	testVersion = "0.1.0"
+)
+
+const (
+	protocolOpenVPN   = "openvpn"
+	protocolWireGuard = "wireguard"
+	transportTCP      = "tcp"
+	transportUDP      = "udp"
+)
+
+var (
+	// ErrInputRequired indicates that we did not receive any input.
+	ErrInputRequired = errors.New("vpnhandshake: input required")
+
+	// ErrInvalidInput indicates that the input is not a valid endpoint.
+	ErrInvalidInput = errors.New("vpnhandshake: invalid input")
+
+	// ErrInvalidKey indicates that a WireGuard key is not valid.
+	ErrInvalidKey = errors.New("vpnhandshake: invalid WireGuard key")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	WireGuardPrivateKey string `ooni:"Base64 encoded private key of a WireGuard client known by the servers"`
+}
+
+// TestKeys contains the experiment's result.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Endpoint        string                     `json:"endpoint"`
+	FailedOperation *string                    `json:"failed_operation"`
+	Failure         *string                    `json:"failure"`
+	NetworkEvents   []archival.NetworkEvent    `json:"network_events"`
+	Protocol        string                     `json:"protocol"`
+	Queries         []archival.DNSQueryEntry   `json:"queries"`
+	TCPConnect      []archival.TCPConnectEntry `json:"tcp_connect"`
+	Transport       string                     `json:"transport"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	config Config
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m *Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m *Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when the handshake failed. We do not flag WireGuard
+// failures without a configured private key, since the server does not
+// reply to unknown clients.
+func (m *Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if ok && tk.Protocol == protocolWireGuard && m.config.WireGuardPrivateKey == "" {
+		return false
+	}
+	return ok && tk.Failure != nil
+}
+
+type target struct {
+	address   string
+	protocol  string
+	publicKey []byte
+	transport string
+}
+
+func parseInput(input string) (*target, error) {
+	URL, err := url.Parse(input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidInput, err.Error())
+	}
+	out := &target{protocol: URL.Scheme}
+	var port string
+	switch URL.Scheme {
+	case protocolOpenVPN:
+		port, out.transport = "1194", URL.Query().Get("transport")
+		if out.transport == "" {
+			out.transport = transportUDP
+		}
+		if out.transport != transportTCP && out.transport != transportUDP {
+			return nil, fmt.Errorf("%w: unknown transport: %s", ErrInvalidInput, out.transport)
+		}
+	case protocolWireGuard:
+		port, out.transport = "51820", transportUDP
+		out.publicKey, err = decodeKey(URL.Query().Get("public_key"))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown protocol: %s", ErrInvalidInput, URL.Scheme)
+	}
+	if URL.Hostname() == "" {
+		return nil, fmt.Errorf("%w: missing host", ErrInvalidInput)
+	}
+	if URL.Port() != "" {
+		port = URL.Port()
+	}
+	out.address = net.JoinHostPort(URL.Hostname(), port)
+	return out, nil
+}
+
+func decodeKey(key string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(data) != wgKeySize {
+		return nil, ErrInvalidKey
+	}
+	return data, nil
+}
+
+// privateKey returns the configured WireGuard private key or
+// a random private key when the private key is not configured.
+func (m *Measurer) privateKey() ([]byte, error) {
+	if m.config.WireGuardPrivateKey != "" {
+		return decodeKey(m.config.WireGuardPrivateKey)
+	}
+	key := make([]byte, wgKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Run implements ExperimentMeasurer.Run.
+func (m *Measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	registerExtensions(measurement)
+	if measurement.Input == "" {
+		return ErrInputRequired
+	}
+	target, err := parseInput(string(measurement.Input))
+	if err != nil {
+		return err
+	}
+	privateKey, err := m.privateKey()
+	if err != nil {
+		return err
+	}
+	tk.Endpoint, tk.Protocol, tk.Transport = target.address, target.protocol, target.transport
+	saver := new(trace.Saver)
+	begin := time.Now()
+	defer func() {
+		events := saver.Read()
+		tk.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+		tk.Queries = archival.NewDNSQueriesList(begin, events, sess.ASNDatabasePath())
+		tk.TCPConnect = archival.NewTCPConnectList(begin, events)
+	}()
+	dialer := netx.NewDialer(netx.Config{
+		ContextByteCounting: true,
+		DialSaver:           saver,
+		Logger:              sess.Logger(),
+		ReadWriteSaver:      saver,
+		ResolveSaver:        saver,
+	})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err = func() error {
+		conn, err := dialer.DialContext(ctx, target.transport, target.address)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if target.protocol == protocolWireGuard {
+			err = wireGuardHandshake(ctx, conn, privateKey, target.publicKey)
+		} else {
+			err = openvpn.Handshake(ctx, conn, target.transport)
+		}
+		return errorx.SafeErrWrapperBuilder{
+			Error:     err,
+			Operation: target.protocol + "_handshake",
+		}.MaybeBuild()
+	}()
+	tk.Failure = archival.NewFailure(err)
+	tk.FailedOperation = archival.NewFailedOperation(err)
+	status := "ok"
+	if tk.Failure != nil {
+		status = *tk.Failure
+	}
+	callbacks.OnProgress(1, fmt.Sprintf("vpnhandshake: %s: %s", measurement.Input, status))
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &Measurer{config: config}
+}
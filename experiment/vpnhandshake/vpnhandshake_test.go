@@ -0,0 +1,262 @@
+package vpnhandshake_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/vpnhandshake"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := vpnhandshake.NewExperimentMeasurer(vpnhandshake.Config{})
+	if measurer.ExperimentName() != "vpn_handshake" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+func run(config vpnhandshake.Config, input string) (
+	*model.Measurement, model.ExperimentMeasurer, error) {
+	measurer := vpnhandshake.NewExperimentMeasurer(config)
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	return measurement, measurer, err
+}
+
+func newKey(t *testing.T) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// listenUDP starts a UDP server that replies to each packet using
+// reply and returns the server endpoint.
+func listenUDP(t *testing.T, reply func([]byte) []byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if data := reply(buffer[:count]); data != nil {
+				conn.WriteTo(data, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// wireGuardServer replies to an initiation with a handshake response
+// that acknowledges the sender index of the initiation.
+func wireGuardServer(packet []byte) []byte {
+	if len(packet) != 148 || packet[0] != 1 {
+		return nil
+	}
+	reply := make([]byte, 92)
+	reply[0] = 2
+	copy(reply[8:12], packet[4:8])
+	return reply
+}
+
+// openVPNServer replies to a client hard reset with a server hard
+// reset that acknowledges the client packet.
+func openVPNServer(packet []byte) []byte {
+	if len(packet) != 14 || packet[0]>>3 != 7 {
+		return nil
+	}
+	reply := []byte{8 << 3}
+	reply = append(reply, make([]byte, 8)...) // server session ID
+	reply = append(reply, 1, 0, 0, 0, 0)      // ack of packet ID zero
+	reply = append(reply, packet[1:9]...)     // client session ID
+	return append(reply, 0, 0, 0, 0)          // packet ID
+}
+
+func TestInputErrors(t *testing.T) {
+	var inputs = []struct {
+		input string
+		err   error
+	}{{
+		input: "",
+		err:   vpnhandshake.ErrInputRequired,
+	}, {
+		input: "\t",
+		err:   vpnhandshake.ErrInvalidInput,
+	}, {
+		input: "ipsec://127.0.0.1",
+		err:   vpnhandshake.ErrInvalidInput,
+	}, {
+		input: "openvpn://127.0.0.1?transport=sctp",
+		err:   vpnhandshake.ErrInvalidInput,
+	}, {
+		input: "openvpn://:1194",
+		err:   vpnhandshake.ErrInvalidInput,
+	}, {
+		input: "wireguard://127.0.0.1",
+		err:   vpnhandshake.ErrInvalidKey,
+	}}
+	for _, in := range inputs {
+		_, _, err := run(vpnhandshake.Config{}, in.input)
+		if !errors.Is(err, in.err) {
+			t.Fatal("not the error we expected", in.input, err)
+		}
+	}
+}
+
+func TestInvalidPrivateKey(t *testing.T) {
+	input := "wireguard://127.0.0.1?public_key=" + url.QueryEscape(newKey(t))
+	_, _, err := run(vpnhandshake.Config{WireGuardPrivateKey: "antani"}, input)
+	if !errors.Is(err, vpnhandshake.ErrInvalidKey) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestWireGuard(t *testing.T) {
+	endpoint := listenUDP(t, wireGuardServer)
+	input := "wireguard://" + endpoint + "?public_key=" + url.QueryEscape(newKey(t))
+	config := vpnhandshake.Config{WireGuardPrivateKey: newKey(t)}
+	measurement, measurer, err := run(config, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*vpnhandshake.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Endpoint != endpoint || tk.Protocol != "wireguard" || tk.Transport != "udp" {
+		t.Fatal("unexpected test keys")
+	}
+	if len(tk.NetworkEvents) <= 0 {
+		t.Fatal("expected network events")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+func TestWireGuardInvalidReply(t *testing.T) {
+	endpoint := listenUDP(t, func([]byte) []byte { return make([]byte, 92) })
+	input := "wireguard://" + endpoint + "?public_key=" + url.QueryEscape(newKey(t))
+	config := vpnhandshake.Config{WireGuardPrivateKey: newKey(t)}
+	measurement, measurer, err := run(config, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*vpnhandshake.TestKeys)
+	if tk.Failure == nil || *tk.Failure != "unknown_failure: vpnhandshake: invalid WireGuard reply" {
+		t.Fatal("not the failure we expected")
+	}
+	if *tk.FailedOperation != "wireguard_handshake" {
+		t.Fatal("not the failed operation we expected")
+	}
+	if !measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestWireGuardFailureWithoutPrivateKey(t *testing.T) {
+	endpoint := listenUDP(t, func([]byte) []byte { return make([]byte, 92) })
+	input := "wireguard://" + endpoint + "?public_key=" + url.QueryEscape(newKey(t))
+	measurement, measurer, err := run(vpnhandshake.Config{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*vpnhandshake.TestKeys)
+	if tk.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if measurer.(model.ExperimentAnomalyDetector).IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly without a private key")
+	}
+}
+
+func TestOpenVPNUDP(t *testing.T) {
+	endpoint := listenUDP(t, openVPNServer)
+	measurement, _, err := run(vpnhandshake.Config{}, "openvpn://"+endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*vpnhandshake.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Protocol != "openvpn" || tk.Transport != "udp" {
+		t.Fatal("unexpected test keys")
+	}
+}
+
+func TestOpenVPNTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		packet := make([]byte, 16)
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		reply := openVPNServer(packet[2:])
+		conn.Write(append([]byte{0, byte(len(reply))}, reply...))
+	}()
+	input := "openvpn://" + listener.Addr().String() + "?transport=tcp"
+	measurement, _, err := run(vpnhandshake.Config{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*vpnhandshake.TestKeys)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Transport != "tcp" || len(tk.TCPConnect) != 1 {
+		t.Fatal("unexpected test keys")
+	}
+}
+
+func TestOpenVPNConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close() // nobody is listening anymore
+	measurement, _, err := run(vpnhandshake.Config{}, "openvpn://"+address+"?transport=tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*vpnhandshake.TestKeys)
+	if tk.Failure == nil || *tk.Failure != "connection_refused" {
+		t.Fatal("not the failure we expected")
+	}
+	if *tk.FailedOperation != "connect" {
+		t.Fatal("not the failed operation we expected")
+	}
+}
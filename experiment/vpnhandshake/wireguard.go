@@ -0,0 +1,195 @@
+package vpnhandshake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// The following values are described in the WireGuard whitepaper in
+// the section about the first handshake message.
+const (
+	wgConstruction = "Noise_IKpsk2_25519_ChaChaPoly_BLAKE2s"
+	wgIdentifier   = "WireGuard v1 zx2c4 Jason@zx2c4.com"
+	wgLabelMAC1    = "mac1----"
+
+	wgMessageInitiation  = 1
+	wgMessageResponse    = 2
+	wgMessageCookieReply = 3
+	wgInitiationSize     = 148
+	wgResponseSize       = 92
+	wgCookieReplySize    = 64
+	wgKeySize            = 32
+	wgTAI64NBase         = 0x400000000000000a
+)
+
+// ErrWireGuardHandshake indicates that the server did not reply
+// with a valid WireGuard handshake response.
+var ErrWireGuardHandshake = errors.New("vpnhandshake: invalid WireGuard reply")
+
+// wgInitiation is a WireGuard handshake initiation message.
+type wgInitiation struct {
+	message     []byte
+	senderIndex uint32
+}
+
+// newWGInitiation creates a new handshake initiation message from the
+// client private key to the server public key.
+func newWGInitiation(privateKey, serverPublicKey []byte) (*wgInitiation, error) {
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPrivate := make([]byte, wgKeySize)
+	if _, err := rand.Read(ephemeralPrivate); err != nil {
+		return nil, err
+	}
+	ephemeralPublic, err := curve25519.X25519(ephemeralPrivate, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	var senderIndex [4]byte
+	if _, err := rand.Read(senderIndex[:]); err != nil {
+		return nil, err
+	}
+	message := make([]byte, 0, wgInitiationSize)
+	message = append(message, wgMessageInitiation, 0, 0, 0)
+	message = append(message, senderIndex[:]...)
+	chainKey := wgHash([]byte(wgConstruction))
+	hashValue := wgHash(chainKey, []byte(wgIdentifier))
+	hashValue = wgHash(hashValue, serverPublicKey)
+	chainKey = wgKDF1(chainKey, ephemeralPublic)
+	message = append(message, ephemeralPublic...)
+	hashValue = wgHash(hashValue, ephemeralPublic)
+	shared, err := curve25519.X25519(ephemeralPrivate, serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	chainKey, key := wgKDF2(chainKey, shared)
+	static, err := wgSeal(key, publicKey, hashValue)
+	if err != nil {
+		return nil, err
+	}
+	message = append(message, static...)
+	hashValue = wgHash(hashValue, static)
+	shared, err = curve25519.X25519(privateKey, serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	_, key = wgKDF2(chainKey, shared)
+	timestamp, err := wgSeal(key, wgTAI64N(time.Now()), hashValue)
+	if err != nil {
+		return nil, err
+	}
+	message = append(message, timestamp...)
+	mac1Key := wgHash([]byte(wgLabelMAC1), serverPublicKey)
+	message = append(message, wgMAC(mac1Key, message)...)
+	// We don't have a cookie, therefore mac2 is all zeroes.
+	message = append(message, make([]byte, blake2s.Size128)...)
+	return &wgInitiation{
+		message:     message,
+		senderIndex: binary.LittleEndian.Uint32(senderIndex[:]),
+	}, nil
+}
+
+// wireGuardHandshake sends a handshake initiation and waits for the
+// handshake response. We also accept a cookie reply, which the server
+// sends when under load, because it still proves that the server is
+// reachable and speaks WireGuard.
+func wireGuardHandshake(
+	ctx context.Context, conn net.Conn, privateKey, serverPublicKey []byte) error {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+	initiation, err := newWGInitiation(privateKey, serverPublicKey)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(initiation.message); err != nil {
+		return err
+	}
+	reply := make([]byte, 1024)
+	count, err := conn.Read(reply)
+	if err != nil {
+		return err
+	}
+	reply = reply[:count]
+	switch {
+	case count == wgResponseSize && reply[0] == wgMessageResponse:
+		if binary.LittleEndian.Uint32(reply[8:12]) != initiation.senderIndex {
+			return ErrWireGuardHandshake
+		}
+	case count == wgCookieReplySize && reply[0] == wgMessageCookieReply:
+		if binary.LittleEndian.Uint32(reply[4:8]) != initiation.senderIndex {
+			return ErrWireGuardHandshake
+		}
+	default:
+		return ErrWireGuardHandshake
+	}
+	return nil
+}
+
+func newBLAKE2s() hash.Hash {
+	h, _ := blake2s.New256(nil) // cannot fail without a key
+	return h
+}
+
+func wgHash(inputs ...[]byte) []byte {
+	h := newBLAKE2s()
+	for _, input := range inputs {
+		h.Write(input)
+	}
+	return h.Sum(nil)
+}
+
+func wgHMAC(key []byte, inputs ...[]byte) []byte {
+	h := hmac.New(newBLAKE2s, key)
+	for _, input := range inputs {
+		h.Write(input)
+	}
+	return h.Sum(nil)
+}
+
+func wgKDF1(key, input []byte) []byte {
+	t0 := wgHMAC(key, input)
+	return wgHMAC(t0, []byte{0x1})
+}
+
+func wgKDF2(key, input []byte) ([]byte, []byte) {
+	t0 := wgHMAC(key, input)
+	t1 := wgHMAC(t0, []byte{0x1})
+	return t1, wgHMAC(t0, t1, []byte{0x2})
+}
+
+func wgMAC(key, input []byte) []byte {
+	h, _ := blake2s.New128(key) // cannot fail with a 32 bytes key
+	h.Write(input)
+	return h.Sum(nil)
+}
+
+// wgSeal encrypts plaintext using a zero nonce, which is fine because
+// each key is only used once during the handshake.
+func wgSeal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+func wgTAI64N(t time.Time) []byte {
+	out := make([]byte, 12)
+	binary.BigEndian.PutUint64(out[:8], wgTAI64NBase+uint64(t.Unix()))
+	binary.BigEndian.PutUint32(out[8:], uint32(t.Nanosecond()))
+	return out
+}
@@ -0,0 +1,77 @@
+package vpnhandshake
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+func newKeyPair(t *testing.T) ([]byte, []byte) {
+	private := make([]byte, wgKeySize)
+	if _, err := rand.Read(private); err != nil {
+		t.Fatal(err)
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, public
+}
+
+func wgOpen(t *testing.T, key, ciphertext, additionalData []byte) []byte {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plaintext
+}
+
+// TestWGInitiation processes the initiation message like a
+// WireGuard server would do and checks the outcome.
+func TestWGInitiation(t *testing.T) {
+	clientPrivate, clientPublic := newKeyPair(t)
+	serverPrivate, serverPublic := newKeyPair(t)
+	initiation, err := newWGInitiation(clientPrivate, serverPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := initiation.message
+	if len(message) != wgInitiationSize || message[0] != wgMessageInitiation {
+		t.Fatal("invalid message header")
+	}
+	mac1Key := wgHash([]byte(wgLabelMAC1), serverPublic)
+	if !bytes.Equal(wgMAC(mac1Key, message[:116]), message[116:132]) {
+		t.Fatal("invalid mac1")
+	}
+	ephemeral, static, timestamp := message[8:40], message[40:88], message[88:116]
+	chainKey := wgHash([]byte(wgConstruction))
+	hashValue := wgHash(chainKey, []byte(wgIdentifier))
+	hashValue = wgHash(hashValue, serverPublic)
+	chainKey = wgKDF1(chainKey, ephemeral)
+	hashValue = wgHash(hashValue, ephemeral)
+	shared, err := curve25519.X25519(serverPrivate, ephemeral)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainKey, key := wgKDF2(chainKey, shared)
+	if !bytes.Equal(wgOpen(t, key, static, hashValue), clientPublic) {
+		t.Fatal("unexpected client public key")
+	}
+	hashValue = wgHash(hashValue, static)
+	shared, err = curve25519.X25519(serverPrivate, clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key = wgKDF2(chainKey, shared)
+	if len(wgOpen(t, key, timestamp, hashValue)) != 12 {
+		t.Fatal("unexpected timestamp length")
+	}
+}
@@ -2,9 +2,14 @@ package webconnectivity
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
 
 	"github.com/ooni/probe-engine/geolocate"
 	"github.com/ooni/probe-engine/internal/httpx"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/errorx"
 )
@@ -48,10 +53,24 @@ type ControlResponse struct {
 	DNS         ControlDNSResult                   `json:"dns"`
 }
 
-// Control performs the control request and returns the response.
+// controlCacheTTL is how long we reuse the control response for the same
+// request, e.g., when several experiments measure the same URL in a row.
+const controlCacheTTL = 10 * time.Minute
+
+// Control performs the control request and returns the response. We
+// reuse the response to the same request for controlCacheTTL, using the
+// "cache" namespace of the session's key-value store.
 func Control(
 	ctx context.Context, sess model.ExperimentSession,
 	thAddr string, creq ControlRequest) (out ControlResponse, err error) {
+	cache := kvstore.NewTTLKeyValueStore(
+		kvstore.WithNamespace(sess.KeyValueStore(), "cache"))
+	key := controlCacheKey(thAddr, creq)
+	if data, err := cache.GetFresh(key); err == nil && json.Unmarshal(data, &out) == nil {
+		sess.Logger().Infof("control %s... cached", creq.HTTPRequest)
+		(&out.DNS).FillASNs(sess)
+		return out, nil
+	}
 	clnt := httpx.Client{
 		BaseURL:    thAddr,
 		HTTPClient: sess.DefaultHTTPClient(),
@@ -64,10 +83,21 @@ func Control(
 		Operation: errorx.TopLevelOperation,
 	}.MaybeBuild()
 	sess.Logger().Infof("control %s... %+v", creq.HTTPRequest, err)
+	if err == nil {
+		if data, err := json.Marshal(out); err == nil {
+			cache.SetWithTTL(key, data, controlCacheTTL)
+		}
+	}
 	(&out.DNS).FillASNs(sess)
 	return
 }
 
+func controlCacheKey(thAddr string, creq ControlRequest) string {
+	data, _ := json.Marshal(creq)
+	digest := sha256.Sum256(append([]byte(thAddr+" "), data...))
+	return "webconnectivity.control." + hex.EncodeToString(digest[:])
+}
+
 // FillASNs fills the ASNs array of ControlDNSResult. For each Addr inside
 // of the ControlDNSResult structure, we obtain the corresponding ASN.
 //
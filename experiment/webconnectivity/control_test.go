@@ -1,11 +1,18 @@
 package webconnectivity_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
+	"github.com/apex/log"
 	"github.com/google/go-cmp/cmp"
 	"github.com/ooni/probe-engine/experiment/webconnectivity"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
 )
 
 func TestFillASNsEmpty(t *testing.T) {
@@ -34,3 +41,42 @@ func TestFillASNsSuccess(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+// storeSession is a session using the same key-value store.
+type storeSession struct {
+	*mockable.ExperimentSession
+	store model.KeyValueStore
+}
+
+func (sess *storeSession) KeyValueStore() model.KeyValueStore {
+	return sess.store
+}
+
+func TestControlUsesCache(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"http_request":{"status_code":200}}`))
+	}))
+	defer server.Close()
+	sess := &storeSession{
+		ExperimentSession: &mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+		},
+		store: kvstore.NewMemoryKeyValueStore(),
+	}
+	for _, URL := range []string{"http://x.org", "http://x.org", "http://y.org"} {
+		creq := webconnectivity.ControlRequest{HTTPRequest: URL}
+		out, err := webconnectivity.Control(context.Background(), sess, server.URL, creq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.HTTPRequest.StatusCode != 200 {
+			t.Fatal("not the response we expected")
+		}
+	}
+	if requests != 2 {
+		t.Fatal("did not use the cache", requests)
+	}
+}
@@ -6,6 +6,7 @@ package webconnectivity
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/url"
 	"strconv"
@@ -27,6 +28,7 @@ type Config struct{}
 
 // TestKeys contains webconnectivity test keys.
 type TestKeys struct {
+	model.MeasurementDataUsage
 	Agent          string  `json:"agent"`
 	ClientResolver string  `json:"client_resolver"`
 	Retries        *int64  `json:"retries"`    // unused
@@ -76,7 +78,27 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag a measurement as anomalous when we think the URL is not accessible.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.Accessible != nil && *tk.Accessible == false
+}
+
+// HardFailure implements model.ExperimentFailureDetector. We fail
+// hard when we cannot talk with the test helper.
+func (m Measurer) HardFailure(measurement *model.Measurement) error {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok || tk.ControlFailure == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrControlFailure, *tk.ControlFailure)
+}
+
 var (
+	// ErrControlFailure indicates that the control measurement failed.
+	ErrControlFailure = errors.New("control measurement failed")
+
 	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
 	ErrNoAvailableTestHelpers = errors.New("no available helpers")
 
@@ -114,15 +136,8 @@ func (m Measurer) Run(
 		return ErrUnsupportedInput
 	}
 	// 1. find test helper
-	testhelpers, _ := sess.GetTestHelpersByName("web-connectivity")
-	var testhelper *model.Service
-	for _, th := range testhelpers {
-		if th.Type == "https" {
-			testhelper = &th
-			break
-		}
-	}
-	if testhelper == nil {
+	testhelper, ok := sess.GetBestTestHelper(ctx, "web-connectivity")
+	if !ok || testhelper.Type != "https" {
 		return ErrNoAvailableTestHelpers
 	}
 	measurement.TestHelpers = map[string]interface{}{
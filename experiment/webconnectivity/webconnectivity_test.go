@@ -352,3 +352,53 @@ func TestComputeTCPBlocking(t *testing.T) {
 		})
 	}
 }
+
+func TestHardFailure(t *testing.T) {
+	measurer := webconnectivity.Measurer{}
+	failure := "connection_refused"
+	measurement := &model.Measurement{TestKeys: &webconnectivity.TestKeys{
+		ControlFailure: &failure,
+	}}
+	if err := measurer.HardFailure(measurement); !errors.Is(err, webconnectivity.ErrControlFailure) {
+		t.Fatal("not the error we expected", err)
+	}
+	measurement.TestKeys = &webconnectivity.TestKeys{}
+	if err := measurer.HardFailure(measurement); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsAnomaly(t *testing.T) {
+	accessible, inaccessible := true, false
+	var tests = []struct {
+		name     string
+		testKeys interface{}
+		expect   bool
+	}{{
+		name:     "with unexpected test keys",
+		testKeys: map[string]interface{}{},
+	}, {
+		name:     "with failed measurement",
+		testKeys: &webconnectivity.TestKeys{},
+	}, {
+		name: "with accessible URL",
+		testKeys: &webconnectivity.TestKeys{Summary: webconnectivity.Summary{
+			Accessible: &accessible,
+		}},
+	}, {
+		name: "with inaccessible URL",
+		testKeys: &webconnectivity.TestKeys{Summary: webconnectivity.Summary{
+			Accessible: &inaccessible,
+		}},
+		expect: true,
+	}}
+	measurer := webconnectivity.Measurer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			measurement := &model.Measurement{TestKeys: tt.testKeys}
+			if measurer.IsAnomaly(measurement) != tt.expect {
+				t.Fatal("not the result we expected")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package webconnectivityh3
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/Psiphon-Labs/quic-go"
+	"github.com/Psiphon-Labs/quic-go/http3"
+	"github.com/ooni/probe-engine/netx"
+)
+
+// errNoAddresses indicates that resolving a host returned no addresses.
+var errNoAddresses = errors.New("webconnectivityh3: no addresses")
+
+// NewHTTP3Transport creates the default HTTP/3 transport. When we connect
+// to domain, we use the first of the addresses that the probe resolved, so
+// that we do not perform another DNS lookup. We create the UDP sockets
+// using netx, so we account for the bytes we send and receive, hence the
+// data used by HTTP/3 counts against the session data cap.
+//
+// We use the QUIC implementation that Psiphon already pulls into our
+// dependency tree. It speaks draft-24 of QUIC (ALPN "h3-24"), hence it
+// will fail with servers only supporting newer QUIC versions. This is
+// why we only flag as anomalies the failures with servers for which the
+// control has seen an Alt-Svc header advertising HTTP/3.
+func NewHTTP3Transport(ctx context.Context, domain string, addresses []string) Transport {
+	txp := &http3Transport{}
+	listener := netx.NewPacketListener(netx.Config{ContextByteCounting: true})
+	resolver := netx.NewResolver(netx.Config{})
+	txp.RoundTripper = &http3.RoundTripper{
+		Dial: func(network, address string, tlsConfig *tls.Config,
+			config *quic.Config) (quic.Session, error) {
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			ip := host
+			if host == domain && len(addresses) > 0 {
+				ip = addresses[0]
+			} else if net.ParseIP(host) == nil {
+				addrs, err := resolver.LookupHost(ctx, host)
+				if err != nil {
+					return nil, err
+				}
+				if len(addrs) <= 0 {
+					return nil, errNoAddresses
+				}
+				ip = addrs[0]
+			}
+			udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip, port))
+			if err != nil {
+				return nil, err
+			}
+			pconn, err := listener.ListenPacket(ctx, "udp")
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig = tlsConfig.Clone()
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = host
+			}
+			sess, err := quic.DialContext(ctx, pconn, udpAddr, host, tlsConfig, config)
+			if err != nil {
+				pconn.Close()
+				return nil, err
+			}
+			txp.track(pconn)
+			return sess, nil
+		},
+		QuicConfig:      &quic.Config{HandshakeTimeout: 10 * time.Second},
+		TLSClientConfig: &tls.Config{RootCAs: netx.CertPool},
+	}
+	return txp
+}
+
+// http3Transport adapts http3.RoundTripper to Transport. Because we
+// create the UDP sockets, quic-go does not close them, so we do that
+// when closing the idle connections.
+type http3Transport struct {
+	*http3.RoundTripper
+	mu     sync.Mutex
+	pconns []net.PacketConn
+}
+
+func (txp *http3Transport) track(pconn net.PacketConn) {
+	txp.mu.Lock()
+	defer txp.mu.Unlock()
+	txp.pconns = append(txp.pconns, pconn)
+}
+
+// CloseIdleConnections implements Transport.CloseIdleConnections.
+func (txp *http3Transport) CloseIdleConnections() {
+	txp.RoundTripper.Close()
+	txp.mu.Lock()
+	defer txp.mu.Unlock()
+	for _, pconn := range txp.pconns {
+		pconn.Close()
+	}
+	txp.pconns = nil
+}
@@ -0,0 +1,317 @@
+// Package webconnectivityh3 contains the HTTP/3 variant of Web Connectivity.
+//
+// This experiment resolves the domain of the input URL, asks the Web
+// Connectivity test helper to fetch the URL as a control, and fetches the
+// URL using HTTP/3. Then, it compares the HTTP/3 measurement with the
+// control using the same analysis code used by Web Connectivity. Since the
+// control fetches the URL using TCP, the server may not support HTTP/3,
+// hence we only flag a measurement as anomalous when the response seen by
+// the control contains an Alt-Svc header advertising HTTP/3 (see the
+// ControlAltSvcH3 test key).
+package webconnectivityh3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/experiment/webconnectivity"
+	"github.com/ooni/probe-engine/internal/httpheader"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "web_connectivity_h3"
+	testVersion = "0.1.0"
+)
+
+// Config contains the experiment config.
+type Config struct{}
+
+// TestKeys contains the experiment test keys. The meaning of the
+// fields is the same of the Web Connectivity test keys, except that
+// requests contains the HTTP/3 requests.
+type TestKeys struct {
+	model.MeasurementDataUsage
+	Agent          string `json:"agent"`
+	ClientResolver string `json:"client_resolver"`
+
+	// DNS experiment
+	Queries              []archival.DNSQueryEntry `json:"queries"`
+	DNSExperimentFailure *string                  `json:"dns_experiment_failure"`
+	webconnectivity.DNSAnalysisResult
+
+	// Control experiment
+	ControlFailure  *string                         `json:"control_failure"`
+	Control         webconnectivity.ControlResponse `json:"control"`
+	ControlAltSvcH3 bool                            `json:"control_alt_svc_h3"`
+
+	// HTTP/3 experiment
+	Requests              []archival.RequestEntry `json:"requests"`
+	HTTPExperimentFailure *string                 `json:"http_experiment_failure"`
+	webconnectivity.HTTPAnalysisResult
+
+	// Top-level analysis
+	webconnectivity.Summary
+}
+
+// Transport is the definition of http.RoundTripper used by this package.
+type Transport interface {
+	RoundTrip(req *http.Request) (*http.Response, error)
+	CloseIdleConnections()
+}
+
+// Measurer performs the measurement.
+type Measurer struct {
+	Config       Config
+	NewTransport func(ctx context.Context, domain string, addresses []string) Transport // for testing
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return Measurer{Config: config}
+}
+
+// ExperimentName implements ExperimentMeasurer.ExperimentName.
+func (m Measurer) ExperimentName() string {
+	return testName
+}
+
+// ExperimentVersion implements ExperimentMeasurer.ExperimentVersion.
+func (m Measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag a measurement as anomalous when we think the URL is not accessible
+// and the control has seen that the server advertises HTTP/3.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && tk.ControlAltSvcH3 && tk.Accessible != nil && *tk.Accessible == false
+}
+
+// HardFailure implements model.ExperimentFailureDetector. We fail
+// hard when we cannot talk with the test helper.
+func (m Measurer) HardFailure(measurement *model.Measurement) error {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	if !ok || tk.ControlFailure == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", webconnectivity.ErrControlFailure, *tk.ControlFailure)
+}
+
+// AdvertisesHTTP3 returns whether headers contain an Alt-Svc header
+// advertising any version of HTTP/3 (e.g., `h3=":443"; ma=86400`).
+func AdvertisesHTTP3(headers map[string]string) bool {
+	for key, value := range headers {
+		if !strings.EqualFold(key, "Alt-Svc") {
+			continue
+		}
+		for _, entry := range strings.Split(value, ",") {
+			protocol := strings.SplitN(strings.TrimSpace(entry), "=", 2)[0]
+			if protocol == "h3" || strings.HasPrefix(protocol, "h3-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
+	ErrNoAvailableTestHelpers = errors.New("webconnectivityh3: no available helpers")
+
+	// ErrNoInput indicates that no input was provided.
+	ErrNoInput = errors.New("webconnectivityh3: no input provided")
+
+	// ErrInputIsNotAnURL indicates that the input is not an URL.
+	ErrInputIsNotAnURL = errors.New("webconnectivityh3: input is not an URL")
+
+	// ErrUnsupportedInput indicates that the input URL scheme is unsupported. We
+	// only support HTTPS, because HTTP/3 always uses TLS.
+	ErrUnsupportedInput = errors.New("webconnectivityh3: unsupported input scheme")
+)
+
+// Run implements ExperimentMeasurer.Run.
+func (m Measurer) Run(
+	ctx context.Context,
+	sess model.ExperimentSession,
+	measurement *model.Measurement,
+	callbacks model.ExperimentCallbacks,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	tk.Agent = "redirect"
+	tk.ClientResolver = sess.ResolverIP()
+	if measurement.Input == "" {
+		return ErrNoInput
+	}
+	URL, err := url.Parse(string(measurement.Input))
+	if err != nil {
+		return ErrInputIsNotAnURL
+	}
+	if URL.Scheme != "https" {
+		return ErrUnsupportedInput
+	}
+	// 1. find test helper
+	testhelper, ok := sess.GetBestTestHelper(ctx, "web-connectivity")
+	if !ok || testhelper.Type != "https" {
+		return ErrNoAvailableTestHelpers
+	}
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": testhelper,
+	}
+	// 2. perform the DNS lookup step
+	dnsResult := webconnectivity.DNSLookup(ctx, webconnectivity.DNSLookupConfig{
+		Session: sess, URL: URL})
+	tk.Queries = append(tk.Queries, dnsResult.TestKeys.Queries...)
+	tk.DNSExperimentFailure = dnsResult.Failure
+	epnts := webconnectivity.NewEndpoints(URL, dnsResult.Addresses())
+	callbacks.OnProgress(0.25, "dns lookup done")
+	// 3. perform the control measurement
+	headers := map[string][]string{
+		"Accept":          {httpheader.Accept()},
+		"Accept-Language": {httpheader.AcceptLanguage()},
+		"User-Agent":      {httpheader.UserAgent()},
+	}
+	tk.Control, err = webconnectivity.Control(ctx, sess, testhelper.Address,
+		webconnectivity.ControlRequest{
+			HTTPRequest:        URL.String(),
+			HTTPRequestHeaders: headers,
+			TCPConnect:         epnts.Endpoints(),
+		})
+	tk.ControlFailure = archival.NewFailure(err)
+	tk.ControlAltSvcH3 = AdvertisesHTTP3(tk.Control.HTTPRequest.Headers)
+	callbacks.OnProgress(0.50, "control done")
+	// 4. analyze DNS results
+	if tk.ControlFailure == nil {
+		tk.DNSAnalysisResult = webconnectivity.DNSAnalysis(URL, dnsResult, tk.Control)
+	}
+	if tk.DNSConsistency != nil {
+		sess.Logger().Infof("DNS analysis result: %s", *tk.DNSConsistency)
+	}
+	// 5. perform the HTTP/3 measurement
+	addresses := dnsResult.Addresses()
+	if net.ParseIP(URL.Hostname()) != nil {
+		addresses = []string{URL.Hostname()}
+	}
+	if len(addresses) > 0 {
+		newTransport := m.NewTransport
+		if newTransport == nil {
+			newTransport = NewHTTP3Transport
+		}
+		txp := newTransport(ctx, URL.Hostname(), addresses)
+		defer txp.CloseIdleConnections()
+		tk.Requests, err = httpGet(ctx, sess.Logger(), txp, URL, headers)
+		tk.HTTPExperimentFailure = archival.NewFailure(err)
+	}
+	callbacks.OnProgress(0.75, "HTTP/3 request done")
+	// 6. compare HTTP/3 measurement to control
+	tk.HTTPAnalysisResult = webconnectivity.HTTPAnalysis(
+		urlgetter.TestKeys{Requests: tk.Requests}, tk.Control)
+	tk.HTTPAnalysisResult.Log(sess.Logger())
+	tk.Summary = Summarize(tk)
+	tk.Summary.Log(sess.Logger())
+	callbacks.OnProgress(1.00, "analysis done")
+	return nil
+}
+
+// httpGet fetches URL using HTTP/3 and returns the requests.
+func httpGet(ctx context.Context, logger model.Logger, txp Transport,
+	URL *url.URL, headers map[string][]string) ([]archival.RequestEntry, error) {
+	saver := new(trace.Saver)
+	var rt httptransport.RoundTripper = errorWrapperTransport{Transport: txp}
+	rt = httptransport.LoggingTransport{Logger: logger, RoundTripper: rt}
+	rt = httptransport.SaverMetadataHTTPTransport{RoundTripper: rt, Saver: saver}
+	rt = httptransport.SaverBodyHTTPTransport{RoundTripper: rt, Saver: saver}
+	rt = httptransport.SaverPerformanceHTTPTransport{RoundTripper: rt, Saver: saver}
+	rt = httptransport.SaverTransactionHTTPTransport{RoundTripper: rt, Saver: saver}
+	begin := time.Now()
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", URL.String(), nil)
+		if err != nil {
+			return err
+		}
+		for key, values := range headers {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+		resp, err := (&http.Client{Transport: rt}).Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = ioutil.ReadAll(resp.Body)
+		return err
+	}()
+	return archival.NewRequestList(begin, saver.Read()), err
+}
+
+// errorWrapperTransport wraps the errors returned by the HTTP/3 transport
+// to be OONI errors. QUIC errors are not known by errorx, so we handle
+// the case where the QUIC error is a timeout here.
+type errorWrapperTransport struct {
+	Transport
+}
+
+// RoundTrip implements Transport.RoundTrip
+func (txp errorWrapperTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := txp.Transport.RoundTrip(req)
+	if err != nil {
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			err = &errorx.ErrWrapper{
+				Failure:    errorx.FailureGenericTimeoutError,
+				Operation:  errorx.HTTPRoundTripOperation,
+				WrappedErr: err,
+			}
+		}
+		err = errorx.SafeErrWrapperBuilder{
+			Error:     err,
+			Operation: errorx.HTTPRoundTripOperation,
+		}.MaybeBuild()
+	}
+	return resp, err
+}
+
+// Summarize computes the summary from the TestKeys using the same
+// algorithm used by Web Connectivity. We rename the blocking reasons
+// caused by the HTTP experiment to highlight that they are specific
+// of HTTP/3 and hence of the QUIC transport.
+func Summarize(tk *TestKeys) webconnectivity.Summary {
+	out := webconnectivity.Summarize(&webconnectivity.TestKeys{
+		DNSExperimentFailure:  tk.DNSExperimentFailure,
+		DNSAnalysisResult:     tk.DNSAnalysisResult,
+		ControlFailure:        tk.ControlFailure,
+		Control:               tk.Control,
+		Requests:              tk.Requests,
+		HTTPExperimentFailure: tk.HTTPExperimentFailure,
+		HTTPAnalysisResult:    tk.HTTPAnalysisResult,
+	})
+	if out.BlockingReason != nil {
+		reason := *out.BlockingReason
+		switch reason {
+		case "http-diff":
+			reason = "http3-diff"
+		case "http-failure":
+			reason = "http3-failure"
+		}
+		out.BlockingReason = &reason
+	}
+	out.Blocking = webconnectivity.DetermineBlocking(out)
+	return out
+}
@@ -0,0 +1,247 @@
+package webconnectivityh3_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/webconnectivity"
+	"github.com/ooni/probe-engine/experiment/webconnectivityh3"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+const webpage = "<html><head><title>Antani</title></head><body>Mascetti</body></html>"
+
+func TestMeasurerExperimentNameVersion(t *testing.T) {
+	measurer := webconnectivityh3.NewExperimentMeasurer(webconnectivityh3.Config{})
+	if measurer.ExperimentName() != "web_connectivity_h3" {
+		t.Fatal("unexpected ExperimentName")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected ExperimentVersion")
+	}
+}
+
+// startControl starts a fake Web Connectivity test helper that always
+// returns the given response.
+func startControl(t *testing.T, resp webconnectivity.ControlResponse) string {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func newsession(controlURL string) *mockable.ExperimentSession {
+	return &mockable.ExperimentSession{
+		MockableHTTPClient: http.DefaultClient,
+		MockableLogger:     log.Log,
+		MockableTestHelpers: map[string][]model.Service{
+			"web-connectivity": {{Address: controlURL, Type: "https"}},
+		},
+	}
+}
+
+func run(measurer webconnectivityh3.Measurer, sess model.ExperimentSession,
+	input string) (*model.Measurement, error) {
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		context.Background(), sess, measurement, model.NewPrinterCallbacks(log.Log))
+	return measurement, err
+}
+
+func TestInputErrors(t *testing.T) {
+	var inputs = []struct {
+		input string
+		err   error
+	}{{
+		input: "",
+		err:   webconnectivityh3.ErrNoInput,
+	}, {
+		input: "\t",
+		err:   webconnectivityh3.ErrInputIsNotAnURL,
+	}, {
+		input: "http://www.example.com/",
+		err:   webconnectivityh3.ErrUnsupportedInput,
+	}}
+	for _, in := range inputs {
+		_, err := run(webconnectivityh3.Measurer{}, newsession(""), in.input)
+		if !errors.Is(err, in.err) {
+			t.Fatal("not the error we expected", err)
+		}
+	}
+}
+
+func TestNoAvailableTestHelpers(t *testing.T) {
+	sess := &mockable.ExperimentSession{MockableLogger: log.Log}
+	_, err := run(webconnectivityh3.Measurer{}, sess, "https://www.example.com/")
+	if !errors.Is(err, webconnectivityh3.ErrNoAvailableTestHelpers) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestAccessible(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(webpage))
+		}))
+	defer server.Close()
+	controlURL := startControl(t, webconnectivity.ControlResponse{
+		HTTPRequest: webconnectivity.ControlHTTPRequestResult{
+			BodyLength: int64(len(webpage)),
+			StatusCode: 200,
+			Title:      "Antani",
+		},
+		DNS: webconnectivity.ControlDNSResult{Addrs: []string{"127.0.0.1"}},
+	})
+	var domains []string
+	measurer := webconnectivityh3.Measurer{
+		NewTransport: func(ctx context.Context, domain string, addresses []string) webconnectivityh3.Transport {
+			domains = append(domains, domain)
+			return server.Client().Transport.(*http.Transport)
+		},
+	}
+	measurement, err := run(measurer, newsession(controlURL), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*webconnectivityh3.TestKeys)
+	if tk.ControlFailure != nil {
+		t.Fatal(*tk.ControlFailure)
+	}
+	if tk.HTTPExperimentFailure != nil {
+		t.Fatal(*tk.HTTPExperimentFailure)
+	}
+	if len(domains) != 1 || domains[0] != "127.0.0.1" {
+		t.Fatal("unexpected domains")
+	}
+	if len(tk.Requests) != 1 || tk.Requests[0].Response.Body.Value != webpage {
+		t.Fatal("unexpected requests")
+	}
+	if tk.Accessible == nil || *tk.Accessible != true || tk.Blocking != false {
+		t.Fatal("expected the website to be accessible")
+	}
+	if measurer.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "Handshake did not complete in time" }
+func (timeoutError) Temporary() bool { return false }
+func (timeoutError) Timeout() bool   { return true }
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, timeoutError{}
+}
+
+func (failingTransport) CloseIdleConnections() {}
+
+func TestHTTP3Failure(t *testing.T) {
+	controlURL := startControl(t, webconnectivity.ControlResponse{
+		HTTPRequest: webconnectivity.ControlHTTPRequestResult{
+			BodyLength: int64(len(webpage)),
+			Headers:    map[string]string{"Alt-Svc": `h3-29=":443"; ma=86400`},
+			StatusCode: 200,
+		},
+		DNS: webconnectivity.ControlDNSResult{Addrs: []string{"127.0.0.1"}},
+	})
+	measurer := webconnectivityh3.Measurer{
+		NewTransport: func(ctx context.Context, domain string, addresses []string) webconnectivityh3.Transport {
+			return failingTransport{}
+		},
+	}
+	measurement, err := run(measurer, newsession(controlURL), "https://127.0.0.1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*webconnectivityh3.TestKeys)
+	if tk.HTTPExperimentFailure == nil || *tk.HTTPExperimentFailure != "generic_timeout_error" {
+		t.Fatal("not the failure we expected")
+	}
+	if tk.Accessible == nil || *tk.Accessible != false {
+		t.Fatal("expected the website to be inaccessible")
+	}
+	if tk.BlockingReason == nil || *tk.BlockingReason != "http3-failure" {
+		t.Fatal("unexpected blocking reason")
+	}
+	if !measurer.IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+}
+
+func TestHTTP3FailureWithoutAltSvc(t *testing.T) {
+	controlURL := startControl(t, webconnectivity.ControlResponse{
+		HTTPRequest: webconnectivity.ControlHTTPRequestResult{
+			BodyLength: int64(len(webpage)),
+			StatusCode: 200,
+		},
+		DNS: webconnectivity.ControlDNSResult{Addrs: []string{"127.0.0.1"}},
+	})
+	measurer := webconnectivityh3.Measurer{
+		NewTransport: func(ctx context.Context, domain string, addresses []string) webconnectivityh3.Transport {
+			return failingTransport{}
+		},
+	}
+	measurement, err := run(measurer, newsession(controlURL), "https://127.0.0.1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*webconnectivityh3.TestKeys)
+	if tk.ControlAltSvcH3 {
+		t.Fatal("the control did not see Alt-Svc")
+	}
+	if measurer.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly without Alt-Svc")
+	}
+}
+
+func TestAdvertisesHTTP3(t *testing.T) {
+	var inputs = []struct {
+		headers  map[string]string
+		expected bool
+	}{
+		{headers: nil, expected: false},
+		{headers: map[string]string{"Alt-Svc": `h2=":443"`}, expected: false},
+		{headers: map[string]string{"alt-svc": `h3=":443"; ma=86400`}, expected: true},
+		{headers: map[string]string{"Alt-Svc": `h2=":443", h3-24=":443"`}, expected: true},
+		{headers: map[string]string{"Alt-Svc": `clear`}, expected: false},
+	}
+	for _, in := range inputs {
+		if webconnectivityh3.AdvertisesHTTP3(in.headers) != in.expected {
+			t.Fatal("unexpected result for", in.headers)
+		}
+	}
+}
+
+func TestSummarizeHTTPDiff(t *testing.T) {
+	falseValue := false
+	consistent := webconnectivity.DNSConsistent
+	tk := &webconnectivityh3.TestKeys{
+		DNSAnalysisResult: webconnectivity.DNSAnalysisResult{DNSConsistency: &consistent},
+		Requests: []archival.RequestEntry{{
+			Request: archival.HTTPRequest{URL: "http://www.example.com/"},
+		}},
+		HTTPAnalysisResult: webconnectivity.HTTPAnalysisResult{
+			StatusCodeMatch: &falseValue,
+		},
+	}
+	summary := webconnectivityh3.Summarize(tk)
+	if summary.BlockingReason == nil || *summary.BlockingReason != "http3-diff" {
+		t.Fatal("unexpected blocking reason")
+	}
+}
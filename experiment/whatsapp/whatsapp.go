@@ -5,10 +5,16 @@ package whatsapp
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ooni/probe-engine/experiment/urlgetter"
@@ -18,6 +24,9 @@ import (
 )
 
 const (
+	// CDNURL is the URL of WhatsApp's media CDN
+	CDNURL = "tlshandshake://mmg.whatsapp.net:443"
+
 	// RegistrationServiceURL is the URL used by WhatsApp registration service
 	RegistrationServiceURL = "https://v.whatsapp.net/v2/register"
 
@@ -28,21 +37,61 @@ const (
 	WebHTTPSURL = "https://web.whatsapp.com/"
 
 	testName    = "whatsapp"
-	testVersion = "0.8.0"
+	testVersion = "0.9.0"
+
+	// EndpointsSourceCompiled indicates that we measured the
+	// WhatsApp endpoints compiled into this experiment.
+	EndpointsSourceCompiled = "compiled"
+
+	// EndpointsSourceDynamic indicates that we measured the WhatsApp
+	// endpoints fetched from Config.EndpointsURL.
+	EndpointsSourceDynamic = "dynamic"
+)
+
+var (
+	// ErrNoEndpoints indicates that the endpoints list we fetched is empty.
+	ErrNoEndpoints = errors.New("whatsapp: empty endpoints list")
+
+	// ErrInvalidEndpoint indicates that the endpoints list we fetched
+	// contains an entry that is not a valid hostname.
+	ErrInvalidEndpoint = errors.New("whatsapp: invalid endpoint")
 )
 
-var endpointPattern = regexp.MustCompile("^tcpconnect://e[0-9]{1,2}.whatsapp.net:[0-9]{3,5}$")
+// endpointPorts contains the ports we measure for each endpoint.
+var endpointPorts = []string{"443", "5222"}
 
-// Config contains the experiment config.
-type Config struct{}
+// endpointRegexp matches the hostnames we accept as endpoints.
+var endpointRegexp = regexp.MustCompile(`^([a-z0-9]+(-[a-z0-9]+)*\.)+[a-z]{2,}$`)
+
+// CompiledEndpoints returns the WhatsApp endpoints compiled into
+// this experiment, which we use unless we can fetch them.
+func CompiledEndpoints() []string {
+	var out []string
+	for idx := 1; idx <= 16; idx++ {
+		out = append(out, fmt.Sprintf("e%d.whatsapp.net", idx))
+	}
+	return out
+}
+
+// Config contains the experiment config. By default, we measure the
+// compiled endpoints (see CompiledEndpoints). When EndpointsURL is not
+// empty, we fetch from it the JSON list of the hostnames of the WhatsApp
+// endpoints to measure, falling back to the compiled list on failure or
+// when any entry is not a valid hostname.
+type Config struct {
+	EndpointsURL string `ooni:"URL of the JSON list of WhatsApp endpoints to measure"`
+}
 
 // TestKeys contains the experiment results
 type TestKeys struct {
 	urlgetter.TestKeys
 	RegistrationServerFailure        *string        `json:"registration_server_failure"`
 	RegistrationServerStatus         string         `json:"registration_server_status"`
+	WhatsappCDNFailure               *string        `json:"whatsapp_cdn_failure"`
+	WhatsappCDNStatus                string         `json:"whatsapp_cdn_status"`
 	WhatsappEndpointsBlocked         []string       `json:"whatsapp_endpoints_blocked"`
 	WhatsappEndpointsDNSInconsistent []string       `json:"whatsapp_endpoints_dns_inconsistent"`
+	WhatsappEndpointsSource          string         `json:"whatsapp_endpoints_source"`
 	WhatsappEndpointsStatus          string         `json:"whatsapp_endpoints_status"`
 	WhatsappWebFailure               *string        `json:"whatsapp_web_failure"`
 	WhatsappWebStatus                string         `json:"whatsapp_web_status"`
@@ -57,8 +106,11 @@ func NewTestKeys() *TestKeys {
 	return &TestKeys{
 		RegistrationServerFailure:        &failure,
 		RegistrationServerStatus:         "blocked",
+		WhatsappCDNFailure:               &failure,
+		WhatsappCDNStatus:                "blocked",
 		WhatsappEndpointsBlocked:         []string{},
 		WhatsappEndpointsDNSInconsistent: []string{},
+		WhatsappEndpointsSource:          EndpointsSourceCompiled,
 		WhatsappEndpointsStatus:          "blocked",
 		WhatsappWebFailure:               &failure,
 		WhatsappWebStatus:                "blocked",
@@ -71,13 +123,10 @@ func NewTestKeys() *TestKeys {
 // Update updates the TestKeys using the given MultiOutput result.
 func (tk *TestKeys) Update(v urlgetter.MultiOutput) {
 	// Update the easy to update entries first
-	tk.NetworkEvents = append(tk.NetworkEvents, v.TestKeys.NetworkEvents...)
-	tk.Queries = append(tk.Queries, v.TestKeys.Queries...)
-	tk.Requests = append(tk.Requests, v.TestKeys.Requests...)
-	tk.TCPConnect = append(tk.TCPConnect, v.TestKeys.TCPConnect...)
-	tk.TLSHandshakes = append(tk.TLSHandshakes, v.TestKeys.TLSHandshakes...)
-	// Set the status of WhatsApp endpoints
-	if endpointPattern.MatchString(v.Input.Target) {
+	tk.AppendObservations(v.TestKeys)
+	// Set the status of WhatsApp endpoints, which are the only
+	// targets we measure using TCP connect.
+	if strings.HasPrefix(v.Input.Target, "tcpconnect://") {
 		if v.TestKeys.Failure != nil {
 			parsed, err := url.Parse(v.Input.Target)
 			runtimex.PanicOnError(err, "url.Parse should not fail here")
@@ -91,6 +140,14 @@ func (tk *TestKeys) Update(v urlgetter.MultiOutput) {
 		tk.WhatsappEndpointsStatus = "ok"
 		return
 	}
+	// Set the status of the CDN
+	if v.Input.Target == CDNURL {
+		tk.WhatsappCDNFailure = v.TestKeys.Failure
+		if v.TestKeys.Failure == nil {
+			tk.WhatsappCDNStatus = "ok"
+		}
+		return
+	}
 	// Set the status of the registration service
 	if v.Input.Target == RegistrationServiceURL {
 		tk.RegistrationServerFailure = v.TestKeys.Failure
@@ -153,6 +210,14 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// IsAnomaly implements model.ExperimentAnomalyDetector.IsAnomaly. We
+// flag the measurement when any of the services is blocked.
+func (m Measurer) IsAnomaly(measurement *model.Measurement) bool {
+	tk, ok := measurement.TestKeys.(*TestKeys)
+	return ok && (tk.RegistrationServerStatus != "ok" || tk.WhatsappCDNStatus != "ok" ||
+		tk.WhatsappEndpointsStatus != "ok" || tk.WhatsappWebStatus != "ok")
+}
+
 // Run implements ExperimentMeasurer.Run
 func (m Measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
@@ -161,12 +226,23 @@ func (m Measurer) Run(
 	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 	urlgetter.RegisterExtensions(measurement)
+	testkeys := NewTestKeys()
+	endpoints := CompiledEndpoints()
+	if m.Config.EndpointsURL != "" {
+		fetched, err := fetchEndpoints(ctx, sess.DefaultHTTPClient(), m.Config.EndpointsURL)
+		if err != nil {
+			sess.Logger().Warnf("whatsapp: cannot fetch endpoints: %s", err.Error())
+		} else {
+			endpoints = fetched
+			testkeys.WhatsappEndpointsSource = EndpointsSourceDynamic
+		}
+	}
 	// generate all the inputs
 	var inputs []urlgetter.MultiInput
-	for idx := 1; idx <= 16; idx++ {
-		for _, port := range []string{"443", "5222"} {
+	for _, endpoint := range endpoints {
+		for _, port := range endpointPorts {
 			inputs = append(inputs, urlgetter.MultiInput{
-				Target: fmt.Sprintf("tcpconnect://e%d.whatsapp.net:%s", idx, port),
+				Target: fmt.Sprintf("tcpconnect://%s:%s", endpoint, port),
 			})
 		}
 	}
@@ -174,6 +250,11 @@ func (m Measurer) Run(
 		Config: urlgetter.Config{FailOnHTTPError: true},
 		Target: RegistrationServiceURL,
 	})
+	inputs = append(inputs, urlgetter.MultiInput{
+		// We only check whether we can establish a TLS connection
+		// with the CDN, which serves the media files.
+		Target: CDNURL,
+	})
 	inputs = append(inputs, urlgetter.MultiInput{
 		// We consider this check successful if we can establish a TLS
 		// connection and we don't see any socket/TLS errors. Hence, we
@@ -192,7 +273,6 @@ func (m Measurer) Run(
 	})
 	// measure in parallel
 	multi := urlgetter.Multi{Begin: time.Now(), Getter: m.Getter, Session: sess}
-	testkeys := NewTestKeys()
 	testkeys.Agent = "redirect"
 	measurement.TestKeys = testkeys
 	for entry := range multi.Collect(ctx, inputs, "whatsapp", callbacks) {
@@ -202,6 +282,57 @@ func (m Measurer) Run(
 	return nil
 }
 
+// fetchEndpoints fetches the JSON list of endpoints from URL.
+func fetchEndpoints(ctx context.Context, clnt *http.Client, URL string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := clnt.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("whatsapp: request failed: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []string
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, endpoint := range endpoints {
+		endpoint = strings.ToLower(strings.TrimSpace(endpoint))
+		if endpoint == "" {
+			continue
+		}
+		if err := validateEndpoint(endpoint); err != nil {
+			return nil, err
+		}
+		out = append(out, endpoint)
+	}
+	if len(out) <= 0 {
+		return nil, ErrNoEndpoints
+	}
+	return out, nil
+}
+
+// validateEndpoint ensures that endpoint is a hostname, so that the
+// host:port targets we build from it are valid. Update parses them and
+// would otherwise panic when an entry is not a valid URL host.
+func validateEndpoint(endpoint string) error {
+	if !endpointRegexp.MatchString(endpoint) {
+		return fmt.Errorf("%w: %s", ErrInvalidEndpoint, endpoint)
+	}
+	return nil
+}
+
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return Measurer{Config: config}
@@ -3,7 +3,10 @@ package whatsapp_test
 import (
 	"context"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/apex/log"
@@ -21,7 +24,7 @@ func TestNewExperimentMeasurer(t *testing.T) {
 	if measurer.ExperimentName() != "whatsapp" {
 		t.Fatal("unexpected name")
 	}
-	if measurer.ExperimentVersion() != "0.8.0" {
+	if measurer.ExperimentVersion() != "0.9.0" {
 		t.Fatal("unexpected version")
 	}
 }
@@ -58,6 +61,12 @@ func TestIntegrationSuccess(t *testing.T) {
 	if tk.WhatsappWebStatus != "ok" {
 		t.Fatal("invalid WhatsappWebStatus")
 	}
+	if tk.WhatsappCDNFailure != nil {
+		t.Fatal("invalid WhatsappCDNFailure")
+	}
+	if tk.WhatsappCDNStatus != "ok" {
+		t.Fatal("invalid WhatsappCDNStatus")
+	}
 }
 
 func TestIntegrationFailureAllEndpoints(t *testing.T) {
@@ -99,6 +108,15 @@ func TestIntegrationFailureAllEndpoints(t *testing.T) {
 	if tk.WhatsappWebStatus != "blocked" {
 		t.Fatal("invalid WhatsappWebStatus")
 	}
+	if *tk.WhatsappCDNFailure != "interrupted" {
+		t.Fatal("invalid WhatsappCDNFailure")
+	}
+	if tk.WhatsappCDNStatus != "blocked" {
+		t.Fatal("invalid WhatsappCDNStatus")
+	}
+	if tk.WhatsappEndpointsSource != whatsapp.EndpointsSourceCompiled {
+		t.Fatal("invalid WhatsappEndpointsSource")
+	}
 }
 
 func TestTestKeysComputeWebStatus(t *testing.T) {
@@ -588,7 +606,112 @@ func TestWeConfigureWebChecksCorrectly(t *testing.T) {
 	if err := measurer.Run(ctx, sess, measurement, callbacks); err != nil {
 		t.Fatal(err)
 	}
-	if called.Load() != 263 {
+	if called.Load() != 271 {
 		t.Fatal("not called the expected number of times")
 	}
 }
+
+func TestDynamicEndpoints(t *testing.T) {
+	var inputs = []struct {
+		name      string
+		handler   http.HandlerFunc
+		source    string
+		endpoints int64
+	}{{
+		name: "with a valid list",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`["e1.example.com", "e2.example.com", ""]`))
+		},
+		source:    whatsapp.EndpointsSourceDynamic,
+		endpoints: 4,
+	}, {
+		name: "with an empty list",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[]`))
+		},
+		source:    whatsapp.EndpointsSourceCompiled,
+		endpoints: 32,
+	}, {
+		name: "with an invalid endpoint",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`["e1.whatsapp.net", "e2.whatsapp.net:443", "%zz"]`))
+		},
+		source:    whatsapp.EndpointsSourceCompiled,
+		endpoints: 32,
+	}, {
+		name: "with invalid JSON",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{`))
+		},
+		source:    whatsapp.EndpointsSourceCompiled,
+		endpoints: 32,
+	}, {
+		name: "with an HTTP error",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		},
+		source:    whatsapp.EndpointsSourceCompiled,
+		endpoints: 32,
+	}}
+	for _, input := range inputs {
+		t.Run(input.name, func(t *testing.T) {
+			server := httptest.NewServer(input.handler)
+			defer server.Close()
+			endpoints := atomicx.NewInt64()
+			measurer := whatsapp.Measurer{
+				Config: whatsapp.Config{EndpointsURL: server.URL},
+				Getter: func(ctx context.Context, g urlgetter.Getter) (urlgetter.TestKeys, error) {
+					if strings.HasPrefix(g.Target, "tcpconnect://") {
+						endpoints.Add(1)
+					}
+					return urlgetter.TestKeys{}, nil
+				},
+			}
+			sess := &mockable.ExperimentSession{
+				MockableHTTPClient: http.DefaultClient,
+				MockableLogger:     log.Log,
+			}
+			measurement := new(model.Measurement)
+			callbacks := model.NewPrinterCallbacks(log.Log)
+			err := measurer.Run(context.Background(), sess, measurement, callbacks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tk := measurement.TestKeys.(*whatsapp.TestKeys)
+			if tk.WhatsappEndpointsSource != input.source {
+				t.Fatal("invalid WhatsappEndpointsSource")
+			}
+			if endpoints.Load() != input.endpoints {
+				t.Fatal("unexpected number of endpoints")
+			}
+		})
+	}
+}
+
+func TestCompiledEndpoints(t *testing.T) {
+	endpoints := whatsapp.CompiledEndpoints()
+	if len(endpoints) != 16 {
+		t.Fatal("unexpected number of endpoints")
+	}
+	pattern := regexp.MustCompile("^e[0-9]{1,2}.whatsapp.net$")
+	for _, endpoint := range endpoints {
+		if !pattern.MatchString(endpoint) {
+			t.Fatal("invalid endpoint", endpoint)
+		}
+	}
+}
+
+func TestIsAnomaly(t *testing.T) {
+	detector := whatsapp.NewExperimentMeasurer(
+		whatsapp.Config{}).(model.ExperimentAnomalyDetector)
+	tk := whatsapp.NewTestKeys()
+	measurement := &model.Measurement{TestKeys: tk}
+	if !detector.IsAnomaly(measurement) {
+		t.Fatal("expected an anomaly here")
+	}
+	tk.RegistrationServerStatus, tk.WhatsappCDNStatus = "ok", "ok"
+	tk.WhatsappEndpointsStatus, tk.WhatsappWebStatus = "ok", "ok"
+	if detector.IsAnomaly(measurement) {
+		t.Fatal("did not expect an anomaly here")
+	}
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"io"
 	"os"
 
 	"github.com/ooni/probe-engine/model"
@@ -8,9 +9,8 @@ import (
 
 func (e *Experiment) SaveMeasurementEx(
 	measurement *model.Measurement, filePath string,
-	marshal func(v interface{}) ([]byte, error),
+	writeJSON func(m *model.Measurement, w io.Writer) error,
 	openFile func(name string, flag int, perm os.FileMode) (*os.File, error),
-	write func(fp *os.File, b []byte) (n int, err error),
 ) error {
-	return e.saveMeasurement(measurement, filePath, marshal, openFile, write)
+	return e.saveMeasurement(measurement, filePath, writeJSON, openFile)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -12,8 +13,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/model"
 )
 
@@ -345,6 +348,21 @@ func TestOptions(t *testing.T) {
 			t.Fatal("expected nil here")
 		}
 	})
+	t.Run("when config has unexported fields", func(t *testing.T) {
+		b := &ExperimentBuilder{
+			config: &struct {
+				Antani   string `ooni:"antani"`
+				mascetti func()
+			}{},
+		}
+		options, err := b.Options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(options) != 1 || options["Antani"].Doc != "antani" {
+			t.Fatal("unexpected options", options)
+		}
+	})
 }
 
 func TestSetOption(t *testing.T) {
@@ -415,6 +433,123 @@ func TestSetOption(t *testing.T) {
 	})
 }
 
+func TestSetOptionAny(t *testing.T) {
+	config := new(example.Config)
+	b := &ExperimentBuilder{config: config}
+	for key, value := range map[string]interface{}{
+		"Message":     "xo",
+		"ReturnError": "true",
+		"SleepTime":   float64(17),
+	} {
+		if err := b.SetOptionAny(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if config.Message != "xo" || !config.ReturnError || config.SleepTime != 17 {
+		t.Fatal("unexpected config", config)
+	}
+	if err := b.SetOptionAny("SleepTime", "10"); err != nil || config.SleepTime != 10 {
+		t.Fatal("cannot set an int option using a string", err)
+	}
+	for key, value := range map[string]interface{}{
+		"Message":     17.0,
+		"ReturnError": "xx",
+		"SleepTime":   1.5,
+		"antani":      "xx",
+	} {
+		if err := b.SetOptionAny(key, value); err == nil {
+			t.Fatal("expected an error here", key)
+		}
+	}
+	if err := b.SetOptionAny("Message", []string{"xo"}); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetOptionAnyStringMap(t *testing.T) {
+	config := new(urlgetter.Config)
+	b := &ExperimentBuilder{config: config}
+	headers := map[string]interface{}{"Cookie": "a=b"}
+	if err := b.SetOptionAny("HTTPHeaders", headers); err != nil {
+		t.Fatal(err)
+	}
+	headers["Cookie"] = "c=d" // must not change the option
+	if len(config.HTTPHeaders) != 1 || config.HTTPHeaders["Cookie"] != "a=b" {
+		t.Fatal("unexpected headers", config.HTTPHeaders)
+	}
+	if err := b.SetOptionAny("HTTPHeaders", map[string]string{"X-Antani": "xo"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.HTTPHeaders) != 1 || config.HTTPHeaders["X-Antani"] != "xo" {
+		t.Fatal("unexpected headers", config.HTTPHeaders)
+	}
+	if err := b.SetOptionAny("HTTPHeaders", map[string]interface{}{"X-Antani": 17.0}); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := b.SetOptionAny("Method", map[string]interface{}{}); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestMeasurerWithOptions(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.SetOptionString("Message", "xo"); err != nil {
+		t.Fatal(err)
+	}
+	experiment := builder.NewExperiment()
+	// changing the builder must not affect the experiment we created
+	if err := builder.SetOptionString("Message", "antani"); err != nil {
+		t.Fatal(err)
+	}
+	measurer, err := experiment.reconfigure(map[string]interface{}{"SleepTime": "17"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repr := fmt.Sprintf("%+v", measurer)
+	if !strings.Contains(repr, "Message:xo") || !strings.Contains(repr, "SleepTime:17") {
+		t.Fatal("unexpected measurer", repr)
+	}
+	if _, err := experiment.reconfigure(map[string]interface{}{"antani": "xx"}); err == nil {
+		t.Fatal("expected an error here")
+	}
+	_, err = experiment.MeasureWithOptions(
+		context.Background(), "", map[string]interface{}{"antani": "xx"})
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestMeasureWithOptionsConcurrently(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.SetOptionInt("SleepTime", int64(200*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	experiment := builder.NewExperiment()
+	errch := make(chan error, 1)
+	go func() {
+		_, err := experiment.MeasureWithOptions(
+			context.Background(), "", map[string]interface{}{"ReturnError": true})
+		errch <- err
+	}()
+	// the other measurement must not use the options of the first one
+	if _, err := experiment.MeasureWithContext(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errch; err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
 func TestLoadMeasurement(t *testing.T) {
 	sess := newSessionForTesting(t)
 	defer sess.Close()
@@ -483,30 +618,17 @@ func TestSaveMeasurementErrors(t *testing.T) {
 	filename := filepath.Join(dirname, "report.jsonl")
 	m := new(model.Measurement)
 	err = exp.SaveMeasurementEx(
-		m, filename, func(v interface{}) ([]byte, error) {
-			return nil, errors.New("mocked error")
-		}, os.OpenFile, func(fp *os.File, b []byte) (int, error) {
-			return fp.Write(b)
-		},
+		m, filename, func(m *model.Measurement, w io.Writer) error {
+			return errors.New("mocked error")
+		}, os.OpenFile,
 	)
 	if err == nil {
 		t.Fatal("expected an error here")
 	}
 	err = exp.SaveMeasurementEx(
-		m, filename, json.Marshal,
+		m, filename, (*model.Measurement).WriteJSON,
 		func(name string, flag int, perm os.FileMode) (*os.File, error) {
 			return nil, errors.New("mocked error")
-		}, func(fp *os.File, b []byte) (int, error) {
-			return fp.Write(b)
-		},
-	)
-	if err == nil {
-		t.Fatal("expected an error here")
-	}
-	err = exp.SaveMeasurementEx(
-		m, filename, json.Marshal, os.OpenFile,
-		func(fp *os.File, b []byte) (int, error) {
-			return 0, errors.New("mocked error")
 		},
 	)
 	if err == nil {
@@ -603,6 +725,21 @@ func TestSubmitAndUpdateMeasurementWithClosedReport(t *testing.T) {
 	}
 }
 
+func TestSubmitOrQueueMeasurementWithClosedReport(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := builder.NewExperiment()
+	m := new(model.Measurement)
+	err = exp.SubmitOrQueueMeasurement(m)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
 func TestMeasureLookupLocationFailure(t *testing.T) {
 	sess := newSessionForTestingNoLookups(t)
 	defer sess.Close()
@@ -645,3 +782,101 @@ func (am *antaniMeasurer) Run(
 ) error {
 	return nil
 }
+
+func TestDryRun(t *testing.T) {
+	filep, err := ioutil.TempFile("", "ooniprobe-engine-dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filep.Close()
+	defer os.Remove(filep.Name())
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.dryRunFile = filep.Name()
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(10 * time.Millisecond)}, "example",
+	))
+	if err := exp.OpenReport(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(exp.ReportID(), DryRunReportIDPrefix) {
+		t.Fatal("not the report ID we expected")
+	}
+	measurement, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if measurement.ReportID != exp.ReportID() {
+		t.Fatal("the measurement does not have the dry-run report ID")
+	}
+	if err := exp.SubmitAndUpdateMeasurement(measurement); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.SubmitOrQueueMeasurement(measurement); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.CloseReport(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filep.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatal("not the number of saved measurements we expected")
+	}
+	var saved model.Measurement
+	if err := json.Unmarshal([]byte(lines[0]), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(saved.ReportID, DryRunReportIDPrefix) {
+		t.Fatal("not the saved report ID we expected")
+	}
+	if sess.submissionsFailed.Load() != 0 {
+		t.Fatal("dry-run submissions should not fail")
+	}
+}
+
+func TestMeasureDataCapExceededBefore(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.dataCapKiB = 1
+	sess.byteCounter.CountKibiBytesReceived(2)
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(10 * time.Millisecond)}, "example",
+	))
+	measurement, err := exp.Measure("")
+	if !errors.Is(err, ErrDataCapExceeded) {
+		t.Fatal("not the error we expected")
+	}
+	if measurement != nil {
+		t.Fatal("expected nil measurement here")
+	}
+}
+
+func TestMeasureDataCapExceededDuring(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.dataCapKiB = 1
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(10 * time.Second)}, "example",
+	))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		sess.byteCounter.CountKibiBytesReceived(2)
+	}()
+	start := time.Now()
+	measurement, err := exp.Measure("")
+	if !errors.Is(err, ErrDataCapExceeded) {
+		t.Fatal("not the error we expected")
+	}
+	if measurement == nil {
+		t.Fatal("expected the partial measurement here")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("the measurement was not interrupted")
+	}
+}
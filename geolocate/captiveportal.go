@@ -0,0 +1,34 @@
+package geolocate
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultCaptivePortalURL is the default URL used by CheckCaptivePortal.
+const DefaultCaptivePortalURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+// CheckCaptivePortal returns whether we are behind a captive portal. We
+// fetch URL, which should always return 204 with an empty body, without
+// following redirects. Captive portals usually redirect us to a login page
+// or directly serve the login page, so any other response means that we
+// are behind a captive portal. We return an error if we cannot fetch URL.
+func CheckCaptivePortal(
+	ctx context.Context, httpClient *http.Client, URL, userAgent string,
+) (bool, error) {
+	client := *httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	request, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	return response.StatusCode != 204 || response.ContentLength > 0, nil
+}
@@ -0,0 +1,71 @@
+package geolocate_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/geolocate"
+)
+
+func TestCheckCaptivePortal(t *testing.T) {
+	var cases = []struct {
+		name     string
+		handler  http.HandlerFunc
+		expected bool
+	}{{
+		name: "no captive portal",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(204)
+		},
+		expected: false,
+	}, {
+		name: "redirect to login page",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/login", 302)
+		},
+		expected: true,
+	}, {
+		name: "login page",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>please login</html>"))
+		},
+		expected: true,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(c.handler)
+			defer server.Close()
+			captive, err := geolocate.CheckCaptivePortal(
+				context.Background(), http.DefaultClient, server.URL, "miniooni/0.1.0-dev")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if captive != c.expected {
+				t.Fatal("not the result we expected")
+			}
+		})
+	}
+}
+
+func TestCheckCaptivePortalFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	captive, err := geolocate.CheckCaptivePortal(
+		ctx, http.DefaultClient, geolocate.DefaultCaptivePortalURL, "miniooni/0.1.0-dev")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if captive {
+		t.Fatal("expected false here")
+	}
+}
+
+func TestCheckCaptivePortalInvalidURL(t *testing.T) {
+	_, err := geolocate.CheckCaptivePortal(
+		context.Background(), http.DefaultClient, "\t", "miniooni/0.1.0-dev")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -0,0 +1,41 @@
+package geolocate
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+	"github.com/ooni/probe-engine/model"
+)
+
+// CloudflareIPLookup performs the IP lookup using Cloudflare services.
+func CloudflareIPLookup(
+	ctx context.Context,
+	httpClient *http.Client,
+	logger model.Logger,
+	userAgent string,
+) (string, error) {
+	data, err := (httpx.Client{
+		BaseURL:    "https://www.cloudflare.com",
+		HTTPClient: httpClient,
+		Logger:     logger,
+		UserAgent:  userAgent,
+	}).FetchResource(ctx, "/cdn-cgi/trace")
+	if err != nil {
+		return model.DefaultProbeIP, err
+	}
+	return parseCloudflareTrace(string(data)), nil
+}
+
+// parseCloudflareTrace returns the value of the `ip` key of the Cloudflare
+// trace, which consists of `key=value` lines. Returns the empty string if
+// there is no such key, which DoWithCustomFunc treats as an invalid IP.
+func parseCloudflareTrace(trace string) string {
+	for _, line := range strings.Split(trace, "\n") {
+		if strings.HasPrefix(line, "ip=") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "ip="))
+		}
+	}
+	return ""
+}
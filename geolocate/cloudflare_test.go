@@ -0,0 +1,36 @@
+package geolocate_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/geolocate"
+)
+
+func TestCloudflareIPLookupIntegration(t *testing.T) {
+	ip, err := geolocate.CloudflareIPLookup(
+		context.Background(),
+		http.DefaultClient,
+		log.Log,
+		"ooniprobe-engine/0.1.0",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if net.ParseIP(ip) == nil {
+		t.Fatalf("not an IP address: '%s'", ip)
+	}
+}
+
+func TestCloudflareIPLookupFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	_, err := geolocate.CloudflareIPLookup(
+		ctx, http.DefaultClient, log.Log, "ooniprobe-engine/0.1.0")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
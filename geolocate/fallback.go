@@ -0,0 +1,147 @@
+package geolocate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cretz/bine/torutil/geoipembed"
+	"github.com/ooni/probe-engine/model"
+)
+
+// SourceFallbackDatabase indicates that a field comes from the fallback
+// database built into the engine (see LookupFallbackCC).
+const SourceFallbackDatabase = "fallback_database"
+
+// ErrNotInFallbackDatabase indicates that the fallback database does
+// not contain the IP address we are looking up.
+var ErrNotInFallbackDatabase = errors.New("geolocate: not in the fallback database")
+
+// fallbackNetwork is a range of IP addresses in the fallback database. The
+// first and last fields are 16-byte IPs in network byte order, so that
+// comparing them as strings compares the addresses.
+type fallbackNetwork struct {
+	first, last string
+	cc          string
+}
+
+// fallbackNetworks contains the sorted networks of the fallback database,
+// which we parse the first time we need them.
+var fallbackNetworks struct {
+	once     sync.Once
+	networks []fallbackNetwork
+	err      error
+}
+
+// FallbackDatabaseDate returns the date of the fallback database. We use
+// as fallback database the country database built into the engine by the
+// github.com/cretz/bine/torutil/geoipembed package, which is a conversion
+// of the MaxMind GeoLite2 country database made by the Tor project.
+func FallbackDatabaseDate() string {
+	return geoipembed.LastUpdated().UTC().Format("2006-01-02")
+}
+
+// LookupFallbackCC is like LookupCC but uses the country database built
+// into the engine, which we use when we could not download the country
+// database. The fallback database is older than the downloadable one,
+// hence it may be less accurate.
+func LookupFallbackCC(ip string) (string, error) {
+	fallbackNetworks.once.Do(func() {
+		fallbackNetworks.networks, fallbackNetworks.err = loadFallbackNetworks()
+	})
+	if fallbackNetworks.err != nil {
+		return "", fallbackNetworks.err
+	}
+	network, err := lookupFallback(fallbackNetworks.networks, ip)
+	if err != nil {
+		return "", err
+	}
+	return network.cc, nil
+}
+
+// LookupFallbackASN is like LookupFallbackCC but for the ASN. Since the
+// fallback database does not contain ASNs, we return model.DefaultProbeASN
+// and model.DefaultProbeNetworkName, i.e., the values we would submit if
+// the user did not want to share the ASN, for any valid IP address.
+func LookupFallbackASN(ip string) (asn uint, org string, err error) {
+	if net.ParseIP(ip) == nil {
+		return 0, "", ErrNotInFallbackDatabase
+	}
+	return model.DefaultProbeASN, model.DefaultProbeNetworkName, nil
+}
+
+// lookupFallback returns the network containing ip. The networks
+// must be sorted and must not overlap.
+func lookupFallback(networks []fallbackNetwork, ip string) (*fallbackNetwork, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, ErrNotInFallbackDatabase
+	}
+	key := string(parsed.To16())
+	idx := sort.Search(len(networks), func(i int) bool {
+		return networks[i].last >= key
+	})
+	if idx >= len(networks) || networks[idx].first > key {
+		return nil, ErrNotInFallbackDatabase
+	}
+	return &networks[idx], nil
+}
+
+// loadFallbackNetworks parses the IPv4 and IPv6 geoip files built into
+// the engine. The IPv4 file contains lines like `16777216,16777471,AU`,
+// where the addresses are integers, while the IPv6 file contains lines
+// like `2001:200::,2001:200:ffff:ffff:ffff:ffff:ffff:ffff,JP`.
+func loadFallbackNetworks() ([]fallbackNetwork, error) {
+	var networks []fallbackNetwork
+	for _, ipv6 := range []bool{false, true} {
+		data, err := geoipembed.GeoIPBytes(ipv6)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			v := strings.Split(line, ",")
+			if len(v) != 3 {
+				return nil, errors.New("geolocate: invalid fallback database line")
+			}
+			first, last := parseFallbackIP(v[0]), parseFallbackIP(v[1])
+			if first == nil || last == nil {
+				return nil, errors.New("geolocate: invalid fallback database address")
+			}
+			networks = append(networks, fallbackNetwork{
+				first: string(first), last: string(last), cc: v[2]})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].first < networks[j].first
+	})
+	return networks, nil
+}
+
+// parseFallbackIP parses an address of the geoip files and returns
+// it as a 16-byte IP, or nil on failure.
+func parseFallbackIP(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.To16()
+	}
+	value, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return nil
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, uint32(value))
+	return ip.To16()
+}
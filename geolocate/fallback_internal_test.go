@@ -0,0 +1,116 @@
+package geolocate
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestLoadFallbackNetworks(t *testing.T) {
+	networks, err := loadFallbackNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) < 1000 {
+		t.Fatal("too few networks", len(networks))
+	}
+	for idx, network := range networks {
+		if network.first > network.last || len(network.cc) != 2 {
+			t.Fatalf("invalid network #%d: %+v", idx, network)
+		}
+		if idx > 0 && networks[idx-1].last >= network.first {
+			t.Fatalf("network #%d overlaps with the previous one", idx)
+		}
+	}
+}
+
+func TestLookupFallback(t *testing.T) {
+	if FallbackDatabaseDate() != "2018-09-21" {
+		t.Fatal("not the date we expected", FallbackDatabaseDate())
+	}
+	for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		cc, err := LookupFallbackCC(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cc != "US" {
+			t.Fatal("not the country code we expected for", ip, cc)
+		}
+		asn, org, err := LookupFallbackASN(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asn != model.DefaultProbeASN || org != model.DefaultProbeNetworkName {
+			t.Fatal("not the ASN we expected for", ip)
+		}
+	}
+	for _, ip := range []string{"10.0.0.1", "::1", "antani"} {
+		if _, err := LookupFallbackCC(ip); !errors.Is(err, ErrNotInFallbackDatabase) {
+			t.Fatal("not the error we expected for", ip, err)
+		}
+	}
+	if _, _, err := LookupFallbackASN("antani"); !errors.Is(err, ErrNotInFallbackDatabase) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestParseFallbackIP(t *testing.T) {
+	if ip := parseFallbackIP("16777216"); !ip.Equal(net.ParseIP("1.0.0.0")) {
+		t.Fatal("not the IP we expected", ip)
+	}
+	if ip := parseFallbackIP("2001:200::"); !ip.Equal(net.ParseIP("2001:200::")) {
+		t.Fatal("not the IP we expected", ip)
+	}
+	for _, s := range []string{"4294967296", "-1", "antani"} {
+		if ip := parseFallbackIP(s); ip != nil {
+			t.Fatal("expected nil IP for", s)
+		}
+	}
+}
+
+func newFallbackTask(ip string) *Task {
+	return NewTask(Config{
+		ASNDatabasePath:     "/nonexistent",
+		CountryDatabasePath: "/nonexistent",
+		Logger:              log.Log,
+		Methods: []IPLookupMethod{{
+			Name: "fake",
+			Func: func(ctx context.Context, client *http.Client,
+				logger model.Logger, userAgent string) (string, error) {
+				return ip, nil
+			},
+		}},
+	})
+}
+
+func TestTaskRunUsesFallbackDatabase(t *testing.T) {
+	results, err := newFallbackTask("8.8.8.8").Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.ASN != model.DefaultProbeASN || results.CountryCode != "US" {
+		t.Fatal("not the location we expected")
+	}
+	if results.Sources.ASN != SourceFallbackDatabase ||
+		results.Sources.CountryCode != SourceFallbackDatabase {
+		t.Fatal("not the location sources we expected")
+	}
+	if results.FallbackDatabaseDate != FallbackDatabaseDate() {
+		t.Fatal("not the fallback database date we expected")
+	}
+}
+
+func TestTaskRunNotInFallbackDatabase(t *testing.T) {
+	results, err := newFallbackTask("10.0.0.1").Run(context.Background())
+	if err == nil || errors.Is(err, ErrNotInFallbackDatabase) {
+		t.Fatal("expected the error of the country database here")
+	}
+	if results != nil {
+		t.Fatal("expected nil results here")
+	}
+}
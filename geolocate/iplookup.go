@@ -4,61 +4,169 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/ooni/probe-engine/model"
 )
 
+// DefaultIPLookupTimeout is the default timeout for racing
+// the IP lookup methods (see IPLookupClient.Timeout).
+const DefaultIPLookupTimeout = 7 * time.Second
+
+const (
+	// FamilyIPv4 is the IPv4 address family.
+	FamilyIPv4 = "ipv4"
+
+	// FamilyIPv6 is the IPv6 address family.
+	FamilyIPv6 = "ipv6"
+)
+
+// ErrAllIPLookuppersFailed indicates that all IP lookuppers failed.
+var ErrAllIPLookuppersFailed = errors.New("All IP lookuppers failed")
+
 // LookupFunc is a function for performing the IP lookup.
 type LookupFunc func(
 	ctx context.Context, client *http.Client,
 	logger model.Logger, userAgent string,
 ) (string, error)
 
-type method struct {
-	name string
-	fn   LookupFunc
+// IPLookupMethod is a named method for performing the IP lookup.
+type IPLookupMethod struct {
+	// Name is the name of the method.
+	Name string
+
+	// Func is the function implementing the method.
+	Func LookupFunc
 }
 
-var (
-	methods = []method{
-		{
-			name: "avast",
-			fn:   AvastIPLookup,
-		},
-		{
-			name: "ubuntu",
-			fn:   UbuntuIPLookup,
-		},
+// DefaultIPLookupMethods returns the default IP lookup methods. Because the
+// "stun" method does not honour proxies, you should remove it when you are
+// using a proxy (see WithoutSTUN).
+func DefaultIPLookupMethods() []IPLookupMethod {
+	return []IPLookupMethod{{
+		Name: "avast",
+		Func: AvastIPLookup,
+	}, {
+		Name: "cloudflare",
+		Func: CloudflareIPLookup,
+	}, {
+		Name: "stun",
+		Func: STUNIPLookup,
+	}, {
+		Name: "ubuntu",
+		Func: UbuntuIPLookup,
+	}}
+}
+
+// WithoutSTUN returns a copy of methods without the "stun" method.
+func WithoutSTUN(methods []IPLookupMethod) (out []IPLookupMethod) {
+	for _, method := range methods {
+		if method.Name != "stun" {
+			out = append(out, method)
+		}
 	}
+	return
+}
 
-	once sync.Once
-)
+// IPLookupMethodsForFamily is like DefaultIPLookupMethods except that the
+// "stun" method only uses the specified family. You should also use an HTTP
+// client restricted to family (see NewHTTPClientForFamily).
+func IPLookupMethodsForFamily(family string) []IPLookupMethod {
+	network := "udp4"
+	if family == FamilyIPv6 {
+		network = "udp6"
+	}
+	stun := &STUNClient{Network: network, Servers: DefaultSTUNServers}
+	return append(WithoutSTUN(DefaultIPLookupMethods()), stun.Method())
+}
+
+// NewHTTPClientForFamily returns a new HTTP client that only uses the
+// specified address family. Note that this client does not use any proxy, so
+// you should not use it when the IP lookup should discover the proxy address.
+func NewHTTPClientForFamily(family string) *http.Client {
+	network := "tcp4"
+	if family == FamilyIPv6 {
+		network = "tcp6"
+	}
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}}
+}
+
+// IPFamily returns the family of the specified IP address, i.e., FamilyIPv4
+// or FamilyIPv6, or the empty string if ip is not a valid IP address.
+func IPFamily(ip string) string {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return ""
+	case addr.To4() != nil:
+		return FamilyIPv4
+	default:
+		return FamilyIPv6
+	}
+}
 
 // IPLookupClient is an iplookup client
 type IPLookupClient struct {
+	// Family is the IP address family we want to discover. If empty
+	// we accept any family, otherwise either FamilyIPv4 or FamilyIPv6.
+	Family string
+
 	// HTTPClient is the HTTP client to use
 	HTTPClient *http.Client
 
 	// Logger is the logger to use
 	Logger model.Logger
 
+	// Methods contains the methods to use. If empty, we use
+	// the methods returned by DefaultIPLookupMethods.
+	Methods []IPLookupMethod
+
+	// Timeout is the maximum time we wait for the methods to
+	// complete. If zero, we use DefaultIPLookupTimeout.
+	Timeout time.Duration
+
 	// UserAgent is the user agent to use
 	UserAgent string
 }
 
-func makeSlice() []method {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	ret := make([]method, len(methods))
-	perm := r.Perm(len(methods))
-	for idx, randIdx := range perm {
-		ret[idx] = methods[randIdx]
-	}
-	return ret
+// IPLookupResult is the result of a single IP lookup method.
+type IPLookupResult struct {
+	// Duration is the time it took for the method to complete.
+	Duration time.Duration
+
+	// Err is the error that occurred, if any.
+	Err error
+
+	// IP is the discovered IP address.
+	IP string
+
+	// Method is the name of the method.
+	Method string
+}
+
+// IPLookupSummary summarizes the results of racing the IP lookup methods.
+type IPLookupSummary struct {
+	// Disagreements contains the successful results that do
+	// not agree with the IP we have selected.
+	Disagreements []IPLookupResult
+
+	// IP is the IP returned by most methods. In case of ties, we
+	// select the IP of the method that completed first.
+	IP string
+
+	// Results contains the results in order of completion.
+	Results []IPLookupResult
+
+	// Votes is the number of methods that returned IP.
+	Votes int
 }
 
 // DoWithCustomFunc performs the IP lookup with a custom function.
@@ -69,21 +177,86 @@ func (c *IPLookupClient) DoWithCustomFunc(
 	if err != nil {
 		return model.DefaultProbeIP, err
 	}
-	if net.ParseIP(ip) == nil {
+	family := IPFamily(ip)
+	if family == "" {
 		return model.DefaultProbeIP, fmt.Errorf("Invalid IP address: %s", ip)
 	}
+	if c.Family != "" && c.Family != family {
+		return model.DefaultProbeIP, fmt.Errorf("Not an %s address: %s", c.Family, ip)
+	}
 	c.Logger.Debugf("iplookup: IP: %s", ip)
 	return ip, nil
 }
 
-// Do performs the IP lookup.
-func (c *IPLookupClient) Do(ctx context.Context) (ip string, err error) {
-	for _, method := range makeSlice() {
-		c.Logger.Debugf("iplookup: using %s", method.name)
-		ip, err = c.DoWithCustomFunc(ctx, method.fn)
-		if err == nil {
-			return
+// Do performs the IP lookup using DoWithSummary.
+func (c *IPLookupClient) Do(ctx context.Context) (string, error) {
+	summary, err := c.DoWithSummary(ctx)
+	if err != nil {
+		return model.DefaultProbeIP, err
+	}
+	return summary.IP, nil
+}
+
+// DoWithSummary races all the IP lookup methods, waiting at most for
+// the configured timeout, and cross validates their results. We stop
+// early when most methods agree on the same IP. Results that disagree
+// with the selected IP are logged and saved into the summary.
+func (c *IPLookupClient) DoWithSummary(ctx context.Context) (*IPLookupSummary, error) {
+	methods := c.Methods
+	if len(methods) <= 0 {
+		methods = DefaultIPLookupMethods()
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultIPLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ch := make(chan IPLookupResult, len(methods)) // buffered so we can stop early
+	for _, method := range methods {
+		go func(method IPLookupMethod) {
+			c.Logger.Debugf("iplookup: using %s", method.Name)
+			start := time.Now()
+			ip, err := c.DoWithCustomFunc(ctx, method.Func)
+			ch <- IPLookupResult{
+				Duration: time.Now().Sub(start),
+				Err:      err,
+				IP:       ip,
+				Method:   method.Name,
+			}
+		}(method)
+	}
+	summary := &IPLookupSummary{}
+	votes := make(map[string]int)
+	for range methods {
+		result := <-ch
+		summary.Results = append(summary.Results, result)
+		if result.Err != nil {
+			c.Logger.Debugf("iplookup: %s failed: %s", result.Method, result.Err.Error())
+			continue
+		}
+		votes[result.IP]++
+		if votes[result.IP] > len(methods)/2 {
+			break // most methods agree, no need to wait for the others
+		}
+	}
+	for _, result := range summary.Results {
+		if result.Err == nil && votes[result.IP] > summary.Votes {
+			summary.IP, summary.Votes = result.IP, votes[result.IP]
+		}
+	}
+	if summary.Votes <= 0 {
+		return nil, ErrAllIPLookuppersFailed
+	}
+	for _, result := range summary.Results {
+		if result.Err == nil && result.IP != summary.IP {
+			// Do not log the IPs at warning level since warnings are
+			// usually shown to the user and may be shared.
+			c.Logger.Warnf("iplookup: %s disagrees with the majority", result.Method)
+			c.Logger.Debugf("iplookup: %s: %s instead of %s",
+				result.Method, result.IP, summary.IP)
+			summary.Disagreements = append(summary.Disagreements, result)
 		}
 	}
-	return model.DefaultProbeIP, errors.New("All IP lookuppers failed")
+	return summary, nil
 }
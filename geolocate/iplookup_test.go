@@ -2,8 +2,10 @@ package geolocate_test
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/geolocate"
@@ -55,3 +57,141 @@ func TestIPLookupInvalidIP(t *testing.T) {
 		t.Fatal("expected the default IP here")
 	}
 }
+
+func newFakeIPLookup(ip string, err error, delay time.Duration) geolocate.LookupFunc {
+	return func(ctx context.Context, client *http.Client,
+		logger model.Logger, userAgent string) (string, error) {
+		select {
+		case <-time.After(delay):
+			return ip, err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func TestIPLookupCrossValidation(t *testing.T) {
+	summary, err := (&geolocate.IPLookupClient{
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		Methods: []geolocate.IPLookupMethod{{
+			Name: "liar",
+			Func: newFakeIPLookup("10.0.0.1", nil, 0),
+		}, {
+			Name: "broken",
+			Func: newFakeIPLookup("", errors.New("mocked error"), 0),
+		}, {
+			Name: "a",
+			Func: newFakeIPLookup("130.192.91.211", nil, 10*time.Millisecond),
+		}, {
+			Name: "b",
+			Func: newFakeIPLookup("130.192.91.211", nil, 20*time.Millisecond),
+		}},
+		UserAgent: "ooniprobe-engine/0.1.0",
+	}).DoWithSummary(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.IP != "130.192.91.211" || summary.Votes != 2 {
+		t.Fatal("not the result we expected")
+	}
+	if len(summary.Results) != 4 {
+		t.Fatal("not the number of results we expected")
+	}
+	if len(summary.Disagreements) != 1 || summary.Disagreements[0].Method != "liar" {
+		t.Fatal("not the disagreements we expected")
+	}
+}
+
+func TestIPLookupStopsWhenMostMethodsAgree(t *testing.T) {
+	summary, err := (&geolocate.IPLookupClient{
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		Methods: []geolocate.IPLookupMethod{{
+			Name: "a",
+			Func: newFakeIPLookup("130.192.91.211", nil, 0),
+		}, {
+			Name: "b",
+			Func: newFakeIPLookup("130.192.91.211", nil, 0),
+		}, {
+			Name: "slow",
+			Func: newFakeIPLookup("10.0.0.1", nil, time.Hour),
+		}},
+		UserAgent: "ooniprobe-engine/0.1.0",
+	}).DoWithSummary(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.IP != "130.192.91.211" || len(summary.Results) != 2 {
+		t.Fatal("not the result we expected")
+	}
+}
+
+func TestIPLookupTimeout(t *testing.T) {
+	ip, err := (&geolocate.IPLookupClient{
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		Methods: []geolocate.IPLookupMethod{{
+			Name: "slow",
+			Func: newFakeIPLookup("130.192.91.211", nil, time.Hour),
+		}},
+		Timeout:   10 * time.Millisecond,
+		UserAgent: "ooniprobe-engine/0.1.0",
+	}).Do(context.Background())
+	if !errors.Is(err, geolocate.ErrAllIPLookuppersFailed) {
+		t.Fatal("not the error we expected")
+	}
+	if ip != model.DefaultProbeIP {
+		t.Fatal("expected the default IP here")
+	}
+}
+
+func TestIPLookupWrongFamily(t *testing.T) {
+	summary, err := (&geolocate.IPLookupClient{
+		Family:     geolocate.FamilyIPv6,
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		Methods: []geolocate.IPLookupMethod{{
+			Name: "ipv4",
+			Func: newFakeIPLookup("130.192.91.211", nil, 0),
+		}, {
+			Name: "ipv6",
+			Func: newFakeIPLookup("2001:db8::1", nil, 10*time.Millisecond),
+		}},
+		UserAgent: "ooniprobe-engine/0.1.0",
+	}).DoWithSummary(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.IP != "2001:db8::1" || len(summary.Disagreements) != 0 {
+		t.Fatal("not the result we expected")
+	}
+	if summary.Results[0].Err == nil {
+		t.Fatal("expected an error for the wrong family")
+	}
+}
+
+func TestIPFamily(t *testing.T) {
+	if geolocate.IPFamily("130.192.91.211") != geolocate.FamilyIPv4 {
+		t.Fatal("expected IPv4 here")
+	}
+	if geolocate.IPFamily("2001:db8::1") != geolocate.FamilyIPv6 {
+		t.Fatal("expected IPv6 here")
+	}
+	if geolocate.IPFamily("antani") != "" {
+		t.Fatal("expected no family here")
+	}
+}
+
+func TestIPLookupMethodsForFamily(t *testing.T) {
+	methods := geolocate.IPLookupMethodsForFamily(geolocate.FamilyIPv6)
+	if len(methods) != len(geolocate.DefaultIPLookupMethods()) {
+		t.Fatal("unexpected number of methods")
+	}
+	if methods[len(methods)-1].Name != "stun" {
+		t.Fatal("expected stun to be the last method")
+	}
+	if geolocate.NewHTTPClientForFamily(geolocate.FamilyIPv6) == nil {
+		t.Fatal("expected a non-nil HTTP client here")
+	}
+}
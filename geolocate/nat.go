@@ -0,0 +1,150 @@
+package geolocate
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/pion/stun"
+)
+
+const (
+	// NATTypeNone indicates that we are not behind a NAT, i.e., the
+	// STUN servers see one of the addresses of our interfaces.
+	NATTypeNone = "none"
+
+	// NATTypeEndpointIndependent indicates that the NAT maps our local
+	// address to the same public address regardless of the destination
+	// (i.e., a "cone" NAT in RFC3489 terms).
+	NATTypeEndpointIndependent = "endpoint_independent"
+
+	// NATTypeEndpointDependent indicates that the NAT maps our local
+	// address to distinct public addresses for distinct destinations
+	// (i.e., a "symmetric" NAT in RFC3489 terms).
+	NATTypeEndpointDependent = "endpoint_dependent"
+
+	// NATTypeUDPBlocked indicates that no STUN server replied.
+	NATTypeUDPBlocked = "udp_blocked"
+
+	// NATTypeUnknown indicates that we could not determine the NAT
+	// type, e.g., because only a single STUN server replied.
+	NATTypeUnknown = "unknown"
+)
+
+// NATType determines the NAT type by sending binding requests to the first
+// two servers that reply from the same local UDP socket and comparing the
+// mapped addresses (see RFC4787 and RFC5780). Because we need to use a single
+// socket for several servers, this function uses c.PacketListener rather than
+// c.Dialer. The return value is one of the NATType constants.
+func (c *STUNClient) NATType(ctx context.Context, logger model.Logger) string {
+	network := c.Network
+	if network == "" {
+		network = "udp"
+	}
+	conn, err := c.listenPacket(ctx, network)
+	if err != nil {
+		logger.Debugf("stun: cannot create socket: %s", err.Error())
+		return NATTypeUnknown
+	}
+	defer conn.Close()
+	var mapped []*net.UDPAddr
+	for _, server := range c.Servers {
+		addr, err := c.mappedAddress(ctx, conn, network, server)
+		if err != nil {
+			logger.Debugf("stun: %s: %s", server, err.Error())
+			c.countFailure(server)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		mapped = append(mapped, addr)
+		if len(mapped) >= 2 {
+			break
+		}
+	}
+	return classifyNAT(mapped, localIPs())
+}
+
+func (c *STUNClient) listenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	if c.PacketListener != nil {
+		return c.PacketListener.ListenPacket(ctx, network)
+	}
+	return net.ListenPacket(network, ":0")
+}
+
+func classifyNAT(mapped []*net.UDPAddr, local map[string]bool) string {
+	switch {
+	case len(mapped) <= 0:
+		return NATTypeUDPBlocked
+	case local[mapped[0].IP.String()]:
+		return NATTypeNone
+	case len(mapped) < 2:
+		return NATTypeUnknown
+	case mapped[0].IP.Equal(mapped[1].IP) && mapped[0].Port == mapped[1].Port:
+		return NATTypeEndpointIndependent
+	default:
+		return NATTypeEndpointDependent
+	}
+}
+
+func localIPs() map[string]bool {
+	out := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return out
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			out[ipnet.IP.String()] = true
+		}
+	}
+	return out
+}
+
+func (c *STUNClient) mappedAddress(
+	ctx context.Context, conn net.PacketConn, network, server string,
+) (*net.UDPAddr, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSTUNTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	addr, err := net.ResolveUDPAddr(network, server)
+	if err != nil {
+		return nil, err
+	}
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.WriteTo(request.Raw, addr); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, 1500)
+	for {
+		count, from, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return nil, err
+		}
+		if from.String() != addr.String() {
+			continue // not from the server we're talking to
+		}
+		var response stun.Message
+		if err := stun.Decode(buffer[:count], &response); err != nil {
+			return nil, err
+		}
+		if response.TransactionID != request.TransactionID {
+			continue // late response to a previous request
+		}
+		var xorAddr stun.XORMappedAddress
+		if err := xorAddr.GetFrom(&response); err != nil {
+			return nil, ErrSTUNInvalidResponse
+		}
+		return &net.UDPAddr{IP: xorAddr.IP, Port: xorAddr.Port}, nil
+	}
+}
@@ -0,0 +1,113 @@
+package geolocate_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/geolocate"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/bytecounter"
+)
+
+func TestSTUNClientNATType(t *testing.T) {
+	public := net.ParseIP("130.192.91.211")
+	var cases = []struct {
+		name     string
+		replies  []func(request []byte) []byte
+		expected string
+	}{{
+		name: "endpoint independent",
+		replies: []func(request []byte) []byte{
+			xorMappedAddressReplyWithPort(public, 4321),
+			xorMappedAddressReplyWithPort(public, 4321),
+		},
+		expected: geolocate.NATTypeEndpointIndependent,
+	}, {
+		name: "endpoint dependent",
+		replies: []func(request []byte) []byte{
+			xorMappedAddressReplyWithPort(public, 4321),
+			xorMappedAddressReplyWithPort(public, 4322),
+		},
+		expected: geolocate.NATTypeEndpointDependent,
+	}, {
+		name: "no NAT",
+		replies: []func(request []byte) []byte{
+			xorMappedAddressReplyWithPort(net.ParseIP("127.0.0.1"), 4321),
+			xorMappedAddressReplyWithPort(net.ParseIP("127.0.0.1"), 4321),
+		},
+		expected: geolocate.NATTypeNone,
+	}, {
+		name: "single server",
+		replies: []func(request []byte) []byte{
+			xorMappedAddressReplyWithPort(public, 4321),
+			func(request []byte) []byte { return nil },
+		},
+		expected: geolocate.NATTypeUnknown,
+	}, {
+		name: "UDP blocked",
+		replies: []func(request []byte) []byte{
+			func(request []byte) []byte { return nil },
+			func(request []byte) []byte { return nil },
+		},
+		expected: geolocate.NATTypeUDPBlocked,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var servers []string
+			for _, reply := range c.replies {
+				address, stop := newSTUNServer(t, reply)
+				defer stop()
+				servers = append(servers, address)
+			}
+			client := &geolocate.STUNClient{
+				Network: "udp4",
+				Servers: servers,
+				Timeout: 200 * time.Millisecond,
+			}
+			natType := client.NATType(context.Background(), log.Log)
+			if natType != c.expected {
+				t.Fatalf("expected %s, got %s", c.expected, natType)
+			}
+		})
+	}
+}
+
+func TestSTUNClientNATTypeInvalidResponse(t *testing.T) {
+	address, stop := newSTUNServer(t, func(request []byte) []byte {
+		return []byte("antani")
+	})
+	defer stop()
+	client := &geolocate.STUNClient{Network: "udp4", Servers: []string{address}}
+	if natType := client.NATType(context.Background(), log.Log); natType != geolocate.NATTypeUDPBlocked {
+		t.Fatal("not the NAT type we expected")
+	}
+	if client.Failures()[address] != 1 {
+		t.Fatal("not the failures we expected")
+	}
+}
+
+func TestSTUNClientNATTypeUsesPacketListener(t *testing.T) {
+	public := net.ParseIP("130.192.91.211")
+	var servers []string
+	for i := 0; i < 2; i++ {
+		address, stop := newSTUNServer(t, xorMappedAddressReplyWithPort(public, 4321))
+		defer stop()
+		servers = append(servers, address)
+	}
+	counter := bytecounter.New()
+	client := &geolocate.STUNClient{
+		Network:        "udp4",
+		PacketListener: netx.NewPacketListener(netx.Config{ByteCounter: counter}),
+		Servers:        servers,
+	}
+	natType := client.NATType(context.Background(), log.Log)
+	if natType != geolocate.NATTypeEndpointIndependent {
+		t.Fatal("not the NAT type we expected")
+	}
+	if counter.BytesSent() <= 0 || counter.BytesReceived() <= 0 {
+		t.Fatal("did not count the bytes")
+	}
+}
@@ -0,0 +1,116 @@
+package geolocate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ErrInvalidLocation indicates that a user-supplied location is not valid.
+var ErrInvalidLocation = errors.New("geolocate: invalid location")
+
+var countryCodeRe = regexp.MustCompile("^[A-Z]{2}$")
+
+// ValidateLocation validates a location supplied by the user rather than
+// discovered using the network, e.g., when running inside a testbed. We
+// check that the country code and the ASN exist in the country and ASN
+// databases located at countryDBPath and asnDBPath. On success, we return
+// a copy of location where we have filled the NetworkName, if empty, using
+// the ASN database, and where all the other fields have their defaults.
+//
+// Because the databases map networks to countries and ASNs, and not the
+// other way around, we need to walk all the networks they contain, which
+// is slow. You should call this function at most once per session.
+func ValidateLocation(
+	asnDBPath, countryDBPath string, location *model.LocationInfo,
+) (*model.LocationInfo, error) {
+	if location == nil {
+		return nil, fmt.Errorf("%w: no location", ErrInvalidLocation)
+	}
+	if !countryCodeRe.MatchString(location.CountryCode) {
+		return nil, fmt.Errorf("%w: invalid country code: %s",
+			ErrInvalidLocation, location.CountryCode)
+	}
+	if location.ASN == model.DefaultProbeASN {
+		return nil, fmt.Errorf("%w: missing ASN", ErrInvalidLocation)
+	}
+	org, err := lookupASNOrg(asnDBPath, location.ASN)
+	if err != nil {
+		return nil, err
+	}
+	if err := lookupCountryCode(countryDBPath, location.CountryCode); err != nil {
+		return nil, err
+	}
+	out := &model.LocationInfo{
+		ASN:                 location.ASN,
+		CountryCode:         location.CountryCode,
+		NetworkName:         location.NetworkName,
+		ProbeIP:             model.DefaultProbeIP,
+		ResolverASN:         model.DefaultResolverASN,
+		ResolverIP:          model.DefaultResolverIP,
+		ResolverNetworkName: model.DefaultResolverNetworkName,
+		ResolverNetworkType: ResolverNetworkTypeUnknown,
+	}
+	if out.NetworkName == "" {
+		out.NetworkName = org
+	}
+	return out, nil
+}
+
+// lookupASNOrg returns the org of the first network announced by asn
+// in the ASN database at path, or an error if there is no such network.
+func lookupASNOrg(path string, asn uint) (string, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	networks := db.Networks()
+	for networks.Next() {
+		var record struct {
+			AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+			AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+		}
+		if _, err := networks.Network(&record); err != nil {
+			return "", err
+		}
+		if record.AutonomousSystemNumber == asn {
+			return record.AutonomousSystemOrganization, nil
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%w: unknown ASN: AS%d", ErrInvalidLocation, asn)
+}
+
+// lookupCountryCode returns nil if the country database at path
+// contains at least a network in the country with code cc.
+func lookupCountryCode(path, cc string) error {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	networks := db.Networks()
+	for networks.Next() {
+		var record struct {
+			Country struct {
+				IsoCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}
+		if _, err := networks.Network(&record); err != nil {
+			return err
+		}
+		if record.Country.IsoCode == cc {
+			return nil
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: unknown country code: %s", ErrInvalidLocation, cc)
+}
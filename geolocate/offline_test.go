@@ -0,0 +1,80 @@
+package geolocate_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/geolocate"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestValidateLocationGood(t *testing.T) {
+	maybeFetchResources(t)
+	location, err := geolocate.ValidateLocation(asnDBPath, countryDBPath,
+		&model.LocationInfo{ASN: 15169, CountryCode: "US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.ASN != 15169 || location.CountryCode != "US" {
+		t.Fatal("not the location we expected")
+	}
+	if location.NetworkName == model.DefaultProbeNetworkName {
+		t.Fatal("expected to fill the network name")
+	}
+	if location.ProbeIP != model.DefaultProbeIP {
+		t.Fatal("expected the default probe IP")
+	}
+	if location.ResolverNetworkType != geolocate.ResolverNetworkTypeUnknown {
+		t.Fatal("expected an unknown resolver network type")
+	}
+}
+
+func TestValidateLocationKeepsNetworkName(t *testing.T) {
+	maybeFetchResources(t)
+	location, err := geolocate.ValidateLocation(asnDBPath, countryDBPath,
+		&model.LocationInfo{ASN: 15169, CountryCode: "US", NetworkName: "Testbed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.NetworkName != "Testbed" {
+		t.Fatal("not the network name we expected")
+	}
+}
+
+func TestValidateLocationUnknownValues(t *testing.T) {
+	maybeFetchResources(t)
+	invalid := []*model.LocationInfo{
+		{ASN: 4294967295, CountryCode: "US"},
+		{ASN: 15169, CountryCode: "XY"},
+	}
+	for idx, location := range invalid {
+		_, err := geolocate.ValidateLocation(asnDBPath, countryDBPath, location)
+		if !errors.Is(err, geolocate.ErrInvalidLocation) {
+			t.Fatalf("not the error we expected for case #%d", idx)
+		}
+	}
+}
+
+func TestValidateLocationInvalidValues(t *testing.T) {
+	invalid := []*model.LocationInfo{
+		nil,
+		{ASN: 15169},
+		{ASN: 15169, CountryCode: "us"},
+		{ASN: 15169, CountryCode: "USA"},
+		{CountryCode: "US"},
+	}
+	for idx, location := range invalid {
+		_, err := geolocate.ValidateLocation("/nonexistent", "/nonexistent", location)
+		if !errors.Is(err, geolocate.ErrInvalidLocation) {
+			t.Fatalf("not the error we expected for case #%d", idx)
+		}
+	}
+}
+
+func TestValidateLocationInvalidFile(t *testing.T) {
+	_, err := geolocate.ValidateLocation("/nonexistent", "/nonexistent",
+		&model.LocationInfo{ASN: 15169, CountryCode: "US"})
+	if err == nil || errors.Is(err, geolocate.ErrInvalidLocation) {
+		t.Fatal("not the error we expected")
+	}
+}
@@ -0,0 +1,49 @@
+package geolocate
+
+import "github.com/ooni/probe-engine/model"
+
+const (
+	// ResolverNetworkTypeISP indicates that the resolver belongs
+	// to the same network of the probe, i.e., its ISP.
+	ResolverNetworkTypeISP = "isp"
+
+	// ResolverNetworkTypePublic indicates that the resolver is
+	// a well known public resolver (see PublicResolverASNs).
+	ResolverNetworkTypePublic = "public"
+
+	// ResolverNetworkTypeOther indicates that the resolver belongs
+	// to another network (e.g. a VPN provider or a corporate network).
+	ResolverNetworkTypeOther = "other"
+
+	// ResolverNetworkTypeUnknown indicates that we cannot classify
+	// the resolver, e.g., because we don't know its ASN.
+	ResolverNetworkTypeUnknown = "unknown"
+)
+
+// PublicResolverASNs maps the ASNs of well known public resolvers to
+// the name of the organization operating the resolver. Note that we match
+// the ASN of the IP address the resolver uses to query the authoritative
+// servers, which is what LookupFirstResolverIP returns.
+var PublicResolverASNs = map[uint]string{
+	13335: "Cloudflare",
+	15169: "Google",
+	19281: "Quad9",
+	36692: "OpenDNS",
+}
+
+// ClassifyResolver returns the type of network the resolver belongs to
+// given the probe ASN and the resolver ASN. The return value is one of
+// ResolverNetworkTypeISP, ResolverNetworkTypePublic, ResolverNetworkTypeOther,
+// and ResolverNetworkTypeUnknown.
+func ClassifyResolver(probeASN, resolverASN uint) string {
+	if resolverASN == model.DefaultResolverASN {
+		return ResolverNetworkTypeUnknown
+	}
+	if _, found := PublicResolverASNs[resolverASN]; found {
+		return ResolverNetworkTypePublic
+	}
+	if probeASN != model.DefaultProbeASN && probeASN == resolverASN {
+		return ResolverNetworkTypeISP
+	}
+	return ResolverNetworkTypeOther
+}
@@ -0,0 +1,29 @@
+package geolocate_test
+
+import (
+	"testing"
+
+	"github.com/ooni/probe-engine/geolocate"
+)
+
+func TestClassifyResolver(t *testing.T) {
+	var cases = []struct {
+		probeASN    uint
+		resolverASN uint
+		expected    string
+	}{
+		{30722, 30722, geolocate.ResolverNetworkTypeISP},
+		{30722, 15169, geolocate.ResolverNetworkTypePublic},
+		{15169, 15169, geolocate.ResolverNetworkTypePublic},
+		{30722, 13335, geolocate.ResolverNetworkTypePublic},
+		{30722, 19281, geolocate.ResolverNetworkTypePublic},
+		{30722, 137, geolocate.ResolverNetworkTypeOther},
+		{0, 137, geolocate.ResolverNetworkTypeOther},
+		{30722, 0, geolocate.ResolverNetworkTypeUnknown},
+	}
+	for _, c := range cases {
+		if out := geolocate.ClassifyResolver(c.probeASN, c.resolverASN); out != c.expected {
+			t.Fatalf("AS%d/AS%d: expected %s, got %s", c.probeASN, c.resolverASN, c.expected, out)
+		}
+	}
+}
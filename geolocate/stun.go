@@ -0,0 +1,187 @@
+package geolocate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/pion/stun"
+)
+
+// DefaultSTUNServers contains the default public STUN servers, which are
+// well known servers used by WebRTC apps. We try them in order.
+var DefaultSTUNServers = []string{
+	"stun.l.google.com:19302",
+	"stun1.l.google.com:19302",
+	"stun.ekiga.net:3478",
+	"stun2.l.google.com:19302",
+	"stun.stunprotocol.org:3478",
+	"stun.voip.blackberry.com:3478",
+}
+
+// DefaultSTUNTimeout is the default timeout for each STUN server.
+const DefaultSTUNTimeout = 3 * time.Second
+
+// ErrSTUNInvalidResponse indicates that a STUN server returned a binding
+// response that does not contain our address.
+var ErrSTUNInvalidResponse = errors.New("stun: invalid response")
+
+// STUNClient discovers the probe IP by sending a STUN binding request
+// (see RFC5389) to public STUN servers. Because STUN uses UDP, this method
+// works even when HTTP based lookup services are blocked. Note that the
+// STUN client does not honour any proxy, hence you should not use it when
+// the IP lookup is supposed to discover the proxy's address.
+type STUNClient struct {
+	// Dialer is the dialer to use. If nil, we use a default dialer.
+	Dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	}
+
+	// PacketListener creates the socket used by NATType. If nil, we
+	// create the socket using net.ListenPacket.
+	PacketListener interface {
+		ListenPacket(ctx context.Context, network string) (net.PacketConn, error)
+	}
+
+	// Network is the network to use. If empty, we use "udp". Use
+	// "udp4" or "udp6" to only discover IPv4 or IPv6 addresses.
+	Network string
+
+	// Servers contains the STUN servers to use, which we try in
+	// sequence until one of them works.
+	Servers []string
+
+	// Timeout is the timeout for each server. If zero, we
+	// use DefaultSTUNTimeout.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	failures map[string]int64
+}
+
+// DefaultSTUNClient is the STUN client used by STUNIPLookup.
+var DefaultSTUNClient = &STUNClient{Servers: DefaultSTUNServers}
+
+// STUNIPLookup performs the IP lookup using DefaultSTUNClient.
+func STUNIPLookup(
+	ctx context.Context,
+	httpClient *http.Client,
+	logger model.Logger,
+	userAgent string,
+) (string, error) {
+	return DefaultSTUNClient.Lookup(ctx, logger)
+}
+
+// Method returns an IPLookupMethod named "stun" that uses c.
+func (c *STUNClient) Method() IPLookupMethod {
+	return IPLookupMethod{
+		Name: "stun",
+		Func: func(ctx context.Context, httpClient *http.Client,
+			logger model.Logger, userAgent string) (string, error) {
+			return c.Lookup(ctx, logger)
+		},
+	}
+}
+
+// Failures returns a copy of the number of times each STUN server failed.
+func (c *STUNClient) Failures() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int64)
+	for key, value := range c.failures {
+		out[key] = value
+	}
+	return out
+}
+
+func (c *STUNClient) countFailure(server string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures == nil {
+		c.failures = make(map[string]int64)
+	}
+	c.failures[server]++
+}
+
+// Lookup returns the IP address discovered using the first server that works.
+func (c *STUNClient) Lookup(ctx context.Context, logger model.Logger) (string, error) {
+	err := errors.New("stun: no servers configured")
+	for _, server := range c.Servers {
+		var ip string
+		ip, err = c.lookup(ctx, server)
+		if err == nil {
+			return ip, nil
+		}
+		logger.Debugf("stun: %s: %s", server, err.Error())
+		c.countFailure(server)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return model.DefaultProbeIP, err
+}
+
+func (c *STUNClient) lookup(ctx context.Context, server string) (string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSTUNTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	} = &net.Dialer{}
+	if c.Dialer != nil {
+		dialer = c.Dialer
+	}
+	network := c.Network
+	if network == "" {
+		network = "udp"
+	}
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return "", err
+	}
+	client, err := stun.NewClient(conn, stun.WithNoConnClose)
+	if err != nil {
+		conn.Close()
+		return "", err
+	}
+	defer func() {
+		// Close the conn first to interrupt the client's reader.
+		conn.Close()
+		client.Close()
+	}()
+	type result struct {
+		ip  string
+		err error
+	}
+	ch := make(chan result, 1) // buffered: the callback may run after we return
+	message := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	err = client.Start(message, func(ev stun.Event) {
+		if ev.Error != nil {
+			ch <- result{err: ev.Error}
+			return
+		}
+		var xorAddr stun.XORMappedAddress
+		if err := xorAddr.GetFrom(ev.Message); err != nil {
+			ch <- result{err: fmt.Errorf("%w: %s", ErrSTUNInvalidResponse, err.Error())}
+			return
+		}
+		ch <- result{ip: xorAddr.IP.String()}
+	})
+	if err != nil {
+		return "", err
+	}
+	select {
+	case r := <-ch:
+		return r.ip, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
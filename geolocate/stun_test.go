@@ -0,0 +1,161 @@
+package geolocate_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/geolocate"
+	"github.com/ooni/probe-engine/model"
+	"github.com/pion/stun"
+)
+
+// newSTUNServer starts a STUN server that replies to binding requests
+// using reply and returns its address and a function to stop it. When
+// reply returns nil, the server does not reply.
+func newSTUNServer(t *testing.T, reply func(request []byte) []byte) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if data := reply(buffer[:count]); data != nil {
+				conn.WriteTo(data, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+// xorMappedAddressReply returns a binding response containing
+// a XOR-MAPPED-ADDRESS attribute for the specified IP.
+func xorMappedAddressReply(ip net.IP) func(request []byte) []byte {
+	return xorMappedAddressReplyWithPort(ip, 443)
+}
+
+// xorMappedAddressReplyWithPort is like xorMappedAddressReply
+// but also allows to specify the mapped port.
+func xorMappedAddressReplyWithPort(ip net.IP, port int) func(request []byte) []byte {
+	return func(request []byte) []byte {
+		var message stun.Message
+		if err := stun.Decode(request, &message); err != nil {
+			return nil
+		}
+		response, err := stun.Build(
+			stun.NewTransactionIDSetter(message.TransactionID),
+			stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: ip, Port: port},
+		)
+		if err != nil {
+			return nil
+		}
+		return response.Raw
+	}
+}
+
+func TestSTUNClientSuccess(t *testing.T) {
+	address, stop := newSTUNServer(t, xorMappedAddressReply(net.ParseIP("130.192.91.211")))
+	defer stop()
+	client := &geolocate.STUNClient{Servers: []string{address}}
+	ip, err := client.Lookup(context.Background(), log.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "130.192.91.211" {
+		t.Fatal("not the IP we expected")
+	}
+	if len(client.Failures()) != 0 {
+		t.Fatal("expected no failures here")
+	}
+}
+
+func TestSTUNClientFallsBackToNextServer(t *testing.T) {
+	bad, stopBad := newSTUNServer(t, func(request []byte) []byte {
+		return []byte("antani")
+	})
+	defer stopBad()
+	good, stopGood := newSTUNServer(t, xorMappedAddressReply(net.ParseIP("130.192.91.211")))
+	defer stopGood()
+	client := &geolocate.STUNClient{
+		Servers: []string{bad, good},
+		Timeout: 500 * time.Millisecond,
+	}
+	ip, err := client.Lookup(context.Background(), log.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "130.192.91.211" {
+		t.Fatal("not the IP we expected")
+	}
+	failures := client.Failures()
+	if len(failures) != 1 || failures[bad] != 1 {
+		t.Fatal("not the failures we expected")
+	}
+}
+
+func TestSTUNClientNoXORMappedAddress(t *testing.T) {
+	address, stop := newSTUNServer(t, func(request []byte) []byte {
+		var message stun.Message
+		if err := stun.Decode(request, &message); err != nil {
+			return nil
+		}
+		response := stun.MustBuild(
+			stun.NewTransactionIDSetter(message.TransactionID), stun.BindingSuccess)
+		return response.Raw
+	})
+	defer stop()
+	client := &geolocate.STUNClient{Servers: []string{address}}
+	ip, err := client.Lookup(context.Background(), log.Log)
+	if !errors.Is(err, geolocate.ErrSTUNInvalidResponse) {
+		t.Fatal("not the error we expected")
+	}
+	if ip != model.DefaultProbeIP {
+		t.Fatal("expected the default IP here")
+	}
+}
+
+func TestSTUNClientTimeout(t *testing.T) {
+	address, stop := newSTUNServer(t, func(request []byte) []byte {
+		return nil // never reply
+	})
+	defer stop()
+	client := &geolocate.STUNClient{
+		Servers: []string{address},
+		Timeout: 50 * time.Millisecond,
+	}
+	_, err := client.Lookup(context.Background(), log.Log)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected")
+	}
+	if client.Failures()[address] != 1 {
+		t.Fatal("did not count the failure")
+	}
+}
+
+func TestSTUNClientNoServers(t *testing.T) {
+	client := &geolocate.STUNClient{}
+	if _, err := client.Lookup(context.Background(), log.Log); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestWithoutSTUN(t *testing.T) {
+	methods := geolocate.WithoutSTUN(geolocate.DefaultIPLookupMethods())
+	if len(methods) != len(geolocate.DefaultIPLookupMethods())-1 {
+		t.Fatal("unexpected number of methods")
+	}
+	for _, method := range methods {
+		if method.Name == "stun" {
+			t.Fatal("we did not remove stun")
+		}
+	}
+}
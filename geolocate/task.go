@@ -0,0 +1,245 @@
+package geolocate
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	// ConfidenceHigh indicates that several IP lookup methods
+	// agree on the probe IP and none of them disagrees.
+	ConfidenceHigh = "high"
+
+	// ConfidenceMedium indicates that most IP lookup methods agree on
+	// the probe IP, or that only a single method has succeeded.
+	ConfidenceMedium = "medium"
+
+	// ConfidenceLow indicates that there is no majority agreeing on
+	// the probe IP, so the results may be wrong.
+	ConfidenceLow = "low"
+)
+
+const (
+	// SourceASNDatabase indicates that a field comes from the ASN database.
+	SourceASNDatabase = "asn_database"
+
+	// SourceCountryDatabase indicates that a field comes from the country database.
+	SourceCountryDatabase = "country_database"
+
+	// SourceResolverLookup indicates that a field comes from the resolver
+	// lookup (see LookupFirstResolverIP).
+	SourceResolverLookup = "resolver_lookup"
+)
+
+// Config contains the Task config. The ASNDatabasePath, CountryDatabasePath,
+// and Logger fields are mandatory. The database paths must point to databases
+// that you have already downloaded using, e.g., resources.Client.Ensure.
+type Config struct {
+	// ASNDatabasePath is the path of the ASN database.
+	ASNDatabasePath string
+
+	// CountryDatabasePath is the path of the country database.
+	CountryDatabasePath string
+
+	// EnableResolverLookup indicates whether we should also
+	// discover the resolver IP, ASN, and network name.
+	EnableResolverLookup bool
+
+	// HTTPClient is the HTTP client to use. If nil, we
+	// use http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Logger is the logger to use.
+	Logger model.Logger
+
+	// Methods contains the IP lookup methods to use. If empty, we
+	// use the methods returned by DefaultIPLookupMethods.
+	Methods []IPLookupMethod
+
+	// Resolver is the resolver to use for the resolver lookup. If
+	// nil, we use the system resolver.
+	Resolver HostLookupper
+
+	// UserAgent is the user agent to use.
+	UserAgent string
+}
+
+// Task performs a geolocation without requiring a measurement session.
+type Task struct {
+	config Config
+}
+
+// NewTask creates a new Task with the specified config.
+func NewTask(config Config) *Task {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &Task{config: config}
+}
+
+// Results contains the results of Task.Run.
+type Results struct {
+	// ASN is the probe ASN.
+	ASN uint
+
+	// Confidence is one of ConfidenceHigh, ConfidenceMedium,
+	// and ConfidenceLow and describes how much we trust the
+	// discovered probe IP and hence all the other fields.
+	Confidence string
+
+	// CountryCode is the probe country code.
+	CountryCode string
+
+	// FallbackDatabaseDate is the FallbackDatabaseDate() when we
+	// have used the fallback database for any field, empty otherwise.
+	FallbackDatabaseDate string
+
+	// IPLookupSummary contains the results of each IP lookup method.
+	IPLookupSummary *IPLookupSummary
+
+	// NetworkName is the probe network name.
+	NetworkName string
+
+	// ProbeIP is the probe IP.
+	ProbeIP string
+
+	// ResolverASN is the resolver ASN.
+	ResolverASN uint
+
+	// ResolverIP is the resolver IP.
+	ResolverIP string
+
+	// ResolverNetworkName is the resolver network name.
+	ResolverNetworkName string
+
+	// ResolverNetworkType is the return value of ClassifyResolver.
+	ResolverNetworkType string
+
+	// Sources tells which method produced each field.
+	Sources Sources
+}
+
+// Sources tells which method produced each field of Results. Each
+// field is empty when we have not discovered the corresponding value.
+type Sources struct {
+	// ASN is the source of Results.ASN and Results.NetworkName.
+	ASN string
+
+	// CountryCode is the source of Results.CountryCode.
+	CountryCode string
+
+	// ProbeIP contains the names of the IP lookup methods
+	// that returned Results.ProbeIP.
+	ProbeIP []string
+
+	// ResolverASN is the source of Results.ResolverASN
+	// and Results.ResolverNetworkName.
+	ResolverASN string
+
+	// ResolverIP is the source of Results.ResolverIP.
+	ResolverIP string
+}
+
+// Run performs the geolocation. We return an error if we cannot discover
+// the probe IP or lookup its ASN and country code. When the resolver lookup
+// is enabled, we also return an error if it fails.
+func (t *Task) Run(ctx context.Context) (*Results, error) {
+	out := &Results{
+		ASN:                 model.DefaultProbeASN,
+		Confidence:          ConfidenceLow,
+		CountryCode:         model.DefaultProbeCC,
+		NetworkName:         model.DefaultProbeNetworkName,
+		ProbeIP:             model.DefaultProbeIP,
+		ResolverASN:         model.DefaultResolverASN,
+		ResolverIP:          model.DefaultResolverIP,
+		ResolverNetworkName: model.DefaultResolverNetworkName,
+		ResolverNetworkType: ResolverNetworkTypeUnknown,
+	}
+	summary, err := (&IPLookupClient{
+		HTTPClient: t.config.HTTPClient,
+		Logger:     t.config.Logger,
+		Methods:    t.config.Methods,
+		UserAgent:  t.config.UserAgent,
+	}).DoWithSummary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out.IPLookupSummary = summary
+	out.ProbeIP = summary.IP
+	out.Confidence = ipLookupConfidence(summary)
+	for _, result := range summary.Results {
+		if result.Err == nil && result.IP == summary.IP {
+			out.Sources.ProbeIP = append(out.Sources.ProbeIP, result.Method)
+		}
+	}
+	out.ASN, out.NetworkName, out.Sources.ASN, err = t.lookupASN(out, out.ProbeIP)
+	if err != nil {
+		return nil, err
+	}
+	out.CountryCode, out.Sources.CountryCode, err = t.lookupCC(out, out.ProbeIP)
+	if err != nil {
+		return nil, err
+	}
+	if !t.config.EnableResolverLookup {
+		return out, nil
+	}
+	out.ResolverIP, err = LookupFirstResolverIP(ctx, t.config.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	out.Sources.ResolverIP = SourceResolverLookup
+	out.ResolverASN, out.ResolverNetworkName, out.Sources.ResolverASN, err = t.lookupASN(
+		out, out.ResolverIP)
+	if err != nil {
+		return nil, err
+	}
+	out.ResolverNetworkType = ClassifyResolver(out.ASN, out.ResolverASN)
+	return out, nil
+}
+
+// lookupASN uses LookupASN and falls back to LookupFallbackASN when
+// the ASN database is not available. It returns the ASN, the org, and
+// the source of such values. When it falls back, it sets the
+// FallbackDatabaseDate of out. We return the error of LookupASN if
+// ip is not a valid IP address.
+func (t *Task) lookupASN(out *Results, ip string) (uint, string, string, error) {
+	asn, org, err := LookupASN(t.config.ASNDatabasePath, ip)
+	if err == nil {
+		return asn, org, SourceASNDatabase, nil
+	}
+	fasn, forg, ferr := LookupFallbackASN(ip)
+	if ferr != nil {
+		return 0, "", "", err
+	}
+	t.config.Logger.Warnf("geolocate: using the fallback ASN database: %s", err.Error())
+	out.FallbackDatabaseDate = FallbackDatabaseDate()
+	return fasn, forg, SourceFallbackDatabase, nil
+}
+
+// lookupCC is like lookupASN but for the country code.
+func (t *Task) lookupCC(out *Results, ip string) (string, string, error) {
+	cc, err := LookupCC(t.config.CountryDatabasePath, ip)
+	if err == nil {
+		return cc, SourceCountryDatabase, nil
+	}
+	fcc, ferr := LookupFallbackCC(ip)
+	if ferr != nil {
+		return "", "", err
+	}
+	t.config.Logger.Warnf("geolocate: using the fallback country database: %s", err.Error())
+	out.FallbackDatabaseDate = FallbackDatabaseDate()
+	return fcc, SourceFallbackDatabase, nil
+}
+
+func ipLookupConfidence(summary *IPLookupSummary) string {
+	switch {
+	case summary.Votes >= 2 && len(summary.Disagreements) <= 0:
+		return ConfidenceHigh
+	case summary.Votes > len(summary.Disagreements):
+		return ConfidenceMedium
+	default:
+		return ConfidenceLow
+	}
+}
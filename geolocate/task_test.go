@@ -0,0 +1,167 @@
+package geolocate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/geolocate"
+)
+
+type fakeHostLookupper struct {
+	addrs []string
+	err   error
+}
+
+func (r *fakeHostLookupper) LookupHost(
+	ctx context.Context, host string,
+) ([]string, error) {
+	return r.addrs, r.err
+}
+
+func newGeolocateTask(methods []geolocate.IPLookupMethod) *geolocate.Task {
+	return geolocate.NewTask(geolocate.Config{
+		ASNDatabasePath:      asnDBPath,
+		CountryDatabasePath:  countryDBPath,
+		EnableResolverLookup: true,
+		Logger:               log.Log,
+		Methods:              methods,
+		Resolver:             &fakeHostLookupper{addrs: []string{"8.8.8.8"}},
+	})
+}
+
+func TestTaskRunHighConfidence(t *testing.T) {
+	maybeFetchResources(t)
+	results, err := newGeolocateTask([]geolocate.IPLookupMethod{{
+		Name: "first",
+		Func: newFakeIPLookup(ipAddr, nil, 0),
+	}, {
+		Name: "second",
+		Func: newFakeIPLookup(ipAddr, nil, 0),
+	}}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.ProbeIP != ipAddr || results.Confidence != geolocate.ConfidenceHigh {
+		t.Fatal("not the results we expected")
+	}
+	if len(results.Sources.ProbeIP) != 2 {
+		t.Fatal("not the probe IP sources we expected")
+	}
+	if results.ASN == 0 || results.CountryCode == "ZZ" {
+		t.Fatal("not the location we expected")
+	}
+	if results.Sources.ASN != geolocate.SourceASNDatabase ||
+		results.Sources.CountryCode != geolocate.SourceCountryDatabase {
+		t.Fatal("not the location sources we expected")
+	}
+	if results.ResolverIP != "8.8.8.8" || results.ResolverASN != 15169 {
+		t.Fatal("not the resolver we expected")
+	}
+	if results.ResolverNetworkType != geolocate.ResolverNetworkTypePublic {
+		t.Fatal("not the resolver network type we expected")
+	}
+	if results.Sources.ResolverIP != geolocate.SourceResolverLookup ||
+		results.Sources.ResolverASN != geolocate.SourceASNDatabase {
+		t.Fatal("not the resolver sources we expected")
+	}
+}
+
+func TestTaskRunLowConfidence(t *testing.T) {
+	maybeFetchResources(t)
+	results, err := newGeolocateTask([]geolocate.IPLookupMethod{{
+		Name: "first",
+		Func: newFakeIPLookup(ipAddr, nil, 0),
+	}, {
+		Name: "second",
+		Func: newFakeIPLookup("8.8.8.8", nil, 0),
+	}}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Confidence != geolocate.ConfidenceLow {
+		t.Fatal("not the confidence we expected")
+	}
+	if len(results.IPLookupSummary.Disagreements) != 1 {
+		t.Fatal("not the disagreements we expected")
+	}
+}
+
+func TestTaskRunMediumConfidence(t *testing.T) {
+	maybeFetchResources(t)
+	results, err := newGeolocateTask([]geolocate.IPLookupMethod{{
+		Name: "first",
+		Func: newFakeIPLookup(ipAddr, nil, 0),
+	}, {
+		Name: "broken",
+		Func: newFakeIPLookup("", errors.New("mocked error"), 0),
+	}}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Confidence != geolocate.ConfidenceMedium {
+		t.Fatal("not the confidence we expected")
+	}
+	if len(results.Sources.ProbeIP) != 1 || results.Sources.ProbeIP[0] != "first" {
+		t.Fatal("not the probe IP sources we expected")
+	}
+}
+
+func TestTaskRunIPLookupFailure(t *testing.T) {
+	results, err := newGeolocateTask([]geolocate.IPLookupMethod{{
+		Name: "broken",
+		Func: newFakeIPLookup("", errors.New("mocked error"), 0),
+	}}).Run(context.Background())
+	if !errors.Is(err, geolocate.ErrAllIPLookuppersFailed) {
+		t.Fatal("not the error we expected")
+	}
+	if results != nil {
+		t.Fatal("expected nil results here")
+	}
+}
+
+func TestTaskRunASNDatabaseFailure(t *testing.T) {
+	results, err := geolocate.NewTask(geolocate.Config{
+		ASNDatabasePath:     "/nonexistent",
+		CountryDatabasePath: "/nonexistent",
+		Logger:              log.Log,
+		Methods: []geolocate.IPLookupMethod{{
+			Name: "first",
+			Func: newFakeIPLookup(ipAddr, nil, 0),
+		}},
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without the databases, we use the fallback database
+	if results.Sources.ASN != geolocate.SourceFallbackDatabase ||
+		results.Sources.CountryCode != geolocate.SourceFallbackDatabase {
+		t.Fatal("not the location sources we expected")
+	}
+	if results.FallbackDatabaseDate != geolocate.FallbackDatabaseDate() {
+		t.Fatal("not the fallback database date we expected")
+	}
+}
+
+func TestTaskRunResolverLookupFailure(t *testing.T) {
+	maybeFetchResources(t)
+	expected := errors.New("mocked error")
+	results, err := geolocate.NewTask(geolocate.Config{
+		ASNDatabasePath:      asnDBPath,
+		CountryDatabasePath:  countryDBPath,
+		EnableResolverLookup: true,
+		Logger:               log.Log,
+		Methods: []geolocate.IPLookupMethod{{
+			Name: "first",
+			Func: newFakeIPLookup(ipAddr, nil, 0),
+		}},
+		Resolver: &fakeHostLookupper{err: expected},
+	}).Run(context.Background())
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if results != nil {
+		t.Fatal("expected nil results here")
+	}
+}
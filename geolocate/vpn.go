@@ -0,0 +1,37 @@
+package geolocate
+
+// DatacenterASNs maps the ASNs of well known hosting and cloud providers,
+// where most commercial VPNs and proxies run, to the provider name.
+var DatacenterASNs = map[uint]string{
+	8075:   "Microsoft",
+	9009:   "M247",
+	14061:  "DigitalOcean",
+	14618:  "Amazon",
+	16276:  "OVH",
+	16509:  "Amazon",
+	20473:  "Vultr",
+	24940:  "Hetzner",
+	45102:  "Alibaba",
+	51167:  "Contabo",
+	60068:  "Datacamp",
+	63949:  "Linode",
+	212238: "Datacamp",
+	396982: "Google Cloud",
+}
+
+// DetectVPN returns whether the probe is likely using a VPN or a proxy, in
+// which case the measurements may not reflect the user's ISP. We say that
+// the probe is likely using a VPN when the probe ASN belongs to a datacenter
+// (see DatacenterASNs) or when the resolver belongs to a network that is
+// neither the probe's network nor a public resolver, which typically happens
+// when traffic goes through a tunnel but DNS still uses the ISP's resolver. The
+// resolverNetworkType argument is the return value of ClassifyResolver.
+//
+// This is just a hint: ISPs announcing several ASNs and corporate networks
+// also cause the resolver network type to be ResolverNetworkTypeOther.
+func DetectVPN(probeASN uint, resolverNetworkType string) bool {
+	if _, found := DatacenterASNs[probeASN]; found {
+		return true
+	}
+	return resolverNetworkType == ResolverNetworkTypeOther
+}
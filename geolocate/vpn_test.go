@@ -0,0 +1,28 @@
+package geolocate_test
+
+import (
+	"testing"
+
+	"github.com/ooni/probe-engine/geolocate"
+)
+
+func TestDetectVPN(t *testing.T) {
+	var cases = []struct {
+		probeASN     uint
+		resolverType string
+		expected     bool
+	}{
+		{30722, geolocate.ResolverNetworkTypeISP, false},
+		{30722, geolocate.ResolverNetworkTypePublic, false},
+		{30722, geolocate.ResolverNetworkTypeUnknown, false},
+		{30722, geolocate.ResolverNetworkTypeOther, true},
+		{9009, geolocate.ResolverNetworkTypeISP, true},
+		{16509, geolocate.ResolverNetworkTypePublic, true},
+		{0, geolocate.ResolverNetworkTypeUnknown, false},
+	}
+	for _, c := range cases {
+		if out := geolocate.DetectVPN(c.probeASN, c.resolverType); out != c.expected {
+			t.Fatalf("AS%d/%s: expected %t, got %t", c.probeASN, c.resolverType, c.expected, out)
+		}
+	}
+}
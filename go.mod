@@ -6,13 +6,13 @@ require (
 	git.torproject.org/pluggable-transports/goptlib.git v1.1.0
 	github.com/AndreasBriese/bbloom v0.0.0-20170702084017-28f7e881ca57 // indirect
 	github.com/Psiphon-Inc/rotate-safe-writer v0.0.0-20170228160301-b276127301a9 // indirect
-	github.com/Psiphon-Labs/bolt v0.0.0-20200624191537-23cedaef7ad7 // indirect
+	github.com/Psiphon-Labs/bolt v0.0.0-20200624191537-23cedaef7ad7
 	github.com/Psiphon-Labs/chacha20 v0.2.1-0.20200128191310-899a4be52863 // indirect
 	github.com/Psiphon-Labs/goarista v0.0.0-20160825065156-d002785f4c67 // indirect
 	github.com/Psiphon-Labs/goptlib v0.0.0-20200406165125-c0e32a7a3464 // indirect
 	github.com/Psiphon-Labs/net v0.0.0-20191204183604-f5d60dada742 // indirect
 	github.com/Psiphon-Labs/psiphon-tunnel-core v2.0.12-0.20200819184412-10cb0192d244+incompatible
-	github.com/Psiphon-Labs/quic-go v0.14.1-0.20200306193310-474e74c89fab // indirect
+	github.com/Psiphon-Labs/quic-go v0.14.1-0.20200306193310-474e74c89fab
 	github.com/Psiphon-Labs/tls-tris v0.0.0-20200610161156-7d791789810f // indirect
 	github.com/agl/ed25519 v0.0.0-20170116200512-5312a6153412 // indirect
 	github.com/apex/log v1.9.0
@@ -42,12 +42,13 @@ require (
 	github.com/miekg/dns v1.1.31
 	github.com/montanaflynn/stats v0.6.3
 	github.com/oschwald/geoip2-golang v1.4.0
+	github.com/oschwald/maxminddb-golang v1.6.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
 	github.com/pborman/getopt/v2 v2.1.0
 	github.com/pion/stun v0.3.5
 	github.com/redjack/marionette v0.0.0-20180818172807-360dd8f58226 // indirect
 	github.com/refraction-networking/gotapdance v0.0.0-20190909202946-3a6e1938ad70 // indirect
-	github.com/refraction-networking/utls v0.0.0-20200729012536-186025ac7b77 // indirect
+	github.com/refraction-networking/utls v0.0.0-20200729012536-186025ac7b77
 	github.com/rogpeppe/go-internal v1.6.1
 	github.com/ryanuber/go-glob v0.0.0-20170128012129-256dc444b735 // indirect
 	github.com/sergeyfrolov/bsbuffer v0.0.0-20180903213811-94e85abb8507 // indirect
@@ -59,6 +60,7 @@ require (
 	go.uber.org/atomic v1.3.3-0.20180806045314-ca680462431f // indirect
 	go.uber.org/multierr v1.1.1-0.20180122172545-ddea229ff1df // indirect
 	go.uber.org/zap v1.9.2-0.20180814183419-67bc79d13d15 // indirect
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc
 	golang.org/x/sys v0.0.0-20200819171115-d785dc25833f // indirect
 )
@@ -0,0 +1,287 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/litemode"
+	"github.com/ooni/probe-engine/model"
+)
+
+// DefaultInputLoaderCheckInLimit is the default maximum number of URLs
+// that InputLoader fetches from the probe services.
+const DefaultInputLoaderCheckInLimit = 17
+
+var (
+	// ErrNoInputExpected indicates that the experiment does not
+	// take any input and you provided some input.
+	ErrNoInputExpected = errors.New("inputloader: this experiment does not expect any input")
+
+	// ErrNoInputProvided indicates that the experiment requires
+	// input and we could not find any input.
+	ErrNoInputProvided = errors.New("inputloader: no input provided")
+)
+
+// InputLoaderSession is the session view used by InputLoader.
+type InputLoaderSession interface {
+	FetchURLList(ctx context.Context, config model.FetchURLListConfig) ([]model.URLInfo, error)
+	LiteMode() bool
+}
+
+// InputLoader loads the inputs of an experiment. Callers should
+// make sure that InputPolicy is the policy of the experiment they
+// want to run. We collect inputs from StaticInputs, from each of the
+// files in SourceFiles, and from Stdin, if not nil. Files and Stdin
+// contain an input per line; we skip empty lines and lines starting
+// with "#". A line starting with "{" is a JSON serialized model.URLInfo,
+// which allows to specify per-input experiment options, as in
+//
+//     {"url": "https://example.com/", "options": {"HTTPHeaders": {"Cookie": "a=b"}}}
+//
+// Files with the ".csv" extension are instead CSV files whose first
+// record is a header naming the columns. The "url" column is required,
+// the "category_code" and "country_code" columns are optional, and
+// every other column is a per-input experiment option (we skip empty
+// cells, so inputs can use the default value of an option). When the experiment requires input and we have not found
+// any input, and NoCheckIn is false, we fetch the URLs to measure
+// from the probe services using Session (fetching fewer URLs when
+// the session is in lite mode). When Categories is not empty,
+// we only keep the URLs whose category is in Categories; inputs with
+// unknown category (i.e., all the inputs that do not come from the
+// probe services) are not filtered. We remove duplicate inputs, i.e.,
+// inputs with the same URL and options, keeping the first occurrence. If Shuffle is true, we shuffle the inputs. If
+// MaxInputs is positive, we return at most MaxInputs inputs.
+type InputLoader struct {
+	Categories    []string
+	DefaultInputs []string
+	InputPolicy   InputPolicy
+	MaxInputs     int
+	NoCheckIn     bool
+	Session       InputLoaderSession
+	Shuffle       bool
+	SourceFiles   []string
+	StaticInputs  []string
+	Stdin         io.Reader
+}
+
+// Load loads the inputs. For experiments with optional input, when we
+// have not found any input, we use DefaultInputs (see the DefaultInputs
+// method of ExperimentBuilder). For experiments not taking any input, and
+// for experiments with optional input and no DefaultInputs, we return a
+// single empty input, because experiments that do not take input still
+// require an empty input to run.
+func (il *InputLoader) Load(ctx context.Context) ([]model.URLInfo, error) {
+	inputs, err := il.loadLocal()
+	if err != nil {
+		return nil, err
+	}
+	switch il.InputPolicy {
+	case InputNone:
+		if len(inputs) > 0 {
+			return nil, ErrNoInputExpected
+		}
+		return []model.URLInfo{{}}, nil
+	case InputOptional:
+		if len(inputs) <= 0 && len(il.DefaultInputs) <= 0 {
+			return []model.URLInfo{{}}, nil
+		}
+		if len(inputs) <= 0 {
+			for _, input := range il.DefaultInputs {
+				inputs = append(inputs, model.URLInfo{URL: input})
+			}
+		}
+	default:
+		if len(inputs) <= 0 && !il.NoCheckIn {
+			if inputs, err = il.loadCheckIn(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	inputs = il.postprocess(inputs)
+	if il.InputPolicy == InputRequired && len(inputs) <= 0 {
+		return nil, ErrNoInputProvided
+	}
+	return inputs, nil
+}
+
+// LoadInputs is like Load but only returns the inputs.
+func (il *InputLoader) LoadInputs(ctx context.Context) ([]string, error) {
+	entries, err := il.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, entry := range entries {
+		out = append(out, entry.URL)
+	}
+	return out, nil
+}
+
+func (il *InputLoader) loadLocal() ([]model.URLInfo, error) {
+	var out []model.URLInfo
+	for _, input := range il.StaticInputs {
+		out = append(out, model.URLInfo{URL: input})
+	}
+	for _, filepath := range il.SourceFiles {
+		inputs, err := il.readFile(filepath)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inputs...)
+	}
+	if il.Stdin != nil {
+		inputs, err := il.readInputs(il.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("inputloader: cannot read stdin: %w", err)
+		}
+		out = append(out, inputs...)
+	}
+	return out, nil
+}
+
+func (il *InputLoader) readFile(path string) ([]model.URLInfo, error) {
+	filep, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("inputloader: %w", err)
+	}
+	defer filep.Close()
+	read := il.readInputs
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		read = il.readCSV
+	}
+	inputs, err := read(filep)
+	if err != nil {
+		return nil, fmt.Errorf("inputloader: cannot read %s: %w", path, err)
+	}
+	return inputs, nil
+}
+
+func (il *InputLoader) readInputs(reader io.Reader) ([]model.URLInfo, error) {
+	var out []model.URLInfo
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "{") {
+			out = append(out, model.URLInfo{URL: line})
+			continue
+		}
+		var input model.URLInfo
+		if err := json.Unmarshal([]byte(line), &input); err != nil {
+			return nil, err
+		}
+		if input.URL == "" {
+			return nil, fmt.Errorf("missing url in %s", line)
+		}
+		out = append(out, input)
+	}
+	return out, scanner.Err()
+}
+
+func (il *InputLoader) readCSV(reader io.Reader) ([]model.URLInfo, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comment = '#'
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) <= 0 {
+		return nil, nil
+	}
+	header := records[0]
+	urlColumn := -1
+	for idx, name := range header {
+		if name == "url" {
+			urlColumn = idx
+		}
+	}
+	if urlColumn < 0 {
+		return nil, errors.New("missing url column")
+	}
+	var out []model.URLInfo
+	for _, record := range records[1:] {
+		input := model.URLInfo{URL: strings.TrimSpace(record[urlColumn])}
+		if input.URL == "" {
+			continue
+		}
+		for idx, name := range header {
+			value := strings.TrimSpace(record[idx])
+			switch {
+			case idx == urlColumn, value == "":
+			case name == "category_code":
+				input.CategoryCode = value
+			case name == "country_code":
+				input.CountryCode = value
+			default:
+				if input.Options == nil {
+					input.Options = make(map[string]interface{})
+				}
+				input.Options[name] = value
+			}
+		}
+		out = append(out, input)
+	}
+	return out, nil
+}
+
+func (il *InputLoader) loadCheckIn(ctx context.Context) ([]model.URLInfo, error) {
+	if il.Session == nil {
+		return nil, ErrNoInputProvided
+	}
+	limit := int64(il.MaxInputs)
+	if limit <= 0 {
+		limit = DefaultInputLoaderCheckInLimit
+		if il.Session.LiteMode() {
+			limit = litemode.CheckInLimit
+		}
+	}
+	return il.Session.FetchURLList(ctx, model.FetchURLListConfig{
+		Categories: il.Categories,
+		Limit:      limit,
+	})
+}
+
+func (il *InputLoader) postprocess(inputs []model.URLInfo) []model.URLInfo {
+	categories := make(map[string]bool)
+	for _, category := range il.Categories {
+		categories[category] = true
+	}
+	seen := make(map[string]bool)
+	var out []model.URLInfo
+	for _, input := range inputs {
+		// Note: json.Marshal sorts the keys of maps
+		options, _ := json.Marshal(input.Options)
+		key := input.URL + " " + string(options)
+		if seen[key] {
+			continue
+		}
+		if len(categories) > 0 && input.CategoryCode != "" &&
+			!categories[input.CategoryCode] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, input)
+	}
+	if il.Shuffle {
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		rnd.Shuffle(len(out), func(i, j int) {
+			out[i], out[j] = out[j], out[i]
+		})
+	}
+	if il.MaxInputs > 0 && len(out) > il.MaxInputs {
+		out = out[:il.MaxInputs]
+	}
+	return out
+}
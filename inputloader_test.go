@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/internal/litemode"
+	"github.com/ooni/probe-engine/model"
+)
+
+type inputLoaderFakeSession struct {
+	config   model.FetchURLListConfig
+	err      error
+	liteMode bool
+	urls     []model.URLInfo
+}
+
+func (sess *inputLoaderFakeSession) LiteMode() bool {
+	return sess.liteMode
+}
+
+func (sess *inputLoaderFakeSession) FetchURLList(
+	ctx context.Context, config model.FetchURLListConfig) ([]model.URLInfo, error) {
+	sess.config = config
+	return sess.urls, sess.err
+}
+
+func TestInputLoaderInputNone(t *testing.T) {
+	il := &InputLoader{InputPolicy: InputNone}
+	out, err := il.LoadInputs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{""}, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderInputNoneWithInput(t *testing.T) {
+	il := &InputLoader{InputPolicy: InputNone, StaticInputs: []string{"x"}}
+	out, err := il.LoadInputs(context.Background())
+	if !errors.Is(err, ErrNoInputExpected) {
+		t.Fatal("not the error we expected")
+	}
+	if out != nil {
+		t.Fatal("expected nil output here")
+	}
+}
+
+func TestInputLoaderInputOptional(t *testing.T) {
+	il := &InputLoader{InputPolicy: InputOptional, Session: &inputLoaderFakeSession{
+		err: errors.New("should not be called"),
+	}}
+	out, err := il.LoadInputs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{""}, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderInputOptionalWithDefaultInputs(t *testing.T) {
+	il := &InputLoader{
+		DefaultInputs: []string{"a.org:3478", "b.org:3478"},
+		InputPolicy:   InputOptional,
+		MaxInputs:     1,
+	}
+	out, err := il.LoadInputs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"a.org:3478"}, out); diff != "" {
+		t.Fatal(diff)
+	}
+	il.StaticInputs = []string{"c.org:3478"}
+	out, err = il.LoadInputs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"c.org:3478"}, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderLocalSources(t *testing.T) {
+	il := &InputLoader{
+		InputPolicy:  InputRequired,
+		SourceFiles:  []string{"testdata/inputloader1.txt"},
+		StaticInputs: []string{"https://www.example.com/", "https://x.org/"},
+		Stdin:        strings.NewReader("https://y.org/\n  https://x.org/  \n"),
+	}
+	out, err := il.LoadInputs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"https://www.example.com/", "https://x.org/",
+		"http://www.example.org/", "https://y.org/",
+	}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderOptions(t *testing.T) {
+	il := &InputLoader{
+		InputPolicy: InputRequired,
+		SourceFiles: []string{"testdata/inputloader2.csv"},
+		Stdin: strings.NewReader(`{"url": "https://x.org/", "options": {"Repetitions": 3}}
+https://x.org/
+{"url": "https://www.example.com/", "options": {"Method": "POST"}}
+`),
+	}
+	out, err := il.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.URLInfo{{
+		CategoryCode: "NEWS",
+		Options:      map[string]interface{}{"Method": "POST"},
+		URL:          "https://www.example.com/",
+	}, {
+		CategoryCode: "NEWS",
+		Options:      map[string]interface{}{"HTTPHost": "example.org"},
+		URL:          "https://www.example.com/",
+	}, {
+		URL: "http://www.example.org/",
+	}, {
+		Options: map[string]interface{}{"Repetitions": float64(3)},
+		URL:     "https://x.org/",
+	}, {
+		URL: "https://x.org/",
+	}}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderInvalidOptions(t *testing.T) {
+	for _, stdin := range []string{`{"url": `, `{"options": {"Method": "POST"}}`} {
+		il := &InputLoader{InputPolicy: InputRequired, Stdin: strings.NewReader(stdin)}
+		if _, err := il.Load(context.Background()); err == nil {
+			t.Fatal("expected an error here", stdin)
+		}
+	}
+}
+
+func TestInputLoaderNonexistentFile(t *testing.T) {
+	il := &InputLoader{
+		InputPolicy: InputRequired,
+		SourceFiles: []string{"testdata/nonexistent.txt"},
+	}
+	out, err := il.LoadInputs(context.Background())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("not the error we expected")
+	}
+	if out != nil {
+		t.Fatal("expected nil output here")
+	}
+}
+
+func TestInputLoaderCheckIn(t *testing.T) {
+	sess := &inputLoaderFakeSession{urls: []model.URLInfo{
+		{CategoryCode: "NEWS", URL: "https://a.org/"},
+		{CategoryCode: "POLR", URL: "https://b.org/"},
+		{CategoryCode: "NEWS", URL: "https://a.org/"},
+		{CategoryCode: "HUMR", URL: "https://c.org/"},
+		{CategoryCode: "NEWS", URL: "https://d.org/"},
+	}}
+	il := &InputLoader{
+		Categories:  []string{"NEWS", "POLR"},
+		InputPolicy: InputRequired,
+		MaxInputs:   2,
+		Session:     sess,
+	}
+	out, err := il.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.URLInfo{
+		{CategoryCode: "NEWS", URL: "https://a.org/"},
+		{CategoryCode: "POLR", URL: "https://b.org/"},
+	}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+	if sess.config.Limit != 2 {
+		t.Fatal("not the limit we expected")
+	}
+	if diff := cmp.Diff(il.Categories, sess.config.Categories); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderCheckInDefaultLimit(t *testing.T) {
+	sess := &inputLoaderFakeSession{}
+	il := &InputLoader{InputPolicy: InputRequired, Session: sess}
+	out, err := il.Load(context.Background())
+	if !errors.Is(err, ErrNoInputProvided) {
+		t.Fatal("not the error we expected")
+	}
+	if out != nil {
+		t.Fatal("expected nil output here")
+	}
+	if sess.config.Limit != DefaultInputLoaderCheckInLimit {
+		t.Fatal("not the limit we expected")
+	}
+}
+
+func TestInputLoaderCheckInFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	il := &InputLoader{
+		InputPolicy: InputRequired,
+		Session:     &inputLoaderFakeSession{err: expected},
+	}
+	if _, err := il.Load(context.Background()); !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestInputLoaderNoCheckIn(t *testing.T) {
+	il := &InputLoader{
+		InputPolicy: InputRequired,
+		NoCheckIn:   true,
+		Session:     &inputLoaderFakeSession{err: errors.New("should not be called")},
+	}
+	if _, err := il.Load(context.Background()); !errors.Is(err, ErrNoInputProvided) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestInputLoaderShuffleAndMaxInputs(t *testing.T) {
+	var inputs []string
+	for idx := 0; idx < 128; idx++ {
+		inputs = append(inputs, strings.Repeat("x", idx+1))
+	}
+	il := &InputLoader{
+		InputPolicy:  InputRequired,
+		MaxInputs:    100,
+		Shuffle:      true,
+		StaticInputs: inputs,
+	}
+	out, err := il.LoadInputs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 100 {
+		t.Fatal("not the number of inputs we expected")
+	}
+	if cmp.Equal(inputs[:100], out) {
+		t.Fatal("inputs were not shuffled")
+	}
+}
+
+func TestInputLoaderCheckInLiteMode(t *testing.T) {
+	sess := &inputLoaderFakeSession{liteMode: true}
+	il := &InputLoader{InputPolicy: InputRequired, Session: sess}
+	if _, err := il.Load(context.Background()); !errors.Is(err, ErrNoInputProvided) {
+		t.Fatal("not the error we expected")
+	}
+	if sess.config.Limit != litemode.CheckInLimit {
+		t.Fatal("not the limit we expected")
+	}
+}
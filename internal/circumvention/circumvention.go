@@ -0,0 +1,154 @@
+// Package circumvention contains the policy with which the session
+// chooses the channel it uses to communicate with the OONI backend.
+//
+// We try these channels in order: "direct", i.e., the HTTPS probe
+// services; "cloudfront", i.e., the cloudfronted probe services; and
+// "psiphon" and "tor", i.e., the HTTPS probe services through the
+// corresponding tunnel. We remember in the key-value store the channel
+// that worked, so that next time we try it first. We forget such channel
+// after RememberedMaxAge, so that we periodically retry the chain in
+// order, e.g., to use direct again once it is not blocked anymore.
+package circumvention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	// Cloudfront uses the cloudfronted probe services.
+	Cloudfront = "cloudfront"
+
+	// Direct uses the HTTPS probe services.
+	Direct = "direct"
+
+	// Psiphon uses the HTTPS probe services through psiphon.
+	Psiphon = "psiphon"
+
+	// Tor uses the HTTPS probe services through tor.
+	Tor = "tor"
+)
+
+// DefaultChain is the default order in which we try the channels.
+var DefaultChain = []string{Direct, Cloudfront, Psiphon, Tor}
+
+// RememberedMaxAge is the time after which we forget the channel that worked.
+const RememberedMaxAge = 24 * time.Hour
+
+// ErrAllChannelsFailed indicates that no channel worked.
+var ErrAllChannelsFailed = errors.New("circumvention: all channels failed")
+
+// Attempt contains information on an attempt at using a channel.
+type Attempt struct {
+	// Channel is the channel we have been using.
+	Channel string
+
+	// Duration is the time it took to perform the attempt.
+	Duration time.Duration
+
+	// Err is the error that occurred, or nil on success.
+	Err error
+}
+
+// Policy tries the channels in Chain using Try, which should return
+// nil if it could communicate with the backend using the channel. Use
+// DefaultChain when Chain is empty. KVStore, if not nil, is where we
+// remember the channel that worked.
+type Policy struct {
+	Chain   []string
+	KVStore model.KeyValueStore
+	Logger  model.Logger
+	Try     func(ctx context.Context, channel string) error
+}
+
+const stateKey = "circumvention.state"
+
+type state struct {
+	Channel string    `json:"channel"`
+	Time    time.Time `json:"time"`
+}
+
+// Run tries the channels in order until one works, starting from the
+// one that worked last time, if any. It returns all the attempts and
+// either the channel that worked or an error wrapping ErrAllChannelsFailed.
+func (p Policy) Run(ctx context.Context) (string, []Attempt, error) {
+	var attempts []Attempt
+	for _, channel := range p.order() {
+		if ctx.Err() != nil {
+			break
+		}
+		start := time.Now()
+		err := p.Try(ctx, channel)
+		attempts = append(attempts, Attempt{
+			Channel:  channel,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+		if err != nil {
+			p.Logger.Debugf("circumvention: %s: %s", channel, err.Error())
+			continue
+		}
+		p.Logger.Infof("circumvention: using %s to reach the backend", channel)
+		p.remember(channel)
+		return channel, attempts, nil
+	}
+	return "", attempts, ErrAllChannelsFailed
+}
+
+// Remembered returns the channel that worked last time, if any, unless
+// we remembered it more than RememberedMaxAge ago.
+func (p Policy) Remembered() string {
+	if p.KVStore == nil {
+		return ""
+	}
+	data, err := p.KVStore.Get(stateKey)
+	if err != nil {
+		return ""
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return ""
+	}
+	if time.Since(s.Time) > RememberedMaxAge {
+		return ""
+	}
+	return s.Channel
+}
+
+func (p Policy) remember(channel string) {
+	if p.KVStore == nil {
+		return
+	}
+	data, err := json.Marshal(state{Channel: channel, Time: time.Now()})
+	if err != nil {
+		return
+	}
+	if err := p.KVStore.Set(stateKey, data); err != nil {
+		p.Logger.Warnf("circumvention: cannot save state: %s", err.Error())
+	}
+}
+
+// order returns the chain beginning with the remembered channel, if
+// the remembered channel belongs to the chain.
+func (p Policy) order() (out []string) {
+	chain := p.Chain
+	if len(chain) <= 0 {
+		chain = DefaultChain
+	}
+	remembered := p.Remembered()
+	for _, channel := range chain {
+		if channel == remembered {
+			out = append(out, channel)
+		}
+	}
+	for _, channel := range chain {
+		if channel != remembered {
+			out = append(out, channel)
+		}
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package circumvention_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/circumvention"
+	"github.com/ooni/probe-engine/internal/kvstore"
+)
+
+// newPolicy returns a policy where only the working channels work
+// and that records the channels that we have tried.
+func newPolicy(store *kvstore.MemoryKeyValueStore, tried *[]string,
+	working ...string) circumvention.Policy {
+	return circumvention.Policy{
+		KVStore: store,
+		Logger:  log.Log,
+		Try: func(ctx context.Context, channel string) error {
+			*tried = append(*tried, channel)
+			for _, w := range working {
+				if w == channel {
+					return nil
+				}
+			}
+			return errors.New("mocked error")
+		},
+	}
+}
+
+func TestFallbackAndRemember(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	var tried []string
+	policy := newPolicy(store, &tried, circumvention.Psiphon)
+	channel, attempts, err := policy.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channel != circumvention.Psiphon || len(attempts) != 3 {
+		t.Fatal("unexpected result")
+	}
+	if attempts[0].Err == nil || attempts[1].Err == nil || attempts[2].Err != nil {
+		t.Fatal("unexpected attempts")
+	}
+	if policy.Remembered() != circumvention.Psiphon {
+		t.Fatal("we did not remember the channel")
+	}
+	tried = nil
+	channel, _, err = policy.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channel != circumvention.Psiphon || len(tried) != 1 {
+		t.Fatal("we did not try the remembered channel first")
+	}
+}
+
+func TestRememberedChannelStopsWorking(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	var tried []string
+	if _, _, err := newPolicy(store, &tried, circumvention.Tor).Run(
+		context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tried = nil
+	policy := newPolicy(store, &tried, circumvention.Direct)
+	channel, _, err := policy.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channel != circumvention.Direct {
+		t.Fatal("not the channel we expected")
+	}
+	if len(tried) != 2 || tried[0] != circumvention.Tor || tried[1] != circumvention.Direct {
+		t.Fatal("not the order we expected", tried)
+	}
+	if policy.Remembered() != circumvention.Direct {
+		t.Fatal("we did not update the remembered channel")
+	}
+}
+
+func TestAllChannelsFailed(t *testing.T) {
+	var tried []string
+	policy := newPolicy(kvstore.NewMemoryKeyValueStore(), &tried)
+	policy.KVStore = nil
+	channel, attempts, err := policy.Run(context.Background())
+	if !errors.Is(err, circumvention.ErrAllChannelsFailed) {
+		t.Fatal("not the error we expected")
+	}
+	if channel != "" || len(attempts) != len(circumvention.DefaultChain) {
+		t.Fatal("unexpected result")
+	}
+	if policy.Remembered() != "" {
+		t.Fatal("expected nothing to be remembered")
+	}
+}
+
+func TestCustomChainAndCancelledContext(t *testing.T) {
+	var tried []string
+	policy := newPolicy(kvstore.NewMemoryKeyValueStore(), &tried, circumvention.Tor)
+	policy.Chain = []string{circumvention.Direct}
+	if _, _, err := policy.Run(context.Background()); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if len(tried) != 1 {
+		t.Fatal("we did not use the custom chain")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tried = nil
+	if _, _, err := policy.Run(ctx); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if len(tried) != 0 {
+		t.Fatal("we should not have tried any channel")
+	}
+}
+
+func TestCorruptState(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	store.Set("circumvention.state", []byte("{"))
+	var tried []string
+	policy := newPolicy(store, &tried, circumvention.Direct)
+	if policy.Remembered() != "" {
+		t.Fatal("expected nothing to be remembered")
+	}
+	if _, _, err := policy.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRememberedChannelExpires(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	when := time.Now().Add(-circumvention.RememberedMaxAge - time.Minute)
+	data := []byte(`{"channel":"tor","time":"` + when.Format(time.RFC3339) + `"}`)
+	store.Set("circumvention.state", data)
+	var tried []string
+	policy := newPolicy(store, &tried, circumvention.Direct, circumvention.Tor)
+	if policy.Remembered() != "" {
+		t.Fatal("expected the remembered channel to be expired")
+	}
+	channel, _, err := policy.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channel != circumvention.Direct || len(tried) != 1 {
+		t.Fatal("we did not start over from the beginning of the chain")
+	}
+}
@@ -3,9 +3,16 @@ package httpx
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 )
 
+// ErrNotModified indicates that the resource did not change since
+// the last time we fetched it, as indicated by its ETag.
+var ErrNotModified = errors.New("httpx: not modified")
+
 // FetchResource fetches the specified resource and returns it.
 func (c Client) FetchResource(ctx context.Context, URLPath string) ([]byte, error) {
 	request, err := c.NewRequest(ctx, "GET", URLPath, nil, nil)
@@ -29,3 +36,27 @@ func (c Client) FetchResourceAndVerify(ctx context.Context, URL, SHA256Sum strin
 	}
 	return data, nil
 }
+
+// FetchResourceWithETag is like FetchResource except that it also accepts
+// a query and the ETag of the copy of the resource we already have, if any,
+// which we send as If-None-Match. On success, returns the resource and its
+// new ETag, if any. If the resource did not change, returns ErrNotModified.
+func (c Client) FetchResourceWithETag(ctx context.Context, URLPath string,
+	query url.Values, etag string) ([]byte, string, error) {
+	request, err := c.NewRequest(ctx, "GET", URLPath, query, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	var header http.Header
+	data, err := c.doWithSpan(request, func(request *http.Request) (data []byte, err error) {
+		header, data, err = c.roundTrip(request)
+		return
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return data, header.Get("ETag"), nil
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/internal/httpx"
+	"github.com/ooni/probe-engine/model"
 )
 
 func TestFetchResourceIntegration(t *testing.T) {
@@ -152,3 +154,69 @@ func TestFetchResourceAndVerifyInvalidSHA256(t *testing.T) {
 		t.Fatal("expected an empty resource")
 	}
 }
+
+func TestFetchResourceWithETag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("antani") != "mascetti" {
+				w.WriteHeader(400)
+				return
+			}
+			if r.Header.Get("If-None-Match") == `"xyz"` {
+				w.WriteHeader(304)
+				return
+			}
+			w.Header().Set("ETag", `"xyz"`)
+			w.Write([]byte("deadbeef"))
+		}))
+	defer server.Close()
+	client := httpx.Client{
+		BaseURL:    server.URL,
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		UserAgent:  "ooniprobe-engine/0.1.0",
+	}
+	query := url.Values{}
+	query.Set("antani", "mascetti")
+	ctx := context.Background()
+	data, etag, err := client.FetchResourceWithETag(ctx, "/", query, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "deadbeef" || etag != `"xyz"` {
+		t.Fatal("not the result we expected")
+	}
+	data, etag, err = client.FetchResourceWithETag(ctx, "/", query, etag)
+	if !errors.Is(err, httpx.ErrNotModified) {
+		t.Fatal("not the error we expected")
+	}
+	if data != nil || etag != "" {
+		t.Fatal("expected empty result here")
+	}
+}
+
+func TestFetchResourceWithETagTracesTheRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(304)
+		}))
+	defer server.Close()
+	client := httpx.Client{
+		BaseURL:    server.URL,
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		UserAgent:  "ooniprobe-engine/0.1.0",
+	}
+	tracer := &requestTracer{}
+	ctx := model.WithTracer(context.Background(), tracer)
+	_, _, err := client.FetchResourceWithETag(ctx, "/", nil, `"xyz"`)
+	if !errors.Is(err, httpx.ErrNotModified) {
+		t.Fatal("not the error we expected")
+	}
+	if len(tracer.spans) != 1 || tracer.spans[0].name != "httpx.request" {
+		t.Fatal("not the spans we expected")
+	}
+	if span := tracer.spans[0]; span.attributes["method"] != "GET" || span.err != err {
+		t.Fatal("not the span we expected")
+	}
+}
@@ -37,6 +37,10 @@ type Client struct {
 	// tunnel, e.g., Psiphon.
 	ProxyURL *url.URL
 
+	// RetryPolicy is the optional retry policy. If nil, we do not
+	// retry requests that failed because of transient errors.
+	RetryPolicy *RetryPolicy
+
 	// UserAgent is the user agent to use.
 	UserAgent string
 }
@@ -91,17 +95,81 @@ func (c Client) NewRequest(ctx context.Context, method, resourcePath string,
 	return request.WithContext(ctx), nil
 }
 
-// Do performs the provided request and returns the response body or an error.
+// MaxErrorBodySize is the maximum number of bytes of the response
+// body that we save into a RequestFailedError.
+const MaxErrorBodySize = 1 << 12
+
+// RequestFailedError indicates that the server returned a status
+// code indicating failure, i.e., a status code >= 400.
+type RequestFailedError struct {
+	// Body contains the first MaxErrorBodySize bytes of the response
+	// body, which may contain details about the error.
+	Body []byte
+
+	// Status is the response status (e.g. "404 Not Found").
+	Status string
+
+	// StatusCode is the response status code (e.g. 404).
+	StatusCode int
+}
+
+// Error returns a description of the error that occurred.
+func (e *RequestFailedError) Error() string {
+	return fmt.Sprintf("httpx: request failed: %s", e.Status)
+}
+
+// Do performs the provided request and returns the response body or an
+// error. If c.RetryPolicy is not nil, we retry the request on transient
+// errors according to such policy. When the status code indicates failure
+// the returned error is a *RequestFailedError. We trace the request using
+// the tracer in the request context, if any (see model.WithTracer).
 func (c Client) Do(request *http.Request) ([]byte, error) {
+	return c.doWithSpan(request, c.do)
+}
+
+// doWithSpan performs request using fn like Do does, i.e., tracing the
+// request and retrying it according to c.RetryPolicy.
+func (c Client) doWithSpan(request *http.Request,
+	fn func(*http.Request) ([]byte, error)) (data []byte, err error) {
+	ctx, span := model.StartSpan(request.Context(), "httpx.request")
+	span.SetAttribute("method", request.Method)
+	span.SetAttribute("url", request.URL.String())
+	defer func() { span.End(err) }()
+	request = request.WithContext(ctx)
+	if c.RetryPolicy != nil {
+		return c.RetryPolicy.do(c, request, fn)
+	}
+	return fn(request)
+}
+
+func (c Client) do(request *http.Request) ([]byte, error) {
+	_, data, err := c.roundTrip(request)
+	return data, err
+}
+
+// roundTrip is like do but also returns the response headers.
+func (c Client) roundTrip(request *http.Request) (http.Header, []byte, error) {
 	response, err := c.HTTPClient.Do(request)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		return response.Header, nil, ErrNotModified
+	}
 	if response.StatusCode >= 400 {
-		return nil, fmt.Errorf("httpx: request failed: %s", response.Status)
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, MaxErrorBodySize))
+		return nil, nil, &RequestFailedError{
+			Body:       body,
+			Status:     response.Status,
+			StatusCode: response.StatusCode,
+		}
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
 	}
-	return ioutil.ReadAll(response.Body)
+	return response.Header, data, nil
 }
 
 // DoJSON performs the provided request and unmarshals the JSON response body
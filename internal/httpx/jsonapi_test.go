@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"github.com/apex/log"
 	"github.com/google/go-cmp/cmp"
 	"github.com/ooni/probe-engine/internal/httpx"
+	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/dialer"
 )
 
@@ -169,7 +171,7 @@ func TestClientDoJSONResponseNotSuccessful(t *testing.T) {
 	client.HTTPClient = &http.Client{Transport: httpx.FakeTransport{
 		Resp: &http.Response{
 			StatusCode: 401,
-			Body:       httpx.FakeBody{},
+			Body:       httpx.FakeBody{Err: io.EOF},
 		},
 	}}
 	err := client.DoJSON(&http.Request{URL: &url.URL{Scheme: "https", Host: "x.org"}}, nil)
@@ -298,3 +300,78 @@ func TestUnitUpdateJSONFailure(t *testing.T) {
 		t.Fatal("not the error we expected")
 	}
 }
+
+func TestClientDoRequestFailedErrorContainsBody(t *testing.T) {
+	client := newClient()
+	client.HTTPClient = &http.Client{Transport: httpx.FakeTransport{
+		Resp: &http.Response{
+			Status:     "429 Too Many Requests",
+			StatusCode: 429,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"error":"slow down"}`)),
+		},
+	}}
+	_, err := client.Do(&http.Request{URL: &url.URL{Scheme: "https", Host: "x.org"}})
+	var failed *httpx.RequestFailedError
+	if !errors.As(err, &failed) {
+		t.Fatal("not the error we expected")
+	}
+	if failed.StatusCode != 429 || string(failed.Body) != `{"error":"slow down"}` {
+		t.Fatal("unexpected error fields")
+	}
+}
+
+type requestSpan struct {
+	attributes map[string]string
+	err        error
+	name       string
+}
+
+func (s *requestSpan) SetAttribute(key, value string) {
+	s.attributes[key] = value
+}
+
+func (s *requestSpan) End(err error) {
+	s.err = err
+}
+
+type requestTracer struct {
+	spans []*requestSpan
+}
+
+func (t *requestTracer) StartSpan(ctx context.Context, name string) (context.Context, model.Span) {
+	span := &requestSpan{attributes: make(map[string]string), name: name}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestClientDoTracesTheRequest(t *testing.T) {
+	client := newClient()
+	client.HTTPClient = &http.Client{Transport: httpx.FakeTransport{
+		Resp: &http.Response{
+			StatusCode: 500,
+			Body:       httpx.FakeBody{Err: io.EOF},
+		},
+	}}
+	tracer := &requestTracer{}
+	ctx := model.WithTracer(context.Background(), tracer)
+	request, err := client.NewRequest(ctx, "GET", "/api/v1/test-helpers", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Do(request)
+	var failure *httpx.RequestFailedError
+	if !errors.As(err, &failure) {
+		t.Fatal("not the error we expected")
+	}
+	if len(tracer.spans) != 1 || tracer.spans[0].name != "httpx.request" {
+		t.Fatal("not the spans we expected")
+	}
+	span := tracer.spans[0]
+	if span.attributes["method"] != "GET" ||
+		span.attributes["url"] != "https://httpbin.org/api/v1/test-helpers" {
+		t.Fatal("unexpected attributes", span.attributes)
+	}
+	if span.err != err {
+		t.Fatal("the span does not contain the error")
+	}
+}
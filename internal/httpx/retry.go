@@ -0,0 +1,176 @@
+package httpx
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is the default maximum number of attempts.
+	DefaultMaxAttempts = 3
+
+	// DefaultInitialDelay is the default delay before the first retry.
+	DefaultInitialDelay = 500 * time.Millisecond
+
+	// DefaultMaxDelay is the default maximum delay between retries.
+	DefaultMaxDelay = 5 * time.Second
+)
+
+// RetryPolicy is a retry policy for HTTP requests. We retry a request
+// when the server returns a 5xx status code or when a timeout occurs,
+// with jittered exponential backoff, until we run out of attempts or
+// the request's context is done. We only retry requests using idempotent
+// methods (e.g., GET and PUT), since retrying other requests (e.g., POST)
+// could cause the server to act twice on them (e.g., to accept the same
+// measurement twice), unless the error occurred before we started to
+// send the request to the server. A RetryPolicy is safe for concurrent
+// use and SHOULD be shared by copies of the same Client, so that it can
+// keep track of the retries for diagnostics.
+type RetryPolicy struct {
+	// InitialDelay is the delay before the first retry. The delay
+	// doubles at each subsequent retry. The actual delay is picked
+	// at random between half of the delay and the delay.
+	InitialDelay time.Duration
+
+	// MaxAttempts is the maximum number of attempts. A value less
+	// than or equal to one means that we never retry.
+	MaxAttempts int
+
+	// MaxDelay is the maximum delay between retries.
+	MaxDelay time.Duration
+
+	mu      sync.Mutex
+	retries map[string]int64
+	rnd     *rand.Rand
+}
+
+// NewRetryPolicy creates a new RetryPolicy with default settings.
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		InitialDelay: DefaultInitialDelay,
+		MaxAttempts:  DefaultMaxAttempts,
+		MaxDelay:     DefaultMaxDelay,
+	}
+}
+
+// Retries returns a copy of the number of retries we have performed so
+// far, indexed by method and path of the request (e.g. "POST /report").
+func (rp *RetryPolicy) Retries() map[string]int64 {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	out := make(map[string]int64)
+	for key, value := range rp.retries {
+		out[key] = value
+	}
+	return out
+}
+
+func (rp *RetryPolicy) countRetry(request *http.Request) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.retries == nil {
+		rp.retries = make(map[string]int64)
+	}
+	rp.retries[request.Method+" "+request.URL.Path]++
+}
+
+func (rp *RetryPolicy) delay(attempt int) time.Duration {
+	delay := rp.InitialDelay
+	for i := 1; i < attempt && delay < rp.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > rp.MaxDelay {
+		delay = rp.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.rnd == nil {
+		rp.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return delay/2 + time.Duration(rp.rnd.Int63n(int64(delay/2)+1))
+}
+
+// shouldRetry returns whether err is a transient error.
+func shouldRetry(err error) bool {
+	var failed *RequestFailedError
+	if errors.As(err, &failed) {
+		return failed.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isIdempotent returns whether the method is idempotent (see RFC7231).
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// send performs request using fn and returns whether we have started
+// writing the request to the server, in which case the server may have
+// acted on the request, even though we got an error.
+func send(request *http.Request, fn func(*http.Request) ([]byte, error)) (
+	data []byte, sent bool, err error) {
+	var wrote int32
+	ctx := httptrace.WithClientTrace(request.Context(), &httptrace.ClientTrace{
+		WroteHeaderField: func(key string, value []string) {
+			atomic.StoreInt32(&wrote, 1)
+		},
+	})
+	data, err = fn(request.WithContext(ctx))
+	return data, atomic.LoadInt32(&wrote) != 0, err
+}
+
+// do performs request using fn and retries according to the policy.
+func (rp *RetryPolicy) do(
+	c Client, request *http.Request, fn func(*http.Request) ([]byte, error),
+) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		data, sent, err := send(request, fn)
+		if err == nil || attempt >= rp.MaxAttempts || !shouldRetry(err) {
+			return data, err
+		}
+		if sent && !isIdempotent(request.Method) {
+			return data, err // the server may have acted on the request
+		}
+		ctx := request.Context()
+		if ctx.Err() != nil {
+			return data, err
+		}
+		if request.Body != nil && request.Body != http.NoBody {
+			if request.GetBody == nil {
+				return data, err // we cannot send the body again
+			}
+			body, bodyErr := request.GetBody()
+			if bodyErr != nil {
+				return data, err
+			}
+			request = request.Clone(ctx)
+			request.Body = body
+		}
+		delay := rp.delay(attempt)
+		c.Logger.Debugf("httpx: retrying in %s after error: %s", delay, err.Error())
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return data, err
+		}
+		rp.countRetry(request)
+	}
+}
@@ -0,0 +1,213 @@
+package httpx_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/httpx"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func newRetryClient(URL string) httpx.Client {
+	return httpx.Client{
+		BaseURL:    URL,
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		RetryPolicy: &httpx.RetryPolicy{
+			InitialDelay: time.Millisecond,
+			MaxAttempts:  3,
+			MaxDelay:     4 * time.Millisecond,
+		},
+		UserAgent: "miniooni/0.1.0-dev",
+	}
+}
+
+func newFlakyServer(failures int32, status int) (*httptest.Server, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			if atomic.AddInt32(&count, 1) <= failures {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write(data)
+		}))
+	return server, &count
+}
+
+func TestRetryPolicyRetriesOn5xx(t *testing.T) {
+	server, count := newFlakyServer(2, 502)
+	defer server.Close()
+	client := newRetryClient(server.URL)
+	var output map[string]int
+	err := client.PutJSON(context.Background(), "/antani", map[string]int{"a": 1}, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output["a"] != 1 {
+		t.Fatal("the body was not sent again")
+	}
+	if *count != 3 {
+		t.Fatal("unexpected number of requests")
+	}
+	if client.RetryPolicy.Retries()["PUT /antani"] != 2 {
+		t.Fatal("unexpected number of retries")
+	}
+}
+
+func TestRetryPolicyDoesNotRetryPOSTOn5xx(t *testing.T) {
+	server, count := newFlakyServer(2, 502)
+	defer server.Close()
+	client := newRetryClient(server.URL)
+	var output map[string]int
+	err := client.PostJSON(context.Background(), "/antani", map[string]int{"a": 1}, &output)
+	var failed *httpx.RequestFailedError
+	if !errors.As(err, &failed) || failed.StatusCode != 502 {
+		t.Fatal("not the error we expected", err)
+	}
+	if *count != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+}
+
+func TestRetryPolicyRetriesPOSTNotSent(t *testing.T) {
+	var count int32
+	client := newRetryClient("http://www.example.com")
+	client.HTTPClient = &http.Client{Transport: httpx.FakeTransport{
+		Func: func(req *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&count, 1) <= 1 {
+				return nil, timeoutError{} // e.g., we could not connect
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader("{}")),
+			}, nil
+		},
+	}}
+	var output map[string]int
+	err := client.PostJSON(context.Background(), "/antani", map[string]int{"a": 1}, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("unexpected number of requests")
+	}
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	server, count := newFlakyServer(10, 503)
+	defer server.Close()
+	client := newRetryClient(server.URL)
+	_, err := client.FetchResource(context.Background(), "/")
+	var failed *httpx.RequestFailedError
+	if !errors.As(err, &failed) || failed.StatusCode != 503 {
+		t.Fatal("not the error we expected")
+	}
+	if *count != 3 {
+		t.Fatal("unexpected number of requests")
+	}
+	if client.RetryPolicy.Retries()["GET /"] != 2 {
+		t.Fatal("unexpected number of retries")
+	}
+}
+
+func TestRetryPolicyDoesNotRetryOn4xx(t *testing.T) {
+	server, count := newFlakyServer(10, 404)
+	defer server.Close()
+	client := newRetryClient(server.URL)
+	_, err := client.FetchResource(context.Background(), "/")
+	if err == nil || !strings.HasSuffix(err.Error(), "404 Not Found") {
+		t.Fatal("not the error we expected")
+	}
+	if *count != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+	if len(client.RetryPolicy.Retries()) != 0 {
+		t.Fatal("unexpected number of retries")
+	}
+}
+
+func TestRetryPolicyRetriesOnTimeout(t *testing.T) {
+	var count int32
+	client := newRetryClient("http://www.example.com")
+	client.HTTPClient = &http.Client{Transport: httpx.FakeTransport{
+		Func: func(req *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&count, 1) <= 1 {
+				return nil, timeoutError{}
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader("{}")),
+			}, nil
+		},
+	}}
+	if _, err := client.FetchResource(context.Background(), "/"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("unexpected number of requests")
+	}
+}
+
+func TestRetryPolicyDoesNotRetryOtherErrors(t *testing.T) {
+	var count int32
+	expected := errors.New("mocked error")
+	client := newRetryClient("http://www.example.com")
+	client.HTTPClient = &http.Client{Transport: httpx.FakeTransport{
+		Func: func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&count, 1)
+			return nil, expected
+		},
+	}}
+	_, err := client.FetchResource(context.Background(), "/")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if count != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+}
+
+func TestRetryPolicyHonoursContext(t *testing.T) {
+	server, count := newFlakyServer(10, 500)
+	defer server.Close()
+	client := newRetryClient(server.URL)
+	client.RetryPolicy.InitialDelay = time.Hour
+	client.RetryPolicy.MaxDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.FetchResource(ctx, "/")
+	if err == nil || !strings.HasSuffix(err.Error(), "500 Internal Server Error") {
+		t.Fatal("not the error we expected")
+	}
+	if *count != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	rp := httpx.NewRetryPolicy()
+	if rp.InitialDelay != httpx.DefaultInitialDelay {
+		t.Fatal("unexpected InitialDelay")
+	}
+	if rp.MaxAttempts != httpx.DefaultMaxAttempts {
+		t.Fatal("unexpected MaxAttempts")
+	}
+	if rp.MaxDelay != httpx.DefaultMaxDelay {
+		t.Fatal("unexpected MaxDelay")
+	}
+}
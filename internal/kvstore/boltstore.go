@@ -0,0 +1,150 @@
+package kvstore
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/Psiphon-Labs/bolt"
+)
+
+// boltBucket is the bucket containing all the keys.
+var boltBucket = []byte("kvstore")
+
+// boltOpenTimeout is the time we wait for the lock on the database,
+// which is held by any other process using the same file.
+const boltOpenTimeout = 5 * time.Second
+
+// BoltKeyValueStore is a persistent key-value store backed by a bbolt
+// database, which supports transactions and prefix scans.
+type BoltKeyValueStore struct {
+	db *bolt.DB
+}
+
+// NewBoltKeyValueStore opens or creates the database at path. You
+// should call Close when done using the key-value store.
+func NewBoltKeyValueStore(path string) (*BoltKeyValueStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltKeyValueStore{db: db}, nil
+}
+
+// Close closes the database.
+func (kvs *BoltKeyValueStore) Close() error {
+	return kvs.db.Close()
+}
+
+// Get returns a key from the key value store
+func (kvs *BoltKeyValueStore) Get(key string) (value []byte, err error) {
+	err = kvs.View(func(tx *BoltTx) error {
+		value, err = tx.Get(key)
+		return err
+	})
+	return
+}
+
+// Set sets a key into the key value store
+func (kvs *BoltKeyValueStore) Set(key string, value []byte) error {
+	return kvs.Update(func(tx *BoltTx) error {
+		return tx.Set(key, value)
+	})
+}
+
+// List returns the sorted keys starting with prefix
+func (kvs *BoltKeyValueStore) List(prefix string) (keys []string, err error) {
+	err = kvs.Scan(prefix, func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return
+}
+
+// Delete removes a key from the key value store. It is not an error
+// to remove a key that does not exist.
+func (kvs *BoltKeyValueStore) Delete(key string) error {
+	return kvs.Update(func(tx *BoltTx) error {
+		return tx.Delete(key)
+	})
+}
+
+// Scan calls fn, in lexicographic order, for each key starting with
+// prefix. We stop at the first error returned by fn.
+func (kvs *BoltKeyValueStore) Scan(prefix string, fn func(key string, value []byte) error) error {
+	return kvs.View(func(tx *BoltTx) error {
+		return tx.Scan(prefix, fn)
+	})
+}
+
+// UpdateKey atomically updates a key (see KeyUpdater)
+func (kvs *BoltKeyValueStore) UpdateKey(
+	key string, fn func(value []byte, err error) ([]byte, error)) error {
+	return kvs.Update(func(tx *BoltTx) error {
+		value, err := fn(tx.Get(key))
+		if err != nil {
+			return err
+		}
+		return tx.Set(key, value)
+	})
+}
+
+// Update runs fn inside a read-write transaction, which is committed
+// if fn returns nil and rolled back otherwise.
+func (kvs *BoltKeyValueStore) Update(fn func(tx *BoltTx) error) error {
+	return kvs.db.Update(func(tx *bolt.Tx) error {
+		return fn(&BoltTx{bucket: tx.Bucket(boltBucket)})
+	})
+}
+
+// View runs fn inside a read-only transaction.
+func (kvs *BoltKeyValueStore) View(fn func(tx *BoltTx) error) error {
+	return kvs.db.View(func(tx *bolt.Tx) error {
+		return fn(&BoltTx{bucket: tx.Bucket(boltBucket)})
+	})
+}
+
+// BoltTx is a transaction on a BoltKeyValueStore. A transaction is
+// only valid inside the function passed to Update or View.
+type BoltTx struct {
+	bucket *bolt.Bucket
+}
+
+// Get returns a key from the key value store
+func (tx *BoltTx) Get(key string) ([]byte, error) {
+	value := tx.bucket.Get([]byte(key))
+	if value == nil {
+		return nil, ErrNoSuchKey
+	}
+	// bbolt values are only valid for the lifetime of the transaction
+	return append([]byte{}, value...), nil
+}
+
+// Set sets a key into the key value store
+func (tx *BoltTx) Set(key string, value []byte) error {
+	return tx.bucket.Put([]byte(key), value)
+}
+
+// Delete removes a key from the key value store
+func (tx *BoltTx) Delete(key string) error {
+	return tx.bucket.Delete([]byte(key))
+}
+
+// Scan calls fn, in lexicographic order, for each key starting with
+// prefix. We stop at the first error returned by fn.
+func (tx *BoltTx) Scan(prefix string, fn func(key string, value []byte) error) error {
+	cursor := tx.bucket.Cursor()
+	for k, v := cursor.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = cursor.Next() {
+		if err := fn(string(k), append([]byte{}, v...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package kvstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newBoltKeyValueStore(t *testing.T) (*BoltKeyValueStore, string) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "kvstore.db")
+	kvs, err := NewBoltKeyValueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kvs, path
+}
+
+func TestUnitBoltGetSetDelete(t *testing.T) {
+	kvs, _ := newBoltKeyValueStore(t)
+	defer kvs.Close()
+	if _, err := kvs.Get("antani"); !errors.Is(err, ErrNoSuchKey) {
+		t.Fatal("not the error we expected")
+	}
+	if err := kvs.Set("antani", []byte("mascetti")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := kvs.Get("antani")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "mascetti" {
+		t.Fatal("not the result we expected")
+	}
+	if err := kvs.Delete("antani"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvs.Get("antani"); !errors.Is(err, ErrNoSuchKey) {
+		t.Fatal("not the error we expected")
+	}
+	if err := kvs.Delete("antani"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitBoltPersistence(t *testing.T) {
+	kvs, path := newBoltKeyValueStore(t)
+	if err := kvs.Set("antani", []byte("mascetti")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := NewBoltKeyValueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvs.Close()
+	value, err := kvs.Get("antani")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "mascetti" {
+		t.Fatal("not the result we expected")
+	}
+}
+
+func TestUnitBoltScan(t *testing.T) {
+	kvs, _ := newBoltKeyValueStore(t)
+	defer kvs.Close()
+	for _, key := range []string{"queue.2", "cache.a", "queue.1", "queuex"} {
+		if err := kvs.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []string
+	err := kvs.Scan("queue.", func(key string, value []byte) error {
+		if string(value) != key {
+			t.Fatal("unexpected value")
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "queue.1" || keys[1] != "queue.2" {
+		t.Fatal("unexpected keys", keys)
+	}
+	expected := errors.New("mocked error")
+	var count int
+	err = kvs.Scan("", func(key string, value []byte) error {
+		count++
+		return expected
+	})
+	if !errors.Is(err, expected) || count != 1 {
+		t.Fatal("we did not stop at the first error")
+	}
+}
+
+func TestUnitBoltUpdateRollback(t *testing.T) {
+	kvs, _ := newBoltKeyValueStore(t)
+	defer kvs.Close()
+	expected := errors.New("mocked error")
+	err := kvs.Update(func(tx *BoltTx) error {
+		if err := tx.Set("antani", []byte("mascetti")); err != nil {
+			return err
+		}
+		if _, err := tx.Get("antani"); err != nil {
+			return err
+		}
+		return expected
+	})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if _, err := kvs.Get("antani"); !errors.Is(err, ErrNoSuchKey) {
+		t.Fatal("we did not roll back the transaction")
+	}
+}
+
+func TestUnitBoltOpenFailure(t *testing.T) {
+	kvs, err := NewBoltKeyValueStore(filepath.Join("/nonexistent", "kvstore.db"))
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if kvs != nil {
+		t.Fatal("expected nil here")
+	}
+}
+
+func TestUnitBoltList(t *testing.T) {
+	kvs, _ := newBoltKeyValueStore(t)
+	defer kvs.Close()
+	for _, key := range []string{"queue.2", "cache.a", "queue.1"} {
+		if err := kvs.Set(key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := kvs.List("queue.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "queue.1" || keys[1] != "queue.2" {
+		t.Fatal("unexpected keys", keys)
+	}
+}
@@ -0,0 +1,75 @@
+package kvstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ErrCannotDecrypt indicates that we could not decrypt a value, e.g.,
+// because it was encrypted using another key or it was not encrypted.
+var ErrCannotDecrypt = errors.New("kvstore: cannot decrypt value")
+
+// encryptedKeyValueStore encrypts the values of a key-value store.
+type encryptedKeyValueStore struct {
+	aead  cipher.AEAD
+	rand  io.Reader
+	store model.KeyValueStore
+}
+
+// NewEncryptedKeyValueStore returns a view of store where we encrypt
+// the values, but not the keys, using AES-GCM with key, which must be
+// 16, 24, or 32 bytes long. The host application should generate the key
+// and keep it in a safe place, such as the platform keystore. We bind
+// each ciphertext to its key, so values cannot be swapped.
+func NewEncryptedKeyValueStore(store model.KeyValueStore, key []byte) (model.KeyValueStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedKeyValueStore{aead: aead, rand: rand.Reader, store: store}, nil
+}
+
+// Delete removes a key from the key value store
+func (kvs *encryptedKeyValueStore) Delete(key string) error {
+	return kvs.store.Delete(key)
+}
+
+// Get returns a key from the key value store
+func (kvs *encryptedKeyValueStore) Get(key string) ([]byte, error) {
+	data, err := kvs.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	size := kvs.aead.NonceSize()
+	if len(data) < size {
+		return nil, ErrCannotDecrypt
+	}
+	value, err := kvs.aead.Open(nil, data[:size], data[size:], []byte(key))
+	if err != nil {
+		return nil, ErrCannotDecrypt
+	}
+	return value, nil
+}
+
+// List returns the sorted keys starting with prefix
+func (kvs *encryptedKeyValueStore) List(prefix string) ([]string, error) {
+	return kvs.store.List(prefix)
+}
+
+// Set sets a key into the key value store
+func (kvs *encryptedKeyValueStore) Set(key string, value []byte) error {
+	nonce := make([]byte, kvs.aead.NonceSize())
+	if _, err := io.ReadFull(kvs.rand, nonce); err != nil {
+		return err
+	}
+	return kvs.store.Set(key, kvs.aead.Seal(nonce, nonce, value, []byte(key)))
+}
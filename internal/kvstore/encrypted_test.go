@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestUnitEncryptedRoundTrip(t *testing.T) {
+	store := NewMemoryKeyValueStore()
+	kvs, err := NewEncryptedKeyValueStore(store, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Set("orchestra.state", []byte("password")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.Get("orchestra.state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("password")) {
+		t.Fatal("we did not encrypt the value")
+	}
+	value, err := kvs.Get("orchestra.state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "password" {
+		t.Fatal("not the result we expected")
+	}
+	keys, err := kvs.List("orchestra.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "orchestra.state" {
+		t.Fatal("unexpected keys", keys)
+	}
+}
+
+func TestUnitEncryptedCannotDecrypt(t *testing.T) {
+	store := NewMemoryKeyValueStore()
+	kvs, err := NewEncryptedKeyValueStore(store, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Set("antani", []byte("mascetti")); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := store.Get("antani")
+	store.Set("other", data)
+	if _, err := kvs.Get("other"); !errors.Is(err, ErrCannotDecrypt) {
+		t.Fatal("we could read a value saved under another key")
+	}
+	store.Set("plaintext", []byte("mascetti"))
+	if _, err := kvs.Get("plaintext"); !errors.Is(err, ErrCannotDecrypt) {
+		t.Fatal("not the error we expected")
+	}
+	store.Set("short", []byte("x"))
+	if _, err := kvs.Get("short"); !errors.Is(err, ErrCannotDecrypt) {
+		t.Fatal("not the error we expected")
+	}
+	other, err := NewEncryptedKeyValueStore(store, testEncryptionKey[:16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get("antani"); !errors.Is(err, ErrCannotDecrypt) {
+		t.Fatal("we could read a value using another key")
+	}
+	if _, err := kvs.Get("nonexistent"); !errors.Is(err, ErrNoSuchKey) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitEncryptedInvalidKey(t *testing.T) {
+	kvs, err := NewEncryptedKeyValueStore(NewMemoryKeyValueStore(), []byte("short"))
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if kvs != nil {
+		t.Fatal("expected nil here")
+	}
+}
+
+func TestUnitEncryptedRandomFailure(t *testing.T) {
+	kvs, err := NewEncryptedKeyValueStore(NewMemoryKeyValueStore(), testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs.(*encryptedKeyValueStore).rand = bytes.NewReader(nil)
+	if err := kvs.Set("antani", []byte("mascetti")); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitEncryptedDelete(t *testing.T) {
+	store := NewMemoryKeyValueStore()
+	kvs, err := NewEncryptedKeyValueStore(store, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs.Set("antani", []byte("mascetti"))
+	if err := kvs.Delete("antani"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("antani"); !errors.Is(err, ErrNoSuchKey) {
+		t.Fatal("we did not delete the key")
+	}
+}
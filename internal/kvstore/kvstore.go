@@ -3,9 +3,38 @@ package kvstore
 
 import (
 	"errors"
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/ooni/probe-engine/model"
 )
 
+// ErrNoSuchKey indicates that a key is not in the key-value store
+var ErrNoSuchKey = errors.New("no such key")
+
+// KeyUpdater is a key-value store that can atomically update a key,
+// which matters when several processes share the same store.
+type KeyUpdater interface {
+	UpdateKey(key string, fn func(value []byte, err error) ([]byte, error)) error
+}
+
+// UpdateKey replaces the value of key in store with the value returned
+// by fn, which receives the current value, or the error that occurred
+// reading it. If fn fails, we do not modify key and return the error. The
+// update is atomic if store is a KeyUpdater; otherwise we use Get and Set.
+func UpdateKey(store model.KeyValueStore, key string,
+	fn func(value []byte, err error) ([]byte, error)) error {
+	if updater, ok := store.(KeyUpdater); ok {
+		return updater.UpdateKey(key, fn)
+	}
+	value, err := fn(store.Get(key))
+	if err != nil {
+		return err
+	}
+	return store.Set(key, value)
+}
+
 // MemoryKeyValueStore is an in-memory key-value store
 type MemoryKeyValueStore struct {
 	m  map[string][]byte
@@ -19,6 +48,14 @@ func NewMemoryKeyValueStore() *MemoryKeyValueStore {
 	}
 }
 
+// Delete removes a key from the key value store
+func (kvs *MemoryKeyValueStore) Delete(key string) error {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	delete(kvs.m, key)
+	return nil
+}
+
 // Get returns a key from the key value store
 func (kvs *MemoryKeyValueStore) Get(key string) ([]byte, error) {
 	var (
@@ -30,11 +67,25 @@ func (kvs *MemoryKeyValueStore) Get(key string) ([]byte, error) {
 	defer kvs.mu.Unlock()
 	value, ok = kvs.m[key]
 	if !ok {
-		err = errors.New("no such key")
+		err = ErrNoSuchKey
 	}
 	return value, err
 }
 
+// List returns the sorted keys starting with prefix
+func (kvs *MemoryKeyValueStore) List(prefix string) ([]string, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	var keys []string
+	for key := range kvs.m {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
 // Set sets a key into the key value store
 func (kvs *MemoryKeyValueStore) Set(key string, value []byte) error {
 	kvs.mu.Lock()
@@ -42,3 +93,20 @@ func (kvs *MemoryKeyValueStore) Set(key string, value []byte) error {
 	kvs.m[key] = value
 	return nil
 }
+
+// UpdateKey atomically updates a key (see KeyUpdater)
+func (kvs *MemoryKeyValueStore) UpdateKey(
+	key string, fn func(value []byte, err error) ([]byte, error)) error {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	var err error
+	value, found := kvs.m[key]
+	if !found {
+		err = ErrNoSuchKey
+	}
+	if value, err = fn(value, err); err != nil {
+		return err
+	}
+	kvs.m[key] = value
+	return nil
+}
@@ -1,6 +1,9 @@
 package kvstore
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestUnitNoSuchKey(t *testing.T) {
 	kvs := NewMemoryKeyValueStore()
@@ -26,3 +29,121 @@ func TestUnitExistingKey(t *testing.T) {
 		t.Fatal("not the result we expected")
 	}
 }
+
+func TestUnitList(t *testing.T) {
+	kvs := NewMemoryKeyValueStore()
+	for _, key := range []string{"queue.2", "cache.a", "queue.1"} {
+		if err := kvs.Set(key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := kvs.List("queue.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "queue.1" || keys[1] != "queue.2" {
+		t.Fatal("unexpected keys", keys)
+	}
+}
+
+func TestUnitDelete(t *testing.T) {
+	kvs := NewMemoryKeyValueStore()
+	if err := kvs.Set("antani", []byte("mascetti")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Delete("antani"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvs.Get("antani"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := kvs.Delete("antani"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitUpdateKey(t *testing.T) {
+	kvs := NewMemoryKeyValueStore()
+	increment := func(value []byte, err error) ([]byte, error) {
+		if err != nil {
+			return []byte("1"), nil
+		}
+		return append(value, '1'), nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := UpdateKey(kvs, "counter", increment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value, _ := kvs.Get("counter")
+	if string(value) != "11" {
+		t.Fatal("not the result we expected", string(value))
+	}
+	expected := errors.New("mocked error")
+	err := UpdateKey(kvs, "counter", func([]byte, error) ([]byte, error) {
+		return nil, expected
+	})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if value, _ := kvs.Get("counter"); string(value) != "11" {
+		t.Fatal("we modified the key after a failure")
+	}
+}
+
+func TestUnitUpdateKeyWithoutKeyUpdater(t *testing.T) {
+	kvs, err := NewEncryptedKeyValueStore(NewMemoryKeyValueStore(), testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kvs.(KeyUpdater); ok {
+		t.Fatal("expected a store that is not a KeyUpdater")
+	}
+	err = UpdateKey(kvs, "antani", func(value []byte, err error) ([]byte, error) {
+		if !errors.Is(err, ErrNoSuchKey) {
+			t.Fatal("not the error we expected")
+		}
+		return []byte("mascetti"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := kvs.Get("antani"); string(value) != "mascetti" {
+		t.Fatal("not the result we expected")
+	}
+	expected := errors.New("mocked error")
+	err = UpdateKey(kvs, "antani", func([]byte, error) ([]byte, error) {
+		return nil, expected
+	})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitUpdateKeyNamespaceAndBolt(t *testing.T) {
+	bolt, _ := newBoltKeyValueStore(t)
+	defer bolt.Close()
+	kvs := WithNamespace(bolt, "probeservices")
+	if _, ok := kvs.(KeyUpdater); !ok {
+		t.Fatal("expected a KeyUpdater")
+	}
+	err := UpdateKey(kvs, "queue", func(value []byte, err error) ([]byte, error) {
+		if !errors.Is(err, ErrNoSuchKey) {
+			t.Fatal("not the error we expected")
+		}
+		return []byte("[]"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := bolt.Get("probeservices.queue"); string(value) != "[]" {
+		t.Fatal("not the result we expected")
+	}
+	expected := errors.New("mocked error")
+	err = bolt.UpdateKey("probeservices.queue", func([]byte, error) ([]byte, error) {
+		return nil, expected
+	})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+}
@@ -0,0 +1,63 @@
+package kvstore
+
+import (
+	"strings"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// NamespaceSeparator separates a namespace from the keys inside it. We
+// use a dot, so that "orchestra.state" is the "state" key inside the
+// "orchestra" namespace, like it was before we introduced namespaces.
+const NamespaceSeparator = "."
+
+// namespacedKeyValueStore is a view of a key-value store where all the
+// keys are inside a namespace.
+type namespacedKeyValueStore struct {
+	prefix string
+	store  model.KeyValueStore
+}
+
+// WithNamespace returns a view of store where all the keys belong to
+// namespace. Subsystems sharing a store should each use a distinct
+// namespace not containing NamespaceSeparator, so that their keys do not
+// collide. You can nest namespaces by wrapping the returned store.
+func WithNamespace(store model.KeyValueStore, namespace string) model.KeyValueStore {
+	return &namespacedKeyValueStore{
+		prefix: namespace + NamespaceSeparator,
+		store:  store,
+	}
+}
+
+// Delete removes a key from the key value store
+func (kvs *namespacedKeyValueStore) Delete(key string) error {
+	return kvs.store.Delete(kvs.prefix + key)
+}
+
+// Get returns a key from the key value store
+func (kvs *namespacedKeyValueStore) Get(key string) ([]byte, error) {
+	return kvs.store.Get(kvs.prefix + key)
+}
+
+// List returns the sorted keys starting with prefix
+func (kvs *namespacedKeyValueStore) List(prefix string) ([]string, error) {
+	keys, err := kvs.store.List(kvs.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+	for idx, key := range keys {
+		keys[idx] = strings.TrimPrefix(key, kvs.prefix)
+	}
+	return keys, nil
+}
+
+// Set sets a key into the key value store
+func (kvs *namespacedKeyValueStore) Set(key string, value []byte) error {
+	return kvs.store.Set(kvs.prefix+key, value)
+}
+
+// UpdateKey updates a key, atomically if the underlying store is a KeyUpdater
+func (kvs *namespacedKeyValueStore) UpdateKey(
+	key string, fn func(value []byte, err error) ([]byte, error)) error {
+	return UpdateKey(kvs.store, kvs.prefix+key, fn)
+}
@@ -0,0 +1,69 @@
+package kvstore
+
+import "testing"
+
+func TestUnitWithNamespace(t *testing.T) {
+	store := NewMemoryKeyValueStore()
+	orchestra := WithNamespace(store, "orchestra")
+	resolver := WithNamespace(store, "resolver")
+	if err := orchestra.Set("state", []byte("orchestra")); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolver.Set("state", []byte("resolver")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := store.Get("orchestra.state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "orchestra" {
+		t.Fatal("not the result we expected")
+	}
+	value, err = resolver.Get("state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "resolver" {
+		t.Fatal("namespaces are not isolated")
+	}
+	if _, err := orchestra.Get("resolver.state"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitWithNamespaceList(t *testing.T) {
+	store := NewMemoryKeyValueStore()
+	queue := WithNamespace(WithNamespace(store, "probeservices"), "queue")
+	for _, key := range []string{"2", "1"} {
+		if err := queue.Set(key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set("queue.3", nil); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := queue.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "1" || keys[1] != "2" {
+		t.Fatal("unexpected keys", keys)
+	}
+	if _, err := store.Get("probeservices.queue.1"); err != nil {
+		t.Fatal("nested namespaces do not compose")
+	}
+}
+
+func TestUnitWithNamespaceDelete(t *testing.T) {
+	store := NewMemoryKeyValueStore()
+	store.Set("state", nil)
+	orchestra := WithNamespace(store, "orchestra")
+	orchestra.Set("state", nil)
+	if err := orchestra.Delete("state"); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := store.List("")
+	if len(keys) != 1 || keys[0] != "state" {
+		t.Fatal("unexpected keys", keys)
+	}
+}
@@ -0,0 +1,89 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ErrExpired indicates that a key exists but its value has expired.
+var ErrExpired = errors.New("kvstore: value has expired")
+
+// ttlEntry is how we save a value along with its expiry time.
+type ttlEntry struct {
+	ExpireAt time.Time `json:"expire_at"`
+	Value    []byte    `json:"value"`
+}
+
+// TTLKeyValueStore saves values with an expiry time into Store. It is
+// meant for caches (e.g., of check-in responses or of the probe location)
+// that should not be used after some time. We recommend keeping the
+// entries with a TTL in their own namespace (see WithNamespace), since
+// Sweep removes every expired entry it finds.
+type TTLKeyValueStore struct {
+	Store model.KeyValueStore
+	now   func() time.Time
+}
+
+// NewTTLKeyValueStore creates a new TTLKeyValueStore using store.
+func NewTTLKeyValueStore(store model.KeyValueStore) *TTLKeyValueStore {
+	return &TTLKeyValueStore{Store: store, now: time.Now}
+}
+
+// SetWithTTL saves value, which expires after ttl, as key.
+func (kvs *TTLKeyValueStore) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	data, err := json.Marshal(ttlEntry{ExpireAt: kvs.now().Add(ttl), Value: value})
+	if err != nil {
+		return err
+	}
+	return kvs.Store.Set(key, data)
+}
+
+// GetFresh returns the value of key if it has not expired yet, and
+// otherwise an error, which is ErrExpired if the value has expired.
+func (kvs *TTLKeyValueStore) GetFresh(key string) ([]byte, error) {
+	entry, err := kvs.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !kvs.now().Before(entry.ExpireAt) {
+		return nil, ErrExpired
+	}
+	return entry.Value, nil
+}
+
+// Sweep removes the expired entries among the keys starting with
+// prefix and returns the number of entries that it removed. We skip the
+// entries that have not been saved using SetWithTTL.
+func (kvs *TTLKeyValueStore) Sweep(prefix string) (int, error) {
+	keys, err := kvs.Store.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, key := range keys {
+		entry, err := kvs.get(key)
+		if err != nil || entry.ExpireAt.IsZero() || kvs.now().Before(entry.ExpireAt) {
+			continue
+		}
+		if err := kvs.Store.Delete(key); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (kvs *TTLKeyValueStore) get(key string) (*ttlEntry, error) {
+	data, err := kvs.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var entry ttlEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
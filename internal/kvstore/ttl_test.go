@@ -0,0 +1,68 @@
+package kvstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTTLKeyValueStoreForTesting() (*TTLKeyValueStore, *time.Time) {
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	kvs := NewTTLKeyValueStore(NewMemoryKeyValueStore())
+	kvs.now = func() time.Time { return now }
+	return kvs, &now
+}
+
+func TestUnitTTLGetFresh(t *testing.T) {
+	kvs, now := newTTLKeyValueStoreForTesting()
+	if err := kvs.SetWithTTL("checkin", []byte("response"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	value, err := kvs.GetFresh("checkin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "response" {
+		t.Fatal("not the result we expected")
+	}
+	*now = now.Add(time.Hour)
+	if _, err := kvs.GetFresh("checkin"); !errors.Is(err, ErrExpired) {
+		t.Fatal("not the error we expected")
+	}
+	if _, err := kvs.GetFresh("nonexistent"); !errors.Is(err, ErrNoSuchKey) {
+		t.Fatal("not the error we expected")
+	}
+	kvs.Store.Set("broken", []byte("{"))
+	if _, err := kvs.GetFresh("broken"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitTTLSweep(t *testing.T) {
+	kvs, now := newTTLKeyValueStoreForTesting()
+	if err := kvs.SetWithTTL("cache.short", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.SetWithTTL("cache.long", nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.SetWithTTL("other.short", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	kvs.Store.Set("cache.plain", []byte(`{"ClientID":"xx"}`))
+	*now = now.Add(2 * time.Minute)
+	count, err := kvs.Sweep("cache.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatal("unexpected number of removed entries", count)
+	}
+	keys, err := kvs.Store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0] != "cache.long" || keys[1] != "cache.plain" || keys[2] != "other.short" {
+		t.Fatal("unexpected keys", keys)
+	}
+}
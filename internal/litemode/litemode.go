@@ -0,0 +1,33 @@
+// Package litemode contains the settings we use in lite mode, i.e.,
+// when running on low-end devices such as cheap Android phones and
+// embedded routers, where memory, CPU, and bandwidth are scarce.
+package litemode
+
+import "context"
+
+const (
+	// CheckInLimit is the maximum number of URLs to fetch from the
+	// probe services when the user has not provided any input.
+	CheckInLimit = 5
+
+	// MaxExperiments is the default number of experiments to run
+	// concurrently when running a batch of experiments.
+	MaxExperiments = 1
+
+	// SnapshotSize is the maximum size of the HTTP body snapshots
+	// that we include into measurements.
+	SnapshotSize = 1 << 13
+)
+
+type liteModeKey struct{}
+
+// Enabled returns whether lite mode is enabled for the context
+func Enabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(liteModeKey{}).(bool)
+	return enabled
+}
+
+// WithLiteMode returns a copy of the context with lite mode enabled
+func WithLiteMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, liteModeKey{}, true)
+}
@@ -0,0 +1,18 @@
+package litemode_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/litemode"
+)
+
+func TestLiteMode(t *testing.T) {
+	ctx := context.Background()
+	if litemode.Enabled(ctx) {
+		t.Fatal("lite mode should be disabled by default")
+	}
+	if !litemode.Enabled(litemode.WithLiteMode(ctx)) {
+		t.Fatal("lite mode should be enabled")
+	}
+}
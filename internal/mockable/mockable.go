@@ -49,6 +49,15 @@ func (sess *ExperimentSession) CABundlePath() string {
 	return sess.MockableCABundlePath
 }
 
+// GetBestTestHelper implements ExperimentSession.GetBestTestHelper
+func (sess *ExperimentSession) GetBestTestHelper(ctx context.Context, name string) (*model.Service, bool) {
+	services := probeservices.OnlyHTTPSHelpers(sess.MockableTestHelpers[name])
+	if len(services) <= 0 {
+		return nil, false
+	}
+	return &services[0], true
+}
+
 // GetTestHelpersByName implements ExperimentSession.GetTestHelpersByName
 func (sess *ExperimentSession) GetTestHelpersByName(name string) ([]model.Service, bool) {
 	services, okay := sess.MockableTestHelpers[name]
@@ -190,7 +199,7 @@ func (c ExperimentOrchestraClient) FetchTorTargets(
 
 // FetchURLList implements ExperimentOrchestraClient.FetchURLList.
 func (c ExperimentOrchestraClient) FetchURLList(
-	ctx context.Context, config model.URLListConfig) ([]model.URLInfo, error) {
+	ctx context.Context, config model.FetchURLListConfig) ([]model.URLInfo, error) {
 	return c.MockableFetchURLListResult, c.MockableFetchURLListErr
 }
 
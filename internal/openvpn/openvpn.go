@@ -0,0 +1,114 @@
+// Package openvpn contains code to perform the first step of the OpenVPN
+// handshake, i.e., exchanging hard reset packets with a server.
+package openvpn
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	hardResetClientV2 = 7
+	hardResetServerV2 = 8
+)
+
+// ErrHandshake indicates that the server did not reply
+// with a valid OpenVPN hard reset packet.
+var ErrHandshake = errors.New("openvpn: invalid hard reset reply")
+
+// newHardReset creates a P_CONTROL_HARD_RESET_CLIENT_V2 packet and
+// returns it along with the session ID it contains. We do not use
+// tls-auth, hence the packet does not contain an HMAC.
+func newHardReset() ([]byte, []byte, error) {
+	sessionID := make([]byte, 8)
+	if _, err := rand.Read(sessionID); err != nil {
+		return nil, nil, err
+	}
+	packet := []byte{hardResetClientV2 << 3} // key ID is zero
+	packet = append(packet, sessionID...)
+	packet = append(packet, 0)          // no acks
+	packet = append(packet, 0, 0, 0, 0) // packet ID
+	return packet, sessionID, nil
+}
+
+// Handshake sends a client hard reset and checks whether the server
+// replies with P_CONTROL_HARD_RESET_SERVER_V2 and acknowledges our session
+// ID. The network is either "tcp" or "udp". Over TCP, each OpenVPN packet
+// is prefixed by its length. We use the context deadline, if any.
+func Handshake(ctx context.Context, conn net.Conn, network string) error {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+	packet, sessionID, err := newHardReset()
+	if err != nil {
+		return err
+	}
+	if network == "tcp" {
+		packet = append([]byte{0, byte(len(packet))}, packet...)
+	}
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+	var reply []byte
+	if network == "tcp" {
+		reply, err = readTCPPacket(conn)
+	} else {
+		reply, err = readUDPPacket(conn)
+	}
+	if err != nil {
+		return err
+	}
+	return checkHardReset(reply, sessionID)
+}
+
+func readTCPPacket(conn net.Conn) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func readUDPPacket(conn net.Conn) ([]byte, error) {
+	reply := make([]byte, 1500)
+	count, err := conn.Read(reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply[:count], nil
+}
+
+// checkHardReset checks whether reply is a server hard reset that
+// acknowledges the packet we sent in the session with the given ID.
+//
+// The reply contains the opcode and key ID, the server session ID, the
+// number of acks, the acked packet IDs and our session ID.
+func checkHardReset(reply, sessionID []byte) error {
+	const minSize = 1 + 8 + 1
+	if len(reply) < minSize || reply[0]>>3 != hardResetServerV2 {
+		return ErrHandshake
+	}
+	acks := int(reply[9])
+	if acks <= 0 {
+		return ErrHandshake
+	}
+	offset := minSize + 4*acks
+	if len(reply) < offset+len(sessionID) {
+		return ErrHandshake
+	}
+	for idx, b := range sessionID {
+		if reply[offset+idx] != b {
+			return ErrHandshake
+		}
+	}
+	return nil
+}
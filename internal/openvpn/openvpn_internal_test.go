@@ -0,0 +1,35 @@
+package openvpn
+
+import "testing"
+
+func TestCheckHardReset(t *testing.T) {
+	sessionID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	newReply := func(tail ...byte) []byte {
+		reply := append([]byte{hardResetServerV2 << 3}, make([]byte, 8)...)
+		return append(reply, tail...)
+	}
+	var inputs = []struct {
+		reply []byte
+		err   error
+	}{{
+		reply: append(newReply(1, 0, 0, 0, 0), sessionID...),
+		err:   nil,
+	}, {
+		reply: newReply(1, 0, 0, 0, 0, 8, 7, 6, 5, 4, 3, 2, 1),
+		err:   ErrHandshake,
+	}, {
+		reply: newReply(0),
+		err:   ErrHandshake,
+	}, {
+		reply: newReply(1, 0, 0),
+		err:   ErrHandshake,
+	}, {
+		reply: []byte{hardResetClientV2 << 3},
+		err:   ErrHandshake,
+	}}
+	for _, in := range inputs {
+		if err := checkHardReset(in.reply, sessionID); err != in.err {
+			t.Fatal("not the error we expected", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Package psiphonnotice allows code using a context to receive the
+// notices that psiphon-tunnel-core emits while bootstrapping a tunnel
+// with such context. This package does not depend on psiphon-tunnel-core
+// so that experiments can use it without pulling in such dependency.
+package psiphonnotice
+
+import "context"
+
+// Notice is a notice emitted by psiphon-tunnel-core.
+type Notice struct {
+	Data      map[string]interface{} `json:"data"`
+	Timestamp string                 `json:"timestamp"`
+	Type      string                 `json:"noticeType"`
+}
+
+// Handler handles notices. Note that psiphon-tunnel-core keeps emitting
+// notices for as long as the tunnel is running.
+type Handler func(notice Notice)
+
+type handlerKey struct{}
+
+// ContextHandler returns the handler for the context, if any
+func ContextHandler(ctx context.Context) Handler {
+	handler, _ := ctx.Value(handlerKey{}).(Handler)
+	return handler
+}
+
+// WithHandler returns a copy of the context using handler
+func WithHandler(ctx context.Context, handler Handler) context.Context {
+	return context.WithValue(ctx, handlerKey{}, handler)
+}
@@ -0,0 +1,23 @@
+package psiphonnotice_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	if psiphonnotice.ContextHandler(ctx) != nil {
+		t.Fatal("there should be no handler by default")
+	}
+	var notices []psiphonnotice.Notice
+	ctx = psiphonnotice.WithHandler(ctx, func(notice psiphonnotice.Notice) {
+		notices = append(notices, notice)
+	})
+	psiphonnotice.ContextHandler(ctx)(psiphonnotice.Notice{Type: "Tunnels"})
+	if len(notices) != 1 || notices[0].Type != "Tunnels" {
+		t.Fatal("the handler did not receive the notice")
+	}
+}
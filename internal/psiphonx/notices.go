@@ -0,0 +1,37 @@
+package psiphonx
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Psiphon-Labs/psiphon-tunnel-core/ClientLibrary/clientlib"
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
+)
+
+// noticeReceiver returns the clientlib notice receiver forwarding the
+// notices to the context's psiphonnotice.Handler, if any.
+func noticeReceiver(ctx context.Context) func(clientlib.NoticeEvent) {
+	handler := psiphonnotice.ContextHandler(ctx)
+	if handler == nil {
+		return nil
+	}
+	return func(event clientlib.NoticeEvent) {
+		handler(psiphonnotice.Notice{
+			Data:      event.Data,
+			Timestamp: event.Timestamp,
+			Type:      event.Type,
+		})
+	}
+}
+
+// enableDiagnosticNotices modifies the config to emit diagnostic notices. We
+// do not enable the diagnostic network parameters, which reveal sensitive
+// information on the circumvention network.
+func enableDiagnosticNotices(configJSON []byte) ([]byte, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return nil, err
+	}
+	config["EmitDiagnosticNotices"] = true
+	return json.Marshal(config)
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/Psiphon-Labs/psiphon-tunnel-core/ClientLibrary/clientlib"
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
 	"github.com/ooni/probe-engine/model"
 )
 
@@ -35,7 +36,7 @@ func (defaultDependencies) RemoveAll(path string) error {
 func (defaultDependencies) Start(
 	ctx context.Context, config []byte, workdir string) (*clientlib.PsiphonTunnel, error) {
 	return clientlib.StartTunnel(ctx, config, "", clientlib.Parameters{
-		DataRootDirectory: &workdir}, nil, nil)
+		DataRootDirectory: &workdir}, nil, noticeReceiver(ctx))
 }
 
 // Config contains the settings for Start. The empty config object implies
@@ -67,7 +68,9 @@ func makeworkingdir(config Config) (string, error) {
 	return workdir, nil
 }
 
-// Start starts the psiphon tunnel.
+// Start starts the psiphon tunnel. When the context contains a
+// psiphonnotice.Handler, we enable psiphon-tunnel-core's diagnostic
+// notices and we pass all the notices to such handler.
 func Start(
 	ctx context.Context, sess model.ExperimentSession, config Config) (*Tunnel, error) {
 	select {
@@ -89,6 +92,11 @@ func Start(
 	if err != nil {
 		return nil, err
 	}
+	if psiphonnotice.ContextHandler(ctx) != nil {
+		if configJSON, err = enableDiagnosticNotices(configJSON); err != nil {
+			return nil, err
+		}
+	}
 	workdir, err := makeworkingdir(config)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,11 @@
+package psiphonx
+
+import (
+	"context"
+
+	"github.com/Psiphon-Labs/psiphon-tunnel-core/ClientLibrary/clientlib"
+)
+
+func NoticeReceiver(ctx context.Context) func(clientlib.NoticeEvent) {
+	return noticeReceiver(ctx)
+}
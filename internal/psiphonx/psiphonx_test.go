@@ -2,6 +2,7 @@ package psiphonx_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"os"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"github.com/apex/log"
 	engine "github.com/ooni/probe-engine"
 	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
 	"github.com/ooni/probe-engine/internal/psiphonx"
 )
 
@@ -154,6 +156,49 @@ func TestUnitMakeStartFailure(t *testing.T) {
 	}
 }
 
+func TestUnitStartWithNoticeHandler(t *testing.T) {
+	expected := errors.New("mocked error")
+	var (
+		config  map[string]interface{}
+		handler func(clientlib.NoticeEvent)
+	)
+	dependencies := FakeDependencies{
+		StartErr: expected,
+		StartHook: func(ctx context.Context, data []byte) {
+			if err := json.Unmarshal(data, &config); err != nil {
+				t.Fatal(err)
+			}
+			handler = psiphonx.NoticeReceiver(ctx)
+		},
+	}
+	clnt := mockable.ExperimentOrchestraClient{
+		MockableFetchPsiphonConfigResult: []byte(`{"ClientPlatform": "ooni"}`),
+	}
+	sess := &mockable.ExperimentSession{
+		MockableOrchestraClient: clnt,
+	}
+	var count int
+	ctx := psiphonnotice.WithHandler(context.Background(), func(notice psiphonnotice.Notice) {
+		if notice.Type == "Tunnels" {
+			count++
+		}
+	})
+	_, err := psiphonx.Start(ctx, sess, psiphonx.Config{Dependencies: dependencies})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if config["EmitDiagnosticNotices"] != true || config["ClientPlatform"] != "ooni" {
+		t.Fatal("unexpected config", config)
+	}
+	if _, found := config["EmitDiagnosticNetworkParameters"]; found {
+		t.Fatal("we should not emit network parameters")
+	}
+	handler(clientlib.NoticeEvent{Type: "Tunnels"})
+	if count != 1 {
+		t.Fatal("the handler was not passed to Start")
+	}
+}
+
 func TestUnitNilTunnel(t *testing.T) {
 	var tunnel *psiphonx.Tunnel
 	if tunnel.BootstrapTime() != 0 {
@@ -169,6 +214,7 @@ type FakeDependencies struct {
 	MkdirAllErr  error
 	RemoveAllErr error
 	StartErr     error
+	StartHook    func(ctx context.Context, config []byte)
 }
 
 func (fd FakeDependencies) MkdirAll(path string, perm os.FileMode) error {
@@ -181,5 +227,8 @@ func (fd FakeDependencies) RemoveAll(path string) error {
 
 func (fd FakeDependencies) Start(
 	ctx context.Context, config []byte, workdir string) (*clientlib.PsiphonTunnel, error) {
+	if fd.StartHook != nil {
+		fd.StartHook(ctx, config)
+	}
 	return nil, fd.StartErr
 }
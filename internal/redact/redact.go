@@ -0,0 +1,275 @@
+// Package redact removes sensitive information from measurements before
+// we save or submit them.
+//
+// Redaction walks the JSON representation of the test keys and applies
+// a list of rules to every string it contains, including object keys,
+// HTTP bodies, and error strings. We decode the binary values that archival serializes as
+// {"format":"base64","data":...} before applying the rules, so that we
+// also redact binary bodies. Because rules only see the JSON data, the
+// result only depends on the input and on the rules.
+//
+// Each Rule receives the path of the string inside the JSON document and
+// the string itself and returns the redacted string. For HTTP headers,
+// which archival serializes both as a "headers" map and as a "headers_list"
+// list of [name, value] pairs, we always call the rules with a path ending
+// with "headers" and the header name, so that rules handle both cases.
+package redact
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Placeholder replaces the redacted information.
+const Placeholder = "[scrubbed]"
+
+// SensitiveHeaders are the HTTP headers that may contain credentials.
+var SensitiveHeaders = []string{
+	"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie",
+}
+
+// Rule redacts value, which we found at path inside the JSON document.
+type Rule func(path []string, value string) string
+
+// Addresses returns a rule that replaces the given IP addresses with the
+// Placeholder. We ignore the strings that are not valid IP addresses and
+// we also replace the canonical form of each IPv6 address.
+func Addresses(addresses ...string) Rule {
+	var targets []string
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		targets = append(targets, address)
+		if canonical := ip.String(); canonical != address {
+			targets = append(targets, canonical)
+		}
+	}
+	return func(path []string, value string) string {
+		for _, target := range targets {
+			value = strings.ReplaceAll(value, target, Placeholder)
+		}
+		return value
+	}
+}
+
+// localNetworks contains the private and link-local networks.
+var localNetworks = func() (out []*net.IPNet) {
+	for _, cidr := range []string{
+		"10.0.0.0/8", "100.64.0.0/10", "169.254.0.0/16", "172.16.0.0/12",
+		"192.168.0.0/16", "fc00::/7", "fe80::/10",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, network)
+	}
+	return
+}()
+
+// isLocal returns whether ip belongs to a private or link-local network.
+func isLocal(ip net.IP) bool {
+	for _, network := range localNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressLike matches the strings that may contain an IP address.
+var addressLike = regexp.MustCompile(`[0-9A-Fa-f:.]{2,}`)
+
+// LocalAddresses returns a rule that replaces the IP addresses belonging
+// to private networks (e.g., 192.168.1.1) and link-local networks (e.g.,
+// fe80::1) with the Placeholder, possibly followed by the port.
+func LocalAddresses() Rule {
+	return matchingAddresses(isLocal)
+}
+
+// OwnAddresses is like LocalAddresses but only replaces the given IP
+// addresses, e.g., the ones returned by InterfaceAddresses. Unlike
+// Addresses, it does not replace 10.0.0.1 inside of 10.0.0.10.
+func OwnAddresses(addresses ...net.IP) Rule {
+	return matchingAddresses(func(ip net.IP) bool {
+		for _, address := range addresses {
+			if address.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// InterfaceAddresses returns the IP addresses of the network interfaces
+// of this host, except for the loopback addresses, which do not identify
+// the host. We return no addresses if we cannot list them.
+func InterfaceAddresses() (out []net.IP) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && !network.IP.IsLoopback() {
+			out = append(out, network.IP)
+		}
+	}
+	return
+}
+
+// matchingAddresses returns a rule that replaces the IP addresses for
+// which match returns true with the Placeholder.
+func matchingAddresses(match func(ip net.IP) bool) Rule {
+	return func(path []string, value string) string {
+		return addressLike.ReplaceAllStringFunc(value, func(token string) string {
+			return redactAddress(token, match)
+		})
+	}
+}
+
+func redactAddress(token string, match func(ip net.IP) bool) string {
+	core := strings.TrimRight(token, ":.")
+	suffix := token[len(core):]
+	if ip := net.ParseIP(core); ip != nil {
+		if match(ip) {
+			return Placeholder + suffix
+		}
+		return token
+	}
+	// Handle IPv4 endpoints, e.g., 192.168.1.1:53.
+	if idx := strings.LastIndex(core, ":"); idx > 0 {
+		ip := net.ParseIP(core[:idx])
+		if _, err := strconv.Atoi(core[idx+1:]); err == nil && ip != nil &&
+			ip.To4() != nil && match(ip) {
+			return Placeholder + core[idx:] + suffix
+		}
+	}
+	return token
+}
+
+// Headers returns a rule that replaces the value of the HTTP headers
+// with the given names, which are case insensitive, with the Placeholder.
+func Headers(names ...string) Rule {
+	return func(path []string, value string) string {
+		if len(path) < 2 || path[len(path)-2] != "headers" {
+			return value
+		}
+		for _, name := range names {
+			if strings.EqualFold(path[len(path)-1], name) {
+				return Placeholder
+			}
+		}
+		return value
+	}
+}
+
+// JSON applies the rules to the JSON document data. It returns the
+// redacted document and whether we have redacted anything.
+func JSON(data []byte, rules ...Rule) ([]byte, bool, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, false, err
+	}
+	r := &redactor{rules: rules}
+	value = r.walk(nil, value)
+	if !r.changed {
+		return data, false, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+type redactor struct {
+	changed bool
+	rules   []Rule
+}
+
+func (r *redactor) walk(path []string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.redact(path, v)
+	case []interface{}:
+		for idx, entry := range v {
+			if pair, ok := headerPair(path, entry); ok {
+				pair[1] = r.walk(append(path, "headers", pair[0].(string)), pair[1])
+				continue
+			}
+			v[idx] = r.walk(append(path, strconv.Itoa(idx)), entry)
+		}
+		return v
+	case map[string]interface{}:
+		if data, ok := binaryData(v); ok {
+			if redacted := r.redact(path, data); redacted != data {
+				v["data"] = base64.StdEncoding.EncodeToString([]byte(redacted))
+			}
+			return v
+		}
+		renamed := make(map[string]string)
+		for key, entry := range v {
+			v[key] = r.walk(append(path, key), entry)
+			// Some test keys use addresses as keys, e.g., "1.1.1.1:443".
+			if redacted := r.redact(path, key); redacted != key {
+				renamed[key] = redacted
+			}
+		}
+		for key, redacted := range renamed {
+			v[redacted] = v[key]
+			delete(v, key)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+func (r *redactor) redact(path []string, value string) string {
+	redacted := value
+	for _, rule := range r.rules {
+		redacted = rule(path, redacted)
+	}
+	if redacted != value {
+		r.changed = true
+	}
+	return redacted
+}
+
+// headerPair returns entry as a [name, value] pair if it is an
+// entry of a "headers_list" list.
+func headerPair(path []string, entry interface{}) ([]interface{}, bool) {
+	if len(path) <= 0 || path[len(path)-1] != "headers_list" {
+		return nil, false
+	}
+	pair, ok := entry.([]interface{})
+	if !ok || len(pair) != 2 {
+		return nil, false
+	}
+	if _, ok := pair[0].(string); !ok {
+		return nil, false
+	}
+	return pair, true
+}
+
+// binaryData returns the decoded data of a binary value.
+func binaryData(v map[string]interface{}) (string, bool) {
+	if len(v) != 2 || v["format"] != "base64" {
+		return "", false
+	}
+	encoded, ok := v["data"].(string)
+	if !ok {
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
@@ -0,0 +1,197 @@
+package redact_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/redact"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+func TestAddresses(t *testing.T) {
+	rule := redact.Addresses("130.192.91.211", "2001:0db8::0001", "antani", "")
+	var cases = []struct {
+		input    string
+		expected string
+	}{
+		{"your IP is 130.192.91.211", "your IP is [scrubbed]"},
+		{"[2001:db8::1]:443", "[[scrubbed]]:443"},
+		{"2001:0db8::0001", "[scrubbed]"},
+		{"antani and 8.8.8.8", "antani and 8.8.8.8"},
+	}
+	for _, c := range cases {
+		if out := rule(nil, c.input); out != c.expected {
+			t.Fatalf("for %s expected %s but got %s", c.input, c.expected, out)
+		}
+	}
+}
+
+func TestLocalAddresses(t *testing.T) {
+	rule := redact.LocalAddresses()
+	var cases = []struct {
+		input    string
+		expected string
+	}{
+		{"192.168.1.1", "[scrubbed]"},
+		{"dial udp 10.0.0.1:53: i/o timeout", "dial udp [scrubbed]:53: i/o timeout"},
+		{"from 172.16.3.4.", "from [scrubbed]."},
+		{"link-local fe80::1%eth0", "link-local [scrubbed]%eth0"},
+		{"[fd00::1]:53", "[[scrubbed]]:53"},
+		{"169.254.1.1 and 100.64.0.1", "[scrubbed] and [scrubbed]"},
+		{"8.8.8.8:53 and 127.0.0.1 and ::1", "8.8.8.8:53 and 127.0.0.1 and ::1"},
+		{"2020-09-01 10:00:00 aa:bb:cc:dd:ee:ff cafe", "2020-09-01 10:00:00 aa:bb:cc:dd:ee:ff cafe"},
+		{"172.32.0.1", "172.32.0.1"},
+	}
+	for _, c := range cases {
+		if out := rule(nil, c.input); out != c.expected {
+			t.Fatalf("for %s expected %s but got %s", c.input, c.expected, out)
+		}
+	}
+}
+
+func TestOwnAddresses(t *testing.T) {
+	rule := redact.OwnAddresses(net.ParseIP("10.0.0.1"), net.ParseIP("fe80::1"))
+	var cases = []struct {
+		input    string
+		expected string
+	}{
+		{"dial udp 10.0.0.1:53: i/o timeout", "dial udp [scrubbed]:53: i/o timeout"},
+		{"link-local fe80::1%eth0", "link-local [scrubbed]%eth0"},
+		{"10.0.0.10 and 192.168.1.1", "10.0.0.10 and 192.168.1.1"},
+	}
+	for _, c := range cases {
+		if out := rule(nil, c.input); out != c.expected {
+			t.Fatalf("for %s expected %s but got %s", c.input, c.expected, out)
+		}
+	}
+}
+
+func TestInterfaceAddresses(t *testing.T) {
+	for _, ip := range redact.InterfaceAddresses() {
+		if ip.IsLoopback() {
+			t.Fatal("unexpected loopback address", ip)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	rule := redact.Headers(redact.SensitiveHeaders...)
+	if out := rule([]string{"requests", "0", "headers", "cookie"}, "a=b"); out != redact.Placeholder {
+		t.Fatal("expected the cookie to be redacted")
+	}
+	if out := rule([]string{"requests", "0", "headers", "Accept"}, "*/*"); out != "*/*" {
+		t.Fatal("expected the header to be unchanged")
+	}
+	if out := rule([]string{"cookie"}, "a=b"); out != "a=b" {
+		t.Fatal("expected a value outside headers to be unchanged")
+	}
+}
+
+func newTestKeys(t *testing.T) []byte {
+	failure := "connection_refused: 192.168.1.1:443"
+	request := archival.RequestEntry{
+		Failure: &failure,
+		Request: archival.HTTPRequest{
+			Headers: map[string]archival.MaybeBinaryValue{
+				"Cookie": {Value: "session=xyz"},
+			},
+			HeadersList: []archival.HTTPHeader{
+				{Key: "Cookie", Value: archival.MaybeBinaryValue{Value: "session=xyz"}},
+				{Key: "User-Agent", Value: archival.MaybeBinaryValue{Value: "miniooni/0.1.0"}},
+			},
+			URL: "https://www.example.com/",
+		},
+		Response: archival.HTTPResponse{
+			Body: archival.HTTPBody{Value: "\xff your IP is 130.192.91.211"},
+		},
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"requests": []archival.RequestEntry{request},
+		"control": map[string]interface{}{
+			"tcp_connect": map[string]interface{}{
+				"10.0.0.1:443": map[string]interface{}{"status": true},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJSON(t *testing.T) {
+	data, changed, err := redact.JSON(newTestKeys(t), redact.Addresses("130.192.91.211"),
+		redact.LocalAddresses(), redact.Headers(redact.SensitiveHeaders...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("expected something to be redacted")
+	}
+	var tk struct {
+		Control struct {
+			TCPConnect map[string]interface{} `json:"tcp_connect"`
+		} `json:"control"`
+		Requests []archival.RequestEntry `json:"requests"`
+	}
+	if err := json.Unmarshal(data, &tk); err != nil {
+		t.Fatal(err)
+	}
+	request := tk.Requests[0]
+	if *request.Failure != "connection_refused: [scrubbed]:443" {
+		t.Fatal("failure not redacted", *request.Failure)
+	}
+	if request.Request.Headers["Cookie"].Value != redact.Placeholder {
+		t.Fatal("cookie not redacted in headers")
+	}
+	if request.Request.HeadersList[0].Value.Value != redact.Placeholder ||
+		request.Request.HeadersList[1].Value.Value != "miniooni/0.1.0" {
+		t.Fatal("unexpected headers list")
+	}
+	if request.Response.Body.Value != "\xff your IP is [scrubbed]" {
+		t.Fatal("binary body not redacted")
+	}
+	if _, found := tk.Control.TCPConnect["[scrubbed]:443"]; !found || len(tk.Control.TCPConnect) != 1 {
+		t.Fatal("key not redacted", tk.Control.TCPConnect)
+	}
+	if strings.Contains(string(data), base64.StdEncoding.EncodeToString([]byte("130.192.91.211"))) {
+		t.Fatal("unexpected base64 leak")
+	}
+}
+
+func TestJSONDeterministic(t *testing.T) {
+	rules := []redact.Rule{redact.LocalAddresses(), redact.Headers(redact.SensitiveHeaders...)}
+	first, _, err := redact.JSON(newTestKeys(t), rules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		again, _, err := redact.JSON(newTestKeys(t), rules...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(first) {
+			t.Fatal("redaction is not deterministic")
+		}
+	}
+}
+
+func TestJSONUnchanged(t *testing.T) {
+	input := []byte(`{"antani": ["mascetti", 17, null, true, {"format": "base64", "data": "%%%"}]}`)
+	data, changed, err := redact.JSON(input, redact.LocalAddresses())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || string(data) != string(input) {
+		t.Fatal("expected the input to be unchanged")
+	}
+}
+
+func TestJSONInvalid(t *testing.T) {
+	if _, _, err := redact.JSON([]byte("{"), redact.LocalAddresses()); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -18,6 +18,11 @@ type Resolver struct {
 	PrimaryFailure  *atomicx.Int64
 	Fallback        netx.DNSClient
 	FallbackFailure *atomicx.Int64
+
+	// ObserveLookup, if not nil, is called after each lookup with the
+	// resolver we used, i.e., "doh" or "system", how long the lookup
+	// took, and the error that occurred, if any.
+	ObserveLookup func(resolver string, elapsed time.Duration, err error)
 }
 
 // New creates a new session resolver.
@@ -48,10 +53,14 @@ func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, e
 	// and therefore see to use DoH more often.
 	trr2, cancel := context.WithTimeout(ctx, 4*time.Second)
 	defer cancel()
+	start := time.Now()
 	addrs, err := r.Primary.LookupHost(trr2, hostname)
+	r.observeLookup("doh", start, err)
 	if err != nil {
 		r.PrimaryFailure.Add(1)
+		start = time.Now()
 		addrs, err = r.Fallback.LookupHost(ctx, hostname)
+		r.observeLookup("system", start, err)
 		if err != nil {
 			r.FallbackFailure.Add(1)
 		}
@@ -59,6 +68,12 @@ func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, e
 	return addrs, err
 }
 
+func (r *Resolver) observeLookup(resolver string, start time.Time, err error) {
+	if r.ObserveLookup != nil {
+		r.ObserveLookup(resolver, time.Since(start), err)
+	}
+}
+
 // Network implements Resolver.Network
 func (r *Resolver) Network() string {
 	return "sessionresolver"
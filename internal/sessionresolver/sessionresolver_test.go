@@ -2,9 +2,12 @@ package sessionresolver_test
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/internal/sessionresolver"
 	"github.com/ooni/probe-engine/netx"
 )
@@ -32,3 +35,44 @@ func TestIntegration(t *testing.T) {
 		t.Fatal("not the counters we expected to see here")
 	}
 }
+
+type fakeResolver struct {
+	err error
+}
+
+func (r fakeResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []string{"8.8.8.8"}, nil
+}
+
+func (r fakeResolver) Network() string {
+	return "fake"
+}
+
+func (r fakeResolver) Address() string {
+	return ""
+}
+
+func TestObserveLookup(t *testing.T) {
+	var observed []string
+	reso := &sessionresolver.Resolver{
+		Primary:         netx.DNSClient{Resolver: fakeResolver{err: errors.New("mocked error")}},
+		PrimaryFailure:  atomicx.NewInt64(),
+		Fallback:        netx.DNSClient{Resolver: fakeResolver{}},
+		FallbackFailure: atomicx.NewInt64(),
+		ObserveLookup: func(resolver string, elapsed time.Duration, err error) {
+			observed = append(observed, resolver)
+			if (resolver == "doh") != (err != nil) {
+				t.Fatal("unexpected error for", resolver, err)
+			}
+		},
+	}
+	if _, err := reso.LookupHost(context.Background(), "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(observed, ",") != "doh,system" {
+		t.Fatal("not the lookups we expected", observed)
+	}
+}
@@ -0,0 +1,160 @@
+// Package sink writes measurements to newline-delimited JSON files,
+// which is convenient when collecting large datasets on headless probes.
+//
+// We write the measurements into a new file in the configured directory
+// and, when the file reaches the configured size, we rotate it, i.e., we
+// close it and continue writing into a new file. Optionally, we compress
+// the files using gzip. We name each file after the time when we created
+// it, so that sorting the file names sorts the measurements by time.
+package sink
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// DefaultMaxFileSize is the default value of Config.MaxFileSize.
+const DefaultMaxFileSize = 64 << 20
+
+// ErrClosed indicates that the sink has been closed.
+var ErrClosed = errors.New("sink: closed")
+
+// Config configures a Sink. Dir is the directory where we write the
+// files, which we create if needed. MaxFileSize is the number of bytes
+// after which we rotate a file; we use DefaultMaxFileSize when it is not
+// positive. When we compress files, MaxFileSize is the number of bytes
+// before compression. Gzip tells whether to compress the files.
+type Config struct {
+	Dir         string
+	Gzip        bool
+	MaxFileSize int64
+}
+
+// Sink writes measurements into files. It is safe to use a Sink from
+// several goroutines.
+type Sink struct {
+	closed  bool
+	config  Config
+	file    *os.File
+	mu      sync.Mutex
+	now     func() time.Time
+	seq     int
+	w       io.Writer
+	written int64
+	zw      *gzip.Writer
+}
+
+// New creates a new Sink. We create the first file when writing the
+// first measurement, so that we do not create empty files.
+func New(config Config) (*Sink, error) {
+	if config.MaxFileSize <= 0 {
+		config.MaxFileSize = DefaultMaxFileSize
+	}
+	if err := os.MkdirAll(config.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Sink{config: config, now: time.Now}, nil
+}
+
+// Write appends measurement to the current file, which we first rotate
+// if it has already reached MaxFileSize. We stream the measurement into
+// the file (see model.Measurement.WriteJSON), hence a file may exceed
+// MaxFileSize by the size of its last measurement, but a measurement is
+// never split across files. If we fail midway, we close the file, which
+// may end with a partial line, and continue with a new file.
+func (s *Sink) Write(measurement *model.Measurement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.file != nil && s.written >= s.config.MaxFileSize {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.openFile(); err != nil {
+			return err
+		}
+	}
+	cw := &countingWriter{w: s.w}
+	err := measurement.WriteJSON(cw)
+	s.written += cw.count
+	if err != nil {
+		s.closeFile()
+	}
+	return err
+}
+
+// countingWriter counts the bytes written into w.
+type countingWriter struct {
+	count int64
+	w     io.Writer
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	count, err := cw.w.Write(p)
+	cw.count += int64(count)
+	return count, err
+}
+
+// Close closes the current file, if any. It is safe to call Close
+// more than once. We fail writing after Close.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	return s.closeFile()
+}
+
+// openFile creates a new file. We use O_EXCL and a sequence number, so
+// that we never overwrite the files written by another sink.
+func (s *Sink) openFile() error {
+	suffix := ".jsonl"
+	if s.config.Gzip {
+		suffix += ".gz"
+	}
+	stamp := s.now().UTC().Format("20060102T150405Z")
+	for {
+		s.seq++
+		name := fmt.Sprintf("measurements-%s-%04d%s", stamp, s.seq, suffix)
+		file, err := os.OpenFile(filepath.Join(s.config.Dir, name),
+			os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.file, s.w, s.written = file, file, 0
+		if s.config.Gzip {
+			s.zw = gzip.NewWriter(file)
+			s.w = s.zw
+		}
+		return nil
+	}
+}
+
+func (s *Sink) closeFile() error {
+	var err error
+	if s.zw != nil {
+		err = s.zw.Close()
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file, s.w, s.zw = nil, nil, nil
+	return err
+}
@@ -0,0 +1,202 @@
+package sink_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/sink"
+	"github.com/ooni/probe-engine/model"
+)
+
+func newDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "measurements")
+}
+
+// readAll returns the inputs of the measurements in each file.
+func readAll(t *testing.T, dir string) (out [][]string) {
+	files, err := filepath.Glob(filepath.Join(dir, "measurements-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		filep, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer filep.Close()
+		var reader io.Reader = filep
+		if strings.HasSuffix(name, ".gz") {
+			zr, err := gzip.NewReader(filep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = zr
+		}
+		var inputs []string
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			var m model.Measurement
+			if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+				t.Fatal(err)
+			}
+			inputs = append(inputs, string(m.Input))
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, inputs)
+	}
+	return
+}
+
+func write(t *testing.T, s *sink.Sink, inputs ...string) {
+	for _, input := range inputs {
+		measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+		if err := s.Write(measurement); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteWithoutRotation(t *testing.T) {
+	dir := newDir(t)
+	s, err := sink.New(sink.Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readAll(t, dir); len(files) != 0 {
+		t.Fatal("we should not create empty files")
+	}
+	write(t, s, "a", "b", "c")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files := readAll(t, dir)
+	if len(files) != 1 || strings.Join(files[0], ",") != "a,b,c" {
+		t.Fatal("unexpected files", files)
+	}
+}
+
+func TestRotationAndGzip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := newDir(t)
+		data, _ := json.Marshal(&model.Measurement{Input: "a"})
+		s, err := sink.New(sink.Config{
+			Dir:         dir,
+			Gzip:        compress,
+			MaxFileSize: int64(2*len(data) + 2),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(t, s, "a", "b", "c", "d", "e")
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		files := readAll(t, dir)
+		if len(files) != 3 {
+			t.Fatal("unexpected number of files", files)
+		}
+		if strings.Join(files[0], ",") != "a,b" || strings.Join(files[2], ",") != "e" {
+			t.Fatal("unexpected files", files)
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.gz"))
+		if compress != (len(matches) == 3) {
+			t.Fatal("unexpected compression")
+		}
+	}
+}
+
+func TestLargeMeasurementIsNotSplit(t *testing.T) {
+	dir := newDir(t)
+	s, err := sink.New(sink.Config{Dir: dir, MaxFileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, s, "a", "b")
+	s.Close()
+	files := readAll(t, dir)
+	if len(files) != 2 || len(files[0]) != 1 || len(files[1]) != 1 {
+		t.Fatal("unexpected files", files)
+	}
+}
+
+func TestTwoSinksDoNotOverwriteEachOther(t *testing.T) {
+	dir := newDir(t)
+	first, err := sink.New(sink.Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sink.New(sink.Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, first, "a")
+	write(t, second, "b")
+	first.Close()
+	second.Close()
+	if files := readAll(t, dir); len(files) != 2 {
+		t.Fatal("unexpected files", files)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	s, err := sink.New(sink.Config{Dir: newDir(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Write(&model.Measurement{})
+	if !errors.Is(err, sink.ErrClosed) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestWriteFailureStartsNewFile(t *testing.T) {
+	dir := newDir(t)
+	s, err := sink.New(sink.Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	measurement := &model.Measurement{TestKeys: map[string]interface{}{"func": func() {}}}
+	if err := s.Write(measurement); err == nil {
+		t.Fatal("expected an error here")
+	}
+	write(t, s, "a")
+	files, err := filepath.Glob(filepath.Join(dir, "measurements-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatal("unexpected files", files)
+	}
+}
+
+func TestNewFailure(t *testing.T) {
+	dir := newDir(t)
+	if err := ioutil.WriteFile(dir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sink.New(sink.Config{Dir: dir}); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -0,0 +1,156 @@
+package torx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	goptlib "git.torproject.org/pluggable-transports/goptlib.git"
+	"github.com/ooni/probe-engine/model"
+	"gitlab.com/yawning/obfs4.git/transports/base"
+	"gitlab.com/yawning/obfs4.git/transports/obfs4"
+)
+
+// ErrUnsupportedBridge indicates that a bridge line does not use
+// the expected pluggable transport.
+var ErrUnsupportedBridge = errors.New("torx: unsupported bridge")
+
+// BridgeLine returns the bridge line for an obfs4 target (such as
+// the ones returned by probeservices.FetchTorTargets).
+func BridgeLine(target model.TorTarget) (string, error) {
+	if target.Protocol != "obfs4" || target.Address == "" {
+		return "", ErrUnsupportedBridge
+	}
+	var keys []string
+	for key := range target.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := []string{"obfs4", target.Address}
+	for _, key := range keys {
+		for _, value := range target.Params[key] {
+			fields = append(fields, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// BridgeLines returns the bridge lines of all the obfs4 targets
+// sorted by target name, ignoring any other target.
+func BridgeLines(targets map[string]model.TorTarget) (out []string) {
+	var names []string
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if line, err := BridgeLine(targets[name]); err == nil {
+			out = append(out, line)
+		}
+	}
+	return
+}
+
+// parseBridgeLine validates a bridge line using transport, which may
+// optionally start with "Bridge", and returns it without such prefix.
+func parseBridgeLine(line, transport string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && fields[0] == "Bridge" {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 || fields[0] != transport {
+		return "", ErrUnsupportedBridge
+	}
+	if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+		return "", ErrUnsupportedBridge
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// obfs4Transport is an obfs4 pluggable transport client embedded into
+// the engine, so that we do not need the obfs4proxy binary. It exposes
+// the SOCKS5 interface with which tor speaks to external transports.
+type obfs4Transport struct {
+	factory  base.ClientFactory
+	listener *goptlib.SocksListener
+	wg       sync.WaitGroup
+}
+
+// startOBFS4Transport starts the embedded obfs4 transport. The stateDir
+// is where obfs4 stores its state.
+func startOBFS4Transport(stateDir string) (*obfs4Transport, error) {
+	factory, err := new(obfs4.Transport).ClientFactory(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := goptlib.ListenSocks("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	txp := &obfs4Transport{factory: factory, listener: listener}
+	txp.wg.Add(1)
+	go txp.acceptLoop()
+	return txp, nil
+}
+
+// Addr returns the address of the SOCKS5 listener.
+func (txp *obfs4Transport) Addr() string {
+	return txp.listener.Addr().String()
+}
+
+// Close stops accepting connections and waits for the accept loop
+// to terminate. Established connections are not interrupted, because
+// they are closed by tor when it exits.
+func (txp *obfs4Transport) Close() error {
+	err := txp.listener.Close()
+	txp.wg.Wait()
+	return err
+}
+
+func (txp *obfs4Transport) acceptLoop() {
+	defer txp.wg.Done()
+	for {
+		conn, err := txp.listener.AcceptSocks()
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				continue
+			}
+			return
+		}
+		go txp.handle(conn)
+	}
+}
+
+func (txp *obfs4Transport) handle(conn *goptlib.SocksConn) {
+	defer conn.Close()
+	args, err := txp.factory.ParseArgs(&conn.Req.Args)
+	if err != nil {
+		conn.Reject()
+		return
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	remote, err := txp.factory.Dial("tcp", conn.Req.Target, dialer.Dial, args)
+	if err != nil {
+		conn.Reject()
+		return
+	}
+	defer remote.Close()
+	if err := conn.Grant(nil); err != nil {
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done // closing both conns interrupts the other copy
+}
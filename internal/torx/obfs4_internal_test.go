@@ -0,0 +1,41 @@
+package torx
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestOBFS4TransportRejectsMissingArgs(t *testing.T) {
+	txp, err := startOBFS4Transport("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txp.Close()
+	conn, err := net.Dial("tcp", txp.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		t.Fatal("unexpected method selection reply")
+	}
+	// CONNECT 127.0.0.1:1 without the cert and iat-mode args
+	if _, err := conn.Write([]byte{5, 1, 0, 1, 127, 0, 0, 1, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	reply = make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] == 0 {
+		t.Fatal("expected the request to be rejected")
+	}
+}
@@ -0,0 +1,232 @@
+package torx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/pion/stun"
+)
+
+const (
+	defaultSnowflakeBridge       = "snowflake 192.0.2.3:1 2B280B23E1107BB62ABFC40DDCC8824814F80A72"
+	defaultSnowflakeBrokerURL    = "https://snowflake-broker.torproject.net.global.prod.fastly.net/"
+	defaultSnowflakeClientBinary = "snowflake-client"
+	defaultSnowflakeFrontDomain  = "cdn.sstatic.net"
+	snowflakeCheckTimeout        = 10 * time.Second
+)
+
+var defaultSnowflakeSTUNServers = []string{
+	"stun:stun.l.google.com:19302",
+	"stun:stun.voip.blackberry.com:3478",
+}
+
+// SnowflakeConfig configures snowflake. BrokerURL is the URL of the
+// broker, which we reach using domain fronting with FrontDomain, and
+// STUNServers are the STUN servers (e.g. "stun:stun.l.google.com:19302")
+// that snowflake uses to traverse NATs. ClientBinary is the path of the
+// snowflake-client binary. We use sensible defaults for empty fields.
+type SnowflakeConfig struct {
+	BrokerURL    string
+	ClientBinary string
+	FrontDomain  string
+	STUNServers  []string
+}
+
+func (c SnowflakeConfig) withDefaults() SnowflakeConfig {
+	if c.BrokerURL == "" {
+		c.BrokerURL = defaultSnowflakeBrokerURL
+	}
+	if c.ClientBinary == "" {
+		c.ClientBinary = defaultSnowflakeClientBinary
+	}
+	if c.FrontDomain == "" {
+		c.FrontDomain = defaultSnowflakeFrontDomain
+	}
+	if len(c.STUNServers) <= 0 {
+		c.STUNServers = defaultSnowflakeSTUNServers
+	}
+	return c
+}
+
+// plugin returns the value of tor's ClientTransportPlugin option.
+func (c SnowflakeConfig) plugin(logfile string) string {
+	return fmt.Sprintf("snowflake exec %s -url %s -front %s -ice %s -log %s",
+		c.ClientBinary, c.BrokerURL, c.FrontDomain,
+		strings.Join(c.STUNServers, ","), logfile)
+}
+
+// SnowflakeDiagnostics contains the results of checking whether we can
+// reach the infrastructure used by snowflake for the rendezvous, i.e.,
+// the broker (through the front domain) and the STUN servers. These
+// checks are informational: we try to bootstrap regardless.
+type SnowflakeDiagnostics struct {
+	Broker SnowflakeBrokerCheck
+	STUN   []SnowflakeSTUNCheck
+}
+
+// SnowflakeBrokerCheck is the result of fetching a page from the broker.
+type SnowflakeBrokerCheck struct {
+	Err        error
+	RTT        time.Duration
+	StatusCode int
+}
+
+// SnowflakeSTUNCheck is the result of sending a STUN binding request
+// to a STUN server. MappedAddress is our address seen by the server.
+type SnowflakeSTUNCheck struct {
+	Err           error
+	MappedAddress string
+	RTT           time.Duration
+	Server        string
+}
+
+// startSnowflake checks the rendezvous infrastructure and then starts
+// tor using snowflake. We use the default snowflake bridge unless the
+// config contains snowflake bridge lines.
+func startSnowflake(ctx context.Context, config StartConfig) (*Tunnel, error) {
+	snowflake := config.Snowflake.withDefaults()
+	bridges := []string{defaultSnowflakeBridge}
+	if len(config.Bridges) > 0 {
+		bridges = nil
+		for _, line := range config.Bridges {
+			bridge, err := parseBridgeLine(line, "snowflake")
+			if err != nil {
+				return nil, err
+			}
+			bridges = append(bridges, bridge)
+		}
+	}
+	diagnostics := diagnoseSnowflake(ctx, config.Sess.Logger(), snowflake)
+	logfile := path.Join(config.Sess.TempDir(), "snowflake.log")
+	tun, err := startWithBridges(ctx, config, bridges, snowflake.plugin(logfile))
+	if err != nil {
+		return nil, err
+	}
+	tun.snowflake = diagnostics
+	return tun, nil
+}
+
+// diagnoseSnowflake checks in parallel the broker and the STUN servers.
+func diagnoseSnowflake(
+	ctx context.Context, logger model.Logger, config SnowflakeConfig) *SnowflakeDiagnostics {
+	diagnostics := &SnowflakeDiagnostics{
+		STUN: make([]SnowflakeSTUNCheck, len(config.STUNServers)),
+	}
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		diagnostics.Broker = checkSnowflakeBroker(ctx, logger, config)
+	}()
+	for idx, server := range config.STUNServers {
+		wg.Add(1)
+		go func(idx int, server string) {
+			defer wg.Done()
+			diagnostics.STUN[idx] = checkSTUNServer(ctx, logger, server)
+		}(idx, server)
+	}
+	wg.Wait()
+	if err := diagnostics.Broker.Err; err != nil {
+		logger.Warnf("snowflake: cannot reach broker: %s", err.Error())
+	}
+	for _, check := range diagnostics.STUN {
+		if check.Err != nil {
+			logger.Warnf("snowflake: cannot use %s: %s", check.Server, check.Err.Error())
+		}
+	}
+	return diagnostics
+}
+
+// ErrSnowflakeBroker indicates that the broker returned an error.
+var ErrSnowflakeBroker = errors.New("torx: snowflake broker returned an error")
+
+// checkSnowflakeBroker fetches the broker's debug page using
+// domain fronting like snowflake-client does.
+func checkSnowflakeBroker(
+	ctx context.Context, logger model.Logger, config SnowflakeConfig) (out SnowflakeBrokerCheck) {
+	URL, err := url.Parse(config.BrokerURL)
+	if err != nil {
+		out.Err = err
+		return
+	}
+	brokerHost := URL.Host
+	URL.Host = config.FrontDomain
+	URL.Path = path.Join("/", URL.Path, "debug")
+	ctx, cancel := context.WithTimeout(ctx, snowflakeCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", URL.String(), nil)
+	if err != nil {
+		out.Err = err
+		return
+	}
+	req.Host = brokerHost
+	txp := netx.NewHTTPTransport(netx.Config{Logger: logger})
+	defer txp.CloseIdleConnections()
+	start := time.Now()
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		out.Err = err
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	out.RTT, out.StatusCode = time.Since(start), resp.StatusCode
+	if resp.StatusCode != 200 {
+		out.Err = ErrSnowflakeBroker
+	}
+	return
+}
+
+// checkSTUNServer sends a binding request to a "stun:host:port" server.
+func checkSTUNServer(ctx context.Context, logger model.Logger, server string) (out SnowflakeSTUNCheck) {
+	out.Server = server
+	ctx, cancel := context.WithTimeout(ctx, snowflakeCheckTimeout)
+	defer cancel()
+	dialer := netx.NewDialer(netx.Config{Logger: logger})
+	conn, err := dialer.DialContext(ctx, "udp", strings.TrimPrefix(server, "stun:"))
+	if err != nil {
+		out.Err = err
+		return
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	start := time.Now()
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := request.WriteTo(conn); err != nil {
+		out.Err = err
+		return
+	}
+	buffer := make([]byte, 1500)
+	for {
+		count, err := conn.Read(buffer)
+		if err != nil {
+			out.Err = err
+			return
+		}
+		response := &stun.Message{Raw: append([]byte{}, buffer[:count]...)}
+		if response.Decode() != nil || response.TransactionID != request.TransactionID {
+			continue // not a response to our request
+		}
+		var addr stun.XORMappedAddress
+		if err := addr.GetFrom(response); err != nil {
+			out.Err = err
+			return
+		}
+		out.MappedAddress = net.JoinHostPort(addr.IP.String(), fmt.Sprint(addr.Port))
+		out.RTT = time.Since(start)
+		return
+	}
+}
@@ -3,9 +3,12 @@ package torx
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,16 +17,57 @@ import (
 	"github.com/ooni/probe-engine/model"
 )
 
+// ErrAllBridgesFailed indicates that we could not bootstrap using any bridge.
+var ErrAllBridgesFailed = errors.New("torx: cannot bootstrap using any bridge")
+
 // TorProcess is a running tor process
 type TorProcess interface {
 	Close() error
 }
 
+// BootstrapProgress is a bootstrap status event emitted by tor. Progress
+// is the bootstrap percentage, Tag identifies the bootstrap phase (e.g.,
+// "conn_done") and Summary describes it (e.g., "Connected to a relay").
+type BootstrapProgress struct {
+	Progress int
+	Summary  string
+	Tag      string
+}
+
+// BridgeAttempt is the result of trying to bootstrap using a bridge.
+type BridgeAttempt struct {
+	Bridge        string
+	BootstrapTime time.Duration
+	Err           error
+}
+
 // Tunnel is the Tor tunnel
 type Tunnel struct {
+	attempts      []BridgeAttempt
 	bootstrapTime time.Duration
+	bridge        string
 	instance      TorProcess
 	proxy         *url.URL
+	snowflake     *SnowflakeDiagnostics
+	transport     io.Closer
+}
+
+// Bridge returns the bridge with which we bootstrapped, or an
+// empty string if we did not use bridges.
+func (tt *Tunnel) Bridge() (bridge string) {
+	if tt != nil {
+		bridge = tt.bridge
+	}
+	return
+}
+
+// BridgeAttempts returns the results of all the bootstrap attempts
+// using bridges, including the successful attempt.
+func (tt *Tunnel) BridgeAttempts() (attempts []BridgeAttempt) {
+	if tt != nil {
+		attempts = tt.attempts
+	}
+	return
 }
 
 // BootstrapTime is the bootstrsap time
@@ -42,24 +86,73 @@ func (tt *Tunnel) SOCKS5ProxyURL() (url *url.URL) {
 	return
 }
 
+// SnowflakeDiagnostics returns the results of checking the snowflake
+// rendezvous, or nil if we did not use snowflake.
+func (tt *Tunnel) SnowflakeDiagnostics() (diagnostics *SnowflakeDiagnostics) {
+	if tt != nil {
+		diagnostics = tt.snowflake
+	}
+	return
+}
+
 // Stop stops the Tor tunnel
 func (tt *Tunnel) Stop() {
 	if tt != nil {
 		tt.instance.Close()
+		if tt.transport != nil {
+			tt.transport.Close()
+		}
 	}
 }
 
-// StartConfig contains the configuration for StartWithConfig
+// StartConfig contains the configuration for StartWithConfig. When Bridges
+// is not empty, we try each obfs4 bridge in order, restarting tor every
+// time, until we bootstrap, and each attempt lasts at most BridgeTimeout
+// (two minutes by default). We use the obfs4proxy at OBFS4ProxyPath as
+// the pluggable transport if set, and otherwise the embedded obfs4. When
+// Snowflake is not nil, we use snowflake bridges instead (see startSnowflake).
+// DataDir is tor's data directory, by default "tor" inside the session's
+// temporary directory. When Progress is not nil, we call it for each
+// bootstrap status event emitted by tor while bootstrapping.
 type StartConfig struct {
-	Sess          model.ExperimentSession
-	Start         func(ctx context.Context, conf *tor.StartConf) (*tor.Tor, error)
-	EnableNetwork func(ctx context.Context, tor *tor.Tor, wait bool) error
-	GetInfo       func(ctrl *control.Conn, keys ...string) ([]*control.KeyVal, error)
+	Bridges        []string
+	BridgeTimeout  time.Duration
+	DataDir        string
+	OBFS4ProxyPath string
+	Progress       func(progress BootstrapProgress)
+	Sess           model.ExperimentSession
+	Snowflake      *SnowflakeConfig
+	Start          func(ctx context.Context, conf *tor.StartConf) (*tor.Tor, error)
+	EnableNetwork  func(ctx context.Context, tor *tor.Tor, wait bool) error
+	GetInfo        func(ctrl *control.Conn, keys ...string) ([]*control.KeyVal, error)
 }
 
 // Start starts the tor tunnel
 func Start(ctx context.Context, sess model.ExperimentSession) (*Tunnel, error) {
-	return StartWithConfig(ctx, StartConfig{
+	return StartWithBridges(ctx, sess, nil, "")
+}
+
+// StartWithBridges starts the tor tunnel using the given obfs4 bridge lines
+// (see BridgeLine) and the obfs4proxy binary at obfs4proxy, if not empty.
+func StartWithBridges(ctx context.Context, sess model.ExperimentSession,
+	bridges []string, obfs4proxy string) (*Tunnel, error) {
+	config := NewStartConfig(sess)
+	config.Bridges, config.OBFS4ProxyPath = bridges, obfs4proxy
+	return StartWithConfig(ctx, config)
+}
+
+// StartWithSnowflake starts the tor tunnel using snowflake.
+func StartWithSnowflake(ctx context.Context, sess model.ExperimentSession,
+	snowflake SnowflakeConfig) (*Tunnel, error) {
+	config := NewStartConfig(sess)
+	config.Snowflake = &snowflake
+	return StartWithConfig(ctx, config)
+}
+
+// NewStartConfig returns the StartConfig used by Start, which you can
+// customize before calling StartWithConfig.
+func NewStartConfig(sess model.ExperimentSession) StartConfig {
+	return StartConfig{
 		Sess: sess,
 		Start: func(ctx context.Context, conf *tor.StartConf) (*tor.Tor, error) {
 			return tor.Start(ctx, conf)
@@ -70,7 +163,7 @@ func Start(ctx context.Context, sess model.ExperimentSession) (*Tunnel, error) {
 		GetInfo: func(ctrl *control.Conn, keys ...string) ([]*control.KeyVal, error) {
 			return ctrl.GetInfo(keys...)
 		},
-	})
+	}
 }
 
 // StartWithConfig is a configurable Start for testing
@@ -80,14 +173,94 @@ func StartWithConfig(ctx context.Context, config StartConfig) (*Tunnel, error) {
 		return nil, ctx.Err() // allows to write unit tests using this code
 	default:
 	}
+	if config.Snowflake != nil {
+		return startSnowflake(ctx, config)
+	}
+	if len(config.Bridges) <= 0 {
+		return start(ctx, config, nil)
+	}
+	var bridges []string
+	for _, line := range config.Bridges {
+		bridge, err := parseBridgeLine(line, "obfs4")
+		if err != nil {
+			return nil, err
+		}
+		bridges = append(bridges, bridge)
+	}
+	if config.OBFS4ProxyPath != "" {
+		plugin := fmt.Sprintf("obfs4 exec %s", config.OBFS4ProxyPath)
+		return startWithBridges(ctx, config, bridges, plugin)
+	}
+	txp, err := startOBFS4Transport(path.Join(config.Sess.TempDir(), "obfs4"))
+	if err != nil {
+		return nil, err
+	}
+	plugin := fmt.Sprintf("obfs4 socks5 %s", txp.Addr())
+	tun, err := startWithBridges(ctx, config, bridges, plugin)
+	if err != nil {
+		txp.Close()
+		return nil, err
+	}
+	tun.transport = txp
+	return tun, nil
+}
+
+// startWithBridges tries each bridge in order using the given client
+// transport plugin until tor bootstraps.
+func startWithBridges(ctx context.Context, config StartConfig,
+	bridges []string, plugin string) (*Tunnel, error) {
+	timeout := config.BridgeTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	logger := config.Sess.Logger()
+	var attempts []BridgeAttempt
+	for _, bridge := range bridges {
+		logger.Infof("tor: trying bridge %s", bridge)
+		attemptctx, cancel := context.WithTimeout(ctx, timeout)
+		tun, err := start(attemptctx, config, []string{
+			"UseBridges", "1",
+			"ClientTransportPlugin", plugin,
+			"Bridge", bridge,
+		})
+		cancel()
+		if err != nil {
+			logger.Warnf("tor: cannot bootstrap using %s: %s", bridge, err.Error())
+			attempts = append(attempts, BridgeAttempt{Bridge: bridge, Err: err})
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		logger.Infof("tor: bootstrapped using %s in %s", bridge, tun.bootstrapTime)
+		tun.attempts = append(attempts, BridgeAttempt{
+			Bridge:        bridge,
+			BootstrapTime: tun.bootstrapTime,
+		})
+		tun.bridge = bridge
+		return tun, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrAllBridgesFailed
+}
+
+// start starts tor once and waits for it to bootstrap.
+func start(ctx context.Context, config StartConfig, bridgeArgs []string) (*Tunnel, error) {
 	logfile := LogFile(config.Sess)
 	extraArgs := append([]string{}, config.Sess.TorArgs()...)
+	extraArgs = append(extraArgs, bridgeArgs...)
 	extraArgs = append(extraArgs, "Log")
 	extraArgs = append(extraArgs, "notice stderr")
 	extraArgs = append(extraArgs, "Log")
 	extraArgs = append(extraArgs, fmt.Sprintf(`notice file %s`, logfile))
+	datadir := config.DataDir
+	if datadir == "" {
+		datadir = path.Join(config.Sess.TempDir(), "tor")
+	}
 	instance, err := config.Start(ctx, &tor.StartConf{
-		DataDir:   path.Join(config.Sess.TempDir(), "tor"),
+		DataDir:   datadir,
 		ExtraArgs: extraArgs,
 		ExePath:   config.Sess.TorBinary(),
 		NoHush:    true,
@@ -96,6 +269,14 @@ func StartWithConfig(ctx context.Context, config StartConfig) (*Tunnel, error) {
 		return nil, err
 	}
 	instance.StopProcessOnClose = true
+	if config.Progress != nil {
+		stopWatching, err := watchBootstrap(instance.Control, config.Progress)
+		if err != nil {
+			instance.Close()
+			return nil, err
+		}
+		defer stopWatching()
+	}
 	start := time.Now()
 	if err := config.EnableNetwork(ctx, instance, true); err != nil {
 		instance.Close()
@@ -124,6 +305,104 @@ func StartWithConfig(ctx context.Context, config StartConfig) (*Tunnel, error) {
 	}, nil
 }
 
+// watchBootstrap calls progress for each bootstrap status event until
+// the returned function is called. We buffer the events because the
+// control connection blocks when delivering an event to a channel, and
+// we deliver the buffered events before returning from such function.
+func watchBootstrap(ctrl *control.Conn, progress func(BootstrapProgress)) (func(), error) {
+	events := make(chan control.Event, 128)
+	if err := ctrl.AddEventListener(events, control.EventCodeStatusClient); err != nil {
+		return nil, err
+	}
+	handle := func(evt control.Event) {
+		status, _ := evt.(*control.StatusEvent)
+		if status == nil || status.Action != "BOOTSTRAP" {
+			return
+		}
+		// bine does not handle quoted values containing spaces
+		arguments := parseStatusArguments(status.Raw)
+		value, err := strconv.Atoi(arguments["PROGRESS"])
+		if err != nil {
+			return
+		}
+		progress(BootstrapProgress{
+			Progress: value,
+			Summary:  arguments["SUMMARY"],
+			Tag:      arguments["TAG"],
+		})
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case evt := <-events:
+				handle(evt)
+			case <-done:
+				for {
+					select {
+					case evt := <-events:
+						handle(evt)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		ctrl.RemoveEventListener(events, control.EventCodeStatusClient)
+		close(done)
+		<-stopped
+	}, nil
+}
+
+// parseStatusArguments parses the KEY=VALUE arguments of a raw status
+// event, where VALUE may be a quoted string (see control-spec.txt).
+func parseStatusArguments(raw string) map[string]string {
+	out := make(map[string]string)
+	for {
+		raw = strings.TrimLeft(raw, " ")
+		end := strings.IndexAny(raw, " =")
+		if end < 0 {
+			return out
+		}
+		if raw[end] == ' ' {
+			raw = raw[end:] // not an argument (e.g. the severity)
+			continue
+		}
+		key, value := raw[:end], new(strings.Builder)
+		raw = raw[end+1:]
+		if !strings.HasPrefix(raw, `"`) {
+			end = strings.IndexByte(raw, ' ')
+			if end < 0 {
+				end = len(raw)
+			}
+			out[key], raw = raw[:end], raw[end:]
+			continue
+		}
+		idx, escaping := 1, false
+		for ; idx < len(raw); idx++ {
+			if escaping {
+				value.WriteByte(raw[idx])
+				escaping = false
+			} else if raw[idx] == '\\' {
+				escaping = true
+			} else if raw[idx] == '"' {
+				break
+			} else {
+				value.WriteByte(raw[idx])
+			}
+		}
+		out[key] = value.String()
+		if idx < len(raw) {
+			idx++
+		}
+		raw = raw[idx:]
+	}
+}
+
 // LogFile returns the name of tor logs given a specific session. The file
 // is always located somewhere inside the sess.TempDir() directory.
 func LogFile(sess model.ExperimentSession) string {
@@ -1,8 +1,15 @@
 package torx
 
 import (
+	"bufio"
+	"net"
+	"net/textproto"
 	"net/url"
+	"strings"
+	"testing"
 	"time"
+
+	"github.com/cretz/bine/control"
 )
 
 func NewTunnel(bootstrapTime time.Duration, instance TorProcess, proxy *url.URL) *Tunnel {
@@ -12,3 +19,62 @@ func NewTunnel(bootstrapTime time.Duration, instance TorProcess, proxy *url.URL)
 		proxy:         proxy,
 	}
 }
+
+func TestWatchBootstrap(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctrl := control.NewConn(textproto.NewConn(client))
+	subscribed := make(chan struct{})
+	go func() {
+		reader := bufio.NewReader(server)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			server.Write([]byte("250 OK\r\n"))
+			if strings.HasPrefix(line, "SETEVENTS STATUS_CLIENT") {
+				close(subscribed)
+				server.Write([]byte(
+					"650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=10 TAG=conn_done SUMMARY=\"Connected to a relay\"\r\n" +
+						"650 STATUS_CLIENT NOTICE CIRCUIT_ESTABLISHED\r\n" +
+						"650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY=\"Done\"\r\n"))
+			}
+		}
+	}()
+	var got []BootstrapProgress
+	stop, err := watchBootstrap(ctrl, func(progress BootstrapProgress) {
+		got = append(got, progress)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-subscribed
+	for i := 0; i < 3; i++ {
+		if err := ctrl.HandleNextEvent(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop() // delivers the buffered events
+	if len(got) != 2 {
+		t.Fatal("unexpected number of events", len(got))
+	}
+	if got[0].Progress != 10 || got[0].Tag != "conn_done" || got[0].Summary != "Connected to a relay" {
+		t.Fatal("unexpected first event", got[0])
+	}
+	if got[1].Progress != 100 || got[1].Tag != "done" {
+		t.Fatal("unexpected second event", got[1])
+	}
+}
+
+func TestParseStatusArguments(t *testing.T) {
+	arguments := parseStatusArguments(
+		`NOTICE BOOTSTRAP PROGRESS=5 TAG=conn SUMMARY="Say \"hello\"" COUNT=1 LAST`)
+	if len(arguments) != 4 || arguments["PROGRESS"] != "5" || arguments["TAG"] != "conn" {
+		t.Fatal("unexpected arguments", arguments)
+	}
+	if arguments["SUMMARY"] != `Say "hello"` || arguments["COUNT"] != "1" {
+		t.Fatal("unexpected arguments", arguments)
+	}
+}
@@ -3,13 +3,21 @@ package torx_test
 import (
 	"context"
 	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/apex/log"
 	"github.com/cretz/bine/control"
 	"github.com/cretz/bine/tor"
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/internal/torx"
+	"github.com/ooni/probe-engine/model"
+	"github.com/pion/stun"
 )
 
 type Closer struct {
@@ -45,6 +53,12 @@ func TestTunnelNil(t *testing.T) {
 	if tun.SOCKS5ProxyURL() != nil {
 		t.Fatal("not the url we expected")
 	}
+	if tun.Bridge() != "" || tun.BridgeAttempts() != nil {
+		t.Fatal("not the bridge we expected")
+	}
+	if tun.SnowflakeDiagnostics() != nil {
+		t.Fatal("not the diagnostics we expected")
+	}
 	tun.Stop() // ensure we don't crash
 }
 
@@ -207,3 +221,235 @@ func TestStartWithConfigSuccess(t *testing.T) {
 		t.Fatal("expected nil tunnel here")
 	}
 }
+
+func TestBridgeLine(t *testing.T) {
+	line, err := torx.BridgeLine(model.TorTarget{
+		Address: "192.95.36.142:443",
+		Params: map[string][]string{
+			"iat-mode": {"1"},
+			"cert":     {"qUVQ0srL1JI/vO6V6m/24anYXiJD3QP2HgzUKQtQ7GRqqUvs7P+tG43RtAqdhLOALP7DJQ"},
+		},
+		Protocol: "obfs4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "obfs4 192.95.36.142:443 cert=qUVQ0srL1JI/vO6V6m/24anYXiJD3QP2HgzUKQtQ7GRqqUvs7P+tG43RtAqdhLOALP7DJQ iat-mode=1"
+	if line != expected {
+		t.Fatal("unexpected bridge line", line)
+	}
+	if _, err := torx.BridgeLine(model.TorTarget{
+		Address: "1.1.1.1:443", Protocol: "or_port",
+	}); !errors.Is(err, torx.ErrUnsupportedBridge) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestBridgeLines(t *testing.T) {
+	lines := torx.BridgeLines(map[string]model.TorTarget{
+		"b": {Address: "10.0.0.2:443", Protocol: "obfs4"},
+		"a": {Address: "10.0.0.1:443", Protocol: "obfs4"},
+		"c": {Address: "10.0.0.3:443", Protocol: "or_port"},
+	})
+	if len(lines) != 2 || lines[0] != "obfs4 10.0.0.1:443" || lines[1] != "obfs4 10.0.0.2:443" {
+		t.Fatal("unexpected bridge lines", lines)
+	}
+}
+
+func TestStartWithConfigInvalidBridge(t *testing.T) {
+	for _, line := range []string{"", "meek_lite 10.0.0.1:443", "obfs4 antani", "Bridge"} {
+		tun, err := torx.StartWithConfig(context.Background(), torx.StartConfig{
+			Bridges: []string{line},
+			Sess:    &mockable.ExperimentSession{},
+		})
+		if !errors.Is(err, torx.ErrUnsupportedBridge) {
+			t.Fatal("not the error we expected", line)
+		}
+		if tun != nil {
+			t.Fatal("expected nil tunnel here")
+		}
+	}
+}
+
+// bridgesConfig returns a config where tor bootstraps only using the
+// bridge named good and saves the extra args of every start.
+func bridgesConfig(good string, args *[][]string) torx.StartConfig {
+	var bridge string
+	return torx.StartConfig{
+		Sess: &mockable.ExperimentSession{
+			MockableLogger:  log.Log,
+			MockableTempDir: "testdata",
+		},
+		Start: func(ctx context.Context, conf *tor.StartConf) (*tor.Tor, error) {
+			*args = append(*args, conf.ExtraArgs)
+			for idx, arg := range conf.ExtraArgs {
+				if arg == "Bridge" {
+					bridge = conf.ExtraArgs[idx+1]
+				}
+			}
+			return &tor.Tor{}, nil
+		},
+		EnableNetwork: func(ctx context.Context, tor *tor.Tor, wait bool) error {
+			if bridge != good {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		},
+		GetInfo: func(ctrl *control.Conn, keys ...string) ([]*control.KeyVal, error) {
+			return []*control.KeyVal{{Key: "net/listeners/socks", Val: "127.0.0.1:9050"}}, nil
+		},
+	}
+}
+
+func TestStartWithConfigBridges(t *testing.T) {
+	var args [][]string
+	config := bridgesConfig("obfs4 10.0.0.2:443 cert=xyz iat-mode=0", &args)
+	config.Bridges = []string{
+		"obfs4 10.0.0.1:443 cert=abc iat-mode=0",
+		"Bridge obfs4 10.0.0.2:443 cert=xyz iat-mode=0",
+	}
+	config.BridgeTimeout = 10 * time.Millisecond
+	tun, err := torx.StartWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Stop()
+	if tun.Bridge() != "obfs4 10.0.0.2:443 cert=xyz iat-mode=0" {
+		t.Fatal("not the bridge we expected")
+	}
+	attempts := tun.BridgeAttempts()
+	if len(attempts) != 2 {
+		t.Fatal("unexpected number of attempts")
+	}
+	if !errors.Is(attempts[0].Err, context.DeadlineExceeded) || attempts[1].Err != nil {
+		t.Fatal("unexpected attempts errors")
+	}
+	if attempts[1].BootstrapTime != tun.BootstrapTime() {
+		t.Fatal("unexpected bootstrap time")
+	}
+	if len(args) != 2 || args[0][0] != "UseBridges" || args[0][2] != "ClientTransportPlugin" {
+		t.Fatal("unexpected tor args")
+	}
+	if !strings.HasPrefix(args[0][3], "obfs4 socks5 127.0.0.1:") {
+		t.Fatal("not using the embedded obfs4 transport")
+	}
+}
+
+func TestStartWithConfigBridgesWithOBFS4Proxy(t *testing.T) {
+	var args [][]string
+	config := bridgesConfig("obfs4 10.0.0.1:443", &args)
+	config.Bridges = []string{"obfs4 10.0.0.1:443"}
+	config.OBFS4ProxyPath = "/usr/bin/obfs4proxy"
+	tun, err := torx.StartWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Stop()
+	if len(args) != 1 || args[0][3] != "obfs4 exec /usr/bin/obfs4proxy" {
+		t.Fatal("unexpected tor args")
+	}
+}
+
+func TestStartWithConfigAllBridgesFailed(t *testing.T) {
+	var args [][]string
+	config := bridgesConfig("", &args)
+	config.Bridges = []string{"obfs4 10.0.0.1:443", "obfs4 10.0.0.2:443"}
+	config.BridgeTimeout = 10 * time.Millisecond
+	tun, err := torx.StartWithConfig(context.Background(), config)
+	if !errors.Is(err, torx.ErrAllBridgesFailed) {
+		t.Fatal("not the error we expected")
+	}
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+	if len(args) != 2 {
+		t.Fatal("unexpected number of attempts")
+	}
+}
+
+// listenSTUN starts a STUN server that replies to binding requests.
+func listenSTUN(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: buffer[:count]}
+			if err := request.Decode(); err != nil {
+				continue
+			}
+			udpAddr := addr.(*net.UDPAddr)
+			response := stun.MustBuild(request, stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port})
+			conn.WriteTo(response.Raw, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestStartWithConfigSnowflake(t *testing.T) {
+	var brokerHost string
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/debug" {
+			brokerHost = r.Host
+			return
+		}
+		w.WriteHeader(404)
+	}))
+	defer broker.Close()
+	var args [][]string
+	config := bridgesConfig(
+		"snowflake 192.0.2.3:1 2B280B23E1107BB62ABFC40DDCC8824814F80A72", &args)
+	config.Snowflake = &torx.SnowflakeConfig{
+		BrokerURL:    "http://snowflake-broker.example.com/",
+		ClientBinary: "/usr/bin/snowflake-client",
+		FrontDomain:  strings.TrimPrefix(broker.URL, "http://"),
+		STUNServers:  []string{"stun:" + listenSTUN(t), "stun:127.0.0.1:1"},
+	}
+	tun, err := torx.StartWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Stop()
+	if brokerHost != "snowflake-broker.example.com" {
+		t.Fatal("we did not use domain fronting")
+	}
+	if len(args) != 1 || !strings.HasPrefix(args[0][3], "snowflake exec /usr/bin/snowflake-client -url ") {
+		t.Fatal("unexpected tor args")
+	}
+	diagnostics := tun.SnowflakeDiagnostics()
+	if diagnostics.Broker.Err != nil || diagnostics.Broker.StatusCode != 200 {
+		t.Fatal("unexpected broker check")
+	}
+	if len(diagnostics.STUN) != 2 {
+		t.Fatal("unexpected number of STUN checks")
+	}
+	if diagnostics.STUN[0].Err != nil || !strings.HasPrefix(diagnostics.STUN[0].MappedAddress, "127.0.0.1:") {
+		t.Fatal("unexpected first STUN check")
+	}
+	if diagnostics.STUN[1].Err == nil {
+		t.Fatal("expected an error for the second STUN server")
+	}
+}
+
+func TestStartWithConfigSnowflakeInvalidBridge(t *testing.T) {
+	tun, err := torx.StartWithConfig(context.Background(), torx.StartConfig{
+		Bridges:   []string{"obfs4 10.0.0.1:443"},
+		Sess:      &mockable.ExperimentSession{},
+		Snowflake: &torx.SnowflakeConfig{},
+	})
+	if !errors.Is(err, torx.ErrUnsupportedBridge) {
+		t.Fatal("not the error we expected")
+	}
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+}
@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/netx"
+)
+
+const (
+	// DefaultHealthCheckURL is the URL we fetch through the tunnel to
+	// check whether it is working when Config.HealthCheckURL is empty.
+	DefaultHealthCheckURL = "https://www.google.com/humans.txt"
+
+	healthCheckTimeout = 30 * time.Second
+)
+
+// bootstrappedTunnel is a tunnel as started by the psiphonx, torx, or
+// socks5 code. We wrap it to implement the health check.
+type bootstrappedTunnel interface {
+	BootstrapTime() time.Duration
+	SOCKS5ProxyURL() *url.URL
+	Stop()
+}
+
+// managedTunnel is the Tunnel returned by Start. It remembers the
+// config, so that it can bootstrap again a tunnel that stopped working.
+type managedTunnel struct {
+	config  Config
+	mu      sync.Mutex
+	stopped bool
+	tun     bootstrappedTunnel
+}
+
+// BootstrapTime returns the time required by the last bootstrap.
+func (t *managedTunnel) BootstrapTime() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tun.BootstrapTime()
+}
+
+// SOCKS5ProxyURL returns the URL of the SOCKS5 proxy. This URL may
+// change when HealthCheck bootstraps again the tunnel.
+func (t *managedTunnel) SOCKS5ProxyURL() *url.URL {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tun.SOCKS5ProxyURL()
+}
+
+// Stop stops the tunnel.
+func (t *managedTunnel) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.stopped {
+		t.tun.Stop()
+		t.stopped = true
+	}
+}
+
+// HealthCheck fetches the health check URL through the tunnel. On
+// failure, it stops the tunnel, bootstraps it again, and repeats the
+// check. It returns nil if the tunnel works, possibly after bootstrapping
+// it again, and the error that occurred otherwise.
+func (t *managedTunnel) HealthCheck(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	logger := t.config.Session.Logger()
+	if !t.stopped {
+		err := t.check(ctx)
+		if err == nil {
+			return nil
+		}
+		logger.Warnf("tunnel: health check failed: %s", err.Error())
+		t.tun.Stop()
+		t.stopped = true
+	}
+	logger.Infof("bootstrapping %s tunnel again; please be patient...", t.config.Name)
+	tun, err := bootstrap(ctx, t.config)
+	if err != nil {
+		return err
+	}
+	t.tun, t.stopped = tun, false
+	return t.check(ctx)
+}
+
+// check fetches the health check URL using the SOCKS5 proxy. Any HTTP
+// response, regardless of the status code, means the tunnel works.
+func (t *managedTunnel) check(ctx context.Context) error {
+	URL := t.config.HealthCheckURL
+	if URL == "" {
+		URL = DefaultHealthCheckURL
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return err
+	}
+	txp := netx.NewHTTPTransport(netx.Config{
+		Logger:   t.config.Session.Logger(),
+		ProxyURL: t.tun.SOCKS5ProxyURL(),
+	})
+	defer txp.CloseIdleConnections()
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	return err
+}
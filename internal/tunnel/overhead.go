@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+)
+
+const (
+	// DefaultOverheadURL is the reference endpoint we fetch to measure
+	// the tunnel overhead when OverheadConfig.URL is empty.
+	DefaultOverheadURL = "https://www.google.com/"
+
+	// maxOverheadBodySize is the maximum number of body bytes we read.
+	maxOverheadBodySize = 1 << 20
+
+	overheadTimeout = 30 * time.Second
+)
+
+// ErrNoProxy indicates that we cannot measure the overhead because
+// we don't know the SOCKS5 proxy URL of the tunnel.
+var ErrNoProxy = errors.New("tunnel: no SOCKS5 proxy URL")
+
+// OverheadConfig configures MeasureOverhead. ProxyURL is the SOCKS5 proxy
+// of the tunnel (see Tunnel.SOCKS5ProxyURL) and URL is the reference
+// endpoint; we use DefaultOverheadURL if empty.
+type OverheadConfig struct {
+	Logger   model.Logger
+	ProxyURL *url.URL
+	URL      string
+}
+
+// PathMeasurement is the result of fetching the reference endpoint
+// either directly or through the tunnel. RTT is the time elapsed until
+// we receive the response headers using a new connection, hence it
+// includes connecting and the TLS handshake. Throughput is the body
+// size divided by the time required to read the body, in bytes per
+// second. Throughput is zero if the body was empty.
+type PathMeasurement struct {
+	BodySize   int64
+	Err        error
+	RTT        time.Duration
+	Throughput float64
+}
+
+// TunnelOverhead compares fetching the reference endpoint directly and
+// through the tunnel. RTTOverhead is the additional RTT caused by the
+// tunnel and ThroughputRatio is the tunnel throughput divided by the
+// direct throughput. These two fields are zero unless both Direct and
+// Tunnel succeeded (and, for ThroughputRatio, have a nonzero throughput).
+type TunnelOverhead struct {
+	Direct          PathMeasurement
+	RTTOverhead     time.Duration
+	ThroughputRatio float64
+	Tunnel          PathMeasurement
+	URL             string
+}
+
+// MeasureOverhead fetches the reference endpoint directly and then using
+// the tunnel and returns the report. Failing to fetch using either path is
+// not an error: it is recorded in the report. We fail only when the config
+// is invalid. We measure the two paths sequentially, so that they do not
+// compete for bandwidth.
+func MeasureOverhead(ctx context.Context, config OverheadConfig) (*TunnelOverhead, error) {
+	if config.ProxyURL == nil {
+		return nil, ErrNoProxy
+	}
+	out := &TunnelOverhead{URL: config.URL}
+	if out.URL == "" {
+		out.URL = DefaultOverheadURL
+	}
+	if _, err := url.Parse(out.URL); err != nil {
+		return nil, err
+	}
+	out.Direct = measurePath(ctx, config.Logger, out.URL, nil)
+	out.Tunnel = measurePath(ctx, config.Logger, out.URL, config.ProxyURL)
+	if out.Direct.Err == nil && out.Tunnel.Err == nil {
+		out.RTTOverhead = out.Tunnel.RTT - out.Direct.RTT
+		if out.Direct.Throughput > 0 {
+			out.ThroughputRatio = out.Tunnel.Throughput / out.Direct.Throughput
+		}
+	}
+	return out, nil
+}
+
+// measurePath fetches URL, using the proxy if not nil.
+func measurePath(
+	ctx context.Context, logger model.Logger, URL string, proxyURL *url.URL) (out PathMeasurement) {
+	ctx, cancel := context.WithTimeout(ctx, overheadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		out.Err = err
+		return
+	}
+	txp := netx.NewHTTPTransport(netx.Config{Logger: logger, ProxyURL: proxyURL})
+	defer txp.CloseIdleConnections()
+	start := time.Now()
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		out.Err = err
+		return
+	}
+	defer resp.Body.Close()
+	out.RTT = time.Since(start)
+	start = time.Now()
+	out.BodySize, out.Err = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxOverheadBodySize))
+	if elapsed := time.Since(start); out.Err == nil && out.BodySize > 0 && elapsed > 0 {
+		out.Throughput = float64(out.BodySize) / elapsed.Seconds()
+	}
+	return
+}
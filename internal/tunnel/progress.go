@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
+	"github.com/ooni/probe-engine/internal/torx"
+)
+
+// psiphonProgress maps psiphon notices to a bootstrap percentage.
+var psiphonProgress = map[string]struct {
+	message    string
+	percentage float64
+}{
+	"CandidateServers": {"psiphon: selected candidate servers", 0.25},
+	"ConnectingServer": {"psiphon: connecting to a server", 0.5},
+	"ConnectedServer":  {"psiphon: connected to a server", 0.75},
+	"Tunnels":          {"psiphon: tunnel established", 1},
+}
+
+// withPsiphonProgress returns a context where the notices emitted by
+// psiphon while bootstrapping update the progress. We still deliver
+// the notices to the handler already in the context, if any (e.g., the
+// handler of the psiphon experiment). We only report increasing
+// percentages, because psiphon may connect to several servers.
+func withPsiphonProgress(ctx context.Context, progress func(float64, string)) context.Context {
+	if progress == nil {
+		return ctx
+	}
+	next := psiphonnotice.ContextHandler(ctx)
+	var (
+		mu   sync.Mutex
+		last float64
+	)
+	return psiphonnotice.WithHandler(ctx, func(notice psiphonnotice.Notice) {
+		if next != nil {
+			next(notice)
+		}
+		info, found := psiphonProgress[notice.Type]
+		if !found {
+			return
+		}
+		if count, _ := notice.Data["count"].(float64); notice.Type == "Tunnels" && count <= 0 {
+			return // psiphon emits Tunnels with zero count when disconnected
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if info.percentage <= last {
+			return
+		}
+		last = info.percentage
+		progress(info.percentage, info.message)
+	})
+}
+
+// torProgress returns a function that maps tor's bootstrap events to
+// the progress, or nil if progress is nil.
+func torProgress(progress func(float64, string)) func(torx.BootstrapProgress) {
+	if progress == nil {
+		return nil
+	}
+	return func(event torx.BootstrapProgress) {
+		progress(float64(event.Progress)/100, "tor: "+event.Summary)
+	}
+}
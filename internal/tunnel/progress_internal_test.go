@@ -0,0 +1,67 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/psiphonnotice"
+	"github.com/ooni/probe-engine/internal/torx"
+)
+
+func TestWithPsiphonProgress(t *testing.T) {
+	var notices int
+	ctx := psiphonnotice.WithHandler(context.Background(), func(psiphonnotice.Notice) {
+		notices++
+	})
+	var percentages []float64
+	ctx = withPsiphonProgress(ctx, func(percentage float64, message string) {
+		percentages = append(percentages, percentage)
+	})
+	handler := psiphonnotice.ContextHandler(ctx)
+	for _, notice := range []psiphonnotice.Notice{
+		{Type: "CandidateServers"},
+		{Type: "Info"},
+		{Type: "ConnectingServer"},
+		{Type: "ConnectedServer"},
+		{Type: "ConnectingServer"},
+		{Type: "Tunnels", Data: map[string]interface{}{"count": float64(0)}},
+		{Type: "Tunnels", Data: map[string]interface{}{"count": float64(1)}},
+	} {
+		handler(notice)
+	}
+	if notices != 7 {
+		t.Fatal("we did not deliver all notices to the previous handler")
+	}
+	expect := []float64{0.25, 0.5, 0.75, 1}
+	if len(percentages) != len(expect) {
+		t.Fatal("unexpected number of progress events", percentages)
+	}
+	for idx, value := range expect {
+		if percentages[idx] != value {
+			t.Fatal("unexpected progress", percentages)
+		}
+	}
+}
+
+func TestWithPsiphonProgressNil(t *testing.T) {
+	ctx := context.Background()
+	if withPsiphonProgress(ctx, nil) != ctx {
+		t.Fatal("expected the original context")
+	}
+}
+
+func TestTorProgress(t *testing.T) {
+	if torProgress(nil) != nil {
+		t.Fatal("expected nil here")
+	}
+	var (
+		percentage float64
+		message    string
+	)
+	torProgress(func(p float64, m string) {
+		percentage, message = p, m
+	})(torx.BootstrapProgress{Progress: 50, Summary: "Loading relay descriptors"})
+	if percentage != 0.5 || message != "tor: Loading relay descriptors" {
+		t.Fatal("unexpected progress", percentage, message)
+	}
+}
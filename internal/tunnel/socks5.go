@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// ErrSOCKS5Handshake indicates that the proxy did not accept
+// our SOCKS5 greeting without authentication.
+var ErrSOCKS5Handshake = errors.New("tunnel: SOCKS5 handshake failed")
+
+// socks5Tunnel is a tunnel using an already running SOCKS5 proxy, e.g.,
+// the tor daemon installed on the system or another circumvention tool.
+type socks5Tunnel struct {
+	bootstrapTime time.Duration
+	proxy         *url.URL
+}
+
+// startSOCKS5 checks whether the proxy at the given URL is running
+// and speaks SOCKS5 and returns a tunnel using such proxy.
+func startSOCKS5(ctx context.Context, proxyURL string) (*socks5Tunnel, error) {
+	URL, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if URL.Scheme != "socks5" || URL.Port() == "" {
+		return nil, errors.New("tunnel: invalid SOCKS5 proxy URL")
+	}
+	start := time.Now()
+	if err := socks5Greeting(ctx, URL.Host); err != nil {
+		return nil, err
+	}
+	return &socks5Tunnel{
+		bootstrapTime: time.Since(start),
+		proxy:         &url.URL{Scheme: URL.Scheme, Host: URL.Host},
+	}, nil
+}
+
+// socks5Greeting connects to address and performs the SOCKS5 method
+// selection (see RFC1928 Sect. 3) asking for no authentication.
+func socks5Greeting(ctx context.Context, address string) error {
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if !bytes.Equal(reply, []byte{5, 0}) {
+		return ErrSOCKS5Handshake
+	}
+	return nil
+}
+
+// BootstrapTime returns the time required to check the proxy.
+func (t *socks5Tunnel) BootstrapTime() time.Duration {
+	return t.bootstrapTime
+}
+
+// SOCKS5ProxyURL returns the URL of the SOCKS5 proxy.
+func (t *socks5Tunnel) SOCKS5ProxyURL() *url.URL {
+	return t.proxy
+}
+
+// Stop does nothing because we do not own the proxy.
+func (t *socks5Tunnel) Stop() {}
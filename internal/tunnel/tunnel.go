@@ -0,0 +1,113 @@
+// Package tunnel contains the tunnels that the session may use to
+// communicate with the OONI backend when it is blocked. Every tunnel
+// exposes a local SOCKS5 proxy that the session uses for all its
+// backend traffic.
+//
+// We support these tunnels: "psiphon", which uses psiphon-tunnel-core;
+// "tor", which starts the tor binary configured in the session (or
+// the tor binary in PATH) using its control port, hence no stem is
+// required, and optionally uses obfs4 bridges; "snowflake", which is like
+// "tor" but uses the snowflake pluggable transport, hence it requires the
+// snowflake-client binary configured in Snowflake (or in PATH); and "socks5://<host>:<port>", which uses an already running
+// SOCKS5 proxy, e.g., the tor daemon installed on the system.
+//
+// Long-lived tunnels may stop working, e.g., because the network
+// changed. Call HealthCheck periodically to detect that and to
+// bootstrap again the tunnel when needed. Use MeasureOverhead to
+// compare the performance of the tunnel with the direct path.
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/psiphonx"
+	"github.com/ooni/probe-engine/internal/torx"
+	"github.com/ooni/probe-engine/model"
+)
+
+// Tunnel is a tunnel used by the session. HealthCheck checks whether
+// the tunnel works and bootstraps it again otherwise, in which case the
+// SOCKS5ProxyURL may change.
+type Tunnel interface {
+	BootstrapTime() time.Duration
+	HealthCheck(ctx context.Context) error
+	SOCKS5ProxyURL() *url.URL
+	Stop()
+}
+
+// Config contains config for the session tunnel. TorBridges contains
+// the obfs4 bridge lines used by the "tor" tunnel (see torx.BridgeLine)
+// and OBFS4ProxyBinary is the obfs4proxy binary to use with them. When
+// OBFS4ProxyBinary is empty, we use the embedded obfs4 transport.
+// Snowflake configures the "snowflake" tunnel. HealthCheckURL is the
+// URL fetched by HealthCheck; we use DefaultHealthCheckURL if empty. When
+// Progress is not nil, we call it with the bootstrap percentage (between
+// zero and one) and a message while bootstrapping tor or psiphon.
+type Config struct {
+	HealthCheckURL   string
+	Name             string
+	OBFS4ProxyBinary string
+	Progress         func(percentage float64, message string)
+	Session          model.ExperimentSession
+	Snowflake        torx.SnowflakeConfig
+	TorBridges       []string
+}
+
+// Start starts a new tunnel by name or returns an error. Note that if you
+// pass to this function the "" tunnel, you get back nil, nil.
+func Start(ctx context.Context, config Config) (Tunnel, error) {
+	tun, err := bootstrap(ctx, config)
+	if err != nil || tun == nil {
+		return nil, err
+	}
+	return &managedTunnel{config: config, tun: tun}, nil
+}
+
+// bootstrap starts the tunnel by name. Like Start, it returns nil, nil
+// when the tunnel name is "".
+func bootstrap(ctx context.Context, config Config) (bootstrappedTunnel, error) {
+	logger := config.Session.Logger()
+	switch config.Name {
+	case "":
+		logger.Debugf("no tunnel has been requested")
+		return enforceNilContract(nil, nil)
+	case "psiphon":
+		logger.Infof("starting %s tunnel; please be patient...", config.Name)
+		ctx = withPsiphonProgress(ctx, config.Progress)
+		tun, err := psiphonx.Start(ctx, config.Session, psiphonx.Config{})
+		return enforceNilContract(tun, err)
+	case "snowflake":
+		logger.Infof("starting %s tunnel; please be patient...", config.Name)
+		startConfig := torx.NewStartConfig(config.Session)
+		startConfig.Progress = torProgress(config.Progress)
+		startConfig.Snowflake = &config.Snowflake
+		tun, err := torx.StartWithConfig(ctx, startConfig)
+		return enforceNilContract(tun, err)
+	case "tor":
+		logger.Infof("starting %s tunnel; please be patient...", config.Name)
+		startConfig := torx.NewStartConfig(config.Session)
+		startConfig.Bridges = config.TorBridges
+		startConfig.OBFS4ProxyPath = config.OBFS4ProxyBinary
+		startConfig.Progress = torProgress(config.Progress)
+		tun, err := torx.StartWithConfig(ctx, startConfig)
+		return enforceNilContract(tun, err)
+	default:
+		if strings.HasPrefix(config.Name, "socks5://") {
+			logger.Infof("checking SOCKS5 proxy %s...", config.Name)
+			tun, err := startSOCKS5(ctx, config.Name)
+			return enforceNilContract(tun, err)
+		}
+		return nil, errors.New("unsupported tunnel")
+	}
+}
+
+func enforceNilContract(tun bootstrappedTunnel, err error) (bootstrappedTunnel, error) {
+	if err != nil {
+		return nil, err
+	}
+	return tun, nil
+}
@@ -0,0 +1,380 @@
+package tunnel_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/tunnel"
+)
+
+func TestNoTunnel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tun, err := tunnel.Start(ctx, tunnel.Config{
+		Name: "",
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+}
+
+func TestPsiphonTunnel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tun, err := tunnel.Start(ctx, tunnel.Config{
+		Name: "psiphon",
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+}
+
+func TestTorTunnel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tun, err := tunnel.Start(ctx, tunnel.Config{
+		Name: "tor",
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+}
+
+func TestInvalidTunnel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tun, err := tunnel.Start(ctx, tunnel.Config{
+		Name: "antani",
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if err == nil || err.Error() != "unsupported tunnel" {
+		t.Fatal("not the error we expected")
+	}
+	t.Log(tun)
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+}
+
+// listenSOCKS5 starts a fake SOCKS5 proxy that replies to the greeting
+// with reply and returns the proxy URL.
+func listenSOCKS5(t *testing.T, reply []byte) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			greeting := make([]byte, 3)
+			if _, err := io.ReadFull(conn, greeting); err == nil {
+				conn.Write(reply)
+			}
+			conn.Close()
+		}
+	}()
+	return "socks5://" + listener.Addr().String()
+}
+
+func TestSOCKS5Tunnel(t *testing.T) {
+	proxyURL := listenSOCKS5(t, []byte{5, 0})
+	tun, err := tunnel.Start(context.Background(), tunnel.Config{
+		Name: proxyURL,
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Stop()
+	if tun.SOCKS5ProxyURL().String() != proxyURL {
+		t.Fatal("not the proxy URL we expected")
+	}
+	if tun.BootstrapTime() <= 0 {
+		t.Fatal("expected positive bootstrap time")
+	}
+}
+
+func TestSOCKS5TunnelHandshakeFailure(t *testing.T) {
+	proxyURL := listenSOCKS5(t, []byte{5, 0xff})
+	tun, err := tunnel.Start(context.Background(), tunnel.Config{
+		Name: proxyURL,
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if !errors.Is(err, tunnel.ErrSOCKS5Handshake) {
+		t.Fatal("not the error we expected")
+	}
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+}
+
+func TestSOCKS5TunnelInvalidURL(t *testing.T) {
+	tun, err := tunnel.Start(context.Background(), tunnel.Config{
+		Name: "socks5://127.0.0.1",
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if err == nil || err.Error() != "tunnel: invalid SOCKS5 proxy URL" {
+		t.Fatal("not the error we expected")
+	}
+	if tun != nil {
+		t.Fatal("expected nil tunnel here")
+	}
+}
+
+// proxySOCKS5 starts a fake SOCKS5 proxy that implements CONNECT and
+// returns the proxy URL and the number of accepted connections. The
+// proxy refuses the first refused CONNECT requests.
+func proxySOCKS5(t *testing.T, refused int64) (string, *int64) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var conns, connects int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&conns, 1)
+			go func() {
+				defer conn.Close()
+				address, err := readSOCKS5Request(conn)
+				if err != nil {
+					return
+				}
+				if atomic.AddInt64(&connects, 1) <= refused {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				remote, err := net.Dial("tcp", address)
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(remote, conn)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+	return "socks5://" + listener.Addr().String(), &conns
+}
+
+// readSOCKS5Request performs the greeting and reads the CONNECT
+// request, returning the target address. The greeting-only connections
+// used by Start to check the proxy fail with io.EOF.
+func readSOCKS5Request(conn net.Conn) (string, error) {
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	var host string
+	switch header[3] {
+	case 1, 4:
+		size := 4
+		if header[3] == 4 {
+			size = 16
+		}
+		addr := make([]byte, size)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func startSOCKS5ForHealthCheck(t *testing.T, refused int64) (tunnel.Tunnel, *int64) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404) // any response means the tunnel works
+		}))
+	t.Cleanup(server.Close)
+	proxyURL, conns := proxySOCKS5(t, refused)
+	tun, err := tunnel.Start(context.Background(), tunnel.Config{
+		HealthCheckURL: server.URL,
+		Name:           proxyURL,
+		Session: &mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tun.Stop)
+	return tun, conns
+}
+
+func TestHealthCheckSuccess(t *testing.T) {
+	tun, conns := startSOCKS5ForHealthCheck(t, 0)
+	if err := tun.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt64(conns); count != 2 {
+		t.Fatal("unexpected number of connections", count)
+	}
+}
+
+func TestHealthCheckBootstrapsAgain(t *testing.T) {
+	tun, conns := startSOCKS5ForHealthCheck(t, 1)
+	if err := tun.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// start, failed check, bootstrap again, successful check
+	if count := atomic.LoadInt64(conns); count != 4 {
+		t.Fatal("unexpected number of connections", count)
+	}
+	if tun.BootstrapTime() <= 0 {
+		t.Fatal("expected positive bootstrap time")
+	}
+}
+
+func TestHealthCheckFailure(t *testing.T) {
+	tun, _ := startSOCKS5ForHealthCheck(t, 2)
+	if err := tun.HealthCheck(context.Background()); err == nil {
+		t.Fatal("expected an error here")
+	}
+	// the next check starts again from a freshly bootstrapped tunnel
+	if err := tun.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMeasureOverhead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(make([]byte, 65536))
+		}))
+	defer server.Close()
+	proxyURL, conns := proxySOCKS5(t, 0)
+	URL, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := tunnel.MeasureOverhead(context.Background(), tunnel.OverheadConfig{
+		Logger:   log.Log,
+		ProxyURL: URL,
+		URL:      server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Direct.Err != nil || report.Tunnel.Err != nil {
+		t.Fatal("unexpected failure")
+	}
+	if report.Direct.BodySize != 65536 || report.Tunnel.BodySize != 65536 {
+		t.Fatal("unexpected body size")
+	}
+	if report.Direct.RTT <= 0 || report.Tunnel.RTT <= 0 {
+		t.Fatal("expected positive RTTs")
+	}
+	if report.ThroughputRatio <= 0 {
+		t.Fatal("expected positive throughput ratio")
+	}
+	if atomic.LoadInt64(conns) != 1 {
+		t.Fatal("we did not use the tunnel only once")
+	}
+}
+
+func TestMeasureOverheadTunnelFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	proxyURL, _ := proxySOCKS5(t, 1)
+	URL, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := tunnel.MeasureOverhead(context.Background(), tunnel.OverheadConfig{
+		Logger:   log.Log,
+		ProxyURL: URL,
+		URL:      server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Direct.Err != nil || report.Tunnel.Err == nil {
+		t.Fatal("unexpected results")
+	}
+	if report.RTTOverhead != 0 || report.ThroughputRatio != 0 {
+		t.Fatal("expected no comparison results")
+	}
+}
+
+func TestMeasureOverheadNoProxy(t *testing.T) {
+	report, err := tunnel.MeasureOverhead(context.Background(), tunnel.OverheadConfig{
+		Logger: log.Log,
+	})
+	if !errors.Is(err, tunnel.ErrNoProxy) {
+		t.Fatal("not the error we expected")
+	}
+	if report != nil {
+		t.Fatal("expected nil report here")
+	}
+}
@@ -1,10 +1,13 @@
 package engine
 
 import (
-	"bytes"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/rogpeppe/go-internal/lockedfile"
 )
 
@@ -12,18 +15,28 @@ import (
 // probe-engine should supply an implementation of this interface,
 // which will be used by probe-engine to store specific data.
 type KVStore interface {
+	Delete(key string) (err error)
 	Get(key string) (value []byte, err error)
+	List(prefix string) (keys []string, err error)
 	Set(key string, value []byte) (err error)
 }
 
-// FileSystemKVStore is a directory based KVStore
+// fileSystemKVStoreDir is the directory, inside the base directory, where
+// FileSystemKVStore keeps the lock files and the temporary files.
+const fileSystemKVStoreDir = ".kvstore"
+
+// FileSystemKVStore is a directory based KVStore. It is safe to use the
+// same directory from several processes (e.g., the CLI and a daemon). We
+// serialize the accesses to each key using an advisory lock, and we write
+// a new value into a temporary file that we rename into place, so that a
+// crash while writing does not corrupt the previous value.
 type FileSystemKVStore struct {
 	basedir string
 }
 
 // NewFileSystemKVStore creates a new FileSystemKVStore.
 func NewFileSystemKVStore(basedir string) (kvs *FileSystemKVStore, err error) {
-	if err = os.MkdirAll(basedir, 0700); err == nil {
+	if err = os.MkdirAll(filepath.Join(basedir, fileSystemKVStoreDir), 0700); err == nil {
 		kvs = &FileSystemKVStore{basedir: basedir}
 	}
 	return
@@ -33,12 +46,117 @@ func (kvs *FileSystemKVStore) filename(key string) string {
 	return filepath.Join(kvs.basedir, key)
 }
 
+// lock acquires the advisory lock protecting key.
+func (kvs *FileSystemKVStore) lock(key string) (func(), error) {
+	path := filepath.Join(kvs.basedir, fileSystemKVStoreDir, key+".lock")
+	return lockedfile.MutexAt(path).Lock()
+}
+
+// Delete removes the specified key, if it exists
+func (kvs *FileSystemKVStore) Delete(key string) error {
+	unlock, err := kvs.lock(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = os.Remove(kvs.filename(key))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
 // Get returns the specified key's value
 func (kvs *FileSystemKVStore) Get(key string) ([]byte, error) {
-	return lockedfile.Read(kvs.filename(key))
+	unlock, err := kvs.lock(key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return ioutil.ReadFile(kvs.filename(key))
+}
+
+// List returns the sorted keys starting with prefix
+func (kvs *FileSystemKVStore) List(prefix string) ([]string, error) {
+	entries, err := ioutil.ReadDir(kvs.basedir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.HasPrefix(entry.Name(), prefix) {
+			keys = append(keys, entry.Name())
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
 }
 
 // Set sets the value of a specific key
 func (kvs *FileSystemKVStore) Set(key string, value []byte) error {
-	return lockedfile.Write(kvs.filename(key), bytes.NewReader(value), 0600)
+	unlock, err := kvs.lock(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return kvs.write(key, value)
+}
+
+// UpdateKey atomically replaces the value of key with the value returned
+// by fn, which receives the current value, or the error that occurred
+// reading it. Other processes cannot access key while fn runs. If fn
+// returns an error, we do not modify key and return such error.
+func (kvs *FileSystemKVStore) UpdateKey(
+	key string, fn func(value []byte, err error) ([]byte, error)) error {
+	unlock, err := kvs.lock(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	value, err := fn(ioutil.ReadFile(kvs.filename(key)))
+	if err != nil {
+		return err
+	}
+	return kvs.write(key, value)
+}
+
+// write writes value into a temporary file that it then renames to the
+// file of key. The caller must hold the lock of key.
+func (kvs *FileSystemKVStore) write(key string, value []byte) error {
+	filep, err := ioutil.TempFile(filepath.Join(kvs.basedir, fileSystemKVStoreDir), "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filep.Name()) // fails after a successful rename
+	if _, err := filep.Write(value); err != nil {
+		filep.Close()
+		return err
+	}
+	if err := filep.Sync(); err != nil {
+		filep.Close()
+		return err
+	}
+	if err := filep.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filep.Name(), kvs.filename(key))
+}
+
+// BoltKVStore is a KVStore backed by a bbolt database, which supports
+// transactions (see its Update and View methods). Only a single process
+// at a time can open the database, while the others wait for the lock
+// for a few seconds and then fail.
+type BoltKVStore = kvstore.BoltKeyValueStore
+
+// NewBoltKVStore opens or creates the bbolt database at path. You
+// should call Close when done using the key-value store.
+func NewBoltKVStore(path string) (*BoltKVStore, error) {
+	return kvstore.NewBoltKeyValueStore(path)
+}
+
+// closeBoltKVStore closes kvs, if not nil.
+func closeBoltKVStore(kvs *BoltKVStore) {
+	if kvs != nil {
+		kvs.Close()
+	}
 }
@@ -2,7 +2,10 @@ package engine
 
 import (
 	"bytes"
+	"errors"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -29,3 +32,95 @@ func TestKVStoreIntegration(t *testing.T) {
 		t.Fatal("invalid value")
 	}
 }
+
+func TestKVStoreList(t *testing.T) {
+	kvstore, err := NewFileSystemKVStore(
+		filepath.Join("testdata", "kvstore3"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"queue.2", "cache.a", "queue.1"} {
+		if err := kvstore.Set(key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := kvstore.List("queue.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "queue.1" || keys[1] != "queue.2" {
+		t.Fatal("unexpected keys", keys)
+	}
+}
+
+func TestKVStoreDelete(t *testing.T) {
+	kvstore, err := NewFileSystemKVStore(
+		filepath.Join("testdata", "kvstore3"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvstore.Set("antani", []byte("foobar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvstore.Delete("antani"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvstore.Get("antani"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := kvstore.Delete("antani"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKVStoreUpdateKey(t *testing.T) {
+	kvstore, err := NewFileSystemKVStore(
+		filepath.Join("testdata", "kvstore3"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvstore.Delete("counter")
+	increment := func(value []byte, err error) ([]byte, error) {
+		if err != nil {
+			return []byte("1"), nil
+		}
+		return append(value, '1'), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := kvstore.UpdateKey("counter", increment); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	value, err := kvstore.Get("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "11111111" {
+		t.Fatal("we lost some updates", string(value))
+	}
+	expected := errors.New("mocked error")
+	err = kvstore.UpdateKey("counter", func([]byte, error) ([]byte, error) {
+		return nil, expected
+	})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	keys, err := kvstore.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, "tmp-") || strings.HasSuffix(key, ".lock") {
+			t.Fatal("unexpected key", key)
+		}
+	}
+}
@@ -3,7 +3,9 @@
 // The input of this package is data generated by netx and the
 // output is a format consistent with OONI specs.
 //
-// Deprecated by the archival package.
+// Deprecated by the archival package. The tor experiment still uses this
+// package, because it measures using legacy/oonitemplates, which emits the
+// events of legacy/netx, and urlgetter cannot connect using obfs4 yet.
 package oonidatamodel
 
 import (
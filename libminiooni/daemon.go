@@ -0,0 +1,320 @@
+package libminiooni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	engine "github.com/ooni/probe-engine"
+	"github.com/ooni/probe-engine/measurementdb"
+)
+
+// daemonCommand is the pseudo experiment name that keeps miniooni running
+// and controlled using a small HTTP API exposed over a unix socket, e.g.,
+// when running miniooni as a systemd service. The API is:
+//
+//     POST /run     starts a run, e.g., {"name": "web_connectivity", "inputs": [...]}
+//     POST /stop    stops the run in progress
+//     GET  /status  returns whether we are running and the session summary
+//     POST /flush   submits the measurements we have queued
+//
+// For example, `curl --unix-socket ~/.miniooni/miniooni.sock http://miniooni/status`.
+// With --metrics-listen ADDRESS, we also serve Prometheus metrics describing
+// the runs at http://ADDRESS/metrics (see the metrics package). With --listen
+// ADDRESS, we also serve the web UI of the serve command at http://ADDRESS/,
+// since the serve command cannot open the database while we are running.
+const daemonCommand = "daemon"
+
+// daemonSocketName is the name of the default socket inside the state directory.
+const daemonSocketName = "miniooni.sock"
+
+// daemonRunRequest is the body of a /run request. Name is an experiment name
+// or "run-all". Options are the experiment options, as in -O KEY=VALUE.
+type daemonRunRequest struct {
+	Inputs  []string          `json:"inputs"`
+	Name    string            `json:"name"`
+	Options map[string]string `json:"options"`
+}
+
+// daemonStatus is the response to a /status request. Name, StartTime and
+// Failure refer to the run in progress or, when we are not running, to the
+// last run. Failure is the fatal error that stopped the last run, if any.
+// RunSummary only contains the aggregates, not the per-measurement summaries,
+// so that the size of the status does not grow with each measurement.
+type daemonStatus struct {
+	Failure    string             `json:"failure,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	Running    bool               `json:"running"`
+	RunSummary *engine.RunSummary `json:"run_summary"`
+	StartTime  *time.Time         `json:"start_time,omitempty"`
+}
+
+// daemonFlushResult is the response to a /flush request.
+type daemonFlushResult struct {
+	Failure   string `json:"failure,omitempty"`
+	Submitted int    `json:"submitted"`
+}
+
+// daemon runs at most a single run at a time using sess.
+type daemon struct {
+	cancel         context.CancelFunc
+	currentOptions Options
+	done           chan struct{}
+	failure        string
+	mu             sync.Mutex
+	name           string
+	out            *output
+	sess           *engine.Session
+	startTime      time.Time
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handlers := map[string]struct {
+		method  string
+		handler func(http.ResponseWriter, *http.Request)
+	}{
+		"/run":    {http.MethodPost, d.run},
+		"/stop":   {http.MethodPost, d.stop},
+		"/status": {http.MethodGet, d.status},
+		"/flush":  {http.MethodPost, d.flush},
+	}
+	route, found := handlers[r.URL.Path]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != route.method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	route.handler(w, r)
+}
+
+func (d *daemon) run(w http.ResponseWriter, r *http.Request) {
+	var req daemonRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid run request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name != runAllCommand {
+		if _, err := d.sess.NewExperimentBuilder(req.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done != nil {
+		http.Error(w, "a run is already in progress", http.StatusConflict)
+		return
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if d.currentOptions.MaxRuntime > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(),
+			time.Duration(d.currentOptions.MaxRuntime)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	d.cancel, d.done = cancel, make(chan struct{})
+	d.failure, d.name, d.startTime = "", req.Name, time.Now()
+	go d.measure(ctx, req)
+	d.writeJSON(w, d.statusLocked())
+}
+
+// measure performs the run requested by req. The functions running the
+// experiments panic on fatal errors, so we recover and record the panic, as
+// we record the error returned when the experiment cannot run.
+func (d *daemon) measure(ctx context.Context, req daemonRunRequest) {
+	var failure string
+	defer func() {
+		if r := recover(); r != nil {
+			failure = fmt.Sprintf("%v", r)
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.cancel()
+		close(d.done)
+		d.cancel, d.done, d.failure = nil, nil, failure
+		log.Infof("daemon: %s run finished", req.Name)
+	}()
+	log.Infof("daemon: starting %s run", req.Name)
+	currentOptions := d.currentOptions
+	currentOptions.InputFilePaths, currentOptions.Inputs = nil, req.Inputs
+	currentOptions.ExtraOptions = nil
+	for key, value := range req.Options {
+		currentOptions.ExtraOptions = append(
+			currentOptions.ExtraOptions, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(currentOptions.ExtraOptions)
+	if req.Name == runAllCommand {
+		runAll(ctx, d.out, d.sess, currentOptions, req.Options)
+		return
+	}
+	builder, err := d.sess.NewExperimentBuilder(req.Name)
+	fatalOnError(err, "cannot create experiment builder")
+	err = runExperiment(ctx, d.out, d.sess, builder, currentOptions, req.Options)
+	warnOnError(err, "cannot run experiment")
+	failure = errorString(err)
+}
+
+// stop stops the run in progress. We stop before measuring the next input
+// and we also interrupt the current measurement if the experiment is
+// interruptible. We respond when the run has actually stopped.
+func (d *daemon) stop(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	cancel, done := d.cancel, d.done
+	d.mu.Unlock()
+	if done == nil {
+		http.Error(w, "no run in progress", http.StatusConflict)
+		return
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		return
+	}
+	d.status(w, r)
+}
+
+func (d *daemon) status(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	status := d.statusLocked()
+	d.mu.Unlock()
+	d.writeJSON(w, status)
+}
+
+func (d *daemon) statusLocked() daemonStatus {
+	status := daemonStatus{
+		Failure:    d.failure,
+		Name:       d.name,
+		Running:    d.done != nil,
+		RunSummary: d.sess.RunSummary(),
+	}
+	status.RunSummary.Measurements = nil
+	if !d.startTime.IsZero() {
+		startTime := d.startTime
+		status.StartTime = &startTime
+	}
+	return status
+}
+
+// flush submits the queued measurements. We refuse to flush while running
+// because the run is already using the network and the collector.
+func (d *daemon) flush(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	running := d.done != nil
+	d.mu.Unlock()
+	if running {
+		http.Error(w, "cannot flush while a run is in progress", http.StatusConflict)
+		return
+	}
+	count, err := d.sess.FlushQueuedMeasurements(r.Context())
+	warnOnError(err, "cannot flush the queued measurements")
+	d.writeJSON(w, daemonFlushResult{Failure: errorString(err), Submitted: count})
+}
+
+func (d *daemon) writeJSON(w http.ResponseWriter, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// wait stops the run in progress, if any, and waits for it to finish.
+func (d *daemon) wait() {
+	d.mu.Lock()
+	cancel, done := d.cancel, d.done
+	d.mu.Unlock()
+	if done != nil {
+		cancel()
+		<-done
+	}
+}
+
+// daemonDialTimeout is how long we wait for a daemon that may still
+// be listening on the control socket.
+const daemonDialTimeout = 2 * time.Second
+
+// removeStaleSocket removes the socket at socketPath left behind by a
+// previous daemon that did not exit cleanly, which would otherwise prevent
+// us from listening. We refuse to remove a file that is not a socket and the
+// socket of a daemon that is still running.
+func removeStaleSocket(socketPath string) {
+	info, err := os.Lstat(socketPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	fatalOnError(err, "cannot stat the control socket")
+	fatalIfFalse(info.Mode()&os.ModeSocket != 0, "the control socket path is not a socket")
+	if conn, err := net.DialTimeout("unix", socketPath, daemonDialTimeout); err == nil {
+		conn.Close()
+		fatalWithString("another daemon is using the control socket")
+	}
+	err = os.Remove(socketPath)
+	fatalOnError(err, "cannot remove stale socket")
+}
+
+// runDaemon serves the control API on the unix socket at socketPath until
+// we receive SIGINT or SIGTERM. Runs queue the measurements they cannot
+// submit, which you can then submit using /flush. When db is not nil, it
+// is the measurement database used by sess, which we show in the web UI.
+func runDaemon(out *output, sess *engine.Session, db *measurementdb.DB,
+	socketPath string, currentOptions Options, extraOptions map[string]string) {
+	fatalIfFalse(len(extraOptions) <= 0, "daemon does not support experiment options")
+	fatalIfFalse(len(currentOptions.Inputs) <= 0 && len(currentOptions.InputFilePaths) <= 0,
+		"daemon does not support inputs")
+	if currentOptions.SocketPath != "" {
+		socketPath = currentOptions.SocketPath
+	}
+	removeStaleSocket(socketPath)
+	// The API does not authenticate clients, so only our user can use it.
+	listener, err := listenUnix(socketPath)
+	fatalOnError(err, "cannot listen on the control socket")
+	defer os.Remove(socketPath)
+	currentOptions.QueueMeasurements = true
+	if currentOptions.ListenAddress != "" && db != nil {
+		ui := &http.Server{Addr: currentOptions.ListenAddress, Handler: &serveHandler{db: db}}
+		defer ui.Close()
+		go func() {
+			err := ui.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				warnOnError(err, "cannot serve the web UI")
+			}
+		}()
+		log.Infof("daemon: serving the results of past runs at http://%s/",
+			currentOptions.ListenAddress)
+	}
+	d := &daemon{currentOptions: currentOptions, out: out, sess: sess}
+	server := &http.Server{Handler: d}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		sig := <-sigs
+		log.Infof("daemon: got %s; shutting down", sig)
+		d.wait()
+		server.Close()
+	}()
+	log.Infof("daemon: listening on %s", socketPath)
+	err = server.Serve(listener)
+	if !errors.Is(err, http.ErrServerClosed) {
+		fatalOnError(err, "cannot serve the control API")
+	}
+}
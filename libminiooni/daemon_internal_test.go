@@ -0,0 +1,62 @@
+package libminiooni
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func mustPanic(t *testing.T, fn func()) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic here")
+		}
+	}()
+	fn()
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on Windows")
+	}
+	dir, err := ioutil.TempDir("", "ooniprobe-engine-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, daemonSocketName)
+	removeStaleSocket(socketPath) // must not panic when there is no socket
+
+	if err := ioutil.WriteFile(socketPath, []byte("antani"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mustPanic(t, func() { removeStaleSocket(socketPath) })
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Fatal("we removed a file that is not a socket", err)
+	}
+	if err := os.Remove(socketPath); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := listenUnix(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected permissions: %o", info.Mode().Perm())
+	}
+	mustPanic(t, func() { removeStaleSocket(socketPath) })
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close() // as if the daemon did not exit cleanly
+	removeStaleSocket(socketPath)
+	if _, err := os.Lstat(socketPath); !os.IsNotExist(err) {
+		t.Fatal("we did not remove the stale socket", err)
+	}
+}
@@ -0,0 +1,18 @@
+// +build !windows
+
+package libminiooni
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix is like net.Listen("unix", path) except that we create the
+// socket with 0600 permissions, rather than changing them after listening,
+// when other users may have already connected. The umask is per process,
+// so we do this before running experiments that may create files.
+func listenUnix(path string) (net.Listener, error) {
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
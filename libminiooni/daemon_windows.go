@@ -0,0 +1,11 @@
+// +build windows
+
+package libminiooni
+
+import "net"
+
+// listenUnix is like net.Listen("unix", path). On Windows, the socket
+// inherits the access control list of the directory containing it.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -16,6 +16,7 @@ package libminiooni
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -25,13 +26,19 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/apex/log"
+	jsonhandler "github.com/apex/log/handlers/json"
 	engine "github.com/ooni/probe-engine"
+	"github.com/ooni/probe-engine/eventbus"
 	"github.com/ooni/probe-engine/internal/humanizex"
+	"github.com/ooni/probe-engine/logx"
+	"github.com/ooni/probe-engine/measurementdb"
+	"github.com/ooni/probe-engine/metrics"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/selfcensor"
 	"github.com/pborman/getopt/v2"
@@ -39,22 +46,37 @@ import (
 
 // Options contains the options you can set from the CLI.
 type Options struct {
-	Annotations      []string
-	ExtraOptions     []string
-	HomeDir          string
-	Inputs           []string
-	NoBouncer        bool
-	NoGeoIP          bool
-	NoJSON           bool
-	NoCollector      bool
-	ProbeServicesURL string
-	Proxy            string
-	ReportFile       string
-	SelfCensorSpec   string
-	TorArgs          []string
-	TorBinary        string
-	Tunnel           string
-	Verbose          bool
+	Annotations       []string
+	Categories        []string
+	ExtraOptions      []string
+	Format            string
+	HomeDir           string
+	InputFilePaths    []string
+	Inputs            []string
+	Limit             int64
+	ListenAddress     string
+	LogJSON           bool
+	MaxRuntime        int64
+	MetricsListen     string
+	NoBouncer         bool
+	NoGeoIP           bool
+	NoJSON            bool
+	NoCollector       bool
+	ProbeServicesURL  string
+	Proxy             string
+	ProxyMeasurements bool
+	Random            bool
+	ReportFile        string
+	SaveMeasurements  bool
+	SelfCensorSpec    string
+	QueueMeasurements bool
+	SelfTest          bool
+	SocketPath        string
+	TorArgs           []string
+	TorBinary         string
+	TorBridges        []string
+	Tunnel            string
+	Verbose           bool
 }
 
 const (
@@ -71,18 +93,55 @@ func init() {
 	getopt.FlagLong(
 		&globalOptions.Annotations, "annotation", 'A', "Add annotaton", "KEY=VALUE",
 	)
+	getopt.FlagLong(
+		&globalOptions.Categories, "category", 'c',
+		"Only fetch test list URLs in this category (may be specified multiple times)",
+		"CODE",
+	)
 	getopt.FlagLong(
 		&globalOptions.ExtraOptions, "option", 'O',
 		"Pass an option to the experiment", "KEY=VALUE",
 	)
+	getopt.FlagLong(
+		&globalOptions.Format, "format", 0,
+		"Output format (one of `table`, `json`); `json` writes all events to the stdout as JSON lines",
+		"FORMAT",
+	)
 	getopt.FlagLong(
 		&globalOptions.HomeDir, "home", 0,
 		"Force specific home directory", "PATH",
 	)
+	getopt.FlagLong(
+		&globalOptions.InputFilePaths, "input-file", 'f',
+		"Path to input file to supply test-dependent input, optionally with per-input "+
+			"options using JSON lines or CSV (use `-` for stdin)",
+		"PATH",
+	)
 	getopt.FlagLong(
 		&globalOptions.Inputs, "input", 'i',
 		"Add test-dependent input to the test input", "INPUT",
 	)
+	getopt.FlagLong(
+		&globalOptions.Limit, "limit", 0,
+		"Maximum number of inputs to measure (default: 17 when fetching test lists)", "N",
+	)
+	getopt.FlagLong(
+		&globalOptions.ListenAddress, "listen", 0,
+		"Address where the serve command (default: 127.0.0.1:8080) or the daemon "+
+			"serves the web UI showing past runs", "ADDRESS",
+	)
+	getopt.FlagLong(
+		&globalOptions.LogJSON, "log-json", 0,
+		"Emit logs as newline-delimited JSON including the engine component",
+	)
+	getopt.FlagLong(
+		&globalOptions.MaxRuntime, "max-runtime", 0,
+		"Stop measuring new inputs after N seconds (per suite with run-all)", "N",
+	)
+	getopt.FlagLong(
+		&globalOptions.MetricsListen, "metrics-listen", 0,
+		"Address where the daemon serves Prometheus metrics at /metrics", "ADDRESS",
+	)
 	getopt.FlagLong(
 		&globalOptions.NoBouncer, "no-bouncer", 0, "Don't use the OONI bouncer",
 	)
@@ -101,16 +160,37 @@ func init() {
 		"Set the URL of the probe-services instance you want to use", "URL",
 	)
 	getopt.FlagLong(
-		&globalOptions.Proxy, "proxy", 0, "Set the proxy URL", "URL",
+		&globalOptions.Proxy, "proxy", 0,
+		"Set the proxy URL (only `socks5://<host>:<port>` is supported)", "URL",
+	)
+	getopt.FlagLong(
+		&globalOptions.ProxyMeasurements, "proxy-measurements", 0,
+		"Also route the measurements traffic through the proxy or the tunnel",
+	)
+	getopt.FlagLong(
+		&globalOptions.Random, "random", 0, "Randomize the order of the inputs",
 	)
 	getopt.FlagLong(
 		&globalOptions.ReportFile, "reportfile", 'o',
 		"Set the report file path", "PATH",
 	)
+	getopt.FlagLong(
+		&globalOptions.SaveMeasurements, "save-measurements", 0,
+		"Also store the measurements into the database shown by the web UI",
+	)
 	getopt.FlagLong(
 		&globalOptions.SelfCensorSpec, "self-censor-spec", 0,
 		"Enable and configure self censorship", "JSON",
 	)
+	getopt.FlagLong(
+		&globalOptions.SelfTest, "self-test", 0,
+		"Check the health of the engine, print a JSON report, and exit",
+	)
+	getopt.FlagLong(
+		&globalOptions.SocketPath, "socket", 0,
+		"Path of the control socket of the daemon (default: ~/.miniooni/miniooni.sock)",
+		"PATH",
+	)
 	getopt.FlagLong(
 		&globalOptions.TorArgs, "tor-args", 0,
 		"Extra args for tor binary (may be specified multiple times)",
@@ -119,15 +199,39 @@ func init() {
 		&globalOptions.TorBinary, "tor-binary", 0,
 		"Specify path to a specific tor binary",
 	)
+	getopt.FlagLong(
+		&globalOptions.TorBridges, "tor-bridge", 0,
+		"Use this obfs4 bridge line with the tor tunnel (may be specified multiple times)",
+		"LINE",
+	)
 	getopt.FlagLong(
 		&globalOptions.Tunnel, "tunnel", 0,
-		"Name of the tunnel to use (one of `tor`, `psiphon`)",
+		"Name of the tunnel to use (one of `tor`, `psiphon`, `snowflake`, `socks5://<host>:<port>`)",
 	)
 	getopt.FlagLong(
 		&globalOptions.Verbose, "verbose", 'v', "Increase verbosity",
 	)
 }
 
+// tunnelHealthCheckInterval is how often we check whether the
+// tunnel still works during long runs.
+const tunnelHealthCheckInterval = 5 * time.Minute
+
+// tunnelLogger logs the tunnel bootstrap progress.
+type tunnelLogger struct{}
+
+func (tunnelLogger) OnTunnelStart(name string) {
+	log.Infof("Starting the %s tunnel; please be patient...", name)
+}
+
+func (tunnelLogger) OnTunnelProgress(name string, percentage float64, message string) {
+	log.Infof("%s tunnel: %3.0f%%: %s", name, percentage*100, message)
+}
+
+func (tunnelLogger) OnTunnelFailure(name string, err error) {
+	log.WithError(err).Warnf("%s tunnel failed", name)
+}
+
 func fatalWithString(msg string) {
 	panic(msg)
 }
@@ -147,7 +251,7 @@ func fatalIfFalse(cond bool, msg string) {
 // integrate this function to either handle the panic of ignore it.
 func Main() {
 	getopt.Parse()
-	fatalIfFalse(len(getopt.Args()) == 1, "Missing experiment name")
+	fatalIfFalse(len(getopt.Args()) == 1 || globalOptions.SelfTest, "Missing experiment name or command")
 	MainWithConfiguration(getopt.Arg(0), globalOptions)
 }
 
@@ -224,14 +328,43 @@ func gethomedir(optionsHome string) string {
 	return os.Getenv("HOME")
 }
 
+// newInputLoader returns the InputLoader configured by the options.
+func newInputLoader(
+	sess *engine.Session, policy engine.InputPolicy, currentOptions Options) *engine.InputLoader {
+	inputLoader := &engine.InputLoader{
+		Categories:   currentOptions.Categories,
+		InputPolicy:  policy,
+		MaxInputs:    int(currentOptions.Limit),
+		Session:      sess,
+		Shuffle:      currentOptions.Random,
+		StaticInputs: currentOptions.Inputs,
+	}
+	for _, filepath := range currentOptions.InputFilePaths {
+		if filepath == "-" {
+			inputLoader.Stdin = os.Stdin
+			continue
+		}
+		inputLoader.SourceFiles = append(inputLoader.SourceFiles, filepath)
+	}
+	return inputLoader
+}
+
 // MainWithConfiguration is the miniooni main with a specific configuration
-// represented by the experiment name and the current options.
+// represented by the experiment name and the current options. When the
+// experiment name is "list", we print the URLs we would measure, along with
+// their category and country code, instead of running an experiment. When
+// it is "run-all", we run the same suites of experiments as the apps. When
+// it is "serve", we serve a web UI showing the results of past runs. When
+// it is "daemon", we keep running and perform the runs requested using
+// the control API exposed over a unix socket (see daemonCommand).
 //
 // This function will panic in case of a fatal error. It is up to you that
 // integrate this function to either handle the panic of ignore it.
 func MainWithConfiguration(experimentName string, currentOptions Options) {
 	extraOptions := mustMakeMap(currentOptions.ExtraOptions)
 	annotations := mustMakeMap(currentOptions.Annotations)
+	fatalIfFalse(currentOptions.Format == "" || currentOptions.Format == "table" ||
+		currentOptions.Format == "json", "invalid --format argument")
 
 	err := selfcensor.MaybeEnable(currentOptions.SelfCensorSpec)
 	fatalOnError(err, "cannot parse --self-censor-spec argument")
@@ -244,6 +377,18 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 		currentOptions.ReportFile = "report.jsonl"
 	}
 	log.Log = logger
+	// With JSON logs, we also emit the fields, such as the component, that
+	// the engine attaches to messages. Otherwise, they would be noise.
+	var engineLogger model.Logger = logger
+	if currentOptions.LogJSON {
+		logger.Handler = jsonhandler.New(os.Stderr)
+		engineLogger = logx.NewApexLogger(logger)
+	}
+	out := &output{json: currentOptions.Format == "json", w: os.Stdout}
+	if out.json {
+		logger.Handler = out
+		engineLogger = logx.NewApexLogger(logger)
+	}
 
 	homeDir := gethomedir(currentOptions.HomeDir)
 	fatalIfFalse(homeDir != "", "home directory is empty")
@@ -253,28 +398,68 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 	fatalOnError(err, "cannot create assets directory")
 	log.Infof("miniooni state directory: %s", miniooniDir)
 
+	dbPath := filepath.Join(miniooniDir, "measurements.db")
+	if experimentName == serveCommand {
+		serve(dbPath, currentOptions)
+		return
+	}
+
 	var proxyURL *url.URL
 	if currentOptions.Proxy != "" {
+		fatalIfFalse(currentOptions.Tunnel == "", "--proxy and --tunnel are mutually exclusive")
 		proxyURL = mustParseURL(currentOptions.Proxy)
+		fatalIfFalse(proxyURL.Scheme == "socks5", "--proxy only supports socks5:// URLs")
+	}
+	var routing engine.RoutingPolicy
+	if currentOptions.ProxyMeasurements {
+		fatalIfFalse(currentOptions.Proxy != "" || currentOptions.Tunnel != "",
+			"--proxy-measurements requires --proxy or --tunnel")
+		routing.Measurements = engine.RouteProxy
+	}
+
+	var prometheus *metrics.Engine
+	if currentOptions.MetricsListen != "" {
+		fatalIfFalse(experimentName == daemonCommand, "--metrics-listen requires daemon")
+		prometheus = metrics.New()
+		server, err := metrics.Listen(currentOptions.MetricsListen, prometheus.Registry)
+		fatalOnError(err, "cannot listen for metrics")
+		defer server.Close()
+		log.Infof("serving metrics at http://%s/metrics", server.Addr())
 	}
 
 	kvstore2dir := filepath.Join(miniooniDir, "kvstore2")
 	kvstore, err := engine.NewFileSystemKVStore(kvstore2dir)
 	fatalOnError(err, "cannot create kvstore2 directory")
 
+	// We keep going without a database, e.g., when another miniooni is
+	// running, because the database is just for browsing past runs.
+	db, err := measurementdb.Open(dbPath)
+	warnOnError(err, "cannot open the measurement database")
+	if err == nil {
+		defer db.Close()
+	}
+
 	config := engine.SessionConfig{
-		AssetsDir: assetsDir,
-		KVStore:   kvstore,
-		Logger:    logger,
+		Annotations:       annotations,
+		AssetsDir:         assetsDir,
+		KVStore:           kvstore,
+		LogLevel:          logger.Level,
+		Logger:            engineLogger,
+		MeasurementDB:     db,
+		MeasurementDBSave: currentOptions.SaveMeasurements,
 		PrivacySettings: model.PrivacySettings{
 			IncludeASN:     true,
 			IncludeCountry: true,
 		},
+		Prometheus:      prometheus,
 		ProxyURL:        proxyURL,
+		Routing:         routing,
 		SoftwareName:    softwareName,
 		SoftwareVersion: softwareVersion,
 		TorArgs:         currentOptions.TorArgs,
 		TorBinary:       currentOptions.TorBinary,
+		TorBridges:      currentOptions.TorBridges,
+		TunnelCallbacks: tunnelLogger{},
 	}
 	if currentOptions.ProbeServicesURL != "" {
 		config.AvailableProbeServices = []model.Service{{
@@ -286,6 +471,7 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 	sess, err := engine.NewSession(config)
 	fatalOnError(err, "cannot create measurement session")
 	defer func() {
+		out.emit("run_summary", sess.RunSummary())
 		sess.Close()
 		log.Infof("whole session: recv %s, sent %s",
 			humanizex.SI(sess.KibiBytesReceived()*1024, "byte"),
@@ -293,9 +479,41 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 		)
 	}()
 	log.Infof("miniooni temporary directory: %s", sess.TempDir())
+	defer sess.EventBus().Subscribe(func(ev eventbus.Event) {
+		if out.json {
+			out.emit(ev.Name(), ev)
+			return
+		}
+		if _, ok := ev.(eventbus.Progress); ok {
+			return // the experiment callbacks already log the progress
+		}
+		data, _ := json.Marshal(ev)
+		log.Debugf("event: %s %s", ev.Name(), string(data))
+	})()
 
 	err = sess.MaybeStartTunnel(context.Background(), currentOptions.Tunnel)
 	fatalOnError(err, "cannot start session tunnel")
+	if currentOptions.Tunnel != "" {
+		log.Infof("%s tunnel bootstrapped in %s", currentOptions.Tunnel,
+			sess.TunnelBootstrapTime())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go sess.KeepTunnelAlive(ctx, tunnelHealthCheckInterval)
+	}
+
+	if currentOptions.SelfTest {
+		log.Info("Checking the health of the engine; please be patient...")
+		report := sess.SelfTest(context.Background())
+		if out.json {
+			out.emit("self_test.report", report)
+		} else {
+			data, err := json.MarshalIndent(report, "", "  ")
+			fatalOnError(err, "cannot serialize the health report")
+			fmt.Printf("%s\n", data)
+		}
+		fatalIfFalse(report.Healthy, "the engine is not healthy")
+		return
+	}
 
 	if !currentOptions.NoBouncer {
 		log.Info("Looking up OONI backends; please be patient...")
@@ -314,34 +532,58 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 	log.Infof("- country: %s", sess.ProbeCC())
 	log.Infof("- network: %s (%s)", sess.ProbeNetworkName(), sess.ProbeASNString())
 	log.Infof("- resolver's IP: %s", sess.ResolverIP())
-	log.Infof("- resolver's network: %s (%s)", sess.ResolverNetworkName(),
-		sess.ResolverASNString())
+	log.Infof("- resolver's network: %s (%s, %s)", sess.ResolverNetworkName(),
+		sess.ResolverASNString(), sess.ResolverNetworkType())
+	if sess.ProbeIsVPN() {
+		log.Warn("- you may be using a VPN: results may not reflect your ISP")
+	}
+
+	if experimentName == listCommand {
+		printURLList(out, sess, currentOptions)
+		return
+	}
+	if experimentName == runAllCommand {
+		runAll(context.Background(), out, sess, currentOptions, extraOptions)
+		return
+	}
+	if experimentName == daemonCommand {
+		runDaemon(out, sess, db, filepath.Join(miniooniDir, daemonSocketName),
+			currentOptions, extraOptions)
+		return
+	}
 
+	ctx := context.Background()
+	if currentOptions.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(
+			ctx, time.Duration(currentOptions.MaxRuntime)*time.Second)
+		defer cancel()
+	}
 	builder, err := sess.NewExperimentBuilder(experimentName)
 	fatalOnError(err, "cannot create experiment builder")
-	if builder.InputPolicy() == engine.InputRequired {
-		if len(currentOptions.Inputs) <= 0 {
-			log.Info("Fetching test lists")
-			client, err := sess.NewOrchestraClient(context.Background())
-			fatalOnError(err, "cannot create new orchestra client")
-			list, err := client.FetchURLList(context.Background(), model.URLListConfig{
-				CountryCode: sess.ProbeCC(),
-				Limit:       17,
-			})
-			fatalOnError(err, "cannot fetch test lists")
-			for _, entry := range list {
-				currentOptions.Inputs = append(currentOptions.Inputs, entry.URL)
-			}
-		}
-	} else if builder.InputPolicy() == engine.InputOptional {
-		if len(currentOptions.Inputs) == 0 {
-			currentOptions.Inputs = append(currentOptions.Inputs, "")
-		}
-	} else if len(currentOptions.Inputs) != 0 {
-		fatalWithString("this experiment does not expect any input")
-	} else {
-		// Tests that do not expect input internally require an empty input to run
-		currentOptions.Inputs = append(currentOptions.Inputs, "")
+	err = runExperiment(ctx, out, sess, builder, currentOptions, extraOptions)
+	fatalOnError(err, "cannot run experiment")
+}
+
+// runExperiment runs the experiment created by builder with the inputs
+// and the experiment options selected by currentOptions, and emits each
+// measurement as a "measurement" event in JSON mode. We stop measuring
+// new inputs when ctx is done, and we also interrupt the measurement in
+// progress when the experiment is interruptible. We return an error when
+// we cannot load the inputs or open the report, which may be transient,
+// and we panic on the other fatal errors, e.g., invalid options.
+func runExperiment(ctx context.Context, out *output, sess *engine.Session,
+	builder *engine.ExperimentBuilder, currentOptions Options,
+	extraOptions map[string]string) error {
+	inputLoader := newInputLoader(sess, builder.InputPolicy(), currentOptions)
+	inputLoader.DefaultInputs = builder.DefaultInputs()
+	if builder.InputPolicy() == engine.InputRequired && len(currentOptions.Inputs) <= 0 &&
+		len(currentOptions.InputFilePaths) <= 0 {
+		log.Info("Fetching test lists")
+	}
+	entries, err := inputLoader.Load(context.Background())
+	if err != nil {
+		return fmt.Errorf("cannot load inputs: %w", err)
 	}
 	intregexp := regexp.MustCompile("^[0-9]+$")
 	for key, value := range extraOptions {
@@ -368,26 +610,49 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 
 	if !currentOptions.NoCollector {
 		log.Info("Opening report; please be patient...")
-		err := experiment.OpenReport()
-		fatalOnError(err, "cannot open report")
+		if err := experiment.OpenReport(); err != nil {
+			return fmt.Errorf("cannot open report: %w", err)
+		}
 		defer experiment.CloseReport()
 		log.Infof("Report ID: %s", experiment.ReportID())
 	}
 
-	inputCount := len(currentOptions.Inputs)
+	inputCount := len(entries)
 	inputCounter := 0
-	for _, input := range currentOptions.Inputs {
+	for _, entry := range entries {
+		input := entry.URL
+		if ctx.Err() != nil {
+			log.Info("maximum runtime reached; skipping the remaining inputs")
+			break
+		}
 		inputCounter++
 		if input != "" {
 			log.Infof("[%d/%d] running with input: %s", inputCounter, inputCount, input)
 		}
-		measurement, err := experiment.Measure(input)
+		measurement, err := experiment.MeasureWithOptions(
+			contextForExperiment(ctx, builder), input, entry.Options)
+		if builder.Interruptible() && ctx.Err() != nil {
+			break // the measurement is incomplete
+		}
 		warnOnError(err, "measurement failed")
-		measurement.AddAnnotations(annotations)
-		measurement.Options = currentOptions.ExtraOptions
+		if measurement == nil {
+			continue // e.g., the options of this input are not valid
+		}
+		measurement.Options = append(append([]string(nil),
+			currentOptions.ExtraOptions...), inputOptions(entry.Options)...)
+		out.emit("measurement", outputMeasurement{
+			Failure:     errorString(err),
+			Idx:         inputCounter - 1,
+			Input:       input,
+			Measurement: measurement,
+		})
 		if !currentOptions.NoCollector {
 			log.Infof("submitting measurement to OONI collector; please be patient...")
-			err := experiment.SubmitAndUpdateMeasurement(measurement)
+			submit := experiment.SubmitAndUpdateMeasurement
+			if currentOptions.QueueMeasurements {
+				submit = experiment.SubmitOrQueueMeasurement
+			}
+			err := submit(measurement)
 			warnOnError(err, "submitting measurement failed")
 		}
 		if !currentOptions.NoJSON {
@@ -398,4 +663,26 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 			warnOnError(err, "saving measurement failed")
 		}
 	}
+	return nil
+}
+
+// inputOptions returns the per-input options as sorted KEY=VALUE strings,
+// i.e., using the same format of the options passed using -O.
+func inputOptions(options map[string]interface{}) []string {
+	var out []string
+	for key, value := range options {
+		out = append(out, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// contextForExperiment returns ctx for interruptible experiments and
+// a context that is never done otherwise.
+func contextForExperiment(
+	ctx context.Context, builder *engine.ExperimentBuilder) context.Context {
+	if builder.Interruptible() {
+		return ctx
+	}
+	return context.Background()
 }
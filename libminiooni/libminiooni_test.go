@@ -9,3 +9,11 @@ import (
 func TestIntegrationSimple(t *testing.T) {
 	libminiooni.MainWithConfiguration("example", libminiooni.Options{})
 }
+
+func TestIntegrationList(t *testing.T) {
+	libminiooni.MainWithConfiguration("list", libminiooni.Options{
+		Categories: []string{"NEWS"},
+		Format:     "json",
+		Limit:      3,
+	})
+}
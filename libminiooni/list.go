@@ -0,0 +1,53 @@
+package libminiooni
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	engine "github.com/ooni/probe-engine"
+	"github.com/ooni/probe-engine/model"
+)
+
+// listCommand is the pseudo experiment name that prints the URLs
+// we would measure rather than measuring them.
+const listCommand = "list"
+
+// printURLList performs the check-in, honouring the options that also
+// select the inputs of a run (e.g., --category, --limit), and writes the
+// URLs we would test to out, as a table or as "url" events.
+func printURLList(out *output, sess *engine.Session, currentOptions Options) {
+	log.Info("Fetching test lists")
+	inputLoader := newInputLoader(sess, engine.InputRequired, currentOptions)
+	entries, err := inputLoader.Load(context.Background())
+	fatalOnError(err, "cannot load inputs")
+	if out.json {
+		for _, entry := range entries {
+			out.emit("url", entry)
+		}
+		return
+	}
+	err = writeURLList(out.w, entries)
+	fatalOnError(err, "cannot write the URL list")
+}
+
+func writeURLList(w io.Writer, entries []model.URLInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CATEGORY\tCOUNTRY\tURL")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", orDash(entry.CategoryCode),
+			orDash(entry.CountryCode), entry.URL)
+	}
+	return tw.Flush()
+}
+
+// orDash returns "-" instead of the empty string, which would otherwise
+// make the table hard to read for inputs not coming from the check-in.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package libminiooni
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+// output is where we write the results. In JSON mode, we write every
+// event, including logs, progress, and results, as a JSON object per line
+// so that scripts can drive miniooni. Otherwise, we only write the human
+// readable results of commands, while the logs go to the stderr.
+type output struct {
+	json bool
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// outputEvent is an event written in JSON mode.
+type outputEvent struct {
+	Key   string      `json:"key"`
+	Time  float64     `json:"t"`
+	Value interface{} `json:"value"`
+}
+
+// outputLog is the value of a "log" event.
+type outputLog struct {
+	Fields  log.Fields `json:"fields,omitempty"`
+	Level   string     `json:"level"`
+	Message string     `json:"message"`
+}
+
+// outputMeasurement is the value of a "measurement" event. Failure is
+// the error that occurred when measuring, if any.
+type outputMeasurement struct {
+	Failure     string             `json:"failure,omitempty"`
+	Idx         int                `json:"idx"`
+	Input       string             `json:"input"`
+	Measurement *model.Measurement `json:"measurement"`
+}
+
+// emit writes an event with the given key and value in JSON mode and
+// does nothing otherwise.
+func (o *output) emit(key string, value interface{}) {
+	if !o.json {
+		return
+	}
+	data, err := json.Marshal(outputEvent{
+		Key:   key,
+		Time:  time.Since(startTime).Seconds(),
+		Value: value,
+	})
+	if err != nil {
+		data, _ = json.Marshal(outputEvent{Key: "failure.serialization", Value: err.Error()})
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Write(append(data, '\n'))
+}
+
+// HandleLog implements log.Handler by emitting "log" events.
+func (o *output) HandleLog(e *log.Entry) error {
+	o.emit("log", outputLog{
+		Fields:  e.Fields,
+		Level:   e.Level.String(),
+		Message: e.Message,
+	})
+	return nil
+}
+
+func errorString(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
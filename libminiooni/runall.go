@@ -0,0 +1,131 @@
+package libminiooni
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apex/log"
+	engine "github.com/ooni/probe-engine"
+)
+
+// runAllCommand is the pseudo experiment name that runs all the suites.
+const runAllCommand = "run-all"
+
+// suite is a group of experiments that the apps run together.
+type suite struct {
+	Name        string
+	Experiments []string
+}
+
+// suites are the suites run by run-all, in the order used by the apps.
+var suites = []suite{{
+	Name:        "websites",
+	Experiments: []string{"web_connectivity"},
+}, {
+	Name:        "im",
+	Experiments: []string{"facebook_messenger", "telegram", "whatsapp", "signal"},
+}, {
+	Name: "performance",
+	Experiments: []string{
+		"ndt", "dash", "http_invalid_request_line", "http_header_field_manipulation",
+	},
+}, {
+	Name:        "circumvention",
+	Experiments: []string{"psiphon", "tor"},
+}}
+
+// suiteSummary summarizes a suite. Experiments are in the order
+// in which we ran them.
+type suiteSummary struct {
+	Experiments []experimentSummary `json:"experiments"`
+	Name        string              `json:"name"`
+	Runtime     float64             `json:"runtime"`
+}
+
+// experimentSummary is the summary of an experiment of a suite.
+type experimentSummary struct {
+	*engine.ExperimentSummary
+	Name string `json:"name"`
+}
+
+// runAll runs all the suites using the same session and writes to out a
+// summary of all the suites, as a table or as a "run_all.summary" event. Inputs
+// are only used by the experiments taking input and --max-runtime applies
+// to each suite rather than to the whole run. We skip the experiments that
+// cannot run, e.g., because we cannot open the report, and we stop when ctx
+// is done.
+func runAll(ctx context.Context, out *output, sess *engine.Session,
+	currentOptions Options, extraOptions map[string]string) {
+	fatalIfFalse(len(extraOptions) <= 0, "run-all does not support experiment options")
+	var summaries []suiteSummary
+	for _, s := range suites {
+		if ctx.Err() != nil {
+			break
+		}
+		log.Infof("Running the %s suite", s.Name)
+		start := time.Now()
+		runSuite(ctx, out, sess, s, currentOptions)
+		summaries = append(summaries, suiteSummary{
+			Experiments: suiteExperiments(sess.RunSummary(), s),
+			Name:        s.Name,
+			Runtime:     time.Since(start).Seconds(),
+		})
+	}
+	if out.json {
+		out.emit("run_all.summary", summaries)
+		return
+	}
+	err := writeRunAllSummary(out.w, summaries)
+	fatalOnError(err, "cannot write the summary")
+}
+
+func runSuite(ctx context.Context, out *output, sess *engine.Session, s suite,
+	currentOptions Options) {
+	if currentOptions.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(
+			ctx, time.Duration(currentOptions.MaxRuntime)*time.Second)
+		defer cancel()
+	}
+	for _, name := range s.Experiments {
+		builder, err := sess.NewExperimentBuilder(name)
+		fatalOnError(err, "cannot create experiment builder")
+		options := currentOptions
+		if builder.InputPolicy() == engine.InputNone {
+			options.InputFilePaths, options.Inputs = nil, nil
+		}
+		// An experiment that cannot run, e.g., because we cannot open the
+		// report, should not prevent us from running the other ones.
+		err = runExperiment(ctx, out, sess, builder, options, nil)
+		warnOnError(err, "cannot run "+name)
+	}
+}
+
+// suiteExperiments returns the summaries of the experiments of s.
+func suiteExperiments(summary *engine.RunSummary, s suite) []experimentSummary {
+	var out []experimentSummary
+	for _, name := range s.Experiments {
+		es, found := summary.Experiments[name]
+		if !found {
+			es = &engine.ExperimentSummary{}
+		}
+		out = append(out, experimentSummary{ExperimentSummary: es, Name: name})
+	}
+	return out
+}
+
+func writeRunAllSummary(w io.Writer, summaries []suiteSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUITE\tEXPERIMENT\tMEASUREMENTS\tFAILURES\tANOMALIES\tRUNTIME")
+	for _, summary := range summaries {
+		for _, es := range summary.Experiments {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%.1fs\n", summary.Name, es.Name,
+				es.Measurements, es.Failures, es.Anomalies, es.Runtime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t\t\t\t%.1fs\n", summary.Name, "(total)", summary.Runtime)
+	}
+	return tw.Flush()
+}
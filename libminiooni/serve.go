@@ -0,0 +1,230 @@
+package libminiooni
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/measurementdb"
+)
+
+// serveCommand is the pseudo experiment name that serves a web
+// UI showing the measurements recorded into the database.
+const serveCommand = "serve"
+
+// defaultListenAddress is the default address of the web UI. We only
+// listen on localhost because the UI does not authenticate users.
+const defaultListenAddress = "127.0.0.1:8080"
+
+// run groups the records of the measurements that we performed in the
+// same run of an experiment, i.e., the records with the same report ID
+// or, when we did not open a report, the consecutive records with the
+// same test name.
+type run struct {
+	Anomalies int
+	Failures  int
+	ID        uint64 // the ID of the first record
+	Records   []measurementdb.Record
+	ReportID  string
+	StartTime time.Time
+	TestName  string
+	Uploaded  int
+}
+
+// groupRuns groups the records, which must be sorted by start
+// time, into runs, most recent first.
+func groupRuns(records []measurementdb.Record) []*run {
+	var runs []*run
+	byReportID := make(map[string]*run)
+	var last *run
+	for _, record := range records {
+		current := byReportID[record.ReportID]
+		if record.ReportID == "" {
+			current = nil
+			if last != nil && last.ReportID == "" && last.TestName == record.TestName {
+				current = last
+			}
+		}
+		if current == nil {
+			current = &run{
+				ID:        record.ID,
+				ReportID:  record.ReportID,
+				StartTime: record.StartTime,
+				TestName:  record.TestName,
+			}
+			runs = append(runs, current)
+			if record.ReportID != "" {
+				byReportID[record.ReportID] = current
+			}
+		}
+		current.Records = append(current.Records, record)
+		switch record.Result {
+		case measurementdb.ResultAnomaly:
+			current.Anomalies++
+		case measurementdb.ResultFailure:
+			current.Failures++
+		}
+		if record.UploadStatus == measurementdb.UploadSucceeded {
+			current.Uploaded++
+		}
+		last = current
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs
+}
+
+// serveHandler implements the web UI. When db is nil, we open the database
+// at dbPath for each request, since a running miniooni keeps the database
+// locked, and we serialize the requests, since the database only has a
+// single user. The daemon instead sets db to the database it is using,
+// so that we can browse the runs while it is running.
+type serveHandler struct {
+	db     *measurementdb.DB
+	dbPath string
+	mu     sync.Mutex
+}
+
+func (h *serveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handlers := map[string]func(http.ResponseWriter, *http.Request, *measurementdb.DB){
+		"/":            h.runs,
+		"/run":         h.run,
+		"/measurement": h.measurement,
+	}
+	handler, found := handlers[r.URL.Path]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if h.db != nil {
+		handler(w, r, h.db)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	db, err := measurementdb.Open(h.dbPath)
+	if err != nil {
+		http.Error(w, "cannot open the measurement database (is miniooni running? "+
+			"use daemon --listen to browse the runs while running): "+err.Error(),
+			http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+	handler(w, r, db)
+}
+
+func (h *serveHandler) runs(w http.ResponseWriter, r *http.Request, db *measurementdb.DB) {
+	records, err := db.Query(measurementdb.Query{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, runsTemplate, groupRuns(records))
+}
+
+func (h *serveHandler) run(w http.ResponseWriter, r *http.Request, db *measurementdb.DB) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid run ID", http.StatusBadRequest)
+		return
+	}
+	records, err := db.Query(measurementdb.Query{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, current := range groupRuns(records) {
+		if current.ID == id {
+			h.render(w, runTemplate, current)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (h *serveHandler) measurement(
+	w http.ResponseWriter, r *http.Request, db *measurementdb.DB) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid measurement ID", http.StatusBadRequest)
+		return
+	}
+	data, err := db.GetMeasurement(id)
+	if errors.Is(err, measurementdb.ErrNoSuchRecord) {
+		http.Error(w, "measurement not found (did you use --save-measurements?)",
+			http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+func (h *serveHandler) render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// serve serves the web UI showing the measurements in the database at
+// dbPath until we fail to listen, e.g., because the address is in use.
+func serve(dbPath string, currentOptions Options) {
+	address := currentOptions.ListenAddress
+	if address == "" {
+		address = defaultListenAddress
+	}
+	log.Infof("serving the results of past runs at http://%s/", address)
+	err := http.ListenAndServe(address, &serveHandler{dbPath: dbPath})
+	fatalOnError(err, "cannot serve the web UI")
+}
+
+const serveStyle = `<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { border-bottom: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.anomaly { color: #c60; } .failure { color: #c00; }
+</style>`
+
+var runsTemplate = template.Must(template.New("runs").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>miniooni: past runs</title>` + serveStyle + `</head>
+<body><h1>Past runs</h1>
+{{if not .}}<p>No measurements yet.</p>{{else}}
+<table><tr><th>Start time</th><th>Experiment</th><th>Measurements</th>
+<th>Anomalies</th><th>Failures</th><th>Uploaded</th><th>Report ID</th></tr>
+{{range .}}<tr><td><a href="/run?id={{.ID}}">{{.StartTime.Format "2006-01-02 15:04:05"}}</a></td>
+<td>{{.TestName}}</td><td>{{len .Records}}</td><td>{{.Anomalies}}</td>
+<td>{{.Failures}}</td><td>{{.Uploaded}}</td><td>{{.ReportID}}</td></tr>
+{{end}}</table>{{end}}
+</body></html>`))
+
+var runTemplate = template.Must(template.New("run").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>miniooni: {{.TestName}}</title>` + serveStyle + `</head>
+<body><p><a href="/">&larr; Past runs</a></p>
+<h1>{{.TestName}}</h1><p>Started at {{.StartTime.Format "2006-01-02 15:04:05"}}
+{{with .ReportID}}with report ID {{.}}{{end}}</p>
+<table><tr><th>Input</th><th>Verdict</th><th>Runtime</th><th>Upload</th><th></th></tr>
+{{range .Records}}<tr><td>{{or .Input "-"}}</td>
+<td class="{{.Result}}">{{.Result}}{{with .Failure}}: {{.}}{{end}}</td>
+<td>{{printf "%.1f" .Runtime}}s</td><td>{{.UploadStatus}}</td>
+<td><a href="/measurement?id={{.ID}}">JSON</a></td></tr>
+{{end}}</table>
+</body></html>`))
@@ -0,0 +1,110 @@
+package libminiooni
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/measurementdb"
+)
+
+func TestGroupRuns(t *testing.T) {
+	start := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	record := func(id uint64, testName, reportID, result, upload string) measurementdb.Record {
+		return measurementdb.Record{
+			ID:           id,
+			ReportID:     reportID,
+			Result:       result,
+			StartTime:    start.Add(time.Duration(id) * time.Second),
+			TestName:     testName,
+			UploadStatus: upload,
+		}
+	}
+	runs := groupRuns([]measurementdb.Record{
+		record(1, "web_connectivity", "r1", measurementdb.ResultOK, measurementdb.UploadSucceeded),
+		record(2, "telegram", "r2", measurementdb.ResultAnomaly, measurementdb.UploadSucceeded),
+		// the records of the same report may be interleaved with other ones
+		record(3, "web_connectivity", "r1", measurementdb.ResultAnomaly, measurementdb.UploadFailed),
+		// without a report, we group the consecutive records of the same experiment
+		record(4, "dash", "", measurementdb.ResultOK, measurementdb.UploadNotAttempted),
+		record(5, "dash", "", measurementdb.ResultFailure, measurementdb.UploadNotAttempted),
+		record(6, "ndt", "", measurementdb.ResultOK, measurementdb.UploadNotAttempted),
+		record(7, "dash", "", measurementdb.ResultOK, measurementdb.UploadNotAttempted),
+	})
+	expected := []struct {
+		id        uint64
+		testName  string
+		records   int
+		anomalies int
+		failures  int
+		uploaded  int
+	}{
+		{7, "dash", 1, 0, 0, 0},
+		{6, "ndt", 1, 0, 0, 0},
+		{4, "dash", 2, 0, 1, 0},
+		{2, "telegram", 1, 1, 0, 1},
+		{1, "web_connectivity", 2, 1, 0, 1},
+	}
+	if len(runs) != len(expected) {
+		t.Fatal("unexpected number of runs", len(runs))
+	}
+	for idx, exp := range expected {
+		current := runs[idx]
+		if current.ID != exp.id || current.TestName != exp.testName ||
+			len(current.Records) != exp.records || current.Anomalies != exp.anomalies ||
+			current.Failures != exp.failures || current.Uploaded != exp.uploaded {
+			t.Fatalf("unexpected run #%d: %+v", idx, current)
+		}
+		if !current.StartTime.Equal(current.Records[0].StartTime) {
+			t.Fatalf("unexpected start time of run #%d", idx)
+		}
+	}
+}
+
+func TestGroupRunsEmpty(t *testing.T) {
+	if runs := groupRuns(nil); len(runs) != 0 {
+		t.Fatal("expected no runs", runs)
+	}
+}
+
+func TestServeHandlerWithDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniprobe-engine-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := measurementdb.Open(filepath.Join(dir, "measurements.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	id, err := db.Add(measurementdb.Record{TestName: "example", StartTime: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handler must not open the database again, which would block
+	handler := &serveHandler{db: db, dbPath: filepath.Join(dir, "nonexistent", "db")}
+	get := func(URL string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", URL, nil))
+		return w
+	}
+	if w := get("/"); w.Code != 200 || !strings.Contains(w.Body.String(), "example") {
+		t.Fatal("unexpected response", w.Code, w.Body.String())
+	}
+	// we did not save the measurement
+	if w := get(fmt.Sprintf("/measurement?id=%d", id)); w.Code != 404 {
+		t.Fatal("unexpected response", w.Code, w.Body.String())
+	}
+	if err := db.PutMeasurement(id, []byte(`{"test_name":"example"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(fmt.Sprintf("/measurement?id=%d", id)); w.Code != 200 {
+		t.Fatal("unexpected response", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,126 @@
+package logx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+// Capturer is a model.FieldLogger that forwards messages to another
+// logger and keeps the most recent messages at or above a given level
+// in memory, so that we can include them into bug reports.
+type Capturer struct {
+	fields model.LogFields
+	inner  model.Logger
+	level  log.Level
+	ring   *ring
+}
+
+// NewCapturer returns a Capturer forwarding to logger and keeping the
+// most recent size lines at or above level. The size must be positive.
+func NewCapturer(logger model.Logger, level log.Level, size int) *Capturer {
+	return &Capturer{
+		inner: logger, level: level, ring: &ring{lines: make([]string, size)}}
+}
+
+// Lines returns the lines we have captured, oldest first. Each line
+// contains the time, the level, the message, and the fields, if any.
+func (c *Capturer) Lines() []string {
+	return c.ring.snapshot()
+}
+
+// WithFields implements model.FieldLogger.WithFields. The returned
+// logger shares the captured lines with c.
+func (c *Capturer) WithFields(fields model.LogFields) model.FieldLogger {
+	merged := make(model.LogFields)
+	for key, value := range c.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &Capturer{
+		fields: merged,
+		inner:  model.WithFields(c.inner, fields),
+		level:  c.level,
+		ring:   c.ring,
+	}
+}
+
+// Debug implements model.Logger.Debug.
+func (c *Capturer) Debug(msg string) {
+	c.capture(log.DebugLevel, msg)
+	c.inner.Debug(msg)
+}
+
+// Debugf implements model.Logger.Debugf.
+func (c *Capturer) Debugf(format string, v ...interface{}) {
+	c.Debug(fmt.Sprintf(format, v...))
+}
+
+// Info implements model.Logger.Info.
+func (c *Capturer) Info(msg string) {
+	c.capture(log.InfoLevel, msg)
+	c.inner.Info(msg)
+}
+
+// Infof implements model.Logger.Infof.
+func (c *Capturer) Infof(format string, v ...interface{}) {
+	c.Info(fmt.Sprintf(format, v...))
+}
+
+// Warn implements model.Logger.Warn.
+func (c *Capturer) Warn(msg string) {
+	c.capture(log.WarnLevel, msg)
+	c.inner.Warn(msg)
+}
+
+// Warnf implements model.Logger.Warnf.
+func (c *Capturer) Warnf(format string, v ...interface{}) {
+	c.Warn(fmt.Sprintf(format, v...))
+}
+
+func (c *Capturer) capture(level log.Level, msg string) {
+	if level < c.level {
+		return
+	}
+	line := fmt.Sprintf("%s <%s> %s", time.Now().UTC().Format(time.RFC3339Nano), level, msg)
+	if len(c.fields) > 0 {
+		var pairs []string
+		for key, value := range c.fields {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", key, value))
+		}
+		sort.Strings(pairs)
+		line += " [" + strings.Join(pairs, " ") + "]"
+	}
+	c.ring.add(line)
+}
+
+type ring struct {
+	full  bool
+	lines []string
+	mu    sync.Mutex
+	next  int
+}
+
+func (r *ring) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	r.full = r.full || r.next == 0
+}
+
+func (r *ring) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]string{}, r.lines[:r.next]...)
+	}
+	return append(append([]string{}, r.lines[r.next:]...), r.lines[:r.next]...)
+}
@@ -0,0 +1,70 @@
+package logx_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/logx"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestCapturer(t *testing.T) {
+	var buf bytes.Buffer
+	capturer := logx.NewCapturer(logx.NewJSONLogger(&buf, log.InfoLevel), log.DebugLevel, 3)
+	if len(capturer.Lines()) != 0 {
+		t.Fatal("expected no lines")
+	}
+	capturer.Debugf("debug %d", 1)
+	capturer.Infof("info %d", 2)
+	lines := capturer.Lines()
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " <debug> debug 1") ||
+		!strings.HasSuffix(lines[1], " <info> info 2") {
+		t.Fatal("unexpected lines", lines)
+	}
+	session := model.WithComponent(capturer, "session")
+	session.Warnf("warn %d", 3)
+	model.WithFields(session, model.LogFields{"experiment": "ndt"}).Warn("warn 4")
+	lines = capturer.Lines()
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], " <info> info 2") {
+		t.Fatal("unexpected lines", lines)
+	}
+	if !strings.HasSuffix(lines[1], " <warn> warn 3 [component=session]") ||
+		!strings.HasSuffix(lines[2], " <warn> warn 4 [component=session experiment=ndt]") {
+		t.Fatal("unexpected lines", lines)
+	}
+	output := buf.String()
+	if strings.Contains(output, "debug 1") || !strings.Contains(output, `"experiment":"ndt"`) {
+		t.Fatal("unexpected forwarded messages", output)
+	}
+}
+
+func TestCapturerWrapsAround(t *testing.T) {
+	capturer := logx.NewCapturer(log.Log, log.DebugLevel, 4)
+	for i := 0; i < 10; i++ {
+		capturer.Debug(fmt.Sprintf("line %d", i))
+	}
+	lines := capturer.Lines()
+	if len(lines) != 4 {
+		t.Fatal("unexpected number of lines")
+	}
+	for idx, line := range lines {
+		if !strings.HasSuffix(line, fmt.Sprintf("line %d", idx+6)) {
+			t.Fatal("unexpected line", line)
+		}
+	}
+}
+
+func TestCapturerSkipsLinesBelowLevel(t *testing.T) {
+	capturer := logx.NewCapturer(log.Log, log.InfoLevel, 4)
+	capturer.Debug("debug")
+	capturer.Info("info")
+	model.WithComponent(capturer, "session").Warn("warn")
+	lines := capturer.Lines()
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " <info> info") ||
+		!strings.HasSuffix(lines[1], " <warn> warn [component=session]") {
+		t.Fatal("unexpected lines", lines)
+	}
+}
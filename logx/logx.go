@@ -0,0 +1,35 @@
+// Package logx contains logging extensions. Use NewApexLogger to attach
+// fields (see model.FieldLogger) to the messages emitted using an apex/log
+// logger, NewJSONLogger to emit messages as newline-delimited JSON, and
+// NewCapturer to keep the most recent messages in memory.
+package logx
+
+import (
+	"io"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/json"
+	"github.com/ooni/probe-engine/model"
+)
+
+type apexLogger struct {
+	log.Interface
+}
+
+// NewApexLogger returns a model.FieldLogger using logger, which passes
+// the fields to its handler (see log.Entry.Fields).
+func NewApexLogger(logger log.Interface) model.FieldLogger {
+	return apexLogger{Interface: logger}
+}
+
+// WithFields implements model.FieldLogger.WithFields.
+func (l apexLogger) WithFields(fields model.LogFields) model.FieldLogger {
+	return apexLogger{Interface: l.Interface.WithFields(log.Fields(fields))}
+}
+
+// NewJSONLogger returns a model.FieldLogger writing to w each message at or
+// above level as a JSON object on its own line, containing the "fields",
+// "level", "timestamp", and "message" keys.
+func NewJSONLogger(w io.Writer, level log.Level) model.FieldLogger {
+	return NewApexLogger(&log.Logger{Handler: json.New(w), Level: level})
+}
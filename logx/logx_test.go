@@ -0,0 +1,47 @@
+package logx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/logx"
+	"github.com/ooni/probe-engine/model"
+)
+
+type jsonEntry struct {
+	Fields  map[string]interface{} `json:"fields"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logx.NewJSONLogger(&buf, log.InfoLevel)
+	logger.Debug("not emitted")
+	session := model.WithComponent(logger, "session")
+	session.Infof("using %s", "probe services")
+	model.WithFields(session, model.LogFields{"experiment": "ndt"}).Warn("slow")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("unexpected number of lines", lines)
+	}
+	var entries []jsonEntry
+	for _, line := range lines {
+		var entry jsonEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if entries[0].Level != "info" || entries[0].Message != "using probe services" ||
+		entries[0].Fields[model.LogFieldComponent] != "session" || len(entries[0].Fields) != 1 {
+		t.Fatal("unexpected first entry", entries[0])
+	}
+	if entries[1].Level != "warn" || entries[1].Fields[model.LogFieldComponent] != "session" ||
+		entries[1].Fields["experiment"] != "ndt" {
+		t.Fatal("unexpected second entry", entries[1])
+	}
+}
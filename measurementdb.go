@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/ooni/probe-engine/measurementdb"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+// ErrNoMeasurementDB indicates that the session has no measurement database.
+var ErrNoMeasurementDB = errors.New("engine: no measurement database")
+
+// maxPendingRecords is the maximum number of records whose ID we remember
+// while waiting for the submission of their measurement. When we exceed
+// it, e.g., because the caller does not submit measurements, we forget
+// the oldest records, whose upload status thus remains unknown.
+const maxPendingRecords = 64
+
+// pendingRecord is a record waiting for the submission of its measurement.
+type pendingRecord struct {
+	id          uint64
+	measurement *model.Measurement
+}
+
+// recordMeasurement adds the metadata of measurement to the session's
+// measurement database, if any, along with the measurement itself, if the
+// session is configured to save it. We remember the ID of the record, so
+// that we can update its upload status when submitting measurement.
+func (e *Experiment) recordMeasurement(measurement *model.Measurement, anomaly bool, err error) {
+	db := e.session.measurementDB
+	if db == nil {
+		return
+	}
+	record := measurementdb.Record{
+		Input:     string(measurement.Input),
+		Result:    measurementdb.ResultOK,
+		Runtime:   measurement.MeasurementRuntime,
+		StartTime: measurement.MeasurementStartTimeSaved,
+		TestName:  e.testName,
+	}
+	switch {
+	case err != nil:
+		record.Failure, record.Result = err.Error(), measurementdb.ResultFailure
+	case anomaly:
+		record.Result = measurementdb.ResultAnomaly
+	}
+	id, err := db.Add(record)
+	if err != nil {
+		e.logger().Warnf("measurementdb: cannot add record: %s", err.Error())
+		return
+	}
+	e.maybeSaveMeasurement(db, id, measurement)
+	e.recordsMu.Lock()
+	defer e.recordsMu.Unlock()
+	if len(e.records) >= maxPendingRecords {
+		e.records = e.records[1:]
+	}
+	e.records = append(e.records, pendingRecord{id: id, measurement: measurement})
+}
+
+// recordSubmission updates the upload status of measurement in the
+// session's measurement database, if any, according to err.
+func (e *Experiment) recordSubmission(measurement *model.Measurement, err error) {
+	db := e.session.measurementDB
+	if db == nil {
+		return
+	}
+	id, found := e.popRecord(measurement)
+	if !found {
+		return // e.g., loaded using LoadMeasurement
+	}
+	status := measurementdb.UploadSucceeded
+	switch {
+	case errors.Is(err, probeservices.ErrMeasurementQueued):
+		status = measurementdb.UploadQueued
+	case err != nil:
+		status = measurementdb.UploadFailed
+	}
+	if err := db.SetUploadStatus(id, status, measurement.ReportID); err != nil {
+		e.logger().Warnf("measurementdb: cannot update record: %s", err.Error())
+	}
+	e.maybeSaveMeasurement(db, id, measurement) // the submission set the report ID
+}
+
+// popRecord returns the ID of the record of measurement, if we
+// remember it, and forgets about such record.
+func (e *Experiment) popRecord(measurement *model.Measurement) (uint64, bool) {
+	e.recordsMu.Lock()
+	defer e.recordsMu.Unlock()
+	for idx, record := range e.records {
+		if record.measurement == measurement {
+			e.records = append(e.records[:idx], e.records[idx+1:]...)
+			return record.id, true
+		}
+	}
+	return 0, false
+}
+
+// maybeSaveMeasurement saves measurement into db, if the session
+// is configured to save the measurements into the database.
+func (e *Experiment) maybeSaveMeasurement(
+	db *measurementdb.DB, id uint64, measurement *model.Measurement) {
+	if !e.session.measurementDBSave {
+		return
+	}
+	var buf bytes.Buffer
+	err := measurement.WriteJSON(&buf)
+	if err == nil {
+		err = db.PutMeasurement(id, buf.Bytes())
+	}
+	if err != nil {
+		e.logger().Warnf("measurementdb: cannot save measurement: %s", err.Error())
+	}
+}
+
+// QueryMeasurements returns the records in the session's measurement
+// database matching query or an error if we do not have a database.
+func (s *Session) QueryMeasurements(query measurementdb.Query) ([]measurementdb.Record, error) {
+	if s.measurementDB == nil {
+		return nil, ErrNoMeasurementDB
+	}
+	return s.measurementDB.Query(query)
+}
@@ -0,0 +1,264 @@
+// Package measurementdb contains a local database of the measurements
+// performed by the engine. We only store the metadata of each measurement
+// (e.g., test name, input, whether it is an anomaly, and whether we have
+// uploaded it), so that apps can build result screens without parsing
+// the measurements. Configure the session with a database using the
+// SessionConfig.MeasurementDB field and query it using DB.Query. You
+// may also store the measurements themselves (see PutMeasurement).
+//
+// The database is backed by bbolt. We index the records by start time,
+// so that querying for a specific date range is cheap.
+package measurementdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Psiphon-Labs/bolt"
+)
+
+const (
+	// ResultAnomaly indicates that the measurement shows signs
+	// of network interference.
+	ResultAnomaly = "anomaly"
+
+	// ResultFailure indicates that the measurement failed.
+	ResultFailure = "failure"
+
+	// ResultOK indicates that the measurement succeeded and does
+	// not show signs of network interference.
+	ResultOK = "ok"
+)
+
+const (
+	// UploadFailed indicates that we could not upload the measurement.
+	UploadFailed = "failed"
+
+	// UploadNotAttempted indicates that we have not tried to
+	// upload the measurement yet.
+	UploadNotAttempted = "not_attempted"
+
+	// UploadQueued indicates that we could not reach the collector
+	// and we have queued the measurement for a later upload.
+	UploadQueued = "queued"
+
+	// UploadSucceeded indicates that we uploaded the measurement.
+	UploadSucceeded = "succeeded"
+)
+
+// ErrNoSuchRecord indicates that a record does not exist.
+var ErrNoSuchRecord = errors.New("measurementdb: no such record")
+
+var (
+	// measurementsBucket maps the ID of a record to its measurement.
+	measurementsBucket = []byte("measurements")
+
+	// recordsBucket maps the time key of a record to the record.
+	recordsBucket = []byte("records")
+
+	// timeKeysBucket maps the ID of a record to its time key.
+	timeKeysBucket = []byte("time_keys")
+)
+
+// openTimeout is the time we wait for the lock on the database.
+const openTimeout = 5 * time.Second
+
+// Record contains the metadata of a measurement. Failure is the error
+// that occurred when measuring, if any. See the Result and Upload
+// constants for the values of Result and UploadStatus.
+type Record struct {
+	Failure      string    `json:"failure,omitempty"`
+	ID           uint64    `json:"id"`
+	Input        string    `json:"input,omitempty"`
+	ReportID     string    `json:"report_id,omitempty"`
+	Result       string    `json:"result"`
+	Runtime      float64   `json:"runtime"`
+	StartTime    time.Time `json:"start_time"`
+	TestName     string    `json:"test_name"`
+	UploadStatus string    `json:"upload_status"`
+}
+
+// Query selects records. Empty fields match all the records. The records
+// match when their StartTime is in [Since, Until).
+type Query struct {
+	Result       string
+	Since        time.Time
+	TestName     string
+	Until        time.Time
+	UploadStatus string
+}
+
+func (q Query) matches(record *Record) bool {
+	return (q.Result == "" || q.Result == record.Result) &&
+		(q.TestName == "" || q.TestName == record.TestName) &&
+		(q.UploadStatus == "" || q.UploadStatus == record.UploadStatus)
+}
+
+// DB is the local measurement database. It is safe to use it
+// from several goroutines.
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database at path. You should call Close
+// when done using the database.
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{measurementsBucket, recordsBucket, timeKeysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Add adds record to the database and returns its ID. We ignore
+// the ID of record, since it is the database that assigns it. We set
+// the UploadStatus to UploadNotAttempted if it is empty.
+func (d *DB) Add(record Record) (id uint64, err error) {
+	if record.UploadStatus == "" {
+		record.UploadStatus = UploadNotAttempted
+	}
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		if id, err = records.NextSequence(); err != nil {
+			return err
+		}
+		record.ID = id
+		return put(tx, &record)
+	})
+	return
+}
+
+// Get returns the record with the given ID.
+func (d *DB) Get(id uint64) (record *Record, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		record, err = get(tx, id)
+		return err
+	})
+	return
+}
+
+// SetUploadStatus updates the upload status and the report ID of the
+// record with the given ID.
+func (d *DB) SetUploadStatus(id uint64, status, reportID string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		record, err := get(tx, id)
+		if err != nil {
+			return err
+		}
+		record.ReportID, record.UploadStatus = reportID, status
+		return put(tx, record)
+	})
+}
+
+// PutMeasurement stores the serialized measurement of the record with
+// the given ID, replacing the measurement we stored previously, if any.
+func (d *DB) PutMeasurement(id uint64, data []byte) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(measurementsBucket).Put(idKey(id), data)
+	})
+}
+
+// GetMeasurement returns the serialized measurement of the record
+// with the given ID, or ErrNoSuchRecord if we did not store it.
+func (d *DB) GetMeasurement(id uint64) (data []byte, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(measurementsBucket).Get(idKey(id))
+		if value == nil {
+			return ErrNoSuchRecord
+		}
+		data = append([]byte{}, value...) // only valid within the transaction
+		return nil
+	})
+	return
+}
+
+// Query returns the records matching q sorted by StartTime.
+func (d *DB) Query(q Query) (out []Record, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(recordsBucket).Cursor()
+		key, value := cursor.First()
+		if !q.Since.IsZero() {
+			key, value = cursor.Seek(timeKey(q.Since, 0))
+		}
+		for ; key != nil; key, value = cursor.Next() {
+			var record Record
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if !q.Until.IsZero() && !record.StartTime.Before(q.Until) {
+				break // the records are sorted by time
+			}
+			if q.matches(&record) {
+				out = append(out, record)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// timeKey returns the key of a record, which sorts by start time
+// first and by ID next, since several records may have the same
+// start time. We assume that start times are after 1970.
+func timeKey(t time.Time, id uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], id)
+	return key
+}
+
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func get(tx *bolt.Tx, id uint64) (*Record, error) {
+	key := tx.Bucket(timeKeysBucket).Get(idKey(id))
+	if key == nil {
+		return nil, ErrNoSuchRecord
+	}
+	value := tx.Bucket(recordsBucket).Get(key)
+	if value == nil {
+		return nil, ErrNoSuchRecord
+	}
+	var record Record
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func put(tx *bolt.Tx, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := timeKey(record.StartTime, record.ID)
+	if err := tx.Bucket(recordsBucket).Put(key, data); err != nil {
+		return err
+	}
+	return tx.Bucket(timeKeysBucket).Put(idKey(record.ID), key)
+}
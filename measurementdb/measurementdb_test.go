@@ -0,0 +1,200 @@
+package measurementdb_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/measurementdb"
+)
+
+func openDB(t *testing.T) *measurementdb.DB {
+	dir, err := ioutil.TempDir("", "measurementdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := measurementdb.Open(filepath.Join(dir, "measurements.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var day = time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+
+func addRecords(t *testing.T, db *measurementdb.DB) {
+	for _, record := range []measurementdb.Record{{
+		Input:     "https://www.example.com/",
+		Result:    measurementdb.ResultAnomaly,
+		StartTime: day.Add(2 * time.Hour),
+		TestName:  "web_connectivity",
+	}, {
+		Result:    measurementdb.ResultOK,
+		StartTime: day.Add(time.Hour),
+		TestName:  "ndt",
+	}, {
+		Failure:   "generic_timeout_error",
+		Result:    measurementdb.ResultFailure,
+		StartTime: day.Add(26 * time.Hour),
+		TestName:  "web_connectivity",
+	}} {
+		if _, err := db.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAddAndGet(t *testing.T) {
+	db := openDB(t)
+	id, err := db.Add(measurementdb.Record{
+		ID:        1234, // ignored
+		Result:    measurementdb.ResultOK,
+		StartTime: day,
+		TestName:  "ndt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := db.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ID != id || record.TestName != "ndt" || !record.StartTime.Equal(day) {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+	if record.UploadStatus != measurementdb.UploadNotAttempted {
+		t.Fatal("unexpected upload status")
+	}
+	if _, err := db.Get(id + 1); !errors.Is(err, measurementdb.ErrNoSuchRecord) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := openDB(t)
+	addRecords(t, db)
+	all, err := db.Query(measurementdb.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].TestName != "ndt" || all[2].Result != measurementdb.ResultFailure {
+		t.Fatalf("unexpected records: %+v", all)
+	}
+	firstDay, err := db.Query(measurementdb.Query{Since: day, Until: day.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(firstDay) != 2 {
+		t.Fatalf("unexpected records: %+v", firstDay)
+	}
+	anomalies, err := db.Query(measurementdb.Query{
+		Result:   measurementdb.ResultAnomaly,
+		TestName: "web_connectivity",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Input != "https://www.example.com/" {
+		t.Fatalf("unexpected records: %+v", anomalies)
+	}
+	since, err := db.Query(measurementdb.Query{Since: day.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 2 || since[0].Result != measurementdb.ResultAnomaly {
+		t.Fatalf("unexpected records: %+v", since)
+	}
+}
+
+func TestSetUploadStatus(t *testing.T) {
+	db := openDB(t)
+	addRecords(t, db)
+	if err := db.SetUploadStatus(2, measurementdb.UploadSucceeded, "20200901T010000Z_ndt_IT_30722_n1_x"); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := db.Query(measurementdb.Query{UploadStatus: measurementdb.UploadSucceeded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 1 || uploaded[0].ID != 2 || uploaded[0].ReportID == "" {
+		t.Fatalf("unexpected records: %+v", uploaded)
+	}
+	all, err := db.Query(measurementdb.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatal("updating a record should not duplicate it")
+	}
+	if err := db.SetUploadStatus(100, measurementdb.UploadFailed, ""); !errors.Is(
+		err, measurementdb.ErrNoSuchRecord) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestMeasurements(t *testing.T) {
+	db := openDB(t)
+	addRecords(t, db)
+	if _, err := db.GetMeasurement(1); !errors.Is(err, measurementdb.ErrNoSuchRecord) {
+		t.Fatal("not the error we expected", err)
+	}
+	for _, data := range []string{`{"test_name":"ndt"}`, `{"test_name":"ndt","report_id":"x"}`} {
+		if err := db.PutMeasurement(2, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetMeasurement(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Fatal("unexpected measurement", string(got))
+		}
+	}
+	if err := db.PutMeasurement(100, []byte(`{}`)); !errors.Is(err, measurementdb.ErrNoSuchRecord) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measurementdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "measurements.db")
+	db, err := measurementdb.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addRecords(t, db)
+	db.Close()
+	if db, err = measurementdb.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	all, err := db.Query(measurementdb.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatal("unexpected number of records")
+	}
+	id, err := db.Add(measurementdb.Record{StartTime: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Fatal("we reused an ID", id)
+	}
+}
+
+func TestOpenFailure(t *testing.T) {
+	if _, err := measurementdb.Open("/nonexistent/measurements.db"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
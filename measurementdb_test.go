@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/measurementdb"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func TestSessionRecordsMeasurements(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measurementdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := measurementdb.Open(filepath.Join(dir, "measurements.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if _, err := sess.QueryMeasurements(measurementdb.Query{}); !errors.Is(err, ErrNoMeasurementDB) {
+		t.Fatal("not the error we expected")
+	}
+	sess.measurementDB = db
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	good := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "example",
+	))
+	measurement, err := good.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{ReturnError: true, SleepTime: int64(time.Millisecond)}, "example",
+	))
+	if _, err := bad.Measure(""); err == nil {
+		t.Fatal("expected an error here")
+	}
+	anomalous := NewExperiment(sess, anomalousMeasurer{example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "anomalous",
+	)})
+	if _, err := anomalous.Measure(""); err != nil {
+		t.Fatal(err)
+	}
+	measurement.ReportID = "_id"
+	good.recordSubmission(measurement, probeservices.ErrMeasurementQueued)
+	records, err := sess.QueryMeasurements(measurementdb.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatal("unexpected number of records")
+	}
+	if records[0].Result != measurementdb.ResultOK || records[0].UploadStatus != measurementdb.UploadQueued {
+		t.Fatalf("unexpected record: %+v", records[0])
+	}
+	if records[1].Result != measurementdb.ResultFailure || records[1].Failure == "" {
+		t.Fatalf("unexpected record: %+v", records[1])
+	}
+	if records[2].Result != measurementdb.ResultAnomaly || records[2].TestName != "anomalous" {
+		t.Fatalf("unexpected record: %+v", records[2])
+	}
+	if len(good.records) != 0 {
+		t.Fatal("we did not forget the submitted measurement")
+	}
+	if _, err := db.GetMeasurement(records[0].ID); !errors.Is(err, measurementdb.ErrNoSuchRecord) {
+		t.Fatal("we should not save the measurements by default", err)
+	}
+
+	sess.measurementDBSave = true
+	measurement, err = good.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	measurement.ReportID = "_id"
+	good.recordSubmission(measurement, nil)
+	records, err = sess.QueryMeasurements(measurementdb.Query{})
+	if err != nil || len(records) != 4 {
+		t.Fatal("unexpected records", records, err)
+	}
+	data, err := db.GetMeasurement(records[3].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved model.Measurement
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.ReportID != "_id" || saved.TestName != "example" {
+		t.Fatalf("unexpected measurement: %+v", saved)
+	}
+}
+
+func TestExperimentForgetsOldestPendingRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measurementdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := measurementdb.Open(filepath.Join(dir, "measurements.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.measurementDB = db
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "example",
+	))
+	first, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxPendingRecords; i++ {
+		if _, err := exp.Measure(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(exp.records) != maxPendingRecords {
+		t.Fatal("unexpected number of pending records")
+	}
+	if _, found := exp.popRecord(first); found {
+		t.Fatal("we did not forget the oldest record")
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+const (
+	// BootstrapBackend labels the time it took to select the probe services.
+	BootstrapBackend = "backend"
+
+	// BootstrapTunnel labels the time it took to bootstrap a tunnel.
+	BootstrapTunnel = "tunnel"
+)
+
+var (
+	// BootstrapBuckets are the buckets of Engine.BootstrapDuration.
+	BootstrapBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+	// ResolverBuckets are the buckets of Engine.ResolverLatency.
+	ResolverBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+)
+
+// Engine contains the metrics updated by the engine. All the sessions
+// sharing an Engine update the same metrics.
+type Engine struct {
+	// Anomalies counts the measurements showing signs of network
+	// interference, by experiment name.
+	Anomalies *Counter
+
+	// BootstrapDuration tracks how long it takes to bootstrap, by
+	// BootstrapBackend or BootstrapTunnel.
+	BootstrapDuration *Histogram
+
+	// Measurements counts the measurements run, by experiment name
+	// and by whether they failed.
+	Measurements *Counter
+
+	// Registry contains all the metrics above.
+	Registry *Registry
+
+	// ResolverLatency tracks the latency of the session resolver lookups,
+	// by resolver, i.e., "doh" or "system", and by whether they failed.
+	ResolverLatency *Histogram
+
+	// SubmissionsFailed counts the measurements that we could not
+	// submit to the collector, by experiment name.
+	SubmissionsFailed *Counter
+}
+
+// New creates the engine metrics.
+func New() *Engine {
+	r := NewRegistry()
+	return &Engine{
+		Anomalies: r.NewCounter("ooni_anomalies_total",
+			"Measurements showing signs of network interference.", "experiment"),
+		BootstrapDuration: r.NewHistogram("ooni_bootstrap_duration_seconds",
+			"Time it took to bootstrap.", BootstrapBuckets, "kind"),
+		Measurements: r.NewCounter("ooni_measurements_total",
+			"Measurements run.", "experiment", "failed"),
+		Registry: r,
+		ResolverLatency: r.NewHistogram("ooni_resolver_latency_seconds",
+			"Latency of the session resolver.", ResolverBuckets, "resolver", "failed"),
+		SubmissionsFailed: r.NewCounter("ooni_submissions_failed_total",
+			"Measurements we could not submit.", "experiment"),
+	}
+}
@@ -0,0 +1,231 @@
+// Package metrics exposes counters and histograms describing the health
+// of the engine using the Prometheus text format, so that the operators of
+// a fleet of probes can scrape them. Create the engine metrics using New,
+// configure sessions to update them using the SessionConfig.Prometheus
+// field, and expose them using Listen.
+//
+// We do not depend on the Prometheus client library because we only need
+// a small subset of it. See https://prometheus.io/docs/instrumenting/exposition_formats/.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry contains metric families.
+type Registry struct {
+	families []*family
+	mu       sync.Mutex
+}
+
+// NewRegistry creates a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers and returns a new counter called name, whose series
+// are identified by values for the given labels.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.register(name, help, "counter", nil, labels)}
+}
+
+// NewHistogram is like NewCounter but for a histogram with the given upper
+// bounds for its buckets, which must be sorted in increasing order.
+func (r *Registry) NewHistogram(
+	name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{f: r.register(name, help, "histogram", buckets, labels)}
+}
+
+func (r *Registry) register(
+	name, help, kind string, buckets []float64, labels []string) *family {
+	f := &family{
+		buckets: buckets,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		name:    name,
+		series:  make(map[string]*series),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+	return f
+}
+
+// WriteTo writes all the metrics to w using the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family{}, r.families...)
+	r.mu.Unlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.writeTo(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+// Counter is a metric that only goes up.
+type Counter struct {
+	f *family
+}
+
+// Add adds value, which must not be negative, to the series identified by
+// labelValues, which must be as many as the counter's labels.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.f.update(labelValues, func(s *series) {
+		s.sum += value
+	})
+}
+
+// Inc is like Add with a value of one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Histogram is a metric that counts observations in buckets.
+type Histogram struct {
+	f *family
+}
+
+// Observe adds value to the series identified by labelValues, which
+// must be as many as the histogram's labels.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.f.update(labelValues, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+		for idx, bound := range h.f.buckets {
+			if value <= bound {
+				s.counts[idx]++
+			}
+		}
+		s.count++
+		s.sum += value
+	})
+}
+
+type family struct {
+	buckets []float64
+	help    string
+	kind    string
+	labels  []string
+	mu      sync.Mutex
+	name    string
+	series  map[string]*series
+}
+
+// series is a counter, in which case we only use sum, or a histogram,
+// in which case counts contains the cumulative count of each bucket.
+type series struct {
+	count       uint64
+	counts      []uint64
+	labelValues []string
+	sum         float64
+}
+
+func (f *family) update(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s: expected %d label values", f.name, len(f.labels)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, found := f.series[key]
+	if !found {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+func (f *family) writeTo(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind == "counter" {
+			f.writeSample(w, "", s.labelValues, "", s.sum)
+			continue
+		}
+		for idx, bound := range f.buckets {
+			var count uint64
+			if s.counts != nil {
+				count = s.counts[idx]
+			}
+			f.writeSample(w, "_bucket", s.labelValues, formatFloat(bound), float64(count))
+		}
+		f.writeSample(w, "_bucket", s.labelValues, "+Inf", float64(s.count))
+		f.writeSample(w, "_sum", s.labelValues, "", s.sum)
+		f.writeSample(w, "_count", s.labelValues, "", float64(s.count))
+	}
+}
+
+func (f *family) writeSample(
+	w *bufio.Writer, suffix string, labelValues []string, le string, value float64) {
+	var pairs []string
+	for idx, label := range f.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, escapeLabelValue(labelValues[idx])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=\"%s\"", le))
+	}
+	w.WriteString(f.name + suffix)
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+type countingWriter struct {
+	n int64
+	w io.Writer
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ooni/probe-engine/metrics"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := metrics.NewRegistry()
+	counter := r.NewCounter("ooni_things_total", "Things.\nMany of them.", "name")
+	counter.Inc("b")
+	counter.Add(2.5, "a\"\\\n")
+	counter.Inc("b")
+	histogram := r.NewHistogram("ooni_latency_seconds", "Latency.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(7)
+	r.NewCounter("ooni_empty_total", "Empty.")
+	var sb strings.Builder
+	n, err := r.WriteTo(&sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP ooni_things_total Things.\nMany of them.
+# TYPE ooni_things_total counter
+ooni_things_total{name="a\"\\\n"} 2.5
+ooni_things_total{name="b"} 2
+# HELP ooni_latency_seconds Latency.
+# TYPE ooni_latency_seconds histogram
+ooni_latency_seconds_bucket{le="0.1"} 1
+ooni_latency_seconds_bucket{le="1"} 2
+ooni_latency_seconds_bucket{le="+Inf"} 3
+ooni_latency_seconds_sum 7.55
+ooni_latency_seconds_count 3
+# HELP ooni_empty_total Empty.
+# TYPE ooni_empty_total counter
+`
+	if sb.String() != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, sb.String())
+	}
+	if n != int64(len(expected)) {
+		t.Fatal("unexpected number of bytes written")
+	}
+}
+
+func TestWrongNumberOfLabels(t *testing.T) {
+	counter := metrics.NewRegistry().NewCounter("ooni_things_total", "Things.", "name")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic here")
+		}
+	}()
+	counter.Inc()
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("mocked error")
+}
+
+func TestWriteToError(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("ooni_things_total", "Things.")
+	if _, err := r.WriteTo(failingWriter{}); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestListen(t *testing.T) {
+	m := metrics.New()
+	m.Measurements.Inc("example", "false")
+	m.BootstrapDuration.Observe(1.5, metrics.BootstrapBackend)
+	srv, err := metrics.Listen("127.0.0.1:0", m.Registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	resp, err := http.Get("http://" + srv.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+	if !strings.Contains(body, `ooni_measurements_total{experiment="example",failed="false"} 1`) {
+		t.Fatal("missing measurements counter", body)
+	}
+	if !strings.Contains(body, `ooni_bootstrap_duration_seconds_bucket{kind="backend",le="2.5"} 1`) {
+		t.Fatal("missing bootstrap histogram", body)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatal("unexpected content type")
+	}
+}
+
+func TestListenError(t *testing.T) {
+	if _, err := metrics.Listen("127.0.0.1:-1", metrics.NewRegistry()); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+)
+
+// Server serves metrics over HTTP.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen listens on address (e.g. "127.0.0.1:9100") and serves the metrics
+// in registry at /metrics in a background goroutine until you call Close.
+func Listen(address string, registry *Registry) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	srv := &Server{listener: listener, server: &http.Server{Handler: mux}}
+	go srv.server.Serve(listener)
+	return srv, nil
+}
+
+// Addr returns the address we are listening on.
+func (srv *Server) Addr() net.Addr {
+	return srv.listener.Addr()
+}
+
+// Close stops serving metrics.
+func (srv *Server) Close() error {
+	return srv.server.Close()
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ErrMeasurementDropped indicates that an ExperimentMiddleware has
+// dropped a measurement, which we have therefore not submitted.
+var ErrMeasurementDropped = errors.New("middleware: measurement dropped")
+
+// ExperimentMiddleware allows embedders to hook into the lifecycle of the
+// measurements performed by an Experiment, e.g., to implement custom logging,
+// to drop measurements, or to mirror submissions to a private collector. Embed
+// NopExperimentMiddleware to only implement some of the methods.
+type ExperimentMiddleware interface {
+	// BeforeMeasurement is called before measuring input. If it
+	// returns an error, we do not measure input and MeasureWithContext
+	// returns this error and a nil measurement.
+	BeforeMeasurement(ctx context.Context, input string) error
+
+	// AfterMeasurement is called after measuring with the measurement
+	// and the error that MeasureWithContext is about to return.
+	AfterMeasurement(measurement *model.Measurement, err error)
+
+	// OnSubmit is called before submitting a measurement, after we have
+	// set its report ID and its backend_channel annotation. If it returns
+	// an error, we do not submit the measurement and we return the error.
+	// Return ErrMeasurementDropped to indicate that you have intentionally
+	// dropped the measurement.
+	OnSubmit(ctx context.Context, measurement *model.Measurement) error
+}
+
+// NopExperimentMiddleware is an ExperimentMiddleware that does nothing.
+type NopExperimentMiddleware struct{}
+
+// BeforeMeasurement implements ExperimentMiddleware.BeforeMeasurement.
+func (NopExperimentMiddleware) BeforeMeasurement(ctx context.Context, input string) error {
+	return nil
+}
+
+// AfterMeasurement implements ExperimentMiddleware.AfterMeasurement.
+func (NopExperimentMiddleware) AfterMeasurement(measurement *model.Measurement, err error) {}
+
+// OnSubmit implements ExperimentMiddleware.OnSubmit.
+func (NopExperimentMiddleware) OnSubmit(ctx context.Context, measurement *model.Measurement) error {
+	return nil
+}
+
+var _ ExperimentMiddleware = NopExperimentMiddleware{}
+
+// Use registers middleware with the experiment. We call the middleware
+// in the same order in which they have been registered. This function is
+// not goroutine safe, so register middleware before measuring.
+func (e *Experiment) Use(middleware ...ExperimentMiddleware) {
+	e.middleware = append(e.middleware, middleware...)
+}
+
+func (e *Experiment) beforeMeasurement(ctx context.Context, input string) error {
+	for _, mw := range e.middleware {
+		if err := mw.BeforeMeasurement(ctx, input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Experiment) afterMeasurement(measurement *model.Measurement, err error) {
+	for _, mw := range e.middleware {
+		mw.AfterMeasurement(measurement, err)
+	}
+}
+
+func (e *Experiment) onSubmit(ctx context.Context, measurement *model.Measurement) error {
+	measurement.ReportID = e.report.ID
+	if channel := e.session.BackendChannel(); channel != "" {
+		measurement.AddAnnotation("backend_channel", channel)
+	}
+	for _, mw := range e.middleware {
+		if err := mw.OnSubmit(ctx, measurement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
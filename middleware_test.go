@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/model"
+)
+
+type recordingMiddleware struct {
+	NopExperimentMiddleware
+	before error
+	events []string
+	name   string
+	submit error
+}
+
+func (mw *recordingMiddleware) BeforeMeasurement(ctx context.Context, input string) error {
+	mw.events = append(mw.events, mw.name+".before:"+input)
+	return mw.before
+}
+
+func (mw *recordingMiddleware) AfterMeasurement(measurement *model.Measurement, err error) {
+	mw.events = append(mw.events, mw.name+".after:"+string(measurement.Input))
+}
+
+func (mw *recordingMiddleware) OnSubmit(ctx context.Context, measurement *model.Measurement) error {
+	mw.events = append(mw.events, mw.name+".submit:"+measurement.ReportID)
+	return mw.submit
+}
+
+func newMiddlewareTestExperiment(t *testing.T) (*Experiment, func()) {
+	filep, err := ioutil.TempFile("", "ooniprobe-engine-middleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filep.Close()
+	sess := newSessionForTestingNoLookups(t)
+	sess.dryRunFile = filep.Name()
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "example",
+	))
+	if err := exp.OpenReport(); err != nil {
+		t.Fatal(err)
+	}
+	return exp, func() {
+		exp.CloseReport()
+		sess.Close()
+		os.Remove(filep.Name())
+	}
+}
+
+func TestExperimentMiddlewareLifecycle(t *testing.T) {
+	exp, cleanup := newMiddlewareTestExperiment(t)
+	defer cleanup()
+	mw := &recordingMiddleware{name: "first"}
+	exp.Use(mw, NopExperimentMiddleware{})
+	measurement, err := exp.Measure("antani")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.SubmitAndUpdateMeasurement(measurement); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"first.before:antani",
+		"first.after:antani",
+		"first.submit:" + exp.ReportID(),
+	}
+	if diff := cmp.Diff(expected, mw.events); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestExperimentMiddlewareBeforeMeasurementFailure(t *testing.T) {
+	exp, cleanup := newMiddlewareTestExperiment(t)
+	defer cleanup()
+	expected := errors.New("mocked error")
+	first := &recordingMiddleware{name: "first", before: expected}
+	second := &recordingMiddleware{name: "second"}
+	exp.Use(first, second)
+	measurement, err := exp.Measure("antani")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if measurement != nil {
+		t.Fatal("expected nil measurement here")
+	}
+	if len(first.events) != 1 || len(second.events) != 0 {
+		t.Fatal("unexpected events")
+	}
+}
+
+func TestExperimentMiddlewareDropsMeasurement(t *testing.T) {
+	exp, cleanup := newMiddlewareTestExperiment(t)
+	defer cleanup()
+	exp.Use(&recordingMiddleware{name: "dropper", submit: ErrMeasurementDropped})
+	measurement, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.SubmitOrQueueMeasurement(measurement); !errors.Is(err, ErrMeasurementDropped) {
+		t.Fatal("not the error we expected")
+	}
+	data, err := ioutil.ReadFile(exp.session.dryRunFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "" {
+		t.Fatal("we should not have submitted the measurement")
+	}
+}
+
+func TestExperimentMiddlewareSeesBackendChannel(t *testing.T) {
+	exp, cleanup := newMiddlewareTestExperiment(t)
+	defer cleanup()
+	exp.session.backendChannel = "cloudfront"
+	measurement, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.SubmitAndUpdateMeasurement(measurement); err != nil {
+		t.Fatal(err)
+	}
+	if measurement.Annotations["backend_channel"] != "cloudfront" {
+		t.Fatal("unexpected backend_channel annotation")
+	}
+}
@@ -0,0 +1,167 @@
+// Package dataformat converts measurements between versions of the
+// OONI data format. We emit Version020 by default.
+//
+// Version030 differs from Version020 in the following ways:
+//
+// 1. the "status" object of each "tcp_connect" entry is flattened into
+// the entry, so that "blocked", "failure", and "success" sit next to
+// "ip" and "port", like the "failure" of all the other archival entries;
+//
+// 2. the values that may be binary, i.e., the HTTP bodies and the HTTP
+// header values in "requests", always have an explicit type, meaning that
+// text values are {"format":"text","data":...} objects just like binary
+// values are {"format":"base64","data":...} objects.
+//
+// The conversion works on the JSON representation of the test keys,
+// hence the converted measurement has generic test keys.
+package dataformat
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	// Version020 is the data format version we emit by default.
+	//
+	// See https://github.com/ooni/spec/tree/master/data-formats#history.
+	Version020 = "0.2.0"
+
+	// Version030 is the next data format version. Note that the
+	// collector does not know about it yet, hence the engine converts
+	// measurements back to Version020 before submitting them.
+	Version030 = "0.3.0"
+)
+
+// ErrUnsupportedVersion indicates that we do not know a version.
+var ErrUnsupportedVersion = errors.New("dataformat: unsupported version")
+
+// IsSupported returns whether we can emit the given version.
+func IsSupported(version string) bool {
+	return version == Version020 || version == Version030
+}
+
+// Convert converts m to version, unless m uses that version already.
+func Convert(m *model.Measurement, version string) error {
+	if !IsSupported(version) || !IsSupported(m.DataFormatVersion) {
+		return ErrUnsupportedVersion
+	}
+	if m.DataFormatVersion == version {
+		return nil
+	}
+	// Note that MakeGenericTestKeys is not enough because a map may
+	// contain typed values, e.g., []archival.RequestEntry.
+	data, err := json.Marshal(m.TestKeys)
+	if err != nil {
+		return err
+	}
+	var tk map[string]interface{}
+	if err := json.Unmarshal(data, &tk); err != nil {
+		return err
+	}
+	upgrade := version == Version030
+	if upgrade {
+		flattenTCPConnect(tk)
+	} else {
+		unflattenTCPConnect(tk)
+	}
+	setExplicitTypes(tk, upgrade)
+	m.TestKeys, m.DataFormatVersion = tk, version
+	return nil
+}
+
+// tcpConnectStatusKeys are the keys of the "status" object of a
+// Version020 "tcp_connect" entry.
+var tcpConnectStatusKeys = []string{"blocked", "failure", "success"}
+
+func tcpConnectEntries(tk map[string]interface{}) (out []map[string]interface{}) {
+	entries, _ := tk["tcp_connect"].([]interface{})
+	for _, entry := range entries {
+		if entry, ok := entry.(map[string]interface{}); ok {
+			out = append(out, entry)
+		}
+	}
+	return
+}
+
+func flattenTCPConnect(tk map[string]interface{}) {
+	for _, entry := range tcpConnectEntries(tk) {
+		status, ok := entry["status"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range tcpConnectStatusKeys {
+			if value, found := status[key]; found {
+				entry[key] = value
+			}
+		}
+		delete(entry, "status")
+	}
+}
+
+func unflattenTCPConnect(tk map[string]interface{}) {
+	for _, entry := range tcpConnectEntries(tk) {
+		if _, found := entry["status"]; found {
+			continue
+		}
+		status := make(map[string]interface{})
+		for _, key := range tcpConnectStatusKeys {
+			if value, found := entry[key]; found {
+				status[key] = value
+				delete(entry, key)
+			}
+		}
+		entry["status"] = status
+	}
+}
+
+// setExplicitTypes adds (if upgrade) or removes (otherwise) the explicit
+// type of the text values inside the "requests" entries.
+func setExplicitTypes(tk map[string]interface{}, upgrade bool) {
+	requests, _ := tk["requests"].([]interface{})
+	for _, entry := range requests {
+		entry, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"request", "response"} {
+			if rr, ok := entry[key].(map[string]interface{}); ok {
+				convertRequestOrResponse(rr, upgrade)
+			}
+		}
+	}
+}
+
+func convertRequestOrResponse(rr map[string]interface{}, upgrade bool) {
+	if body, found := rr["body"]; found {
+		rr["body"] = convertValue(body, upgrade)
+	}
+	if headers, ok := rr["headers"].(map[string]interface{}); ok {
+		for key, value := range headers {
+			headers[key] = convertValue(value, upgrade)
+		}
+	}
+	headersList, _ := rr["headers_list"].([]interface{})
+	for _, pair := range headersList {
+		if pair, ok := pair.([]interface{}); ok && len(pair) == 2 {
+			pair[1] = convertValue(pair[1], upgrade)
+		}
+	}
+}
+
+func convertValue(value interface{}, upgrade bool) interface{} {
+	if upgrade {
+		if s, ok := value.(string); ok {
+			return map[string]interface{}{"format": "text", "data": s}
+		}
+		return value
+	}
+	if object, ok := value.(map[string]interface{}); ok && object["format"] == "text" {
+		if s, ok := object["data"].(string); ok {
+			return s
+		}
+	}
+	return value
+}
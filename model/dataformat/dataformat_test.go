@@ -0,0 +1,147 @@
+package dataformat_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/model/dataformat"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+func newMeasurement() *model.Measurement {
+	failure := "connection_refused"
+	return &model.Measurement{
+		DataFormatVersion: dataformat.Version020,
+		TestKeys: map[string]interface{}{
+			"requests": []archival.RequestEntry{{
+				Request: archival.HTTPRequest{
+					Body: archival.HTTPBody{Value: ""},
+					Headers: map[string]archival.MaybeBinaryValue{
+						"User-Agent": {Value: "miniooni/0.1.0"},
+					},
+					HeadersList: []archival.HTTPHeader{{
+						Key:   "User-Agent",
+						Value: archival.MaybeBinaryValue{Value: "miniooni/0.1.0"},
+					}},
+					URL: "https://www.example.com/",
+				},
+				Response: archival.HTTPResponse{
+					Body: archival.HTTPBody{Value: "\xff\xfe"},
+					Code: 200,
+				},
+			}},
+			"tcp_connect": []archival.TCPConnectEntry{{
+				IP:   "93.184.216.34",
+				Port: 443,
+				Status: archival.TCPConnectStatus{
+					Failure: &failure,
+				},
+			}},
+		},
+	}
+}
+
+func toJSON(t *testing.T, v interface{}) (out map[string]interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestConvertToVersion030(t *testing.T) {
+	m := newMeasurement()
+	if err := dataformat.Convert(m, dataformat.Version030); err != nil {
+		t.Fatal(err)
+	}
+	if m.DataFormatVersion != dataformat.Version030 {
+		t.Fatal("not the version we expected")
+	}
+	var tk struct {
+		Requests []struct {
+			Request struct {
+				Body        map[string]interface{}            `json:"body"`
+				Headers     map[string]map[string]interface{} `json:"headers"`
+				HeadersList [][]interface{}                   `json:"headers_list"`
+			} `json:"request"`
+			Response struct {
+				Body map[string]interface{} `json:"body"`
+			} `json:"response"`
+		} `json:"requests"`
+		TCPConnect []map[string]interface{} `json:"tcp_connect"`
+	}
+	data, err := json.Marshal(m.TestKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &tk); err != nil {
+		t.Fatal(err)
+	}
+	request := tk.Requests[0].Request
+	if request.Body["format"] != "text" || request.Body["data"] != "" {
+		t.Fatal("request body has no explicit type")
+	}
+	if request.Headers["User-Agent"]["format"] != "text" {
+		t.Fatal("header has no explicit type")
+	}
+	if value, ok := request.HeadersList[0][1].(map[string]interface{}); !ok || value["format"] != "text" {
+		t.Fatal("headers list value has no explicit type")
+	}
+	if tk.Requests[0].Response.Body["format"] != "base64" {
+		t.Fatal("binary body is not base64")
+	}
+	entry := tk.TCPConnect[0]
+	if _, found := entry["status"]; found || entry["failure"] != "connection_refused" {
+		t.Fatal("tcp_connect entry not flattened", entry)
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	m := newMeasurement()
+	expected := toJSON(t, m)
+	if err := dataformat.Convert(m, dataformat.Version030); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataformat.Convert(m, dataformat.Version020); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expected, toJSON(t, m)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestConvertSameVersion(t *testing.T) {
+	m := newMeasurement()
+	m.TestKeys = &archival.TCPConnectEntry{}
+	if err := dataformat.Convert(m, dataformat.Version020); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.TestKeys.(*archival.TCPConnectEntry); !ok {
+		t.Fatal("expected the test keys to be unchanged")
+	}
+}
+
+func TestConvertUnsupportedVersion(t *testing.T) {
+	m := newMeasurement()
+	if err := dataformat.Convert(m, "0.1.0"); !errors.Is(err, dataformat.ErrUnsupportedVersion) {
+		t.Fatal("not the error we expected")
+	}
+	m.DataFormatVersion = ""
+	if err := dataformat.Convert(m, dataformat.Version030); !errors.Is(err, dataformat.ErrUnsupportedVersion) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConvertMarshalError(t *testing.T) {
+	m := newMeasurement()
+	m.TestKeys = func() {}
+	if err := dataformat.Convert(m, dataformat.Version030); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
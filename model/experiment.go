@@ -14,13 +14,14 @@ import (
 type ExperimentOrchestraClient interface {
 	FetchPsiphonConfig(ctx context.Context) ([]byte, error)
 	FetchTorTargets(ctx context.Context, cc string) (map[string]TorTarget, error)
-	FetchURLList(ctx context.Context, config URLListConfig) ([]URLInfo, error)
+	FetchURLList(ctx context.Context, config FetchURLListConfig) ([]URLInfo, error)
 }
 
 // ExperimentSession is the experiment's view of a session.
 type ExperimentSession interface {
 	ASNDatabasePath() string
 	CABundlePath() string
+	GetBestTestHelper(ctx context.Context, name string) (*Service, bool)
 	GetTestHelpersByName(name string) ([]Service, bool)
 	DefaultHTTPClient() *http.Client
 	Logger() Logger
@@ -54,6 +55,14 @@ type ExperimentCallbacks interface {
 	OnProgress(percentage float64, message string)
 }
 
+// ExperimentUploadCallbacks is an optional interface that the ExperimentCallbacks
+// may implement to be informed about the progress of submitting measurements.
+type ExperimentUploadCallbacks interface {
+	// OnUploadProgress provides information about the progress of
+	// submitting a measurement, i.e., bytes sent and total bytes.
+	OnUploadProgress(sent, total int64)
+}
+
 // PrinterCallbacks is the default event handler
 type PrinterCallbacks struct {
 	Logger
@@ -93,3 +102,21 @@ type ExperimentMeasurer interface {
 		measurement *Measurement, callbacks ExperimentCallbacks,
 	) error
 }
+
+// ExperimentAnomalyDetector is an optional interface that an
+// ExperimentMeasurer may implement to tell whether a measurement it
+// has performed shows signs of network interference.
+type ExperimentAnomalyDetector interface {
+	// IsAnomaly returns whether measurement is an anomaly.
+	IsAnomaly(measurement *Measurement) bool
+}
+
+// ExperimentFailureDetector is an optional interface that an
+// ExperimentMeasurer may implement to tell whether a measurement it
+// has performed failed hard even though Run did not return an error,
+// e.g., because the experiment could not reach its test helper.
+type ExperimentFailureDetector interface {
+	// HardFailure returns the reason why measurement failed hard,
+	// or nil if measurement did not fail hard.
+	HardFailure(measurement *Measurement) error
+}
@@ -0,0 +1,390 @@
+// Package harexport converts the archival data of a measurement into
+// a HAR 1.2 archive (see http://www.softwareishard.com/blog/har-12-spec/),
+// so that you can inspect measurements using browser devtools and other
+// tools supporting HAR.
+//
+// We create an entry for each request in the "requests" test key. We use
+// the TCP connect, TLS handshake, and DNS entries of the test keys, if any,
+// to fill the server IP address and the timings of the entry. Because HAR
+// has no place for DNS and TLS, we save them inside the "_dns" and "_tls"
+// custom fields. When a request failed, we set the status to zero and the
+// "_failure" custom field to the OONI failure string.
+package harexport
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+// Version is the HAR version we produce.
+const Version = "1.2"
+
+// ErrNoRequests indicates that the measurement does not contain any request.
+var ErrNoRequests = errors.New("harexport: no requests in measurement")
+
+// dateFormat is the format of the measurement_start_time.
+const dateFormat = "2006-01-02 15:04:05"
+
+// HAR is a HAR archive.
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log is the root of a HAR archive.
+type Log struct {
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+	Version string  `json:"version"`
+}
+
+// Creator describes the software that produced the archive.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is an HTTP transaction.
+type Entry struct {
+	Cache           struct{}                 `json:"cache"`
+	DNS             []archival.DNSQueryEntry `json:"_dns,omitempty"`
+	Failure         *string                  `json:"_failure,omitempty"`
+	Request         Request                  `json:"request"`
+	Response        Response                 `json:"response"`
+	ServerIPAddress string                   `json:"serverIPAddress,omitempty"`
+	StartedDateTime string                   `json:"startedDateTime"`
+	Time            float64                  `json:"time"`
+	Timings         Timings                  `json:"timings"`
+	TLS             *archival.TLSHandshake   `json:"_tls,omitempty"`
+}
+
+// Request is an HTTP request.
+type Request struct {
+	BodySize    int64       `json:"bodySize"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	HeadersSize int64       `json:"headersSize"`
+	HTTPVersion string      `json:"httpVersion"`
+	Method      string      `json:"method"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	QueryString []NameValue `json:"queryString"`
+	URL         string      `json:"url"`
+}
+
+// Response is an HTTP response.
+type Response struct {
+	BodySize    int64       `json:"bodySize"`
+	Content     Content     `json:"content"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	HeadersSize int64       `json:"headersSize"`
+	HTTPVersion string      `json:"httpVersion"`
+	RedirectURL string      `json:"redirectURL"`
+	Status      int64       `json:"status"`
+	StatusText  string      `json:"statusText"`
+}
+
+// Cookie is a cookie sent or received.
+type Cookie struct {
+	Domain   string `json:"domain,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Name     string `json:"name"`
+	Path     string `json:"path,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+	Value    string `json:"value"`
+}
+
+// NameValue is a header or a query string parameter.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is the body of a request.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Content is the body of a response. We set Encoding to "base64" when
+// the body is not valid UTF-8. Comment tells whether we truncated it.
+type Content struct {
+	Comment  string `json:"comment,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	Text     string `json:"text"`
+}
+
+// Timings contains the timings of an entry in milliseconds. As the
+// spec mandates, a -1 value means that the timing is not available and
+// Connect includes SSL. Since OONI only saves when operations complete,
+// we derive the timings from the completion times of the DNS lookup, TCP
+// connect, and TLS handshake of the same transaction, if any.
+type Timings struct {
+	Connect float64 `json:"connect"`
+	DNS     float64 `json:"dns"`
+	Receive float64 `json:"receive"`
+	Send    float64 `json:"send"`
+	SSL     float64 `json:"ssl"`
+	Wait    float64 `json:"wait"`
+}
+
+// testKeys contains the archival test keys we use.
+type testKeys struct {
+	NetworkEvents []archival.NetworkEvent    `json:"network_events"`
+	Queries       []archival.DNSQueryEntry   `json:"queries"`
+	Requests      []archival.RequestEntry    `json:"requests"`
+	TCPConnect    []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes []archival.TLSHandshake    `json:"tls_handshakes"`
+}
+
+// Convert converts measurement into a HAR archive. It returns
+// ErrNoRequests if the measurement does not contain requests.
+func Convert(measurement *model.Measurement) (*HAR, error) {
+	data, err := json.Marshal(measurement.TestKeys)
+	if err != nil {
+		return nil, err
+	}
+	var tk testKeys
+	if err := json.Unmarshal(data, &tk); err != nil {
+		return nil, err
+	}
+	if len(tk.Requests) <= 0 {
+		return nil, ErrNoRequests
+	}
+	begin, err := time.Parse(dateFormat, measurement.MeasurementStartTime)
+	if err != nil {
+		return nil, err
+	}
+	har := &HAR{Log: Log{
+		Creator: Creator{
+			Name:    "ooniprobe-engine",
+			Version: measurement.Annotations["engine_version"],
+		},
+		Entries: []Entry{},
+		Version: Version,
+	}}
+	// OONI saves the last request first, while HAR wants the entries
+	// sorted by start time.
+	sort.SliceStable(tk.Requests, func(i, j int) bool {
+		return tk.Requests[i].T < tk.Requests[j].T
+	})
+	for _, request := range tk.Requests {
+		har.Log.Entries = append(har.Log.Entries, newEntry(begin, &tk, request))
+	}
+	return har, nil
+}
+
+func newEntry(begin time.Time, tk *testKeys, re archival.RequestEntry) Entry {
+	entry := Entry{
+		Failure:         re.Failure,
+		Request:         newRequest(re.Request),
+		Response:        newResponse(re.Response),
+		StartedDateTime: begin.Add(seconds(re.T)).UTC().Format(time.RFC3339Nano),
+	}
+	var host string
+	if URL, err := url.Parse(re.Request.URL); err == nil {
+		host = URL.Hostname()
+	}
+	for _, query := range tk.Queries {
+		if query.Hostname == host && host != "" {
+			entry.DNS = append(entry.DNS, query)
+		}
+	}
+	tx := newTransaction(tk, re)
+	if tx.connect != nil {
+		entry.ServerIPAddress = tx.connect.IP
+	}
+	if entry.TLS = tx.tls; entry.TLS == nil {
+		entry.TLS = findTLSHandshake(tk, re)
+	}
+	if entry.TLS != nil && entry.TLS.NegotiatedProtocol == "h2" {
+		entry.Request.HTTPVersion, entry.Response.HTTPVersion = "HTTP/2.0", "HTTP/2.0"
+	}
+	entry.Timings, entry.Time = tx.timings(re.T)
+	return entry
+}
+
+// findTLSHandshake returns the last TLS handshake with the host of URL
+// as SNI. We use it when we cannot match the handshake using the
+// transaction ID, because either of them lacks it.
+func findTLSHandshake(tk *testKeys, re archival.RequestEntry) (out *archival.TLSHandshake) {
+	URL, err := url.Parse(re.Request.URL)
+	if err != nil || URL.Scheme != "https" {
+		return
+	}
+	for idx := range tk.TLSHandshakes {
+		if tk.TLSHandshakes[idx].ServerName == URL.Hostname() &&
+			(re.TransactionID == 0 || tk.TLSHandshakes[idx].TransactionID == 0) {
+			out = &tk.TLSHandshakes[idx]
+		}
+	}
+	return
+}
+
+// transaction contains the entries of an HTTP transaction.
+type transaction struct {
+	connect *archival.TCPConnectEntry
+	dns     *archival.DNSQueryEntry
+	done    float64 // the time of the last network event, if any
+	tls     *archival.TLSHandshake
+}
+
+func newTransaction(tk *testKeys, re archival.RequestEntry) (tx transaction) {
+	if re.TransactionID == 0 {
+		return // cannot know which entries belong to the transaction
+	}
+	for idx := range tk.Queries {
+		if tk.Queries[idx].TransactionID == re.TransactionID {
+			tx.dns = &tk.Queries[idx]
+		}
+	}
+	for idx := range tk.TCPConnect {
+		if tk.TCPConnect[idx].TransactionID == re.TransactionID {
+			tx.connect = &tk.TCPConnect[idx]
+		}
+	}
+	for idx := range tk.TLSHandshakes {
+		if tk.TLSHandshakes[idx].TransactionID == re.TransactionID {
+			tx.tls = &tk.TLSHandshakes[idx]
+		}
+	}
+	for _, ev := range tk.NetworkEvents {
+		if ev.TransactionID == re.TransactionID && ev.T > tx.done {
+			tx.done = ev.T
+		}
+	}
+	return
+}
+
+// timings returns the timings of the transaction started at t and its
+// total duration, both in milliseconds.
+func (tx transaction) timings(t float64) (Timings, float64) {
+	timings := Timings{Connect: -1, DNS: -1, SSL: -1}
+	last := t
+	if tx.dns != nil && tx.dns.T >= last {
+		timings.DNS, last = millis(tx.dns.T-last), tx.dns.T
+	}
+	connectStart := last
+	if tx.connect != nil && tx.connect.T >= last {
+		last = tx.connect.T
+	}
+	if tx.tls != nil && tx.tls.T >= last {
+		timings.SSL, last = millis(tx.tls.T-last), tx.tls.T
+	}
+	if tx.connect != nil {
+		timings.Connect = millis(last - connectStart)
+	}
+	if tx.done > last {
+		timings.Wait, last = millis(tx.done-last), tx.done
+	}
+	return timings, millis(last - t)
+}
+
+func newRequest(in archival.HTTPRequest) Request {
+	out := Request{
+		BodySize:    int64(len(in.Body.Value)),
+		Cookies:     []Cookie{},
+		Headers:     newHeaders(in.HeadersList),
+		HeadersSize: -1,
+		HTTPVersion: "HTTP/1.1",
+		Method:      in.Method,
+		QueryString: []NameValue{},
+		URL:         in.URL,
+	}
+	if URL, err := url.Parse(in.URL); err == nil {
+		for _, key := range sortedKeys(URL.Query()) {
+			for _, value := range URL.Query()[key] {
+				out.QueryString = append(out.QueryString, NameValue{Name: key, Value: value})
+			}
+		}
+	}
+	header := newHTTPHeader(in.HeadersList)
+	for _, cookie := range (&http.Request{Header: header}).Cookies() {
+		out.Cookies = append(out.Cookies, Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	if in.Body.Value != "" {
+		out.PostData = &PostData{MimeType: header.Get("Content-Type"), Text: in.Body.Value}
+	}
+	return out
+}
+
+func newResponse(in archival.HTTPResponse) Response {
+	header := newHTTPHeader(in.HeadersList)
+	out := Response{
+		BodySize: int64(len(in.Body.Value)),
+		Content: Content{
+			MimeType: header.Get("Content-Type"),
+			Size:     int64(len(in.Body.Value)),
+			Text:     in.Body.Value,
+		},
+		Cookies:     []Cookie{},
+		Headers:     newHeaders(in.HeadersList),
+		HeadersSize: -1,
+		HTTPVersion: "HTTP/1.1",
+		RedirectURL: header.Get("Location"),
+		Status:      in.Code,
+		StatusText:  http.StatusText(int(in.Code)),
+	}
+	if !utf8.ValidString(in.Body.Value) {
+		out.Content.Encoding = "base64"
+		out.Content.Text = base64.StdEncoding.EncodeToString([]byte(in.Body.Value))
+	}
+	if in.BodyIsTruncated {
+		out.Content.Comment = "body is truncated"
+	}
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		out.Cookies = append(out.Cookies, Cookie{
+			Domain:   cookie.Domain,
+			HTTPOnly: cookie.HttpOnly,
+			Name:     cookie.Name,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			Value:    cookie.Value,
+		})
+	}
+	return out
+}
+
+func newHeaders(in []archival.HTTPHeader) []NameValue {
+	out := []NameValue{}
+	for _, header := range in {
+		out = append(out, NameValue{Name: header.Key, Value: header.Value.Value})
+	}
+	return out
+}
+
+func newHTTPHeader(in []archival.HTTPHeader) http.Header {
+	out := make(http.Header)
+	for _, header := range in {
+		out.Add(header.Key, header.Value.Value)
+	}
+	return out
+}
+
+func sortedKeys(values url.Values) (out []string) {
+	for key := range values {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// millis converts seconds to milliseconds, rounding to microseconds.
+func millis(value float64) float64 {
+	return math.Round(value*1e6) / 1e3
+}
@@ -0,0 +1,182 @@
+package harexport_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/model/harexport"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+func header(key, value string) archival.HTTPHeader {
+	return archival.HTTPHeader{Key: key, Value: archival.MaybeBinaryValue{Value: value}}
+}
+
+func newMeasurement() *model.Measurement {
+	failure := "connection_reset"
+	return &model.Measurement{
+		Annotations:          map[string]string{"engine_version": "0.17.0"},
+		MeasurementStartTime: "2020-09-01 10:00:00",
+		TestKeys: map[string]interface{}{
+			"queries": []archival.DNSQueryEntry{{
+				Answers:   []archival.DNSAnswerEntry{{AnswerType: "A", IPv4: "93.184.216.34"}},
+				Hostname:  "www.example.com",
+				QueryType: "A",
+				T:         0.1,
+			}},
+			"requests": []archival.RequestEntry{{
+				Failure: &failure,
+				Request: archival.HTTPRequest{
+					Method: "GET",
+					URL:    "http://www.example.com/broken",
+				},
+				T:             2,
+				TransactionID: 2,
+			}, {
+				Request: archival.HTTPRequest{
+					HeadersList: []archival.HTTPHeader{
+						header("Cookie", "a=b; c=d"),
+						header("User-Agent", "miniooni/0.1.0"),
+					},
+					Method: "GET",
+					URL:    "https://www.example.com/?q=antani&lang=it",
+				},
+				Response: archival.HTTPResponse{
+					Body: archival.HTTPBody{Value: "\xff\xfe"},
+					Code: 302,
+					HeadersList: []archival.HTTPHeader{
+						header("Content-Type", "application/octet-stream"),
+						header("Location", "https://www.example.org/"),
+						header("Set-Cookie", "session=xyz; Path=/; HttpOnly; Secure"),
+					},
+				},
+				T:             0,
+				TransactionID: 1,
+			}},
+			"tcp_connect": []archival.TCPConnectEntry{{
+				IP:            "93.184.216.34",
+				Port:          443,
+				T:             0.2,
+				TransactionID: 1,
+			}},
+			"tls_handshakes": []archival.TLSHandshake{{
+				NegotiatedProtocol: "h2",
+				ServerName:         "www.example.com",
+				T:                  0.35,
+				TLSVersion:         "TLSv1.3",
+				TransactionID:      1,
+			}},
+			"network_events": []archival.NetworkEvent{{
+				Operation:     "read",
+				T:             0.5,
+				TransactionID: 1,
+			}},
+		},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	har, err := harexport.Convert(newMeasurement())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != harexport.Version || har.Log.Creator.Version != "0.17.0" {
+		t.Fatal("not the log we expected")
+	}
+	if len(har.Log.Entries) != 2 {
+		t.Fatal("not the number of entries we expected")
+	}
+	first, second := har.Log.Entries[0], har.Log.Entries[1]
+	if first.StartedDateTime != "2020-09-01T10:00:00Z" ||
+		second.StartedDateTime != "2020-09-01T10:00:02Z" {
+		t.Fatal("entries are not sorted by start time")
+	}
+	if first.ServerIPAddress != "93.184.216.34" || first.TLS == nil ||
+		first.TLS.TLSVersion != "TLSv1.3" || first.Request.HTTPVersion != "HTTP/2.0" {
+		t.Fatal("not the connection information we expected")
+	}
+	if len(first.DNS) != 1 || len(second.DNS) != 1 {
+		t.Fatal("not the DNS information we expected")
+	}
+	timings := first.Timings
+	if timings.DNS != -1 || timings.Connect != 350 || timings.SSL != 150 ||
+		timings.Wait != 150 || first.Time != 500 {
+		t.Fatalf("not the timings we expected: %+v %f", timings, first.Time)
+	}
+	if len(first.Request.QueryString) != 2 || first.Request.QueryString[0].Name != "lang" {
+		t.Fatal("not the query string we expected")
+	}
+	if len(first.Request.Cookies) != 2 || first.Request.Cookies[1].Value != "d" {
+		t.Fatal("not the request cookies we expected")
+	}
+	response := first.Response
+	if response.Status != 302 || response.StatusText != "Found" ||
+		response.RedirectURL != "https://www.example.org/" {
+		t.Fatal("not the response we expected")
+	}
+	if len(response.Cookies) != 1 || !response.Cookies[0].HTTPOnly || !response.Cookies[0].Secure {
+		t.Fatal("not the response cookies we expected")
+	}
+	if response.Content.Encoding != "base64" ||
+		response.Content.Text != base64.StdEncoding.EncodeToString([]byte("\xff\xfe")) ||
+		response.Content.MimeType != "application/octet-stream" || response.Content.Size != 2 {
+		t.Fatal("not the content we expected")
+	}
+	if second.Failure == nil || *second.Failure != "connection_reset" || second.Response.Status != 0 {
+		t.Fatal("not the failure we expected")
+	}
+	if second.TLS != nil || second.Timings.Connect != -1 || second.Time != 0 {
+		t.Fatal("not the failed entry we expected")
+	}
+}
+
+func TestConvertSerializesToHAR(t *testing.T) {
+	har, err := harexport.Convert(newMeasurement())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(har)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic struct {
+		Log struct {
+			Entries []map[string]interface{} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"cache", "request", "response", "startedDateTime", "time", "timings"} {
+		if _, found := generic.Log.Entries[0][key]; !found {
+			t.Fatal("missing mandatory HAR field", key)
+		}
+	}
+}
+
+func TestConvertNoRequests(t *testing.T) {
+	measurement := newMeasurement()
+	measurement.TestKeys = map[string]interface{}{}
+	if _, err := harexport.Convert(measurement); !errors.Is(err, harexport.ErrNoRequests) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConvertInvalidStartTime(t *testing.T) {
+	measurement := newMeasurement()
+	measurement.MeasurementStartTime = "antani"
+	if _, err := harexport.Convert(measurement); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestConvertInvalidTestKeys(t *testing.T) {
+	measurement := newMeasurement()
+	measurement.TestKeys = map[string]interface{}{"requests": 17}
+	if _, err := harexport.Convert(measurement); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
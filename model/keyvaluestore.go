@@ -1,7 +1,11 @@
 package model
 
-// KeyValueStore is a key-value store used by the session.
+// KeyValueStore is a key-value store used by the session. List
+// returns, in lexicographic order, the keys starting with prefix.
+// Delete does not fail if the key does not exist.
 type KeyValueStore interface {
+	Delete(key string) (err error)
 	Get(key string) (value []byte, err error)
+	List(prefix string) (keys []string, err error)
 	Set(key string, value []byte) (err error)
 }
@@ -5,15 +5,38 @@ type LocationInfo struct {
 	// ASN is the autonomous system number
 	ASN uint
 
+	// BehindCaptivePortal indicates that the probe is behind
+	// a captive portal. See geolocate.CheckCaptivePortal.
+	BehindCaptivePortal bool
+
 	// CountryCode is the country code
 	CountryCode string
 
+	// FallbackDatabaseDate is not empty when we have derived the
+	// location using the fallback database built into the engine and
+	// contains the date of such database. See geolocate.Results.
+	FallbackDatabaseDate string
+
 	// NetworkName is the network name
 	NetworkName string
 
+	// NATType is the NAT type (e.g. "endpoint_independent").
+	// See geolocate.STUNClient.NATType.
+	NATType string
+
 	// IP is the probe IP
 	ProbeIP string
 
+	// ProbeIPv4 is the probe IPv4 address, if any
+	ProbeIPv4 string
+
+	// ProbeIPv6 is the probe IPv6 address, if any
+	ProbeIPv6 string
+
+	// ProbeIsVPN indicates that the probe is likely using a
+	// VPN or a proxy. See geolocate.DetectVPN.
+	ProbeIsVPN bool
+
 	// ResolverASN is the resolver ASN
 	ResolverASN uint
 
@@ -22,4 +45,8 @@ type LocationInfo struct {
 
 	// ResolverNetworkName is the resolver network name
 	ResolverNetworkName string
+
+	// ResolverNetworkType is the resolver network type (e.g.
+	// "isp" or "public"). See geolocate.ClassifyResolver.
+	ResolverNetworkType string
 }
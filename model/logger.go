@@ -21,3 +21,35 @@ type Logger interface {
 	// Warnf formats and emits a warning message.
 	Warnf(format string, v ...interface{})
 }
+
+// LogFieldComponent is the LogFields key naming the component of the
+// engine that emits a message (e.g., "session", "probeservices").
+const LogFieldComponent = "component"
+
+// LogFields contains key-value pairs describing log messages.
+type LogFields map[string]interface{}
+
+// FieldLogger is a Logger that attaches fields to each message, so that
+// structured handlers (e.g., emitting JSON) can make them available.
+type FieldLogger interface {
+	Logger
+
+	// WithFields returns a logger attaching fields, in addition to the
+	// fields already attached by this logger, to each message.
+	WithFields(fields LogFields) FieldLogger
+}
+
+// WithFields returns a logger attaching fields to the messages emitted
+// using logger, if logger is a FieldLogger. Otherwise, it returns logger,
+// because a logger without fields could not emit them anyway.
+func WithFields(logger Logger, fields LogFields) Logger {
+	if fl, ok := logger.(FieldLogger); ok {
+		return fl.WithFields(fields)
+	}
+	return logger
+}
+
+// WithComponent is like WithFields for the LogFieldComponent field.
+func WithComponent(logger Logger, component string) Logger {
+	return WithFields(logger, LogFields{LogFieldComponent: component})
+}
@@ -0,0 +1,41 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+type fieldLogger struct {
+	model.Logger
+	fields model.LogFields
+}
+
+func (l fieldLogger) WithFields(fields model.LogFields) model.FieldLogger {
+	merged := make(model.LogFields)
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return fieldLogger{Logger: l.Logger, fields: merged}
+}
+
+func TestWithFields(t *testing.T) {
+	logger := model.WithComponent(fieldLogger{Logger: log.Log}, "session")
+	logger = model.WithFields(logger, model.LogFields{"experiment": "ndt"})
+	fields := logger.(fieldLogger).fields
+	if len(fields) != 2 || fields[model.LogFieldComponent] != "session" ||
+		fields["experiment"] != "ndt" {
+		t.Fatal("not the fields we expected", fields)
+	}
+}
+
+func TestWithFieldsWithoutFieldLogger(t *testing.T) {
+	var logger model.Logger = log.Log
+	if model.WithComponent(logger, "session") != logger {
+		t.Fatal("expected the same logger")
+	}
+}
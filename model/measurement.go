@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"net"
 	"time"
 )
 
@@ -66,6 +67,12 @@ type Measurement struct {
 	// ProbeIP contains the probe IP
 	ProbeIP string `json:"probe_ip,omitempty"`
 
+	// ProbeIPv4 contains the probe IPv4 address, if known
+	ProbeIPv4 string `json:"probe_ipv4,omitempty"`
+
+	// ProbeIPv6 contains the probe IPv6 address, if known
+	ProbeIPv6 string `json:"probe_ipv6,omitempty"`
+
 	// ProbeNetworkName contains the probe network name
 	ProbeNetworkName string `json:"probe_network_name,omitempty"`
 
@@ -81,6 +88,10 @@ type Measurement struct {
 	// ResolverNetworkName is the network name of the resolver.
 	ResolverNetworkName string `json:"resolver_network_name"`
 
+	// ResolverNetworkType is the type of network of the resolver,
+	// i.e., "isp", "public", "other", or "unknown".
+	ResolverNetworkType string `json:"resolver_network_type,omitempty"`
+
 	// SoftwareName contains the software name
 	SoftwareName string `json:"software_name"`
 
@@ -123,6 +134,20 @@ func (m *Measurement) AddAnnotations(input map[string]string) {
 	}
 }
 
+// AddIPFamilyAnnotation adds the "ip_family" annotation, whose value is
+// "ipv4" or "ipv6" depending on the family of address, which should be the
+// address the experiment has used. We do nothing if address is not an IP.
+func (m *Measurement) AddIPFamilyAnnotation(address string) {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+	case ip.To4() != nil:
+		m.AddAnnotation("ip_family", "ipv4")
+	default:
+		m.AddAnnotation("ip_family", "ipv6")
+	}
+}
+
 // AddAnnotation adds a single annotations to m.Annotations.
 func (m *Measurement) AddAnnotation(key, value string) {
 	if m.Annotations == nil {
@@ -131,6 +156,41 @@ func (m *Measurement) AddAnnotation(key, value string) {
 	m.Annotations[key] = value
 }
 
+// DataUsage is the amount of data, in KiB, used by a measurement, as
+// accounted by the netx byte counters.
+type DataUsage struct {
+	KibiBytesReceived float64 `json:"kibi_bytes_received"`
+	KibiBytesSent     float64 `json:"kibi_bytes_sent"`
+}
+
+// DataUsageSetter is implemented by test keys that can record the data
+// used by the measurement (see MeasurementDataUsage).
+type DataUsageSetter interface {
+	SetDataUsage(usage DataUsage)
+}
+
+// MeasurementDataUsage should be embedded by the test keys of experiments
+// so that the engine can record the data used by each measurement.
+type MeasurementDataUsage struct {
+	DataUsage *DataUsage `json:"data_usage,omitempty"`
+}
+
+// SetDataUsage implements DataUsageSetter.SetDataUsage.
+func (mdu *MeasurementDataUsage) SetDataUsage(usage DataUsage) {
+	mdu.DataUsage = &usage
+}
+
+// SetDataUsage records usage into the "data_usage" test key. We do nothing
+// when m.TestKeys is neither a generic map nor a DataUsageSetter.
+func (m *Measurement) SetDataUsage(usage DataUsage) {
+	switch tk := m.TestKeys.(type) {
+	case DataUsageSetter:
+		tk.SetDataUsage(usage)
+	case map[string]interface{}:
+		tk["data_usage"] = &usage
+	}
+}
+
 // MakeGenericTestKeys casts the m.TestKeys to a map[string]interface{}.
 //
 // Ideally, all tests should have a clear Go structure, well defined, that
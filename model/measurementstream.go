@@ -0,0 +1,341 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// WriteJSON writes the JSON serialization of m followed by a newline
+// to w. The output is the same as json.Marshal's, except that we do not
+// escape <, >, and &, which are common in the HTTP bodies and URLs of
+// the test keys, but we emit it while walking m, so that we only keep
+// in memory one element of the lists and one value of the maps contained
+// by the test keys at a time. This helps with experiments (e.g., dash,
+// ndt7) whose test keys contain a very large number of entries. If we
+// fail midway, w will contain the part of the measurement that we have
+// already written.
+func (m *Measurement) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	sw := &streamWriter{w: bw}
+	sw.encode(reflect.ValueOf(m))
+	sw.writeString("\n")
+	if sw.err != nil {
+		return sw.err
+	}
+	return bw.Flush()
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+type streamWriter struct {
+	buf bytes.Buffer
+	err error
+	w   *bufio.Writer
+}
+
+func (sw *streamWriter) writeString(s string) {
+	if sw.err == nil {
+		_, sw.err = sw.w.WriteString(s)
+	}
+}
+
+func (sw *streamWriter) marshal(v interface{}) {
+	if sw.err != nil {
+		return
+	}
+	sw.buf.Reset()
+	encoder := json.NewEncoder(&sw.buf)
+	encoder.SetEscapeHTML(false)
+	if sw.err = encoder.Encode(v); sw.err != nil {
+		return
+	}
+	_, sw.err = sw.w.Write(bytes.TrimSuffix(sw.buf.Bytes(), []byte("\n")))
+}
+
+func (sw *streamWriter) encode(v reflect.Value) {
+	if sw.err != nil {
+		return
+	}
+	if !v.IsValid() {
+		sw.writeString("null")
+		return
+	}
+	if isMarshaler(v) {
+		// Let json.Marshal decide whether and how to use the marshaler.
+		if v.CanAddr() {
+			sw.marshal(v.Addr().Interface())
+			return
+		}
+		sw.marshal(v.Interface())
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			sw.writeString("null")
+			return
+		}
+		sw.encode(v.Elem())
+	case reflect.Struct:
+		sw.encodeStruct(v)
+	case reflect.Map:
+		sw.encodeMap(v)
+	case reflect.Slice:
+		if v.IsNil() {
+			sw.writeString("null")
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			sw.marshal(v.Interface()) // base64
+			return
+		}
+		sw.encodeList(v)
+	case reflect.Array:
+		sw.encodeList(v)
+	default:
+		sw.marshal(v.Interface())
+	}
+}
+
+func isMarshaler(v reflect.Value) bool {
+	t := v.Type()
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return true
+	}
+	pt := reflect.PtrTo(t)
+	return v.CanAddr() && (pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType))
+}
+
+func (sw *streamWriter) encodeList(v reflect.Value) {
+	sw.writeString("[")
+	for idx := 0; idx < v.Len(); idx++ {
+		if idx > 0 {
+			sw.writeString(",")
+		}
+		sw.encode(v.Index(idx))
+	}
+	sw.writeString("]")
+}
+
+func (sw *streamWriter) encodeMap(v reflect.Value) {
+	if v.IsNil() {
+		sw.writeString("null")
+		return
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		sw.marshal(v.Interface()) // json.Marshal knows how to sort other keys
+		return
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	sw.writeString("{")
+	for idx, key := range keys {
+		if idx > 0 {
+			sw.writeString(",")
+		}
+		sw.marshal(key.String())
+		sw.writeString(":")
+		sw.encode(v.MapIndex(key))
+	}
+	sw.writeString("}")
+}
+
+func (sw *streamWriter) encodeStruct(v reflect.Value) {
+	fields, ok := structFields(v.Type())
+	if !ok {
+		sw.marshal(v.Interface())
+		return
+	}
+	sw.writeString("{")
+	first := true
+	for _, field := range fields {
+		fv, ok := fieldByIndex(v, field.index)
+		if !ok || (field.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		if !first {
+			sw.writeString(",")
+		}
+		first = false
+		sw.marshal(field.name)
+		sw.writeString(":")
+		sw.encode(fv)
+	}
+	sw.writeString("}")
+}
+
+// fieldByIndex is like v.FieldByIndex except that it returns false
+// when it would need to traverse a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for idx, i := range index {
+		if idx > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+type streamField struct {
+	index     []int
+	name      string
+	omitEmpty bool
+	tagged    bool
+}
+
+// structFields returns the fields that json.Marshal would serialize for
+// the struct type t, following the same rules for embedded structs. It
+// returns false if t uses tag options that we do not support, in which
+// case the caller should fall back to json.Marshal.
+func structFields(t reflect.Type) ([]streamField, bool) {
+	var (
+		all     []streamField
+		current []streamField
+		next    = []streamField{{}}
+		visited = map[reflect.Type]bool{}
+	)
+	for len(next) > 0 {
+		current, next = next, nil
+		for _, parent := range current {
+			ft := t
+			if len(parent.index) > 0 {
+				ft = t.FieldByIndex(parent.index).Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+			}
+			if visited[ft] {
+				continue
+			}
+			visited[ft] = true
+			for i := 0; i < ft.NumField(); i++ {
+				sf := ft.Field(i)
+				if sf.Anonymous {
+					st := sf.Type
+					if st.Kind() == reflect.Ptr {
+						st = st.Elem()
+					}
+					if sf.PkgPath != "" && st.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, options := tag, ""
+				if idx := strings.Index(tag, ","); idx >= 0 {
+					name, options = tag[:idx], tag[idx+1:]
+				}
+				omitEmpty := false
+				for _, option := range strings.Split(options, ",") {
+					switch option {
+					case "", "omitempty":
+						omitEmpty = omitEmpty || option == "omitempty"
+					default:
+						return nil, false
+					}
+				}
+				index := append(append([]int{}, parent.index...), i)
+				st := sf.Type
+				if st.Kind() == reflect.Ptr && st.Name() == "" {
+					st = st.Elem()
+				}
+				if name == "" && sf.Anonymous && st.Kind() == reflect.Struct {
+					next = append(next, streamField{index: index})
+					continue
+				}
+				if sf.PkgPath != "" {
+					continue // unexported embedded non-struct
+				}
+				field := streamField{
+					index: index, name: name, omitEmpty: omitEmpty, tagged: name != "",
+				}
+				if field.name == "" {
+					field.name = sf.Name
+				}
+				all = append(all, field)
+			}
+		}
+	}
+	return dominantFields(all), true
+}
+
+// dominantFields implements the rules that json.Marshal uses to choose
+// among fields with the same name: the shallowest field wins and, at the
+// same depth, a tagged field wins over untagged ones. Otherwise, we drop
+// all the fields with that name. The result is sorted by index.
+func dominantFields(all []streamField) (out []streamField) {
+	byName := make(map[string][]streamField)
+	for _, field := range all {
+		byName[field.name] = append(byName[field.name], field)
+	}
+	for _, fields := range byName {
+		depth := len(fields[0].index)
+		for _, field := range fields[1:] {
+			if len(field.index) < depth {
+				depth = len(field.index)
+			}
+		}
+		var candidates, tagged []streamField
+		for _, field := range fields {
+			if len(field.index) != depth {
+				continue
+			}
+			candidates = append(candidates, field)
+			if field.tagged {
+				tagged = append(tagged, field)
+			}
+		}
+		switch {
+		case len(candidates) == 1:
+			out = append(out, candidates[0])
+		case len(tagged) == 1:
+			out = append(out, tagged[0])
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].index, out[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return
+}
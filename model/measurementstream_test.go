@@ -0,0 +1,144 @@
+package model_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+type streamEmbedded struct {
+	Failure *string `json:"failure"`
+	Shadow  string  `json:"shadowed"`
+	Hidden  string  `json:"hidden"`
+}
+
+type streamOther struct {
+	Hidden string `json:"hidden"`
+}
+
+type streamTagged struct {
+	Value int `json:"value"`
+}
+
+type streamTestKeys struct {
+	streamEmbedded
+	*streamOther
+	Tagged     streamTagged             `json:"tagged"`
+	Shadow     string                   `json:"shadowed"`
+	Bytes      []byte                   `json:"bytes"`
+	Array      [2]int                   `json:"array"`
+	Body       archival.HTTPBody        `json:"body"`
+	Empty      []string                 `json:"empty,omitempty"`
+	Requests   []archival.RequestEntry  `json:"requests"`
+	Nil        []archival.NetworkEvent  `json:"nil"`
+	ByInt      map[int]string           `json:"by_int"`
+	Generic    map[string]interface{}   `json:"generic"`
+	Things     []map[string]interface{} `json:"things"`
+	Time       time.Time                `json:"time"`
+	Untagged   float64
+	Skipped    string `json:"-"`
+	Headers    map[string]archival.MaybeBinaryValue
+	unexported int
+}
+
+func newStreamMeasurement() *model.Measurement {
+	failure := "generic_timeout_error"
+	return &model.Measurement{
+		Annotations: map[string]string{"engine_version": "0.17.0", "<html>": "&"},
+		Input:       "https://www.example.com/",
+		TestKeys: &streamTestKeys{
+			streamEmbedded: streamEmbedded{Failure: &failure, Shadow: "nope", Hidden: "a"},
+			streamOther:    &streamOther{Hidden: "b"},
+			Tagged:         streamTagged{Value: 17},
+			Shadow:         "yes",
+			Bytes:          []byte("antani"),
+			Array:          [2]int{1, 2},
+			Body:           archival.HTTPBody{Value: "\xff\xfe"},
+			Requests: []archival.RequestEntry{{
+				Request: archival.HTTPRequest{URL: "https://www.example.com/"},
+			}, {
+				Failure: &failure,
+			}},
+			ByInt:    map[int]string{10: "x", 2: "y"},
+			Generic:  map[string]interface{}{"z": 1.5, "a": []interface{}{"b", nil}},
+			Things:   []map[string]interface{}{{"a": 1}, nil},
+			Time:     time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC),
+			Untagged: 3.14,
+			Headers:  map[string]archival.MaybeBinaryValue{"X": {Value: "y"}},
+		},
+		MeasurementRuntime: 1.25,
+	}
+}
+
+func checkWriteJSON(t *testing.T, m *model.Measurement) {
+	var expected bytes.Buffer
+	encoder := json.NewEncoder(&expected)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != expected.String() {
+		t.Fatalf("expected\n%sbut got\n%s", expected.String(), got)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	checkWriteJSON(t, newStreamMeasurement())
+}
+
+func TestWriteJSONNilEmbeddedPointer(t *testing.T) {
+	m := newStreamMeasurement()
+	m.TestKeys.(*streamTestKeys).streamOther = nil
+	checkWriteJSON(t, m)
+}
+
+func TestWriteJSONGenericTestKeys(t *testing.T) {
+	m := newStreamMeasurement()
+	m.TestKeys = map[string]interface{}{
+		"queries": []interface{}{map[string]interface{}{"hostname": "x.org"}},
+		"failure": nil,
+	}
+	checkWriteJSON(t, m)
+	m.TestKeys = nil
+	checkWriteJSON(t, m)
+}
+
+func TestWriteJSONStringOption(t *testing.T) {
+	m := newStreamMeasurement()
+	m.TestKeys = struct {
+		Count int `json:"count,string"`
+	}{Count: 10}
+	checkWriteJSON(t, m)
+}
+
+func TestWriteJSONMarshalError(t *testing.T) {
+	m := newStreamMeasurement()
+	m.TestKeys = map[string]interface{}{"func": func() {}}
+	if err := m.WriteJSON(&bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("mocked error")
+}
+
+func TestWriteJSONWriteError(t *testing.T) {
+	m := newStreamMeasurement()
+	m.TestKeys = map[string]interface{}{"body": strings.Repeat("x", 1<<16)}
+	if err := m.WriteJSON(failingWriter{}); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
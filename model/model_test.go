@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/ooni/probe-engine/internal/redact"
 	"github.com/ooni/probe-engine/model"
 )
 
@@ -114,6 +115,83 @@ func TestScrubCommonCase(t *testing.T) {
 	}
 }
 
+func TestScrubIPv4AndIPv6(t *testing.T) {
+	const probeIPv6 = "2001:db8::1"
+	m := makeMeasurement(probeIPv6, "AS137", "IT")
+	m.ProbeIPv4 = "130.192.91.211"
+	m.ProbeIPv6 = probeIPv6
+	m.TestKeys = fakeTestKeys{Body: "your IPv4 is 130.192.91.211"}
+	privacy := model.PrivacySettings{}
+	if err := privacy.Apply(&m, probeIPv6); err != nil {
+		t.Fatal(err)
+	}
+	if m.ProbeIPv4 != "" || m.ProbeIPv6 != "" {
+		t.Fatal("ProbeIPv4 or ProbeIPv6 have not been scrubbed")
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("130.192.91.211")) {
+		t.Fatal("ProbeIPv4 not fully redacted")
+	}
+}
+
+func TestAddIPFamilyAnnotation(t *testing.T) {
+	var cases = []struct {
+		address  string
+		expected string
+	}{
+		{"130.192.91.211", "ipv4"},
+		{"2001:db8::1", "ipv6"},
+		{"antani", ""},
+	}
+	for _, c := range cases {
+		m := &model.Measurement{}
+		m.AddIPFamilyAnnotation(c.address)
+		if m.Annotations["ip_family"] != c.expected {
+			t.Fatalf("unexpected family for %s", c.address)
+		}
+	}
+}
+
+func TestMeasurementSetDataUsage(t *testing.T) {
+	usage := model.DataUsage{KibiBytesReceived: 1.5, KibiBytesSent: 0.5}
+	t.Run("with setter test keys", func(t *testing.T) {
+		tk := &struct{ model.MeasurementDataUsage }{}
+		m := &model.Measurement{TestKeys: tk}
+		m.SetDataUsage(usage)
+		if tk.DataUsage == nil || *tk.DataUsage != usage {
+			t.Fatal("data usage not recorded")
+		}
+		data, err := json.Marshal(tk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"data_usage":{"kibi_bytes_received":1.5,"kibi_bytes_sent":0.5}}`
+		if string(data) != expected {
+			t.Fatal("unexpected serialization", string(data))
+		}
+	})
+	t.Run("with generic test keys", func(t *testing.T) {
+		tk := map[string]interface{}{}
+		m := &model.Measurement{TestKeys: tk}
+		m.SetDataUsage(usage)
+		if value, ok := tk["data_usage"].(*model.DataUsage); !ok || *value != usage {
+			t.Fatal("data usage not recorded")
+		}
+	})
+	t.Run("with other test keys", func(t *testing.T) {
+		m := &model.Measurement{TestKeys: struct{}{}}
+		m.SetDataUsage(usage) // must not panic
+		m.TestKeys = nil
+		m.SetDataUsage(usage)
+		if m.TestKeys != nil {
+			t.Fatal("test keys should not be modified")
+		}
+	})
+}
+
 func TestPrivacySettingsApply(t *testing.T) {
 	ps := &model.PrivacySettings{}
 	m := &model.Measurement{
@@ -159,6 +237,54 @@ func TestPrivacySettingsApplyMarshalError(t *testing.T) {
 	}
 }
 
+func TestPrivacySettingsApplyRedactsTestKeys(t *testing.T) {
+	ps := &model.PrivacySettings{RedactHTTPCredentials: true}
+	m := &model.Measurement{
+		TestKeys: map[string]interface{}{
+			"failure": "connection_refused: 192.168.1.1:443",
+			"headers": map[string]interface{}{
+				"Cookie":     "session=xyz",
+				"User-Agent": "miniooni/0.1.0",
+			},
+		},
+	}
+	if err := ps.Apply(m, "8.8.8.8"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(m.TestKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"failure":"connection_refused: 192.168.1.1:443",` +
+		`"headers":{"Cookie":"[scrubbed]","User-Agent":"miniooni/0.1.0"}}`
+	if string(data) != expected {
+		t.Fatal("not the test keys we expected", string(data))
+	}
+	if _, found := m.Annotations["_probe_engine_sanitize_test_keys"]; found {
+		t.Fatal("did not expect the probe IP annotation")
+	}
+}
+
+func TestPrivacySettingsApplyRedactsInterfaceAddresses(t *testing.T) {
+	addresses := redact.InterfaceAddresses()
+	if len(addresses) <= 0 {
+		t.Skip("this host has no non-loopback addresses")
+	}
+	ps := &model.PrivacySettings{}
+	m := &model.Measurement{
+		TestKeys: map[string]interface{}{
+			"failure": "connection_refused: " + addresses[0].String(),
+		},
+	}
+	if err := ps.Apply(m, "8.8.8.8"); err != nil {
+		t.Fatal(err)
+	}
+	if failure := m.TestKeys.(map[string]interface{})["failure"]; failure !=
+		"connection_refused: [scrubbed]" {
+		t.Fatal("not the failure we expected", failure)
+	}
+}
+
 func TestMakeGenericTestKeysIdempotent(t *testing.T) {
 	m := new(model.Measurement)
 	m.TestKeys = make(map[string]interface{})
@@ -197,3 +323,32 @@ func TestMakeGenericTestKeysMarshalError(t *testing.T) {
 		t.Fatal("expected nil output here")
 	}
 }
+
+func TestMeasurementWriteJSON(t *testing.T) {
+	m := &model.Measurement{
+		Input:    "https://www.example.com/?a=b&c=d",
+		TestKeys: map[string]interface{}{"body": "<html></html>"},
+	}
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasSuffix(data, []byte("}\n")) || !bytes.Contains(data, []byte("<html></html>")) {
+		t.Fatal("not the serialization we expected", string(data))
+	}
+	var decoded model.Measurement
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Input != m.Input {
+		t.Fatal("not the input we expected")
+	}
+}
+
+func TestMeasurementWriteJSONError(t *testing.T) {
+	m := &model.Measurement{TestKeys: map[string]interface{}{"func": func() {}}}
+	if err := m.WriteJSON(&bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
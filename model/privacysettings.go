@@ -1,10 +1,11 @@
 package model
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"net"
+
+	"github.com/ooni/probe-engine/internal/redact"
 )
 
 // PrivacySettings contains privacy settings for submitting measurements.
@@ -17,10 +18,19 @@ type PrivacySettings struct {
 
 	// IncludeIP indicates whether to include the IP
 	IncludeIP bool
+
+	// RedactHTTPCredentials indicates whether to redact the value of
+	// the HTTP headers containing credentials, e.g., cookies.
+	RedactHTTPCredentials bool
 }
 
 // Apply applies the privacy settings to the measurement, possibly
-// scrubbing the probeIP out of it.
+// scrubbing the probeIP, the probe IPv4 and IPv6 addresses saved into
+// the measurement, and the addresses of the probe's network interfaces
+// out of it, as well as the HTTP credentials. We do not scrub the other
+// addresses belonging to local networks, e.g., the address of a local
+// DNS resolver, which do not identify the probe. See the internal/redact
+// package for the rules we use for redacting the test keys.
 func (ps PrivacySettings) Apply(m *Measurement, probeIP string) (err error) {
 	if ps.IncludeASN == false {
 		m.ProbeASN = DefaultProbeASNString
@@ -28,9 +38,23 @@ func (ps PrivacySettings) Apply(m *Measurement, probeIP string) (err error) {
 	if ps.IncludeCountry == false {
 		m.ProbeCC = DefaultProbeCC
 	}
+	var rules []redact.Rule
 	if ps.IncludeIP == false {
 		m.ProbeIP = DefaultProbeIP
 		err = ps.MaybeRewriteTestKeys(m, probeIP, json.Marshal)
+		for _, ip := range []string{m.ProbeIPv4, m.ProbeIPv6} {
+			if err == nil && ip != "" && ip != probeIP {
+				err = ps.MaybeRewriteTestKeys(m, ip, json.Marshal)
+			}
+		}
+		m.ProbeIPv4, m.ProbeIPv6 = "", ""
+		rules = append(rules, redact.OwnAddresses(redact.InterfaceAddresses()...))
+	}
+	if ps.RedactHTTPCredentials {
+		rules = append(rules, redact.Headers(redact.SensitiveHeaders...))
+	}
+	if err == nil && len(rules) > 0 {
+		_, err = rewriteTestKeys(m, json.Marshal, rules...)
 	}
 	return
 }
@@ -44,21 +68,27 @@ func (ps PrivacySettings) MaybeRewriteTestKeys(
 	if net.ParseIP(currentIP) == nil {
 		return errors.New("Invalid probe IP string")
 	}
+	changed, err := rewriteTestKeys(m, marshal, redact.Addresses(currentIP))
+	if changed {
+		// We add an annotation such that hopefully later we can measure the
+		// number of cases where we failed to sanitize properly.
+		m.AddAnnotation("_probe_engine_sanitize_test_keys", "true")
+	}
+	return err
+}
+
+// rewriteTestKeys applies the rules to the test keys of m and returns
+// whether we have redacted anything.
+func rewriteTestKeys(
+	m *Measurement, marshal func(interface{}) ([]byte, error), rules ...redact.Rule,
+) (bool, error) {
 	data, err := marshal(m.TestKeys)
 	if err != nil {
-		return err
+		return false, err
 	}
-	// The check using Count is to save an unnecessary copy performed by
-	// ReplaceAll when there are no matches into the body. This is what
-	// we would like the common case to be, meaning that the code has done
-	// its job correctly and has not leaked the IP.
-	bpip := []byte(currentIP)
-	if bytes.Count(data, bpip) <= 0 {
-		return nil
+	data, changed, err := redact.JSON(data, rules...)
+	if err != nil || !changed {
+		return false, err
 	}
-	data = bytes.ReplaceAll(data, bpip, []byte(`[scrubbed]`))
-	// We add an annotation such that hopefully later we can measure the
-	// number of cases where we failed to sanitize properly.
-	m.AddAnnotation("_probe_engine_sanitize_test_keys", "true")
-	return json.Unmarshal(data, &m.TestKeys)
+	return true, json.Unmarshal(data, &m.TestKeys)
 }
@@ -0,0 +1,51 @@
+package model
+
+import "context"
+
+// Tracer creates spans describing the operations performed by the engine
+// (e.g., bootstrapping the session, measuring, calling the backend, and
+// dialing), so that embedders can export traces (e.g., using OpenTelemetry)
+// and debug slow runs. A Tracer must be safe for concurrent use.
+type Tracer interface {
+	// StartSpan starts a span called name, whose parent is the span in
+	// ctx, if any, and returns a context containing the new span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation traced by a Tracer.
+type Span interface {
+	// SetAttribute attaches a key-value pair to the span.
+	SetAttribute(key, value string)
+
+	// End ends the span. The err argument is the error that
+	// occurred, if any, during the operation.
+	End(err error)
+}
+
+type tracerKey struct{}
+
+// WithTracer returns a copy of ctx using tracer for StartSpan.
+func WithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// ContextTracer returns the tracer in ctx, if any, or nil.
+func ContextTracer(ctx context.Context) Tracer {
+	tracer, _ := ctx.Value(tracerKey{}).(Tracer)
+	return tracer
+}
+
+// StartSpan starts a span using the tracer in ctx. Without a tracer,
+// it returns ctx and a span that does nothing.
+func StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	if tracer := ContextTracer(ctx); tracer != nil {
+		return tracer.StartSpan(ctx, name)
+	}
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key, value string) {}
+
+func (nopSpan) End(err error) {}
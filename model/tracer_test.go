@@ -0,0 +1,75 @@
+package model_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+type fakeSpan struct {
+	attributes map[string]string
+	ended      bool
+	err        error
+	name       string
+	parent     *fakeSpan
+}
+
+func (s *fakeSpan) SetAttribute(key, value string) {
+	s.attributes[key] = value
+}
+
+func (s *fakeSpan) End(err error) {
+	s.ended, s.err = true, err
+}
+
+type fakeSpanKey struct{}
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) StartSpan(ctx context.Context, name string) (context.Context, model.Span) {
+	parent, _ := ctx.Value(fakeSpanKey{}).(*fakeSpan)
+	span := &fakeSpan{attributes: make(map[string]string), name: name, parent: parent}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, fakeSpanKey{}, span), span
+}
+
+func TestStartSpanWithTracer(t *testing.T) {
+	tracer := &fakeTracer{}
+	ctx := model.WithTracer(context.Background(), tracer)
+	if model.ContextTracer(ctx) != tracer {
+		t.Fatal("not the tracer we expected")
+	}
+	ctx, parent := model.StartSpan(ctx, "parent")
+	_, child := model.StartSpan(ctx, "child")
+	child.SetAttribute("key", "value")
+	expected := errors.New("mocked error")
+	child.End(expected)
+	parent.End(nil)
+	if len(tracer.spans) != 2 {
+		t.Fatal("unexpected number of spans")
+	}
+	span := tracer.spans[1]
+	if span.name != "child" || span.parent != tracer.spans[0] {
+		t.Fatal("not the span we expected")
+	}
+	if span.attributes["key"] != "value" || !span.ended || span.err != expected {
+		t.Fatal("the span was not updated")
+	}
+}
+
+func TestStartSpanWithoutTracer(t *testing.T) {
+	ctx := context.Background()
+	if model.ContextTracer(ctx) != nil {
+		t.Fatal("expected no tracer here")
+	}
+	spanCtx, span := model.StartSpan(ctx, "antani")
+	if spanCtx != ctx {
+		t.Fatal("expected the same context")
+	}
+	span.SetAttribute("key", "value")
+	span.End(nil)
+}
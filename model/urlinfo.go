@@ -1,8 +1,11 @@
 package model
 
-// URLInfo contains info on a test lists URL
+// URLInfo contains info on a test lists URL. Options are the experiment
+// options to use when measuring this URL, e.g., when the URL comes from
+// an input file specifying per-input options.
 type URLInfo struct {
-	CategoryCode string `json:"category_code"`
-	CountryCode  string `json:"country_code"`
-	URL          string `json:"url"`
+	CategoryCode string                 `json:"category_code"`
+	CountryCode  string                 `json:"country_code"`
+	Options      map[string]interface{} `json:"options,omitempty"`
+	URL          string                 `json:"url"`
 }
@@ -1,8 +1,15 @@
 package model
 
-// URLListConfig contains configuration for fetching the URL list.
-type URLListConfig struct {
+// FetchURLListConfig contains configuration for fetching the URL list.
+type FetchURLListConfig struct {
 	Categories  []string // Categories to query for (empty means all)
 	CountryCode string   // CountryCode is the optional country code
 	Limit       int64    // Max number of URLs (<= 0 means no limit)
+	Offset      int64    // Number of URLs to skip (<= 0 means none)
 }
+
+// URLListConfig contains configuration for fetching the URL list.
+//
+// This type is deprecated and will be removed in the future. Please
+// use FetchURLListConfig instead.
+type URLListConfig = FetchURLListConfig
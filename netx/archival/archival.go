@@ -1,5 +1,10 @@
 // Package archival contains data formats used for archival.
 //
+// These are the typed DNS, TCP connect, TLS handshake, HTTP, and network
+// events entries that the experiments include into their test keys (e.g.,
+// webconnectivity and the experiments using urlgetter). The only experiment
+// still using the legacy oonidatamodel copy of these types is tor.
+//
 // See https://github.com/ooni/spec.
 package archival
 
@@ -122,6 +127,15 @@ func NewFailure(err error) *string {
 	return &s
 }
 
+// FailureString returns the failure, or "success" if failure is nil. This is
+// useful to describe the result of an operation in progress messages.
+func FailureString(failure *string) string {
+	if failure != nil {
+		return *failure
+	}
+	return "success"
+}
+
 // NewFailedOperation creates a failed operation string from the given error.
 func NewFailedOperation(err error) *string {
 	if err == nil {
@@ -912,6 +912,16 @@ func TestHTTPHeader_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestFailureString(t *testing.T) {
+	if archival.FailureString(nil) != "success" {
+		t.Fatal("unexpected result for nil failure")
+	}
+	failure := "connection_refused"
+	if archival.FailureString(&failure) != failure {
+		t.Fatal("unexpected result for non-nil failure")
+	}
+}
+
 func TestNewFailure(t *testing.T) {
 	type args struct {
 		err error
@@ -0,0 +1,90 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// ConnLimiter limits the number of connections that can be open at
+// the same time. A connection counts against the limit until it is
+// closed. Use WithConnLimiter to assign a ConnLimiter to a context.
+type ConnLimiter struct {
+	tokens chan struct{}
+}
+
+// NewConnLimiter creates a new ConnLimiter allowing at most
+// maxConns connections. The maxConns argument MUST be positive.
+func NewConnLimiter(maxConns int) *ConnLimiter {
+	return &ConnLimiter{tokens: make(chan struct{}, maxConns)}
+}
+
+// Open returns the number of connections currently open.
+func (cl *ConnLimiter) Open() int {
+	return len(cl.tokens)
+}
+
+func (cl *ConnLimiter) acquire(ctx context.Context) error {
+	select {
+	case cl.tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (cl *ConnLimiter) release() {
+	<-cl.tokens
+}
+
+// ConnLimiterDialer is a dialer that honours the ConnLimiter in the
+// context, if any. When the limit has been reached, DialContext waits for
+// a connection to be closed or for the context to be done. Beware that
+// connections kept alive in a connection pool count against the limit, so
+// the limit should be larger than the connections used by a measurement.
+type ConnLimiterDialer struct {
+	Dialer
+}
+
+// DialContext implements Dialer.DialContext
+func (d ConnLimiterDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	limiter := ContextConnLimiter(ctx)
+	if limiter == nil {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+	if err := limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		limiter.release()
+		return nil, err
+	}
+	return &connLimiterConnWrapper{Conn: conn, limiter: limiter}, nil
+}
+
+type connLimiterKey struct{}
+
+// ContextConnLimiter retrieves the ConnLimiter from the context
+func ContextConnLimiter(ctx context.Context) *ConnLimiter {
+	limiter, _ := ctx.Value(connLimiterKey{}).(*ConnLimiter)
+	return limiter
+}
+
+// WithConnLimiter assigns the ConnLimiter to the context
+func WithConnLimiter(ctx context.Context, limiter *ConnLimiter) context.Context {
+	return context.WithValue(ctx, connLimiterKey{}, limiter)
+}
+
+type connLimiterConnWrapper struct {
+	net.Conn
+	limiter *ConnLimiter
+	once    sync.Once
+}
+
+func (c *connLimiterConnWrapper) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.limiter.release)
+	return err
+}
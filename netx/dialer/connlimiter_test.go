@@ -0,0 +1,66 @@
+package dialer_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+func TestConnLimiterDialerNoLimiter(t *testing.T) {
+	d := dialer.ConnLimiterDialer{Dialer: dialer.FakeDialer{Conn: &dialer.FakeConn{}}}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*dialer.FakeConn); !ok {
+		t.Fatal("expected the conn not to be wrapped")
+	}
+}
+
+func TestConnLimiterDialerLimits(t *testing.T) {
+	limiter := dialer.NewConnLimiter(1)
+	ctx := dialer.WithConnLimiter(context.Background(), limiter)
+	d := dialer.ConnLimiterDialer{Dialer: dialer.FakeDialer{Conn: &dialer.FakeConn{}}}
+	conn, err := d.DialContext(ctx, "tcp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limiter.Open() != 1 {
+		t.Fatal("not the number of open connections we expected")
+	}
+	timedctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialContext(timedctx, "tcp", "8.8.8.8:53"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected")
+	}
+	conn.Close()
+	conn.Close() // must be idempotent
+	if limiter.Open() != 0 {
+		t.Fatal("not the number of open connections we expected")
+	}
+	conn, err = d.DialContext(ctx, "tcp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestConnLimiterDialerFailure(t *testing.T) {
+	limiter := dialer.NewConnLimiter(1)
+	ctx := dialer.WithConnLimiter(context.Background(), limiter)
+	expected := errors.New("mocked error")
+	d := dialer.ConnLimiterDialer{Dialer: dialer.FakeDialer{Err: expected}}
+	conn, err := d.DialContext(ctx, "tcp", "8.8.8.8:53")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+	if limiter.Open() != 0 {
+		t.Fatal("the failed dial should not count against the limit")
+	}
+}
@@ -0,0 +1,74 @@
+package dialer
+
+import (
+	"context"
+	"net"
+
+	"github.com/ooni/probe-engine/netx/bytecounter"
+)
+
+// PacketListener creates unconnected UDP sockets, which we need for
+// protocols like STUN that talk to several servers from the same socket.
+type PacketListener interface {
+	ListenPacket(ctx context.Context, network string) (net.PacketConn, error)
+}
+
+// SystemPacketListener creates sockets bound to a random local port.
+type SystemPacketListener struct{}
+
+// ListenPacket implements PacketListener.ListenPacket
+func (SystemPacketListener) ListenPacket(
+	ctx context.Context, network string) (net.PacketConn, error) {
+	return new(net.ListenConfig).ListenPacket(ctx, network, ":0")
+}
+
+// ByteCounterPacketListener is a byte-counting-aware PacketListener. It counts
+// the bytes using Counter, if not nil, and the counters in the context.
+type ByteCounterPacketListener struct {
+	PacketListener
+	Counter *bytecounter.Counter
+}
+
+// ListenPacket implements PacketListener.ListenPacket
+func (pl ByteCounterPacketListener) ListenPacket(
+	ctx context.Context, network string) (net.PacketConn, error) {
+	pconn, err := pl.PacketListener.ListenPacket(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	var counters []*bytecounter.Counter
+	for _, counter := range []*bytecounter.Counter{
+		pl.Counter, ContextExperimentByteCounter(ctx), ContextSessionByteCounter(ctx),
+	} {
+		if counter != nil {
+			counters = append(counters, counter)
+		}
+	}
+	if len(counters) <= 0 {
+		return pconn, nil // no point in wrapping
+	}
+	// We return a pointer so that the result is comparable, because some
+	// users (e.g., quic-go) use the PacketConn as a map key.
+	return &byteCounterPacketConnWrapper{PacketConn: pconn, counters: counters}, nil
+}
+
+type byteCounterPacketConnWrapper struct {
+	net.PacketConn
+	counters []*bytecounter.Counter
+}
+
+func (c *byteCounterPacketConnWrapper) ReadFrom(p []byte) (int, net.Addr, error) {
+	count, addr, err := c.PacketConn.ReadFrom(p)
+	for _, counter := range c.counters {
+		counter.CountBytesReceived(count)
+	}
+	return count, addr, err
+}
+
+func (c *byteCounterPacketConnWrapper) WriteTo(p []byte, addr net.Addr) (int, error) {
+	count, err := c.PacketConn.WriteTo(p, addr)
+	for _, counter := range c.counters {
+		counter.CountBytesSent(count)
+	}
+	return count, err
+}
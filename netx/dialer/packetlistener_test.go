@@ -0,0 +1,46 @@
+package dialer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/bytecounter"
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+func TestByteCounterPacketListener(t *testing.T) {
+	counter := bytecounter.New()
+	exp := bytecounter.New()
+	ctx := dialer.WithExperimentByteCounter(context.Background(), exp)
+	pl := dialer.ByteCounterPacketListener{
+		PacketListener: dialer.SystemPacketListener{},
+		Counter:        counter,
+	}
+	pconn, err := pl.ListenPacket(ctx, "udp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pconn.Close()
+	addr := pconn.LocalAddr()
+	if _, err := pconn.WriteTo(make([]byte, 128), addr); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 1024)
+	if _, _, err := pconn.ReadFrom(buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*bytecounter.Counter{counter, exp} {
+		if c.BytesSent() != 128 || c.BytesReceived() != 128 {
+			t.Fatal("unexpected byte counts")
+		}
+	}
+}
+
+func TestByteCounterPacketListenerNoCounters(t *testing.T) {
+	pl := dialer.ByteCounterPacketListener{PacketListener: dialer.SystemPacketListener{}}
+	pconn, err := pl.ListenPacket(context.Background(), "udp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pconn.Close()
+}
@@ -0,0 +1,32 @@
+package dialer
+
+import (
+	"context"
+	"net"
+
+	"github.com/ooni/probe-engine/eventbus"
+	"github.com/ooni/probe-engine/model"
+)
+
+// TracingDialer creates a span for each dial, using the tracer in the
+// context, if any (see model.WithTracer), and publishes an eventbus.Dial
+// event on the bus in the context, if any (see eventbus.WithBus).
+type TracingDialer struct {
+	Dialer
+}
+
+// DialContext implements Dialer.DialContext
+func (d TracingDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	ctx, span := model.StartSpan(ctx, "netx.dial")
+	span.SetAttribute("network", network)
+	span.SetAttribute("address", address)
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	span.End(err)
+	ev := eventbus.Dial{Address: address, Network: network}
+	if err != nil {
+		ev.Failure = err.Error()
+	}
+	eventbus.Publish(ctx, ev)
+	return conn, err
+}
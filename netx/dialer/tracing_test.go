@@ -0,0 +1,77 @@
+package dialer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ooni/probe-engine/eventbus"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+type dialSpan struct {
+	attributes map[string]string
+	err        error
+}
+
+func (s *dialSpan) SetAttribute(key, value string) {
+	s.attributes[key] = value
+}
+
+func (s *dialSpan) End(err error) {
+	s.err = err
+}
+
+type dialTracer struct {
+	span *dialSpan
+}
+
+func (t *dialTracer) StartSpan(ctx context.Context, name string) (context.Context, model.Span) {
+	t.span = &dialSpan{attributes: map[string]string{"name": name}}
+	return ctx, t.span
+}
+
+func TestUnitTracingDialer(t *testing.T) {
+	tracer := &dialTracer{}
+	ctx := model.WithTracer(context.Background(), tracer)
+	d := dialer.TracingDialer{Dialer: dialer.EOFDialer{}}
+	conn, err := d.DialContext(ctx, "tcp", "www.google.com:443")
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+	span := tracer.span
+	if span == nil || span.attributes["name"] != "netx.dial" {
+		t.Fatal("no span was created")
+	}
+	if span.attributes["network"] != "tcp" || span.attributes["address"] != "www.google.com:443" {
+		t.Fatal("unexpected attributes", span.attributes)
+	}
+	if !errors.Is(span.err, io.EOF) {
+		t.Fatal("the span does not contain the error")
+	}
+}
+
+func TestUnitTracingDialerPublishesDial(t *testing.T) {
+	bus := eventbus.New()
+	var events []eventbus.Event
+	bus.Subscribe(func(ev eventbus.Event) {
+		events = append(events, ev)
+	})
+	ctx := eventbus.WithBus(context.Background(), bus)
+	d := dialer.TracingDialer{Dialer: dialer.EOFDialer{}}
+	if _, err := d.DialContext(ctx, "tcp", "www.google.com:443"); !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	if len(events) != 1 {
+		t.Fatal("expected a single event")
+	}
+	ev, ok := events[0].(eventbus.Dial)
+	if !ok || ev.Network != "tcp" || ev.Address != "www.google.com:443" || ev.Failure != io.EOF.Error() {
+		t.Fatalf("unexpected event: %+v", events[0])
+	}
+}
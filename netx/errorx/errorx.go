@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"syscall"
 )
 
 const (
@@ -16,6 +17,9 @@ const (
 	// FailureConnectionReset means ECONNRESET.
 	FailureConnectionReset = "connection_reset"
 
+	// FailureConnectionAborted means ECONNABORTED.
+	FailureConnectionAborted = "connection_aborted"
+
 	// FailureDNSBogonError means we detected bogon in DNS reply.
 	FailureDNSBogonError = "dns_bogon_error"
 
@@ -28,9 +32,19 @@ const (
 	// FailureGenericTimeoutError means we got some timer has expired.
 	FailureGenericTimeoutError = "generic_timeout_error"
 
+	// FailureHostUnreachable means EHOSTUNREACH.
+	FailureHostUnreachable = "host_unreachable"
+
 	// FailureInterrupted means that the user interrupted us.
 	FailureInterrupted = "interrupted"
 
+	// FailureNetworkUnreachable means ENETUNREACH.
+	FailureNetworkUnreachable = "network_unreachable"
+
+	// FailureSSLFailedHandshake means the peer sent us a TLS alert
+	// during the handshake. The alert number is in ErrWrapper.TLSAlert.
+	FailureSSLFailedHandshake = "ssl_failed_handshake"
+
 	// FailureSSLInvalidHostname means we got certificate is not valid for SNI.
 	FailureSSLInvalidHostname = "ssl_invalid_hostname"
 
@@ -90,6 +104,11 @@ type ErrWrapper struct {
 	// DialID is the dial ID, or zero if not known.
 	DialID int64
 
+	// Errno is the system error number, or zero if not known. Because
+	// errno values are platform specific, you SHOULD compare this
+	// field with the constants defined by the syscall package.
+	Errno syscall.Errno
+
 	// Failure is the OONI failure string. The failure strings are
 	// loosely backward compatible with Measurement Kit.
 	//
@@ -98,6 +117,10 @@ type ErrWrapper struct {
 	// error that we have not yet mapped to a failure.
 	Failure string
 
+	// HTTPStatusCode is the HTTP status code we had received when
+	// the failure occurred, or zero if not known.
+	HTTPStatusCode int64
+
 	// Operation is the operation that failed. If possible, it
 	// SHOULD be a _major_ operation. Major operations are:
 	//
@@ -122,6 +145,11 @@ type ErrWrapper struct {
 	// supposed to refer to the major operation that failed.
 	Operation string
 
+	// TLSAlert is the TLS alert sent by the peer, or zero if not
+	// known. Note that zero is close_notify, which is never a
+	// failure, so there is no ambiguity here.
+	TLSAlert uint8
+
 	// TransactionID is the transaction ID, or zero if not known.
 	TransactionID int64
 
@@ -151,6 +179,9 @@ type SafeErrWrapperBuilder struct {
 	// Error is the error, if any
 	Error error
 
+	// HTTPStatusCode is the HTTP status code, if any
+	HTTPStatusCode int64
+
 	// Operation is the operation that failed
 	Operation string
 
@@ -163,12 +194,15 @@ type SafeErrWrapperBuilder struct {
 func (b SafeErrWrapperBuilder) MaybeBuild() (err error) {
 	if b.Error != nil {
 		err = &ErrWrapper{
-			ConnID:        b.ConnID,
-			DialID:        b.DialID,
-			Failure:       toFailureString(b.Error),
-			Operation:     toOperationString(b.Error, b.Operation),
-			TransactionID: b.TransactionID,
-			WrappedErr:    b.Error,
+			ConnID:         b.ConnID,
+			DialID:         b.DialID,
+			Errno:          toErrno(b.Error),
+			Failure:        toFailureString(b.Error),
+			HTTPStatusCode: toHTTPStatusCode(b.Error, b.HTTPStatusCode),
+			Operation:      toOperationString(b.Error, b.Operation),
+			TLSAlert:       toTLSAlert(b.Error),
+			TransactionID:  b.TransactionID,
+			WrappedErr:     b.Error,
 		}
 	}
 	return
@@ -207,6 +241,10 @@ func toFailureString(err error) string {
 		return FailureSSLInvalidCertificate
 	}
 
+	if toTLSAlert(err) != 0 {
+		return FailureSSLFailedHandshake // not in MK
+	}
+
 	s := err.Error()
 	if strings.HasSuffix(s, "operation was canceled") {
 		return FailureInterrupted
@@ -220,6 +258,15 @@ func toFailureString(err error) string {
 	if strings.HasSuffix(s, "connection reset by peer") {
 		return FailureConnectionReset
 	}
+	if strings.HasSuffix(s, "software caused connection abort") {
+		return FailureConnectionAborted // not in MK
+	}
+	if strings.HasSuffix(s, "no route to host") {
+		return FailureHostUnreachable // not in MK
+	}
+	if strings.HasSuffix(s, "network is unreachable") {
+		return FailureNetworkUnreachable // not in MK
+	}
 	if strings.HasSuffix(s, "context deadline exceeded") {
 		return FailureGenericTimeoutError
 	}
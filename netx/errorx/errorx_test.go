@@ -96,6 +96,21 @@ func TestToFailureString(t *testing.T) {
 			t.Fatal("unexpected results")
 		}
 	})
+	t.Run("for connection_aborted", func(t *testing.T) {
+		if toFailureString(syscall.ECONNABORTED) != FailureConnectionAborted {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for host_unreachable", func(t *testing.T) {
+		if toFailureString(syscall.EHOSTUNREACH) != FailureHostUnreachable {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for network_unreachable", func(t *testing.T) {
+		if toFailureString(syscall.ENETUNREACH) != FailureNetworkUnreachable {
+			t.Fatal("unexpected results")
+		}
+	})
 	t.Run("for context deadline exceeded", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1)
 		defer cancel()
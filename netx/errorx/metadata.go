@@ -0,0 +1,49 @@
+package errorx
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+)
+
+// The functions in this file extract machine readable metadata from
+// errors. This allows code that processes measurements to branch on
+// the specific cause of a failure rather than on its failure string.
+
+func toErrno(err error) syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return 0
+}
+
+func toHTTPStatusCode(err error, code int64) int64 {
+	if code != 0 {
+		return code
+	}
+	var errwrapper *ErrWrapper
+	if errors.As(err, &errwrapper) {
+		return errwrapper.HTTPStatusCode
+	}
+	return 0
+}
+
+func toTLSAlert(err error) uint8 {
+	// The crypto/tls package does not export its alert type, so we
+	// need to use reflection to recognize it in the error chain.
+	for ; err != nil; err = errors.Unwrap(err) {
+		if errwrapper, ok := err.(*ErrWrapper); ok {
+			if errwrapper.TLSAlert != 0 {
+				return errwrapper.TLSAlert
+			}
+			continue
+		}
+		t := reflect.TypeOf(err)
+		if t.PkgPath() == "crypto/tls" && t.Name() == "alert" &&
+			t.Kind() == reflect.Uint8 {
+			return uint8(reflect.ValueOf(err).Uint())
+		}
+	}
+	return 0
+}
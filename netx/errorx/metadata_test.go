@@ -0,0 +1,104 @@
+package errorx
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestToErrno(t *testing.T) {
+	t.Run("for errors without errno", func(t *testing.T) {
+		if toErrno(errors.New("mocked error")) != 0 {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("for wrapped errno", func(t *testing.T) {
+		err := &net.OpError{Op: "dial", Err: fmt.Errorf("x: %w", syscall.ECONNREFUSED)}
+		if toErrno(err) != syscall.ECONNREFUSED {
+			t.Fatal("unexpected result")
+		}
+	})
+}
+
+func TestToHTTPStatusCode(t *testing.T) {
+	t.Run("with explicit code", func(t *testing.T) {
+		if toHTTPStatusCode(errors.New("mocked error"), 403) != 403 {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("inherited from child wrapper", func(t *testing.T) {
+		child := &ErrWrapper{HTTPStatusCode: 451}
+		if toHTTPStatusCode(fmt.Errorf("x: %w", child), 0) != 451 {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("when not known", func(t *testing.T) {
+		if toHTTPStatusCode(errors.New("mocked error"), 0) != 0 {
+			t.Fatal("unexpected result")
+		}
+	})
+}
+
+func TestToTLSAlert(t *testing.T) {
+	t.Run("for errors without alert", func(t *testing.T) {
+		if toTLSAlert(errors.New("mocked error")) != 0 {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("for child wrapper with alert", func(t *testing.T) {
+		child := &ErrWrapper{TLSAlert: 40}
+		if toTLSAlert(fmt.Errorf("x: %w", child)) != 40 {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("for real TLS alert", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+		go func() {
+			// The server only speaks TLSv1.0 and therefore it is going to
+			// send us a protocol_version (70) alert.
+			tls.Server(server, &tls.Config{
+				MaxVersion: tls.VersionTLS10,
+			}).Handshake()
+			server.Close()
+		}()
+		err := tls.Client(client, &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: "example.com",
+		}).Handshake()
+		if err == nil {
+			t.Fatal("expected an error here")
+		}
+		if toTLSAlert(err) != 70 {
+			t.Fatal("unexpected result", err)
+		}
+		if toFailureString(err) != FailureSSLFailedHandshake {
+			t.Fatal("unexpected failure string")
+		}
+	})
+}
+
+func TestMaybeBuildWithMetadata(t *testing.T) {
+	err := SafeErrWrapperBuilder{
+		Error:          syscall.ECONNRESET,
+		HTTPStatusCode: 200,
+		Operation:      ReadOperation,
+	}.MaybeBuild()
+	var target *ErrWrapper
+	if !errors.As(err, &target) {
+		t.Fatal("not the expected error type")
+	}
+	if target.Errno != syscall.ECONNRESET {
+		t.Fatal("wrong Errno")
+	}
+	if target.HTTPStatusCode != 200 {
+		t.Fatal("wrong HTTPStatusCode")
+	}
+	if target.TLSAlert != 0 {
+		t.Fatal("wrong TLSAlert")
+	}
+}
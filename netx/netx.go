@@ -52,6 +52,11 @@ type TLSDialer interface {
 	DialTLSContext(ctx context.Context, network, address string) (net.Conn, error)
 }
 
+// PacketListener is the definition of a UDP socket factory assumed by this package.
+type PacketListener interface {
+	ListenPacket(ctx context.Context, network string) (net.PacketConn, error)
+}
+
 // HTTPRoundTripper is the definition of http.HTTPRoundTripper used by this package.
 type HTTPRoundTripper interface {
 	RoundTrip(req *http.Request) (*http.Response, error)
@@ -71,16 +76,19 @@ type Resolver interface {
 // We use different savers for different kind of events such that the
 // user of this library can choose what to save.
 type Config struct {
+	BasePacketListener  PacketListener       // default: system packet listener
 	BaseResolver        Resolver             // default: system resolver
 	BogonIsError        bool                 // default: bogon is not error
 	ByteCounter         *bytecounter.Counter // default: no explicit byte counting
 	CacheResolutions    bool                 // default: no caching
+	CertPool            *x509.CertPool       // default: use netx.CertPool
 	ContextByteCounting bool                 // default: no implicit byte counting
 	DNSCache            map[string][]string  // default: cache is empty
 	DialSaver           *trace.Saver         // default: not saving dials
 	Dialer              Dialer               // default: dialer.DNSDialer
 	FullResolver        Resolver             // default: base resolver + goodies
 	HTTPSaver           *trace.Saver         // default: not saving HTTP
+	HTTPSnapshotSize    int                  // default: 1<<17 bytes
 	Logger              Logger               // default: no logging
 	NoTLSVerify         bool                 // default: perform TLS verify
 	ProxyURL            *url.URL             // default: no proxy
@@ -154,13 +162,29 @@ func NewDialer(config Config) Dialer {
 	}
 	d = dialer.DNSDialer{Resolver: config.FullResolver, Dialer: d}
 	d = dialer.ProxyDialer{ProxyURL: config.ProxyURL, Dialer: d}
+	d = dialer.TracingDialer{Dialer: d}
 	if config.ContextByteCounting {
 		d = dialer.ByteCounterDialer{Dialer: d}
 	}
+	d = dialer.ConnLimiterDialer{Dialer: d}
 	d = dialer.ShapingDialer{Dialer: d}
 	return d
 }
 
+// NewPacketListener creates a new PacketListener from the specified config. The
+// listener performs byte counting like the dialer returned by NewDialer.
+func NewPacketListener(config Config) PacketListener {
+	var pl PacketListener = dialer.SystemPacketListener{}
+	if config.BasePacketListener != nil {
+		pl = config.BasePacketListener
+	}
+	if config.ByteCounter != nil || config.ContextByteCounting {
+		pl = dialer.ByteCounterPacketListener{
+			PacketListener: pl, Counter: config.ByteCounter}
+	}
+	return pl
+}
+
 // NewTLSDialer creates a new TLSDialer from the specified config
 func NewTLSDialer(config Config) TLSDialer {
 	if config.Dialer == nil {
@@ -178,7 +202,10 @@ func NewTLSDialer(config Config) TLSDialer {
 	if config.TLSConfig == nil {
 		config.TLSConfig = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
 	}
-	config.TLSConfig.RootCAs = CertPool // always use our own CA
+	if config.CertPool == nil {
+		config.CertPool = CertPool // use our own CA by default
+	}
+	config.TLSConfig.RootCAs = config.CertPool
 	config.TLSConfig.InsecureSkipVerify = config.NoTLSVerify
 	return dialer.TLSDialer{
 		Config:        config.TLSConfig,
@@ -209,7 +236,8 @@ func NewHTTPTransport(config Config) HTTPRoundTripper {
 		txp = httptransport.SaverMetadataHTTPTransport{
 			RoundTripper: txp, Saver: config.HTTPSaver}
 		txp = httptransport.SaverBodyHTTPTransport{
-			RoundTripper: txp, Saver: config.HTTPSaver}
+			RoundTripper: txp, Saver: config.HTTPSaver,
+			SnapshotSize: config.HTTPSnapshotSize}
 		txp = httptransport.SaverPerformanceHTTPTransport{
 			RoundTripper: txp, Saver: config.HTTPSaver}
 		txp = httptransport.SaverTransactionHTTPTransport{
@@ -233,6 +261,23 @@ func (c DNSClient) CloseIdleConnections() {
 	}
 }
 
+// ParseResolverURL parses a resolver URL for NewDNSClient, after expanding
+// the `doh://powerdns`, `doh://google` and `doh://cloudflare` aliases and
+// mapping the empty URL to `system:///`.
+func ParseResolverURL(URL string) (*url.URL, error) {
+	switch URL {
+	case "doh://powerdns":
+		URL = "https://doh.powerdns.org/"
+	case "doh://google":
+		URL = "https://dns.google/dns-query"
+	case "doh://cloudflare":
+		URL = "https://cloudflare-dns.com/dns-query"
+	case "":
+		URL = "system:///"
+	}
+	return url.Parse(URL)
+}
+
 // NewDNSClient creates a new DNS client. The config argument is used to
 // create the underlying Dialer and/or HTTP transport, if needed. The URL
 // argument describes the kind of client that we want to make:
@@ -246,6 +291,13 @@ func (c DNSClient) CloseIdleConnections() {
 // - if the URL starts with `udp://`, then we create a client using
 // a resolver that uses the specified UDP endpoint.
 //
+// - if the URL starts with `tor://`, then we create a client using
+// the tor SOCKS5 RESOLVE extension with the SOCKS5 port of tor
+// listening at the specified endpoint (e.g. `tor://127.0.0.1:9050`).
+//
+// To send DNS over TCP queries through tor's exit nodes, instead, use
+// a `tcp://` URL and set config.ProxyURL to tor's SOCKS5 port.
+//
 // We return error if the URL does not parse or the URL scheme does not
 // fall into one of the cases described above.
 //
@@ -253,20 +305,11 @@ func (c DNSClient) CloseIdleConnections() {
 // resolver where this is possible, we will also save events.
 func NewDNSClient(config Config, URL string) (DNSClient, error) {
 	var c DNSClient
-	switch URL {
-	case "doh://powerdns":
-		URL = "https://doh.powerdns.org/"
-	case "doh://google":
-		URL = "https://dns.google/dns-query"
-	case "doh://cloudflare":
-		URL = "https://cloudflare-dns.com/dns-query"
-	case "":
-		URL = "system:///"
-	}
-	resolverURL, err := url.Parse(URL)
+	resolverURL, err := ParseResolverURL(URL)
 	if err != nil {
 		return c, err
 	}
+	URL = resolverURL.String()
 	switch resolverURL.Scheme {
 	case "system":
 		c.Resolver = resolver.SystemResolver{}
@@ -317,6 +360,18 @@ func NewDNSClient(config Config, URL string) (DNSClient, error) {
 		}
 		c.Resolver = resolver.NewSerialResolver(txp)
 		return c, nil
+	case "tor":
+		dialer := NewDialer(config)
+		var r Resolver = resolver.NewTorResolver(
+			dialer.DialContext, resolverURL.Host)
+		if config.ResolveSaver != nil {
+			r = resolver.SaverResolver{
+				Resolver: r,
+				Saver:    config.ResolveSaver,
+			}
+		}
+		c.Resolver = r
+		return c, nil
 	default:
 		return c, errors.New("unsupported resolver scheme")
 	}
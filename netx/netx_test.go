@@ -2,6 +2,7 @@ package netx_test
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"net/http"
 	"strings"
@@ -187,7 +188,15 @@ func TestNewDialerVanilla(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := trd.Dialer.(dialer.ProxyDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
@@ -227,7 +236,15 @@ func TestNewDialerWithResolver(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := trd.Dialer.(dialer.ProxyDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
@@ -265,7 +282,15 @@ func TestNewDialerWithLogger(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := trd.Dialer.(dialer.ProxyDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
@@ -311,7 +336,15 @@ func TestNewDialerWithDialSaver(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := trd.Dialer.(dialer.ProxyDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
@@ -357,7 +390,15 @@ func TestNewDialerWithReadWriteSaver(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := trd.Dialer.(dialer.ProxyDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
@@ -402,11 +443,19 @@ func TestNewDialerWithContextByteCounting(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	bcd, ok := sd.Dialer.(dialer.ByteCounterDialer)
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	bcd, ok := cld.Dialer.(dialer.ByteCounterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := bcd.Dialer.(dialer.TracingDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	pd, ok := bcd.Dialer.(dialer.ProxyDialer)
+	pd, ok := trd.Dialer.(dialer.ProxyDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
@@ -436,6 +485,27 @@ func TestNewDialerWithContextByteCounting(t *testing.T) {
 	}
 }
 
+func TestNewPacketListenerVanilla(t *testing.T) {
+	pl := netx.NewPacketListener(netx.Config{})
+	if _, ok := pl.(dialer.SystemPacketListener); !ok {
+		t.Fatal("not the packet listener we expected")
+	}
+}
+
+func TestNewPacketListenerWithBaseAndByteCounter(t *testing.T) {
+	base := dialer.SystemPacketListener{}
+	counter := bytecounter.New()
+	pl := netx.NewPacketListener(netx.Config{
+		BasePacketListener: base, ByteCounter: counter})
+	bcpl, ok := pl.(dialer.ByteCounterPacketListener)
+	if !ok {
+		t.Fatal("not the packet listener we expected")
+	}
+	if bcpl.Counter != counter || bcpl.PacketListener != base {
+		t.Fatal("not the packet listener we expected")
+	}
+}
+
 func TestNewTLSDialerVanilla(t *testing.T) {
 	td := netx.NewTLSDialer(netx.Config{})
 	rtd, ok := td.(dialer.TLSDialer)
@@ -458,7 +528,15 @@ func TestNewTLSDialerVanilla(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := sd.Dialer.(dialer.ProxyDialer); !ok {
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := trd.Dialer.(dialer.ProxyDialer); !ok {
 		t.Fatal("not the Dialer we expected")
 	}
 	if rtd.TLSHandshaker == nil {
@@ -498,7 +576,15 @@ func TestNewTLSDialerWithConfig(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := sd.Dialer.(dialer.ProxyDialer); !ok {
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := trd.Dialer.(dialer.ProxyDialer); !ok {
 		t.Fatal("not the Dialer we expected")
 	}
 	if rtd.TLSHandshaker == nil {
@@ -517,6 +603,18 @@ func TestNewTLSDialerWithConfig(t *testing.T) {
 	}
 }
 
+func TestNewTLSDialerWithCertPool(t *testing.T) {
+	pool := x509.NewCertPool()
+	td := netx.NewTLSDialer(netx.Config{CertPool: pool})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	if rtd.Config.RootCAs != pool {
+		t.Fatal("invalid Config.RootCAs")
+	}
+}
+
 func TestNewTLSDialerWithLogging(t *testing.T) {
 	td := netx.NewTLSDialer(netx.Config{
 		Logger: log.Log,
@@ -541,7 +639,15 @@ func TestNewTLSDialerWithLogging(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := sd.Dialer.(dialer.ProxyDialer); !ok {
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := trd.Dialer.(dialer.ProxyDialer); !ok {
 		t.Fatal("not the Dialer we expected")
 	}
 	if rtd.TLSHandshaker == nil {
@@ -592,7 +698,15 @@ func TestNewTLSDialerWithSaver(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := sd.Dialer.(dialer.ProxyDialer); !ok {
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := trd.Dialer.(dialer.ProxyDialer); !ok {
 		t.Fatal("not the Dialer we expected")
 	}
 	if rtd.TLSHandshaker == nil {
@@ -643,7 +757,15 @@ func TestNewTLSDialerWithNoTLSVerifyAndConfig(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := sd.Dialer.(dialer.ProxyDialer); !ok {
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := trd.Dialer.(dialer.ProxyDialer); !ok {
 		t.Fatal("not the Dialer we expected")
 	}
 	if rtd.TLSHandshaker == nil {
@@ -689,7 +811,15 @@ func TestNewTLSDialerWithNoTLSVerifyAndNoConfig(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := sd.Dialer.(dialer.ProxyDialer); !ok {
+	cld, ok := sd.Dialer.(dialer.ConnLimiterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	trd, ok := cld.Dialer.(dialer.TracingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := trd.Dialer.(dialer.ProxyDialer); !ok {
 		t.Fatal("not the Dialer we expected")
 	}
 	if rtd.TLSHandshaker == nil {
@@ -838,6 +968,42 @@ func TestNewWithSaver(t *testing.T) {
 	}
 }
 
+func TestNewWithSaverAndSnapshotSize(t *testing.T) {
+	txp := netx.NewHTTPTransport(netx.Config{
+		HTTPSaver:        new(trace.Saver),
+		HTTPSnapshotSize: 1024,
+	})
+	uatxp := txp.(httptransport.UserAgentTransport)
+	stxptxp := uatxp.RoundTripper.(httptransport.SaverTransactionHTTPTransport)
+	sptxp := stxptxp.RoundTripper.(httptransport.SaverPerformanceHTTPTransport)
+	sbtxp, ok := sptxp.RoundTripper.(httptransport.SaverBodyHTTPTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if sbtxp.SnapshotSize != 1024 {
+		t.Fatal("not the snapshot size we expected")
+	}
+}
+
+func TestParseResolverURL(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":                            "system:///",
+		"doh://google":                "https://dns.google/dns-query",
+		"doh://cloudflare":            "https://cloudflare-dns.com/dns-query",
+		"doh://powerdns":              "https://doh.powerdns.org/",
+		"udp://8.8.8.8:53":            "udp://8.8.8.8:53",
+		"https://dns.quad9.net/query": "https://dns.quad9.net/query",
+	} {
+		URL, err := netx.ParseResolverURL(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if URL.String() != expected {
+			t.Fatalf("expected %s, got %s", expected, URL.String())
+		}
+	}
+}
+
 func TestNewDNSClientInvalidURL(t *testing.T) {
 	dnsclient, err := netx.NewDNSClient(netx.Config{}, "\t\t\t")
 	if err == nil || !strings.HasSuffix(err.Error(), "invalid control character in URL") {
@@ -1035,6 +1201,42 @@ func TestNewDNSClientTCPDNSSaver(t *testing.T) {
 	dnsclient.CloseIdleConnections()
 }
 
+func TestNewDNSClientTor(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "tor://127.0.0.1:9050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.TorResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if r.Network() != "tor" {
+		t.Fatal("not the Network we expected")
+	}
+	if r.Address() != "127.0.0.1:9050" {
+		t.Fatal("not the Address we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientTorDNSSaver(t *testing.T) {
+	saver := new(trace.Saver)
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{ResolveSaver: saver}, "tor://127.0.0.1:9050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.SaverResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if _, ok := r.Resolver.(resolver.TorResolver); !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
 func TestNewDNSClientDoT(t *testing.T) {
 	dnsclient, err := netx.NewDNSClient(
 		netx.Config{}, "dot://8.8.8.8:53")
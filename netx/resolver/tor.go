@@ -0,0 +1,147 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// TorResolver is a resolver that asks tor to resolve domain names
+// using the RESOLVE extension to the SOCKS5 protocol. The queries are
+// thus performed by the exit node of the current circuit.
+//
+// Because the RESOLVE command returns a single address, LookupHost
+// will always return at most one address. Also, since tor does not
+// tell us why a resolution failed, we map the host unreachable SOCKS
+// error it uses to a `no such host` error, which errorx maps to the
+// dns_nxdomain_error failure string.
+//
+// If you instead want to send DNS over TCP queries via the exit, you
+// should use DNSOverTCP with a dialer configured to use tor as a
+// SOCKS5 proxy (e.g., via netx.Config.ProxyURL).
+//
+// LookupHost honours the deadline of the context and returns early
+// when the context is cancelled. When the context has no deadline, we
+// use TorResolverDefaultTimeout.
+type TorResolver struct {
+	dial    DialContextFunc
+	address string
+}
+
+// NewTorResolver creates a new TorResolver. The dial function is used
+// to connect to the SOCKS5 port of tor, which listens at address.
+func NewTorResolver(dial DialContextFunc, address string) TorResolver {
+	return TorResolver{dial: dial, address: address}
+}
+
+// TorResolverDefaultTimeout is the timeout used by TorResolver when
+// the context passed to LookupHost has no deadline.
+const TorResolverDefaultTimeout = 10 * time.Second
+
+// The following constants are defined by the SOCKS5 protocol and
+// by tor's extensions to such protocol.
+const (
+	torSOCKSVersion         = 5
+	torSOCKSNoAuth          = 0
+	torSOCKSCmdResolve      = 0xf0
+	torSOCKSAtypIPv4        = 1
+	torSOCKSAtypDomain      = 3
+	torSOCKSAtypIPv6        = 4
+	torSOCKSSucceeded       = 0
+	torSOCKSHostUnreachable = 4
+)
+
+// LookupHost implements Resolver.LookupHost.
+func (r TorResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	if len(hostname) > 255 {
+		return nil, errors.New("tor: hostname too long")
+	}
+	conn, err := r.dial(ctx, "tcp", r.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(TorResolverDefaultTimeout)
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now()) // interrupt pending I/O
+		case <-done:
+		}
+	}()
+	if err := r.authenticate(conn); err != nil {
+		return nil, err
+	}
+	req := []byte{torSOCKSVersion, torSOCKSCmdResolve, 0, torSOCKSAtypDomain}
+	req = append(req, byte(len(hostname)))
+	req = append(req, hostname...)
+	req = append(req, 0, 0) // the port is ignored by tor
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != torSOCKSVersion {
+		return nil, errors.New("tor: unexpected SOCKS version in reply")
+	}
+	switch header[1] {
+	case torSOCKSSucceeded:
+	case torSOCKSHostUnreachable:
+		return nil, &net.DNSError{Err: "no such host", Name: hostname}
+	default:
+		return nil, fmt.Errorf("tor: SOCKS error %d", header[1])
+	}
+	var size int
+	switch header[3] {
+	case torSOCKSAtypIPv4:
+		size = net.IPv4len
+	case torSOCKSAtypIPv6:
+		size = net.IPv6len
+	default:
+		return nil, errors.New("tor: unexpected address type in reply")
+	}
+	address := make([]byte, size+2) // also read the port
+	if _, err := io.ReadFull(conn, address); err != nil {
+		return nil, err
+	}
+	return []string{net.IP(address[:size]).String()}, nil
+}
+
+func (r TorResolver) authenticate(conn net.Conn) error {
+	if _, err := conn.Write([]byte{torSOCKSVersion, 1, torSOCKSNoAuth}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != torSOCKSVersion || reply[1] != torSOCKSNoAuth {
+		return errors.New("tor: SOCKS authentication failed")
+	}
+	return nil
+}
+
+// Network implements Resolver.Network.
+func (r TorResolver) Network() string {
+	return "tor"
+}
+
+// Address implements Resolver.Address.
+func (r TorResolver) Address() string {
+	return r.address
+}
+
+var _ Resolver = TorResolver{}
@@ -0,0 +1,150 @@
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+func TestUnitTorResolverDialFailure(t *testing.T) {
+	mocked := errors.New("mocked error")
+	fakedialer := resolver.FakeDialer{Err: mocked}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), "www.google.com")
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestUnitTorResolverHostnameTooLong(t *testing.T) {
+	r := resolver.NewTorResolver(resolver.FakeDialer{}.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), strings.Repeat("x", 256))
+	if err == nil || err.Error() != "tor: hostname too long" {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestUnitTorResolverAuthFailure(t *testing.T) {
+	fakedialer := resolver.FakeDialer{Conn: &resolver.FakeConn{
+		ReadData: []byte{5, 0xff},
+	}}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), "www.google.com")
+	if err == nil || err.Error() != "tor: SOCKS authentication failed" {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestUnitTorResolverHostUnreachable(t *testing.T) {
+	fakedialer := resolver.FakeDialer{Conn: &resolver.FakeConn{
+		ReadData: []byte{5, 0, 5, 4, 0, 1},
+	}}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), "www.antani.xyz")
+	if err == nil || !strings.HasSuffix(err.Error(), "no such host") {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestUnitTorResolverOtherSOCKSError(t *testing.T) {
+	fakedialer := resolver.FakeDialer{Conn: &resolver.FakeConn{
+		ReadData: []byte{5, 0, 5, 1, 0, 1},
+	}}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), "www.google.com")
+	if err == nil || err.Error() != "tor: SOCKS error 1" {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestUnitTorResolverIPv4Success(t *testing.T) {
+	fakedialer := resolver.FakeDialer{Conn: &resolver.FakeConn{
+		ReadData: []byte{5, 0, 5, 0, 0, 1, 8, 8, 4, 4, 0, 0},
+	}}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.4.4" {
+		t.Fatal("not the addrs we expected")
+	}
+}
+
+func TestUnitTorResolverIPv6Success(t *testing.T) {
+	reply := []byte{5, 0, 5, 0, 0, 4}
+	reply = append(reply, make([]byte, 15)...)
+	reply = append(reply, 1, 0, 0)
+	fakedialer := resolver.FakeDialer{Conn: &resolver.FakeConn{ReadData: reply}}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "::1" {
+		t.Fatal("not the addrs we expected")
+	}
+}
+
+func TestUnitTorResolverUnexpectedAddressType(t *testing.T) {
+	fakedialer := resolver.FakeDialer{Conn: &resolver.FakeConn{
+		ReadData: []byte{5, 0, 5, 0, 0, 3},
+	}}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	addrs, err := r.LookupHost(context.Background(), "www.google.com")
+	if err == nil || err.Error() != "tor: unexpected address type in reply" {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestUnitTorResolverHonoursContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		// read the request and never reply
+		buf := make([]byte, 1024)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	fakedialer := resolver.FakeDialer{Conn: client}
+	r := resolver.NewTorResolver(fakedialer.DialContext, "127.0.0.1:9050")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	addrs, err := r.LookupHost(ctx, "www.google.com")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+	if elapsed := time.Since(start); elapsed >= resolver.TorResolverDefaultTimeout/2 {
+		t.Fatal("we did not honour the context deadline", elapsed)
+	}
+}
@@ -2,11 +2,15 @@ package oonimkall
 
 import (
 	"fmt"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
 )
 
 // chanLogger is a logger targeting a channel
 type chanLogger struct {
 	emitter    *eventEmitter
+	fields     model.LogFields
 	hasdebug   bool
 	hasinfo    bool
 	haswarning bool
@@ -17,6 +21,7 @@ type chanLogger struct {
 func (cl *chanLogger) Debug(msg string) {
 	if cl.hasdebug {
 		cl.emitter.Emit("log", eventLog{
+			Fields:   cl.fields,
 			LogLevel: "DEBUG",
 			Message:  msg,
 		})
@@ -34,6 +39,7 @@ func (cl *chanLogger) Debugf(format string, v ...interface{}) {
 func (cl *chanLogger) Info(msg string) {
 	if cl.hasinfo {
 		cl.emitter.Emit("log", eventLog{
+			Fields:   cl.fields,
 			LogLevel: "INFO",
 			Message:  msg,
 		})
@@ -51,6 +57,7 @@ func (cl *chanLogger) Infof(format string, v ...interface{}) {
 func (cl *chanLogger) Warn(msg string) {
 	if cl.haswarning {
 		cl.emitter.Emit("log", eventLog{
+			Fields:   cl.fields,
 			LogLevel: "WARNING",
 			Message:  msg,
 		})
@@ -64,6 +71,32 @@ func (cl *chanLogger) Warnf(format string, v ...interface{}) {
 	}
 }
 
+// WithFields implements model.FieldLogger.WithFields
+func (cl *chanLogger) WithFields(fields model.LogFields) model.FieldLogger {
+	merged := make(model.LogFields)
+	for key, value := range cl.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	out := *cl
+	out.fields = merged
+	return &out
+}
+
+// level returns the minimum level of the messages we emit.
+func (cl *chanLogger) level() log.Level {
+	switch {
+	case cl.hasdebug:
+		return log.DebugLevel
+	case cl.hasinfo:
+		return log.InfoLevel
+	default:
+		return log.WarnLevel
+	}
+}
+
 // newChanLogger creates a new ChanLogger instance.
 func newChanLogger(
 	emitter *eventEmitter, logLevel string,
@@ -0,0 +1,34 @@
+package oonimkall
+
+import (
+	"testing"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestUnitChanLoggerWithFields(t *testing.T) {
+	out := make(chan *eventRecord)
+	emitter := newEventEmitter([]string{}, out)
+	logger := newChanLogger(emitter, "INFO", out)
+	go func() {
+		session := model.WithComponent(logger, "session")
+		model.WithFields(session, model.LogFields{"experiment": "ndt"}).Info("antani")
+		logger.Info("mascetti")
+		close(out)
+	}()
+	var events []eventLog
+	for ev := range out {
+		events = append(events, ev.Value.(eventLog))
+	}
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	fields := events[0].Fields
+	if len(fields) != 2 || fields[model.LogFieldComponent] != "session" ||
+		fields["experiment"] != "ndt" || events[0].Message != "antani" {
+		t.Fatal("unexpected first event", events[0])
+	}
+	if events[1].Fields != nil {
+		t.Fatal("the original logger should not have fields")
+	}
+}
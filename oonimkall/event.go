@@ -1,5 +1,7 @@
 package oonimkall
 
+import "github.com/ooni/probe-engine/model"
+
 type eventEmpty struct{}
 
 type eventFailureGeneric struct {
@@ -7,23 +9,32 @@ type eventFailureGeneric struct {
 }
 
 type eventLog struct {
-	LogLevel string `json:"log_level"`
-	Message  string `json:"message"`
+	Fields   model.LogFields `json:"fields,omitempty"`
+	LogLevel string          `json:"log_level"`
+	Message  string          `json:"message"`
 }
 
 type eventMeasurementGeneric struct {
+	Anomaly bool   `json:"anomaly,omitempty"`
 	Failure string `json:"failure,omitempty"`
 	Idx     int64  `json:"idx"`
 	Input   string `json:"input"`
 	JSONStr string `json:"json_str,omitempty"`
 }
 
-type eventStatusEnd struct {
+type eventStatusDataUsage struct {
 	DownloadedKB float64 `json:"downloaded_kb"`
-	Failure      string  `json:"failure"`
 	UploadedKB   float64 `json:"uploaded_kb"`
 }
 
+type eventStatusEnd struct {
+	DownloadedKB             float64 `json:"downloaded_kb"`
+	Failure                  string  `json:"failure"`
+	MeasurementsDownloadedKB float64 `json:"measurements_downloaded_kb"`
+	MeasurementsUploadedKB   float64 `json:"measurements_uploaded_kb"`
+	UploadedKB               float64 `json:"uploaded_kb"`
+}
+
 type eventStatusGeoIPLookup struct {
 	ProbeASN         string `json:"probe_asn"`
 	ProbeCC          string `json:"probe_cc"`
@@ -40,10 +51,31 @@ type eventStatusReportGeneric struct {
 	ReportID string `json:"report_id"`
 }
 
+type eventStatusTunnelFailure struct {
+	Failure string `json:"failure"`
+	Name    string `json:"name"`
+}
+
+type eventStatusTunnelProgress struct {
+	Message    string  `json:"message"`
+	Name       string  `json:"name"`
+	Percentage float64 `json:"percentage"`
+}
+
+type eventStatusTunnelStart struct {
+	Name string `json:"name"`
+}
+
+type eventStatusUploadProgress struct {
+	Sent  int64 `json:"sent"`
+	Total int64 `json:"total"`
+}
+
 type eventStatusResolverLookup struct {
 	ResolverASN         string `json:"resolver_asn"`
 	ResolverIP          string `json:"resolver_ip"`
 	ResolverNetworkName string `json:"resolver_network_name"`
+	ResolverNetworkType string `json:"resolver_network_type"`
 }
 
 // eventRecord is an event emitted by a task. This structure extends the event
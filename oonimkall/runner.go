@@ -3,9 +3,12 @@ package oonimkall
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"path/filepath"
 	"time"
 
 	engine "github.com/ooni/probe-engine"
+	"github.com/ooni/probe-engine/eventbus"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/model"
 )
@@ -14,22 +17,29 @@ const (
 	failureIPLookup              = "failure.ip_lookup"
 	failureASNLookup             = "failure.asn_lookup"
 	failureCCLookup              = "failure.cc_lookup"
+	failureDataCap               = "failure.data_cap"
 	failureMeasurement           = "failure.measurement"
 	failureMeasurementSubmission = "failure.measurement_submission"
 	failureReportCreate          = "failure.report_create"
 	failureResolverLookup        = "failure.resolver_lookup"
 	failureStartup               = "failure.startup"
 	measurement                  = "measurement"
+	statusDataUsage              = "status.data_usage"
 	statusEnd                    = "status.end"
 	statusGeoIPLookup            = "status.geoip_lookup"
 	statusMeasurementDone        = "status.measurement_done"
 	statusMeasurementStart       = "status.measurement_start"
 	statusMeasurementSubmission  = "status.measurement_submission"
+	statusMeasurementUpload      = "status.measurement_upload_progress"
 	statusProgress               = "status.progress"
 	statusQueued                 = "status.queued"
 	statusReportCreate           = "status.report_create"
 	statusResolverLookup         = "status.resolver_lookup"
+	statusRunSummary             = "status.run_summary"
 	statusStarted                = "status.started"
+	statusTunnelFailure          = "status.tunnel_failure"
+	statusTunnelProgress         = "status.tunnel_progress"
+	statusTunnelStart            = "status.tunnel_start"
 )
 
 // runner runs a specific task
@@ -37,6 +47,7 @@ type runner struct {
 	emitter             *eventEmitter
 	maybeLookupLocation func(*engine.Session) error
 	out                 chan<- *eventRecord
+	progress            *engine.ProgressEstimator
 	settings            *settingsRecord
 }
 
@@ -54,9 +65,6 @@ func (r *runner) hasUnsupportedSettings(logger *chanLogger) (unsupported bool) {
 		r.emitter.EmitFailureStartup(why)
 		unsupported = true
 	}
-	if r.settings.InputFilepaths != nil {
-		sadly("InputFilepaths: not supported")
-	}
 	if r.settings.Options.AllEndpoints != nil {
 		logger.Warn("Options.AllEndpoints: not supported")
 	}
@@ -132,9 +140,6 @@ func (r *runner) hasUnsupportedSettings(logger *chanLogger) (unsupported bool) {
 	if r.settings.Options.ProbeNetworkName != "" {
 		logger.Warn("Options.ProbeNetworkName: not supported")
 	}
-	if r.settings.Options.RandomizeInput != false {
-		sadly("Options.RandomizeInput: not supported")
-	}
 	if r.settings.Options.SaveRealResolverIP != nil {
 		sadly("Options.SaveRealResolverIP: not supported")
 	}
@@ -164,17 +169,27 @@ func (r *runner) newsession(logger *chanLogger) (*engine.Session, error) {
 		return nil, err
 	}
 	config := engine.SessionConfig{
-		AssetsDir: r.settings.AssetsDir,
-		KVStore:   kvstore,
-		Logger:    logger,
+		Annotations:        r.settings.Annotations,
+		AssetsDir:          r.settings.AssetsDir,
+		CrashDir:           filepath.Join(r.settings.StateDir, "crashes"),
+		CrashReportsURL:    r.settings.Options.CrashReportsURL,
+		DataCapKiB:         r.settings.Options.DataCapKB,
+		EnableCrashReports: r.settings.Options.EnableCrashReports,
+		KVStore:            kvstore,
+		LiteMode:           r.settings.Options.LiteMode,
+		LogLevel:           logger.level(),
+		Logger:             logger,
 		PrivacySettings: model.PrivacySettings{
-			IncludeASN:     r.settings.Options.SaveRealProbeASN,
-			IncludeCountry: r.settings.Options.SaveRealProbeCC,
-			IncludeIP:      r.settings.Options.SaveRealProbeIP,
+			IncludeASN:            r.settings.Options.SaveRealProbeASN,
+			IncludeCountry:        r.settings.Options.SaveRealProbeCC,
+			IncludeIP:             r.settings.Options.SaveRealProbeIP,
+			RedactHTTPCredentials: r.settings.Options.RedactHTTPCredentials,
 		},
-		SoftwareName:    r.settings.Options.SoftwareName,
-		SoftwareVersion: r.settings.Options.SoftwareVersion,
-		TempDir:         r.settings.TempDir,
+		SoftwareName:       r.settings.Options.SoftwareName,
+		SoftwareVersion:    r.settings.Options.SoftwareVersion,
+		StateEncryptionKey: r.settings.StateEncryptionKey,
+		TempDir:            r.settings.TempDir,
+		TunnelCallbacks:    &runnerCallbacks{emitter: r.emitter},
 	}
 	if r.settings.Options.ProbeServicesBaseURL != "" {
 		config.AvailableProbeServices = []model.Service{{
@@ -203,12 +218,61 @@ func (cb *runnerCallbacks) OnDataUsage(dloadKiB, uploadKiB float64) {
 }
 
 func (cb *runnerCallbacks) OnProgress(percentage float64, message string) {
-	cb.emitter.Emit(statusProgress, eventStatusProgress{
-		Percentage: 0.4 + (percentage * 0.6), // open report is 40%
+	// nothing! we emit progress when we see it on the event bus
+}
+
+func (cb *runnerCallbacks) OnUploadProgress(sent, total int64) {
+	cb.emitter.Emit(statusMeasurementUpload, eventStatusUploadProgress{
+		Sent:  sent,
+		Total: total,
+	})
+}
+
+func (cb *runnerCallbacks) OnTunnelStart(name string) {
+	cb.emitter.Emit(statusTunnelStart, eventStatusTunnelStart{Name: name})
+}
+
+func (cb *runnerCallbacks) OnTunnelProgress(name string, percentage float64, message string) {
+	cb.emitter.Emit(statusTunnelProgress, eventStatusTunnelProgress{
 		Message:    message,
+		Name:       name,
+		Percentage: percentage,
 	})
 }
 
+func (cb *runnerCallbacks) OnTunnelFailure(name string, err error) {
+	cb.emitter.Emit(statusTunnelFailure, eventStatusTunnelFailure{
+		Failure: err.Error(),
+		Name:    name,
+	})
+}
+
+// onEvent forwards some of the events published on the session event bus.
+// We map the progress of experiments to MK's status.progress event and we
+// emit diagnostics using their name as the key. We do not forward the other
+// events, e.g., netx.dial, because apps would receive a flood of events they
+// do not know about, which would also become part of our API.
+func (r *runner) onEvent(ev eventbus.Event) {
+	switch ev := ev.(type) {
+	case eventbus.Progress:
+		r.emitter.Emit(statusProgress, eventStatusProgress{
+			Percentage: r.overallProgress(ev.Percentage),
+			Message:    ev.Message,
+		})
+	case eventbus.Diagnostic:
+		r.emitter.Emit(ev.Name(), ev)
+	}
+}
+
+// overallProgress maps the progress of the current measurement to the
+// progress of the whole run, where opening the report is 40%.
+func (r *runner) overallProgress(percentage float64) float64 {
+	if r.progress != nil {
+		percentage = r.progress.Progress(percentage)
+	}
+	return 0.4 + (percentage * 0.6)
+}
+
 // Run runs the runner until completion. The context argument controls
 // when to stop when processing multiple inputs, as well as when to stop
 // experiments explicitly marked as interruptible.
@@ -224,8 +288,10 @@ func (r *runner) Run(ctx context.Context) {
 		r.emitter.EmitFailureStartup(err.Error())
 		return
 	}
+	defer sess.EventBus().Subscribe(r.onEvent)()
 	endEvent := new(eventStatusEnd)
 	defer func() {
+		r.emitter.Emit(statusRunSummary, sess.RunSummary())
 		sess.Close()
 		r.emitter.Emit(statusEnd, endEvent)
 	}()
@@ -243,6 +309,11 @@ func (r *runner) Run(ctx context.Context) {
 			return
 		}
 		r.emitter.EmitStatusProgress(0.1, "contacted bouncer")
+		if r.settings.Options.EnableCrashReports {
+			if _, err := sess.SubmitCrashReports(ctx); err != nil {
+				logger.Warnf("cannot submit crash reports: %s", err.Error())
+			}
+		}
 	}
 	if !r.settings.Options.NoGeoIP && !r.settings.Options.NoResolverLookup {
 		logger.Info("Looking up your location... please, be patient")
@@ -271,6 +342,7 @@ func (r *runner) Run(ctx context.Context) {
 			ResolverASN:         sess.ResolverASNString(),
 			ResolverIP:          sess.ResolverIP(),
 			ResolverNetworkName: sess.ResolverNetworkName(),
+			ResolverNetworkType: sess.ResolverNetworkType(),
 		})
 	} else if r.settings.Options.NoGeoIP && r.settings.Options.NoResolverLookup {
 		logger.Warn("Not looking up your location")
@@ -280,17 +352,26 @@ func (r *runner) Run(ctx context.Context) {
 	}
 
 	builder.SetCallbacks(&runnerCallbacks{emitter: r.emitter})
-	if len(r.settings.Inputs) <= 0 {
-		if builder.InputPolicy() == engine.InputRequired {
-			r.emitter.EmitFailureStartup("no input provided")
-			return
-		}
-		r.settings.Inputs = append(r.settings.Inputs, "")
+	inputs, err := (&engine.InputLoader{
+		DefaultInputs: builder.DefaultInputs(),
+		InputPolicy:   builder.InputPolicy(),
+		NoCheckIn:     true,
+		Shuffle:       r.settings.Options.RandomizeInput,
+		SourceFiles:   r.settings.InputFilepaths,
+		StaticInputs:  r.settings.Inputs,
+	}).LoadInputs(ctx)
+	if err != nil {
+		r.emitter.EmitFailureStartup(err.Error())
+		return
 	}
+	r.settings.Inputs = inputs
 	experiment := builder.NewExperiment()
 	defer func() {
 		endEvent.DownloadedKB = experiment.KibiBytesReceived()
 		endEvent.UploadedKB = experiment.KibiBytesSent()
+		usage := sess.RunSummary().MeasurementsDataUsage
+		endEvent.MeasurementsDownloadedKB = usage.KibiBytesReceived
+		endEvent.MeasurementsUploadedKB = usage.KibiBytesSent
 	}()
 	if !r.settings.Options.NoCollector {
 		logger.Info("Opening report... please, be patient")
@@ -319,11 +400,17 @@ func (r *runner) Run(ctx context.Context) {
 		)
 		defer cancel()
 	}
+	r.progress = sess.NewProgressEstimator(experiment.Name(), r.settings.Inputs)
 	for idx, input := range r.settings.Inputs {
 		if ctx.Err() != nil {
 			break
 		}
+		if sess.DataCapExceeded() {
+			r.emitter.EmitFailureGeneric(failureDataCap, engine.ErrDataCapExceeded.Error())
+			break
+		}
 		logger.Infof("Starting measurement with index %d", idx)
+		r.progress.SetIndex(idx)
 		r.emitter.Emit(statusMeasurementStart, eventMeasurementGeneric{
 			Idx:   int64(idx),
 			Input: input,
@@ -337,7 +424,6 @@ func (r *runner) Run(ctx context.Context) {
 			// submit measurement and stop at beginning of next iteration
 			break
 		}
-		m.AddAnnotations(r.settings.Annotations)
 		if err != nil {
 			r.emitter.Emit(failureMeasurement, eventMeasurementGeneric{
 				Failure: err.Error(),
@@ -346,6 +432,9 @@ func (r *runner) Run(ctx context.Context) {
 			})
 			// fallthrough: we want to submit the report anyway
 		}
+		r.emitter.EmitStatusProgress(r.overallProgress(1), fmt.Sprintf(
+			"measured input %d/%d", idx+1, len(r.settings.Inputs)))
+		anomaly := err == nil && experiment.IsAnomaly(m)
 		data, err := json.Marshal(m)
 		runtimex.PanicOnError(err, "measurement.MarshalJSON failed")
 		r.emitter.Emit(measurement, eventMeasurementGeneric{
@@ -363,9 +452,14 @@ func (r *runner) Run(ctx context.Context) {
 				Failure: measurementSubmissionFailure(err),
 			})
 		}
+		r.emitter.Emit(statusDataUsage, eventStatusDataUsage{
+			DownloadedKB: sess.KibiBytesReceived(),
+			UploadedKB:   sess.KibiBytesSent(),
+		})
 		r.emitter.Emit(statusMeasurementDone, eventMeasurementGeneric{
-			Idx:   int64(idx),
-			Input: input,
+			Anomaly: anomaly,
+			Idx:     int64(idx),
+			Input:   input,
 		})
 	}
 }
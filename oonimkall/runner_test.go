@@ -13,6 +13,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	engine "github.com/ooni/probe-engine"
+	"github.com/ooni/probe-engine/eventbus"
 )
 
 func TestUnitRunnerHasUnsupportedSettings(t *testing.T) {
@@ -87,12 +88,10 @@ func TestUnitRunnerHasUnsupportedSettings(t *testing.T) {
 		}
 	}
 	expectedFatal := []string{
-		"InputFilepaths: not supported",
 		"Options.Backend: not supported",
 		"Options.BouncerBaseURL: not supported",
 		"Options.CollectorBaseURL: not supported",
 		"Options.Port: not supported",
-		"Options.RandomizeInput: not supported",
 		"Options.SaveRealResolverIP: not supported",
 		"Options.Server: not supported",
 		"Options.TestSuite: not supported",
@@ -173,7 +172,7 @@ func TestIntegrationRunnerMaybeLookupLocationFailure(t *testing.T) {
 				if evv.Percentage >= 0.2 {
 					panic(fmt.Sprintf("too much progress: %+v", ev))
 				}
-			case "status.queued", "status.started", "status.end":
+			case "status.queued", "status.started", "status.run_summary", "status.end":
 			default:
 				panic(fmt.Sprintf("unexpected key: %s", ev.Key))
 			}
@@ -215,7 +214,8 @@ func TestIntegrationRunnerMaybeLookupBackendsFailure(t *testing.T) {
 			switch ev.Key {
 			case "failure.startup":
 				seen++
-			case "status.queued", "status.started", "log", "status.end":
+			case "status.queued", "status.started", "log", "status.run_summary",
+				"status.end":
 			default:
 				panic(fmt.Sprintf("unexpected key: %s", ev.Key))
 			}
@@ -269,8 +269,8 @@ func TestIntegrationRunnerOpenReportFailure(t *testing.T) {
 				if evv.Percentage >= 0.4 {
 					panic(fmt.Sprintf("too much progress: %+v", ev))
 				}
-			case "status.queued", "status.started", "log", "status.end",
-				"status.geoip_lookup", "status.resolver_lookup":
+			case "status.queued", "status.started", "log", "status.run_summary",
+				"status.end", "status.geoip_lookup", "status.resolver_lookup":
 			default:
 				panic(fmt.Sprintf("unexpected key: %s", ev.Key))
 			}
@@ -284,3 +284,72 @@ func TestIntegrationRunnerOpenReportFailure(t *testing.T) {
 		t.Fatal("unexpected number of events")
 	}
 }
+
+func TestUnitRunnerCallbacksOnUploadProgress(t *testing.T) {
+	out := make(chan *eventRecord, 1)
+	cb := &runnerCallbacks{emitter: newEventEmitter(nil, out)}
+	cb.OnUploadProgress(128, 1024)
+	ev := <-out
+	if ev.Key != statusMeasurementUpload {
+		t.Fatal("unexpected event key")
+	}
+	value, ok := ev.Value.(eventStatusUploadProgress)
+	if !ok || value.Sent != 128 || value.Total != 1024 {
+		t.Fatal("unexpected event value")
+	}
+}
+
+func TestUnitRunnerCallbacksOnTunnelEvents(t *testing.T) {
+	out := make(chan *eventRecord, 3)
+	cb := &runnerCallbacks{emitter: newEventEmitter(nil, out)}
+	cb.OnTunnelStart("psiphon")
+	cb.OnTunnelProgress("psiphon", 0.5, "psiphon: connecting to a server")
+	cb.OnTunnelFailure("psiphon", errors.New("mocked error"))
+	ev := <-out
+	if ev.Key != statusTunnelStart {
+		t.Fatal("unexpected event key")
+	}
+	if value, ok := ev.Value.(eventStatusTunnelStart); !ok || value.Name != "psiphon" {
+		t.Fatal("unexpected event value")
+	}
+	ev = <-out
+	if ev.Key != statusTunnelProgress {
+		t.Fatal("unexpected event key")
+	}
+	progress, ok := ev.Value.(eventStatusTunnelProgress)
+	if !ok || progress.Name != "psiphon" || progress.Percentage != 0.5 {
+		t.Fatal("unexpected event value")
+	}
+	ev = <-out
+	if ev.Key != statusTunnelFailure {
+		t.Fatal("unexpected event key")
+	}
+	failure, ok := ev.Value.(eventStatusTunnelFailure)
+	if !ok || failure.Name != "psiphon" || failure.Failure != "mocked error" {
+		t.Fatal("unexpected event value")
+	}
+}
+
+func TestUnitRunnerOnEvent(t *testing.T) {
+	out := make(chan *eventRecord, 4)
+	r := newRunner(&settingsRecord{}, out)
+	r.onEvent(eventbus.Progress{Experiment: "example", Message: "antani", Percentage: 1})
+	r.onEvent(eventbus.Dial{Address: "8.8.8.8:53", Network: "udp"})
+	r.onEvent(eventbus.DataUsage{Experiment: "example", KibiBytesReceived: 1})
+	r.onEvent(eventbus.Diagnostic{Experiment: "example", Failure: "mocked error"})
+	ev := <-out
+	progress, ok := ev.Value.(eventStatusProgress)
+	if ev.Key != statusProgress || !ok || progress.Percentage != 1 || progress.Message != "antani" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	ev = <-out
+	diagnostic, ok := ev.Value.(eventbus.Diagnostic)
+	if ev.Key != "experiment.diagnostic" || !ok || diagnostic.Failure != "mocked error" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	select {
+	case ev := <-out:
+		t.Fatalf("unexpected event: %+v", ev)
+	default:
+	}
+}
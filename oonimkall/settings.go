@@ -20,9 +20,8 @@ type settingsRecord struct {
 	// requires input and you provide no input.
 	Inputs []string `json:"inputs,omitempty"`
 
-	// InputFilepaths contains the input file paths. This
-	// setting is not implemented by this library. Attempting
-	// to set it will cause a startup error.
+	// InputFilepaths contains the paths of files containing
+	// additional inputs, one per line.
 	InputFilepaths []string `json:"input_filepaths,omitempty"`
 
 	// LogLevel contains the logs level. See https://git.io/Jv4Rv
@@ -47,6 +46,13 @@ type settingsRecord struct {
 	// this field is empty, the task won't start.
 	StateDir string `json:"state_dir"`
 
+	// StateEncryptionKey is the AES key, encoded using base64, with which
+	// we encrypt the orchestra credentials stored in StateDir. This field
+	// is an extension of MK's specification. The key should be generated
+	// by the app and kept in the platform keystore. If this field is
+	// empty, we store the credentials without encrypting them.
+	StateEncryptionKey []byte `json:"state_encryption_key,omitempty"`
+
 	// TempDir is the temporary directory. This field is an extension of MK's
 	// specification. If this field is empty, we will pick the tempdir that
 	// ioutil.TempDir uses by default, which may not work on mobile. According
@@ -89,6 +95,16 @@ type settingsOptions struct {
 	// cause the code to stop early with a startup failure.
 	ConstantBitrate *bool `json:"constant_bitrate,omitempty"`
 
+	// CrashReportsURL is the full URL of the endpoint to which we
+	// submit crash reports when EnableCrashReports is true.
+	CrashReportsURL string `json:"crash_reports_url,omitempty"`
+
+	// DataCapKB is the maximum amount of data, in KiB, that the task
+	// may send and receive. When the task exceeds this amount, we stop the
+	// current measurement and emit a "failure.data_cap" event rather than
+	// starting the next one. A zero or negative value means no limit.
+	DataCapKB float64 `json:"data_cap_kb,omitempty"`
+
 	// DNSNameserver is a legacy option that this library does
 	// not support. Setting it causes the experiment to fail.
 	DNSNameserver *string `json:"dns_nameserver,omitempty"`
@@ -97,6 +113,13 @@ type settingsOptions struct {
 	// not support. Setting it causes the experiment to fail.
 	DNSEngine *string `json:"dns_engine,omitempty"`
 
+	// EnableCrashReports indicates whether the user opted in for
+	// submitting the crash reports of experiments that panicked. We
+	// save such crash reports inside the StateDir and we submit them
+	// to CrashReportsURL at the beginning of the next task if this
+	// option is true.
+	EnableCrashReports bool `json:"enable_crash_reports,omitempty"`
+
 	// ExpectedBody is a legacy option that this library does
 	// not support. Setting it causes the experiment to fail.
 	ExpectedBody *string `json:"expected_body,omitempty"`
@@ -125,6 +148,10 @@ type settingsOptions struct {
 	// not support. Setting it causes the experiment to fail.
 	IgnoreOpenReportError *bool `json:"ignore_open_report_error,omitempty"`
 
+	// LiteMode indicates whether to configure the session for
+	// low-end devices (see engine.SessionConfig).
+	LiteMode bool `json:"lite_mode,omitempty"`
+
 	// MaxRuntime is the maximum runtime expressed. A negative
 	// value for this field disables the maximum runtime. Using
 	// a zero value will also mean disabled. This is not the
@@ -209,11 +236,13 @@ type settingsOptions struct {
 	// ProbeServicesBaseURL contains the probe services base URL.
 	ProbeServicesBaseURL string `json:"probe_services_base_url,omitempty"`
 
-	// RandomizeInput indicates whether to randomize inputs. This
-	// option is not implemented by this library. Attempting
-	// to set it to true will cause a startup error.
+	// RandomizeInput indicates whether to randomize inputs.
 	RandomizeInput bool `json:"randomize_input,omitempty"`
 
+	// RedactHTTPCredentials indicates whether to redact the
+	// cookies and the other HTTP credentials from measurements
+	RedactHTTPCredentials bool `json:"redact_http_credentials,omitempty"`
+
 	// SaveRealProbeASN indicates whether to save the real probe ASN
 	SaveRealProbeASN bool `json:"save_real_probe_asn,omitempty"`
 
@@ -124,10 +124,10 @@ func TestIntegrationInvalidJSON(t *testing.T) {
 func TestIntegrationUnsupportedSetting(t *testing.T) {
 	task, err := oonimkall.StartTask(`{
 		"assets_dir": "../testdata/oonimkall/assets",
-		"input_filepaths": ["/nonexistent"],
 		"log_level": "DEBUG",
 		"name": "Example",
 		"options": {
+			"backend": "foo",
 			"software_name": "oonimkall-test",
 			"software_version": "0.1.0"
 		},
@@ -395,8 +395,11 @@ func TestIntegrationInterruptExampleWithInput(t *testing.T) {
 		"log",
 		"status.progress",
 		"measurement",
+		"status.measurement_upload_progress",
 		"status.measurement_submission",
+		"status.data_usage",
 		"status.measurement_done",
+		"status.run_summary",
 		"status.end",
 		"task_terminated",
 	}
@@ -432,7 +435,12 @@ func TestIntegrationInterruptNdt7(t *testing.T) {
 		// We compress the keys because we don't know how many
 		// status.progress we will see. What matters is that we
 		// don't see a measurement submission, since it means
-		// that we have interrupted the measurement.
+		// that we have interrupted the measurement. We also skip
+		// the dials published on the event bus, since their number
+		// depends on the network conditions.
+		if event.Key == "netx.dial" {
+			continue
+		}
 		if keys == nil || keys[len(keys)-1] != event.Key {
 			keys = append(keys, event.Key)
 		}
@@ -447,6 +455,7 @@ func TestIntegrationInterruptNdt7(t *testing.T) {
 		"status.report_create",
 		"status.measurement_start",
 		"status.progress",
+		"status.run_summary",
 		"status.end",
 		"task_terminated",
 	}
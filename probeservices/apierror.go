@@ -0,0 +1,107 @@
+package probeservices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+)
+
+var (
+	// ErrInvalidCredentials indicates that the backend did not accept
+	// our credentials or token. You probably want to register again.
+	ErrInvalidCredentials = errors.New("probe services: invalid credentials")
+
+	// ErrRateLimited indicates that the backend is rate limiting
+	// us. You probably want to back off and try again later.
+	ErrRateLimited = errors.New("probe services: rate limited")
+
+	// ErrReportNotFound indicates that the backend does not know the
+	// report (e.g. because it expired). You probably want to open
+	// a new report and submit again.
+	ErrReportNotFound = errors.New("probe services: report not found")
+)
+
+// APIError is an error returned by the probe services API. Use errors.Is
+// to check whether an APIError is ErrInvalidCredentials, ErrRateLimited, or
+// ErrReportNotFound. Use errors.As to access the underlying error, which
+// is a *httpx.RequestFailedError containing the status code.
+type APIError struct {
+	// Code is the error code returned by the backend, if any.
+	Code string
+
+	// Message is the error message returned by the backend, if any.
+	Message string
+
+	// ResourcePath is the path of the API that failed.
+	ResourcePath string
+
+	// StatusCode is the HTTP status code.
+	StatusCode int
+
+	// Err is the underlying error.
+	Err *httpx.RequestFailedError
+}
+
+// apiErrorBody is the JSON body that the backend returns on error. Some
+// APIs use the `error` field while others use the `msg` field.
+type apiErrorBody struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+	Msg   string `json:"msg"`
+}
+
+// Error returns a description of the error that occurred.
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s", e.Err.Error(), e.Message)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether the error is equivalent to target.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInvalidCredentials:
+		return e.Code == "invalid_credentials" ||
+			e.StatusCode == http.StatusUnauthorized
+	case ErrRateLimited:
+		return e.Code == "rate_limited" ||
+			e.StatusCode == http.StatusTooManyRequests
+	case ErrReportNotFound:
+		return e.Code == "report_not_found" || (e.StatusCode == http.StatusNotFound &&
+			strings.HasPrefix(e.ResourcePath, "/report/"))
+	default:
+		return false
+	}
+}
+
+// newAPIError converts err to an *APIError, if err is a failed request,
+// otherwise it returns err unchanged.
+func newAPIError(err error, resourcePath string) error {
+	var failed *httpx.RequestFailedError
+	if !errors.As(err, &failed) {
+		return err
+	}
+	var body apiErrorBody
+	json.Unmarshal(failed.Body, &body) // it's fine if the body is not JSON
+	message := body.Error
+	if message == "" {
+		message = body.Msg
+	}
+	return &APIError{
+		Code:         body.Code,
+		Message:      message,
+		ResourcePath: resourcePath,
+		StatusCode:   failed.StatusCode,
+		Err:          failed,
+	}
+}
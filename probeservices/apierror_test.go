@@ -0,0 +1,114 @@
+package probeservices_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func newAPIErrorServer(status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI == "/report" {
+				w.Write([]byte(`{"report_id":"_id","supported_formats":["json"]}`))
+				return
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+}
+
+func TestAPIErrorRateLimited(t *testing.T) {
+	server := newAPIErrorServer(429, `{"error":"slow down"}`)
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	_, err := client.FetchURLList(context.Background(), model.FetchURLListConfig{})
+	if !errors.Is(err, probeservices.ErrRateLimited) {
+		t.Fatal("not the error we expected")
+	}
+	if errors.Is(err, probeservices.ErrInvalidCredentials) {
+		t.Fatal("the error should not be ErrInvalidCredentials")
+	}
+	var apiErr *probeservices.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatal("not an APIError")
+	}
+	if apiErr.Message != "slow down" || apiErr.StatusCode != 429 {
+		t.Fatal("unexpected APIError fields")
+	}
+	if apiErr.ResourcePath != "/api/v1/test-list/urls" {
+		t.Fatal("unexpected resource path")
+	}
+	if err.Error() != "httpx: request failed: 429 Too Many Requests: slow down" {
+		t.Fatal("unexpected error string")
+	}
+	var failed *httpx.RequestFailedError
+	if !errors.As(err, &failed) || failed.StatusCode != 429 {
+		t.Fatal("cannot access the underlying error")
+	}
+}
+
+func TestAPIErrorInvalidCredentials(t *testing.T) {
+	for _, input := range []struct {
+		status int
+		body   string
+	}{
+		{401, ""},
+		{400, `{"code":"invalid_credentials","msg":"wrong password"}`},
+	} {
+		server := newAPIErrorServer(input.status, input.body)
+		client := newLoggedInClient(server.URL, -1)
+		err := client.MaybeLogin(context.Background())
+		server.Close()
+		if !errors.Is(err, probeservices.ErrInvalidCredentials) {
+			t.Fatal("not the error we expected")
+		}
+	}
+}
+
+func TestAPIErrorReportNotFound(t *testing.T) {
+	for _, input := range []struct {
+		status int
+		body   string
+	}{
+		{404, "not found"},
+		{400, `{"code":"report_not_found"}`},
+	} {
+		server := newAPIErrorServer(input.status, input.body)
+		client := newclient()
+		client.BaseURL = server.URL
+		template := newBatchTemplate()
+		report, err := client.OpenReport(context.Background(), template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		measurement := makeMeasurement(template, report.ID)
+		err = report.SubmitMeasurement(context.Background(), &measurement)
+		server.Close()
+		if !errors.Is(err, probeservices.ErrReportNotFound) {
+			t.Fatal("not the error we expected")
+		}
+	}
+}
+
+func TestAPIErrorNotFoundIsNotReportNotFound(t *testing.T) {
+	server := newAPIErrorServer(404, "")
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	_, err := client.GetTestHelpers(context.Background())
+	if errors.Is(err, probeservices.ErrReportNotFound) {
+		t.Fatal("should not be ErrReportNotFound")
+	}
+	var apiErr *probeservices.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Fatal("not the error we expected")
+	}
+}
@@ -0,0 +1,89 @@
+package probeservices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+var (
+	// ErrBatchNotSupported indicates that the collector we're using
+	// does not support submitting measurements in batches.
+	ErrBatchNotSupported = errors.New("probe services: batch submission not supported")
+
+	// ErrBatchMismatch indicates that the collector returned a number
+	// of measurement IDs different from the number of measurements.
+	ErrBatchMismatch = errors.New("probe services: batch response mismatch")
+)
+
+type collectorBatchRequest struct {
+	// Format is the data format
+	Format string `json:"format"`
+
+	// Content contains the measurements
+	Content []*model.Measurement `json:"content"`
+}
+
+type collectorBatchResponse struct {
+	// IDs contains the measurement IDs in the same order in
+	// which we submitted measurements.
+	IDs []string `json:"measurement_ids"`
+}
+
+// SubmitBatch submits several measurements belonging to the report using
+// a single HTTP request with a gzip compressed body. As SubmitMeasurement
+// does, we modify each measurement to contain the ReportID and, if the
+// collector sends back measurement IDs, we update the OOID fields.
+//
+// If the collector does not support batches, we fall back to submitting
+// each measurement separately using SubmitMeasurement. In such case, we
+// stop at the first error and return it.
+func (r Report) SubmitBatch(ctx context.Context, ms []*model.Measurement) error {
+	if len(ms) <= 0 {
+		return nil
+	}
+	err := r.submitBatch(ctx, ms)
+	if !errors.Is(err, ErrBatchNotSupported) {
+		return err
+	}
+	r.client.Logger.Debug("collector.go: falling back to per-measurement submission")
+	for _, m := range ms {
+		if err := r.SubmitMeasurement(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r Report) submitBatch(ctx context.Context, ms []*model.Measurement) error {
+	for _, m := range ms {
+		m.ReportID = r.ID
+	}
+	code, data, err := r.client.postCompressedJSON(
+		ctx, fmt.Sprintf("/report/%s/batch", r.ID), CompressionGzip,
+		collectorBatchRequest{Format: "json", Content: ms},
+	)
+	switch code {
+	case http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusUnsupportedMediaType, http.StatusNotImplemented:
+		return ErrBatchNotSupported
+	}
+	if err != nil {
+		return err
+	}
+	var batchResponse collectorBatchResponse
+	if err := json.Unmarshal(data, &batchResponse); err != nil {
+		return err
+	}
+	if len(batchResponse.IDs) != len(ms) {
+		return ErrBatchMismatch
+	}
+	for idx, m := range ms {
+		m.OOID = batchResponse.IDs[idx]
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package probeservices_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func newBatchTemplate() probeservices.ReportTemplate {
+	return probeservices.ReportTemplate{
+		DataFormatVersion: probeservices.DefaultDataFormatVersion,
+		Format:            probeservices.DefaultFormat,
+		ProbeASN:          "AS0",
+		ProbeCC:           "ZZ",
+		SoftwareName:      "ooniprobe-engine",
+		SoftwareVersion:   "0.1.0",
+		TestName:          "dummy",
+		TestVersion:       "0.1.0",
+	}
+}
+
+func TestSubmitBatchSuccess(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI == "/report" {
+				w.Write([]byte(`{"report_id":"_id","supported_formats":["json"]}`))
+				return
+			}
+			if r.RequestURI == "/report/_id/batch" {
+				if r.Header.Get("Content-Encoding") != "gzip" {
+					panic("not gzip encoded")
+				}
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					panic(err)
+				}
+				var req struct {
+					Content []model.Measurement `json:"content"`
+				}
+				if err := json.NewDecoder(zr).Decode(&req); err != nil {
+					panic(err)
+				}
+				if len(req.Content) != 2 || req.Content[0].ReportID != "_id" {
+					panic("unexpected batch content")
+				}
+				w.Write([]byte(`{"measurement_ids":["a","b"]}`))
+				return
+			}
+			panic(r.RequestURI)
+		}),
+	)
+	defer server.Close()
+	ctx := context.Background()
+	template := newBatchTemplate()
+	client := newclient()
+	client.BaseURL = server.URL
+	report, err := client.OpenReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, m2 := makeMeasurement(template, ""), makeMeasurement(template, "")
+	if err := report.SubmitBatch(ctx, []*model.Measurement{&m1, &m2}); err != nil {
+		t.Fatal(err)
+	}
+	if m1.OOID != "a" || m2.OOID != "b" {
+		t.Fatal("unexpected measurement IDs")
+	}
+	if m1.ReportID != "_id" || m2.ReportID != "_id" {
+		t.Fatal("unexpected report IDs")
+	}
+}
+
+func TestSubmitBatchFallback(t *testing.T) {
+	var count int
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI == "/report" {
+				w.Write([]byte(`{"report_id":"_id","supported_formats":["json"]}`))
+				return
+			}
+			if r.RequestURI == "/report/_id/batch" {
+				w.WriteHeader(404)
+				return
+			}
+			if r.RequestURI == "/report/_id" {
+				count++
+				w.Write([]byte(`{"measurement_id":"xx"}`))
+				return
+			}
+			panic(r.RequestURI)
+		}),
+	)
+	defer server.Close()
+	ctx := context.Background()
+	template := newBatchTemplate()
+	client := newclient()
+	client.BaseURL = server.URL
+	report, err := client.OpenReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, m2 := makeMeasurement(template, ""), makeMeasurement(template, "")
+	if err := report.SubmitBatch(ctx, []*model.Measurement{&m1, &m2}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("we did not fall back to per-measurement submission")
+	}
+	if m1.OOID != "xx" || m2.OOID != "xx" {
+		t.Fatal("unexpected measurement IDs")
+	}
+}
+
+func TestSubmitBatchMismatch(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI == "/report" {
+				w.Write([]byte(`{"report_id":"_id","supported_formats":["json"]}`))
+				return
+			}
+			w.Write([]byte(`{"measurement_ids":["a"]}`))
+		}),
+	)
+	defer server.Close()
+	ctx := context.Background()
+	template := newBatchTemplate()
+	client := newclient()
+	client.BaseURL = server.URL
+	report, err := client.OpenReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, m2 := makeMeasurement(template, ""), makeMeasurement(template, "")
+	err = report.SubmitBatch(ctx, []*model.Measurement{&m1, &m2})
+	if !errors.Is(err, probeservices.ErrBatchMismatch) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestSubmitBatchEmpty(t *testing.T) {
+	var report probeservices.Report
+	if err := report.SubmitBatch(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
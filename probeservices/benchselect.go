@@ -52,6 +52,16 @@ func OnlyHTTPS(in []model.Service) (out []model.Service) {
 	return
 }
 
+// OnlyCloudfront returns the cloudfronted endpoints only.
+func OnlyCloudfront(in []model.Service) (out []model.Service) {
+	for _, entry := range in {
+		if entry.Type == "cloudfront" {
+			out = append(out, entry)
+		}
+	}
+	return
+}
+
 // OnlyFallbacks returns the fallback endpoints only.
 func OnlyFallbacks(in []model.Service) (out []model.Service) {
 	for _, entry := range SortEndpoints(in) {
@@ -78,7 +88,7 @@ type Candidate struct {
 }
 
 func (c *Candidate) try(ctx context.Context, sess model.ExperimentSession) {
-	client, err := NewClient(sess, c.Endpoint)
+	client, err := NewClientWithTunnel(ctx, sess, c.Endpoint)
 	if err != nil {
 		c.Err = err
 		return
@@ -106,7 +116,8 @@ func try(ctx context.Context, sess model.ExperimentSession, svc model.Service) *
 // attempt with all the available fallbacks, and return at the first success. In
 // such case, you will see a list of N failing HTTPS candidates, followed by a single
 // successful fallback candidate (e.g. cloudfronted). If all candidates fail, you
-// see in output a list containing all entries where Err is not nil.
+// see in output a list containing all entries where Err is not nil. When we try
+// an onion fallback, we bootstrap the session's tor tunnel if needed.
 func TryAll(ctx context.Context, sess model.ExperimentSession, in []model.Service) (out []*Candidate) {
 	var found bool
 	for _, svc := range OnlyHTTPS(in) {
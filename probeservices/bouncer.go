@@ -10,5 +10,6 @@ import (
 func (c Client) GetTestHelpers(
 	ctx context.Context) (output map[string][]model.Service, err error) {
 	err = c.Client.GetJSON(ctx, "/api/v1/test-helpers", &output)
+	err = newAPIError(err, "/api/v1/test-helpers")
 	return
 }
@@ -0,0 +1,51 @@
+package probeservices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+)
+
+// cachedResource is a resource cached in the key-value store along
+// with the ETag the server returned when we fetched it.
+type cachedResource struct {
+	Data []byte
+	ETag string
+}
+
+// fetchCachedResource fetches the resource at URLPath using client, which
+// should be a copy of c.Client with the proper authorization. We cache the
+// resource in the key-value store using key. If we have a cached copy, we
+// ask the server to send us the resource only if it changed since then.
+func (c Client) fetchCachedResource(ctx context.Context, client httpx.Client,
+	key, URLPath string, query url.Values) ([]byte, error) {
+	key = "cache." + key
+	var cached cachedResource
+	if data, err := c.StateFile.Store.Get(key); err == nil {
+		if err := json.Unmarshal(data, &cached); err != nil {
+			cached = cachedResource{} // ignore broken cache entry
+		}
+	}
+	data, etag, err := client.FetchResourceWithETag(ctx, URLPath, query, cached.ETag)
+	err = newAPIError(err, URLPath)
+	if errors.Is(err, httpx.ErrNotModified) && cached.Data != nil {
+		c.Logger.Debugf("probeservices: using cached %s", URLPath)
+		return cached.Data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		data, err := json.Marshal(cachedResource{Data: data, ETag: etag})
+		if err == nil {
+			err = c.StateFile.Store.Set(key, data)
+		}
+		if err != nil {
+			c.Logger.Debugf("probeservices: cannot cache %s: %s", URLPath, err.Error())
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,95 @@
+package probeservices_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type etagServer struct {
+	Body      string
+	ETag      string
+	Downloads int32
+	Requests  int32
+}
+
+func (es *etagServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&es.Requests, 1)
+	if r.Header.Get("Authorization") != "Bearer old-token" {
+		w.WriteHeader(401)
+		return
+	}
+	if es.ETag != "" && r.Header.Get("If-None-Match") == es.ETag {
+		w.WriteHeader(304)
+		return
+	}
+	atomic.AddInt32(&es.Downloads, 1)
+	w.Header().Set("ETag", es.ETag)
+	w.Write([]byte(es.Body))
+}
+
+func TestFetchPsiphonConfigUsesCache(t *testing.T) {
+	es := &etagServer{Body: `{"antani":1}`, ETag: `"abc"`}
+	server := httptest.NewServer(es)
+	defer server.Close()
+	clnt := newLoggedInClient(server.URL, time.Hour)
+	for i := 0; i < 3; i++ {
+		data, err := clnt.FetchPsiphonConfig(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != es.Body {
+			t.Fatal("not the body we expected")
+		}
+	}
+	if es.Requests != 3 || es.Downloads != 1 {
+		t.Fatal("did not use the cache")
+	}
+}
+
+func TestFetchTorTargetsUsesCache(t *testing.T) {
+	es := &etagServer{
+		Body: `{"antani":{"address":"1.1.1.1:443","protocol":"obfs4"}}`,
+		ETag: `"abc"`,
+	}
+	server := httptest.NewServer(es)
+	defer server.Close()
+	clnt := newLoggedInClient(server.URL, time.Hour)
+	for i := 0; i < 2; i++ {
+		targets, err := clnt.FetchTorTargets(context.Background(), "ZZ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if targets["antani"].Address != "1.1.1.1:443" {
+			t.Fatal("not the targets we expected")
+		}
+	}
+	if es.Requests != 2 || es.Downloads != 1 {
+		t.Fatal("did not use the cache")
+	}
+	// a different country code is a different cache entry
+	if _, err := clnt.FetchTorTargets(context.Background(), "IT"); err != nil {
+		t.Fatal(err)
+	}
+	if es.Downloads != 2 {
+		t.Fatal("did not download again")
+	}
+}
+
+func TestFetchPsiphonConfigWithoutETag(t *testing.T) {
+	es := &etagServer{Body: `{"antani":1}`}
+	server := httptest.NewServer(es)
+	defer server.Close()
+	clnt := newLoggedInClient(server.URL, time.Hour)
+	for i := 0; i < 2; i++ {
+		if _, err := clnt.FetchPsiphonConfig(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if es.Downloads != 2 {
+		t.Fatal("should not have used the cache")
+	}
+}
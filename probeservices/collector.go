@@ -5,15 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/model/dataformat"
 )
 
 const (
 	// DefaultDataFormatVersion is the default data format version.
 	//
 	// See https://github.com/ooni/spec/tree/master/data-formats#history.
-	DefaultDataFormatVersion = "0.2.0"
+	DefaultDataFormatVersion = dataformat.Version020
 
 	// DefaultFormat is the default format
 	DefaultFormat = "json"
@@ -34,8 +36,9 @@ var (
 
 // ReportTemplate is the template for opening a report
 type ReportTemplate struct {
-	// DataFormatVersion is unconditionally set to DefaultDataFormatVersion
-	// and you don't need to be concerned about it.
+	// DataFormatVersion is the data format version of the measurements
+	// in the report, usually DefaultDataFormatVersion. See the
+	// model/dataformat package for the versions we support.
 	DataFormatVersion string `json:"data_format_version"`
 
 	// Format is unconditionally set to `json` and you don't need
@@ -73,11 +76,33 @@ type Report struct {
 
 	// client is the client that was used.
 	client Client
+
+	// key is the key used to persist the report, if any.
+	key string
+}
+
+// NewReportTemplate returns the template of the report to which
+// the specified measurement belongs.
+func NewReportTemplate(m *model.Measurement) ReportTemplate {
+	dataFormatVersion := m.DataFormatVersion
+	if dataFormatVersion == "" {
+		dataFormatVersion = DefaultDataFormatVersion
+	}
+	return ReportTemplate{
+		DataFormatVersion: dataFormatVersion,
+		Format:            DefaultFormat,
+		ProbeASN:          m.ProbeASN,
+		ProbeCC:           m.ProbeCC,
+		SoftwareName:      m.SoftwareName,
+		SoftwareVersion:   m.SoftwareVersion,
+		TestName:          m.TestName,
+		TestVersion:       m.TestVersion,
+	}
 }
 
 // OpenReport opens a new report.
 func (c Client) OpenReport(ctx context.Context, rt ReportTemplate) (*Report, error) {
-	if rt.DataFormatVersion != DefaultDataFormatVersion {
+	if !dataformat.IsSupported(rt.DataFormatVersion) {
 		return nil, ErrUnsupportedDataFormatVersion
 	}
 	if rt.Format != DefaultFormat {
@@ -85,7 +110,7 @@ func (c Client) OpenReport(ctx context.Context, rt ReportTemplate) (*Report, err
 	}
 	var cor collectorOpenResponse
 	if err := c.Client.PostJSON(ctx, "/report", rt, &cor); err != nil {
-		return nil, err
+		return nil, newAPIError(err, "/report")
 	}
 	for _, format := range cor.SupportedFormats {
 		if format == "json" {
@@ -103,6 +128,59 @@ type collectorUpdateRequest struct {
 	Content interface{} `json:"content"`
 }
 
+// WriteJSON writes the request into w, streaming the content when it
+// is a measurement (see model.Measurement.WriteJSON).
+func (r collectorUpdateRequest) WriteJSON(w io.Writer) error {
+	m, ok := r.Content.(*model.Measurement)
+	if !ok {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	format, err := json.Marshal(r.Format)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, `{"format":%s,"content":`, format); err != nil {
+		return err
+	}
+	// we drop the newline that WriteJSON writes after the measurement
+	if err := m.WriteJSON(&newlineDropper{w: w}); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "}")
+	return err
+}
+
+// newlineDropper writes into w all the bytes except a final newline.
+type newlineDropper struct {
+	pending bool
+	w       io.Writer
+}
+
+func (nd *newlineDropper) Write(p []byte) (int, error) {
+	if len(p) <= 0 {
+		return 0, nil
+	}
+	if nd.pending {
+		if _, err := nd.w.Write([]byte("\n")); err != nil {
+			return 0, err
+		}
+		nd.pending = false
+	}
+	data := p
+	if data[len(data)-1] == '\n' {
+		data, nd.pending = data[:len(data)-1], true
+	}
+	if _, err := nd.w.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 type collectorUpdateResponse struct {
 	// ID is the measurement ID
 	ID string `json:"measurement_id"`
@@ -111,11 +189,12 @@ type collectorUpdateResponse struct {
 // SubmitMeasurement submits a measurement belonging to the report
 // to the OONI collector. We will unconditionally modify the measurement
 // with the ReportID it should contain. If the collector supports sending
-// back to us a measurement ID, we also update the m.OOID field with it.
+// back to us a measurement ID, we also update the m.OOID field with it. If
+// the client's Compression field is set, we compress the request body.
 func (r Report) SubmitMeasurement(ctx context.Context, m *model.Measurement) error {
 	var updateResponse collectorUpdateResponse
 	m.ReportID = r.ID
-	err := r.client.Client.PostJSON(
+	err := r.client.postMaybeCompressedJSON(
 		ctx, fmt.Sprintf("/report/%s", r.ID), collectorUpdateRequest{
 			Format:  "json",
 			Content: m,
@@ -129,20 +208,33 @@ func (r Report) SubmitMeasurement(ctx context.Context, m *model.Measurement) err
 
 // Close closes the report. Returns nil on success; an error on failure.
 func (r Report) Close(ctx context.Context) error {
+	if r.key != "" && reportUsers.release(r.ID) > 0 {
+		return nil // other users are still using this report
+	}
 	var input, output struct{}
-	err := r.client.Client.PostJSON(
-		ctx, fmt.Sprintf("/report/%s/close", r.ID), input, &output,
-	)
+	resourcePath := fmt.Sprintf("/report/%s/close", r.ID)
+	err := r.client.Client.PostJSON(ctx, resourcePath, input, &output)
+	err = newAPIError(err, resourcePath)
 	// Implementation note: the server is not compliant with
 	// the spec, which says it MUST return a JSON. It does
 	// instead return an empty string. Intercept this error
 	// and turn it to nil, since we cannot really act upon
 	// this error, and we ought be flexible.
-	if _, ok := err.(*json.SyntaxError); ok && err.Error() == "unexpected end of JSON input" {
+	if isEmptyJSONError(err) {
 		r.client.Logger.Debug(
 			"collector.go: working around collector-returning-empty-string bug",
 		)
 		err = nil
 	}
+	if err == nil && r.key != "" {
+		r.client.forgetReport(r.key, r.ID)
+	}
 	return err
 }
+
+// isEmptyJSONError returns whether err indicates that the server
+// returned us an empty body rather than a JSON document.
+func isEmptyJSONError(err error) bool {
+	_, ok := err.(*json.SyntaxError)
+	return ok && err.Error() == "unexpected end of JSON input"
+}
@@ -0,0 +1,144 @@
+package probeservices
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+)
+
+const (
+	// CompressionNone means that we don't compress uploads.
+	CompressionNone = ""
+
+	// CompressionGzip means that we compress uploads using gzip.
+	CompressionGzip = "gzip"
+
+	// CompressionDeflate means that we compress uploads using deflate,
+	// i.e., the zlib format, as mandated by RFC7230.
+	CompressionDeflate = "deflate"
+)
+
+// ErrUnsupportedCompression indicates that we don't support the
+// compression method requested by the user.
+var ErrUnsupportedCompression = errors.New("probe services: unsupported compression")
+
+// IsSupportedCompression returns whether we support encoding.
+func IsSupportedCompression(encoding string) bool {
+	switch encoding {
+	case CompressionNone, CompressionGzip, CompressionDeflate:
+		return true
+	default:
+		return false
+	}
+}
+
+// jsonWriter is implemented by the values that can write their JSON
+// serialization incrementally (see model.Measurement.WriteJSON).
+type jsonWriter interface {
+	WriteJSON(w io.Writer) error
+}
+
+// nopWriteCloser is an io.WriteCloser whose Close does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressJSON serializes v as JSON and compresses it using encoding. When
+// encoding is CompressionNone, we just serialize v as JSON. When v is a
+// jsonWriter, we stream its serialization into the compressor, so that we
+// do not keep in memory both the serialized and the compressed body.
+func compressJSON(v interface{}, encoding string) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		zw  io.WriteCloser
+	)
+	switch encoding {
+	case CompressionNone:
+		zw = nopWriteCloser{&buf}
+	case CompressionGzip:
+		zw = gzip.NewWriter(&buf)
+	case CompressionDeflate:
+		zw = zlib.NewWriter(&buf)
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+	if err := writeJSON(zw, v); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeJSON writes the JSON serialization of v into w.
+func writeJSON(w io.Writer, v interface{}) error {
+	if jw, ok := v.(jsonWriter); ok {
+		return jw.WriteJSON(w)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// postCompressedJSON posts input at resourcePath as a JSON body compressed
+// using encoding. Returns the status code and the response body on success. On
+// failure, returns an error and, if we received a response, its status code.
+// We report the upload progress using c.UploadProgress, if set.
+func (c Client) postCompressedJSON(ctx context.Context, resourcePath, encoding string,
+	input interface{}) (int, []byte, error) {
+	body, err := compressJSON(input, encoding)
+	if err != nil {
+		return 0, nil, err
+	}
+	c.Logger.Debugf("probeservices: request body: %d %s bytes", len(body), encoding)
+	request, err := c.newUploadRequest(ctx, resourcePath, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if encoding != CompressionNone {
+		request.Header.Set("Content-Encoding", encoding)
+	}
+	data, err := c.Client.Do(request)
+	err = newAPIError(err, resourcePath)
+	var failed *httpx.RequestFailedError
+	if errors.As(err, &failed) {
+		return failed.StatusCode, nil, err
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, data, nil
+}
+
+// postMaybeCompressedJSON is like c.Client.PostJSON except that, if
+// c.Compression is not CompressionNone, it compresses the request body. If
+// the server tells us it does not support the compressed body, we retry
+// once more without compression.
+func (c Client) postMaybeCompressedJSON(
+	ctx context.Context, resourcePath string, input, output interface{}) error {
+	code, data, err := c.postCompressedJSON(ctx, resourcePath, c.Compression, input)
+	if code == http.StatusUnsupportedMediaType && c.Compression != CompressionNone {
+		c.Logger.Debug("probeservices: server does not support compression")
+		code, data, err = c.postCompressedJSON(ctx, resourcePath, CompressionNone, input)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, output)
+}
@@ -0,0 +1,143 @@
+package probeservices_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func newCompressionTestServer(accept bool, count *int) *httptest.Server {
+	return httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI == "/report" {
+				w.Write([]byte(`{"report_id":"_id","supported_formats":["json"]}`))
+				return
+			}
+			if r.RequestURI != "/report/_id" {
+				panic(r.RequestURI)
+			}
+			*count++
+			var (
+				reader io.Reader = r.Body
+				err    error
+			)
+			switch r.Header.Get("Content-Encoding") {
+			case "gzip":
+				reader, err = gzip.NewReader(r.Body)
+			case "deflate":
+				reader, err = zlib.NewReader(r.Body)
+			case "":
+			default:
+				panic("unexpected encoding")
+			}
+			if err != nil {
+				panic(err)
+			}
+			if r.Header.Get("Content-Encoding") != "" && !accept {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			var req struct {
+				Format string `json:"format"`
+			}
+			if err := json.NewDecoder(reader).Decode(&req); err != nil {
+				panic(err)
+			}
+			if req.Format != "json" {
+				panic("unexpected format")
+			}
+			w.Write([]byte(`{"measurement_id":"e00c584e6e9e5326"}`))
+		}),
+	)
+}
+
+func TestSubmitMeasurementWithCompression(t *testing.T) {
+	for _, encoding := range []string{
+		probeservices.CompressionGzip, probeservices.CompressionDeflate,
+	} {
+		t.Run(encoding, func(t *testing.T) {
+			var count int
+			server := newCompressionTestServer(true, &count)
+			defer server.Close()
+			ctx := context.Background()
+			template := newBatchTemplate()
+			client := newclient()
+			client.BaseURL = server.URL
+			client.Compression = encoding
+			report, err := client.OpenReport(ctx, template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			measurement := makeMeasurement(template, report.ID)
+			if err := report.SubmitMeasurement(ctx, &measurement); err != nil {
+				t.Fatal(err)
+			}
+			if measurement.OOID != "e00c584e6e9e5326" || count != 1 {
+				t.Fatal("unexpected result")
+			}
+		})
+	}
+}
+
+func TestSubmitMeasurementCompressionNotSupported(t *testing.T) {
+	var count int
+	server := newCompressionTestServer(false, &count)
+	defer server.Close()
+	ctx := context.Background()
+	template := newBatchTemplate()
+	client := newclient()
+	client.BaseURL = server.URL
+	client.Compression = probeservices.CompressionGzip
+	report, err := client.OpenReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	measurement := makeMeasurement(template, report.ID)
+	if err := report.SubmitMeasurement(ctx, &measurement); err != nil {
+		t.Fatal(err)
+	}
+	if measurement.OOID != "e00c584e6e9e5326" || count != 2 {
+		t.Fatal("we did not fall back to uncompressed submission")
+	}
+}
+
+func TestSubmitMeasurementUnsupportedCompression(t *testing.T) {
+	var count int
+	server := newCompressionTestServer(true, &count)
+	defer server.Close()
+	ctx := context.Background()
+	template := newBatchTemplate()
+	client := newclient()
+	client.BaseURL = server.URL
+	client.Compression = "antani"
+	report, err := client.OpenReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	measurement := makeMeasurement(template, report.ID)
+	err = report.SubmitMeasurement(ctx, &measurement)
+	if err != probeservices.ErrUnsupportedCompression {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestIsSupportedCompression(t *testing.T) {
+	for _, encoding := range []string{
+		probeservices.CompressionNone, probeservices.CompressionGzip,
+		probeservices.CompressionDeflate,
+	} {
+		if !probeservices.IsSupportedCompression(encoding) {
+			t.Fatal("we should support", encoding)
+		}
+	}
+	if probeservices.IsSupportedCompression("brotli") {
+		t.Fatal("we should not support brotli")
+	}
+}
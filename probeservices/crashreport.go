@@ -0,0 +1,91 @@
+package probeservices
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+)
+
+// ErrInvalidCrashReport indicates that a crash report does not conform to
+// the schema expected by the backend, hence we refuse to submit it.
+var ErrInvalidCrashReport = errors.New("probe services: invalid crash report")
+
+// ErrInvalidCrashReportsURL indicates that the URL of the crash reports
+// endpoint is not an absolute HTTP or HTTPS URL.
+var ErrInvalidCrashReportsURL = errors.New("probe services: invalid crash reports URL")
+
+// CrashReport describes an experiment that panicked. Like EngineMetrics,
+// crash reports are anonymous: they do not include the measurement, nor its
+// input, nor the logs, nor the probe IP, ASN, or country code. We only submit
+// them when the user has opted in (see SessionConfig.EnableCrashReports).
+type CrashReport struct {
+	// EngineVersion is the version of the engine.
+	EngineVersion string `json:"engine_version"`
+
+	// Experiment is the name of the experiment that panicked.
+	Experiment string `json:"experiment"`
+
+	// Failure describes the panic.
+	Failure string `json:"failure"`
+
+	// Platform is the platform name (e.g. "android").
+	Platform string `json:"platform"`
+
+	// SoftwareName is the name of the application.
+	SoftwareName string `json:"software_name"`
+
+	// SoftwareVersion is the version of the application.
+	SoftwareVersion string `json:"software_version"`
+
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack string `json:"stack"`
+
+	// Time is when the experiment panicked.
+	Time time.Time `json:"time"`
+}
+
+// Valid returns true if the crash report conforms to the schema.
+func (r CrashReport) Valid() bool {
+	return r.EngineVersion != "" && r.Experiment != "" && r.Platform != "" &&
+		r.SoftwareName != "" && r.SoftwareVersion != "" && r.Stack != "" &&
+		!r.Time.IsZero()
+}
+
+// ValidateCrashReportsURL returns ErrInvalidCrashReportsURL unless URL is
+// an absolute HTTP or HTTPS URL, as required by SubmitCrashReport.
+func ValidateCrashReportsURL(URL string) error {
+	_, err := parseCrashReportsURL(URL)
+	return err
+}
+
+func parseCrashReportsURL(URL string) (*url.URL, error) {
+	parsed, err := url.Parse(URL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") ||
+		parsed.Host == "" {
+		return nil, ErrInvalidCrashReportsURL
+	}
+	return parsed, nil
+}
+
+// SubmitCrashReport submits a crash report to the crash reports endpoint
+// at URL. The probe services do not implement such an API, therefore URL
+// is the full URL of the endpoint (see SessionConfig.CrashReportsURL) and
+// we only use c for its HTTP client, proxy, and retry policy. Like for
+// SubmitMetrics, this request is not authenticated.
+func (c Client) SubmitCrashReport(ctx context.Context, URL string, report CrashReport) error {
+	if !report.Valid() {
+		return ErrInvalidCrashReport
+	}
+	parsed, err := parseCrashReportsURL(URL)
+	if err != nil {
+		return err
+	}
+	clnt := c.Client
+	clnt.Authorization, clnt.BaseURL, clnt.Host = "", URL, ""
+	var resp struct{}
+	if err := clnt.PostJSON(ctx, parsed.Path, report, &resp); err != nil {
+		return newAPIError(err, parsed.Path)
+	}
+	return nil
+}
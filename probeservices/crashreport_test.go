@@ -0,0 +1,126 @@
+package probeservices_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func newCrashReport() probeservices.CrashReport {
+	return probeservices.CrashReport{
+		EngineVersion:   "0.1.0",
+		Experiment:      "example",
+		Failure:         "engine: experiment panicked: antani",
+		Platform:        "linux",
+		SoftwareName:    "miniooni",
+		SoftwareVersion: "0.1.0-dev",
+		Stack:           "goroutine 1 [running]:",
+		Time:            time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCrashReportValid(t *testing.T) {
+	if !newCrashReport().Valid() {
+		t.Fatal("expected a valid crash report here")
+	}
+	invalid := []func(*probeservices.CrashReport){
+		func(r *probeservices.CrashReport) { r.EngineVersion = "" },
+		func(r *probeservices.CrashReport) { r.Experiment = "" },
+		func(r *probeservices.CrashReport) { r.Platform = "" },
+		func(r *probeservices.CrashReport) { r.SoftwareName = "" },
+		func(r *probeservices.CrashReport) { r.SoftwareVersion = "" },
+		func(r *probeservices.CrashReport) { r.Stack = "" },
+		func(r *probeservices.CrashReport) { r.Time = time.Time{} },
+	}
+	for idx, fn := range invalid {
+		report := newCrashReport()
+		fn(&report)
+		if report.Valid() {
+			t.Fatalf("expected an invalid crash report for case #%d", idx)
+		}
+	}
+}
+
+func TestSubmitCrashReportSuccess(t *testing.T) {
+	var received probeservices.CrashReport
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/crashes" || r.URL.RawQuery != "key=antani" {
+				w.WriteHeader(404)
+				return
+			}
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(400)
+				return
+			}
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{}`))
+		}))
+	defer server.Close()
+	client := newclient()
+	client.Authorization = "Bearer antani"
+	client.Host = "api.ooni.io"
+	URL := server.URL + "/crashes?key=antani"
+	if err := client.SubmitCrashReport(context.Background(), URL, newCrashReport()); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(newCrashReport(), received); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestSubmitCrashReportInvalid(t *testing.T) {
+	client := newclient()
+	err := client.SubmitCrashReport(
+		context.Background(), "https://crashes.example.com/", probeservices.CrashReport{})
+	if !errors.Is(err, probeservices.ErrInvalidCrashReport) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestSubmitCrashReportFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(429)
+		}))
+	defer server.Close()
+	client := newclient()
+	err := client.SubmitCrashReport(context.Background(), server.URL, newCrashReport())
+	if !errors.Is(err, probeservices.ErrRateLimited) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestValidateCrashReportsURL(t *testing.T) {
+	valid := []string{"https://crashes.example.com/", "http://127.0.0.1:8080/crashes"}
+	for _, URL := range valid {
+		if err := probeservices.ValidateCrashReportsURL(URL); err != nil {
+			t.Fatal(URL, err)
+		}
+	}
+	invalid := []string{"", "/crashes", "ftp://crashes.example.com/", "https://", "http://\t/"}
+	for _, URL := range invalid {
+		err := probeservices.ValidateCrashReportsURL(URL)
+		if !errors.Is(err, probeservices.ErrInvalidCrashReportsURL) {
+			t.Fatal(URL, "not the error we expected", err)
+		}
+	}
+}
+
+func TestSubmitCrashReportInvalidURL(t *testing.T) {
+	client := newclient()
+	err := client.SubmitCrashReport(context.Background(), "/crashes", newCrashReport())
+	if !errors.Is(err, probeservices.ErrInvalidCrashReportsURL) {
+		t.Fatal("not the error we expected", err)
+	}
+}
@@ -0,0 +1,83 @@
+package probeservices
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// HelperCandidate is a candidate test helper.
+type HelperCandidate struct {
+	// Duration is the round trip time of a HEAD request.
+	Duration time.Duration
+
+	// Err indicates whether the test helper works.
+	Err error
+
+	// Helper is the test helper.
+	Helper model.Service
+}
+
+// OnlyHTTPSHelpers returns the "https" test helpers, i.e., the ones whose
+// Address is an URL that we can access directly. We skip the "legacy" ones
+// because the experiments using TryAllHelpers cannot use them.
+func OnlyHTTPSHelpers(in []model.Service) (out []model.Service) {
+	for _, entry := range in {
+		if entry.Type == "https" {
+			out = append(out, entry)
+		}
+	}
+	return
+}
+
+func (c *HelperCandidate) try(ctx context.Context, sess model.ExperimentSession) {
+	request, err := http.NewRequest("HEAD", c.Helper.Address, nil)
+	if err != nil {
+		c.Err = err
+		return
+	}
+	start := time.Now()
+	response, err := sess.DefaultHTTPClient().Do(request.WithContext(ctx))
+	c.Duration = time.Now().Sub(start)
+	if err != nil {
+		c.Err = err
+	} else {
+		// Any response, including errors like 405, means that
+		// the test helper is up and running.
+		response.Body.Close()
+	}
+	sess.Logger().Debugf("test helpers: %+v: %+v %s", c.Helper, err, c.Duration)
+}
+
+// TryAllHelpers measures in parallel the round trip time of each helper
+// returned by OnlyHTTPSHelpers(in). It returns a list where the helpers
+// that work come first, sorted by increasing round trip time, followed by the
+// helpers that do not work, if any.
+func TryAllHelpers(
+	ctx context.Context, sess model.ExperimentSession, in []model.Service) []*HelperCandidate {
+	var (
+		out []*HelperCandidate
+		wg  sync.WaitGroup
+	)
+	for _, helper := range OnlyHTTPSHelpers(in) {
+		candidate := &HelperCandidate{Helper: helper}
+		out = append(out, candidate)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			candidate.try(ctx, sess)
+		}()
+	}
+	wg.Wait()
+	sort.SliceStable(out, func(i, j int) bool {
+		if (out[i].Err == nil) != (out[j].Err == nil) {
+			return out[i].Err == nil
+		}
+		return out[i].Err == nil && out[i].Duration < out[j].Duration
+	})
+	return out
+}
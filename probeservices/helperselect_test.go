@@ -0,0 +1,81 @@
+package probeservices_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func newHelperServer(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			w.WriteHeader(405) // still means that the helper works
+		}))
+}
+
+func TestOnlyHTTPSHelpers(t *testing.T) {
+	out := probeservices.OnlyHTTPSHelpers([]model.Service{
+		{Address: "https://a.org", Type: "https"},
+		{Address: "https://b.org", Type: "cloudfront", Front: "c.org"},
+		{Address: "http://d.org", Type: "legacy"},
+	})
+	if len(out) != 1 || out[0].Address != "https://a.org" {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestTryAllHelpers(t *testing.T) {
+	slow := newHelperServer(200 * time.Millisecond)
+	defer slow.Close()
+	fast := newHelperServer(0)
+	defer fast.Close()
+	broken := newHelperServer(0)
+	broken.Close()
+	sess := &mockable.ExperimentSession{
+		MockableHTTPClient: http.DefaultClient,
+		MockableLogger:     log.Log,
+	}
+	out := probeservices.TryAllHelpers(context.Background(), sess, []model.Service{
+		{Address: broken.URL, Type: "https"},
+		{Address: slow.URL, Type: "https"},
+		{Address: "https://b.org", Type: "cloudfront", Front: "c.org"},
+		{Address: fast.URL, Type: "https"},
+		{Address: "http://d.org", Type: "legacy"},
+	})
+	if len(out) != 3 {
+		t.Fatal("unexpected number of candidates")
+	}
+	if out[0].Helper.Address != fast.URL || out[0].Err != nil {
+		t.Fatal("the fast helper should be the first")
+	}
+	if out[1].Helper.Address != slow.URL || out[1].Err != nil {
+		t.Fatal("the slow helper should be the second")
+	}
+	if out[2].Helper.Address != broken.URL || out[2].Err == nil {
+		t.Fatal("the broken helper should be the last")
+	}
+	if out[0].Duration >= out[1].Duration {
+		t.Fatal("unexpected durations")
+	}
+}
+
+func TestTryAllHelpersInvalidURL(t *testing.T) {
+	sess := &mockable.ExperimentSession{
+		MockableHTTPClient: http.DefaultClient,
+		MockableLogger:     log.Log,
+	}
+	out := probeservices.TryAllHelpers(context.Background(), sess, []model.Service{
+		{Address: "\t\t\t", Type: "https"},
+	})
+	if len(out) != 1 || out[0].Err == nil {
+		t.Fatal("expected a failure here")
+	}
+}
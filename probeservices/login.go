@@ -5,6 +5,16 @@ import (
 	"time"
 )
 
+const (
+	// TokenRefreshMargin is how long before the token expires we start
+	// trying to get a new token, so that we never use an expired token.
+	TokenRefreshMargin = 5 * time.Minute
+
+	// TokenRefreshRetryDelay is how long RunTokenRefresher waits before
+	// trying again after it failed to get a new token.
+	TokenRefreshRetryDelay = time.Minute
+)
+
 // LoginCredentials contains the login credentials
 type LoginCredentials struct {
 	ClientID string `json:"username"`
@@ -27,12 +37,68 @@ func (c Client) MaybeLogin(ctx context.Context) error {
 	if creds == nil {
 		return ErrNotRegistered
 	}
+	_, err := c.login(ctx, state, creds)
+	return err
+}
+
+// Auth returns the authentication info to use for API calls. If the token
+// we have expires in less than TokenRefreshMargin, or we do not have a token
+// at all, Auth logs in again. If logging in fails but the token we have is
+// still valid, we return such token. Otherwise, we return the error. This
+// method hence never returns an expired token. We need to be registered
+// beforehand, otherwise this method fails with ErrNotRegistered.
+func (c Client) Auth(ctx context.Context) (*LoginAuth, error) {
+	state := c.StateFile.Get()
+	auth := state.Auth()
+	if auth != nil && time.Now().Add(TokenRefreshMargin).Before(auth.Expire) {
+		return auth, nil
+	}
+	creds := state.Credentials()
+	if creds == nil {
+		return nil, ErrNotRegistered
+	}
+	newAuth, err := c.login(ctx, state, creds)
+	if err != nil && auth != nil {
+		c.Logger.Debugf("probeservices: cannot refresh token: %s", err.Error())
+		return auth, nil
+	}
+	return newAuth, err
+}
+
+// RunTokenRefresher refreshes the token TokenRefreshMargin before it
+// expires, until the context is done. If refreshing fails, we try again
+// after TokenRefreshRetryDelay. This is meant to be used by long running
+// sessions, and you typically want to run it in a background goroutine.
+func (c Client) RunTokenRefresher(ctx context.Context) {
+	for {
+		delay := TokenRefreshRetryDelay
+		if auth, err := c.Auth(ctx); err == nil {
+			delay = time.Until(auth.Expire.Add(-TokenRefreshMargin))
+		}
+		if delay < TokenRefreshRetryDelay {
+			delay = TokenRefreshRetryDelay // short lived token?
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (c Client) login(
+	ctx context.Context, state State, creds *LoginCredentials) (*LoginAuth, error) {
 	c.LoginCalls.Add(1)
 	var auth LoginAuth
 	if err := c.Client.PostJSON(ctx, "/api/v1/login", *creds, &auth); err != nil {
-		return err
+		return nil, newAPIError(err, "/api/v1/login")
 	}
 	state.Expire = auth.Expire
 	state.Token = auth.Token
-	return c.StateFile.Set(state)
+	if err := c.StateFile.Set(state); err != nil {
+		return nil, err
+	}
+	return &auth, nil
 }
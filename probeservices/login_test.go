@@ -2,6 +2,10 @@ package probeservices_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -71,3 +75,115 @@ func TestIntegrationMaybeLoginIdempotent(t *testing.T) {
 		t.Fatal("called login API too many times")
 	}
 }
+
+func newLoginServer(expire time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/login" {
+				w.WriteHeader(404)
+				return
+			}
+			json.NewEncoder(w).Encode(probeservices.LoginAuth{
+				Expire: time.Now().Add(expire),
+				Token:  "fresh-token",
+			})
+		}))
+}
+
+func newLoggedInClient(URL string, expire time.Duration) *probeservices.Client {
+	clnt := newclient()
+	clnt.BaseURL = URL
+	state := probeservices.State{
+		ClientID: "xx-xxx-x-xxxx",
+		Expire:   time.Now().Add(expire),
+		Password: "xx",
+		Token:    "old-token",
+	}
+	if err := clnt.StateFile.Set(state); err != nil {
+		panic(err)
+	}
+	return clnt
+}
+
+func TestAuthWithFreshToken(t *testing.T) {
+	server := newLoginServer(time.Hour)
+	defer server.Close()
+	clnt := newLoggedInClient(server.URL, time.Hour)
+	auth, err := clnt.Auth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Token != "old-token" {
+		t.Fatal("not the token we expected")
+	}
+	if clnt.LoginCalls.Load() != 0 {
+		t.Fatal("should not have called login")
+	}
+}
+
+func TestAuthRefreshesTokenAboutToExpire(t *testing.T) {
+	server := newLoginServer(time.Hour)
+	defer server.Close()
+	clnt := newLoggedInClient(server.URL, time.Minute)
+	auth, err := clnt.Auth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Token != "fresh-token" {
+		t.Fatal("not the token we expected")
+	}
+	if clnt.StateFile.Get().Token != "fresh-token" {
+		t.Fatal("did not save the token")
+	}
+	if clnt.LoginCalls.Load() != 1 {
+		t.Fatal("should have called login once")
+	}
+}
+
+func TestAuthRefreshFailureWithValidToken(t *testing.T) {
+	clnt := newLoggedInClient("\t\t\t", time.Minute) // causes login to fail
+	auth, err := clnt.Auth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Token != "old-token" {
+		t.Fatal("not the token we expected")
+	}
+}
+
+func TestAuthRefreshFailureWithExpiredToken(t *testing.T) {
+	clnt := newLoggedInClient("\t\t\t", -time.Minute) // causes login to fail
+	auth, err := clnt.Auth(context.Background())
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if auth != nil {
+		t.Fatal("expected nil auth here")
+	}
+}
+
+func TestAuthNotRegistered(t *testing.T) {
+	clnt := newclient()
+	auth, err := clnt.Auth(context.Background())
+	if !errors.Is(err, probeservices.ErrNotRegistered) {
+		t.Fatal("not the error we expected")
+	}
+	if auth != nil {
+		t.Fatal("expected nil auth here")
+	}
+}
+
+func TestRunTokenRefresher(t *testing.T) {
+	server := newLoginServer(time.Hour)
+	defer server.Close()
+	clnt := newLoggedInClient(server.URL, -time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	clnt.RunTokenRefresher(ctx) // returns when the context expires
+	if clnt.StateFile.Get().Token != "fresh-token" {
+		t.Fatal("did not refresh the token")
+	}
+	if clnt.LoginCalls.Load() != 1 {
+		t.Fatal("should have called login once")
+	}
+}
@@ -0,0 +1,79 @@
+package probeservices
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidMetrics indicates that the metrics do not conform to the
+// schema expected by the backend, hence we refuse to submit them.
+var ErrInvalidMetrics = errors.New("probe services: invalid metrics")
+
+const (
+	// MetricsResolverDoH indicates that the session resolver is
+	// using DNS over HTTPS successfully.
+	MetricsResolverDoH = "doh"
+
+	// MetricsResolverSystem indicates that the session resolver
+	// has fallen back to using the system resolver.
+	MetricsResolverSystem = "system"
+)
+
+// EngineMetrics contains aggregate metrics describing the health of the
+// engine. These metrics are anonymous: they do not include any measurement,
+// nor the probe IP, ASN, or country code, nor the client ID. We only submit
+// them when the user has opted in (see SessionConfig.EnableMetrics).
+type EngineMetrics struct {
+	// BootstrapsAttempted is the number of times we have tried to
+	// discover and select the probe services.
+	BootstrapsAttempted int64 `json:"bootstraps_attempted"`
+
+	// BootstrapsSucceeded is the number of times we have succeeded
+	// in discovering and selecting the probe services.
+	BootstrapsSucceeded int64 `json:"bootstraps_succeeded"`
+
+	// Platform is the platform name (e.g. "android").
+	Platform string `json:"platform"`
+
+	// Resolver is the resolver used by the engine. It's one of
+	// MetricsResolverDoH and MetricsResolverSystem.
+	Resolver string `json:"resolver"`
+
+	// SoftwareName is the name of the application.
+	SoftwareName string `json:"software_name"`
+
+	// SoftwareVersion is the version of the application.
+	SoftwareVersion string `json:"software_version"`
+
+	// SubmissionsFailed is the number of times we have failed
+	// to submit a measurement to the collector.
+	SubmissionsFailed int64 `json:"submissions_failed"`
+}
+
+// Valid returns true if the metrics conform to the schema.
+func (m EngineMetrics) Valid() bool {
+	if m.BootstrapsAttempted < 0 || m.BootstrapsSucceeded < 0 ||
+		m.SubmissionsFailed < 0 {
+		return false
+	}
+	if m.BootstrapsSucceeded > m.BootstrapsAttempted {
+		return false
+	}
+	if m.Resolver != MetricsResolverDoH && m.Resolver != MetricsResolverSystem {
+		return false
+	}
+	return m.Platform != "" && m.SoftwareName != "" && m.SoftwareVersion != ""
+}
+
+// SubmitMetrics submits the engine metrics to the backend. This request
+// is not authenticated, so that we cannot link metrics to a probe.
+func (c Client) SubmitMetrics(ctx context.Context, metrics EngineMetrics) error {
+	if !metrics.Valid() {
+		return ErrInvalidMetrics
+	}
+	var resp struct{}
+	if err := c.Client.PostJSON(ctx, "/api/v1/metrics", metrics, &resp); err != nil {
+		return newAPIError(err, "/api/v1/metrics")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package probeservices_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func newEngineMetrics() probeservices.EngineMetrics {
+	return probeservices.EngineMetrics{
+		BootstrapsAttempted: 2,
+		BootstrapsSucceeded: 1,
+		Platform:            "linux",
+		Resolver:            probeservices.MetricsResolverDoH,
+		SoftwareName:        "miniooni",
+		SoftwareVersion:     "0.1.0-dev",
+		SubmissionsFailed:   3,
+	}
+}
+
+func TestEngineMetricsValid(t *testing.T) {
+	if !newEngineMetrics().Valid() {
+		t.Fatal("expected valid metrics here")
+	}
+	invalid := []func(*probeservices.EngineMetrics){
+		func(m *probeservices.EngineMetrics) { m.BootstrapsAttempted = -1 },
+		func(m *probeservices.EngineMetrics) { m.BootstrapsSucceeded = 3 },
+		func(m *probeservices.EngineMetrics) { m.SubmissionsFailed = -1 },
+		func(m *probeservices.EngineMetrics) { m.Resolver = "8.8.8.8" },
+		func(m *probeservices.EngineMetrics) { m.Platform = "" },
+		func(m *probeservices.EngineMetrics) { m.SoftwareName = "" },
+		func(m *probeservices.EngineMetrics) { m.SoftwareVersion = "" },
+	}
+	for idx, fn := range invalid {
+		metrics := newEngineMetrics()
+		fn(&metrics)
+		if metrics.Valid() {
+			t.Fatalf("expected invalid metrics for case #%d", idx)
+		}
+	}
+}
+
+func TestSubmitMetricsSuccess(t *testing.T) {
+	var received probeservices.EngineMetrics
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/api/v1/metrics" {
+				w.WriteHeader(404)
+				return
+			}
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(400)
+				return
+			}
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{}`))
+		}))
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	if err := client.SubmitMetrics(context.Background(), newEngineMetrics()); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(newEngineMetrics(), received); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestSubmitMetricsInvalid(t *testing.T) {
+	client := newclient()
+	err := client.SubmitMetrics(context.Background(), probeservices.EngineMetrics{})
+	if !errors.Is(err, probeservices.ErrInvalidMetrics) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestSubmitMetricsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(429)
+		}))
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	err := client.SubmitMetrics(context.Background(), newEngineMetrics())
+	if !errors.Is(err, probeservices.ErrRateLimited) {
+		t.Fatal("not the error we expected")
+	}
+}
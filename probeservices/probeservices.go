@@ -24,8 +24,10 @@
 package probeservices
 
 import (
+	"context"
 	"errors"
 	"net/url"
+	"strings"
 
 	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/internal/httpx"
@@ -51,14 +53,34 @@ var (
 
 	// ErrInvalidMetadata indicates that the metadata is not valid
 	ErrInvalidMetadata = errors.New("invalid metadata")
+
+	// ErrUnsupportedOnionAddress indicates that we don't support this
+	// onion address (e.g. wrong scheme, not a .onion domain).
+	ErrUnsupportedOnionAddress = errors.New(
+		"probe services: unsupported onion address",
+	)
+
+	// ErrOnionRequiresProxy indicates that we cannot use an onion
+	// endpoint because we are not using a tor proxy or tunnel.
+	ErrOnionRequiresProxy = errors.New(
+		"probe services: onion endpoint requires a proxy",
+	)
 )
 
-// Client is a client for the OONI probe services API.
+// Client is a client for the OONI probe services API. The Compression
+// field controls the compression we use when submitting measurements. By
+// default, we don't use any compression (i.e., CompressionNone). Clients
+// created using NewClient retry API calls failing with transient errors
+// using httpx.NewRetryPolicy(). Use Client.RetryPolicy.Retries() to know
+// how many times we have retried each API call. If the UploadProgress field
+// is not nil, we call it to report progress when submitting measurements.
 type Client struct {
 	httpx.Client
-	LoginCalls    *atomicx.Int64
-	RegisterCalls *atomicx.Int64
-	StateFile     StateFile
+	Compression    string
+	LoginCalls     *atomicx.Int64
+	RegisterCalls  *atomicx.Int64
+	StateFile      StateFile
+	UploadProgress UploadProgressFunc
 }
 
 // GetCredsAndAuth is an utility function that returns the credentials with
@@ -80,17 +102,22 @@ func (c Client) GetCredsAndAuth() (*LoginCredentials, *LoginAuth, error) {
 // NewClient creates a new client for the specified probe services endpoint. This
 // function fails, e.g., we don't support the specified endpoint.
 func NewClient(sess model.ExperimentSession, endpoint model.Service) (*Client, error) {
+	stateFile, err := newStateFile(sess)
+	if err != nil {
+		return nil, err
+	}
 	client := &Client{
 		Client: httpx.Client{
-			BaseURL:    endpoint.Address,
-			HTTPClient: sess.DefaultHTTPClient(),
-			Logger:     sess.Logger(),
-			ProxyURL:   sess.ProxyURL(),
-			UserAgent:  sess.UserAgent(),
+			BaseURL:     endpoint.Address,
+			HTTPClient:  sess.DefaultHTTPClient(),
+			Logger:      model.WithComponent(sess.Logger(), "probeservices"),
+			ProxyURL:    sess.ProxyURL(),
+			RetryPolicy: httpx.NewRetryPolicy(),
+			UserAgent:   sess.UserAgent(),
 		},
 		LoginCalls:    atomicx.NewInt64(),
 		RegisterCalls: atomicx.NewInt64(),
-		StateFile:     NewStateFile(sess.KeyValueStore()),
+		StateFile:     stateFile,
 	}
 	switch endpoint.Type {
 	case "https":
@@ -113,7 +140,57 @@ func NewClient(sess model.ExperimentSession, endpoint model.Service) (*Client, e
 			return nil, err
 		}
 		return client, nil
+	case "onion":
+		// Onion services are only reachable using tor, hence we require
+		// that the session is already using a tor proxy or tunnel (use
+		// NewClientWithTunnel to bootstrap the tunnel on demand). We
+		// accept both `httpo://` URLs, which is the OONI convention for
+		// onion services, and `http://` URLs.
+		URL, err := url.Parse(client.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		if (URL.Scheme != "httpo" && URL.Scheme != "http") ||
+			!strings.HasSuffix(URL.Hostname(), ".onion") {
+			return nil, ErrUnsupportedOnionAddress
+		}
+		if client.ProxyURL == nil {
+			return nil, ErrOnionRequiresProxy
+		}
+		URL.Scheme = "http"
+		client.BaseURL = URL.String()
+		return client, nil
 	default:
 		return nil, ErrUnsupportedEndpoint
 	}
 }
+
+// stateEncryptionKeyProvider is a session that wants us to encrypt the
+// state file using the returned key, if not nil.
+type stateEncryptionKeyProvider interface {
+	StateEncryptionKey() []byte
+}
+
+// newStateFile creates the state file of a new client, which is encrypted
+// if sess provides us with a key (see NewEncryptedStateFile).
+func newStateFile(sess model.ExperimentSession) (StateFile, error) {
+	if p, ok := sess.(stateEncryptionKeyProvider); ok && p.StateEncryptionKey() != nil {
+		return NewEncryptedStateFile(sess.KeyValueStore(), p.StateEncryptionKey())
+	}
+	return NewStateFile(sess.KeyValueStore()), nil
+}
+
+// NewClientWithTunnel is like NewClient except that, if endpoint is an
+// onion endpoint and the session is not using any proxy, we bootstrap the
+// session's tor tunnel first, such that we can route the requests to the
+// onion service through the tunnel's SOCKS5 proxy.
+func NewClientWithTunnel(ctx context.Context, sess model.ExperimentSession,
+	endpoint model.Service) (*Client, error) {
+	if endpoint.Type == "onion" && sess.ProxyURL() == nil {
+		sess.Logger().Infof("probe services: starting tor to reach %s", endpoint.Address)
+		if err := sess.MaybeStartTunnel(ctx, "tor"); err != nil {
+			return nil, err
+		}
+	}
+	return NewClient(sess, endpoint)
+}
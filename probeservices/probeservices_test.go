@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"testing"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/apex/log"
 	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/probeservices"
@@ -36,6 +38,41 @@ func newclient() *probeservices.Client {
 	return client
 }
 
+// encryptingSession is a session that wants the state to be encrypted.
+type encryptingSession struct {
+	*mockable.ExperimentSession
+	store model.KeyValueStore
+}
+
+func (sess *encryptingSession) KeyValueStore() model.KeyValueStore {
+	return sess.store
+}
+
+func (sess *encryptingSession) StateEncryptionKey() []byte {
+	return []byte("0123456789abcdef")
+}
+
+func TestNewClientEncryptsTheState(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	clnt, err := probeservices.NewClient(&encryptingSession{
+		ExperimentSession: &mockable.ExperimentSession{},
+		store:             store,
+	}, model.Service{Address: "https://x.org", Type: "https"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := clnt.StateFile.Set(probeservices.State{Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.Get("orchestra.state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatal("we did not encrypt the state")
+	}
+}
+
 func TestNewClientHTTPS(t *testing.T) {
 	client, err := probeservices.NewClient(
 		&mockable.ExperimentSession{}, model.Service{
@@ -48,13 +85,16 @@ func TestNewClientHTTPS(t *testing.T) {
 	if client.BaseURL != "https://x.org" {
 		t.Fatal("not the URL we expected")
 	}
+	if client.RetryPolicy == nil {
+		t.Fatal("expected a retry policy here")
+	}
 }
 
 func TestNewClientUnsupportedEndpoint(t *testing.T) {
 	client, err := probeservices.NewClient(
 		&mockable.ExperimentSession{}, model.Service{
 			Address: "https://x.org",
-			Type:    "onion",
+			Type:    "antani",
 		})
 	if !errors.Is(err, probeservices.ErrUnsupportedEndpoint) {
 		t.Fatal("not the error we expected")
@@ -64,6 +104,50 @@ func TestNewClientUnsupportedEndpoint(t *testing.T) {
 	}
 }
 
+func TestNewClientOnionWithoutProxy(t *testing.T) {
+	client, err := probeservices.NewClient(
+		&mockable.ExperimentSession{}, model.Service{
+			Address: "httpo://jehhrikjjqrlpufu.onion",
+			Type:    "onion",
+		})
+	if !errors.Is(err, probeservices.ErrOnionRequiresProxy) {
+		t.Fatal("not the error we expected")
+	}
+	if client != nil {
+		t.Fatal("expected nil client here")
+	}
+}
+
+func TestNewClientOnionUnsupportedAddress(t *testing.T) {
+	client, err := probeservices.NewClient(
+		&mockable.ExperimentSession{}, model.Service{
+			Address: "https://x.org",
+			Type:    "onion",
+		})
+	if !errors.Is(err, probeservices.ErrUnsupportedOnionAddress) {
+		t.Fatal("not the error we expected")
+	}
+	if client != nil {
+		t.Fatal("expected nil client here")
+	}
+}
+
+func TestNewClientOnionWithProxy(t *testing.T) {
+	client, err := probeservices.NewClient(
+		&mockable.ExperimentSession{
+			MockableProxyURL: &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"},
+		}, model.Service{
+			Address: "httpo://jehhrikjjqrlpufu.onion",
+			Type:    "onion",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL != "http://jehhrikjjqrlpufu.onion" {
+		t.Fatal("not the URL we expected")
+	}
+}
+
 func TestNewClientCloudfrontInvalidURL(t *testing.T) {
 	client, err := probeservices.NewClient(
 		&mockable.ExperimentSession{}, model.Service{
@@ -261,6 +345,30 @@ func TestOnlyHTTPS(t *testing.T) {
 	}
 }
 
+func TestOnlyCloudfront(t *testing.T) {
+	in := []model.Service{{
+		Type:    "onion",
+		Address: "httpo://jehhrikjjqrlpufu.onion",
+	}, {
+		Type:    "https",
+		Address: "https://ams-ps-nonexistent.ooni.io",
+	}, {
+		Front:   "dkyhjv0wpi2dk.cloudfront.net",
+		Type:    "cloudfront",
+		Address: "https://dkyhjv0wpi2dk.cloudfront.net",
+	}}
+	expect := []model.Service{{
+		Front:   "dkyhjv0wpi2dk.cloudfront.net",
+		Type:    "cloudfront",
+		Address: "https://dkyhjv0wpi2dk.cloudfront.net",
+	}}
+	out := probeservices.OnlyCloudfront(in)
+	diff := cmp.Diff(out, expect)
+	if diff != "" {
+		t.Fatal(diff)
+	}
+}
+
 func TestOnlyFallbacks(t *testing.T) {
 	// put onion first so we also verify that we sort the endpoints
 	in := []model.Service{{
@@ -384,7 +492,7 @@ func TestTryAllCanceledContext(t *testing.T) {
 	// and so we don't basically do anything. But it also may be nonzero since
 	// we also run tests in the cloud, which is slower than my desktop. So, I
 	// have not written a specific test concerning out[4].Duration.
-	if !errors.Is(out[4].Err, probeservices.ErrUnsupportedEndpoint) {
+	if !errors.Is(out[4].Err, probeservices.ErrOnionRequiresProxy) {
 		t.Fatal("invalid error")
 	}
 	if out[4].Endpoint.Type != "onion" {
@@ -625,3 +733,54 @@ func TestGetCredsAndAuthNotLoggedIn(t *testing.T) {
 		t.Fatal("expected nil auth here")
 	}
 }
+
+func TestNewClientWithTunnelStartsTunnelForOnion(t *testing.T) {
+	expected := errors.New("mocked error")
+	client, err := probeservices.NewClientWithTunnel(
+		context.Background(), &mockable.ExperimentSession{
+			MockableLogger:              log.Log,
+			MockableMaybeStartTunnelErr: expected,
+		}, model.Service{
+			Address: "httpo://jehhrikjjqrlpufu.onion",
+			Type:    "onion",
+		})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if client != nil {
+		t.Fatal("expected nil client here")
+	}
+}
+
+func TestNewClientWithTunnelOnionWithProxy(t *testing.T) {
+	client, err := probeservices.NewClientWithTunnel(
+		context.Background(), &mockable.ExperimentSession{
+			MockableMaybeStartTunnelErr: errors.New("mocked error"),
+			MockableProxyURL:            &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"},
+		}, model.Service{
+			Address: "httpo://jehhrikjjqrlpufu.onion",
+			Type:    "onion",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL != "http://jehhrikjjqrlpufu.onion" {
+		t.Fatal("not the URL we expected")
+	}
+}
+
+func TestNewClientWithTunnelDoesNotStartTunnelForHTTPS(t *testing.T) {
+	client, err := probeservices.NewClientWithTunnel(
+		context.Background(), &mockable.ExperimentSession{
+			MockableMaybeStartTunnelErr: errors.New("mocked error"),
+		}, model.Service{
+			Address: "https://ps-test.ooni.io",
+			Type:    "https",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL != "https://ps-test.ooni.io" {
+		t.Fatal("not the URL we expected")
+	}
+}
@@ -0,0 +1,53 @@
+package probeservices
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// UploadProgressFunc is a function called while we upload measurements
+// with the number of bytes sent so far and the total number of bytes. Note
+// that the HTTP code may call this function from a background goroutine.
+type UploadProgressFunc func(sent, total int64)
+
+type progressReader struct {
+	fn     UploadProgressFunc
+	reader io.Reader
+	sent   int64
+	total  int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	count, err := pr.reader.Read(p)
+	if count > 0 {
+		pr.sent += int64(count)
+		pr.fn(pr.sent, pr.total)
+	}
+	return count, err
+}
+
+// newUploadRequest creates a new POST request for resourcePath having
+// the specified body. If c.UploadProgress is set, we call it while we
+// are sending the body, including when the request is retried.
+func (c Client) newUploadRequest(
+	ctx context.Context, resourcePath string, body []byte) (*http.Request, error) {
+	request, err := c.Client.NewRequest(
+		ctx, "POST", resourcePath, nil, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.UploadProgress != nil {
+		request.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(&progressReader{
+				fn:     c.UploadProgress,
+				reader: bytes.NewReader(body),
+				total:  int64(len(body)),
+			}), nil
+		}
+		request.Body, _ = request.GetBody()
+	}
+	return request, nil
+}
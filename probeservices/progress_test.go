@@ -0,0 +1,43 @@
+package probeservices_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func TestSubmitMeasurementUploadProgress(t *testing.T) {
+	for _, encoding := range []string{
+		probeservices.CompressionNone, probeservices.CompressionGzip,
+	} {
+		t.Run(encoding, func(t *testing.T) {
+			var count int
+			server := newCompressionTestServer(true, &count)
+			defer server.Close()
+			ctx := context.Background()
+			template := newBatchTemplate()
+			client := newclient()
+			client.BaseURL = server.URL
+			client.Compression = encoding
+			var calls, lastSent, lastTotal int64
+			client.UploadProgress = func(sent, total int64) {
+				if sent < lastSent || sent > total {
+					panic("unexpected progress")
+				}
+				calls, lastSent, lastTotal = calls+1, sent, total
+			}
+			report, err := client.OpenReport(ctx, template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			measurement := makeMeasurement(template, report.ID)
+			if err := report.SubmitMeasurement(ctx, &measurement); err != nil {
+				t.Fatal(err)
+			}
+			if calls <= 0 || lastSent != lastTotal || lastTotal <= 0 {
+				t.Fatal("unexpected progress reports")
+			}
+		})
+	}
+}
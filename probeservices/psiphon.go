@@ -6,12 +6,16 @@ import (
 )
 
 // FetchPsiphonConfig fetches psiphon config from authenticated OONI orchestra.
+// We use c.Auth to make sure we do not use an expired token. We cache the
+// config in the key-value store and use its ETag to avoid downloading it
+// again when it did not change.
 func (c Client) FetchPsiphonConfig(ctx context.Context) ([]byte, error) {
-	_, auth, err := c.GetCredsAndAuth()
+	auth, err := c.Auth(ctx)
 	if err != nil {
 		return nil, err
 	}
 	client := c.Client
 	client.Authorization = fmt.Sprintf("Bearer %s", auth.Token)
-	return client.FetchResource(ctx, "/api/v1/test-list/psiphon-config")
+	return c.fetchCachedResource(
+		ctx, client, "psiphon-config", "/api/v1/test-list/psiphon-config", nil)
 }
@@ -32,7 +32,7 @@ func (c Client) MaybeRegister(ctx context.Context, metadata Metadata) error {
 	}
 	var resp registerResult
 	if err := c.Client.PostJSON(ctx, "/api/v1/register", req, &resp); err != nil {
-		return err
+		return newAPIError(err, "/api/v1/register")
 	}
 	state.ClientID = resp.ClientID
 	state.Password = pwd
@@ -0,0 +1,102 @@
+package probeservices
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportExpiry is the maximum amount of time for which we reuse a report
+// that we previously opened using OpenOrResumeReport.
+const ReportExpiry = 1 * time.Hour
+
+// OpenOrResumeReport is like OpenReport, except that it saves the ID of
+// the report it opens into the StateFile. If the process is restarted
+// mid-run, calling this function again with an identical template returns
+// the previously opened report, as long as such report has not expired.
+//
+// Because several experiments of this process may be using the same report
+// at the same time, closing a report opened with this function only closes
+// it on the collector, and removes it from the StateFile, when it is closed
+// by the last experiment using it.
+func (c Client) OpenOrResumeReport(ctx context.Context, rt ReportTemplate) (*Report, error) {
+	key := reportKey(rt)
+	state := c.StateFile.Get()
+	if pr, found := state.Reports[key]; found && time.Now().Before(pr.Expire) {
+		c.Logger.Debugf("collector.go: resuming report %s", pr.ID)
+		reportUsers.acquire(pr.ID)
+		return &Report{ID: pr.ID, client: c, key: key}, nil
+	}
+	report, err := c.OpenReport(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+	report.key = key
+	reportUsers.acquire(report.ID)
+	state = c.StateFile.Get() // reload since time has passed
+	if state.Reports == nil {
+		state.Reports = make(map[string]PersistedReport)
+	}
+	state.Reports[key] = PersistedReport{
+		Expire: time.Now().Add(ReportExpiry),
+		ID:     report.ID,
+	}
+	if err := c.StateFile.Set(state); err != nil {
+		// Not being able to persist the report is not fatal, since
+		// we can use the report anyway. So, just log the error.
+		c.Logger.Debugf("collector.go: cannot persist report: %s", err.Error())
+	}
+	return report, nil
+}
+
+// forgetReport removes the report with the given key and ID from the
+// StateFile, unless we have since then persisted another report.
+func (c Client) forgetReport(key, ID string) {
+	state := c.StateFile.Get()
+	if pr, found := state.Reports[key]; !found || pr.ID != ID {
+		return
+	}
+	delete(state.Reports, key)
+	if err := c.StateFile.Set(state); err != nil {
+		c.Logger.Debugf("collector.go: cannot forget report: %s", err.Error())
+	}
+}
+
+// reportKey returns the key of a report, which depends on all the fields
+// of the template, since the collector uses them to validate measurements.
+func reportKey(rt ReportTemplate) string {
+	return strings.Join([]string{
+		rt.TestName, rt.TestVersion, rt.ProbeASN, rt.ProbeCC, rt.SoftwareName,
+		rt.SoftwareVersion, rt.DataFormatVersion, rt.Format,
+	}, "/")
+}
+
+// reportUsers counts how many users of this process are using each of
+// the reports opened or resumed using OpenOrResumeReport.
+var reportUsers = &reportUsersCounter{users: make(map[string]int)}
+
+type reportUsersCounter struct {
+	mu    sync.Mutex
+	users map[string]int
+}
+
+func (c *reportUsersCounter) acquire(ID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[ID]++
+}
+
+// release returns the number of users of the report that remain.
+func (c *reportUsersCounter) release(ID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.users[ID] > 0 {
+		c.users[ID]--
+	}
+	count := c.users[ID]
+	if count <= 0 {
+		delete(c.users, ID)
+	}
+	return count
+}
@@ -0,0 +1,121 @@
+package probeservices_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func TestOpenOrResumeReport(t *testing.T) {
+	var opened, closed int
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI == "/report" {
+				opened++
+				ID := "_id"
+				if opened > 1 {
+					ID = fmt.Sprintf("_id%d", opened)
+				}
+				fmt.Fprintf(w, `{"report_id":"%s","supported_formats":["json"]}`, ID)
+				return
+			}
+			if r.RequestURI == "/report/_id/close" {
+				closed++
+				w.Write([]byte(`{}`))
+				return
+			}
+			panic(r.RequestURI)
+		}),
+	)
+	defer server.Close()
+	ctx := context.Background()
+	template := newBatchTemplate()
+	client := newclient()
+	client.BaseURL = server.URL
+	report, err := client.OpenOrResumeReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ID != "_id" || opened != 1 {
+		t.Fatal("unexpected report")
+	}
+	// Simulate a restart by creating a new client sharing the state file
+	other := newclient()
+	other.BaseURL = server.URL
+	other.StateFile = client.StateFile
+	resumed, err := other.OpenOrResumeReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.ID != "_id" || opened != 1 {
+		t.Fatal("we did not resume the report")
+	}
+	// A different test name or test version should cause us to open a new report
+	for _, change := range []func(){
+		func() { template.TestName = "antani" },
+		func() { template.TestVersion = "0.2.0" },
+	} {
+		change()
+		if _, err := other.OpenOrResumeReport(ctx, template); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opened != 3 {
+		t.Fatal("we did not open new reports")
+	}
+	// Closing while another user is using the report should not close it
+	if err := report.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 0 {
+		t.Fatal("we closed a report which is still in use")
+	}
+	// Closing by the last user should remove the report from the state file
+	if err := resumed.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 1 {
+		t.Fatal("we did not close the report")
+	}
+	if len(client.StateFile.Get().Reports) != 2 {
+		t.Fatal("the report is still in the state file")
+	}
+}
+
+func TestOpenOrResumeReportExpired(t *testing.T) {
+	var opened int
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			opened++
+			w.Write([]byte(`{"report_id":"_id","supported_formats":["json"]}`))
+		}),
+	)
+	defer server.Close()
+	ctx := context.Background()
+	template := newBatchTemplate()
+	client := newclient()
+	client.BaseURL = server.URL
+	if err := client.StateFile.Set(probeservices.State{
+		Reports: map[string]probeservices.PersistedReport{
+			"dummy/0.1.0/AS0/ZZ/ooniprobe-engine/0.1.0/" +
+				probeservices.DefaultDataFormatVersion + "/" + probeservices.DefaultFormat: {
+				Expire: time.Now().Add(-1 * time.Second),
+				ID:     "_expired",
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := client.OpenOrResumeReport(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ID != "_id" || opened != 1 {
+		t.Fatal("we resumed an expired report")
+	}
+}
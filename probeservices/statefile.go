@@ -2,8 +2,10 @@ package probeservices
 
 import (
 	"encoding/json"
+	"errors"
 	"time"
 
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/model"
 )
 
@@ -12,9 +14,17 @@ type State struct {
 	ClientID string
 	Expire   time.Time
 	Password string
+	Reports  map[string]PersistedReport `json:",omitempty"`
 	Token    string
 }
 
+// PersistedReport is a report that we have opened and that we may want
+// to reuse, e.g., after the process has been restarted mid-run.
+type PersistedReport struct {
+	Expire time.Time
+	ID     string
+}
+
 // Auth returns an authentication structure, if possible, otherwise
 // it returns nil, meaning that you should login again.
 func (s State) Auth() *LoginAuth {
@@ -39,21 +49,53 @@ func (s State) Credentials() *LoginCredentials {
 	return &LoginCredentials{ClientID: s.ClientID, Password: s.Password}
 }
 
-// StateFile is the orchestra state file. It is backed by
-// a generic key-value store configured by the user.
+// StateFile is the orchestra state file. It is backed by the
+// "orchestra" namespace of a key-value store configured by the user.
 type StateFile struct {
-	Store model.KeyValueStore
-	key   string
+	Store     model.KeyValueStore
+	key       string
+	plaintext model.KeyValueStore
 }
 
 // NewStateFile creates a new state file backed by a key-value store
-func NewStateFile(kvstore model.KeyValueStore) StateFile {
-	return StateFile{key: "orchestra.state", Store: kvstore}
+func NewStateFile(store model.KeyValueStore) StateFile {
+	return StateFile{key: "state", Store: kvstore.WithNamespace(store, "orchestra")}
+}
+
+// NewEncryptedStateFile is like NewStateFile except that we encrypt
+// the state, which contains the orchestra password and token, using
+// key. See kvstore.NewEncryptedKeyValueStore for more information on
+// the key. We encrypt the state saved without encryption when we read it.
+func NewEncryptedStateFile(store model.KeyValueStore, key []byte) (StateFile, error) {
+	encrypted, err := kvstore.NewEncryptedKeyValueStore(store, key)
+	if err != nil {
+		return StateFile{}, err
+	}
+	sf := NewStateFile(encrypted)
+	sf.plaintext = kvstore.WithNamespace(store, "orchestra")
+	return sf, nil
+}
+
+// get reads key from Store. When we cannot decrypt the value because
+// we saved it before enabling encryption, we encrypt it now.
+func (sf StateFile) get(key string) ([]byte, error) {
+	value, err := sf.Store.Get(key)
+	if !errors.Is(err, kvstore.ErrCannotDecrypt) || sf.plaintext == nil {
+		return value, err
+	}
+	value, perr := sf.plaintext.Get(key)
+	if perr != nil || !json.Valid(value) {
+		return nil, err // probably encrypted using another key
+	}
+	if err := sf.Store.Set(key, value); err != nil {
+		return nil, err
+	}
+	return value, nil
 }
 
 // SetMockable is a mockable version of Set
 func (sf StateFile) SetMockable(s State, mf func(interface{}) ([]byte, error)) error {
-	data, err := mf(s)
+	data, err := mf(versionedState{State: s, Version: StateVersion})
 	if err != nil {
 		return err
 	}
@@ -65,23 +107,20 @@ func (sf StateFile) Set(s State) error {
 	return sf.SetMockable(s, json.Marshal)
 }
 
-// GetMockable is a mockable version of Get
+// GetMockable is a mockable version of Get. We upgrade the state written
+// using previous versions of the schema (see StateVersion).
 func (sf StateFile) GetMockable(sfget func(string) ([]byte, error),
 	unmarshal func([]byte, interface{}) error) (State, error) {
 	value, err := sfget(sf.key)
 	if err != nil {
 		return State{}, err
 	}
-	var state State
-	if err := unmarshal(value, &state); err != nil {
-		return State{}, err
-	}
-	return state, nil
+	return migrateState(value, stateMigrations, unmarshal)
 }
 
 // Get returns the current state. In case of any error with the
 // underlying key-value store, we return an empty state.
 func (sf StateFile) Get() (state State) {
-	state, _ = sf.GetMockable(sf.Store.Get, json.Unmarshal)
+	state, _ = sf.GetMockable(sf.get, json.Unmarshal)
 	return
 }
@@ -3,6 +3,7 @@ package probeservices_test
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -151,3 +152,122 @@ func TestStateFileGetUnmarshalError(t *testing.T) {
 		t.Fatal("unexpected Token field")
 	}
 }
+
+func TestStateFileUsesOrchestraNamespace(t *testing.T) {
+	// We must keep using the same key we used before namespaces
+	// otherwise existing probes would need to register again.
+	store := kvstore.NewMemoryKeyValueStore()
+	sf := probeservices.NewStateFile(store)
+	if err := sf.Set(probeservices.State{ClientID: "xx"}); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "orchestra.state" {
+		t.Fatal("unexpected keys", keys)
+	}
+}
+
+func TestEncryptedStateFile(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	key := []byte("0123456789abcdef")
+	sf, err := probeservices.NewEncryptedStateFile(store, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := probeservices.State{ClientID: "xx", Password: "secret", Token: "abc"}
+	if err := sf.Set(s); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.Get("orchestra.state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatal("we did not encrypt the state")
+	}
+	if diff := cmp.Diff(s, sf.Get()); diff != "" {
+		t.Fatal(diff)
+	}
+	if probeservices.NewStateFile(store).Get().Credentials() != nil {
+		t.Fatal("we could read the state without the key")
+	}
+}
+
+func TestEncryptedStateFileMigratesPlaintextState(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	s := probeservices.State{ClientID: "xx", Password: "secret"}
+	if err := probeservices.NewStateFile(store).Set(s); err != nil {
+		t.Fatal(err)
+	}
+	sf, err := probeservices.NewEncryptedStateFile(store, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(s, sf.Get()); diff != "" {
+		t.Fatal(diff)
+	}
+	data, err := store.Get("orchestra.state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatal("we did not encrypt the plaintext state")
+	}
+	other, err := probeservices.NewEncryptedStateFile(store, []byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Get().Credentials() != nil {
+		t.Fatal("we could read the state using another key")
+	}
+}
+
+func TestEncryptedStateFileInvalidKey(t *testing.T) {
+	if _, err := probeservices.NewEncryptedStateFile(
+		kvstore.NewMemoryKeyValueStore(), []byte("short")); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestStateFileReadsUnversionedState(t *testing.T) {
+	// Make sure we can read the state written before we
+	// started versioning the schema of the state.
+	store := kvstore.NewMemoryKeyValueStore()
+	store.Set("orchestra.state", []byte(`{"ClientID":"xx","Password":"xy"}`))
+	sf := probeservices.NewStateFile(store)
+	if creds := sf.Get().Credentials(); creds == nil || creds.ClientID != "xx" {
+		t.Fatal("we could not read the unversioned state")
+	}
+	if err := sf.Set(sf.Get()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.Get("orchestra.state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state struct{ Version int }
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != probeservices.StateVersion {
+		t.Fatal("we did not save the schema version")
+	}
+}
+
+func TestStateFileUnsupportedVersion(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	sf := probeservices.NewStateFile(store)
+	for _, data := range []string{
+		`{"ClientID":"xx","Version":1000}`,
+		`{"ClientID":"xx","Version":"1"}`,
+	} {
+		store.Set("orchestra.state", []byte(data))
+		_, err := sf.GetMockable(sf.Store.Get, json.Unmarshal)
+		if !errors.Is(err, probeservices.ErrUnsupportedStateVersion) {
+			t.Fatal("not the error we expected", err)
+		}
+	}
+}
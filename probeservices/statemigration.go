@@ -0,0 +1,71 @@
+package probeservices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// StateVersion is the version of the schema of the state we write. The
+// state written before we versioned the schema has version zero.
+const StateVersion = 1
+
+// ErrUnsupportedStateVersion indicates that the state has been written
+// by a more recent version of the engine, so we cannot read it.
+var ErrUnsupportedStateVersion = errors.New("probeservices: unsupported state version")
+
+// stateMigration upgrades the state, which we decode as a generic JSON
+// object, so that migrations can see fields not in State anymore.
+type stateMigration func(state map[string]interface{}) error
+
+// stateMigrations contains the migrations. The migration at index N
+// upgrades the state from version N to version N+1. When you change the
+// State schema, append a migration and increment StateVersion.
+var stateMigrations = []stateMigration{
+	// The unversioned state has the same fields of version 1.
+	func(state map[string]interface{}) error { return nil },
+}
+
+// versionedState is the state as we write it into the key-value store.
+type versionedState struct {
+	State
+	Version int `json:",omitempty"`
+}
+
+// migrateState decodes data using unmarshal and applies the migrations
+// required to upgrade the state to len(migrations).
+func migrateState(data []byte, migrations []stateMigration,
+	unmarshal func([]byte, interface{}) error) (State, error) {
+	var generic map[string]interface{}
+	if err := unmarshal(data, &generic); err != nil {
+		return State{}, err
+	}
+	var version int
+	if value, found := generic["Version"]; found {
+		number, ok := value.(float64)
+		if !ok || number < 0 || number != float64(int(number)) {
+			return State{}, fmt.Errorf("%w: %v", ErrUnsupportedStateVersion, value)
+		}
+		version = int(number)
+	}
+	if version > len(migrations) {
+		return State{}, fmt.Errorf("%w: %d", ErrUnsupportedStateVersion, version)
+	}
+	if version < len(migrations) {
+		for _, migrate := range migrations[version:] {
+			if err := migrate(generic); err != nil {
+				return State{}, err
+			}
+		}
+		generic["Version"] = len(migrations)
+		var err error
+		if data, err = json.Marshal(generic); err != nil {
+			return State{}, err
+		}
+	}
+	var state versionedState
+	if err := unmarshal(data, &state); err != nil {
+		return State{}, err
+	}
+	return state.State, nil
+}
@@ -0,0 +1,73 @@
+package probeservices
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestStateMigrationsMatchVersion(t *testing.T) {
+	if len(stateMigrations) != StateVersion {
+		t.Fatal("you should add a migration when changing StateVersion")
+	}
+}
+
+func TestMigrateStateRunsMigrationsInOrder(t *testing.T) {
+	var order []int
+	migrations := []stateMigration{
+		func(state map[string]interface{}) error {
+			order = append(order, 0)
+			return nil
+		},
+		func(state map[string]interface{}) error {
+			order = append(order, 1)
+			state["ClientID"] = state["ClientName"]
+			delete(state, "ClientName")
+			return nil
+		},
+		func(state map[string]interface{}) error {
+			order = append(order, 2)
+			state["Token"] = "re-derived"
+			return nil
+		},
+	}
+	data := []byte(`{"ClientName":"xx","Version":1}`)
+	state, err := migrateState(data, migrations, json.Unmarshal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Fatal("unexpected migrations order", order)
+	}
+	if state.ClientID != "xx" || state.Token != "re-derived" {
+		t.Fatal("we did not migrate the state", state)
+	}
+}
+
+func TestMigrateStateMigrationError(t *testing.T) {
+	expected := errors.New("mocked error")
+	migrations := []stateMigration{
+		func(state map[string]interface{}) error {
+			return expected
+		},
+	}
+	if _, err := migrateState([]byte(`{}`), migrations, json.Unmarshal); !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestMigrateStateCurrentVersion(t *testing.T) {
+	migrations := []stateMigration{
+		func(state map[string]interface{}) error {
+			t.Fatal("we should not run this migration")
+			return nil
+		},
+	}
+	state, err := migrateState([]byte(`{"ClientID":"xx","Version":1}`), migrations, json.Unmarshal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ClientID != "xx" {
+		t.Fatal("unexpected state")
+	}
+}
@@ -0,0 +1,319 @@
+package probeservices
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+)
+
+// DefaultMaxQueueSize is the default maximum size in bytes of the
+// measurements queued by a Submitter.
+const DefaultMaxQueueSize = 16 << 20
+
+// ErrMeasurementQueued indicates that we could not submit a measurement
+// and we have queued it for submitting it later using Submitter.Flush.
+var ErrMeasurementQueued = errors.New(
+	"probe services: measurement queued for later submission",
+)
+
+// claimLease is how long a Flush may keep claimed a queued measurement
+// that it is submitting. After that, we assume that the process that had
+// claimed the measurement died, so another Flush can claim it again.
+const claimLease = 10 * time.Minute
+
+// errNotQueued indicates that a measurement is not queued anymore, or
+// that another Flush has claimed it, so we should skip it.
+var errNotQueued = errors.New("probe services: measurement not queued")
+
+// errBrokenEntry indicates that we cannot parse a queued measurement.
+var errBrokenEntry = errors.New("probe services: broken queued measurement")
+
+// queuedMeasurement is a measurement waiting to be submitted. ClaimedUntil
+// is set while a Flush, possibly in another process, is submitting it.
+type queuedMeasurement struct {
+	ClaimedUntil time.Time `json:",omitempty"`
+	Measurement  json.RawMessage
+	ReportID     string
+}
+
+// Submitter submits measurements. When we cannot submit a measurement
+// because, e.g., the collector is unreachable, the Submitter saves it into
+// the key-value store, so that we can submit it later, possibly during
+// another session, by calling Flush. We save each measurement into its own
+// key, whose name sorts by queueing time and contains the size of the
+// measurement, and we enumerate the queue by listing the keys. The queue
+// is bounded by MaxQueueSize and, when it is full, we evict the oldest
+// measurements first. We claim each measurement atomically before
+// submitting it if the store is a kvstore.KeyUpdater, so that several
+// processes sharing the same store do not submit it twice.
+type Submitter struct {
+	// MaxQueueSize is the maximum size in bytes of the queue. You
+	// should not modify this field after you started using the
+	// Submitter. NewSubmitter sets it to DefaultMaxQueueSize.
+	MaxQueueSize int
+
+	logger model.Logger
+	mu     sync.Mutex
+	prefix string
+	store  model.KeyValueStore
+}
+
+// NewSubmitter creates a new Submitter that saves the queue of
+// measurements into the "probeservices" namespace of store.
+func NewSubmitter(store model.KeyValueStore, logger model.Logger) *Submitter {
+	return &Submitter{
+		MaxQueueSize: DefaultMaxQueueSize,
+		logger:       logger,
+		prefix:       "queue.",
+		store:        kvstore.WithNamespace(store, "probeservices"),
+	}
+}
+
+// Submit submits m as part of r. If the collector rejects m, we return
+// the error. If we otherwise fail to submit m, e.g., because the collector
+// is unreachable, we queue m and return ErrMeasurementQueued.
+func (s *Submitter) Submit(ctx context.Context, r *Report, m *model.Measurement) error {
+	err := r.SubmitMeasurement(ctx, m)
+	if err == nil || isRejected(err) {
+		return err
+	}
+	s.logger.Debugf("submitter.go: cannot submit measurement: %s", err.Error())
+	var buf bytes.Buffer
+	if marshalErr := m.WriteJSON(&buf); marshalErr != nil {
+		return marshalErr
+	}
+	data := buf.Bytes()
+	if len(data) > s.MaxQueueSize {
+		return err // we cannot queue it anyway
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, err := s.newKey(len(data))
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(queuedMeasurement{Measurement: data, ReportID: r.ID})
+	if err != nil {
+		return err
+	}
+	if err := s.store.Set(key, value); err != nil {
+		return err
+	}
+	if err := s.evict(); err != nil {
+		return err
+	}
+	return ErrMeasurementQueued
+}
+
+// Flush tries to submit the queued measurements using c. Because the
+// report to which we originally tried to submit a measurement may have
+// been closed in the meanwhile, we open a new report for each template
+// (see NewReportTemplate) and we submit the measurements there. We stop at
+// the first measurement that we cannot submit, unless the collector rejects
+// such measurement, in which case we discard it. We skip the measurements
+// that another Flush has claimed. Returns the number of measurements that
+// we submitted and the error that occurred, if any.
+func (s *Submitter) Flush(ctx context.Context, c Client) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int
+	reports := make(map[string]*Report)
+	defer func() {
+		for _, report := range reports {
+			if err := report.Close(ctx); err != nil {
+				s.logger.Debugf("submitter.go: cannot close report: %s", err.Error())
+			}
+		}
+	}()
+	for {
+		key, entry, found, err := s.claim()
+		if err != nil || !found {
+			return count, err
+		}
+		var m model.Measurement
+		if json.Unmarshal(entry.Measurement, &m) != nil {
+			if err := s.store.Delete(key); err != nil {
+				return count, err
+			}
+			continue // discard broken entry
+		}
+		report, err := s.openReport(ctx, c, reports, &m)
+		if err == nil {
+			err = report.SubmitMeasurement(ctx, &m)
+		}
+		if err != nil && !isRejected(err) {
+			if releaseErr := s.release(key, entry); releaseErr != nil {
+				s.logger.Debugf("submitter.go: cannot release measurement: %s",
+					releaseErr.Error())
+			}
+			return count, err
+		}
+		if err != nil {
+			s.logger.Warnf("submitter.go: discarding measurement: %s", err.Error())
+		} else {
+			count++
+		}
+		// we own the claim for claimLease, so nobody else is using the key
+		if err := s.store.Delete(key); err != nil {
+			return count, err
+		}
+	}
+}
+
+// claim atomically claims the oldest queued measurement that nobody has
+// claimed, or whose claim has expired. The boolean is false if there is
+// no such measurement.
+func (s *Submitter) claim() (key string, entry queuedMeasurement, found bool, err error) {
+	keys, err := s.store.List(s.prefix)
+	if err != nil {
+		return "", entry, false, err
+	}
+	for _, key = range keys {
+		err = kvstore.UpdateKey(s.store, key, func(data []byte, err error) ([]byte, error) {
+			if err != nil {
+				return nil, errNotQueued // e.g., another Flush has removed it
+			}
+			var current queuedMeasurement
+			if json.Unmarshal(data, &current) != nil {
+				return nil, errBrokenEntry
+			}
+			now := time.Now()
+			if now.Before(current.ClaimedUntil) {
+				return nil, errNotQueued // another Flush is submitting it
+			}
+			current.ClaimedUntil = now.Add(claimLease)
+			entry = current
+			return json.Marshal(current)
+		})
+		if errors.Is(err, errNotQueued) {
+			continue
+		}
+		if errors.Is(err, errBrokenEntry) {
+			if err := s.store.Delete(key); err != nil {
+				return "", entry, false, err
+			}
+			continue // discard broken entry
+		}
+		if err != nil {
+			return "", entry, false, err
+		}
+		return key, entry, true, nil
+	}
+	return "", entry, false, nil
+}
+
+// release makes a measurement that we have claimed available again.
+func (s *Submitter) release(key string, entry queuedMeasurement) error {
+	err := kvstore.UpdateKey(s.store, key, func(data []byte, err error) ([]byte, error) {
+		var current queuedMeasurement
+		if err != nil || json.Unmarshal(data, &current) != nil ||
+			!current.ClaimedUntil.Equal(entry.ClaimedUntil) {
+			return nil, errNotQueued // e.g., our claim expired
+		}
+		current.ClaimedUntil = time.Time{}
+		return json.Marshal(current)
+	})
+	if errors.Is(err, errNotQueued) {
+		err = nil
+	}
+	return err
+}
+
+// openReport returns the report to use for m, which we open unless
+// we have already opened a report with the same template.
+func (s *Submitter) openReport(ctx context.Context, c Client,
+	reports map[string]*Report, m *model.Measurement) (*Report, error) {
+	template := NewReportTemplate(m)
+	key := reportKey(template)
+	if report, found := reports[key]; found {
+		return report, nil
+	}
+	report, err := c.OpenReport(ctx, template)
+	if err != nil {
+		return nil, err
+	}
+	reports[key] = report
+	return report, nil
+}
+
+// newKey returns a new key for a measurement of the given size. The keys
+// sort by time and contain a random part to avoid collisions with the
+// keys created by other processes sharing the same store.
+func (s *Submitter) newKey(size int) (string, error) {
+	var random [4]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%020d.%x.%d", s.prefix, time.Now().UnixNano(),
+		random[:], size), nil
+}
+
+// keySize returns the size of the measurement saved at key.
+func (s *Submitter) keySize(key string) int {
+	v := strings.Split(strings.TrimPrefix(key, s.prefix), ".")
+	if len(v) != 3 {
+		return 0
+	}
+	size, _ := strconv.Atoi(v[2])
+	return size
+}
+
+// evict removes the oldest measurements if the queue is too large.
+func (s *Submitter) evict() error {
+	keys, err := s.store.List(s.prefix)
+	if err != nil {
+		return err
+	}
+	var total int
+	for _, key := range keys {
+		total += s.keySize(key)
+	}
+	for len(keys) > 0 && total > s.MaxQueueSize {
+		s.logger.Warn("submitter.go: queue is full; evicting oldest measurement")
+		if err := s.store.Delete(keys[0]); err != nil {
+			return err
+		}
+		total -= s.keySize(keys[0])
+		keys = keys[1:]
+	}
+	return nil
+}
+
+// Len returns the number of queued measurements.
+func (s *Submitter) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, _ := s.store.List(s.prefix)
+	return len(keys)
+}
+
+// isRejected returns whether err means that the collector has received
+// and rejected the measurement because the request itself was invalid, so
+// it does not make sense to retry. Other client errors (e.g., 404 because
+// the report has been closed, or 429 because we are rate limited) do not
+// depend on the measurement, so we can try again later.
+func isRejected(err error) bool {
+	var failed *httpx.RequestFailedError
+	if !errors.As(err, &failed) {
+		return false
+	}
+	switch failed.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge,
+		http.StatusUnprocessableEntity:
+		return true
+	default:
+		return false
+	}
+}
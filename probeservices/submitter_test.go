@@ -0,0 +1,378 @@
+package probeservices_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+type submitterTestServer struct {
+	Closed    []string
+	Opened    int
+	Status    int
+	Submitted int
+	Targets   []string
+}
+
+func (sts *submitterTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.RequestURI == "/report" {
+		sts.Opened++
+		fmt.Fprintf(w, `{"report_id":"_id%d","supported_formats":["json"]}`, sts.Opened)
+		return
+	}
+	if !strings.HasPrefix(r.RequestURI, "/report/") {
+		panic(r.RequestURI)
+	}
+	if strings.HasSuffix(r.RequestURI, "/close") {
+		sts.Closed = append(sts.Closed, r.RequestURI)
+		w.Write([]byte(`{}`))
+		return
+	}
+	sts.Targets = append(sts.Targets, r.RequestURI)
+	if sts.Status != 0 {
+		w.WriteHeader(sts.Status)
+		return
+	}
+	sts.Submitted++
+	w.Write([]byte(`{"measurement_id":"e00c584e6e9e5326"}`))
+}
+
+func newSubmitterTest(t *testing.T) (
+	*submitterTestServer, *httptest.Server, *probeservices.Client, *probeservices.Report) {
+	sts := &submitterTestServer{}
+	server := httptest.NewServer(sts)
+	client := newclient()
+	client.BaseURL = server.URL
+	client.RetryPolicy = nil // make tests faster
+	report, err := client.OpenReport(context.Background(), newBatchTemplate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sts, server, client, report
+}
+
+func TestSubmitterSubmitSuccess(t *testing.T) {
+	sts, server, _, report := newSubmitterTest(t)
+	defer server.Close()
+	submitter := probeservices.NewSubmitter(kvstore.NewMemoryKeyValueStore(), log.Log)
+	measurement := makeMeasurement(newBatchTemplate(), report.ID)
+	if err := submitter.Submit(context.Background(), report, &measurement); err != nil {
+		t.Fatal(err)
+	}
+	if sts.Submitted != 1 || submitter.Len() != 0 {
+		t.Fatal("unexpected state")
+	}
+}
+
+func TestSubmitterSubmitRejected(t *testing.T) {
+	sts, server, _, report := newSubmitterTest(t)
+	defer server.Close()
+	sts.Status = 400
+	submitter := probeservices.NewSubmitter(kvstore.NewMemoryKeyValueStore(), log.Log)
+	measurement := makeMeasurement(newBatchTemplate(), report.ID)
+	err := submitter.Submit(context.Background(), report, &measurement)
+	if err == nil || !strings.HasSuffix(err.Error(), "400 Bad Request") {
+		t.Fatal("not the error we expected")
+	}
+	if submitter.Len() != 0 {
+		t.Fatal("should not have queued the measurement")
+	}
+}
+
+func TestSubmitterQueueAndFlush(t *testing.T) {
+	sts, server, client, report := newSubmitterTest(t)
+	defer server.Close()
+	store := kvstore.NewMemoryKeyValueStore()
+	submitter := probeservices.NewSubmitter(store, log.Log)
+	sts.Status = 502
+	for i := 0; i < 3; i++ {
+		measurement := makeMeasurement(newBatchTemplate(), report.ID)
+		err := submitter.Submit(context.Background(), report, &measurement)
+		if !errors.Is(err, probeservices.ErrMeasurementQueued) {
+			t.Fatal("not the error we expected")
+		}
+	}
+	if submitter.Len() != 3 {
+		t.Fatal("unexpected queue length")
+	}
+	count, err := submitter.Flush(context.Background(), *client)
+	if err == nil || count != 0 || submitter.Len() != 3 {
+		t.Fatal("should have stopped at the first failure")
+	}
+	sts.Status = 0
+	// Use a new submitter with the same store to emulate a new session.
+	submitter = probeservices.NewSubmitter(store, log.Log)
+	count, err = submitter.Flush(context.Background(), *client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || sts.Submitted != 3 || submitter.Len() != 0 {
+		t.Fatal("unexpected state after flush")
+	}
+}
+
+func TestSubmitterFlushDiscardsRejected(t *testing.T) {
+	sts, server, client, report := newSubmitterTest(t)
+	defer server.Close()
+	submitter := probeservices.NewSubmitter(kvstore.NewMemoryKeyValueStore(), log.Log)
+	sts.Status = 503
+	measurement := makeMeasurement(newBatchTemplate(), report.ID)
+	submitter.Submit(context.Background(), report, &measurement)
+	sts.Status = 400
+	count, err := submitter.Flush(context.Background(), *client)
+	if err != nil || count != 0 || submitter.Len() != 0 {
+		t.Fatal("should have discarded the measurement")
+	}
+}
+
+func TestSubmitterFlushKeepsOnOtherClientErrors(t *testing.T) {
+	sts, server, client, report := newSubmitterTest(t)
+	defer server.Close()
+	submitter := probeservices.NewSubmitter(kvstore.NewMemoryKeyValueStore(), log.Log)
+	sts.Status = 404 // e.g., the report has been closed
+	measurement := makeMeasurement(newBatchTemplate(), report.ID)
+	err := submitter.Submit(context.Background(), report, &measurement)
+	if !errors.Is(err, probeservices.ErrMeasurementQueued) {
+		t.Fatal("not the error we expected", err)
+	}
+	count, err := submitter.Flush(context.Background(), *client)
+	if err == nil || count != 0 || submitter.Len() != 1 {
+		t.Fatal("should have kept the measurement")
+	}
+}
+
+func TestSubmitterFlushUsesNewReports(t *testing.T) {
+	sts, server, client, report := newSubmitterTest(t)
+	defer server.Close()
+	submitter := probeservices.NewSubmitter(kvstore.NewMemoryKeyValueStore(), log.Log)
+	sts.Status = 502
+	other := newBatchTemplate()
+	other.TestName = "antani"
+	for _, template := range []probeservices.ReportTemplate{
+		newBatchTemplate(), other, newBatchTemplate(),
+	} {
+		measurement := makeMeasurement(template, report.ID)
+		submitter.Submit(context.Background(), report, &measurement)
+	}
+	sts.Status, sts.Targets = 0, nil
+	count, err := submitter.Flush(context.Background(), *client)
+	if err != nil || count != 3 {
+		t.Fatal("unexpected result", count, err)
+	}
+	expected := []string{"/report/_id2", "/report/_id3", "/report/_id2"}
+	if diff := cmp.Diff(expected, sts.Targets); diff != "" {
+		t.Fatal(diff)
+	}
+	if len(sts.Closed) != 2 {
+		t.Fatal("we did not close the reports we opened")
+	}
+}
+
+func TestSubmitterEvictsOldest(t *testing.T) {
+	sts, server, _, report := newSubmitterTest(t)
+	defer server.Close()
+	submitter := probeservices.NewSubmitter(kvstore.NewMemoryKeyValueStore(), log.Log)
+	measurement := makeMeasurement(newBatchTemplate(), report.ID)
+	data, _ := json.Marshal(measurement)
+	submitter.MaxQueueSize = len(data) + 1 // room for just one measurement
+	sts.Status = 500
+	for i := 0; i < 3; i++ {
+		err := submitter.Submit(context.Background(), report, &measurement)
+		if !errors.Is(err, probeservices.ErrMeasurementQueued) {
+			t.Fatal("not the error we expected")
+		}
+	}
+	if submitter.Len() != 1 {
+		t.Fatal("should have evicted older measurements")
+	}
+	submitter.MaxQueueSize = 10 // too small for any measurement
+	err := submitter.Submit(context.Background(), report, &measurement)
+	if err == nil || !strings.HasSuffix(err.Error(), "500 Internal Server Error") {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestSubmitterQueuesWhenRateLimited(t *testing.T) {
+	sts, server, _, report := newSubmitterTest(t)
+	defer server.Close()
+	submitter := probeservices.NewSubmitter(kvstore.NewMemoryKeyValueStore(), log.Log)
+	sts.Status = 429
+	measurement := makeMeasurement(newBatchTemplate(), report.ID)
+	err := submitter.Submit(context.Background(), report, &measurement)
+	if !errors.Is(err, probeservices.ErrMeasurementQueued) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+// racingStore emulates another process that updates the queue right
+// before we first update it during Flush.
+type racingStore struct {
+	*kvstore.MemoryKeyValueStore
+	onUpdate func()
+}
+
+func (rs *racingStore) UpdateKey(
+	key string, fn func(value []byte, err error) ([]byte, error)) error {
+	if onUpdate := rs.onUpdate; onUpdate != nil {
+		rs.onUpdate = nil
+		onUpdate()
+	}
+	return rs.MemoryKeyValueStore.UpdateKey(key, fn)
+}
+
+func TestSubmitterFlushKeepsMeasurementsQueuedByOthers(t *testing.T) {
+	sts, server, client, report := newSubmitterTest(t)
+	defer server.Close()
+	store := &racingStore{MemoryKeyValueStore: kvstore.NewMemoryKeyValueStore()}
+	submitter := probeservices.NewSubmitter(store, log.Log)
+	sts.Status = 502
+	for i := 0; i < 2; i++ {
+		measurement := makeMeasurement(newBatchTemplate(), report.ID)
+		submitter.Submit(context.Background(), report, &measurement)
+	}
+	sts.Status = 0
+	store.onUpdate = func() {
+		sts.Status = 502
+		other := probeservices.NewSubmitter(store, log.Log)
+		measurement := makeMeasurement(newBatchTemplate(), report.ID)
+		measurement.Input = "https://www.example.com/"
+		if err := other.Submit(context.Background(), report, &measurement); !errors.Is(
+			err, probeservices.ErrMeasurementQueued) {
+			t.Fatal("not the error we expected")
+		}
+		sts.Status = 0
+	}
+	count, err := submitter.Flush(context.Background(), *client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || submitter.Len() != 0 {
+		t.Fatal("we lost the measurement queued by the other submitter")
+	}
+}
+
+func TestSubmitterConcurrentFlushesSubmitOnce(t *testing.T) {
+	sts, server, client, report := newSubmitterTest(t)
+	defer server.Close()
+	store := &racingStore{MemoryKeyValueStore: kvstore.NewMemoryKeyValueStore()}
+	submitter := probeservices.NewSubmitter(store, log.Log)
+	sts.Status = 502
+	for i := 0; i < 2; i++ {
+		measurement := makeMeasurement(newBatchTemplate(), report.ID)
+		submitter.Submit(context.Background(), report, &measurement)
+	}
+	sts.Status = 0
+	var otherCount int
+	store.onUpdate = func() {
+		other := probeservices.NewSubmitter(store, log.Log)
+		count, err := other.Flush(context.Background(), *client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherCount = count
+	}
+	count, err := submitter.Flush(context.Background(), *client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || otherCount != 2 || sts.Submitted != 2 || submitter.Len() != 0 {
+		t.Fatal("unexpected state")
+	}
+}
+
+func TestSubmitterFlushSkipsClaimedMeasurements(t *testing.T) {
+	// queue queues a measurement and sets its claim
+	queue := func(t *testing.T, claimedUntil time.Time) (*submitterTestServer,
+		*httptest.Server, *probeservices.Client, *probeservices.Submitter) {
+		sts, server, client, report := newSubmitterTest(t)
+		store := kvstore.NewMemoryKeyValueStore()
+		submitter := probeservices.NewSubmitter(store, log.Log)
+		sts.Status = 502
+		measurement := makeMeasurement(newBatchTemplate(), report.ID)
+		submitter.Submit(context.Background(), report, &measurement)
+		sts.Status, sts.Submitted = 0, 0
+		keys, err := store.List("probeservices.queue.")
+		if err != nil || len(keys) != 1 {
+			t.Fatal("unexpected keys", keys, err)
+		}
+		data, err := store.Get(keys[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entry["ClaimedUntil"] = claimedUntil
+		if data, err = json.Marshal(entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(keys[0], data); err != nil {
+			t.Fatal(err)
+		}
+		return sts, server, client, submitter
+	}
+	t.Run("when the claim is still valid", func(t *testing.T) {
+		sts, server, client, submitter := queue(t, time.Now().Add(time.Hour))
+		defer server.Close()
+		count, err := submitter.Flush(context.Background(), *client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 || sts.Submitted != 0 || submitter.Len() != 1 {
+			t.Fatal("unexpected state")
+		}
+	})
+	t.Run("when the claim has expired", func(t *testing.T) {
+		sts, server, client, submitter := queue(t, time.Now().Add(-time.Hour))
+		defer server.Close()
+		count, err := submitter.Flush(context.Background(), *client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 || sts.Submitted != 1 || submitter.Len() != 0 {
+			t.Fatal("unexpected state")
+		}
+	})
+}
+
+func TestSubmitterQueuesEachMeasurementIntoItsOwnKey(t *testing.T) {
+	sts, server, client, report := newSubmitterTest(t)
+	defer server.Close()
+	store := kvstore.NewMemoryKeyValueStore()
+	submitter := probeservices.NewSubmitter(store, log.Log)
+	sts.Status = 502
+	for _, input := range []string{"a", "b", "c"} {
+		measurement := makeMeasurement(newBatchTemplate(), report.ID)
+		measurement.Input = model.MeasurementTarget(input)
+		submitter.Submit(context.Background(), report, &measurement)
+	}
+	keys, err := store.List("probeservices.queue.")
+	if err != nil || len(keys) != 3 {
+		t.Fatal("unexpected keys", keys, err)
+	}
+	// a broken entry should not prevent us from flushing the others
+	if err := store.Set(keys[1], []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	sts.Status, sts.Submitted = 0, 0
+	count, err := submitter.Flush(context.Background(), *client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || sts.Submitted != 2 || submitter.Len() != 0 {
+		t.Fatal("unexpected state")
+	}
+}
@@ -2,15 +2,19 @@ package probeservices
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
 
 	"github.com/ooni/probe-engine/model"
 )
 
-// FetchTorTargets returns the targets for the tor experiment.
+// FetchTorTargets returns the targets for the tor experiment. We use
+// c.Auth to make sure we do not use an expired token. We cache the
+// targets in the key-value store and use their ETag to avoid downloading
+// them again when they did not change.
 func (c Client) FetchTorTargets(ctx context.Context, cc string) (result map[string]model.TorTarget, err error) {
-	_, auth, err := c.GetCredsAndAuth()
+	auth, err := c.Auth(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -18,7 +22,13 @@ func (c Client) FetchTorTargets(ctx context.Context, cc string) (result map[stri
 	client.Authorization = fmt.Sprintf("Bearer %s", auth.Token)
 	query := url.Values{}
 	query.Add("country_code", cc)
-	err = client.GetJSONWithQuery(
-		ctx, "/api/v1/test-list/tor-targets", query, &result)
-	return
+	data, err := c.fetchCachedResource(
+		ctx, client, "tor-targets."+cc, "/api/v1/test-list/tor-targets", query)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
@@ -0,0 +1,58 @@
+package probeservices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMissingMeasurementID indicates that we cannot update a
+	// measurement because we don't know its ID.
+	ErrMissingMeasurementID = errors.New("probe services: missing measurement ID")
+
+	// ErrNoAnnotations indicates that we have been asked to update
+	// a measurement without providing any annotation.
+	ErrNoAnnotations = errors.New("probe services: no annotations")
+)
+
+type measurementUpdateRequest struct {
+	// Annotations contains the annotations to attach
+	Annotations map[string]string `json:"annotations"`
+}
+
+// CloseReport closes the report with the specified ID. This is useful
+// to close a report that we opened, e.g., in a previous session.
+func (c Client) CloseReport(ctx context.Context, reportID string) error {
+	return Report{ID: reportID, client: c}.Close(ctx)
+}
+
+// UpdateMeasurement attaches annotations to a measurement we have already
+// submitted, e.g., feedback from the user saying that a website is actually
+// blocked. The measurementID argument is the ID returned by the collector,
+// i.e., the OOID field of the measurement. We need to be registered with
+// the orchestra backend to update measurements.
+func (c Client) UpdateMeasurement(
+	ctx context.Context, measurementID string, annotations map[string]string) error {
+	if measurementID == "" {
+		return ErrMissingMeasurementID
+	}
+	if len(annotations) <= 0 {
+		return ErrNoAnnotations
+	}
+	auth, err := c.Auth(ctx)
+	if err != nil {
+		return err
+	}
+	client := c.Client
+	client.Authorization = fmt.Sprintf("Bearer %s", auth.Token)
+	var output struct{}
+	resourcePath := fmt.Sprintf("/api/v1/measurement/%s", measurementID)
+	err = client.PutJSON(ctx, resourcePath,
+		measurementUpdateRequest{Annotations: annotations}, &output)
+	err = newAPIError(err, resourcePath)
+	if isEmptyJSONError(err) {
+		err = nil // see the comment in Report.Close
+	}
+	return err
+}
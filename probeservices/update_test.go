@@ -0,0 +1,97 @@
+package probeservices_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+func TestCloseReport(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/report/_id/close" {
+				w.WriteHeader(404)
+				return
+			}
+			called = true // the collector returns an empty body
+		}))
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	if err := client.CloseReport(context.Background(), "_id"); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("did not close the report")
+	}
+}
+
+func TestUpdateMeasurementSuccess(t *testing.T) {
+	var annotations map[string]string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/login" {
+				json.NewEncoder(w).Encode(probeservices.LoginAuth{
+					Expire: time.Now().Add(time.Hour),
+					Token:  "fresh-token",
+				})
+				return
+			}
+			if r.Method != "PUT" || r.URL.Path != "/api/v1/measurement/e00c584e6e9e5326" {
+				w.WriteHeader(404)
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer fresh-token" {
+				w.WriteHeader(401)
+				return
+			}
+			var req struct {
+				Annotations map[string]string `json:"annotations"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			annotations = req.Annotations
+			w.Write([]byte(`{}`))
+		}))
+	defer server.Close()
+	client := newLoggedInClient(server.URL, -time.Minute)
+	err := client.UpdateMeasurement(context.Background(), "e00c584e6e9e5326",
+		map[string]string{"user_feedback": "blocked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotations["user_feedback"] != "blocked" {
+		t.Fatal("did not send the annotations")
+	}
+}
+
+func TestUpdateMeasurementInvalidArguments(t *testing.T) {
+	client := newclient()
+	ctx := context.Background()
+	err := client.UpdateMeasurement(ctx, "", map[string]string{"a": "b"})
+	if !errors.Is(err, probeservices.ErrMissingMeasurementID) {
+		t.Fatal("not the error we expected")
+	}
+	err = client.UpdateMeasurement(ctx, "e00c584e6e9e5326", nil)
+	if !errors.Is(err, probeservices.ErrNoAnnotations) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUpdateMeasurementNotRegistered(t *testing.T) {
+	client := newclient()
+	err := client.UpdateMeasurement(context.Background(), "e00c584e6e9e5326",
+		map[string]string{"a": "b"})
+	if !errors.Is(err, probeservices.ErrNotRegistered) {
+		t.Fatal("not the error we expected")
+	}
+}
@@ -17,8 +17,10 @@ type urlListResult struct {
 
 // FetchURLList fetches the list of URLs used by WebConnectivity. The config
 // argument contains the optional settings. Returns the list of URLs, on success,
-// or an explanatory error, in case of failure.
-func (c Client) FetchURLList(ctx context.Context, config model.URLListConfig) ([]model.URLInfo, error) {
+// or an explanatory error, in case of failure. To page through the whole list,
+// set config.Limit and increment config.Offset by the number of URLs that have
+// been returned, until the returned list is shorter than config.Limit.
+func (c Client) FetchURLList(ctx context.Context, config model.FetchURLListConfig) ([]model.URLInfo, error) {
 	query := url.Values{}
 	if config.CountryCode != "" {
 		query.Set("country_code", config.CountryCode)
@@ -26,13 +28,16 @@ func (c Client) FetchURLList(ctx context.Context, config model.URLListConfig) ([
 	if config.Limit > 0 {
 		query.Set("limit", fmt.Sprintf("%d", config.Limit))
 	}
+	if config.Offset > 0 {
+		query.Set("offset", fmt.Sprintf("%d", config.Offset))
+	}
 	if len(config.Categories) > 0 {
 		query.Set("category_codes", strings.Join(config.Categories, ","))
 	}
 	var response urlListResult
 	err := c.Client.GetJSONWithQuery(ctx, "/api/v1/test-list/urls", query, &response)
 	if err != nil {
-		return nil, err
+		return nil, newAPIError(err, "/api/v1/test-list/urls")
 	}
 	return response.Results, nil
 }
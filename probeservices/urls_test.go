@@ -3,6 +3,7 @@ package probeservices_test
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -52,6 +53,36 @@ func TestFetchURLListFailure(t *testing.T) {
 	}
 }
 
+func TestFetchURLListQueryString(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if query.Get("category_codes") != "NEWS,HUMR" ||
+				query.Get("country_code") != "IT" ||
+				query.Get("limit") != "10" || query.Get("offset") != "20" {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{"results":[{"category_code":"NEWS","url":"https://x.org/"}]}`))
+		}))
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	config := model.FetchURLListConfig{
+		Categories:  []string{"NEWS", "HUMR"},
+		CountryCode: "IT",
+		Limit:       10,
+		Offset:      20,
+	}
+	result, err := client.FetchURLList(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].URL != "https://x.org/" {
+		t.Fatal("unexpected result")
+	}
+}
+
 func TestURLsSuccess(t *testing.T) {
 	config := probeservices.URLsConfig{
 		BaseURL:           "https://ps1.ooni.io",
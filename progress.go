@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"sync"
+)
+
+// runtimesKey is the key-value store key where we keep the average
+// runtime of measurements by experiment and input type.
+const runtimesKey = "runtimes.state"
+
+// maxRuntimeSamples is the maximum number of samples we weigh when
+// updating an average runtime, so that recent runs count more.
+const maxRuntimeSamples = 20
+
+type runtimeStats struct {
+	Average float64 `json:"average"`
+	Count   int64   `json:"count"`
+}
+
+// inputType returns the type of input that we use to group runtimes, which
+// is "none" for experiments without input, the URL scheme for URLs,
+// "endpoint" for host:port inputs, and "domain" otherwise.
+func inputType(input string) string {
+	if input == "" {
+		return "none"
+	}
+	if URL, err := url.Parse(input); err == nil && URL.Scheme != "" && URL.Host != "" {
+		return URL.Scheme
+	}
+	if _, _, err := net.SplitHostPort(input); err == nil {
+		return "endpoint"
+	}
+	return "domain"
+}
+
+func runtimesStatsKey(testName, input string) string {
+	return testName + "/" + inputType(input)
+}
+
+// loadRuntimes returns the historical runtimes. We start over if
+// we cannot read them, because they are just a best effort.
+func (s *Session) loadRuntimes() map[string]runtimeStats {
+	runtimes := make(map[string]runtimeStats)
+	if data, err := s.kvStore.Get(runtimesKey); err == nil {
+		json.Unmarshal(data, &runtimes)
+	}
+	return runtimes
+}
+
+// recordRuntime updates the average runtime of the measurements of the
+// given experiment with an input having the same type as input.
+func (s *Session) recordRuntime(testName, input string, runtime float64) {
+	s.runtimesMu.Lock()
+	defer s.runtimesMu.Unlock()
+	runtimes := s.loadRuntimes()
+	key := runtimesStatsKey(testName, input)
+	stats := runtimes[key]
+	if stats.Count < maxRuntimeSamples {
+		stats.Count++
+	}
+	stats.Average += (runtime - stats.Average) / float64(stats.Count)
+	runtimes[key] = stats
+	data, err := json.Marshal(runtimes)
+	if err != nil {
+		return
+	}
+	if err := s.kvStore.Set(runtimesKey, data); err != nil {
+		s.logger.Warnf("session: cannot save runtimes: %s", err.Error())
+	}
+}
+
+// ProgressEstimator estimates the progress of running an experiment with
+// several inputs using the historical runtimes of the experiment by input
+// type. With no history, it weighs all the inputs equally.
+type ProgressEstimator struct {
+	current  int
+	expected []float64
+	mu       sync.Mutex
+	offsets  []float64
+	total    float64
+}
+
+// NewProgressEstimator returns a ProgressEstimator for running the
+// experiment called testName with the given inputs.
+func (s *Session) NewProgressEstimator(testName string, inputs []string) *ProgressEstimator {
+	s.runtimesMu.Lock()
+	runtimes := s.loadRuntimes()
+	s.runtimesMu.Unlock()
+	pe := &ProgressEstimator{}
+	var known, sum float64
+	for _, input := range inputs {
+		stats := runtimes[runtimesStatsKey(testName, input)]
+		pe.expected = append(pe.expected, stats.Average)
+		if stats.Count > 0 && stats.Average > 0 {
+			known, sum = known+1, sum+stats.Average
+		}
+	}
+	fallback := 1.0 // no history: same weight for all inputs
+	if known > 0 {
+		fallback = sum / known
+	}
+	for idx, expected := range pe.expected {
+		if expected <= 0 {
+			expected = fallback
+			pe.expected[idx] = expected
+		}
+		pe.offsets = append(pe.offsets, pe.total)
+		pe.total += expected
+	}
+	return pe
+}
+
+// SetIndex tells the estimator that we are now measuring the input
+// with the given index, which must be a valid index.
+func (pe *ProgressEstimator) SetIndex(idx int) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	pe.current = idx
+}
+
+// Progress returns the overall progress, between zero and one, given
+// the progress, between zero and one, of the current input.
+func (pe *ProgressEstimator) Progress(percentage float64) float64 {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	if pe.total <= 0 || pe.current >= len(pe.offsets) {
+		return percentage
+	}
+	if percentage < 0 {
+		percentage = 0
+	}
+	if percentage > 1 {
+		percentage = 1
+	}
+	done := pe.offsets[pe.current] + percentage*pe.expected[pe.current]
+	return done / pe.total
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInputType(t *testing.T) {
+	inputs := map[string]string{
+		"":                          "none",
+		"https://www.example.com/":  "https",
+		"http://www.example.com/":   "http",
+		"dot://1.1.1.1:853/":        "dot",
+		"www.example.com:443":       "endpoint",
+		"www.example.com":           "domain",
+		"[2001:db8::1]:443":         "endpoint",
+		"https://[2001:db8::1]:443": "https",
+	}
+	for input, expected := range inputs {
+		if got := inputType(input); got != expected {
+			t.Fatalf("%s: expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestProgressEstimator(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	inputs := []string{"http://a.example.com/", "https://b.example.com/", "https://c.example.com/"}
+	pe := sess.NewProgressEstimator("web_connectivity", inputs)
+	pe.SetIndex(1)
+	if progress := pe.Progress(0.5); math.Abs(progress-0.5) > 1e-09 {
+		t.Fatal("without history we should be linear", progress)
+	}
+	for i := 0; i < 3; i++ {
+		sess.recordRuntime("web_connectivity", "http://x.example.com/", 8)
+		sess.recordRuntime("web_connectivity", "https://x.example.com/", 1)
+	}
+	pe = sess.NewProgressEstimator("web_connectivity", inputs)
+	pe.SetIndex(0)
+	if progress := pe.Progress(1); math.Abs(progress-0.8) > 1e-09 {
+		t.Fatal("the http input should weigh 80%", progress)
+	}
+	pe.SetIndex(2)
+	if progress := pe.Progress(2); progress != 1 {
+		t.Fatal("progress should not exceed one", progress)
+	}
+	pe = sess.NewProgressEstimator("web_connectivity", []string{"www.example.com", "https://b.example.com/"})
+	pe.SetIndex(1)
+	if progress := pe.Progress(0); math.Abs(progress-0.5) > 1e-09 {
+		t.Fatal("unknown input types should weigh like known ones", progress)
+	}
+}
+
+func TestRecordRuntimeAverage(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	for i := 0; i < 2*maxRuntimeSamples; i++ {
+		sess.recordRuntime("example", "", 1)
+	}
+	sess.recordRuntime("example", "", 1+maxRuntimeSamples)
+	stats := sess.loadRuntimes()["example/none"]
+	if stats.Count != maxRuntimeSamples || math.Abs(stats.Average-2) > 1e-09 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
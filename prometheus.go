@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"strconv"
+	"time"
+)
+
+// observeMeasurement updates the session's Prometheus metrics, if
+// any, after we have run a measurement.
+func (e *Experiment) observeMeasurement(anomaly bool, err error) {
+	pm := e.session.prometheus
+	if pm == nil {
+		return
+	}
+	pm.Measurements.Inc(e.testName, strconv.FormatBool(err != nil))
+	if anomaly {
+		pm.Anomalies.Inc(e.testName)
+	}
+}
+
+// observeSubmission is like observeMeasurement but for submissions.
+func (e *Experiment) observeSubmission(err error) {
+	if pm := e.session.prometheus; pm != nil && err != nil {
+		pm.SubmissionsFailed.Inc(e.testName)
+	}
+}
+
+// observeBootstrap records that a bootstrap of the given kind, i.e.
+// metrics.BootstrapBackend or metrics.BootstrapTunnel, took elapsed.
+func (s *Session) observeBootstrap(kind string, elapsed time.Duration) {
+	if s.prometheus != nil {
+		s.prometheus.BootstrapDuration.Observe(elapsed.Seconds(), kind)
+	}
+}
+
+// observeLookup is the sessionresolver.Resolver.ObserveLookup callback.
+func (s *Session) observeLookup(resolver string, elapsed time.Duration, err error) {
+	s.prometheus.ResolverLatency.Observe(
+		elapsed.Seconds(), resolver, strconv.FormatBool(err != nil))
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/metrics"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	sess := &Session{prometheus: metrics.New()}
+	exp := &Experiment{session: sess, testName: "example"}
+	exp.observeMeasurement(true, nil)
+	exp.observeMeasurement(false, errors.New("mocked error"))
+	exp.observeSubmission(nil)
+	exp.observeSubmission(errors.New("mocked error"))
+	sess.observeBootstrap(metrics.BootstrapTunnel, 3*time.Second)
+	sess.observeLookup("doh", 20*time.Millisecond, nil)
+	var sb strings.Builder
+	if _, err := sess.prometheus.Registry.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`ooni_anomalies_total{experiment="example"} 1`,
+		`ooni_measurements_total{experiment="example",failed="false"} 1`,
+		`ooni_measurements_total{experiment="example",failed="true"} 1`,
+		`ooni_submissions_failed_total{experiment="example"} 1`,
+		`ooni_bootstrap_duration_seconds_bucket{kind="tunnel",le="5"} 1`,
+		`ooni_resolver_latency_seconds_bucket{resolver="doh",failed="false",le="0.025"} 1`,
+	} {
+		if !strings.Contains(sb.String(), line+"\n") {
+			t.Fatal("missing metric", line)
+		}
+	}
+}
+
+func TestPrometheusMetricsDisabled(t *testing.T) {
+	sess := &Session{}
+	exp := &Experiment{session: sess, testName: "example"}
+	exp.observeMeasurement(true, nil)
+	exp.observeSubmission(errors.New("mocked error"))
+	sess.observeBootstrap(metrics.BootstrapBackend, time.Second)
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ExperimentFactory describes an experiment that is not part of the
+// engine and that you register using RegisterExperiment. NewConfig returns
+// a pointer to a struct containing the default options, which you can then
+// modify using the ExperimentBuilder's SetOption methods. NewMeasurer
+// creates the measurer given the (modified) value returned by NewConfig.
+type ExperimentFactory struct {
+	InputPolicy   InputPolicy
+	Interruptible bool
+	NewConfig     func() interface{}
+	NewMeasurer   func(config interface{}) model.ExperimentMeasurer
+}
+
+// ErrExperimentAlreadyRegistered indicates that there is already
+// an experiment with the name passed to RegisterExperiment.
+var ErrExperimentAlreadyRegistered = errors.New("experiment already registered")
+
+// ErrInvalidExperimentFactory indicates that the ExperimentFactory
+// passed to RegisterExperiment is not valid.
+var ErrInvalidExperimentFactory = errors.New("invalid experiment factory")
+
+// experimentsMu protects experimentsByName
+var experimentsMu sync.RWMutex
+
+// RegisterExperiment registers an experiment that is not part of the engine,
+// so that you can create it using Session.NewExperimentBuilder, like any other
+// experiment. Like the builtin experiments, the registered experiment uses
+// the session for input loading (see InputPolicy) and for submission. The
+// name is canonicalized like we do for the builtin experiments (e.g. "FooBar"
+// becomes "foo_bar") and cannot be the name of an existing experiment.
+func RegisterExperiment(name string, factory ExperimentFactory) error {
+	name = canonicalizeExperimentName(name)
+	if name == "" || factory.NewConfig == nil || factory.NewMeasurer == nil {
+		return ErrInvalidExperimentFactory
+	}
+	switch factory.InputPolicy {
+	case InputOptional, InputRequired, InputNone:
+	default:
+		return fmt.Errorf("%w: unknown input policy: %s",
+			ErrInvalidExperimentFactory, factory.InputPolicy)
+	}
+	if value := reflect.ValueOf(factory.NewConfig()); value.Kind() != reflect.Ptr ||
+		value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: config is not a pointer to struct",
+			ErrInvalidExperimentFactory)
+	}
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	if _, found := experimentsByName[name]; found {
+		return fmt.Errorf("%w: %s", ErrExperimentAlreadyRegistered, name)
+	}
+	experimentsByName[name] = func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, factory.NewMeasurer(config))
+			},
+			config:        factory.NewConfig(),
+			inputPolicy:   factory.InputPolicy,
+			interruptible: factory.Interruptible,
+		}
+	}
+	return nil
+}
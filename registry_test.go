@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/model"
+)
+
+func newExampleExperimentFactory(name string) ExperimentFactory {
+	return ExperimentFactory{
+		InputPolicy: InputOptional,
+		NewConfig: func() interface{} {
+			return &example.Config{Message: "antani"}
+		},
+		NewMeasurer: func(config interface{}) model.ExperimentMeasurer {
+			return example.NewExperimentMeasurer(*config.(*example.Config), name)
+		},
+	}
+}
+
+func TestRegisterExperimentGood(t *testing.T) {
+	const name = "registry_example"
+	if err := RegisterExperiment("RegistryExample", newExampleExperimentFactory(name)); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, entry := range AllExperiments() {
+		found = found || entry == name
+	}
+	if !found {
+		t.Fatal("the experiment is not listed")
+	}
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if builder.InputPolicy() != InputOptional || builder.Interruptible() {
+		t.Fatal("not the builder we expected")
+	}
+	if err := builder.SetOptionString("Message", "mascetti"); err != nil {
+		t.Fatal(err)
+	}
+	if exp := builder.NewExperiment(); exp.Name() != name {
+		t.Fatal("not the experiment name we expected")
+	}
+	// make sure each builder gets a fresh config
+	other, err := sess.NewExperimentBuilder(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.config.(*example.Config).Message != "antani" {
+		t.Fatal("builders are sharing the config")
+	}
+	err = RegisterExperiment(name, newExampleExperimentFactory(name))
+	if !errors.Is(err, ErrExperimentAlreadyRegistered) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestRegisterExperimentBuiltin(t *testing.T) {
+	err := RegisterExperiment("example", newExampleExperimentFactory("example"))
+	if !errors.Is(err, ErrExperimentAlreadyRegistered) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestRegisterExperimentInvalid(t *testing.T) {
+	invalid := []func(*ExperimentFactory){
+		func(f *ExperimentFactory) { f.NewConfig = nil },
+		func(f *ExperimentFactory) { f.NewMeasurer = nil },
+		func(f *ExperimentFactory) { f.InputPolicy = "antani" },
+		func(f *ExperimentFactory) {
+			f.NewConfig = func() interface{} { return example.Config{} }
+		},
+	}
+	for idx, fn := range invalid {
+		factory := newExampleExperimentFactory("registry_invalid")
+		fn(&factory)
+		err := RegisterExperiment("registry_invalid", factory)
+		if !errors.Is(err, ErrInvalidExperimentFactory) {
+			t.Fatalf("not the error we expected for case #%d", idx)
+		}
+	}
+	err := RegisterExperiment("", newExampleExperimentFactory(""))
+	if !errors.Is(err, ErrInvalidExperimentFactory) {
+		t.Fatal("not the error we expected")
+	}
+}
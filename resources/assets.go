@@ -20,14 +20,14 @@ const (
 // ResourceInfo contains information on a resource.
 type ResourceInfo struct {
 	// URLPath is the resource's URL path.
-	URLPath string
+	URLPath string `json:"url_path"`
 
 	// GzSHA256 is used to validate the downloaded file.
-	GzSHA256 string
+	GzSHA256 string `json:"gz_sha256"`
 
 	// SHA256 is used to check whether the assets file
 	// stored locally is still up-to-date.
-	SHA256 string
+	SHA256 string `json:"sha256"`
 }
 
 // All contains info on all known assets.
@@ -0,0 +1,153 @@
+package resources
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+)
+
+// maxAttemptsPerURL is the number of times we try to download a resource
+// from the same base URL, provided that each attempt makes progress.
+const maxAttemptsPerURL = 3
+
+// partSuffix is the suffix of the file, inside of the WorkDir, where we
+// save the compressed resource while we are downloading it.
+const partSuffix = ".gz.part"
+
+// errUnexpectedContentRange indicates that the server returned a range
+// that is not the one we asked for.
+var errUnexpectedContentRange = errors.New("resources: unexpected content range")
+
+// baseURLs returns the base URL followed by the mirrors.
+func (c *Client) baseURLs() []string {
+	return append([]string{c.baseURL()}, c.Mirrors...)
+}
+
+// fetchResource fetches the compressed resource and verifies it against
+// its GzSHA256. We try the base URL first and then each mirror. When an
+// attempt fails midway, we keep the bytes we have downloaded and we resume
+// from them, either using the same base URL or using the next mirror. When
+// the resource is corrupt, we discard it. If we had resumed from a stale
+// partial file, e.g., one left behind while downloading an older version
+// of the resource, we retry from scratch. Otherwise, we use the next mirror.
+func (c *Client) fetchResource(
+	ctx context.Context, name string, resource ResourceInfo) ([]byte, error) {
+	partpath := filepath.Join(c.WorkDir, name+partSuffix)
+	var err error
+	for _, baseURL := range c.baseURLs() {
+		for attempt := 0; attempt < maxAttemptsPerURL; attempt++ {
+			_, statErr := os.Stat(partpath)
+			resumed := statErr == nil
+			var progress bool
+			progress, err = c.fetchPart(ctx, baseURL, resource.URLPath, partpath)
+			if err == nil {
+				var data []byte
+				if data, err = c.verifyPart(partpath, resource.GzSHA256); err == nil {
+					return data, nil
+				}
+				// verifyPart removed the partial file, so retrying makes sense
+				// only if the stale bytes we resumed from were the issue.
+				progress = resumed
+			}
+			c.Logger.Debugf("resources: %s%s: %s", baseURL, resource.URLPath, err.Error())
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if !progress {
+				break // try with the next mirror
+			}
+		}
+	}
+	return nil, err
+}
+
+// fetchPart downloads the resource into partpath, resuming from the
+// bytes already in partpath, if any. Returns whether we have written any
+// byte into partpath, so the caller knows whether retrying makes sense.
+func (c *Client) fetchPart(
+	ctx context.Context, baseURL, URLPath, partpath string) (bool, error) {
+	var offset int64
+	if info, err := os.Stat(partpath); err == nil {
+		offset = info.Size()
+	}
+	clnt := httpx.Client{
+		BaseURL:    baseURL,
+		HTTPClient: c.HTTPClient,
+		Logger:     c.Logger,
+		UserAgent:  c.UserAgent,
+	}
+	request, err := clnt.NewRequest(ctx, "GET", URLPath, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		c.Logger.Debugf("resources: resuming %s from byte %d", partpath, offset)
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case response.StatusCode == http.StatusPartialContent && offset > 0:
+		if !strings.HasPrefix(response.Header.Get("Content-Range"),
+			"bytes "+strconv.FormatInt(offset, 10)+"-") {
+			return false, errUnexpectedContentRange
+		}
+		flags |= os.O_APPEND
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is as long as (or longer than) the resource. We
+		// restart from scratch and let verifyPart judge the result.
+		if err := os.Remove(partpath); err != nil {
+			return false, err
+		}
+		return true, errUnexpectedContentRange
+	case response.StatusCode >= 400:
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, httpx.MaxErrorBodySize))
+		return false, &httpx.RequestFailedError{
+			Body:       body,
+			Status:     response.Status,
+			StatusCode: response.StatusCode,
+		}
+	default:
+		// The server ignored the range, so we start over.
+		flags |= os.O_TRUNC
+	}
+	filep, err := os.OpenFile(partpath, flags, 0600)
+	if err != nil {
+		return false, err
+	}
+	count, err := io.Copy(filep, response.Body)
+	if closeErr := filep.Close(); err == nil {
+		err = closeErr
+	}
+	return count > 0, err
+}
+
+// verifyPart reads the fully downloaded resource and removes partpath. We
+// return an error if the resource does not match GzSHA256Sum.
+func (c *Client) verifyPart(partpath, GzSHA256Sum string) ([]byte, error) {
+	data, err := ioutil.ReadFile(partpath)
+	os.Remove(partpath) // either we'll use data or it's corrupt
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf("%x", sha256.Sum256(data))
+	if s != GzSHA256Sum {
+		return nil, fmt.Errorf("resources: %s: gz sha256 mismatch: got %s and expected %s",
+			partpath, s, GzSHA256Sum)
+	}
+	return data, nil
+}
@@ -0,0 +1,351 @@
+package resources_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/resources"
+)
+
+// newResource returns a large enough compressed resource along with
+// the corresponding ResourceInfo.
+func newResource(t *testing.T) ([]byte, []byte, resources.ResourceInfo) {
+	content := []byte(strings.Repeat("antani mascetti melandri\n", 1024))
+	var gzdata bytes.Buffer
+	gzwriter := gzip.NewWriter(&gzdata)
+	gzwriter.Write(content)
+	if err := gzwriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return content, gzdata.Bytes(), resources.ResourceInfo{
+		URLPath:  "/antani.txt.gz",
+		GzSHA256: fmt.Sprintf("%x", sha256.Sum256(gzdata.Bytes())),
+		SHA256:   fmt.Sprintf("%x", sha256.Sum256(content)),
+	}
+}
+
+// rangeRecorder records the Range headers of the requests.
+type rangeRecorder struct {
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (rr *rangeRecorder) record(r *http.Request) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.ranges = append(rr.ranges, r.Header.Get("Range"))
+}
+
+func (rr *rangeRecorder) get() []string {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return append([]string(nil), rr.ranges...)
+}
+
+func ensureSingle(client *resources.Client, info resources.ResourceInfo) error {
+	return client.EnsureForSingleResource(
+		context.Background(), "antani.txt", info, func(real, expected string) bool {
+			return real == expected
+		}, gzip.NewReader, ioutil.ReadAll,
+	)
+}
+
+func checkInstalled(t *testing.T, client *resources.Client, content []byte) {
+	data, err := ioutil.ReadFile(filepath.Join(client.WorkDir, "antani.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("not the content we expected")
+	}
+	partpath := filepath.Join(client.WorkDir, "antani.txt.gz.part")
+	if _, err := os.Stat(partpath); !os.IsNotExist(err) {
+		t.Fatal("expected the partial file to be removed")
+	}
+}
+
+func TestEnsureResumesPartialDownload(t *testing.T) {
+	content, gzdata, info := newResource(t)
+	var rr rangeRecorder
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			rr.record(r)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(gzdata))
+		}))
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	half := len(gzdata) / 2
+	partpath := filepath.Join(client.WorkDir, "antani.txt.gz.part")
+	if err := ioutil.WriteFile(partpath, gzdata[:half], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSingle(client, info); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalled(t, client, content)
+	ranges := rr.get()
+	if len(ranges) != 1 || ranges[0] != fmt.Sprintf("bytes=%d-", half) {
+		t.Fatal("not the ranges we expected", ranges)
+	}
+}
+
+func TestEnsureResumesAfterInterruptedDownload(t *testing.T) {
+	content, gzdata, info := newResource(t)
+	var (
+		rr    rangeRecorder
+		count int
+		mu    sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			rr.record(r)
+			mu.Lock()
+			count++
+			first := count == 1
+			mu.Unlock()
+			if first {
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", len(gzdata)))
+				w.Write(gzdata[:len(gzdata)/2])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler) // simulate a flaky link
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(gzdata))
+		}))
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	if err := ensureSingle(client, info); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalled(t, client, content)
+	ranges := rr.get()
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != fmt.Sprintf("bytes=%d-", len(gzdata)/2) {
+		t.Fatal("not the ranges we expected", ranges)
+	}
+}
+
+func TestEnsureRestartsWhenServerIgnoresRange(t *testing.T) {
+	content, gzdata, info := newResource(t)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(gzdata)
+		}))
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	partpath := filepath.Join(client.WorkDir, "antani.txt.gz.part")
+	if err := ioutil.WriteFile(partpath, gzdata[:10], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSingle(client, info); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalled(t, client, content)
+}
+
+func TestEnsureRestartsWhenRangeIsNotSatisfiable(t *testing.T) {
+	content, gzdata, info := newResource(t)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(gzdata))
+		}))
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	partpath := filepath.Join(client.WorkDir, "antani.txt.gz.part")
+	garbage := append(append([]byte{}, gzdata...), []byte("garbage")...)
+	if err := ioutil.WriteFile(partpath, garbage, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSingle(client, info); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalled(t, client, content)
+}
+
+func TestEnsureFallsBackToMirrors(t *testing.T) {
+	content, gzdata, info := newResource(t)
+	broken := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		}))
+	defer broken.Close()
+	tampered := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			data := append([]byte{}, gzdata...)
+			data[len(data)-1] ^= 0xff
+			w.Write(data)
+		}))
+	defer tampered.Close()
+	good := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(gzdata))
+		}))
+	defer good.Close()
+	client := newManifestClient(t, broken.URL)
+	client.Mirrors = []string{tampered.URL, good.URL}
+	if err := ensureSingle(client, info); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalled(t, client, content)
+}
+
+func TestEnsureAllMirrorsFail(t *testing.T) {
+	_, gzdata, info := newResource(t)
+	tampered := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(gzdata[1:])
+		}))
+	defer tampered.Close()
+	client := newManifestClient(t, tampered.URL)
+	client.Mirrors = []string{tampered.URL}
+	err := ensureSingle(client, info)
+	if err == nil || !strings.Contains(err.Error(), "gz sha256 mismatch") {
+		t.Fatal("not the error we expected", err)
+	}
+	partpath := filepath.Join(client.WorkDir, "antani.txt.gz.part")
+	if _, err := os.Stat(partpath); !os.IsNotExist(err) {
+		t.Fatal("expected the partial file to be removed")
+	}
+}
+
+// newSignedManifestServer is like newManifestServer but also serves
+// the signature of the manifest made using key.
+func newSignedManifestServer(t *testing.T, key ed25519.PrivateKey) *httptest.Server {
+	manifest := resources.Manifest{
+		Resources: map[string]resources.ResourceInfo{
+			"antani.txt": {URLPath: "/antani.txt.gz", GzSHA256: "aa", SHA256: "bb"},
+		},
+		Version: resources.Version + 1,
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := ed25519.Sign(key, data)
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case resources.ManifestURLPath:
+				w.Write(data)
+			case resources.ManifestSignatureURLPath:
+				w.Write(signature)
+			default:
+				w.WriteHeader(404)
+			}
+		}))
+}
+
+func TestFetchManifestWithValidSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newSignedManifestServer(t, private)
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	client.ManifestPublicKey = public
+	manifest, err := client.FetchManifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != resources.Version+1 {
+		t.Fatal("not the manifest we expected")
+	}
+}
+
+func TestFetchManifestWithInvalidSignature(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newSignedManifestServer(t, other)
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	client.ManifestPublicKey = public
+	manifest, err := client.FetchManifest(context.Background())
+	if !errors.Is(err, resources.ErrInvalidSignature) {
+		t.Fatal("not the error we expected", err)
+	}
+	if manifest != nil {
+		t.Fatal("expected nil manifest here")
+	}
+}
+
+func TestFetchManifestMissingSignature(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newManifestServer(t, resources.Version+1)
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	client.ManifestPublicKey = public
+	if _, err := client.FetchManifest(context.Background()); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestFetchManifestFallsBackToMirrors(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := newSignedManifestServer(t, other)
+	defer tampered.Close()
+	good := newSignedManifestServer(t, private)
+	defer good.Close()
+	client := newManifestClient(t, "http://127.0.0.1:1")
+	client.ManifestPublicKey = public
+	client.Mirrors = []string{tampered.URL, good.URL}
+	if _, err := client.FetchManifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnsureRestartsWhenPartialFileIsStale(t *testing.T) {
+	content, gzdata, info := newResource(t)
+	var rr rangeRecorder
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			rr.record(r)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(gzdata))
+		}))
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	half := len(gzdata) / 2
+	partpath := filepath.Join(client.WorkDir, "antani.txt.gz.part")
+	stale := bytes.Repeat([]byte{0}, half)
+	if err := ioutil.WriteFile(partpath, stale, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSingle(client, info); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalled(t, client, content)
+	ranges := rr.get()
+	if len(ranges) != 2 || ranges[0] != fmt.Sprintf("bytes=%d-", half) || ranges[1] != "" {
+		t.Fatal("not the ranges we expected", ranges)
+	}
+}
@@ -0,0 +1,232 @@
+package resources
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/httpx"
+)
+
+const (
+	// ManifestName is the name of the file, inside of the WorkDir,
+	// where we save the manifest of the installed resources.
+	ManifestName = "manifest.json"
+
+	// ManifestURLPath is the URL path of the latest manifest.
+	ManifestURLPath = "/ooni/probe-assets/releases/latest/download/manifest.json"
+
+	// ManifestSignatureURLPath is the URL path of the Ed25519 signature
+	// of the latest manifest, which is served as raw bytes.
+	ManifestSignatureURLPath = ManifestURLPath + ".sig"
+)
+
+// DefaultManifestPublicKey is the Ed25519 public key of the key pair with
+// which the probe-assets release process signs the manifest.
+var DefaultManifestPublicKey = ed25519.PublicKey(mustDecodeHex(
+	"609966dfcded9926aaf30137e050e1570f793db0f28ee6974cf1523bdd21123d"))
+
+// DefaultMirrors contains the base URLs mirroring BaseURL.
+var DefaultMirrors = []string{"https://mirror.ooni.org/"}
+
+func mustDecodeHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// ErrInvalidManifest indicates that a manifest is not valid.
+var ErrInvalidManifest = errors.New("resources: invalid manifest")
+
+// ErrInvalidSignature indicates that the signature of a manifest is not valid.
+var ErrInvalidSignature = errors.New("resources: invalid manifest signature")
+
+// ErrNeverUpdated indicates that we have not installed any resource yet.
+var ErrNeverUpdated = errors.New("resources: never updated")
+
+// Manifest describes a specific version of the resources.
+type Manifest struct {
+	// LastUpdated is when we have installed this version. This field
+	// is only meaningful for the manifest saved into the WorkDir.
+	LastUpdated time.Time `json:"last_updated,omitempty"`
+
+	// Resources contains information on each resource.
+	Resources map[string]ResourceInfo `json:"resources"`
+
+	// Version is the resources version.
+	Version int64 `json:"version"`
+}
+
+// BuiltinManifest returns the manifest of the resources that
+// we know about at compile time (i.e. All and Version).
+func BuiltinManifest() *Manifest {
+	return &Manifest{Resources: All, Version: Version}
+}
+
+func (m *Manifest) valid() bool {
+	if m.Version <= 0 || len(m.Resources) <= 0 {
+		return false
+	}
+	for name, resource := range m.Resources {
+		// The name MUST NOT allow us to write outside of the WorkDir.
+		if name != filepath.Base(name) || resource.URLPath == "" ||
+			resource.GzSHA256 == "" || resource.SHA256 == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// FetchManifest fetches the latest manifest. We try the base URL first
+// and then each mirror. If ManifestPublicKey is set, we also fetch the
+// signature of the manifest from the same base URL and we fail with
+// ErrInvalidSignature unless the signature is valid.
+func (c *Client) FetchManifest(ctx context.Context) (*Manifest, error) {
+	var err error
+	for _, baseURL := range c.baseURLs() {
+		var manifest *Manifest
+		if manifest, err = c.fetchManifest(ctx, baseURL); err == nil {
+			return manifest, nil
+		}
+		c.Logger.Debugf("resources: %s%s: %s", baseURL, ManifestURLPath, err.Error())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) fetchManifest(ctx context.Context, baseURL string) (*Manifest, error) {
+	clnt := httpx.Client{
+		BaseURL:    baseURL,
+		HTTPClient: c.HTTPClient,
+		Logger:     c.Logger,
+		UserAgent:  c.UserAgent,
+	}
+	data, err := clnt.FetchResource(ctx, ManifestURLPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.ManifestPublicKey != nil {
+		signature, err := clnt.FetchResource(ctx, ManifestSignatureURLPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.ManifestPublicKey) != ed25519.PublicKeySize ||
+			!ed25519.Verify(c.ManifestPublicKey, data, signature) {
+			return nil, ErrInvalidSignature
+		}
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if !manifest.valid() {
+		return nil, ErrInvalidManifest
+	}
+	return &manifest, nil
+}
+
+// InstalledManifest returns the manifest of the installed resources. If
+// we have not installed any resource, or we have installed resources that
+// are older than the builtin ones, we return BuiltinManifest().
+func (c *Client) InstalledManifest() *Manifest {
+	manifest, err := c.readManifest()
+	if err != nil || manifest.Version < Version {
+		return BuiltinManifest()
+	}
+	return manifest
+}
+
+// LastUpdated returns when we have last installed new resources. Returns
+// ErrNeverUpdated if we have never installed any resource.
+func (c *Client) LastUpdated() (time.Time, error) {
+	manifest, err := c.readManifest()
+	if err != nil {
+		return time.Time{}, ErrNeverUpdated
+	}
+	return manifest.LastUpdated, nil
+}
+
+// Update fetches the latest manifest and, if it is more recent than
+// the installed one, downloads and installs the new resources. Returns
+// whether we have installed new resources.
+func (c *Client) Update(ctx context.Context) (bool, error) {
+	latest, err := c.FetchManifest(ctx)
+	if err != nil {
+		return false, err
+	}
+	installed := c.InstalledManifest()
+	if latest.Version <= installed.Version {
+		c.Logger.Debugf("resources: version %d is up to date", installed.Version)
+		return false, nil
+	}
+	c.Logger.Infof("resources: updating from %d to %d", installed.Version, latest.Version)
+	if err := c.ensureManifest(ctx, latest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Client) readManifest() (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.WorkDir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if !manifest.valid() {
+		return nil, ErrInvalidManifest
+	}
+	return &manifest, nil
+}
+
+// writeManifest saves manifest into the WorkDir, setting LastUpdated.
+func (c *Client) writeManifest(manifest *Manifest) error {
+	saved := *manifest
+	saved.LastUpdated = time.Now().UTC()
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.WorkDir, ManifestName), data)
+}
+
+// writeFileAtomic writes data into a temporary file in the same directory
+// of filename and then renames the temporary file to filename, so that
+// concurrent readers either see the old file or the new file.
+func writeFileAtomic(filename string, data []byte) error {
+	filep, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempname := filep.Name()
+	if _, err := filep.Write(data); err != nil {
+		filep.Close()
+		os.Remove(tempname)
+		return err
+	}
+	if err := filep.Close(); err != nil {
+		os.Remove(tempname)
+		return err
+	}
+	if err := os.Chmod(tempname, 0600); err != nil {
+		os.Remove(tempname)
+		return err
+	}
+	if err := os.Rename(tempname, filename); err != nil {
+		os.Remove(tempname)
+		return err
+	}
+	return nil
+}
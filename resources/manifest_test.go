@@ -0,0 +1,199 @@
+package resources_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/resources"
+)
+
+// newManifestServer returns a server that serves a manifest with the
+// specified version containing a single "antani.txt" resource.
+func newManifestServer(t *testing.T, version int64) *httptest.Server {
+	const content = "antani antani antani\n"
+	var gzdata bytes.Buffer
+	gzwriter := gzip.NewWriter(&gzdata)
+	gzwriter.Write([]byte(content))
+	gzwriter.Close()
+	manifest := resources.Manifest{
+		Resources: map[string]resources.ResourceInfo{
+			"antani.txt": {
+				URLPath:  "/antani.txt.gz",
+				GzSHA256: fmt.Sprintf("%x", sha256.Sum256(gzdata.Bytes())),
+				SHA256:   fmt.Sprintf("%x", sha256.Sum256([]byte(content))),
+			},
+		},
+		Version: version,
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case resources.ManifestURLPath:
+				w.Write(data)
+			case "/antani.txt.gz":
+				w.Write(gzdata.Bytes())
+			default:
+				w.WriteHeader(404)
+			}
+		}))
+}
+
+func newManifestClient(t *testing.T, URL string) *resources.Client {
+	tempdir, err := ioutil.TempDir("", "ooniprobe-engine-resources-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &resources.Client{
+		BaseURL:    URL,
+		HTTPClient: http.DefaultClient,
+		Logger:     log.Log,
+		UserAgent:  "ooniprobe-engine/0.1.0",
+		WorkDir:    tempdir,
+	}
+}
+
+func TestUpdateInstallsNewerResources(t *testing.T) {
+	server := newManifestServer(t, resources.Version+1)
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	if _, err := client.LastUpdated(); !errors.Is(err, resources.ErrNeverUpdated) {
+		t.Fatal("not the error we expected")
+	}
+	before := time.Now().UTC()
+	updated, err := client.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated {
+		t.Fatal("expected to update the resources")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(client.WorkDir, "antani.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "antani antani antani\n" {
+		t.Fatal("not the content we expected")
+	}
+	if client.InstalledManifest().Version != resources.Version+1 {
+		t.Fatal("not the installed version we expected")
+	}
+	lastUpdated, err := client.LastUpdated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastUpdated.Before(before) {
+		t.Fatal("LastUpdated is too old")
+	}
+	// the second round should find that we're up to date
+	updated, err = client.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated {
+		t.Fatal("expected no update here")
+	}
+	// Ensure should not downgrade to the builtin resources
+	if err := client.Ensure(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.InstalledManifest().Version != resources.Version+1 {
+		t.Fatal("Ensure downgraded the resources")
+	}
+}
+
+func TestUpdateIgnoresOlderResources(t *testing.T) {
+	server := newManifestServer(t, resources.Version-1)
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	updated, err := client.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated {
+		t.Fatal("expected no update here")
+	}
+	if client.InstalledManifest().Version != resources.Version {
+		t.Fatal("expected the builtin manifest here")
+	}
+}
+
+func TestFetchManifestInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"version":1,"resources":{"../x":{}}}`))
+		}))
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	manifest, err := client.FetchManifest(context.Background())
+	if !errors.Is(err, resources.ErrInvalidManifest) {
+		t.Fatal("not the error we expected")
+	}
+	if manifest != nil {
+		t.Fatal("expected nil manifest here")
+	}
+}
+
+func TestUpdateFetchManifestFailure(t *testing.T) {
+	client := newManifestClient(t, "http://127.0.0.1:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	updated, err := client.Update(ctx)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if updated {
+		t.Fatal("expected no update here")
+	}
+}
+
+func TestBuiltinManifest(t *testing.T) {
+	manifest := resources.BuiltinManifest()
+	if manifest.Version != resources.Version || len(manifest.Resources) != len(resources.All) {
+		t.Fatal("not the manifest we expected")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server := newManifestServer(t, resources.Version+1)
+	defer server.Close()
+	client := newManifestClient(t, server.URL)
+	if err := client.Verify(); !errors.Is(err, resources.ErrInvalidResource) {
+		t.Fatal("not the error we expected", err)
+	}
+	if _, err := client.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	fullpath := filepath.Join(client.WorkDir, "antani.txt")
+	if err := ioutil.WriteFile(fullpath, []byte("mascetti\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Verify(); !errors.Is(err, resources.ErrInvalidResource) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestDefaultManifestPublicKey(t *testing.T) {
+	if len(resources.DefaultManifestPublicKey) != ed25519.PublicKeySize {
+		t.Fatal("invalid default manifest public key")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,18 +15,29 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/ooni/probe-engine/internal/httpx"
 	"github.com/ooni/probe-engine/model"
 )
 
 // Client is a client for fetching resources.
 type Client struct {
+	// BaseURL is the base URL from which to fetch resources. If
+	// empty, we use the default BaseURL.
+	BaseURL string
+
 	// HTTPClient is the HTTP client to use.
 	HTTPClient *http.Client
 
 	// Logger is the logger to use.
 	Logger model.Logger
 
+	// ManifestPublicKey is the optional Ed25519 public key with which
+	// we verify the signature of the manifest fetched by FetchManifest.
+	ManifestPublicKey ed25519.PublicKey
+
+	// Mirrors contains optional base URLs that we try, in order, when
+	// we cannot fetch a resource or the manifest from BaseURL.
+	Mirrors []string
+
 	// OSMkdirAll allows testing os.MkdirAll failures.
 	OSMkdirAll func(path string, perm os.FileMode) error
 
@@ -35,8 +48,33 @@ type Client struct {
 	WorkDir string
 }
 
-// Ensure ensures that resources are downloaded and current.
+// Ensure ensures that resources are downloaded and current. We use the
+// resources described by InstalledManifest, so that we do not downgrade
+// the resources that we have installed using Update.
 func (c *Client) Ensure(ctx context.Context) error {
+	return c.ensureManifest(ctx, c.InstalledManifest())
+}
+
+// ErrInvalidResource indicates that a resource is missing or outdated.
+var ErrInvalidResource = errors.New("resources: missing or outdated resource")
+
+// Verify is like Ensure but only checks, without downloading anything,
+// whether the resources are present and current. The returned error wraps
+// ErrInvalidResource if a resource is missing or outdated.
+func (c *Client) Verify() error {
+	for name, resource := range c.InstalledManifest().Resources {
+		data, err := ioutil.ReadFile(filepath.Join(c.WorkDir, name))
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidResource, err.Error())
+		}
+		if sha256sum := fmt.Sprintf("%x", sha256.Sum256(data)); sha256sum != resource.SHA256 {
+			return fmt.Errorf("%w: %s: sha256 mismatch", ErrInvalidResource, name)
+		}
+	}
+	return nil
+}
+
+func (c *Client) ensureManifest(ctx context.Context, manifest *Manifest) error {
 	mkdirall := c.OSMkdirAll
 	if mkdirall == nil {
 		mkdirall = os.MkdirAll
@@ -44,7 +82,7 @@ func (c *Client) Ensure(ctx context.Context) error {
 	if err := mkdirall(c.WorkDir, 0700); err != nil {
 		return err
 	}
-	for name, resource := range All {
+	for name, resource := range manifest.Resources {
 		if err := c.EnsureForSingleResource(
 			ctx, name, resource, func(real, expected string) bool {
 				return real == expected
@@ -54,9 +92,20 @@ func (c *Client) Ensure(ctx context.Context) error {
 			return err
 		}
 	}
+	installed, err := c.readManifest()
+	if err != nil || installed.Version != manifest.Version {
+		return c.writeManifest(manifest)
+	}
 	return nil
 }
 
+func (c *Client) baseURL() string {
+	if c.BaseURL != "" {
+		return c.BaseURL
+	}
+	return BaseURL
+}
+
 // EnsureForSingleResource ensures that a single resource
 // is downloaded and is current.
 func (c *Client) EnsureForSingleResource(
@@ -76,12 +125,7 @@ func (c *Client) EnsureForSingleResource(
 	} else {
 		c.Logger.Debugf("resources: can't read %s: %s", fullpath, err.Error())
 	}
-	data, err = (httpx.Client{
-		BaseURL:    BaseURL,
-		HTTPClient: c.HTTPClient,
-		Logger:     c.Logger,
-		UserAgent:  c.UserAgent,
-	}).FetchResourceAndVerify(ctx, resource.URLPath, resource.GzSHA256)
+	data, err = c.fetchResource(ctx, name, resource)
 	if err != nil {
 		return err
 	}
@@ -100,5 +144,5 @@ func (c *Client) EnsureForSingleResource(
 		return fmt.Errorf("resources: %s sha256 mismatch", fullpath)
 	}
 	c.Logger.Debugf("resources: overwrite %s", fullpath)
-	return ioutil.WriteFile(fullpath, data, 0600)
+	return writeFileAtomic(fullpath, data)
 }
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	// RouteDirect means that the traffic does not use the proxy.
+	RouteDirect = "direct"
+
+	// RouteProxy means that the traffic uses the proxy, i.e., either
+	// the proxy configured by the user or the tunnel.
+	RouteProxy = "proxy"
+)
+
+// ErrInvalidRoute indicates that a RoutingPolicy contains a route
+// that is neither RouteDirect nor RouteProxy.
+var ErrInvalidRoute = errors.New("invalid route")
+
+// RoutingPolicy tells which traffic uses the session's proxy or tunnel.
+// Backend is the route of the traffic towards the OONI backend, i.e., the
+// probe services and the collectors, and Measurements is the route of
+// the traffic generated by experiments, including the traffic used to
+// discover the probe location. Empty fields mean the default: backend
+// traffic uses the proxy, while measurement traffic goes direct, so that
+// we measure the network rather than the proxy. An experiment that
+// explicitly requests a tunnel (e.g., psiphon) always uses it.
+type RoutingPolicy struct {
+	Backend      string
+	Measurements string
+}
+
+func (p RoutingPolicy) backend() string {
+	if p.Backend == "" {
+		return RouteProxy
+	}
+	return p.Backend
+}
+
+func (p RoutingPolicy) measurements() string {
+	if p.Measurements == "" {
+		return RouteDirect
+	}
+	return p.Measurements
+}
+
+// Validate returns an error wrapping ErrInvalidRoute if the
+// policy contains invalid routes.
+func (p RoutingPolicy) Validate() error {
+	for _, route := range []string{p.backend(), p.measurements()} {
+		if route != RouteDirect && route != RouteProxy {
+			return fmt.Errorf("%w: %q", ErrInvalidRoute, route)
+		}
+	}
+	return nil
+}
+
+// backendProxyURL returns the proxy for backend traffic, if any.
+func (s *Session) backendProxyURL(proxyURL *url.URL) *url.URL {
+	if s.routing.backend() == RouteDirect {
+		return nil
+	}
+	return proxyURL
+}
+
+// measurementProxyURL returns the proxy for measurement traffic, if any.
+func (s *Session) measurementProxyURL() *url.URL {
+	if s.routing.measurements() == RouteDirect {
+		return nil
+	}
+	return s.ProxyURL()
+}
+
+// measurementSession is the session seen by a running experiment, which
+// enforces the routing policy for the measurement traffic.
+type measurementSession struct {
+	*Session
+	testName string
+	tunnel   bool
+}
+
+var _ model.ExperimentSession = &measurementSession{}
+
+// MaybeStartTunnel is like Session.MaybeStartTunnel except that, once
+// an experiment has requested a tunnel, its traffic uses the tunnel.
+func (ms *measurementSession) MaybeStartTunnel(ctx context.Context, name string) error {
+	if err := ms.Session.MaybeStartTunnel(ctx, name); err != nil {
+		return err
+	}
+	ms.tunnel = ms.tunnel || name != ""
+	return nil
+}
+
+// Logger returns the logger that the experiment should use, which
+// tells that the messages come from the experiment.
+func (ms *measurementSession) Logger() model.Logger {
+	return experimentLogger(ms.Session.Logger(), ms.testName)
+}
+
+// experimentLogger returns a logger telling that the messages emitted
+// using logger come from the experiment with the given name.
+func experimentLogger(logger model.Logger, testName string) model.Logger {
+	return model.WithFields(logger, model.LogFields{
+		model.LogFieldComponent: "experiment",
+		"experiment":            testName,
+	})
+}
+
+// ProxyURL returns the proxy that the experiment should use, if any.
+func (ms *measurementSession) ProxyURL() *url.URL {
+	if ms.tunnel {
+		return ms.Session.ProxyURL()
+	}
+	return ms.Session.measurementProxyURL()
+}
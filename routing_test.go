@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestRoutingPolicyValidate(t *testing.T) {
+	if err := (RoutingPolicy{}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	policy := RoutingPolicy{Backend: RouteDirect, Measurements: RouteProxy}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	policy = RoutingPolicy{Measurements: "tunnel"}
+	if err := policy.Validate(); !errors.Is(err, ErrInvalidRoute) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestNewSessionWithInvalidRoutingPolicy(t *testing.T) {
+	sess, err := NewSession(SessionConfig{
+		AssetsDir:       "testdata",
+		Logger:          log.Log,
+		Routing:         RoutingPolicy{Backend: "antani"},
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+	if !errors.Is(err, ErrInvalidRoute) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestRoutingDefaultPolicy(t *testing.T) {
+	proxyURL := &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"}
+	sess := newSessionForTestingNoLookupsWithProxyURL(t, proxyURL)
+	defer sess.Close()
+	if sess.backendProxyURL(sess.proxyURL) != proxyURL {
+		t.Fatal("backend traffic should use the proxy")
+	}
+	ms := &measurementSession{Session: sess}
+	if ms.ProxyURL() != nil {
+		t.Fatal("measurement traffic should go direct")
+	}
+	if sess.ProxyURL() != proxyURL {
+		t.Fatal("the session should still use the proxy")
+	}
+}
+
+func TestRoutingCustomPolicy(t *testing.T) {
+	proxyURL := &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"}
+	sess := newSessionForTestingNoLookupsWithProxyURL(t, proxyURL)
+	defer sess.Close()
+	sess.routing = RoutingPolicy{Backend: RouteDirect, Measurements: RouteProxy}
+	if sess.backendProxyURL(sess.proxyURL) != nil {
+		t.Fatal("backend traffic should go direct")
+	}
+	ms := &measurementSession{Session: sess}
+	if ms.ProxyURL() != proxyURL {
+		t.Fatal("measurement traffic should use the proxy")
+	}
+}
+
+func TestRoutingExperimentRequestingTunnel(t *testing.T) {
+	proxyURL := &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"}
+	sess := newSessionForTestingNoLookupsWithProxyURL(t, proxyURL)
+	defer sess.Close()
+	ms := &measurementSession{Session: sess}
+	ctx := context.Background()
+	if err := ms.MaybeStartTunnel(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if ms.ProxyURL() != nil {
+		t.Fatal("measurement traffic should go direct")
+	}
+	if err := ms.MaybeStartTunnel(ctx, "psiphon"); !errors.Is(err, ErrAlreadyUsingProxy) {
+		t.Fatal("not the error we expected")
+	}
+	if ms.ProxyURL() != nil {
+		t.Fatal("a failed request should not change the route")
+	}
+	// simulate an already running tunnel requested by the experiment
+	sess.tunnelName, sess.tunnel = "psiphon", &fakeTunnel{proxy: proxyURL}
+	if err := ms.MaybeStartTunnel(ctx, "psiphon"); err != nil {
+		t.Fatal(err)
+	}
+	if ms.ProxyURL() != proxyURL {
+		t.Fatal("the experiment should use the tunnel")
+	}
+}
+
+type fakeTunnel struct {
+	proxy *url.URL
+}
+
+func (tun *fakeTunnel) BootstrapTime() time.Duration {
+	return 0
+}
+
+func (tun *fakeTunnel) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+func (tun *fakeTunnel) SOCKS5ProxyURL() *url.URL {
+	return tun.proxy
+}
+
+func (tun *fakeTunnel) Stop() {}
@@ -0,0 +1,252 @@
+package engine
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ExperimentSummary summarizes the measurements performed by all the
+// experiments with the same name during a session. A measurement is
+// counted in Failures when MeasureWithContext returns an error, and it is
+// counted in Anomalies when the experiment implements the optional
+// model.ExperimentAnomalyDetector interface and flags the measurement.
+// Measurements that we queued for later submission, because the collector
+// was not reachable, are counted in SubmissionFailures.
+type ExperimentSummary struct {
+	Anomalies          int64   `json:"anomalies"`
+	Failures           int64   `json:"failures"`
+	KibiBytesReceived  float64 `json:"kibi_bytes_received"`
+	KibiBytesSent      float64 `json:"kibi_bytes_sent"`
+	Measurements       int64   `json:"measurements"`
+	Runtime            float64 `json:"runtime"`
+	SubmissionFailures int64   `json:"submission_failures"`
+	Submitted          int64   `json:"submitted"`
+}
+
+// MeasurementSummary summarizes a single measurement. Failure is the error
+// returned by MeasureWithContext, if any. Accessible and Blocking are the
+// test keys with the same name, which Web Connectivity and similar
+// experiments use to tell whether the input is accessible and which kind
+// of blocking, if any, they detected. Accessible is nil and Blocking is
+// empty when the experiment does not set them. When Blocking is a boolean,
+// as in the case where there is no blocking, we convert it to a string.
+type MeasurementSummary struct {
+	Accessible        *bool     `json:"accessible"`
+	Anomaly           bool      `json:"anomaly"`
+	Blocking          string    `json:"blocking"`
+	Failure           string    `json:"failure"`
+	Input             string    `json:"input"`
+	KibiBytesReceived float64   `json:"kibi_bytes_received"`
+	KibiBytesSent     float64   `json:"kibi_bytes_sent"`
+	Runtime           float64   `json:"runtime"`
+	StartTime         time.Time `json:"start_time"`
+	TestName          string    `json:"test_name"`
+}
+
+// MaxMeasurementSummaries is the maximum number of measurement summaries
+// that RunSummary.Measurements contains.
+const MaxMeasurementSummaries = 1000
+
+// RunSummary summarizes all the measurements performed during a session,
+// so that apps do not need to tally the results of each measurement. The
+// Experiments map is indexed by experiment name. Measurements contains a
+// summary of the most recent MaxMeasurementSummaries measurements in the
+// order in which we performed them (see WriteCSV and WriteJSONL for
+// exporting it), while Experiments accounts for all of them. StartTime and EndTime are
+// the times when the first measurement started and the last measurement
+// ended; they are zero if we have not measured anything yet. The data
+// usage fields account for all the bytes sent and received by the session,
+// including the bytes used for bootstrapping, while MeasurementsDataUsage
+// only accounts for the bytes used by the measurements, i.e., it is the
+// total of the data usage we record into each measurement's test keys.
+type RunSummary struct {
+	EndTime               time.Time                     `json:"end_time"`
+	Experiments           map[string]*ExperimentSummary `json:"experiments"`
+	KibiBytesReceived     float64                       `json:"kibi_bytes_received"`
+	KibiBytesSent         float64                       `json:"kibi_bytes_sent"`
+	Measurements          []MeasurementSummary          `json:"measurements"`
+	MeasurementsDataUsage model.DataUsage               `json:"measurements_data_usage"`
+	StartTime             time.Time                     `json:"start_time"`
+}
+
+// RunSummary returns a snapshot of the session run summary.
+func (s *Session) RunSummary() *RunSummary {
+	out := s.runSummary.snapshot()
+	out.KibiBytesReceived = s.KibiBytesReceived()
+	out.KibiBytesSent = s.KibiBytesSent()
+	return out
+}
+
+// runSummary collects the data returned by Session.RunSummary.
+type runSummary struct {
+	endTime      time.Time
+	experiments  map[string]*ExperimentSummary
+	measurements []MeasurementSummary
+	mu           sync.Mutex
+	startTime    time.Time
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{experiments: make(map[string]*ExperimentSummary)}
+}
+
+// experiment returns the summary of the named experiment. This
+// function assumes that the caller is holding the mutex.
+func (rs *runSummary) experiment(name string) *ExperimentSummary {
+	es, found := rs.experiments[name]
+	if !found {
+		es = &ExperimentSummary{}
+		rs.experiments[name] = es
+	}
+	return es
+}
+
+// measured records a measurement performed by the named experiment.
+func (rs *runSummary) measured(
+	name string, measurement *model.Measurement, start, stop time.Time,
+	kibRecv, kibSent float64, anomaly bool, err error,
+) {
+	ms := newMeasurementSummary(measurement, err)
+	ms.Anomaly = anomaly
+	ms.KibiBytesReceived, ms.KibiBytesSent = kibRecv, kibSent
+	ms.Runtime, ms.StartTime, ms.TestName = stop.Sub(start).Seconds(), start, name
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.startTime.IsZero() || start.Before(rs.startTime) {
+		rs.startTime = start
+	}
+	if stop.After(rs.endTime) {
+		rs.endTime = stop
+	}
+	if len(rs.measurements) >= MaxMeasurementSummaries {
+		rs.measurements = rs.measurements[1:]
+	}
+	rs.measurements = append(rs.measurements, ms)
+	es := rs.experiment(name)
+	es.Measurements++
+	es.Runtime += stop.Sub(start).Seconds()
+	es.KibiBytesReceived += kibRecv
+	es.KibiBytesSent += kibSent
+	switch {
+	case err != nil:
+		es.Failures++
+	case anomaly:
+		es.Anomalies++
+	}
+}
+
+// submitted records the result of submitting a measurement.
+func (rs *runSummary) submitted(name string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	es := rs.experiment(name)
+	if err != nil {
+		es.SubmissionFailures++
+		return
+	}
+	es.Submitted++
+}
+
+func (rs *runSummary) snapshot() *RunSummary {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	out := &RunSummary{
+		EndTime:      rs.endTime,
+		Experiments:  make(map[string]*ExperimentSummary),
+		Measurements: append([]MeasurementSummary{}, rs.measurements...),
+		StartTime:    rs.startTime,
+	}
+	for name, es := range rs.experiments {
+		copied := *es
+		out.Experiments[name] = &copied
+		out.MeasurementsDataUsage.KibiBytesReceived += es.KibiBytesReceived
+		out.MeasurementsDataUsage.KibiBytesSent += es.KibiBytesSent
+	}
+	return out
+}
+
+// newMeasurementSummary fills the fields of a MeasurementSummary that
+// depend on measurement and on the error that occurred.
+func newMeasurementSummary(measurement *model.Measurement, err error) MeasurementSummary {
+	var ms MeasurementSummary
+	if err != nil {
+		ms.Failure = err.Error()
+	}
+	if measurement == nil {
+		return ms
+	}
+	ms.Input = string(measurement.Input)
+	switch accessible := testKey(measurement.TestKeys, "accessible").(type) {
+	case bool:
+		ms.Accessible = &accessible
+	case *bool:
+		ms.Accessible = accessible
+	}
+	switch blocking := testKey(measurement.TestKeys, "blocking").(type) {
+	case bool:
+		ms.Blocking = strconv.FormatBool(blocking)
+	case string:
+		ms.Blocking = blocking
+	}
+	return ms
+}
+
+// testKey returns the value of the test key with the given JSON name, or
+// nil. The test keys are either a struct, possibly embedding other structs,
+// or a map, e.g., after dataformat.Convert. We use reflection rather than
+// marshalling the test keys, which may be large, for each measurement.
+func testKey(testKeys interface{}, name string) interface{} {
+	if m, ok := testKeys.(map[string]interface{}); ok {
+		return m[name]
+	}
+	value := structTestKey(reflect.ValueOf(testKeys), name)
+	if !value.IsValid() || !value.CanInterface() {
+		return nil
+	}
+	return value.Interface()
+}
+
+// structTestKey is the reflection-based backend of testKey.
+func structTestKey(value reflect.Value, name string) reflect.Value {
+	value = indirect(value)
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && tag == "" {
+			if v := structTestKey(value.Field(i), name); v.IsValid() {
+				return v
+			}
+			continue
+		}
+		if field.PkgPath == "" && tag == name {
+			return indirect(value.Field(i))
+		}
+	}
+	return reflect.Value{}
+}
+
+// indirect follows pointers and interfaces until it finds a concrete
+// value. It returns the zero reflect.Value if it finds a nil.
+func indirect(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	return value
+}
+
+// isAnomaly returns whether measurer thinks measurement is an anomaly.
+func isAnomaly(measurer model.ExperimentMeasurer, measurement *model.Measurement) bool {
+	detector, ok := measurer.(model.ExperimentAnomalyDetector)
+	return ok && detector.IsAnomaly(measurement)
+}
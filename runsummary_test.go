@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/model/dataformat"
+	"github.com/ooni/probe-engine/probeservices"
+)
+
+type anomalousMeasurer struct {
+	model.ExperimentMeasurer
+}
+
+func (anomalousMeasurer) IsAnomaly(measurement *model.Measurement) bool {
+	return true
+}
+
+func TestSessionRunSummary(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	if summary := sess.RunSummary(); !summary.StartTime.IsZero() || len(summary.Experiments) != 0 {
+		t.Fatal("expected an empty summary here")
+	}
+	good := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "example",
+	))
+	measurement, err := good.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk := measurement.TestKeys.(*example.TestKeys); tk.DataUsage == nil {
+		t.Fatal("expected data usage in the test keys")
+	}
+	bad := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{ReturnError: true, SleepTime: int64(time.Millisecond)}, "example",
+	))
+	if _, err := bad.Measure(""); err == nil {
+		t.Fatal("expected an error here")
+	}
+	anomalous := NewExperiment(sess, anomalousMeasurer{example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "anomalous",
+	)})
+	if _, err := anomalous.Measure(""); err != nil {
+		t.Fatal(err)
+	}
+	summary := sess.RunSummary()
+	if summary.StartTime.IsZero() || summary.EndTime.Before(summary.StartTime) {
+		t.Fatal("unexpected times")
+	}
+	es := summary.Experiments["example"]
+	if es == nil || es.Measurements != 2 || es.Failures != 1 || es.Anomalies != 0 {
+		t.Fatalf("unexpected example summary: %+v", es)
+	}
+	if es.Runtime <= 0 {
+		t.Fatal("unexpected runtime")
+	}
+	usage := summary.MeasurementsDataUsage
+	if usage.KibiBytesReceived != es.KibiBytesReceived+summary.Experiments["anomalous"].KibiBytesReceived ||
+		usage.KibiBytesSent != es.KibiBytesSent+summary.Experiments["anomalous"].KibiBytesSent {
+		t.Fatalf("unexpected measurements data usage: %+v", usage)
+	}
+	es = summary.Experiments["anomalous"]
+	if es == nil || es.Measurements != 1 || es.Failures != 0 || es.Anomalies != 1 {
+		t.Fatalf("unexpected anomalous summary: %+v", es)
+	}
+	if len(summary.Measurements) != 3 {
+		t.Fatal("unexpected number of measurement summaries")
+	}
+	if summary.Measurements[1].Failure == "" || !summary.Measurements[2].Anomaly ||
+		summary.Measurements[2].TestName != "anomalous" {
+		t.Fatalf("unexpected measurement summaries: %+v", summary.Measurements)
+	}
+}
+
+func TestRunSummarySubmitted(t *testing.T) {
+	rs := newRunSummary()
+	rs.submitted("example", nil)
+	rs.submitted("example", nil)
+	rs.submitted("example", errors.New("mocked error"))
+	out := rs.snapshot()
+	es := out.Experiments["example"]
+	if es.Submitted != 2 || es.SubmissionFailures != 1 {
+		t.Fatalf("unexpected summary: %+v", es)
+	}
+	es.Submitted = 10 // must not modify the original
+	if rs.snapshot().Experiments["example"].Submitted != 2 {
+		t.Fatal("snapshot is not a copy")
+	}
+}
+
+func TestRunSummaryCapsMeasurements(t *testing.T) {
+	rs := newRunSummary()
+	now := time.Now()
+	for i := 0; i < MaxMeasurementSummaries+1; i++ {
+		measurement := &model.Measurement{Input: model.MeasurementTarget(strconv.Itoa(i))}
+		rs.measured("example", measurement, now, now, 0, 0, false, nil)
+	}
+	out := rs.snapshot()
+	if len(out.Measurements) != MaxMeasurementSummaries || out.Measurements[0].Input != "1" {
+		t.Fatal("we did not drop the oldest measurement summary")
+	}
+	if out.Experiments["example"].Measurements != MaxMeasurementSummaries+1 {
+		t.Fatal("the experiment summary should account for all measurements")
+	}
+}
+
+func TestNewMeasurementSummaryTestKeys(t *testing.T) {
+	type summary struct {
+		Accessible *bool       `json:"accessible"`
+		Blocking   interface{} `json:"blocking"`
+	}
+	type testKeys struct {
+		Failure *string `json:"failure"`
+		summary
+	}
+	accessible := false
+	for _, tk := range []interface{}{
+		&testKeys{summary: summary{Accessible: &accessible, Blocking: "dns"}},
+		map[string]interface{}{"accessible": false, "blocking": "dns"},
+	} {
+		ms := newMeasurementSummary(&model.Measurement{TestKeys: tk}, nil)
+		if ms.Accessible == nil || *ms.Accessible || ms.Blocking != "dns" {
+			t.Fatalf("unexpected summary for %T: %+v", tk, ms)
+		}
+	}
+	ms := newMeasurementSummary(&model.Measurement{TestKeys: &testKeys{
+		summary: summary{Blocking: false}}}, nil)
+	if ms.Accessible != nil || ms.Blocking != "false" {
+		t.Fatalf("unexpected summary: %+v", ms)
+	}
+}
+
+// typedAnomalyMeasurer flags the measurements whose test keys have
+// the type the example experiment uses, like real experiments do.
+type typedAnomalyMeasurer struct {
+	model.ExperimentMeasurer
+}
+
+func (typedAnomalyMeasurer) IsAnomaly(measurement *model.Measurement) bool {
+	_, ok := measurement.TestKeys.(*example.TestKeys)
+	return ok
+}
+
+func TestExperimentIsAnomalyAfterConversion(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	sess.dataFormatVersion = dataformat.Version030
+	exp := NewExperiment(sess, typedAnomalyMeasurer{example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "example",
+	)})
+	measurement, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := measurement.TestKeys.(map[string]interface{}); !ok {
+		t.Fatal("expected converted test keys")
+	}
+	if !exp.IsAnomaly(measurement) {
+		t.Fatal("we forgot that the measurement is an anomaly")
+	}
+}
+
+func TestExperimentSubmitConvertedMeasurement(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	sess.dataFormatVersion = dataformat.Version030
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "example",
+	))
+	measurement, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exp.submitConverted(context.Background(), measurement, func(
+		ctx context.Context, m *model.Measurement) error {
+		if m.DataFormatVersion != probeservices.DefaultDataFormatVersion {
+			t.Fatal("we did not convert the measurement", m.DataFormatVersion)
+		}
+		m.ReportID, m.OOID = "xx", "yy"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if measurement.DataFormatVersion != dataformat.Version030 {
+		t.Fatal("we converted the original measurement")
+	}
+	if measurement.ReportID != "xx" || measurement.OOID != "yy" {
+		t.Fatal("we did not update the original measurement")
+	}
+}
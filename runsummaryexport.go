@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// runSummaryCSVHeader is the first row written by WriteCSV.
+var runSummaryCSVHeader = []string{
+	"test_name", "input", "start_time", "runtime", "anomaly", "accessible",
+	"blocking", "failure", "kibi_bytes_received", "kibi_bytes_sent",
+}
+
+// WriteCSV writes a CSV header row followed by a row for each measurement
+// in rs.Measurements. We leave the accessible column empty when the
+// experiment does not tell whether the input is accessible.
+func (rs *RunSummary) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(runSummaryCSVHeader); err != nil {
+		return err
+	}
+	for _, ms := range rs.Measurements {
+		var accessible string
+		if ms.Accessible != nil {
+			accessible = strconv.FormatBool(*ms.Accessible)
+		}
+		err := writer.Write([]string{
+			ms.TestName,
+			ms.Input,
+			ms.StartTime.UTC().Format(time.RFC3339Nano),
+			strconv.FormatFloat(ms.Runtime, 'f', -1, 64),
+			strconv.FormatBool(ms.Anomaly),
+			accessible,
+			ms.Blocking,
+			ms.Failure,
+			strconv.FormatFloat(ms.KibiBytesReceived, 'f', -1, 64),
+			strconv.FormatFloat(ms.KibiBytesSent, 'f', -1, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSONL writes each measurement in rs.Measurements as a JSON
+// object followed by a newline.
+func (rs *RunSummary) WriteJSONL(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, ms := range rs.Measurements {
+		if err := encoder.Encode(ms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+func newRunSummaryForExport() *RunSummary {
+	rs := newRunSummary()
+	start := time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC)
+	accessible := false
+	rs.measured("web_connectivity", &model.Measurement{
+		Input: "https://www.example.com/",
+		TestKeys: map[string]interface{}{
+			"accessible": &accessible,
+			"blocking":   "dns",
+		},
+	}, start, start.Add(1500*time.Millisecond), 10.5, 1.25, true, nil)
+	rs.measured("web_connectivity", &model.Measurement{
+		Input:    "https://www.example.org/",
+		TestKeys: map[string]interface{}{"blocking": false},
+	}, start.Add(2*time.Second), start.Add(3*time.Second), 4, 1, false, nil)
+	rs.measured("example", &model.Measurement{TestKeys: 17},
+		start.Add(4*time.Second), start.Add(5*time.Second), 0, 0, false,
+		errors.New("generic_timeout_error"))
+	return rs.snapshot()
+}
+
+func TestRunSummaryMeasurements(t *testing.T) {
+	summary := newRunSummaryForExport()
+	if len(summary.Measurements) != 3 {
+		t.Fatal("unexpected number of measurements")
+	}
+	first := summary.Measurements[0]
+	if first.Accessible == nil || *first.Accessible || first.Blocking != "dns" ||
+		!first.Anomaly || first.Runtime != 1.5 || first.Input != "https://www.example.com/" {
+		t.Fatalf("unexpected first measurement: %+v", first)
+	}
+	second := summary.Measurements[1]
+	if second.Accessible != nil || second.Blocking != "false" || second.Failure != "" {
+		t.Fatalf("unexpected second measurement: %+v", second)
+	}
+	third := summary.Measurements[2]
+	if third.Blocking != "" || third.Failure != "generic_timeout_error" || third.TestName != "example" {
+		t.Fatalf("unexpected third measurement: %+v", third)
+	}
+}
+
+func TestRunSummaryWriteCSV(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := newRunSummaryForExport().WriteCSV(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || len(records[0]) != len(runSummaryCSVHeader) {
+		t.Fatal("unexpected number of records or fields")
+	}
+	expected := []string{
+		"web_connectivity", "https://www.example.com/", "2020-09-01T10:00:00Z",
+		"1.5", "true", "false", "dns", "", "10.5", "1.25",
+	}
+	for idx, value := range expected {
+		if records[1][idx] != value {
+			t.Fatalf("unexpected value for %s: %s", records[0][idx], records[1][idx])
+		}
+	}
+	if records[2][5] != "" || records[3][7] != "generic_timeout_error" {
+		t.Fatal("unexpected accessible or failure values")
+	}
+}
+
+func TestRunSummaryWriteJSONL(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := newRunSummaryForExport().WriteJSONL(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	var lines []MeasurementSummary
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		var ms MeasurementSummary
+		if err := json.Unmarshal(scanner.Bytes(), &ms); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, ms)
+	}
+	if len(lines) != 3 || lines[0].Blocking != "dns" || lines[2].Failure == "" {
+		t.Fatalf("unexpected lines: %+v", lines)
+	}
+}
+
+func TestRunSummaryWriteCSVEmpty(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := newRunSummary().snapshot().WriteCSV(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatal("expected just the header")
+	}
+}
@@ -0,0 +1,243 @@
+// Package scheduler runs experiments periodically and unattended. It
+// allows running probe-engine as a daemon without an external cron.
+//
+// The caller provides a Plan, typically derived from what the probe
+// services tell us to measure, and a RunFunc that knows how to run each
+// Entry of the plan, e.g., using an engine.Session. We run each entry
+// approximately every Entry.Interval, randomizing the interval to avoid
+// all probes contacting the backend at the same time, and we persist the
+// time of the last run into the key-value store, so that restarting the
+// daemon does not cause us to run all the experiments again.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// DefaultJitter is the default value of Config.Jitter.
+const DefaultJitter = 0.2
+
+// StateKey is the key-value store key where we save the scheduler state.
+const StateKey = "scheduler.state"
+
+var (
+	// ErrAlreadyStarted indicates that the scheduler is already running.
+	ErrAlreadyStarted = errors.New("scheduler: already started")
+
+	// ErrInvalidPlan indicates that the plan is empty, or that an entry
+	// has an empty or duplicate name, or a non-positive interval.
+	ErrInvalidPlan = errors.New("scheduler: invalid plan")
+)
+
+// Entry is an experiment that the scheduler should run periodically.
+type Entry struct {
+	// Name is the experiment name. It must be unique within
+	// a plan, since we use it to persist the last run time.
+	Name string
+
+	// Inputs contains the experiment inputs, if any.
+	Inputs []string
+
+	// Interval is the average interval between two runs.
+	Interval time.Duration
+}
+
+// Plan contains the entries to run.
+type Plan []Entry
+
+// RunFunc runs an entry of the plan. The context is canceled when
+// the scheduler is stopped, so the function should honour it.
+type RunFunc func(ctx context.Context, entry Entry) error
+
+// Config contains the scheduler config. The KVStore, Logger, Plan, and
+// Run fields are mandatory. Jitter is the fraction of Entry.Interval by
+// which we randomize each interval (if zero, we use DefaultJitter).
+type Config struct {
+	Jitter  float64
+	KVStore model.KeyValueStore
+	Logger  model.Logger
+	Plan    Plan
+	Run     RunFunc
+}
+
+// Scheduler runs the entries of a plan periodically.
+type Scheduler struct {
+	cancel  context.CancelFunc
+	config  Config
+	done    chan struct{}
+	lastRun map[string]time.Time
+	mu      sync.Mutex
+	nextRun map[string]time.Time
+	rnd     *rand.Rand
+}
+
+// New creates a new Scheduler with the specified config.
+func New(config Config) *Scheduler {
+	if config.Jitter <= 0 {
+		config.Jitter = DefaultJitter
+	}
+	return &Scheduler{
+		config:  config,
+		lastRun: make(map[string]time.Time),
+		nextRun: make(map[string]time.Time),
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Start starts running the plan in a background goroutine. It returns
+// an error if the plan is invalid or the scheduler is already running.
+func (s *Scheduler) Start() error {
+	if err := s.config.Plan.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return ErrAlreadyStarted
+	}
+	s.loadState()
+	now := time.Now()
+	for _, entry := range s.config.Plan {
+		last, found := s.lastRun[entry.Name]
+		if !found {
+			// Do not run everything at startup: spread the first
+			// runs over the first interval of each entry.
+			s.nextRun[entry.Name] = now.Add(s.randomDuration(entry.Interval, 0, 1))
+			continue
+		}
+		s.nextRun[entry.Name] = last.Add(s.randomInterval(entry.Interval))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done = cancel, make(chan struct{})
+	go s.loop(ctx, s.done)
+	return nil
+}
+
+// Stop stops the scheduler, interrupting the running entry, if any,
+// and waits for the background goroutine to terminate. It is safe to
+// call Stop when the scheduler is not running.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// LastRun returns the time when we last run the entry with the specified
+// name, and whether we have ever run such entry.
+func (s *Scheduler) LastRun(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, found := s.lastRun[name]
+	return last, found
+}
+
+func (s *Scheduler) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		entry, when := s.next()
+		timer := time.NewTimer(time.Until(when))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.config.Logger.Infof("scheduler: running %s", entry.Name)
+		if err := s.config.Run(ctx, entry); err != nil {
+			s.config.Logger.Warnf("scheduler: %s failed: %s", entry.Name, err.Error())
+		}
+		if ctx.Err() != nil {
+			return // do not record interrupted runs
+		}
+		s.ran(entry)
+	}
+}
+
+// next returns the entry that should run first and when.
+func (s *Scheduler) next() (entry Entry, when time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for idx, e := range s.config.Plan {
+		if t := s.nextRun[e.Name]; idx == 0 || t.Before(when) {
+			entry, when = e, t
+		}
+	}
+	return
+}
+
+// ran records that we have run entry and schedules its next run.
+func (s *Scheduler) ran(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.lastRun[entry.Name] = now
+	s.nextRun[entry.Name] = now.Add(s.randomInterval(entry.Interval))
+	s.saveState()
+}
+
+// randomInterval returns interval randomized by the configured jitter.
+func (s *Scheduler) randomInterval(interval time.Duration) time.Duration {
+	return s.randomDuration(interval, 1-s.config.Jitter, 1+s.config.Jitter)
+}
+
+// randomDuration returns a random duration between min*d and max*d.
+func (s *Scheduler) randomDuration(d time.Duration, min, max float64) time.Duration {
+	return time.Duration(float64(d) * (min + s.rnd.Float64()*(max-min)))
+}
+
+// state is the scheduler state saved into the key-value store.
+type state struct {
+	LastRun map[string]time.Time `json:"last_run"`
+}
+
+func (s *Scheduler) loadState() {
+	data, err := s.config.KVStore.Get(StateKey)
+	if err != nil {
+		return // most likely we have never run before
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		s.config.Logger.Warnf("scheduler: cannot parse state: %s", err.Error())
+		return
+	}
+	for name, last := range st.LastRun {
+		s.lastRun[name] = last
+	}
+}
+
+func (s *Scheduler) saveState() {
+	data, err := json.Marshal(state{LastRun: s.lastRun})
+	if err != nil {
+		s.config.Logger.Warnf("scheduler: cannot serialize state: %s", err.Error())
+		return
+	}
+	if err := s.config.KVStore.Set(StateKey, data); err != nil {
+		s.config.Logger.Warnf("scheduler: cannot save state: %s", err.Error())
+	}
+}
+
+func (p Plan) validate() error {
+	if len(p) <= 0 {
+		return ErrInvalidPlan
+	}
+	names := make(map[string]bool)
+	for _, entry := range p {
+		if entry.Name == "" || entry.Interval <= 0 || names[entry.Name] {
+			return ErrInvalidPlan
+		}
+		names[entry.Name] = true
+	}
+	return nil
+}
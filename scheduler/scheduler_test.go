@@ -0,0 +1,182 @@
+package scheduler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/scheduler"
+)
+
+type runCounter struct {
+	ch    chan scheduler.Entry
+	count int
+	mu    sync.Mutex
+}
+
+func newRunCounter() *runCounter {
+	return &runCounter{ch: make(chan scheduler.Entry, 128)}
+}
+
+func (rc *runCounter) Run(ctx context.Context, entry scheduler.Entry) error {
+	rc.mu.Lock()
+	rc.count++
+	rc.mu.Unlock()
+	rc.ch <- entry
+	return errors.New("mocked error") // should not stop the scheduler
+}
+
+func TestSchedulerRunsPeriodically(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	rc := newRunCounter()
+	sched := scheduler.New(scheduler.Config{
+		KVStore: kvs,
+		Logger:  log.Log,
+		Plan: scheduler.Plan{{
+			Name:     "example",
+			Inputs:   []string{"https://www.example.com"},
+			Interval: 50 * time.Millisecond,
+		}},
+		Run: rc.Run,
+	})
+	if err := sched.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for idx := 0; idx < 3; idx++ {
+		select {
+		case entry := <-rc.ch:
+			if entry.Name != "example" || len(entry.Inputs) != 1 {
+				t.Fatal("not the entry we expected")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the scheduler did not run the entry")
+		}
+	}
+	sched.Stop()
+	if _, found := sched.LastRun("example"); !found {
+		t.Fatal("expected to find the last run")
+	}
+	data, err := kvs.Get(scheduler.StateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state struct {
+		LastRun map[string]time.Time `json:"last_run"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := state.LastRun["example"]; !found {
+		t.Fatal("expected to find the last run in the state")
+	}
+}
+
+func TestSchedulerHonoursPersistedState(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	data, err := json.Marshal(map[string]interface{}{
+		"last_run": map[string]time.Time{
+			"recent": time.Now(),
+			"stale":  time.Now().Add(-24 * time.Hour),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Set(scheduler.StateKey, data); err != nil {
+		t.Fatal(err)
+	}
+	rc := newRunCounter()
+	sched := scheduler.New(scheduler.Config{
+		KVStore: kvs,
+		Logger:  log.Log,
+		Plan: scheduler.Plan{{
+			Name:     "recent",
+			Interval: time.Hour,
+		}, {
+			Name:     "stale",
+			Interval: time.Hour,
+		}},
+		Run: rc.Run,
+	})
+	if err := sched.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sched.Stop()
+	select {
+	case entry := <-rc.ch:
+		if entry.Name != "stale" {
+			t.Fatal("not the entry we expected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scheduler did not run the stale entry")
+	}
+	select {
+	case entry := <-rc.ch:
+		t.Fatalf("unexpected run of %s", entry.Name)
+	case <-time.After(250 * time.Millisecond):
+	}
+}
+
+func TestSchedulerStopInterruptsRun(t *testing.T) {
+	started := make(chan struct{})
+	sched := scheduler.New(scheduler.Config{
+		KVStore: kvstore.NewMemoryKeyValueStore(),
+		Logger:  log.Log,
+		Plan:    scheduler.Plan{{Name: "example", Interval: time.Millisecond}},
+		Run: func(ctx context.Context, entry scheduler.Entry) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	if err := sched.Start(); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	sched.Stop()
+	if _, found := sched.LastRun("example"); found {
+		t.Fatal("we should not record interrupted runs")
+	}
+	sched.Stop() // must be idempotent
+}
+
+func TestSchedulerAlreadyStarted(t *testing.T) {
+	sched := scheduler.New(scheduler.Config{
+		KVStore: kvstore.NewMemoryKeyValueStore(),
+		Logger:  log.Log,
+		Plan:    scheduler.Plan{{Name: "example", Interval: time.Hour}},
+		Run:     newRunCounter().Run,
+	})
+	if err := sched.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sched.Stop()
+	if err := sched.Start(); !errors.Is(err, scheduler.ErrAlreadyStarted) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestSchedulerInvalidPlan(t *testing.T) {
+	plans := []scheduler.Plan{
+		nil,
+		{{Name: "", Interval: time.Hour}},
+		{{Name: "example", Interval: 0}},
+		{{Name: "example", Interval: time.Hour}, {Name: "example", Interval: time.Hour}},
+	}
+	for _, plan := range plans {
+		sched := scheduler.New(scheduler.Config{
+			KVStore: kvstore.NewMemoryKeyValueStore(),
+			Logger:  log.Log,
+			Plan:    plan,
+			Run:     newRunCounter().Run,
+		})
+		if err := sched.Start(); !errors.Is(err, scheduler.ErrInvalidPlan) {
+			t.Fatal("not the error we expected")
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Names of the checks performed by Session.SelfTest.
+const (
+	HealthCheckResources     = "resources"
+	HealthCheckGeolocation   = "geolocation"
+	HealthCheckResolver      = "resolver"
+	HealthCheckProbeServices = "probe_services"
+	HealthCheckClock         = "clock"
+)
+
+// MaxClockOffset is the maximum offset between the clock of the probe and
+// the clock of the probe services that Session.SelfTest considers sane.
+const MaxClockOffset = 5 * time.Minute
+
+var (
+	// ErrClockOffset indicates that the clock of the probe is not sane.
+	ErrClockOffset = errors.New("engine: clock offset is too large")
+
+	// ErrNoDateHeader indicates that the probe services did not send
+	// us the Date header, so we cannot check the clock.
+	ErrNoDateHeader = errors.New("engine: missing or invalid Date header")
+)
+
+// HealthCheck is the result of a single check performed by SelfTest. The
+// Failure is empty when the check succeeded.
+type HealthCheck struct {
+	Failure string  `json:"failure"`
+	Name    string  `json:"name"`
+	Runtime float64 `json:"runtime"`
+}
+
+// HealthReport is the result of Session.SelfTest. Checks contains the
+// checks in the order in which we performed them. ClockOffset is the offset
+// in seconds between the clock of the probe services and our clock, which
+// is zero when we could not read the clock of the probe services. Healthy
+// is true when all the checks succeeded.
+type HealthReport struct {
+	Checks        []HealthCheck `json:"checks"`
+	ClockOffset   float64       `json:"clock_offset"`
+	EngineVersion string        `json:"engine_version"`
+	Healthy       bool          `json:"healthy"`
+	Time          time.Time     `json:"time"`
+}
+
+// SelfTest checks whether the resources are present and current, whether
+// we can geolocate the probe, whether the session resolver bootstraps,
+// whether the probe services respond, and whether our clock is sane. We
+// run all the checks even if some of them fail, so that the report is
+// useful for support workflows.
+func (s *Session) SelfTest(ctx context.Context) *HealthReport {
+	report := &HealthReport{
+		EngineVersion: Version,
+		Healthy:       true,
+		Time:          time.Now().UTC(),
+	}
+	checks := []struct {
+		name string
+		fn   func(ctx context.Context) error
+	}{
+		{HealthCheckResources, func(ctx context.Context) error {
+			return s.newResourcesClient().Verify()
+		}},
+		{HealthCheckGeolocation, s.maybeLookupLocation},
+		{HealthCheckResolver, s.selfTestResolver},
+		{HealthCheckProbeServices, s.maybeLookupBackends},
+		{HealthCheckClock, func(ctx context.Context) (err error) {
+			report.ClockOffset, err = s.selfTestClock(ctx)
+			return
+		}},
+	}
+	for _, check := range checks {
+		start := time.Now()
+		err := check.fn(ctx)
+		result := HealthCheck{Name: check.name, Runtime: time.Since(start).Seconds()}
+		if err != nil {
+			s.logger.Warnf("session: self test: %s: %s", check.name, err.Error())
+			result.Failure = err.Error()
+			report.Healthy = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+// selfTestResolver checks whether the session resolver can resolve the
+// domain of the first probe service we know about.
+func (s *Session) selfTestResolver(ctx context.Context) error {
+	URL, err := url.Parse(s.getAvailableProbeServices()[0].Address)
+	if err != nil {
+		return err
+	}
+	_, err = s.resolver.LookupHost(ctx, URL.Hostname())
+	return err
+}
+
+// selfTestClock returns the offset between the clock of the selected
+// probe service, as told by the Date header, and our clock.
+func (s *Session) selfTestClock(ctx context.Context) (float64, error) {
+	clnt, err := s.newProbeServicesClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	request, err := clnt.NewRequest(ctx, "GET", "/", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	response, err := clnt.HTTPClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	// The Date header has a one second resolution, therefore using the
+	// middle of the round trip as our clock is accurate enough.
+	now := start.Add(time.Since(start) / 2)
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, ErrNoDateHeader
+	}
+	offset := date.Sub(now)
+	if offset > MaxClockOffset || offset < -MaxClockOffset {
+		return offset.Seconds(), fmt.Errorf("%w: %s", ErrClockOffset, offset)
+	}
+	return offset.Seconds(), nil
+}
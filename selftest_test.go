@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+// newSelfTestSession returns a session using a local probe services
+// whose clock is offset from our clock by the given amount.
+func newSelfTestSession(t *testing.T, offset time.Duration) (*Session, func()) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+			if r.URL.Path != "/api/v1/test-helpers" {
+				w.WriteHeader(404)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string][]model.Service{})
+		}))
+	rootCAs := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+	sess, err := NewSession(SessionConfig{
+		AssetsDir: "testdata",
+		BackendProfile: &BackendProfile{
+			ProbeServicesURL: server.URL,
+			RootCAs:          rootCAs,
+		},
+		Logger:          log.Log,
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return sess, func() {
+		sess.Close()
+		server.Close()
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	sess, cleanup := newSelfTestSession(t, 0)
+	defer cleanup()
+	report := sess.SelfTest(context.Background())
+	expected := []string{
+		HealthCheckResources, HealthCheckGeolocation, HealthCheckResolver,
+		HealthCheckProbeServices, HealthCheckClock,
+	}
+	if len(report.Checks) != len(expected) {
+		t.Fatal("unexpected number of checks")
+	}
+	for idx, check := range report.Checks {
+		if check.Name != expected[idx] {
+			t.Fatal("unexpected check", check.Name)
+		}
+		if check.Failure != "" && report.Healthy {
+			t.Fatal("report should not be healthy")
+		}
+	}
+	if report.Checks[3].Failure != "" || report.Checks[4].Failure != "" {
+		t.Fatalf("unexpected failures: %+v", report.Checks)
+	}
+	if report.ClockOffset < -2 || report.ClockOffset > 2 {
+		t.Fatal("unexpected clock offset", report.ClockOffset)
+	}
+	if report.EngineVersion != Version || report.Time.IsZero() {
+		t.Fatal("unexpected report metadata")
+	}
+}
+
+func TestSelfTestClockOffset(t *testing.T) {
+	sess, cleanup := newSelfTestSession(t, -time.Hour)
+	defer cleanup()
+	offset, err := sess.selfTestClock(context.Background())
+	if !errors.Is(err, ErrClockOffset) {
+		t.Fatal("not the error we expected", err)
+	}
+	if offset > -3500 {
+		t.Fatal("unexpected clock offset", offset)
+	}
+}
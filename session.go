@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -12,60 +13,295 @@ import (
 	"sync"
 	"time"
 
+	"github.com/apex/log"
 	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/eventbus"
 	"github.com/ooni/probe-engine/geolocate"
+	"github.com/ooni/probe-engine/internal/circumvention"
 	"github.com/ooni/probe-engine/internal/httpheader"
 	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/internal/platform"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/internal/sessionresolver"
-	"github.com/ooni/probe-engine/internal/sessiontunnel"
+	"github.com/ooni/probe-engine/internal/sink"
+	"github.com/ooni/probe-engine/internal/torx"
+	"github.com/ooni/probe-engine/internal/tunnel"
+	"github.com/ooni/probe-engine/logx"
+	"github.com/ooni/probe-engine/measurementdb"
+	"github.com/ooni/probe-engine/metrics"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/model/dataformat"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/probeservices"
 	"github.com/ooni/probe-engine/resources"
 )
 
-// SessionConfig contains the Session config
+// SessionConfig contains the Session config.
 type SessionConfig struct {
-	AssetsDir              string
+	// Annotations are added to every measurement (see ValidateAnnotations
+	// for the keys you cannot use).
+	Annotations map[string]string
+
+	// AssetsDir is the directory where we keep the resources (e.g., the
+	// ASN and country databases).
+	AssetsDir string
+
+	// AvailableProbeServices contains the probe services to use. When
+	// empty, we use the default probe services.
 	AvailableProbeServices []model.Service
-	KVStore                KVStore
-	Logger                 model.Logger
-	PrivacySettings        model.PrivacySettings
-	ProxyURL               *url.URL
-	SoftwareName           string
-	SoftwareVersion        string
-	TempDir                string
-	TorArgs                []string
-	TorBinary              string
+
+	// BackendProfile, if not nil, describes the OONI compatible backend
+	// to use (see BackendProfile). AvailableProbeServices must then be empty.
+	BackendProfile *BackendProfile
+
+	// CrashDir, if not empty, is the directory where we save a crash file
+	// for each experiment that panics, keeping the most recent ones.
+	CrashDir string
+
+	// CrashReportsURL is the full URL of the endpoint to which
+	// SubmitCrashReports submits the crash files.
+	CrashReportsURL string
+
+	// DataCapKiB, if positive, is the number of KiB that the session may
+	// send and receive, after which we interrupt the running measurement
+	// and refuse to start new measurements.
+	DataCapKiB float64
+
+	// DataFormatVersion is the data format version of the measurements
+	// (see Session.DataFormatVersion). When empty, we use
+	// probeservices.DefaultDataFormatVersion.
+	DataFormatVersion string
+
+	// DryRunFile, if not empty, tells experiments to never contact the
+	// collector. Instead, they use a fake report ID (see DryRunReportIDPrefix)
+	// and append to DryRunFile the measurements they would have submitted.
+	DryRunFile string
+
+	// EnableCrashReports tells whether the user opted in to submitting
+	// crash reports using SubmitCrashReports.
+	EnableCrashReports bool
+
+	// EnableMetrics tells whether the user opted in to submitting the
+	// engine metrics (see Session.MetricsEnabled).
+	EnableMetrics bool
+
+	// KVStore is the key-value store where we save the state of the
+	// session. When nil, we use KVStorePath or a memory store.
+	KVStore KVStore
+
+	// KVStorePath, if not empty and KVStore is nil, is the path of the
+	// bbolt database to use as KVStore (see NewBoltKVStore), which we
+	// close in Close.
+	KVStorePath string
+
+	// LiteMode configures the session for low-end devices: experiments
+	// save smaller HTTP body snapshots and do not save read and write
+	// events, RunBatch runs a single experiment at a time by default, and
+	// InputLoader fetches fewer URLs from the probe services.
+	LiteMode bool
+
+	// LogLevel is the minimum level of the log lines that we include
+	// into diagnostics (see Diagnostics), which should be the level of
+	// Logger. When zero, we include all the log lines.
+	LogLevel log.Level
+
+	// Logger is the logger to use.
+	Logger model.Logger
+
+	// MeasurementDB, if not nil, is where we record the metadata of each
+	// measurement (see the measurementdb package). You are responsible
+	// for closing it.
+	MeasurementDB *measurementdb.DB
+
+	// MeasurementDBSave tells whether we also save each measurement
+	// into MeasurementDB.
+	MeasurementDBSave bool
+
+	// MeasurementWatchdog is the maximum runtime of a single measurement,
+	// after which we give up on the experiment even if it ignores the
+	// cancellation of its context, and report the stacks of the goroutines
+	// as a diagnostic. When zero, we use DefaultMeasurementWatchdog and
+	// when negative we disable the watchdog.
+	MeasurementWatchdog time.Duration
+
+	// OBFS4ProxyBinary is the obfs4proxy binary used by the "tor" tunnel
+	// to connect to the TorBridges.
+	OBFS4ProxyBinary string
+
+	// OfflineLocation, if not nil, contains the CountryCode, ASN, and
+	// NetworkName to use instead of discovering the probe location using
+	// the network, after we validate them against the ASN and country
+	// databases. This is useful inside testbeds with no real connectivity.
+	OfflineLocation *model.LocationInfo
+
+	// PrivacySettings tells which location fields we include into the
+	// measurements.
+	PrivacySettings model.PrivacySettings
+
+	// Prometheus, if not nil, contains the metrics that we update, which
+	// you may expose using metrics.Listen (see the metrics package).
+	Prometheus *metrics.Engine
+
+	// ProxyURL, if not nil, is the proxy to use (see Routing).
+	ProxyURL *url.URL
+
+	// ResourcesUpdateInterval, if positive, is the interval after which
+	// we check in the background for updated resources (e.g. the ASN and
+	// country databases) during the session lifetime.
+	ResourcesUpdateInterval time.Duration
+
+	// Routing tells which traffic uses the proxy or the tunnel
+	// (see RoutingPolicy).
+	Routing RoutingPolicy
+
+	// SinkDir, if not empty, is the directory where we also write each
+	// measurement into newline-delimited JSON files (see Close).
+	SinkDir string
+
+	// SinkGzip tells whether we compress the files inside SinkDir.
+	SinkGzip bool
+
+	// SinkMaxFileSize is the number of bytes after which we rotate
+	// the files inside SinkDir.
+	SinkMaxFileSize int64
+
+	// SnowflakeBrokerURL is the broker URL used by the "snowflake"
+	// tunnel (see MaybeStartTunnel and the tunnel package).
+	SnowflakeBrokerURL string
+
+	// SnowflakeClientBinary is the client binary used by the
+	// "snowflake" tunnel.
+	SnowflakeClientBinary string
+
+	// SnowflakeFrontDomain is the domain fronting the broker of the
+	// "snowflake" tunnel.
+	SnowflakeFrontDomain string
+
+	// SnowflakeSTUNServers are the STUN servers used by the
+	// "snowflake" tunnel.
+	SnowflakeSTUNServers []string
+
+	// SoftwareName is the name of the application.
+	SoftwareName string
+
+	// SoftwareVersion is the version of the application.
+	SoftwareVersion string
+
+	// StateEncryptionKey, if not nil, is the key used to encrypt the
+	// orchestra credentials saved into KVStore (see
+	// probeservices.NewEncryptedStateFile).
+	StateEncryptionKey []byte
+
+	// TempDir is the directory inside which we create the temporary
+	// directory of the session. When empty, we use the system default.
+	TempDir string
+
+	// TorArgs contains additional arguments for tor.
+	TorArgs []string
+
+	// TorBinary is the tor binary to use.
+	TorBinary string
+
+	// TorBridges contains the obfs4 bridges used by the "tor" tunnel
+	// (see MaybeStartTunnel and the tunnel package).
+	TorBridges []string
+
+	// Tracer, if not nil, traces the major operations of the session
+	// and of its experiments (see model.Tracer).
+	Tracer model.Tracer
+
+	// TunnelCallbacks, if not nil, receives events while the session
+	// starts a tunnel.
+	TunnelCallbacks TunnelCallbacks
+
+	// UploadCompression is the compression used to submit measurements,
+	// which must be one of the probeservices.Compression constants.
+	UploadCompression string
+}
+
+// TunnelCallbacks contains the callbacks invoked while the session starts
+// a tunnel, including when it starts a tunnel to reach the OONI backend, so
+// that apps can show the bootstrap progress. The percentage is between zero
+// and one. These callbacks are invoked from background goroutines.
+type TunnelCallbacks interface {
+	OnTunnelStart(name string)
+	OnTunnelProgress(name string, percentage float64, message string)
+	OnTunnelFailure(name string, err error)
 }
 
 // Session is a measurement session
 type Session struct {
+	annotations              map[string]string
 	assetsDir                string
 	availableProbeServices   []model.Service
 	availableTestHelpers     map[string][]model.Service
+	backendCertPool          *x509.CertPool
+	backendChannel           string
+	backendChannelMu         sync.Mutex
+	backendProfile           *BackendProfile
+	boltKVStore              *BoltKVStore
+	bestTestHelpers          map[string]model.Service
+	bestTestHelpersMu        sync.Mutex
 	byteCounter              *bytecounter.Counter
+	cache                    *kvstore.TTLKeyValueStore
+	crashDir                 string
+	crashReportsEnabled      bool
+	crashReportsURL          string
+	dataCapKiB               float64
+	dataFormatVersion        string
+	diagnostics              []Diagnostic
+	diagnosticsMu            sync.Mutex
+	dryRunFile               string
+	eventBus                 *eventbus.Bus
 	httpDefaultTransport     netx.HTTPRoundTripper
+	httpSwitchableTransport  *switchableTransport
 	kvStore                  model.KeyValueStore
+	liteMode                 bool
+	metricsEnabled           bool
+	obfs4ProxyBinary         string
+	offlineLocation          *model.LocationInfo
 	privacySettings          model.PrivacySettings
+	prometheus               *metrics.Engine
 	location                 *model.LocationInfo
+	locationMu               sync.Mutex
+	logCapturer              *logx.Capturer
 	logger                   model.Logger
+	measurementDB            *measurementdb.DB
+	measurementDBSave        bool
+	natType                  string
+	natTypeMu                sync.Mutex
+	measurementWatchdog      time.Duration
+	proxyMu                  sync.Mutex
 	proxyURL                 *url.URL
 	queryProbeServicesCount  *atomicx.Int64
+	queryProbeServicesOK     *atomicx.Int64
 	resolver                 *sessionresolver.Resolver
+	routing                  RoutingPolicy
+	runSummary               *runSummary
+	runtimesMu               sync.Mutex
 	selectedProbeServiceHook func(*model.Service)
 	selectedProbeService     *model.Service
+	sink                     *sink.Sink
+	snowflake                torx.SnowflakeConfig
 	softwareName             string
 	softwareVersion          string
+	stateEncryptionKey       []byte
+	stopResourcesUpdater     context.CancelFunc
+	stopTokenRefresher       context.CancelFunc
+	submissionsFailed        *atomicx.Int64
+	submitter                *probeservices.Submitter
 	tempDir                  string
+	tokenRefresherMu         sync.Mutex
 	torArgs                  []string
 	torBinary                string
+	torBridges               []string
+	tracer                   model.Tracer
+	tunnelCallbacks          TunnelCallbacks
 	tunnelMu                 sync.Mutex
 	tunnelName               string
-	tunnel                   sessiontunnel.Tunnel
+	tunnel                   tunnel.Tunnel
+	uploadCompression        string
 }
 
 // NewSession creates a new session or returns an error
@@ -82,50 +318,273 @@ func NewSession(config SessionConfig) (*Session, error) {
 	if config.SoftwareVersion == "" {
 		return nil, errors.New("SoftwareVersion is empty")
 	}
+	if err := ValidateAnnotations(config.Annotations); err != nil {
+		return nil, err
+	}
+	if err := config.Routing.Validate(); err != nil {
+		return nil, err
+	}
+	if config.BackendProfile != nil {
+		if len(config.AvailableProbeServices) > 0 {
+			return nil, fmt.Errorf(
+				"%w: cannot use AvailableProbeServices", ErrInvalidBackendProfile)
+		}
+		if err := config.BackendProfile.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if config.DataFormatVersion == "" {
+		config.DataFormatVersion = probeservices.DefaultDataFormatVersion
+	}
+	if !dataformat.IsSupported(config.DataFormatVersion) {
+		return nil, dataformat.ErrUnsupportedVersion
+	}
+	if !probeservices.IsSupportedCompression(config.UploadCompression) {
+		return nil, probeservices.ErrUnsupportedCompression
+	}
+	if config.CrashReportsURL != "" {
+		if err := probeservices.ValidateCrashReportsURL(config.CrashReportsURL); err != nil {
+			return nil, err
+		}
+	}
+	if config.MeasurementWatchdog == 0 {
+		config.MeasurementWatchdog = DefaultMeasurementWatchdog
+	}
+	var measurementSink *sink.Sink
+	if config.SinkDir != "" {
+		var err error
+		measurementSink, err = sink.New(sink.Config{
+			Dir:         config.SinkDir,
+			Gzip:        config.SinkGzip,
+			MaxFileSize: config.SinkMaxFileSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var boltKVStore *BoltKVStore
+	if config.KVStore == nil && config.KVStorePath != "" {
+		var err error
+		if boltKVStore, err = NewBoltKVStore(config.KVStorePath); err != nil {
+			return nil, err
+		}
+		config.KVStore = boltKVStore
+	}
 	if config.KVStore == nil {
 		config.KVStore = kvstore.NewMemoryKeyValueStore()
 	}
+	if config.StateEncryptionKey != nil {
+		if _, err := kvstore.NewEncryptedKeyValueStore(
+			config.KVStore, config.StateEncryptionKey); err != nil {
+			closeBoltKVStore(boltKVStore)
+			return nil, err
+		}
+	}
 	// Implementation note: if config.TempDir is empty, then Go will
 	// use the temporary directory on the current system. This should
 	// work on Desktop. We tested that it did also work on iOS, but
 	// we have also seen on 2020-06-10 that it does not work on Android.
 	tempDir, err := ioutil.TempDir(config.TempDir, "ooniengine")
 	if err != nil {
+		closeBoltKVStore(boltKVStore)
 		return nil, err
 	}
+	logCapturer := logx.NewCapturer(config.Logger, config.LogLevel, diagnosticLogLines)
 	sess := &Session{
+		annotations:             mergeAnnotations(config.Annotations),
 		assetsDir:               config.AssetsDir,
 		availableProbeServices:  config.AvailableProbeServices,
+		backendProfile:          config.BackendProfile,
+		boltKVStore:             boltKVStore,
 		byteCounter:             bytecounter.New(),
+		cache:                   newCache(config.KVStore, config.Logger),
+		crashDir:                config.CrashDir,
+		crashReportsEnabled:     config.EnableCrashReports,
+		crashReportsURL:         config.CrashReportsURL,
+		dataCapKiB:              config.DataCapKiB,
+		dataFormatVersion:       config.DataFormatVersion,
+		dryRunFile:              config.DryRunFile,
+		eventBus:                eventbus.New(),
 		kvStore:                 config.KVStore,
+		liteMode:                config.LiteMode,
+		metricsEnabled:          config.EnableMetrics,
+		offlineLocation:         config.OfflineLocation,
 		privacySettings:         config.PrivacySettings,
-		logger:                  config.Logger,
+		prometheus:              config.Prometheus,
+		logCapturer:             logCapturer,
+		logger:                  model.WithComponent(logCapturer, "session"),
+		measurementDB:           config.MeasurementDB,
+		measurementDBSave:       config.MeasurementDBSave,
+		measurementWatchdog:     config.MeasurementWatchdog,
+		obfs4ProxyBinary:        config.OBFS4ProxyBinary,
 		proxyURL:                config.ProxyURL,
 		queryProbeServicesCount: atomicx.NewInt64(),
+		queryProbeServicesOK:    atomicx.NewInt64(),
+		routing:                 config.Routing,
+		runSummary:              newRunSummary(),
+		sink:                    measurementSink,
 		softwareName:            config.SoftwareName,
 		softwareVersion:         config.SoftwareVersion,
+		stateEncryptionKey:      config.StateEncryptionKey,
+		submissionsFailed:       atomicx.NewInt64(),
+		submitter:               probeservices.NewSubmitter(config.KVStore, model.WithComponent(logCapturer, "probeservices")),
 		tempDir:                 tempDir,
 		torArgs:                 config.TorArgs,
 		torBinary:               config.TorBinary,
+		torBridges:              config.TorBridges,
+		tracer:                  config.Tracer,
+		tunnelCallbacks:         config.TunnelCallbacks,
+		uploadCompression:       config.UploadCompression,
 	}
-	httpConfig := netx.Config{
+	sess.snowflake = torx.SnowflakeConfig{
+		BrokerURL:    config.SnowflakeBrokerURL,
+		ClientBinary: config.SnowflakeClientBinary,
+		FrontDomain:  config.SnowflakeFrontDomain,
+		STUNServers:  config.SnowflakeSTUNServers,
+	}
+	if config.BackendProfile != nil {
+		// cannot fail because we have already validated the profile
+		sess.backendCertPool, _ = config.BackendProfile.certPool()
+	}
+	sess.resolver = sessionresolver.New(netx.Config{
 		ByteCounter:  sess.byteCounter,
 		BogonIsError: true,
-		Logger:       sess.logger,
+		Logger:       model.WithComponent(sess.logger, "sessionresolver"),
+	})
+	if sess.prometheus != nil {
+		sess.resolver.ObserveLookup = sess.observeLookup
+	}
+	sess.httpSwitchableTransport = &switchableTransport{
+		txp: sess.newHTTPDefaultTransport(config.ProxyURL)}
+	sess.httpDefaultTransport = sess.httpSwitchableTransport
+	if config.ResourcesUpdateInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		sess.stopResourcesUpdater = cancel
+		go sess.runResourcesUpdater(ctx, config.ResourcesUpdateInterval)
 	}
-	sess.resolver = sessionresolver.New(httpConfig)
-	httpConfig.FullResolver = sess.resolver
-	httpConfig.ProxyURL = config.ProxyURL // no need to proxy the resolver
-	sess.httpDefaultTransport = netx.NewHTTPTransport(httpConfig)
 	return sess, nil
 }
 
+// newHTTPDefaultTransport creates the HTTP transport used for communicating
+// with the OONI backend, which uses the given proxy, if not nil, unless the
+// routing policy says that backend traffic goes direct. This transport
+// also trusts the CAs of the backend profile, if any.
+func (s *Session) newHTTPDefaultTransport(proxyURL *url.URL) netx.HTTPRoundTripper {
+	return s.newHTTPTransport(s.backendProxyURL(proxyURL), s.backendCertPool)
+}
+
+// switchableTransport is the session's default HTTP transport. It forwards
+// requests to the current transport, which we replace when the proxy changes,
+// so that the clients created before the proxy changed use the new proxy.
+type switchableTransport struct {
+	mu  sync.Mutex
+	txp netx.HTTPRoundTripper
+}
+
+func (t *switchableTransport) current() netx.HTTPRoundTripper {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.txp
+}
+
+func (t *switchableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current().RoundTrip(req)
+}
+
+func (t *switchableTransport) CloseIdleConnections() {
+	t.current().CloseIdleConnections()
+}
+
+// replace starts using txp and closes the idle connections of the
+// previous transport. Requests in progress use the previous transport.
+func (t *switchableTransport) replace(txp netx.HTTPRoundTripper) {
+	t.mu.Lock()
+	previous := t.txp
+	t.txp = txp
+	t.mu.Unlock()
+	previous.CloseIdleConnections()
+}
+
+// setProxyURL changes the proxy and makes sure that all the backend
+// traffic from now on uses the new proxy.
+func (s *Session) setProxyURL(proxyURL *url.URL) {
+	s.proxyMu.Lock()
+	s.proxyURL = proxyURL
+	s.proxyMu.Unlock()
+	s.httpSwitchableTransport.replace(s.newHTTPDefaultTransport(proxyURL))
+}
+
+// newHTTPTransport creates an HTTP transport using the given proxy, if not
+// nil, and the given cert pool, if not nil.
+func (s *Session) newHTTPTransport(
+	proxyURL *url.URL, certPool *x509.CertPool) netx.HTTPRoundTripper {
+	return netx.NewHTTPTransport(netx.Config{
+		ByteCounter:  s.byteCounter,
+		BogonIsError: true,
+		CertPool:     certPool,
+		FullResolver: s.resolver,
+		Logger:       model.WithComponent(s.logger, "netx"),
+		ProxyURL:     proxyURL, // no need to proxy the resolver
+	})
+}
+
+// BackendChannel returns the channel we use to communicate with the
+// OONI backend, i.e., one of the channels of the circumvention package
+// or, when the user configured them, "proxy" or the tunnel name. We
+// return an empty string if we did not select the probe services yet.
+func (s *Session) BackendChannel() string {
+	s.backendChannelMu.Lock()
+	defer s.backendChannelMu.Unlock()
+	return s.backendChannel
+}
+
+func (s *Session) setBackendChannel(channel string) {
+	s.backendChannelMu.Lock()
+	s.backendChannel = channel
+	s.backendChannelMu.Unlock()
+}
+
+// DataFormatVersion returns the data format version of the measurements
+// that we emit, which is probeservices.DefaultDataFormatVersion unless
+// SessionConfig.DataFormatVersion says otherwise. The collector does not
+// know about dataformat.Version030, so we convert the measurements back to
+// probeservices.DefaultDataFormatVersion before submitting them.
+func (s *Session) DataFormatVersion() string {
+	return s.dataFormatVersion
+}
+
+// BehindCaptivePortal returns whether the probe is behind a captive
+// portal, in which case most experiments will fail or measure the portal
+// rather than the network. When measurements use a proxy (see
+// RoutingPolicy), we don't check for captive portals, hence this function
+// returns false.
+func (s *Session) BehindCaptivePortal() bool {
+	location := s.getLocation()
+	return location != nil && location.BehindCaptivePortal
+}
+
 // ASNDatabasePath returns the path where the ASN database path should
 // be if you have called s.FetchResourcesIdempotent.
 func (s *Session) ASNDatabasePath() string {
 	return filepath.Join(s.assetsDir, resources.ASNDatabaseName)
 }
 
+// ErrDataCapExceeded indicates that the session has used more data
+// than allowed by the SessionConfig.DataCapKiB setting.
+var ErrDataCapExceeded = errors.New("session: data cap exceeded")
+
+// DataCapExceeded returns whether the session has sent and received more
+// than the SessionConfig.DataCapKiB setting. Always false without a data cap.
+func (s *Session) DataCapExceeded() bool {
+	return s.dataCapKiB > 0 && s.KibiBytesTotal() > s.dataCapKiB
+}
+
+// DryRun returns whether we are running in dry-run mode, i.e., whether
+// we are saving measurements into a file instead of submitting them.
+func (s *Session) DryRun() bool {
+	return s.dryRunFile != ""
+}
+
 // KibiBytesReceived accounts for the KibiBytes received by the HTTP clients
 // managed by this session so far, including experiments.
 func (s *Session) KibiBytesReceived() float64 {
@@ -137,6 +596,11 @@ func (s *Session) KibiBytesSent() float64 {
 	return s.byteCounter.KibiBytesSent()
 }
 
+// KibiBytesTotal is the sum of KibiBytesReceived and KibiBytesSent.
+func (s *Session) KibiBytesTotal() float64 {
+	return s.KibiBytesReceived() + s.KibiBytesSent()
+}
+
 // CABundlePath is like ASNDatabasePath but for the CA bundle path.
 func (s *Session) CABundlePath() string {
 	return filepath.Join(s.assetsDir, resources.CABundleName)
@@ -148,12 +612,66 @@ func (s *Session) CABundlePath() string {
 // cause memory leaks in your application because of open idle connections,
 // as well as excessive usage of disk space.
 func (s *Session) Close() error {
+	if s.stopResourcesUpdater != nil {
+		s.stopResourcesUpdater()
+	}
+	s.tokenRefresherMu.Lock()
+	if s.stopTokenRefresher != nil {
+		s.stopTokenRefresher()
+	}
+	s.tokenRefresherMu.Unlock()
 	s.httpDefaultTransport.CloseIdleConnections()
 	s.resolver.CloseIdleConnections()
 	if s.tunnel != nil {
 		s.tunnel.Stop()
 	}
-	return os.RemoveAll(s.tempDir)
+	var err error
+	if s.sink != nil {
+		err = s.sink.Close()
+	}
+	if s.boltKVStore != nil {
+		if closeErr := s.boltKVStore.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if removeErr := os.RemoveAll(s.tempDir); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// writeToSink writes measurement into the measurements sink, if any.
+func (s *Session) writeToSink(measurement *model.Measurement) {
+	if s.sink == nil {
+		return
+	}
+	if err := s.sink.Write(measurement); err != nil {
+		s.logger.Warnf("sink: cannot write measurement: %s", err.Error())
+	}
+}
+
+// CloseReport closes the report with the specified ID, e.g., a report
+// that we have opened during a previous session.
+func (s *Session) CloseReport(ctx context.Context, reportID string) error {
+	ctx = s.withTracer(ctx)
+	clnt, err := s.newProbeServicesClient(ctx)
+	if err != nil {
+		return err
+	}
+	return withBaseURL(clnt, s.collectorURL()).CloseReport(ctx, reportID)
+}
+
+// ResourcesLastUpdated returns when we have last installed new resources
+// (e.g. the ASN and country databases) into the assets directory.
+func (s *Session) ResourcesLastUpdated() (time.Time, error) {
+	return s.newResourcesClient().LastUpdated()
+}
+
+// UpdateResources checks whether there are more recent resources than the
+// ones we have installed and, if so, installs them. Returns whether we have
+// installed new resources. See also SessionConfig.ResourcesUpdateInterval.
+func (s *Session) UpdateResources(ctx context.Context) (bool, error) {
+	return s.newResourcesClient().Update(ctx)
 }
 
 // CountryDatabasePath is like ASNDatabasePath but for the country DB path.
@@ -161,6 +679,63 @@ func (s *Session) CountryDatabasePath() string {
 	return filepath.Join(s.assetsDir, resources.CountryDatabaseName)
 }
 
+// FallbackDatabaseDate returns the date of the fallback database built into
+// the engine if we have used it to geolocate the probe, or an empty string.
+func (s *Session) FallbackDatabaseDate() string {
+	if location := s.getLocation(); location != nil {
+		return location.FallbackDatabaseDate
+	}
+	return ""
+}
+
+// FlushQueuedMeasurements submits the measurements that we have queued
+// because we could not submit them (see Experiment.SubmitOrQueueMeasurement),
+// possibly during a previous session using the same KVStore. Returns the
+// number of measurements submitted and the error that occurred, if any.
+func (s *Session) FlushQueuedMeasurements(ctx context.Context) (int, error) {
+	ctx = s.withTracer(ctx)
+	clnt, err := s.newProbeServicesClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.submitter.Flush(ctx, *withBaseURL(clnt, s.collectorURL()))
+}
+
+// GetBestTestHelper returns the test helper with the specified name that has
+// the lowest round trip time, among the "https" ones (see the
+// probeservices.TryAllHelpers function), or false if there's none. We cache
+// the result, so we only measure the round trip time once per session.
+func (s *Session) GetBestTestHelper(ctx context.Context, name string) (*model.Service, bool) {
+	s.bestTestHelpersMu.Lock()
+	helper, found := s.bestTestHelpers[name]
+	s.bestTestHelpersMu.Unlock()
+	if found {
+		return &helper, true
+	}
+	helpers := probeservices.OnlyHTTPSHelpers(s.availableTestHelpers[name])
+	if len(helpers) <= 0 {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	candidates := probeservices.TryAllHelpers(ctx, s, helpers)
+	if candidates[0].Err != nil {
+		// None works from here but the experiment may still be able to
+		// use them, so return the first one without caching it.
+		return &helpers[0], true
+	}
+	// We do not hold the lock while probing, so concurrent callers may
+	// probe as well; the last one to finish wins, which is fine.
+	helper = candidates[0].Helper
+	s.bestTestHelpersMu.Lock()
+	if s.bestTestHelpers == nil {
+		s.bestTestHelpers = make(map[string]model.Service)
+	}
+	s.bestTestHelpers[name] = helper
+	s.bestTestHelpersMu.Unlock()
+	return &helper, true
+}
+
 // GetTestHelpersByName returns the available test helpers that
 // use the specified name, or false if there's none.
 func (s *Session) GetTestHelpersByName(name string) ([]model.Service, bool) {
@@ -168,6 +743,13 @@ func (s *Session) GetTestHelpersByName(name string) ([]model.Service, bool) {
 	return services, ok
 }
 
+// EventBus returns the bus on which the session, its experiments, and
+// netx publish events (see the eventbus package). Frontends subscribe to
+// it to learn about progress and diagnostics.
+func (s *Session) EventBus() *eventbus.Bus {
+	return s.eventBus
+}
+
 // DefaultHTTPClient returns the session's default HTTP client.
 func (s *Session) DefaultHTTPClient() *http.Client {
 	return &http.Client{Transport: s.httpDefaultTransport}
@@ -178,6 +760,12 @@ func (s *Session) KeyValueStore() model.KeyValueStore {
 	return s.kvStore
 }
 
+// LiteMode returns whether the session is running in lite mode,
+// i.e., whether it is optimized for low-end devices.
+func (s *Session) LiteMode() bool {
+	return s.liteMode
+}
+
 // Logger returns the logger used by the session.
 func (s *Session) Logger() model.Logger {
 	return s.logger
@@ -199,6 +787,49 @@ var ErrAlreadyUsingProxy = errors.New(
 	"session: cannot create a new tunnel of this kind: we are already using a proxy",
 )
 
+// ErrMetricsDisabled indicates that we cannot submit the engine
+// metrics because the user did not opt in.
+var ErrMetricsDisabled = errors.New("session: metrics are disabled")
+
+// Metrics returns the aggregate metrics describing the health of this
+// session. See probeservices.EngineMetrics for more information.
+func (s *Session) Metrics() probeservices.EngineMetrics {
+	resolver := probeservices.MetricsResolverDoH
+	if s.resolver.PrimaryFailure.Load() > 0 {
+		resolver = probeservices.MetricsResolverSystem
+	}
+	return probeservices.EngineMetrics{
+		BootstrapsAttempted: s.queryProbeServicesCount.Load(),
+		BootstrapsSucceeded: s.queryProbeServicesOK.Load(),
+		Platform:            s.Platform(),
+		Resolver:            resolver,
+		SoftwareName:        s.softwareName,
+		SoftwareVersion:     s.softwareVersion,
+		SubmissionsFailed:   s.submissionsFailed.Load(),
+	}
+}
+
+// MetricsEnabled returns whether the user opted in for submitting
+// the engine metrics (see SessionConfig.EnableMetrics).
+func (s *Session) MetricsEnabled() bool {
+	return s.metricsEnabled
+}
+
+// SubmitMetrics submits the metrics returned by s.Metrics to the probe
+// services. This function fails with ErrMetricsDisabled unless the user
+// opted in by setting SessionConfig.EnableMetrics.
+func (s *Session) SubmitMetrics(ctx context.Context) error {
+	if !s.metricsEnabled {
+		return ErrMetricsDisabled
+	}
+	ctx = s.withTracer(ctx)
+	clnt, err := s.newProbeServicesClient(ctx)
+	if err != nil {
+		return err
+	}
+	return clnt.SubmitMetrics(ctx, s.Metrics())
+}
+
 // MaybeStartTunnel starts the requested tunnel.
 //
 // This function silently succeeds if we're already using a tunnel with
@@ -209,6 +840,10 @@ var ErrAlreadyUsingProxy = errors.New(
 // for a tunnel name that is not the empty string and you get a nil error,
 // you can be confident that session.ProxyURL() gives you the tunnel URL.
 //
+// See the tunnel package for the supported tunnel names. Once the tunnel
+// has started, all the backend traffic goes through the tunnel, including
+// the traffic of the HTTP clients we created before starting the tunnel.
+//
 // The tunnel will be closed by session.Close().
 func (s *Session) MaybeStartTunnel(ctx context.Context, name string) error {
 	s.tunnelMu.Lock()
@@ -217,34 +852,127 @@ func (s *Session) MaybeStartTunnel(ctx context.Context, name string) error {
 		// We've been asked more than once to start the same tunnel.
 		return nil
 	}
-	if s.proxyURL != nil && name == "" {
+	if s.ProxyURL() != nil && name == "" {
 		// The user configured a proxy and here we're not actually trying
 		// to start any tunnel since `name` is empty.
 		return nil
 	}
-	if s.proxyURL != nil || s.tunnel != nil {
+	if s.ProxyURL() != nil || s.tunnel != nil {
 		// We already have a proxy or we have a different tunnel. Because a tunnel
 		// sets a proxy, the second check for s.tunnel is for robustness.
 		return ErrAlreadyUsingProxy
 	}
-	tunnel, err := sessiontunnel.Start(ctx, sessiontunnel.Config{
-		Name:    name,
-		Session: s,
-	})
+	config := tunnel.Config{
+		Name:             name,
+		OBFS4ProxyBinary: s.obfs4ProxyBinary,
+		Session:          tunnelSession{Session: s},
+		Snowflake:        s.snowflake,
+		TorBridges:       s.torBridges,
+	}
+	callbacks := s.tunnelCallbacks
+	notify := callbacks != nil && name != ""
+	if notify {
+		callbacks.OnTunnelStart(name)
+		config.Progress = func(percentage float64, message string) {
+			callbacks.OnTunnelProgress(name, percentage, message)
+		}
+	}
+	tun, err := tunnel.Start(ctx, config)
 	if err != nil {
 		s.logger.Warnf("cannot start tunnel: %+v", err)
+		if notify {
+			callbacks.OnTunnelFailure(name, err)
+		}
 		return err
 	}
-	// Implementation note: tunnel _may_ be NIL here if name is ""
-	if tunnel == nil {
+	// Implementation note: tun _may_ be NIL here if name is ""
+	if tun == nil {
 		return nil
 	}
+	s.observeBootstrap(metrics.BootstrapTunnel, tun.BootstrapTime())
 	s.tunnelName = name
-	s.tunnel = tunnel
-	s.proxyURL = tunnel.SOCKS5ProxyURL()
+	s.tunnel = tun
+	s.setProxyURL(tun.SOCKS5ProxyURL())
 	return nil
 }
 
+// tunnelSession is the tunnel's view of the session, whose logger
+// tells that the messages come from the tunnel.
+type tunnelSession struct {
+	*Session
+}
+
+// Logger returns the logger that the tunnel should use.
+func (ts tunnelSession) Logger() model.Logger {
+	return model.WithComponent(ts.Session.logger, "tunnel")
+}
+
+// CheckTunnelHealth checks whether the tunnel we're using, if any, still
+// works and bootstraps it again otherwise (see tunnel.Tunnel). When that
+// happens, the backend traffic uses the new tunnel from now on. This
+// function returns nil if we're not using any tunnel.
+func (s *Session) CheckTunnelHealth(ctx context.Context) error {
+	s.tunnelMu.Lock()
+	defer s.tunnelMu.Unlock()
+	if s.tunnel == nil {
+		return nil
+	}
+	err := s.tunnel.HealthCheck(ctx)
+	if proxyURL := s.tunnel.SOCKS5ProxyURL(); proxyURL.String() != s.ProxyURL().String() {
+		s.setProxyURL(proxyURL)
+	}
+	return err
+}
+
+// KeepTunnelAlive calls CheckTunnelHealth every interval until the
+// context is done. It is meant for long-running deployments that route
+// the backend traffic through a tunnel, and should run in a background
+// goroutine. Failures are logged and we try again at the next interval.
+func (s *Session) KeepTunnelAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.CheckTunnelHealth(ctx); err != nil {
+				s.logger.Warnf("tunnel is not working: %s", err.Error())
+			}
+		}
+	}
+}
+
+// MeasureTunnelOverhead compares the RTT and the throughput of fetching
+// a reference endpoint directly and through the tunnel we're using (see
+// tunnel.MeasureOverhead), which may help to decide whether to route the
+// backend traffic through the tunnel. This function fails with
+// tunnel.ErrNoProxy if we're not using any tunnel.
+func (s *Session) MeasureTunnelOverhead(ctx context.Context) (*tunnel.TunnelOverhead, error) {
+	s.tunnelMu.Lock()
+	var proxyURL *url.URL
+	if s.tunnel != nil {
+		proxyURL = s.tunnel.SOCKS5ProxyURL()
+	}
+	s.tunnelMu.Unlock()
+	return tunnel.MeasureOverhead(ctx, tunnel.OverheadConfig{
+		Logger:   model.WithComponent(s.logger, "tunnel"),
+		ProxyURL: proxyURL,
+	})
+}
+
+// stopTunnel stops the tunnel, if any, and stops using it.
+func (s *Session) stopTunnel() {
+	s.tunnelMu.Lock()
+	defer s.tunnelMu.Unlock()
+	if s.tunnel == nil {
+		return
+	}
+	s.tunnel.Stop()
+	s.tunnel, s.tunnelName = nil, ""
+	s.setProxyURL(nil)
+}
+
 // NewExperimentBuilder returns a new experiment builder
 // for the experiment with the given name, or an error if
 // there's no such experiment with the given name
@@ -267,13 +995,41 @@ func (s *Session) NewOrchestraClient(ctx context.Context) (model.ExperimentOrche
 	if s.selectedProbeServiceHook != nil {
 		s.selectedProbeServiceHook(s.selectedProbeService)
 	}
-	clnt, err := probeservices.NewClient(s, *s.selectedProbeService)
+	clnt, err := s.newSelectedProbeServicesClient()
 	if err != nil {
 		return nil, err
 	}
+	clnt = withBaseURL(clnt, s.orchestraURL())
 	return s.initOrchestraClient(ctx, clnt, clnt.MaybeLogin)
 }
 
+// FetchURLList fetches the list of URLs used by WebConnectivity using
+// a new orchestra client. If config.CountryCode is empty, we use the
+// country code of the probe. See probeservices.Client.FetchURLList for
+// more information on how to page through the list of URLs.
+func (s *Session) FetchURLList(
+	ctx context.Context, config model.FetchURLListConfig) ([]model.URLInfo, error) {
+	if config.CountryCode == "" {
+		config.CountryCode = s.ProbeCC()
+	}
+	key := cacheKey("checkin", config)
+	var urls []model.URLInfo
+	if s.getCached(key, &urls) {
+		s.logger.Debugf("session: using the cached URL list")
+		return urls, nil
+	}
+	clnt, err := s.NewOrchestraClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	urls, err = clnt.FetchURLList(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	s.setCached(key, urls, checkInCacheTTL)
+	return urls, nil
+}
+
 // Platform returns the current platform. The platform is one of:
 //
 // - android
@@ -290,6 +1046,16 @@ func (s *Session) Platform() string {
 	return platform.Name()
 }
 
+// NATType returns the NAT type, i.e., one of the geolocate.NATType
+// constants. When measurements use a proxy (see RoutingPolicy), we don't
+// determine the NAT type, hence this function returns NATTypeUnknown.
+func (s *Session) NATType() string {
+	if location := s.getLocation(); location != nil && location.NATType != "" {
+		return location.NATType
+	}
+	return geolocate.NATTypeUnknown
+}
+
 // ProbeASNString returns the probe ASN as a string.
 func (s *Session) ProbeASNString() string {
 	return fmt.Sprintf("AS%d", s.ProbeASN())
@@ -298,8 +1064,8 @@ func (s *Session) ProbeASNString() string {
 // ProbeASN returns the probe ASN as an integer.
 func (s *Session) ProbeASN() uint {
 	asn := model.DefaultProbeASN
-	if s.location != nil {
-		asn = s.location.ASN
+	if location := s.getLocation(); location != nil {
+		asn = location.ASN
 	}
 	return asn
 }
@@ -307,8 +1073,8 @@ func (s *Session) ProbeASN() uint {
 // ProbeCC returns the probe CC.
 func (s *Session) ProbeCC() string {
 	cc := model.DefaultProbeCC
-	if s.location != nil {
-		cc = s.location.CountryCode
+	if location := s.getLocation(); location != nil {
+		cc = location.CountryCode
 	}
 	return cc
 }
@@ -316,23 +1082,51 @@ func (s *Session) ProbeCC() string {
 // ProbeNetworkName returns the probe network name.
 func (s *Session) ProbeNetworkName() string {
 	nn := model.DefaultProbeNetworkName
-	if s.location != nil {
-		nn = s.location.NetworkName
+	if location := s.getLocation(); location != nil {
+		nn = location.NetworkName
 	}
 	return nn
 }
 
+// ProbeIPv4 returns the probe IPv4 address, or the empty
+// string if we don't know it (e.g. we don't have IPv4).
+func (s *Session) ProbeIPv4() string {
+	if location := s.getLocation(); location != nil {
+		return location.ProbeIPv4
+	}
+	return ""
+}
+
+// ProbeIPv6 returns the probe IPv6 address, or the empty
+// string if we don't know it (e.g. we don't have IPv6).
+func (s *Session) ProbeIPv6() string {
+	if location := s.getLocation(); location != nil {
+		return location.ProbeIPv6
+	}
+	return ""
+}
+
+// ProbeIsVPN returns whether the probe is likely using a VPN or a proxy, in
+// which case the results may not reflect the user's ISP. This is just a hint
+// computed by geolocate.DetectVPN and it's false when we use a proxy.
+func (s *Session) ProbeIsVPN() bool {
+	location := s.getLocation()
+	return location != nil && location.ProbeIsVPN
+}
+
 // ProbeIP returns the probe IP.
 func (s *Session) ProbeIP() string {
 	ip := model.DefaultProbeIP
-	if s.location != nil {
-		ip = s.location.ProbeIP
+	if location := s.getLocation(); location != nil {
+		ip = location.ProbeIP
 	}
 	return ip
 }
 
 // ProxyURL returns the Proxy URL, or nil if not set
 func (s *Session) ProxyURL() *url.URL {
+	s.proxyMu.Lock()
+	defer s.proxyMu.Unlock()
 	return s.proxyURL
 }
 
@@ -344,8 +1138,8 @@ func (s *Session) ResolverASNString() string {
 // ResolverASN returns the resolver ASN
 func (s *Session) ResolverASN() uint {
 	asn := model.DefaultResolverASN
-	if s.location != nil {
-		asn = s.location.ResolverASN
+	if location := s.getLocation(); location != nil {
+		asn = location.ResolverASN
 	}
 	return asn
 }
@@ -353,17 +1147,27 @@ func (s *Session) ResolverASN() uint {
 // ResolverIP returns the resolver IP
 func (s *Session) ResolverIP() string {
 	ip := model.DefaultResolverIP
-	if s.location != nil {
-		ip = s.location.ResolverIP
+	if location := s.getLocation(); location != nil {
+		ip = location.ResolverIP
 	}
 	return ip
 }
 
+// ResolverNetworkType returns the resolver network type, i.e., one of
+// "isp", "public", "other", and "unknown" (see geolocate.ClassifyResolver).
+func (s *Session) ResolverNetworkType() string {
+	location := s.getLocation()
+	if location != nil && location.ResolverNetworkType != "" {
+		return location.ResolverNetworkType
+	}
+	return geolocate.ResolverNetworkTypeUnknown
+}
+
 // ResolverNetworkName returns the resolver network name.
 func (s *Session) ResolverNetworkName() string {
 	nn := model.DefaultResolverNetworkName
-	if s.location != nil {
-		nn = s.location.ResolverNetworkName
+	if location := s.getLocation(); location != nil {
+		nn = location.ResolverNetworkName
 	}
 	return nn
 }
@@ -398,12 +1202,32 @@ func (s *Session) TorBinary() string {
 // TunnelBootstrapTime returns the time required to bootstrap the tunnel
 // we're using, or zero if we're using no tunnel.
 func (s *Session) TunnelBootstrapTime() time.Duration {
+	s.tunnelMu.Lock()
+	defer s.tunnelMu.Unlock()
 	if s.tunnel == nil {
 		return 0
 	}
 	return s.tunnel.BootstrapTime()
 }
 
+// UpdateMeasurement attaches annotations, e.g., feedback from the user, to
+// the measurement with the specified ID, i.e., the measurement's OOID field
+// as set by the collector when we submitted the measurement.
+func (s *Session) UpdateMeasurement(
+	ctx context.Context, measurementID string, annotations map[string]string) error {
+	ctx = s.withTracer(ctx)
+	clnt, err := s.newProbeServicesClient(ctx)
+	if err != nil {
+		return err
+	}
+	clnt = withBaseURL(clnt, s.orchestraURL())
+	clnt, err = s.initOrchestraClient(ctx, clnt, clnt.MaybeLogin)
+	if err != nil {
+		return err
+	}
+	return clnt.UpdateMeasurement(ctx, measurementID, annotations)
+}
+
 // UserAgent constructs the user agent to be used in this session.
 func (s *Session) UserAgent() (useragent string) {
 	useragent += s.softwareName + "/" + s.softwareVersion
@@ -412,15 +1236,141 @@ func (s *Session) UserAgent() (useragent string) {
 }
 
 func (s *Session) fetchResourcesIdempotent(ctx context.Context) error {
-	return (&resources.Client{
-		HTTPClient: s.DefaultHTTPClient(),
-		Logger:     s.logger,
-		UserAgent:  s.UserAgent(),
-		WorkDir:    s.assetsDir,
-	}).Ensure(ctx)
+	return s.newResourcesClient().Ensure(ctx)
+}
+
+func (s *Session) newResourcesClient() *resources.Client {
+	return &resources.Client{
+		HTTPClient:        s.DefaultHTTPClient(),
+		Logger:            model.WithComponent(s.logger, "resources"),
+		ManifestPublicKey: resources.DefaultManifestPublicKey,
+		Mirrors:           resources.DefaultMirrors,
+		UserAgent:         s.UserAgent(),
+		WorkDir:           s.assetsDir,
+	}
+}
+
+// dataCapPollInterval is how often enforceDataCap checks the data usage.
+const dataCapPollInterval = 250 * time.Millisecond
+
+// enforceDataCap calls cancel as soon as the session exceeds the data
+// cap. It returns when ctx is done or immediately if there's no data cap.
+func (s *Session) enforceDataCap(ctx context.Context, cancel context.CancelFunc) {
+	if s.dataCapKiB <= 0 {
+		return
+	}
+	ticker := time.NewTicker(dataCapPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.DataCapExceeded() {
+			s.logger.Warnf("session: data cap of %.0f KiB exceeded; stopping", s.dataCapKiB)
+			cancel()
+			return
+		}
+	}
+}
+
+func (s *Session) runResourcesUpdater(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.UpdateResources(ctx); err != nil {
+			s.logger.Warnf("session: cannot update resources: %s", err.Error())
+		}
+	}
+}
+
+// LocationChangedFunc is the callback called by WatchLocation when the
+// probe country code or ASN changes. The previous location is nil if we
+// had not looked up the location before starting to watch it.
+type LocationChangedFunc func(previous, current *model.LocationInfo)
+
+// RefreshLocation looks up the probe location again, replacing the cached
+// location, and returns whether the probe country code or ASN changed. If
+// the lookup fails, we keep using the cached location. When using an offline
+// location, this function does nothing and returns false.
+func (s *Session) RefreshLocation(ctx context.Context) (bool, error) {
+	previous, current, err := s.refreshLocation(ctx, s.lookupLocation)
+	return err == nil && locationChanged(previous, current), err
+}
+
+// WatchLocation periodically refreshes the probe location (see RefreshLocation)
+// and calls callback whenever the probe country code or ASN changes, so that
+// long-running sessions can, e.g., check in again and discard cached URL
+// lists. This function blocks until ctx is done; run it in a background
+// goroutine and cancel ctx to stop watching.
+func (s *Session) WatchLocation(
+	ctx context.Context, interval time.Duration, callback LocationChangedFunc,
+) {
+	s.watchLocation(ctx, interval, s.lookupLocation, callback)
+}
+
+func (s *Session) watchLocation(
+	ctx context.Context, interval time.Duration,
+	lookup func(context.Context) (*model.LocationInfo, error),
+	callback LocationChangedFunc,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		previous, current, err := s.refreshLocation(ctx, lookup)
+		if err != nil {
+			s.logger.Warnf("session: cannot refresh location: %s", err.Error())
+			continue
+		}
+		if locationChanged(previous, current) {
+			s.logger.Infof("session: location changed to %s, AS%d",
+				current.CountryCode, current.ASN)
+			callback(previous, current)
+		}
+	}
+}
+
+func (s *Session) refreshLocation(
+	ctx context.Context, lookup func(context.Context) (*model.LocationInfo, error),
+) (previous, current *model.LocationInfo, err error) {
+	previous = s.getLocation()
+	if s.offlineLocation != nil {
+		return previous, previous, nil
+	}
+	current, err = lookup(ctx)
+	if err != nil {
+		return previous, previous, err
+	}
+	if s.locationCacheable() {
+		s.setCached(locationCacheKey, current, locationCacheTTL)
+	}
+	s.setLocation(current)
+	return previous, current, nil
+}
+
+func locationChanged(previous, current *model.LocationInfo) bool {
+	if current == nil {
+		return false
+	}
+	return previous == nil || previous.CountryCode != current.CountryCode ||
+		previous.ASN != current.ASN
 }
 
 func (s *Session) getAvailableProbeServices() []model.Service {
+	if s.backendProfile != nil {
+		return s.backendProfile.probeServices()
+	}
 	if len(s.availableProbeServices) > 0 {
 		return s.availableProbeServices
 	}
@@ -452,87 +1402,342 @@ func (s *Session) initOrchestraClient(
 	if err := maybeLogin(ctx); err != nil {
 		return nil, err
 	}
+	s.maybeStartTokenRefresher(*clnt)
 	return clnt, nil
 }
 
-func (s *Session) lookupASN(dbPath, ip string) (uint, string, error) {
-	return geolocate.LookupASN(dbPath, ip)
+// maybeStartTokenRefresher starts refreshing the orchestra token in the
+// background, unless we have already done that, so that long running
+// sessions (e.g., miniooni's daemon) do not use an expired token. We stop
+// refreshing the token when the session is closed.
+func (s *Session) maybeStartTokenRefresher(clnt probeservices.Client) {
+	s.tokenRefresherMu.Lock()
+	defer s.tokenRefresherMu.Unlock()
+	if s.stopTokenRefresher != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopTokenRefresher = cancel
+	go clnt.RunTokenRefresher(ctx)
 }
 
-func (s *Session) lookupProbeIP(ctx context.Context) (string, error) {
-	return (&geolocate.IPLookupClient{
-		HTTPClient: s.DefaultHTTPClient(),
-		Logger:     s.logger,
-		UserAgent:  httpheader.UserAgent(), // no need to identify as OONI
-	}).Do(ctx)
+func (s *Session) newProbeServicesClient(ctx context.Context) (*probeservices.Client, error) {
+	if err := s.maybeLookupBackends(ctx); err != nil {
+		return nil, err
+	}
+	return s.newSelectedProbeServicesClient()
 }
 
-func (s *Session) lookupProbeCC(dbPath, probeIP string) (string, error) {
-	return geolocate.LookupCC(dbPath, probeIP)
+// newSelectedProbeServicesClient creates a client for the selected probe
+// service. The client encrypts its state file if we have a StateEncryptionKey.
+func (s *Session) newSelectedProbeServicesClient() (*probeservices.Client, error) {
+	return probeservices.NewClient(s, *s.selectedProbeService)
+}
+
+// StateEncryptionKey returns the key with which the probe services clients
+// encrypt the orchestra credentials (see probeservices.NewClient), or nil.
+func (s *Session) StateEncryptionKey() []byte {
+	return s.stateEncryptionKey
+}
+
+// newGeolocateTask returns the task discovering the probe location, which
+// uses the route of the measurement traffic, because the location should
+// describe the network that we measure.
+func (s *Session) newGeolocateTask(httpClient *http.Client) *geolocate.Task {
+	methods := geolocate.DefaultIPLookupMethods()
+	if s.measurementProxyURL() != nil {
+		// STUN would bypass the proxy and discover our real IP
+		methods = geolocate.WithoutSTUN(methods)
+	}
+	return geolocate.NewTask(geolocate.Config{
+		ASNDatabasePath:      s.ASNDatabasePath(),
+		CountryDatabasePath:  s.CountryDatabasePath(),
+		EnableResolverLookup: s.measurementProxyURL() == nil,
+		HTTPClient:           httpClient,
+		Logger:               model.WithComponent(s.logger, "geolocate"),
+		Methods:              methods,
+		UserAgent:            httpheader.UserAgent(), // no need to identify as OONI
+	})
+}
+
+// lookupProbeIPFamilies returns the probe IPv4 and IPv6 addresses, when
+// available, given the already discovered probeIP. When measurements use
+// a proxy, we do not perform further lookups because the family specific
+// lookups do not use the proxy, hence they would discover our real IP
+// addresses.
+func (s *Session) lookupProbeIPFamilies(
+	ctx context.Context, probeIP string) (ipv4, ipv6 string) {
+	switch geolocate.IPFamily(probeIP) {
+	case geolocate.FamilyIPv4:
+		ipv4 = probeIP
+	case geolocate.FamilyIPv6:
+		ipv6 = probeIP
+	}
+	if s.measurementProxyURL() != nil {
+		return
+	}
+	if ipv4 == "" {
+		ipv4 = s.lookupProbeIPFamily(ctx, geolocate.FamilyIPv4)
+	}
+	if ipv6 == "" {
+		ipv6 = s.lookupProbeIPFamily(ctx, geolocate.FamilyIPv6)
+	}
+	return
 }
 
-func (s *Session) lookupResolverIP(ctx context.Context) (string, error) {
-	return geolocate.LookupFirstResolverIP(ctx, nil)
+func (s *Session) lookupProbeIPFamily(ctx context.Context, family string) string {
+	ip, err := (&geolocate.IPLookupClient{
+		Family:     family,
+		HTTPClient: geolocate.NewHTTPClientForFamily(family),
+		Logger:     model.WithComponent(s.logger, "geolocate"),
+		Methods:    geolocate.IPLookupMethodsForFamily(family),
+		UserAgent:  httpheader.UserAgent(), // no need to identify as OONI
+	}).Do(ctx)
+	if err != nil {
+		s.logger.Debugf("session: no %s address: %s", family, err.Error())
+		return ""
+	}
+	return ip
 }
 
-func (s *Session) maybeLookupBackends(ctx context.Context) error {
+// maybeLookupBackends selects the probe services. When the user has not
+// configured a proxy or a tunnel, we use the circumvention policy, which
+// may start a tunnel, to choose the channel to use (see the circumvention
+// package). Otherwise, the channel is the proxy or the tunnel. When the
+// routing policy says that backend traffic goes direct, we do not use
+// the proxy and we only try the channels that do not need a tunnel.
+func (s *Session) maybeLookupBackends(ctx context.Context) (err error) {
 	// TODO(bassosimone): do we need a mutex here?
 	if s.selectedProbeService != nil {
 		return nil
 	}
 	s.queryProbeServicesCount.Add(1)
-	candidates := probeservices.TryAll(ctx, s, s.getAvailableProbeServices())
+	ctx, span := model.StartSpan(s.withTracer(ctx), "session.lookup_backends")
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			s.observeBootstrap(metrics.BootstrapBackend, time.Since(start))
+			span.SetAttribute("channel", s.BackendChannel())
+		}
+		span.End(err)
+	}()
+	if s.backendProxyURL(s.ProxyURL()) != nil {
+		channel := s.tunnelName
+		if channel == "" {
+			channel = "proxy"
+		}
+		if err := s.tryProbeServices(ctx, s.getAvailableProbeServices()); err != nil {
+			return err
+		}
+		s.setBackendChannel(channel)
+		return nil
+	}
+	policy := circumvention.Policy{
+		KVStore: s.kvStore,
+		Logger:  model.WithComponent(s.logger, "circumvention"),
+		Try:     s.tryBackendChannel,
+	}
+	if s.routing.backend() == RouteDirect {
+		policy.Chain = []string{circumvention.Direct, circumvention.Cloudfront}
+	}
+	if s.backendProfile != nil {
+		// a custom backend is not reachable through our circumvention channels
+		policy.Chain = []string{circumvention.Direct}
+	}
+	channel, _, err := policy.Run(ctx)
+	if err != nil {
+		return errAllProbeServicesFailed
+	}
+	s.setBackendChannel(channel)
+	return nil
+}
+
+var errAllProbeServicesFailed = errors.New("all available probe services failed")
+
+// tryBackendChannel tries to select the probe services using channel
+// and stops the tunnel used by channel, if any, on failure.
+func (s *Session) tryBackendChannel(ctx context.Context, channel string) error {
+	services := probeservices.OnlyHTTPS(s.getAvailableProbeServices())
+	if channel == circumvention.Cloudfront {
+		services = probeservices.OnlyCloudfront(s.getAvailableProbeServices())
+	}
+	if len(services) <= 0 {
+		return errAllProbeServicesFailed
+	}
+	if channel != circumvention.Psiphon && channel != circumvention.Tor {
+		return s.tryProbeServices(ctx, services)
+	}
+	if err := s.MaybeStartTunnel(ctx, channel); err != nil {
+		return err
+	}
+	if err := s.tryProbeServices(ctx, services); err != nil {
+		s.stopTunnel()
+		return err
+	}
+	return nil
+}
+
+// tryProbeServices selects the best probe services among services.
+func (s *Session) tryProbeServices(ctx context.Context, services []model.Service) error {
+	candidates := probeservices.TryAll(ctx, s, services)
 	selected := probeservices.SelectBest(candidates)
 	if selected == nil {
-		return errors.New("all available probe services failed")
+		return errAllProbeServicesFailed
 	}
+	s.queryProbeServicesOK.Add(1)
 	s.logger.Infof("session: using probe services: %+v", selected.Endpoint)
 	s.selectedProbeService = &selected.Endpoint
 	s.availableTestHelpers = selected.TestHelpers
+	if s.backendProfile != nil && s.backendProfile.TestHelpers != nil {
+		s.availableTestHelpers = s.backendProfile.TestHelpers
+	}
 	return nil
 }
 
-func (s *Session) maybeLookupLocation(ctx context.Context) (err error) {
-	if s.location == nil {
-		defer func() {
-			if recover() != nil {
-				// JUST KNOW WE'VE BEEN HERE
-			}
-		}()
-		var (
-			probeIP     string
-			asn         uint
-			org         string
-			cc          string
-			resolverASN uint   = model.DefaultResolverASN
-			resolverIP  string = model.DefaultResolverIP
-			resolverOrg string
-		)
-		err = s.fetchResourcesIdempotent(ctx)
-		runtimex.PanicOnError(err, "s.fetchResourcesIdempotent failed")
-		probeIP, err = s.lookupProbeIP(ctx)
-		runtimex.PanicOnError(err, "s.lookupProbeIP failed")
-		asn, org, err = s.lookupASN(s.ASNDatabasePath(), probeIP)
-		runtimex.PanicOnError(err, "s.lookupASN #1 failed")
-		cc, err = s.lookupProbeCC(s.CountryDatabasePath(), probeIP)
-		runtimex.PanicOnError(err, "s.lookupProbeCC failed")
-		if s.proxyURL == nil {
-			resolverIP, err = s.lookupResolverIP(ctx)
-			runtimex.PanicOnError(err, "s.lookupResolverIP failed")
-			resolverASN, resolverOrg, err = s.lookupASN(
-				s.ASNDatabasePath(), resolverIP,
-			)
-			runtimex.PanicOnError(err, "s.lookupASN #2 failed")
+// lookupNATType determines the NAT type once per session, since refreshing
+// the location does not usually change it. The STUN socket is created
+// through netx, so we account for the bytes it uses.
+func (s *Session) lookupNATType(ctx context.Context) string {
+	s.natTypeMu.Lock()
+	defer s.natTypeMu.Unlock()
+	if s.natType != "" {
+		return s.natType
+	}
+	client := &geolocate.STUNClient{
+		PacketListener: netx.NewPacketListener(netx.Config{ByteCounter: s.byteCounter}),
+		Servers:        geolocate.DefaultSTUNServers,
+	}
+	natType := client.NATType(ctx, model.WithComponent(s.logger, "geolocate"))
+	if ctx.Err() == nil {
+		s.natType = natType // don't cache the result of an interrupted check
+	}
+	return natType
+}
+
+// characterizeNetwork determines in parallel the NAT type and whether
+// we are behind a captive portal, using clnt, which should route traffic
+// like measurements do (see lookupLocation), for the latter. When
+// measurements use a proxy, we skip both checks because they would bypass
+// the proxy and characterize our real network.
+func (s *Session) characterizeNetwork(
+	ctx context.Context, clnt *http.Client) (natType string, captivePortal bool) {
+	natType = geolocate.NATTypeUnknown
+	if s.measurementProxyURL() != nil {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		natType = s.lookupNATType(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		var err error
+		captivePortal, err = geolocate.CheckCaptivePortal(ctx, clnt,
+			geolocate.DefaultCaptivePortalURL, httpheader.UserAgent())
+		if err != nil {
+			s.logger.Debugf("session: cannot check for captive portal: %s", err.Error())
 		}
-		s.location = &model.LocationInfo{
-			ASN:                 asn,
-			CountryCode:         cc,
-			NetworkName:         org,
-			ProbeIP:             probeIP,
-			ResolverASN:         resolverASN,
-			ResolverIP:          resolverIP,
-			ResolverNetworkName: resolverOrg,
+	}()
+	wg.Wait()
+	if captivePortal {
+		s.logger.Warn("session: you seem to be behind a captive portal")
+	}
+	return
+}
+
+func (s *Session) getLocation() *model.LocationInfo {
+	s.locationMu.Lock()
+	defer s.locationMu.Unlock()
+	return s.location
+}
+
+func (s *Session) setLocation(location *model.LocationInfo) {
+	s.locationMu.Lock()
+	defer s.locationMu.Unlock()
+	s.location = location
+}
+
+func (s *Session) maybeLookupLocation(ctx context.Context) error {
+	if s.getLocation() != nil {
+		return nil
+	}
+	var location *model.LocationInfo
+	cacheable := s.locationCacheable()
+	if cacheable && s.getCached(locationCacheKey, &location) && location != nil {
+		s.logger.Debugf("session: using the cached location")
+		s.setLocation(location)
+		return nil
+	}
+	ctx, span := model.StartSpan(s.withTracer(ctx), "session.lookup_location")
+	location, err := s.lookupLocation(ctx)
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	if cacheable {
+		s.setCached(locationCacheKey, location, locationCacheTTL)
+	}
+	s.setLocation(location)
+	return nil
+}
+
+// locationCacheKey is the key of the probe location in the cache.
+const locationCacheKey = "location"
+
+// locationCacheable returns whether we can cache the probe location. We
+// do not cache the location discovered using a proxy, which depends on the
+// proxy, nor the offline location, which we do not look up.
+func (s *Session) locationCacheable() bool {
+	return s.measurementProxyURL() == nil && s.offlineLocation == nil
+}
+
+func (s *Session) lookupLocation(ctx context.Context) (location *model.LocationInfo, err error) {
+	defer func() {
+		if recover() != nil {
+			// JUST KNOW WE'VE BEEN HERE
 		}
+	}()
+	if err = s.fetchResourcesIdempotent(ctx); err != nil && s.offlineLocation == nil {
+		// We can still geolocate using the fallback database.
+		s.logger.Warnf("session: cannot fetch resources: %s", err.Error())
+		err = nil
+	}
+	runtimex.PanicOnError(err, "s.fetchResourcesIdempotent failed")
+	if s.offlineLocation != nil {
+		location, err = geolocate.ValidateLocation(
+			s.ASNDatabasePath(), s.CountryDatabasePath(), s.offlineLocation,
+		)
+		runtimex.PanicOnError(err, "geolocate.ValidateLocation failed")
+		s.logger.Infof("session: using the offline location: %s, AS%d",
+			location.CountryCode, location.ASN)
+		return
+	}
+	txp := s.newHTTPTransport(s.measurementProxyURL(), nil)
+	defer txp.CloseIdleConnections()
+	clnt := &http.Client{Transport: txp}
+	results, err := s.newGeolocateTask(clnt).Run(ctx)
+	runtimex.PanicOnError(err, "geolocate.Task.Run failed")
+	probeIPv4, probeIPv6 := s.lookupProbeIPFamilies(ctx, results.ProbeIP)
+	isVPN := s.measurementProxyURL() == nil && geolocate.DetectVPN(
+		results.ASN, results.ResolverNetworkType)
+	natType, captivePortal := s.characterizeNetwork(ctx, clnt)
+	location = &model.LocationInfo{
+		ASN:                  results.ASN,
+		BehindCaptivePortal:  captivePortal,
+		CountryCode:          results.CountryCode,
+		FallbackDatabaseDate: results.FallbackDatabaseDate,
+		NATType:              natType,
+		NetworkName:          results.NetworkName,
+		ProbeIP:              results.ProbeIP,
+		ProbeIPv4:            probeIPv4,
+		ProbeIPv6:            probeIPv6,
+		ProbeIsVPN:           isVPN,
+		ResolverASN:          results.ResolverASN,
+		ResolverIP:           results.ResolverIP,
+		ResolverNetworkName:  results.ResolverNetworkName,
+		ResolverNetworkType:  results.ResolverNetworkType,
 	}
 	return
 }
@@ -3,11 +3,13 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"testing"
@@ -15,6 +17,10 @@ import (
 
 	"github.com/apex/log"
 	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/geolocate"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/internal/tunnel"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/probeservices"
@@ -51,6 +57,42 @@ func TestNewSessionBuilderChecks(t *testing.T) {
 			TempDir:         "./nonexistent",
 		})
 	})
+	t.Run("with reserved annotations", func(t *testing.T) {
+		newSessionMustFail(t, SessionConfig{
+			Annotations:     map[string]string{"platform": "antani"},
+			AssetsDir:       "testdata",
+			Logger:          log.Log,
+			SoftwareName:    "ooniprobe-engine",
+			SoftwareVersion: "0.0.1",
+		})
+	})
+	t.Run("with invalid state encryption key", func(t *testing.T) {
+		newSessionMustFail(t, SessionConfig{
+			AssetsDir:          "testdata",
+			Logger:             log.Log,
+			SoftwareName:       "ooniprobe-engine",
+			SoftwareVersion:    "0.0.1",
+			StateEncryptionKey: []byte("short"),
+		})
+	})
+	t.Run("with unsupported data format version", func(t *testing.T) {
+		newSessionMustFail(t, SessionConfig{
+			AssetsDir:         "testdata",
+			DataFormatVersion: "0.1.0",
+			Logger:            log.Log,
+			SoftwareName:      "ooniprobe-engine",
+			SoftwareVersion:   "0.0.1",
+		})
+	})
+	t.Run("with unsupported upload compression", func(t *testing.T) {
+		newSessionMustFail(t, SessionConfig{
+			AssetsDir:         "testdata",
+			Logger:            log.Log,
+			SoftwareName:      "ooniprobe-engine",
+			SoftwareVersion:   "0.0.1",
+			UploadCompression: "brotli",
+		})
+	})
 }
 
 func TestNewSessionBuilderGood(t *testing.T) {
@@ -105,6 +147,43 @@ func TestSessionTorArgsTorBinary(t *testing.T) {
 	}
 }
 
+func TestSessionKVStorePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniprobe-engine-kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := SessionConfig{
+		AssetsDir:       "testdata",
+		KVStorePath:     filepath.Join(dir, "kvstore.db"),
+		Logger:          log.Log,
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	}
+	sess, err := NewSession(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sess.KeyValueStore().(*BoltKVStore); !ok {
+		t.Fatal("not the KVStore we expected")
+	}
+	if err := sess.KeyValueStore().Set("antani", []byte("mascetti")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// we must have released the database lock when closing
+	sess, err = NewSession(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	if value, err := sess.KeyValueStore().Get("antani"); err != nil || string(value) != "mascetti" {
+		t.Fatal("not the value we expected", string(value), err)
+	}
+}
+
 func newSessionForTestingNoLookupsWithProxyURL(t *testing.T, URL *url.URL) *Session {
 	sess, err := NewSession(SessionConfig{
 		AssetsDir: "testdata",
@@ -545,6 +624,96 @@ func TestNewOrchestraClientMaybeLookupBackendsFailure(t *testing.T) {
 	}
 }
 
+func TestSessionFetchURLListFailure(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	list, err := sess.FetchURLList(ctx, model.FetchURLListConfig{})
+	if err == nil || err.Error() != "all available probe services failed" {
+		t.Fatal("not the error we expected")
+	}
+	if list != nil {
+		t.Fatal("expected nil list here")
+	}
+}
+
+func TestIntegrationSessionFetchURLList(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	list, err := sess.FetchURLList(context.Background(), model.FetchURLListConfig{
+		Categories: []string{"NEWS", "HUMR"},
+		Limit:      7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range list {
+		if entry.CategoryCode != "NEWS" && entry.CategoryCode != "HUMR" {
+			t.Fatal("unexpected category code")
+		}
+	}
+}
+
+func TestSessionFlushQueuedMeasurementsFailure(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	count, err := sess.FlushQueuedMeasurements(ctx)
+	if err == nil || err.Error() != "all available probe services failed" {
+		t.Fatal("not the error we expected")
+	}
+	if count != 0 {
+		t.Fatal("expected zero measurements here")
+	}
+}
+
+func TestSessionCloseReportFailure(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	err := sess.CloseReport(ctx, "_id")
+	if err == nil || err.Error() != "all available probe services failed" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestSessionUpdateMeasurementFailure(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	err := sess.UpdateMeasurement(ctx, "_id", map[string]string{"a": "b"})
+	if err == nil || err.Error() != "all available probe services failed" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestSessionGetBestTestHelper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.availableTestHelpers = map[string][]model.Service{
+		"antani": {{Address: "https://b.org", Front: "c.org", Type: "cloudfront"},
+			{Address: "http://d.org", Type: "legacy"},
+			{Address: server.URL, Type: "https"}},
+	}
+	helper, ok := sess.GetBestTestHelper(context.Background(), "antani")
+	if !ok || helper.Address != server.URL {
+		t.Fatal("not the helper we expected")
+	}
+	if _, found := sess.bestTestHelpers["antani"]; !found {
+		t.Fatal("did not cache the helper")
+	}
+	if _, ok := sess.GetBestTestHelper(context.Background(), "mascetti"); ok {
+		t.Fatal("expected no helper here")
+	}
+}
+
 type httpTransportThatSleeps struct {
 	txp netx.HTTPRoundTripper
 	st  time.Duration
@@ -592,3 +761,398 @@ func TestNewOrchestraClientProbeServicesNewClientFailure(t *testing.T) {
 		t.Fatal("expected nil client here")
 	}
 }
+
+func TestSessionSubmitMetricsDisabled(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if sess.MetricsEnabled() {
+		t.Fatal("metrics should be disabled by default")
+	}
+	if err := sess.SubmitMetrics(context.Background()); !errors.Is(err, ErrMetricsDisabled) {
+		t.Fatal("not the error we expected")
+	}
+	if sess.QueryProbeServicesCount() != 0 {
+		t.Fatal("we should not have contacted the probe services")
+	}
+}
+
+func TestSessionSubmitMetricsFailure(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.metricsEnabled = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	err := sess.SubmitMetrics(ctx)
+	if err == nil || err.Error() != "all available probe services failed" {
+		t.Fatal("not the error we expected")
+	}
+	metrics := sess.Metrics()
+	if metrics.BootstrapsAttempted != 1 || metrics.BootstrapsSucceeded != 0 {
+		t.Fatal("not the bootstrap metrics we expected")
+	}
+	if metrics.SoftwareName != "ooniprobe-engine" || !metrics.Valid() {
+		t.Fatal("not the metrics we expected")
+	}
+}
+
+func TestSessionLookupProbeIPFamiliesWithProxy(t *testing.T) {
+	sess := newSessionForTestingNoLookupsWithProxyURL(t, &url.URL{
+		Scheme: "socks5", Host: "127.0.0.1:9050"})
+	defer sess.Close()
+	sess.routing.Measurements = RouteProxy
+	ipv4, ipv6 := sess.lookupProbeIPFamilies(context.Background(), "2001:db8::1")
+	if ipv4 != "" || ipv6 != "2001:db8::1" {
+		t.Fatal("not the addresses we expected")
+	}
+}
+
+func TestSessionProbeIPv4AndIPv6(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if sess.ProbeIPv4() != "" || sess.ProbeIPv6() != "" {
+		t.Fatal("expected empty addresses here")
+	}
+	sess.location = &model.LocationInfo{
+		ProbeIPv4: "130.192.91.211",
+		ProbeIPv6: "2001:db8::1",
+	}
+	if sess.ProbeIPv4() != "130.192.91.211" || sess.ProbeIPv6() != "2001:db8::1" {
+		t.Fatal("not the addresses we expected")
+	}
+}
+
+func TestSessionResourcesUpdater(t *testing.T) {
+	sess, err := NewSession(SessionConfig{
+		AssetsDir:               "testdata",
+		Logger:                  log.Log,
+		ResourcesUpdateInterval: time.Hour,
+		SoftwareName:            "ooniprobe-engine",
+		SoftwareVersion:         "0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.stopResourcesUpdater == nil {
+		t.Fatal("expected the resources updater to be running")
+	}
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionUpdateResourcesFailure(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	updated, err := sess.UpdateResources(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+	if updated {
+		t.Fatal("expected no update here")
+	}
+}
+
+func TestSessionResolverNetworkType(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if sess.ResolverNetworkType() != "unknown" {
+		t.Fatal("expected unknown network type here")
+	}
+	sess.location = &model.LocationInfo{ResolverNetworkType: "isp"}
+	if sess.ResolverNetworkType() != "isp" {
+		t.Fatal("not the network type we expected")
+	}
+}
+
+func TestSessionOfflineLocation(t *testing.T) {
+	sess, err := NewSession(SessionConfig{
+		AssetsDir: "testdata",
+		Logger:    log.Log,
+		OfflineLocation: &model.LocationInfo{
+			ASN:         15169,
+			CountryCode: "US",
+			NetworkName: "Testbed",
+		},
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	if err := sess.MaybeLookupLocation(); err != nil {
+		t.Fatal(err)
+	}
+	if sess.ProbeASN() != 15169 || sess.ProbeCC() != "US" {
+		t.Fatal("not the location we expected")
+	}
+	if sess.ProbeNetworkName() != "Testbed" {
+		t.Fatal("not the network name we expected")
+	}
+	if sess.ProbeIP() != model.DefaultProbeIP {
+		t.Fatal("expected the default probe IP")
+	}
+	if sess.ResolverIP() != model.DefaultResolverIP {
+		t.Fatal("expected the default resolver IP")
+	}
+}
+
+func TestSessionOfflineLocationInvalid(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.offlineLocation = &model.LocationInfo{ASN: 15169, CountryCode: "usa"}
+	err := sess.MaybeLookupLocation()
+	if !errors.Is(err, geolocate.ErrInvalidLocation) {
+		t.Fatal("not the error we expected")
+	}
+	if sess.location != nil {
+		t.Fatal("expected nil location here")
+	}
+}
+
+func TestSessionProbeIsVPN(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if sess.ProbeIsVPN() {
+		t.Fatal("expected false without a location")
+	}
+	sess.location = &model.LocationInfo{ProbeIsVPN: true}
+	if !sess.ProbeIsVPN() {
+		t.Fatal("expected true here")
+	}
+}
+
+func TestSessionWatchLocation(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"}
+	locations := []*model.LocationInfo{
+		{ASN: 30722, CountryCode: "IT", ProbeIP: "130.192.91.211"},
+		nil, // simulate a failure
+		{ASN: 3269, CountryCode: "IT"},
+	}
+	var idx int
+	lookup := func(ctx context.Context) (*model.LocationInfo, error) {
+		if idx >= len(locations) {
+			return nil, errors.New("no more locations") // racing with cancel
+		}
+		location := locations[idx]
+		idx++
+		if location == nil {
+			return nil, errors.New("mocked error")
+		}
+		return location, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var previous, current *model.LocationInfo
+	sess.watchLocation(ctx, time.Millisecond, lookup,
+		func(p, c *model.LocationInfo) {
+			previous, current = p, c
+			cancel()
+		})
+	if idx != 3 {
+		t.Fatal("not the number of lookups we expected")
+	}
+	if previous.ProbeIP != "130.192.91.211" || current.ASN != 3269 {
+		t.Fatal("not the locations we expected")
+	}
+	if sess.ProbeASN() != 3269 {
+		t.Fatal("did not update the session location")
+	}
+}
+
+func TestSessionRefreshLocationOffline(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.offlineLocation = &model.LocationInfo{ASN: 30722, CountryCode: "IT"}
+	changed, err := sess.RefreshLocation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Fatal("expected no change here")
+	}
+	if sess.location != nil {
+		t.Fatal("expected nil location here")
+	}
+}
+
+func TestLocationChanged(t *testing.T) {
+	var cases = []struct {
+		previous *model.LocationInfo
+		current  *model.LocationInfo
+		expected bool
+	}{
+		{nil, nil, false},
+		{nil, &model.LocationInfo{}, true},
+		{&model.LocationInfo{ASN: 1}, nil, false},
+		{&model.LocationInfo{ASN: 1, CountryCode: "IT"}, &model.LocationInfo{ASN: 1, CountryCode: "IT"}, false},
+		{&model.LocationInfo{ASN: 1, CountryCode: "IT"}, &model.LocationInfo{ASN: 2, CountryCode: "IT"}, true},
+		{&model.LocationInfo{ASN: 1, CountryCode: "IT"}, &model.LocationInfo{ASN: 1, CountryCode: "FR"}, true},
+	}
+	for idx, c := range cases {
+		if locationChanged(c.previous, c.current) != c.expected {
+			t.Fatalf("unexpected result for case #%d", idx)
+		}
+	}
+}
+
+func TestSessionNetworkCharacterization(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if sess.NATType() != geolocate.NATTypeUnknown || sess.BehindCaptivePortal() {
+		t.Fatal("not the defaults we expected")
+	}
+	sess.location = &model.LocationInfo{
+		BehindCaptivePortal: true,
+		NATType:             geolocate.NATTypeEndpointDependent,
+	}
+	if sess.NATType() != geolocate.NATTypeEndpointDependent || !sess.BehindCaptivePortal() {
+		t.Fatal("not the values we expected")
+	}
+}
+
+func TestSessionCharacterizeNetworkWithProxy(t *testing.T) {
+	sess := newSessionForTestingNoLookupsWithProxyURL(t, &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"})
+	defer sess.Close()
+	sess.routing.Measurements = RouteProxy
+	natType, captive := sess.characterizeNetwork(context.Background(), sess.DefaultHTTPClient())
+	if natType != geolocate.NATTypeUnknown || captive {
+		t.Fatal("expected no checks when using a proxy")
+	}
+}
+
+func TestSessionDataCapExceeded(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.byteCounter.CountKibiBytesReceived(2)
+	sess.byteCounter.CountKibiBytesSent(2)
+	if sess.KibiBytesTotal() != 4 {
+		t.Fatal("not the total we expected")
+	}
+	if sess.DataCapExceeded() {
+		t.Fatal("expected false without a data cap")
+	}
+	sess.dataCapKiB = 5
+	if sess.DataCapExceeded() {
+		t.Fatal("expected false below the data cap")
+	}
+	sess.dataCapKiB = 3
+	if !sess.DataCapExceeded() {
+		t.Fatal("expected true above the data cap")
+	}
+}
+
+func TestSessionLiteMode(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if sess.LiteMode() {
+		t.Fatal("lite mode should be disabled by default")
+	}
+	sess.liteMode = true
+	if !sess.LiteMode() {
+		t.Fatal("lite mode should be enabled")
+	}
+}
+
+func TestCheckTunnelHealthWithoutTunnel(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	if err := sess.CheckTunnelHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sess.ProxyURL() != nil {
+		t.Fatal("expected nil ProxyURL")
+	}
+}
+
+func TestCheckTunnelHealthUpdatesExistingClients(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	first := &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"}
+	tun := &fakeTunnel{proxy: first}
+	sess.tunnelName, sess.tunnel = "psiphon", tun
+	sess.setProxyURL(first)
+	clnt := sess.DefaultHTTPClient()
+	second := &url.URL{Scheme: "socks5", Host: "127.0.0.1:9051"}
+	tun.proxy = second
+	if err := sess.CheckTunnelHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sess.ProxyURL() != second {
+		t.Fatal("we did not switch to the new proxy")
+	}
+	if clnt.Transport != sess.httpSwitchableTransport {
+		t.Fatal("the client does not use the switchable transport")
+	}
+}
+
+func TestMeasureTunnelOverheadWithoutTunnel(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	report, err := sess.MeasureTunnelOverhead(context.Background())
+	if !errors.Is(err, tunnel.ErrNoProxy) {
+		t.Fatal("not the error we expected")
+	}
+	if report != nil {
+		t.Fatal("expected nil report here")
+	}
+}
+
+func TestSessionWritesMeasurementsToSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sess, err := NewSession(SessionConfig{
+		AssetsDir:       "testdata",
+		Logger:          log.Log,
+		SinkDir:         dir,
+		SinkGzip:        true,
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.location = &model.LocationInfo{ASN: 30722, CountryCode: "IT"} // skip lookup
+	exp := NewExperiment(sess, example.NewExperimentMeasurer(
+		example.Config{SleepTime: int64(time.Millisecond)}, "example",
+	))
+	for i := 0; i < 2; i++ {
+		if _, err := exp.Measure(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "measurements-*.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("unexpected files", files)
+	}
+}
+
+func TestSessionTokenRefresher(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	clnt := probeservices.Client{StateFile: probeservices.NewStateFile(
+		kvstore.NewMemoryKeyValueStore())}
+	sess.maybeStartTokenRefresher(clnt)
+	stop := sess.stopTokenRefresher
+	if stop == nil {
+		t.Fatal("we did not start the token refresher")
+	}
+	sess.maybeStartTokenRefresher(clnt)
+	if fmt.Sprintf("%p", stop) != fmt.Sprintf("%p", sess.stopTokenRefresher) {
+		t.Fatal("we started the token refresher twice")
+	}
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
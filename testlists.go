@@ -68,7 +68,7 @@ func (s *Session) QueryTestListsURLs(conf *TestListsURLsConfig) (*TestListsURLsR
 		EnabledCategories: conf.Categories,
 		HTTPClient:        s.DefaultHTTPClient(),
 		Limit:             conf.Limit,
-		Logger:            s.logger,
+		Logger:            model.WithComponent(s.logger, "probeservices"),
 		UserAgent:         s.UserAgent(),
 	})
 	if err != nil {
@@ -0,0 +1,16 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// withTracer returns a copy of ctx using the session's tracer, if any,
+// unless ctx already contains a tracer.
+func (s *Session) withTracer(ctx context.Context) context.Context {
+	if s.tracer == nil || model.ContextTracer(ctx) != nil {
+		return ctx
+	}
+	return model.WithTracer(ctx, s.tracer)
+}
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key, value string) {}
+
+func (nopSpan) End(err error) {}
+
+type nopTracer struct{}
+
+func (nopTracer) StartSpan(ctx context.Context, name string) (context.Context, model.Span) {
+	return ctx, nopSpan{}
+}
+
+func TestSessionWithTracer(t *testing.T) {
+	ctx := context.Background()
+	if (&Session{}).withTracer(ctx) != ctx {
+		t.Fatal("expected the same context without a tracer")
+	}
+	var tracer model.Tracer = nopTracer{}
+	sess := &Session{tracer: tracer}
+	if model.ContextTracer(sess.withTracer(ctx)) != tracer {
+		t.Fatal("expected the session tracer")
+	}
+	other := model.WithTracer(ctx, &nopTracer{})
+	if sess.withTracer(other) != other {
+		t.Fatal("expected to keep the tracer in the context")
+	}
+}
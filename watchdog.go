@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	// DefaultMeasurementWatchdog is the default maximum runtime of
+	// a single measurement (see SessionConfig.MeasurementWatchdog).
+	DefaultMeasurementWatchdog = 30 * time.Minute
+
+	// watchdogFailure is the failure of the test keys of a measurement
+	// that we gave up on because its experiment was stuck.
+	watchdogFailure = "engine_watchdog_timeout"
+)
+
+// watchdogGracePeriod is how long we wait for an experiment to honour
+// the cancellation of its context before giving up on it.
+var watchdogGracePeriod = 5 * time.Second
+
+// ErrWatchdog indicates that a measurement took longer than the
+// maximum runtime enforced by the session watchdog.
+var ErrWatchdog = errors.New("engine: measurement stopped by watchdog")
+
+// watchdogError is the error returned by runMeasurerWithWatchdog when it
+// gives up on a stuck experiment. Goroutines contains the stacks of all the
+// goroutines, which tell where the experiment is stuck.
+type watchdogError struct {
+	Goroutines string
+	Timeout    time.Duration
+}
+
+func (e *watchdogError) Error() string {
+	return fmt.Sprintf("%s after %s", ErrWatchdog.Error(), e.Timeout)
+}
+
+func (e *watchdogError) Unwrap() error {
+	return ErrWatchdog
+}
+
+// watchdogCallbacks forwards to the inner callbacks until we give up
+// on the experiment, after which all the callbacks are no-ops.
+type watchdogCallbacks struct {
+	abandoned bool
+	inner     model.ExperimentCallbacks
+	mu        sync.Mutex
+}
+
+func (cb *watchdogCallbacks) abandon() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.abandoned = true
+}
+
+func (cb *watchdogCallbacks) OnDataUsage(dloadKiB, uploadKiB float64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !cb.abandoned {
+		cb.inner.OnDataUsage(dloadKiB, uploadKiB)
+	}
+}
+
+func (cb *watchdogCallbacks) OnProgress(percentage float64, message string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !cb.abandoned {
+		cb.inner.OnProgress(percentage, message)
+	}
+}
+
+// runMeasurerWithWatchdog is like runMeasurer except that it cancels the
+// context of the experiment when the measurement takes too long. If the
+// experiment ignores the cancellation, we stop waiting for it after a short
+// grace period, we return a *watchdogError, we replace the test keys, since
+// the stuck experiment may still be writing them, and we stop forwarding
+// its callbacks. This is not a panic, therefore the stack is empty.
+func (e *Experiment) runMeasurerWithWatchdog(
+	ctx context.Context, measurer model.ExperimentMeasurer, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) (string, error) {
+	timeout := e.session.measurementWatchdog
+	if timeout <= 0 {
+		return e.runMeasurer(ctx, measurer, sess, measurement, callbacks)
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	inner := *measurement // the stuck experiment must not share our data
+	inner.Annotations = nil
+	inner.AddAnnotations(measurement.Annotations)
+	type result struct {
+		stack string
+		err   error
+	}
+	done := make(chan result, 1)
+	guarded := &watchdogCallbacks{inner: callbacks}
+	go func() {
+		stack, err := e.runMeasurer(ctx, measurer, sess, &inner, guarded)
+		done <- result{stack: stack, err: err}
+	}()
+	timer := time.NewTimer(timeout + watchdogGracePeriod)
+	defer timer.Stop()
+	var r result
+	select {
+	case r = <-done:
+	case <-timer.C:
+		guarded.abandon()
+		e.logger().Warnf("experiment: %s: stuck after %s", e.testName, timeout)
+		measurement.TestKeys = map[string]interface{}{"failure": watchdogFailure}
+		return "", &watchdogError{Goroutines: goroutineStacks(), Timeout: timeout}
+	}
+	*measurement = inner
+	if parent.Err() == nil && ctx.Err() != nil && r.stack == "" {
+		r.err = fmt.Errorf("%w after %s", ErrWatchdog, timeout)
+	}
+	return r.stack, r.err
+}
+
+// goroutineStacks returns the stacks of all the goroutines.
+func goroutineStacks() string {
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	return buf.String()
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/eventbus"
+	"github.com/ooni/probe-engine/model"
+)
+
+// stuckMeasurer is a measurer that ignores the context and only
+// returns when unblock is closed, if ever.
+type stuckMeasurer struct {
+	cooperative bool
+	unblock     chan struct{}
+}
+
+func (stuckMeasurer) ExperimentName() string {
+	return "stuck"
+}
+
+func (stuckMeasurer) ExperimentVersion() string {
+	return "0.1.0"
+}
+
+func (m stuckMeasurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	measurement.TestKeys = map[string]interface{}{"failure": nil}
+	if m.cooperative {
+		<-ctx.Done()
+		return nil
+	}
+	<-m.unblock
+	return nil
+}
+
+func TestWatchdog(t *testing.T) {
+	saved := watchdogGracePeriod
+	watchdogGracePeriod = 10 * time.Millisecond
+	defer func() { watchdogGracePeriod = saved }()
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.measurementWatchdog = 100 * time.Millisecond
+	unblock := make(chan struct{})
+	defer close(unblock)
+	ctx := context.Background()
+	run := func(m stuckMeasurer) (*model.Measurement, string, error) {
+		exp := &Experiment{measurer: m, session: sess, testName: "stuck"}
+		measurement := new(model.Measurement)
+		stack, err := exp.runMeasurerWithWatchdog(ctx, m, &measurementSession{Session: sess},
+			measurement, model.NewPrinterCallbacks(sess.Logger()))
+		return measurement, stack, err
+	}
+
+	measurement, stack, err := run(stuckMeasurer{unblock: unblock})
+	var stuck *watchdogError
+	if !errors.Is(err, ErrWatchdog) || !errors.As(err, &stuck) {
+		t.Fatal("not the error we expected", err)
+	}
+	if stack != "" {
+		t.Fatal("a stuck experiment is not a panic", stack)
+	}
+	if !strings.Contains(stuck.Goroutines, "stuckMeasurer") {
+		t.Fatal("the stacks do not mention the stuck measurer")
+	}
+	tk := measurement.TestKeys.(map[string]interface{})
+	if tk["failure"] != watchdogFailure {
+		t.Fatal("unexpected test keys", tk)
+	}
+
+	measurement, stack, err = run(stuckMeasurer{cooperative: true})
+	if !errors.Is(err, ErrWatchdog) || stack != "" {
+		t.Fatal("not the result we expected", stack, err)
+	}
+	tk = measurement.TestKeys.(map[string]interface{})
+	if tk["failure"] != nil {
+		t.Fatal("we should keep the test keys of a cooperative measurer", tk)
+	}
+}
+
+func TestWatchdogDisabled(t *testing.T) {
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.measurementWatchdog = -1
+	exp := &Experiment{measurer: panickingMeasurer{}, session: sess, testName: "panicking"}
+	_, err := exp.runMeasurerWithWatchdog(context.Background(), exp.measurer,
+		&measurementSession{Session: sess},
+		new(model.Measurement), model.NewPrinterCallbacks(sess.Logger()))
+	if !errors.Is(err, ErrMeasurerPanic) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+type countingCallbacks struct {
+	dataUsage, progress int
+}
+
+func (cb *countingCallbacks) OnDataUsage(dloadKiB, uploadKiB float64) {
+	cb.dataUsage++
+}
+
+func (cb *countingCallbacks) OnProgress(percentage float64, message string) {
+	cb.progress++
+}
+
+func TestWatchdogCallbacks(t *testing.T) {
+	inner := new(countingCallbacks)
+	cb := &watchdogCallbacks{inner: inner}
+	cb.OnDataUsage(1, 1)
+	cb.OnProgress(0.5, "antani")
+	cb.abandon()
+	cb.OnDataUsage(1, 1)
+	cb.OnProgress(1, "antani")
+	if inner.dataUsage != 1 || inner.progress != 1 {
+		t.Fatal("we forwarded the callbacks after abandoning", inner)
+	}
+}
+
+func TestWatchdogDiagnostic(t *testing.T) {
+	crashDir, err := ioutil.TempDir("", "ooniprobe-engine-crashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(crashDir)
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	sess.crashDir = crashDir
+	var panicked []bool
+	defer sess.EventBus().Subscribe(func(ev eventbus.Event) {
+		if diagnostic, ok := ev.(eventbus.Diagnostic); ok {
+			panicked = append(panicked, diagnostic.Panic)
+		}
+	})()
+	exp := &Experiment{measurer: stuckMeasurer{}, session: sess, testName: "stuck"}
+	exp.maybeAddDiagnostic(context.Background(), "", "", &watchdogError{
+		Goroutines: "goroutine 1 [running]:", Timeout: time.Second,
+	})
+	diagnostics := sess.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Stack != "" ||
+		diagnostics[0].Goroutines != "goroutine 1 [running]:" {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	if len(panicked) != 1 || panicked[0] {
+		t.Fatal("unexpected diagnostic events", panicked)
+	}
+	if paths, err := sess.CrashFiles(); err != nil || len(paths) != 0 {
+		t.Fatal("we should not write crash files for stuck experiments", paths, err)
+	}
+}